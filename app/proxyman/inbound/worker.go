@@ -139,7 +139,10 @@ func (w *tcpWorker) Proxy() proxy.Inbound {
 }
 
 func (w *tcpWorker) Start() error {
-	ctx := context.Background()
+	// Keep the handler context values (instance, inbound tag) so transports
+	// can reach features such as stats, but not its cancellation: the
+	// listener is closed explicitly.
+	ctx := context.WithoutCancel(w.ctx)
 
 	type HysteriaInboundValidator interface{ HysteriaInboundValidator() *account.Validator }
 	if v, ok := w.proxy.(HysteriaInboundValidator); ok {
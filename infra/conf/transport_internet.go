@@ -525,51 +525,292 @@ type GameTunnelConfig struct {
 	MTU                uint32 `json:"mtu"`
 	MaxStreams          uint32 `json:"maxStreams"`
 	ConnectionIdLength uint32 `json:"connectionIdLength"`
-	EnablePadding      bool   `json:"enablePadding"`
+	EnablePadding      *bool  `json:"enablePadding"`
 	PaddingMinSize     uint32 `json:"paddingMinSize"`
 	PaddingMaxSize     uint32 `json:"paddingMaxSize"`
+	PaddingRange       []uint32 `json:"paddingRange"`
 	HandshakeTimeout   uint32 `json:"handshakeTimeout"`
 	KeepAliveInterval  uint32 `json:"keepAliveInterval"`
 	Key                string `json:"key"`
-}
-
-func (c *GameTunnelConfig) Build() (*gametunnel.Config, error) {
-	config := gametunnel.DefaultConfig()
-	if c.Obfuscation != "" {
-		config.Obfuscation = gametunnel.ObfuscationModeFromString(c.Obfuscation)
-	}
-	if c.Priority != "" {
-		config.Priority = gametunnel.PriorityModeFromString(c.Priority)
-	}
-	if c.MTU > 0 {
-		config.MTU = c.MTU
-	}
-	if c.MaxStreams > 0 {
-		config.MaxStreams = c.MaxStreams
-	}
-	if c.ConnectionIdLength > 0 {
-		config.ConnectionIdLength = c.ConnectionIdLength
-	}
-	config.EnablePadding = c.EnablePadding
-	if c.PaddingMinSize > 0 {
-		config.PaddingMinSize = c.PaddingMinSize
-	}
-	if c.PaddingMaxSize > 0 {
-		config.PaddingMaxSize = c.PaddingMaxSize
-	}
-	if c.HandshakeTimeout > 0 {
-		config.HandshakeTimeout = c.HandshakeTimeout
-	}
-	if c.KeepAliveInterval > 0 {
-		config.KeepAliveInterval = c.KeepAliveInterval
+	EnableDscp         bool   `json:"enableDscp"`
+	DscpHigh           *uint32 `json:"dscpHigh"`
+	DscpMedium         *uint32 `json:"dscpMedium"`
+	DscpLow            *uint32 `json:"dscpLow"`
+	SessionRateLimit   uint64 `json:"sessionRateLimit"`
+	SessionRateBurst   uint64 `json:"sessionRateBurst"`
+	HighRateLimit      uint64 `json:"highRateLimit"`
+	MediumRateLimit    uint64 `json:"mediumRateLimit"`
+	LowRateLimit       uint64 `json:"lowRateLimit"`
+	RateLimitPolicy    string `json:"rateLimitPolicy"`
+	Classifier         string `json:"classifier"`
+	Scheduler          string `json:"scheduler"`
+	HighLatencyBudget  uint32 `json:"highLatencyBudget"`
+	MediumLatencyBudget uint32 `json:"mediumLatencyBudget"`
+	LowLatencyBudget   uint32 `json:"lowLatencyBudget"`
+	EnablePacing       bool   `json:"enablePacing"`
+	PacingRate         uint64 `json:"pacingRate"`
+	DropPolicy         string `json:"dropPolicy"`
+	MaxSessionsPerIp   uint32 `json:"maxSessionsPerIp"`
+	HandshakesPerMinute uint32 `json:"handshakesPerMinute"`
+	IpBanDuration      uint32 `json:"ipBanDuration"`
+	AllowIps           StringList `json:"allowIps"`
+	DenyIps            StringList `json:"denyIps"`
+	SessionSnapshotPath string `json:"sessionSnapshotPath"`
+	SessionSnapshotInterval uint32 `json:"sessionSnapshotInterval"`
+	MetricsListen      string `json:"metricsListen"`
+	ApiListen          string `json:"apiListen"`
+	ApiToken           string `json:"apiToken"`
+	QuotaBytes         uint64 `json:"quotaBytes"`
+	QuotaAction        string `json:"quotaAction"`
+	QuotaThrottleRate  uint64 `json:"quotaThrottleRate"`
+	QuotaFlushInterval uint32 `json:"quotaFlushInterval"`
+	DeadPeerInterval   uint32 `json:"deadPeerInterval"`
+	DeadPeerProbes     uint32 `json:"deadPeerProbes"`
+	ExtraListen        StringList `json:"extraListen"`
+	ReceiveSockets     uint32 `json:"receiveSockets"`
+	MaxSessions        uint32 `json:"maxSessions"`
+	MemoryBudgetMb     uint32 `json:"memoryBudgetMb"`
+	OverloadPolicy     string `json:"overloadPolicy"`
+	OverloadRetryAfter uint32 `json:"overloadRetryAfter"`
+	SessionSenders     bool   `json:"sessionSenders"`
+	DecryptWorkers     uint32 `json:"decryptWorkers"`
+	BlackholeThreshold uint32 `json:"blackholeThreshold"`
+	IssueConnectionIds bool   `json:"issueConnectionIds"`
+	ReconnectAttempts  uint32 `json:"reconnectAttempts"`
+	ReconnectBackoff   uint32 `json:"reconnectBackoff"`
+	ReconnectMaxBackoff uint32 `json:"reconnectMaxBackoff"`
+	ValidateMigration  bool   `json:"validateMigration"`
+	Multipath          bool   `json:"multipath"`
+	MultipathLocalAddr string `json:"multipathLocalAddr"`
+	DontFragment       bool   `json:"dontFragment"`
+	Endpoints          StringList `json:"endpoints"`
+	SharedSession      bool   `json:"sharedSession"`
+	EarlyData          bool   `json:"earlyData"`
+	MtuProbe           bool   `json:"mtuProbe"`
+	Resolver           string `json:"resolver"`
+	ResolveStrategy    string `json:"resolveStrategy"`
+	CoalesceWindow     uint32 `json:"coalesceWindow"`
+	AppStreams         bool   `json:"appStreams"`
+	InboundWait        uint32 `json:"inboundWait"`
+	EncryptWorkers     uint32 `json:"encryptWorkers"`
+	SendBatch          uint32 `json:"sendBatch"`
+	Lenient            bool   `json:"lenient"`
+	Profile            string `json:"profile"`
+	ChaffBudget        uint32 `json:"chaffBudget"`
+	ReadBufferSize     uint32 `json:"readBufferSize"`
+	WriteBufferSize    uint32 `json:"writeBufferSize"`
+	Ttl                uint32 `json:"ttl"`
+	Dscp               uint32 `json:"dscp"`
+	BindInterface      string `json:"bindInterface"`
+	Fwmark             uint32 `json:"fwmark"`
+	PortRange          string `json:"portRange"`
+	HandshakeMinSize   uint32 `json:"handshakeMinSize"`
+	HandshakeMaxSize   uint32 `json:"handshakeMaxSize"`
+	CaptureFile        string `json:"captureFile"`
+	CapturePlaintext   bool   `json:"capturePlaintext"`
+	TracingEndpoint    string `json:"tracingEndpoint"`
+	DebugListen        string `json:"debugListen"`
+	AuditLog           string `json:"auditLog"`
+	AuditIp            string `json:"auditIp"`
+	AuditSalt          string `json:"auditSalt"`
+	AuditMaxSize       uint32 `json:"auditMaxSize"`
+	AuditMaxFiles      uint32 `json:"auditMaxFiles"`
+	SocketActivation   bool   `json:"socketActivation"`
+	ListenFd           uint32 `json:"listenFd"`
+	UpgradeSocket      string `json:"upgradeSocket"`
+	CompactHeaders     bool   `json:"compactHeaders"`
+	Duplicate          bool   `json:"duplicate"`
+	PuzzleRate         uint32 `json:"puzzleRate"`
+	Timestamps         bool   `json:"timestamps"`
+	HubGroup           string `json:"hubGroup"`
+	PaddingBackoff     string `json:"paddingBackoff"`
+	PaddingBandwidth   uint64 `json:"paddingBandwidth"`
+	KeepAliveTune      bool   `json:"keepAliveTune"`
+	KeepAliveMax       uint32 `json:"keepAliveMax"`
+	ClusterListen      string `json:"clusterListen"`
+	ClusterPeers       StringList `json:"clusterPeers"`
+	ClusterNode        uint32 `json:"clusterNode"`
+	ClusterInterval    uint32 `json:"clusterInterval"`
+	LbServerId         string `json:"lbServerId"`
+	LbConfigId         uint32 `json:"lbConfigId"`
+	RecvBatch          uint32 `json:"recvBatch"`
+	ClassSockets       bool   `json:"classSockets"`
+	HighWriteBufferSize uint32 `json:"highWriteBufferSize"`
+	BulkWriteBufferSize uint32 `json:"bulkWriteBufferSize"`
+	Users              []*GameTunnelUser `json:"users"`
+	UserKey            string `json:"userKey"`
+}
+
+// GameTunnelUser - пользователь сервера GameTunnel с личным ключом
+type GameTunnelUser struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// Build собирает Settings транспорта GameTunnel (config.proto); режимы
+// остаются строками - их разбирает сам транспорт (settings.go)
+func (c *GameTunnelConfig) Build() (*gametunnel.Settings, error) {
+	config := &gametunnel.Settings{
+		Obfuscation:             c.Obfuscation,
+		Priority:                c.Priority,
+		Mtu:                     c.MTU,
+		MaxStreams:              c.MaxStreams,
+		ConnectionIdLength:      c.ConnectionIdLength,
+		EnablePadding:           c.EnablePadding,
+		PaddingMinSize:          c.PaddingMinSize,
+		PaddingMaxSize:          c.PaddingMaxSize,
+		HandshakeTimeout:        c.HandshakeTimeout,
+		KeepAliveInterval:       c.KeepAliveInterval,
+		Key:                     c.Key,
+		EnableDscp:              c.EnableDscp,
+		DscpHigh:                c.DscpHigh,
+		DscpMedium:              c.DscpMedium,
+		DscpLow:                 c.DscpLow,
+		SessionRateLimit:        c.SessionRateLimit,
+		SessionRateBurst:        c.SessionRateBurst,
+		HighRateLimit:           c.HighRateLimit,
+		MediumRateLimit:         c.MediumRateLimit,
+		LowRateLimit:            c.LowRateLimit,
+		RateLimitPolicy:         c.RateLimitPolicy,
+		Classifier:              c.Classifier,
+		Scheduler:               c.Scheduler,
+		HighLatencyBudget:       c.HighLatencyBudget,
+		MediumLatencyBudget:     c.MediumLatencyBudget,
+		LowLatencyBudget:        c.LowLatencyBudget,
+		EnablePacing:            c.EnablePacing,
+		PacingRate:              c.PacingRate,
+		DropPolicy:              c.DropPolicy,
+		MaxSessionsPerIp:        c.MaxSessionsPerIp,
+		HandshakesPerMinute:     c.HandshakesPerMinute,
+		IpBanDuration:           c.IpBanDuration,
+		SessionSnapshotPath:     c.SessionSnapshotPath,
+		SessionSnapshotInterval: c.SessionSnapshotInterval,
+		MetricsListen:           c.MetricsListen,
+		ApiListen:               c.ApiListen,
+		ApiToken:                c.ApiToken,
+		QuotaBytes:              c.QuotaBytes,
+		QuotaAction:             c.QuotaAction,
+		QuotaThrottleRate:       c.QuotaThrottleRate,
+		QuotaFlushInterval:      c.QuotaFlushInterval,
+		DeadPeerInterval:        c.DeadPeerInterval,
+		DeadPeerProbes:          c.DeadPeerProbes,
+		ExtraListen:             c.ExtraListen,
+		ReceiveSockets:          c.ReceiveSockets,
+		MaxSessions:             c.MaxSessions,
+		MemoryBudgetMb:          c.MemoryBudgetMb,
+		OverloadPolicy:          c.OverloadPolicy,
+		OverloadRetryAfter:      c.OverloadRetryAfter,
+		SessionSenders:          c.SessionSenders,
+		DecryptWorkers:          c.DecryptWorkers,
+		BlackholeThreshold:      c.BlackholeThreshold,
+		IssueConnectionIds:      c.IssueConnectionIds,
+		ReconnectAttempts:       c.ReconnectAttempts,
+		ReconnectBackoff:        c.ReconnectBackoff,
+		ReconnectMaxBackoff:     c.ReconnectMaxBackoff,
+		ValidateMigration:       c.ValidateMigration,
+		Multipath:               c.Multipath,
+		MultipathLocalAddr:      c.MultipathLocalAddr,
+		DontFragment:            c.DontFragment,
+		Endpoints:               c.Endpoints,
+		SharedSession:           c.SharedSession,
+		EarlyData:               c.EarlyData,
+		MtuProbe:                c.MtuProbe,
+		Resolver:                c.Resolver,
+		ResolveStrategy:         c.ResolveStrategy,
+		CoalesceWindow:          c.CoalesceWindow,
+		AppStreams:              c.AppStreams,
+		InboundWait:             c.InboundWait,
+		EncryptWorkers:          c.EncryptWorkers,
+		SendBatch:               c.SendBatch,
+		Lenient:                 c.Lenient,
+		Profile:                 c.Profile,
+		ChaffBudget:             c.ChaffBudget,
+		ReadBufferSize:          c.ReadBufferSize,
+		WriteBufferSize:         c.WriteBufferSize,
+		Ttl:                     c.Ttl,
+		Dscp:                    c.Dscp,
+		BindInterface:           c.BindInterface,
+		Fwmark:                  c.Fwmark,
+		PortRange:               c.PortRange,
+		HandshakeMinSize:        c.HandshakeMinSize,
+		HandshakeMaxSize:        c.HandshakeMaxSize,
+		CaptureFile:             c.CaptureFile,
+		CapturePlaintext:        c.CapturePlaintext,
+		TracingEndpoint:         c.TracingEndpoint,
+		DebugListen:             c.DebugListen,
+		AuditLog:                c.AuditLog,
+		AuditIp:                 c.AuditIp,
+		AuditSalt:               c.AuditSalt,
+		AuditMaxSize:            c.AuditMaxSize,
+		AuditMaxFiles:           c.AuditMaxFiles,
+		SocketActivation:        c.SocketActivation,
+		ListenFd:                c.ListenFd,
+		UpgradeSocket:           c.UpgradeSocket,
+		CompactHeaders:          c.CompactHeaders,
+		Duplicate:               c.Duplicate,
+		PuzzleRate:              c.PuzzleRate,
+		Timestamps:              c.Timestamps,
+		HubGroup:                c.HubGroup,
+		PaddingBackoff:          c.PaddingBackoff,
+		PaddingBandwidth:        c.PaddingBandwidth,
+		KeepAliveTune:           c.KeepAliveTune,
+		KeepAliveMax:            c.KeepAliveMax,
+		ClusterListen:           c.ClusterListen,
+		ClusterPeers:            c.ClusterPeers,
+		ClusterNode:             c.ClusterNode,
+		ClusterInterval:         c.ClusterInterval,
+		LbServerId:              c.LbServerId,
+		LbConfigId:              c.LbConfigId,
+		RecvBatch:               c.RecvBatch,
+		ClassSockets:            c.ClassSockets,
+		HighWriteBufferSize:     c.HighWriteBufferSize,
+		BulkWriteBufferSize:     c.BulkWriteBufferSize,
+		UserKey:                 c.UserKey,
+	}
+	for _, u := range c.Users {
+		if u == nil {
+			return nil, errors.New("gametunnel users: empty entry")
+		}
+		config.Users = append(config.Users, &gametunnel.Settings_User{Name: u.Name, Key: u.Key})
+	}
+
+	// paddingRange: [min, max] - то же, что paddingMinSize/paddingMaxSize
+	if c.PaddingRange != nil {
+		if len(c.PaddingRange) != 2 || c.PaddingRange[0] > c.PaddingRange[1] {
+			return nil, errors.New("gametunnel paddingRange must be [min, max], got ", c.PaddingRange)
+		}
+		config.PaddingMinSize, config.PaddingMaxSize = c.PaddingRange[0], c.PaddingRange[1]
+	}
+
+	allowIps, err := buildGameTunnelIPRules(c.AllowIps)
+	if err != nil {
+		return nil, errors.New("gametunnel allowIps").Base(err)
 	}
-	if c.Key != "" {
-		config.Key = c.Key
+	config.AllowIps = allowIps
+	denyIps, err := buildGameTunnelIPRules(c.DenyIps)
+	if err != nil {
+		return nil, errors.New("gametunnel denyIps").Base(err)
 	}
-	config.Validate()
+	config.DenyIps = denyIps
 	return config, nil
 }
 
+// buildGameTunnelIPRules превращает каждую запись (CIDR или geoip:xx)
+// в отдельное правило, чтобы у каждой был свой счётчик срабатываний
+func buildGameTunnelIPRules(ips StringList) ([]*gametunnel.Settings_IPRule, error) {
+	var rules []*gametunnel.Settings_IPRule
+	for _, ip := range ips {
+		geoips, err := ToCidrList(StringList{ip})
+		if err != nil {
+			return nil, err
+		}
+		for _, geoip := range geoips {
+			rules = append(rules, &gametunnel.Settings_IPRule{Name: ip, Geoip: geoip})
+		}
+	}
+	return rules, nil
+}
+
 type HysteriaConfig struct {
 	Version    int32     `json:"version"`
 	Auth       string    `json:"auth"`
@@ -1954,8 +2195,9 @@ func (c *StreamConfig) Build() (*internet.StreamConfig, error) {
 		if err != nil {
 			return nil, errors.New("Failed to build GameTunnel config.").Base(err)
 		}
-		internet.RegisterProtocolConfigCreator("gametunnel", func() interface{} {
-			return gs
+		config.TransportSettings = append(config.TransportSettings, &internet.TransportConfig{
+			ProtocolName: "gametunnel",
+			Settings:     serial.ToTypedMessage(gs),
 		})
 	}
 	if c.SocketSettings != nil {
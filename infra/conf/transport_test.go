@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"testing"
 
+	"github.com/xtls/xray-core/common/serial"
 	. "github.com/xtls/xray-core/infra/conf"
 	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
 	"google.golang.org/protobuf/proto"
 )
 
@@ -156,3 +158,72 @@ func TestSocketConfig(t *testing.T) {
 		t.Fatalf("unexpected parsed TFO value, which should be -1")
 	}
 }
+
+func TestGameTunnelStreamConfig(t *testing.T) {
+	createParser := func() func(string) (proto.Message, error) {
+		return func(s string) (proto.Message, error) {
+			config := new(StreamConfig)
+			if err := json.Unmarshal([]byte(s), config); err != nil {
+				return nil, err
+			}
+			return config.Build()
+		}
+	}
+
+	// Пример из config.go: строки режимов и paddingRange доходят до
+	// TransportSettings
+	dscpHigh := uint32(0)
+	enablePadding := true
+	runMultiTestCase(t, []TestCase{
+		{
+			Input: `{
+				"network": "gametunnel",
+				"gametunnelSettings": {
+					"obfuscation": "quic",
+					"priority": "gaming",
+					"mtu": 1400,
+					"maxStreams": 16,
+					"connectionIdLength": 8,
+					"enablePadding": true,
+					"paddingRange": [40, 200],
+					"handshakeTimeout": 5,
+					"keepAliveInterval": 15,
+					"key": "my-secret-preshared-key",
+					"enableDscp": true,
+					"dscpHigh": 0
+				}
+			}`,
+			Parser: createParser(),
+			Output: &internet.StreamConfig{
+				ProtocolName: "gametunnel",
+				TransportSettings: []*internet.TransportConfig{{
+					ProtocolName: "gametunnel",
+					Settings: serial.ToTypedMessage(&gametunnel.Settings{
+						Obfuscation:        "quic",
+						Priority:           "gaming",
+						Mtu:                1400,
+						MaxStreams:         16,
+						ConnectionIdLength: 8,
+						EnablePadding:      &enablePadding,
+						PaddingMinSize:     40,
+						PaddingMaxSize:     200,
+						HandshakeTimeout:   5,
+						KeepAliveInterval:  15,
+						Key:                "my-secret-preshared-key",
+						EnableDscp:         true,
+						DscpHigh:           &dscpHigh,
+					}),
+				}},
+			},
+		},
+	})
+
+	for _, input := range []string{
+		`{"gametunnelSettings": {"paddingRange": [200, 40]}}`,
+		`{"gametunnelSettings": {"paddingRange": [40]}}`,
+	} {
+		if _, err := createParser()(input); err == nil {
+			t.Errorf("%s: no error", input)
+		}
+	}
+}
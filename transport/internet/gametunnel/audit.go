@@ -0,0 +1,429 @@
+package gametunnel
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Журнал аудита сессий (auditLog)
+// ====================================================================
+//
+// Для разбора жалоб на злоупотребления серверу нужно знать, кто и
+// когда был подключён, - но не содержимое трафика. С auditLog хаб
+// пишет по строке JSON на событие сессии:
+//
+//	session_created  - сессия создана (хэндшейк или снапшот)
+//	session_migrated - клиент сменил адрес, from - прежний
+//	session_closed   - сессия закрыта: причина, длительность, трафик
+//
+// Пример:
+//
+//	{"time":"2026-10-17T12:00:00.1Z","event":"session_closed",
+//	 "connectionId":"1a2b3c4d5e6f7a8b","listen":"0.0.0.0:443",
+//	 "client":"203.0.113.0/24","reason":"timeout","durationSec":312.4,
+//	 "bytesSent":1048576,"bytesRecv":65536,...}
+//
+// Адрес клиента пишется по auditIp:
+//
+//	truncate - сеть /24 для IPv4, /48 для IPv6, без порта (по
+//	           умолчанию)
+//	hash     - HMAC-SHA256 адреса с auditSalt, 16 hex-символов: один
+//	           клиент узнаётся между записями, а сам адрес - только
+//	           тем, кто знает соль. Без auditSalt соль случайная на
+//	           процесс, и хэши не сопоставить после перезапуска
+//	full     - адрес с портом
+//	none     - адрес не пишется
+//
+// Назначения:
+//
+//	путь              - файл JSONL, дописывается; по достижении
+//	                    auditMaxSize МБ переименовывается в путь.1
+//	                    (путь.1 -> путь.2, ...), хранится auditMaxFiles
+//	                    старых файлов
+//	syslog            - локальный syslog (daemon.info, тег
+//	                    xray-gametunnel)
+//	syslog://host:514 - удалённый syslog по UDP
+//
+// Записи уходят в канал и пишутся отдельной горутиной: медленный диск
+// не тормозит приём. Канал переполнен - запись теряется с
+// предупреждением в журнал. Одно назначение на процесс: listener с
+// тем же auditLog пишут в общий файл, параметры ротации - первого.
+//
+// ====================================================================
+
+// AuditIPMode - как журнал аудита пишет адрес клиента
+type AuditIPMode int32
+
+const (
+	// AuditIPMode_TRUNCATE - сеть /24 (IPv4) или /48 (IPv6)
+	AuditIPMode_TRUNCATE AuditIPMode = 0
+
+	// AuditIPMode_HASH - HMAC-SHA256 адреса с солью
+	AuditIPMode_HASH AuditIPMode = 1
+
+	// AuditIPMode_FULL - адрес с портом
+	AuditIPMode_FULL AuditIPMode = 2
+
+	// AuditIPMode_NONE - адрес не пишется
+	AuditIPMode_NONE AuditIPMode = 3
+)
+
+// AuditIPModeFromString парсит строковое значение режима адреса
+// Неизвестное значение - truncate: ошибка в конфиге не раскрывает
+// адреса
+func AuditIPModeFromString(s string) AuditIPMode {
+	switch strings.ToLower(s) {
+	case "hash":
+		return AuditIPMode_HASH
+	case "full":
+		return AuditIPMode_FULL
+	case "none":
+		return AuditIPMode_NONE
+	default:
+		return AuditIPMode_TRUNCATE
+	}
+}
+
+const (
+	// auditQueueSize - записей в очереди назначения
+	auditQueueSize = 1024
+
+	// auditDefaultMaxSize - размер файла до ротации по умолчанию, МБ
+	auditDefaultMaxSize = 100
+
+	// auditDefaultMaxFiles - старых файлов по умолчанию
+	auditDefaultMaxFiles = 5
+
+	// auditSyslogTag - тег записей syslog
+	auditSyslogTag = "xray-gametunnel"
+
+	// auditHashLen - байт HMAC в записи (hex - вдвое больше)
+	auditHashLen = 8
+)
+
+// auditErrorLog - ошибки записи и переполнение очереди (warning)
+var auditErrorLog logThrottle
+
+// auditDest - разобранное назначение auditLog
+type auditDest struct {
+	// path - файл JSONL ("" - syslog)
+	path string
+
+	// network, addr - удалённый syslog ("" - локальный)
+	network, addr string
+}
+
+// auditTarget разбирает значение auditLog
+func auditTarget(s string) (auditDest, error) {
+	switch {
+	case s == "syslog":
+		return auditDest{}, nil
+	case strings.HasPrefix(s, "syslog://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return auditDest{}, err
+		}
+		if _, port, err := net.SplitHostPort(u.Host); err != nil || port == "" {
+			return auditDest{}, fmt.Errorf("syslog address %q: want host:port", u.Host)
+		}
+		return auditDest{network: "udp", addr: u.Host}, nil
+	default:
+		return auditDest{path: s}, nil
+	}
+}
+
+// auditEvent - запись журнала аудита
+type auditEvent struct {
+	Time         string `json:"time"`
+	Event        string `json:"event"`
+	ConnectionID string `json:"connectionId"`
+	Listen       string `json:"listen,omitempty"`
+	Client       string `json:"client,omitempty"`
+	From         string `json:"from,omitempty"`
+	User         string `json:"user,omitempty"`
+
+	// Итог сессии - только в session_closed
+	*auditTraffic
+}
+
+// auditTraffic - итог закрытой сессии
+type auditTraffic struct {
+	Reason      string  `json:"reason"`
+	Duration    float64 `json:"durationSec"`
+	BytesSent   uint64  `json:"bytesSent"`
+	BytesRecv   uint64  `json:"bytesRecv"`
+	PacketsSent uint64  `json:"packetsSent"`
+	PacketsRecv uint64  `json:"packetsRecv"`
+}
+
+// auditLog - журнал аудита хаба (nil = выключен)
+type auditLog struct {
+	sink   *auditSink
+	mode   AuditIPMode
+	salt   []byte
+	listen string
+}
+
+// auditProcessSalt - соль hash без auditSalt, одна на процесс
+var auditProcessSalt = sync.OnceValue(func() []byte {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	return salt
+})
+
+// newAuditLog - журнал аудита по config (nil без auditLog)
+// listen - адрес listener в записях
+func newAuditLog(config *Config, listen net.Addr) (*auditLog, error) {
+	if config.AuditLog == "" {
+		return nil, nil
+	}
+	sink, err := openAuditSink(config)
+	if err != nil {
+		return nil, fmt.Errorf("open auditLog: %w", err)
+	}
+	a := &auditLog{sink: sink, mode: config.AuditIp}
+	if listen != nil {
+		a.listen = listen.String()
+	}
+	if a.mode == AuditIPMode_HASH {
+		a.salt = []byte(config.AuditSalt)
+		if len(a.salt) == 0 {
+			a.salt = auditProcessSalt()
+		}
+	}
+	return a, nil
+}
+
+// client - адрес addr ("ip:port") в виде по режиму журнала
+func (a *auditLog) client(addr string) string {
+	if a.mode == AuditIPMode_FULL {
+		return addr
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || a.mode == AuditIPMode_NONE {
+		return ""
+	}
+	if a.mode == AuditIPMode_HASH {
+		mac := hmac.New(sha256.New, a.salt)
+		mac.Write(ip.To16())
+		return hex.EncodeToString(mac.Sum(nil)[:auditHashLen])
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(24, 32)).String() + "/24"
+	}
+	return ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+}
+
+// event - запись события name сессии session
+func (a *auditLog) event(name string, session *Session) *auditEvent {
+	info := session.info()
+	return &auditEvent{
+		Time:         time.Now().UTC().Format(time.RFC3339Nano),
+		Event:        name,
+		ConnectionID: info.ConnectionID,
+		Listen:       a.listen,
+		Client:       a.client(info.RemoteAddr),
+		User:         info.User,
+	}
+}
+
+// sessionCreated записывает создание сессии
+func (a *auditLog) sessionCreated(session *Session) {
+	if a == nil {
+		return
+	}
+	a.sink.write(a.event("session_created", session))
+}
+
+// sessionMigrated записывает смену адреса клиента с from
+func (a *auditLog) sessionMigrated(session *Session, from string) {
+	if a == nil {
+		return
+	}
+	e := a.event("session_migrated", session)
+	e.From = a.client(from)
+	a.sink.write(e)
+}
+
+// sessionClosed записывает закрытие сессии с итогом
+func (a *auditLog) sessionClosed(session *Session, reason CloseReason) {
+	if a == nil {
+		return
+	}
+	e := a.event("session_closed", session)
+	stats := session.GetStats()
+	e.auditTraffic = &auditTraffic{
+		Reason:      reason.String(),
+		Duration:    time.Since(session.CreatedAt).Seconds(),
+		BytesSent:   stats.BytesSent,
+		BytesRecv:   stats.BytesRecv,
+		PacketsSent: stats.PacketsSent,
+		PacketsRecv: stats.PacketsRecv,
+	}
+	a.sink.write(e)
+}
+
+// auditWriter - назначение записей: файл или syslog
+type auditWriter interface {
+	writeLine(line []byte) error
+}
+
+// auditSink - очередь записей одного назначения
+type auditSink struct {
+	target string
+	lines  chan []byte
+	out    auditWriter
+}
+
+// auditSinks - назначения процесса по значению auditLog
+var auditSinks = struct {
+	sync.Mutex
+	byTarget map[string]*auditSink
+}{byTarget: make(map[string]*auditSink)}
+
+// openAuditSink открывает назначение config.AuditLog или возвращает
+// уже открытое
+func openAuditSink(config *Config) (*auditSink, error) {
+	auditSinks.Lock()
+	defer auditSinks.Unlock()
+
+	if s := auditSinks.byTarget[config.AuditLog]; s != nil {
+		return s, nil
+	}
+	dest, err := auditTarget(config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	var out auditWriter
+	if dest.path != "" {
+		out, err = openAuditFile(dest.path, config.AuditMaxSize, config.AuditMaxFiles)
+	} else {
+		out, err = dialAuditSyslog(dest.network, dest.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &auditSink{target: config.AuditLog, lines: make(chan []byte, auditQueueSize), out: out}
+	auditSinks.byTarget[config.AuditLog] = s
+	go goLabeled("audit", s.writeLoop)
+	return s, nil
+}
+
+// write ставит запись в очередь; очередь полна - запись теряется
+func (s *auditSink) write(e *auditEvent) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	select {
+	case s.lines <- line:
+	default:
+		auditErrorLog.logf(log.Severity_Warning, "audit log %s: queue full, event %s of %s lost", s.target, e.Event, e.ConnectionID)
+	}
+}
+
+// writeLoop пишет записи из очереди
+func (s *auditSink) writeLoop() {
+	for line := range s.lines {
+		if err := s.out.writeLine(line); err != nil {
+			auditErrorLog.logf(log.Severity_Warning, "audit log %s: %v", s.target, err)
+		}
+	}
+}
+
+// auditFile - файл JSONL с ротацией по размеру
+type auditFile struct {
+	path     string
+	file     *os.File
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+// openAuditFile открывает файл path на дозапись
+// maxSizeMB, maxFiles - ротация (0 - по умолчанию)
+func openAuditFile(path string, maxSizeMB, maxFiles uint32) (*auditFile, error) {
+	if maxSizeMB == 0 {
+		maxSizeMB = auditDefaultMaxSize
+	}
+	if maxFiles == 0 {
+		maxFiles = auditDefaultMaxFiles
+	}
+	f := &auditFile{path: path, maxSize: int64(maxSizeMB) << 20, maxFiles: int(maxFiles)}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open открывает файл на дозапись и запоминает его размер
+func (f *auditFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// writeLine дописывает строку, при переполнении - после ротации
+func (f *auditFile) writeLine(line []byte) error {
+	if f.file == nil {
+		// Прошлая ротация не смогла открыть файл - пробуем снова
+		if err := f.open(); err != nil {
+			return err
+		}
+	}
+	if f.size > 0 && f.size+int64(len(line))+1 > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return fmt.Errorf("rotate: %w", err)
+		}
+	}
+	n, err := f.file.Write(append(line, '\n'))
+	f.size += int64(n)
+	return err
+}
+
+// rotate сдвигает старые файлы (путь.1 -> путь.2, ...) и начинает
+// новый
+func (f *auditFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		os.Rename(f.rotated(i), f.rotated(i+1))
+	}
+	if err := os.Rename(f.path, f.rotated(1)); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// rotated - имя i-го старого файла
+func (f *auditFile) rotated(i int) string {
+	return f.path + "." + strconv.Itoa(i)
+}
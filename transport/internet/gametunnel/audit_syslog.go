@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+
+package gametunnel
+
+import (
+	"log/syslog"
+)
+
+// auditSyslog - назначение журнала аудита в syslog
+type auditSyslog struct {
+	w *syslog.Writer
+}
+
+// dialAuditSyslog подключается к syslog: локальному при пустом
+// network, иначе к addr
+func dialAuditSyslog(network, addr string) (*auditSyslog, error) {
+	w, err := syslog.Dial(network, addr, syslog.LOG_INFO|syslog.LOG_DAEMON, auditSyslogTag)
+	if err != nil {
+		return nil, err
+	}
+	return &auditSyslog{w: w}, nil
+}
+
+// writeLine отправляет запись с уровнем info
+func (s *auditSyslog) writeLine(line []byte) error {
+	return s.w.Info(string(line))
+}
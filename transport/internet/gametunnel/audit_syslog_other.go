@@ -0,0 +1,18 @@
+//go:build windows || plan9
+
+package gametunnel
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// auditSyslog - syslog на этой платформе недоступен
+type auditSyslog struct{}
+
+// dialAuditSyslog - ошибка: в log/syslog нет этой платформы
+func dialAuditSyslog(network, addr string) (*auditSyslog, error) {
+	return nil, fmt.Errorf("syslog is not supported on %s", runtime.GOOS)
+}
+
+func (*auditSyslog) writeLine([]byte) error { return nil }
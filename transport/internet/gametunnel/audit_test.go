@@ -0,0 +1,160 @@
+package gametunnel
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditClientAddress(t *testing.T) {
+	tests := []struct {
+		mode AuditIPMode
+		addr string
+		want string
+	}{
+		{AuditIPMode_TRUNCATE, "203.0.113.77:40000", "203.0.113.0/24"},
+		{AuditIPMode_TRUNCATE, "[2001:db8:1234:5678::1]:443", "2001:db8:1234::/48"},
+		{AuditIPMode_FULL, "203.0.113.77:40000", "203.0.113.77:40000"},
+		{AuditIPMode_NONE, "203.0.113.77:40000", ""},
+		{AuditIPMode_TRUNCATE, "not an address", ""},
+	}
+	for _, tt := range tests {
+		a := &auditLog{mode: tt.mode}
+		if got := a.client(tt.addr); got != tt.want {
+			t.Errorf("mode %d client(%q) = %q, want %q", tt.mode, tt.addr, got, tt.want)
+		}
+	}
+
+	// hash: порт не влияет, соль - влияет
+	a := &auditLog{mode: AuditIPMode_HASH, salt: []byte("salt")}
+	h1, h2 := a.client("203.0.113.77:1"), a.client("203.0.113.77:2")
+	if len(h1) != 2*auditHashLen || h1 != h2 {
+		t.Errorf("hash %q / %q, want equal %d hex chars", h1, h2, 2*auditHashLen)
+	}
+	if strings.Contains(h1, "203") {
+		t.Errorf("hash %q leaks address", h1)
+	}
+	other := &auditLog{mode: AuditIPMode_HASH, salt: []byte("pepper")}
+	if other.client("203.0.113.77:1") == h1 {
+		t.Error("hash ignores salt")
+	}
+}
+
+func TestAuditTarget(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    auditDest
+		wantErr bool
+	}{
+		{"/var/log/gt-audit.jsonl", auditDest{path: "/var/log/gt-audit.jsonl"}, false},
+		{"syslog", auditDest{}, false},
+		{"syslog://10.0.0.5:514", auditDest{network: "udp", addr: "10.0.0.5:514"}, false},
+		{"syslog://10.0.0.5", auditDest{}, true},
+	}
+	for _, tt := range tests {
+		got, err := auditTarget(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("auditTarget(%q) = %+v, %v", tt.in, got, err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.AuditLog = "syslog://collector"
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "auditLog") {
+		t.Errorf("Validate = %v, want auditLog error", err)
+	}
+}
+
+func TestAuditFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	f, err := openAuditFile(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.maxSize = 100
+
+	line := []byte(strings.Repeat("x", 39))
+	for i := 0; i < 10; i++ {
+		if err := f.writeLine(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// По две строки на файл, старых хранится два
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(data), "\n"); n != 2 {
+			t.Errorf("%s: %d lines, want 2", filepath.Base(name), n)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("third old file kept: %v", err)
+	}
+
+	// Дозапись после перезапуска: размер учитывается
+	f.file.Close()
+	f, err = openAuditFile(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.size != 80 {
+		t.Errorf("reopened size %d, want 80", f.size)
+	}
+}
+
+func TestAuditLogSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	config := DefaultConfig()
+	config.Key = "audit"
+	config.AuditLog = path
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+
+	client.Write([]byte("hello"))
+	readWithTimeout(t, server, 1500)
+	l.hub.RemoveSession(client.session().ConnectionID)
+
+	var events []map[string]any
+	deadline := time.Now().Add(2 * time.Second)
+	for len(events) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = events[:0]
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			var e map[string]any
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("bad line %q: %v", scanner.Text(), err)
+			}
+			events = append(events, e)
+		}
+		file.Close()
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events, want created and closed: %v", len(events), events)
+	}
+
+	created, closed := events[0], events[1]
+	if created["event"] != "session_created" || closed["event"] != "session_closed" {
+		t.Errorf("events %v, %v", created["event"], closed["event"])
+	}
+	if created["client"] != "127.0.0.0/24" {
+		t.Errorf("client %v, want truncated /24", created["client"])
+	}
+	if closed["reason"] != "normal" || closed["bytesRecv"].(float64) == 0 {
+		t.Errorf("closed event %v", closed)
+	}
+	if _, ok := created["bytesRecv"]; ok {
+		t.Error("created event carries traffic")
+	}
+}
@@ -0,0 +1,202 @@
+package gametunnel
+
+import (
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ====================================================================
+// Пакетная отправка (sendmmsg)
+// ====================================================================
+//
+// На большой передаче sendLoop хаба упирается не в шифрование, а в
+// системные вызовы: один sendto на датаграмму. Пакеты уже зашифрованы
+// в очередях сессий, поэтому sendLoop может отдать ядру сразу пачку.
+//
+// sendLoop берёт пакеты из круга сессий как раньше, по одному за
+// заход сессии, но не пишет каждый сразу: пакеты, которые лимиты
+// пропускают без ожидания, копятся в пачку до sendBatch штук. Пачка
+// уходит, когда круг опустел, пачка полна или очередной пакет должен
+// ждать лимита (тогда накопленное уходит до ожидания). Подряд идущие
+// пакеты одного сокета и одного DSCP уходят одним sendmmsg.
+//
+// Ядро отправляет пачку по порядку; ошибка на сообщении засчитывается
+// его сессии (здоровье пути), остальные сообщения пачки уходят
+// следующим вызовом.
+//
+// sendBatch:
+//   0 - defaultSendBatch
+//   1 - без пачек, sendto на каждый пакет
+//   N - до N пакетов (не больше MaxSendBatch)
+//
+// sendmmsg есть только в Linux (batch_linux.go); на других ОС пачка
+// отправляется по одной датаграмме, как и у горутин sessionSenders. IPv6-сокет пишет IPv4-клиентам
+// (dual-stack) тоже по одной: x/net кодирует такие адреса как AF_INET.
+//
+// ====================================================================
+
+const (
+	// defaultSendBatch - пакетов в пачке по умолчанию
+	defaultSendBatch = 32
+
+	// MaxSendBatch - потолок sendBatch
+	MaxSendBatch = 256
+)
+
+// batchConn - пакетные запись и чтение UDP-сокета (ipv4/ipv6.PacketConn)
+type batchConn interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// sendBatchSize возвращает размер пачки sendLoop
+func sendBatchSize(config *Config) int {
+	if config.SendBatch == 0 {
+		return defaultSendBatch
+	}
+	return int(min(config.SendBatch, MaxSendBatch))
+}
+
+// batchEntry - пакет пачки с уже выбранным путём
+type batchEntry struct {
+	session *Session
+	sock    *dscpMarker
+	addr    *net.UDPAddr
+	data    []byte
+	level   PriorityLevel
+}
+
+// sendBatch - пакеты sendLoop, ожидающие общей отправки
+type sendBatch struct {
+	entries []batchEntry
+
+	// msgs - сообщения sendmmsg, переиспользуются между пачками
+	msgs []ipv4.Message
+}
+
+// newSendBatch создаёт пачку на size пакетов
+func newSendBatch(size int) *sendBatch {
+	b := &sendBatch{
+		entries: make([]batchEntry, 0, size),
+		msgs:    make([]ipv4.Message, size),
+	}
+	for i := range b.msgs {
+		b.msgs[i].Buffers = make([][]byte, 1)
+	}
+	return b
+}
+
+// full - пачка набрана
+func (b *sendBatch) full() bool {
+	return len(b.entries) == cap(b.entries)
+}
+
+// batchable - датаграмму на addr можно отправить пачкой через сокет
+func (m *dscpMarker) batchable(addr *net.UDPAddr) bool {
+	return m.batch != nil && (!m.ipv6 || addr.IP.To4() == nil)
+}
+
+// writeBatch отправляет msgs одной пачкой с DSCP уровня level
+// Возвращает число отправленных сообщений; при ошибке сообщение
+// msgs[n] не отправлено, следующие не пробовались
+func (m *dscpMarker) writeBatch(msgs []ipv4.Message, level PriorityLevel) (int, error) {
+	if m.tap != nil {
+		for i := range msgs {
+			addr, _ := msgs[i].Addr.(*net.UDPAddr)
+			m.captured(true, addr, msgs[i].Buffers[0])
+		}
+	}
+	if m.enabled {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.markLocked(level)
+	}
+
+	sent := 0
+	for sent < len(msgs) {
+		n, err := m.batch.WriteBatch(msgs[sent:], 0)
+		sent += n
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// addToBatch добавляет пакет сессии в пачку, выбрав путь сейчас - как
+// writeToSession в момент записи
+func (h *Hub) addToBatch(b *sendBatch, session *Session, pkt *PriorityPacket) {
+	sock, addr := session.route(pkt.Data, pkt.Priority)
+	b.entries = append(b.entries, batchEntry{
+		session: session,
+		sock:    sock,
+		addr:    addr,
+		data:    pkt.Data,
+		level:   pkt.Priority,
+	})
+
+	// Копия дублирующего потока - следом, если её не отправил multipath
+	// (duplicate.go)
+	if pkt.Duplicate && !session.copiesHigh() {
+		b.entries = append(b.entries, b.entries[len(b.entries)-1])
+		h.count(func(c *sideCounters) *uint64 { return &c.duplicatesSent }, 1)
+	}
+}
+
+// flushBatch отправляет пачку: подряд идущие пакеты одного сокета и
+// DSCP - одним вызовом, остальные - по одному
+func (h *Hub) flushBatch(b *sendBatch) {
+	entries := b.entries
+	for i := 0; i < len(entries); {
+		first := &entries[i]
+		j := i + 1
+		if first.sock.batchable(first.addr) {
+			for j < len(entries) && entries[j].sock == first.sock &&
+				entries[j].level == first.level && first.sock.batchable(entries[j].addr) {
+				j++
+			}
+		}
+
+		start := latencyStart()
+		if j-i == 1 {
+			n, err := first.sock.WriteToUDP(first.data, first.addr, first.level)
+			metrics.serverLatency.since(latencyWrite, first.level, start)
+			h.wrote(first.session, n, err)
+			i++
+			continue
+		}
+
+		msgs := b.msgs[:j-i]
+		for k := range msgs {
+			msgs[k].Buffers[0] = entries[i+k].data
+			msgs[k].Addr = entries[i+k].addr
+		}
+		sent, err := first.sock.writeBatch(msgs, first.level)
+		metrics.serverLatency.since(latencyWrite, first.level, start)
+		atomic.AddUint64(&h.sendBatches, 1)
+		for k := 0; k < sent; k++ {
+			h.wrote(entries[i+k].session, len(entries[i+k].data), nil)
+		}
+		if err != nil {
+			// Сообщение с ошибкой пропускаем, остальные - следующим вызовом
+			h.wrote(entries[i+sent].session, 0, err)
+			sent++
+		}
+		i += sent
+	}
+
+	// Не держим ссылки на отправленные пакеты
+	for k := range b.msgs[:len(entries)] {
+		b.msgs[k].Buffers[0] = nil
+		b.msgs[k].Addr = nil
+	}
+	clear(entries)
+	b.entries = entries[:0]
+}
+
+// GetSendBatches - вызовов sendmmsg sendLoop хаба
+func (h *Hub) GetSendBatches() uint64 {
+	return atomic.LoadUint64(&h.sendBatches)
+}
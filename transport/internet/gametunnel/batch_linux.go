@@ -0,0 +1,23 @@
+//go:build linux
+
+package gametunnel
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// newBatchConn открывает пакетную отправку (sendmmsg) для сокета conn
+// Сокету в памяти (memnet.go) - nil: sendmmsg нужен настоящий сокет
+func newBatchConn(conn PacketConn, isIPv6 bool) batchConn {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok || udp == nil {
+		return nil
+	}
+	if isIPv6 {
+		return ipv6.NewPacketConn(udp)
+	}
+	return ipv4.NewPacketConn(udp)
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package gametunnel
+
+// newBatchConn - sendmmsg есть только в Linux: на других ОС x/net
+// отправляет по одному сообщению за вызов, выигрыша нет
+func newBatchConn(conn PacketConn, isIPv6 bool) batchConn {
+	return nil
+}
@@ -0,0 +1,115 @@
+package gametunnel
+
+import "sync"
+
+// ====================================================================
+// Пул буферов горячего пути
+// ====================================================================
+//
+// На 128-байтный игровой пакет приходилось несколько аллокаций:
+// копия принятой датаграммы, шифротекст, собранный пакет. Все они
+// живут до конца обработки одного пакета.
+//
+// Приём (сервер): receiveLoop читает датаграмму прямо в буфер пула и
+// отдаёт его receivePacket; буфер возвращается в пул, когда пакет
+// разобран - сразу или в воркере пула расшифровки. Пакет данных
+// разбирается без копий (UnmarshalView), а открытый текст пишется в
+// отдельный буфер, так что после разбора на датаграмму никто не
+// ссылается.
+//
+// Открытый текст: буферы по классам размера (256/512/1024/
+// MaxPacketSize), чтобы пакет в очереди чтения держал не больше
+// двойного своего размера. Буфер живёт в очереди чтения сессии
+// (inbound.go) и возвращается в пул явно: Read дочитал пакет (с
+// хвостом после неполного Read), ReadMultiBuffer скопировал его в
+// буфер xray, читатель отпустил PacketLease (lease.go), кольцо
+// отбросило пакет или ошибка расшифровки.
+//
+// Приём (клиент): handlePacket обрабатывает пакет синхронно и его не
+// хранит - receiveLoop отдаёт ему свой буфер без копии.
+//
+// Отправка: шифротекст (EncryptTo) и пакет (MarshalTo) собираются в
+// буферах пула; в очередь уходит только результат Wrap. Raw-обфускатор
+// возвращает сам пакет - тогда в очередь идёт его копия (detach).
+//
+// ====================================================================
+
+// packetPool - буферы на датаграмму (len = cap >= MaxPacketSize)
+var packetPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, MaxPacketSize)
+		return &b
+	},
+}
+
+// getPacketBuf берёт буфер из пула
+func getPacketBuf() *[]byte {
+	return packetPool.Get().(*[]byte)
+}
+
+// putPacketBuf возвращает буфер в пул; ссылок на него больше быть не должно
+func putPacketBuf(b *[]byte) {
+	*b = (*b)[:cap(*b)]
+	packetPool.Put(b)
+}
+
+// detach возвращает wrapped, не ссылающийся на буфер пула packet
+// (результат Wrap этого пакета)
+func detach(wrapped, packet []byte) []byte {
+	if len(wrapped) == 0 || len(packet) == 0 || &wrapped[0] != &packet[0] {
+		return wrapped
+	}
+	return append([]byte(nil), wrapped...)
+}
+
+// Пулы буферов открытого текста по классам размера
+var (
+	plain256  = sync.Pool{New: func() any { return new([256]byte) }}
+	plain512  = sync.Pool{New: func() any { return new([512]byte) }}
+	plain1024 = sync.Pool{New: func() any { return new([1024]byte) }}
+	plainMax  = sync.Pool{New: func() any { return new([MaxPacketSize]byte) }}
+)
+
+// getPlainBuf берёт пустой буфер под открытый текст size байт
+// Больше MaxPacketSize - nil (DecryptTo выделит память сам)
+func getPlainBuf(size int) []byte {
+	switch {
+	case size <= 256:
+		return plain256.Get().(*[256]byte)[:0]
+	case size <= 512:
+		return plain512.Get().(*[512]byte)[:0]
+	case size <= 1024:
+		return plain1024.Get().(*[1024]byte)[:0]
+	case size <= MaxPacketSize:
+		return plainMax.Get().(*[MaxPacketSize]byte)[:0]
+	}
+	return nil
+}
+
+// putPlainBuf возвращает в пул буфер открытого текста b
+// b - результат расшифровки целиком, ссылок на него больше быть не
+// должно; срезы других размеров пул не принимает
+func putPlainBuf(b []byte) {
+	switch cap(b) {
+	case 256:
+		plain256.Put((*[256]byte)(b[:256]))
+	case 512:
+		plain512.Put((*[512]byte)(b[:512]))
+	case 1024:
+		plain1024.Put((*[1024]byte)(b[:1024]))
+	case MaxPacketSize:
+		plainMax.Put((*[MaxPacketSize]byte)(b[:MaxPacketSize]))
+	}
+}
+
+// decryptPlain расшифровывает ciphertext в буфер пула открытого текста
+// При ошибке буфер возвращается в пул
+func decryptPlain(keys *SessionKeys, ciphertext []byte, packetNumber uint32, additionalData []byte) ([]byte, error) {
+	buf := getPlainBuf(len(ciphertext) - AuthTagSize)
+	plaintext, err := keys.DecryptTo(buf, ciphertext, packetNumber, additionalData)
+	if err != nil {
+		putPlainBuf(buf)
+		return nil, err
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,297 @@
+package gametunnel
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMarshalToAppends(t *testing.T) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	pkt := NewDataPacket(connID, 7, []byte("payload"), false)
+
+	want, err := pkt.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := []byte("prefix")
+	got, err := pkt.MarshalTo(append(make([]byte, 0, 64), prefix...), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(got, prefix) || !bytes.Equal(got[len(prefix):], want) {
+		t.Fatalf("MarshalTo %x, want prefix + %x", got, want)
+	}
+
+	// Без ёмкости - новый буфер, префикс сохранён
+	got, _ = pkt.MarshalTo(prefix, config)
+	if !bytes.Equal(got[:len(prefix)], prefix) || !bytes.Equal(got[len(prefix):], want) {
+		t.Fatalf("MarshalTo grown %x", got)
+	}
+}
+
+func TestEncryptToRoundTrip(t *testing.T) {
+	clientKP, _ := GenerateKeyPair()
+	serverKP, _ := GenerateKeyPair()
+	sharedSecret, _ := ComputeSharedSecret(clientKP.PrivateKey, serverKP.PublicKey)
+	clientKeys, _ := DeriveSessionKeys(sharedSecret, "", true)
+	serverKeys, _ := DeriveSessionKeys(sharedSecret, "", false)
+	ad := make([]byte, 13)
+
+	sealBuf := getPacketBuf()
+	defer putPacketBuf(sealBuf)
+	ciphertext, err := clientKeys.EncryptTo((*sealBuf)[:0], []byte("game state"), 3, ad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &ciphertext[0] != &(*sealBuf)[0] {
+		t.Error("EncryptTo allocated despite capacity")
+	}
+	want, _ := clientKeys.Encrypt([]byte("game state"), 3, ad)
+	if !bytes.Equal(ciphertext, want) {
+		t.Fatal("EncryptTo differs from Encrypt")
+	}
+
+	plaintext, err := serverKeys.DecryptTo(make([]byte, 0, 64), ciphertext, 3, ad)
+	if err != nil || string(plaintext) != "game state" {
+		t.Fatalf("DecryptTo %q, %v", plaintext, err)
+	}
+}
+
+func TestDetachRawWrap(t *testing.T) {
+	packetBuf := getPacketBuf()
+	data := append((*packetBuf)[:0], "packet"...)
+
+	// Raw возвращает сам пакет - копия, иначе тот же срез
+	raw, _ := (&RawObfuscator{}).Wrap(data)
+	owned := detach(raw, data)
+	putPacketBuf(packetBuf)
+	copy(*packetBuf, "reused")
+	if string(owned) != "packet" {
+		t.Errorf("detached raw wrap changed to %q", owned)
+	}
+
+	wrapped := []byte("other")
+	if got := detach(wrapped, data); &got[0] != &wrapped[0] {
+		t.Error("detach copied a separate buffer")
+	}
+}
+
+func TestUnmarshalViewAliases(t *testing.T) {
+	config := DefaultConfig()
+	connIDLen := int(config.ConnectionIdLength)
+	connID, _ := GenerateConnectionID(connIDLen)
+	data, _ := NewDataPacket(connID, 9, []byte("payload"), false).Marshal(config)
+
+	view, err := UnmarshalView(data, connIDLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	owned, err := Unmarshal(data, connIDLen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if view.PacketNumber != 9 || string(view.Payload) != "payload" || !bytes.Equal(view.ConnectionID, connID) {
+		t.Fatalf("view %+v", view)
+	}
+
+	// View видит изменения data, копия - нет
+	copy(data[len(data)-len("payload"):], "PAYLOAD")
+	if string(view.Payload) != "PAYLOAD" || string(owned.Payload) != "payload" {
+		t.Errorf("view %q, copy %q", view.Payload, owned.Payload)
+	}
+	// Дописывание в срез view не портит соседние байты data
+	if cap(view.Payload) != len(view.Payload) || cap(view.ConnectionID) != connIDLen {
+		t.Error("view slices are not capped")
+	}
+
+	if _, err := UnmarshalView(data[:len(data)-1], connIDLen); err == nil {
+		t.Error("truncated payload accepted")
+	}
+}
+
+func TestDataADCache(t *testing.T) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	var slot atomic.Pointer[dataAD]
+
+	// Кэш совпадает с заголовком собранного пакета
+	for _, padding := range []bool{false, true} {
+		data, _ := NewDataPacket(connID, 5, []byte("x"), padding).Marshal(config)
+		want := data[:FlagsSize+VersionSize+len(connID)]
+		if ad := loadDataAD(&slot, connID, padding); !bytes.Equal(ad, want) {
+			t.Errorf("padding %v: AD %x, want %x", padding, ad, want)
+		}
+	}
+	cached := slot.Load()
+	loadDataAD(&slot, connID, true)
+	if slot.Load() != cached {
+		t.Error("AD rebuilt for the same Connection ID")
+	}
+
+	// Новый Connection ID - новый кэш
+	rotated, _ := GenerateConnectionID(len(connID))
+	if ad := loadDataAD(&slot, rotated, false); !bytes.Equal(ad[FlagsSize+VersionSize:], rotated) {
+		t.Errorf("AD after rotation %x", ad)
+	}
+}
+
+func TestAppendDataPacket(t *testing.T) {
+	config := DefaultConfig()
+	config.PaddingMinSize, config.PaddingMaxSize = 8, 32
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	var slot atomic.Pointer[dataAD]
+
+	// Без padding - байт в байт как MarshalTo
+	want, _ := NewDataPacket(connID, 11, []byte("payload"), false).Marshal(config)
+	got := appendDataPacket(nil, loadDataAD(&slot, connID, false), 11, []byte("payload"), config)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("template %x, want %x", got, want)
+	}
+
+	// С padding - тот же разбор, padding в пределах конфига
+	prefix := []byte("prefix")
+	got = appendDataPacket(append(make([]byte, 0, 128), prefix...), loadDataAD(&slot, connID, true), 12, []byte("payload"), config)
+	if !bytes.HasPrefix(got, prefix) {
+		t.Fatal("prefix lost")
+	}
+	pkt, err := Unmarshal(got[len(prefix):], len(connID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pkt.HasPadding || pkt.PacketNumber != 12 || string(pkt.Payload) != "payload" || !bytes.Equal(pkt.ConnectionID, connID) {
+		t.Errorf("parsed %+v", pkt)
+	}
+	padding := len(got) - len(prefix) - len(want) - PaddingLengthSize
+	if padding < 8 || padding >= 32 {
+		t.Errorf("padding %d bytes", padding)
+	}
+
+	// Padding выключен в конфиге - флаг шаблона не добавляет хвост
+	config.EnablePadding = false
+	if got := appendDataPacket(nil, loadDataAD(&slot, connID, true), 1, []byte("x"), config); len(got) != len(want)-len("payload")+1 {
+		t.Errorf("padding added with padding disabled: %d bytes", len(got))
+	}
+}
+
+func BenchmarkEncryptTo(b *testing.B) {
+	clientKP, _ := GenerateKeyPair()
+	serverKP, _ := GenerateKeyPair()
+	sharedSecret, _ := ComputeSharedSecret(clientKP.PrivateKey, serverKP.PublicKey)
+	keys, _ := DeriveSessionKeys(sharedSecret, "", true)
+
+	payload := make([]byte, 128)
+	ad := make([]byte, 13)
+	buf := make([]byte, 0, MaxPacketSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		keys.EncryptTo(buf, payload, uint32(i), ad)
+	}
+}
+
+func BenchmarkMarshalPacketTo(b *testing.B) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	payload := make([]byte, 128)
+	buf := make([]byte, 0, MaxPacketSize)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pkt := NewDataPacket(connID, uint32(i), payload, false)
+		pkt.MarshalTo(buf, config)
+	}
+}
+
+// BenchmarkFullPipelinePooled - BenchmarkFullPipeline на буферах пула,
+// с AD и шаблоном заголовка из кэша сессии, как отправка хаба и клиента
+func BenchmarkFullPipelinePooled(b *testing.B) {
+	config := DefaultConfig()
+	clientKP, _ := GenerateKeyPair()
+	serverKP, _ := GenerateKeyPair()
+	sharedSecret, _ := ComputeSharedSecret(clientKP.PrivateKey, serverKP.PublicKey)
+	clientKeys, _ := DeriveSessionKeys(sharedSecret, "", true)
+
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	payload := make([]byte, 128)
+	var adCache atomic.Pointer[dataAD]
+	obfs := &QUICObfuscator{connIDLen: int(config.ConnectionIdLength)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sealBuf, packetBuf := getPacketBuf(), getPacketBuf()
+		ad := loadDataAD(&adCache, connID, false)
+		ciphertext, _ := clientKeys.EncryptTo((*sealBuf)[:0], payload, uint32(i), ad)
+		data := appendDataPacket((*packetBuf)[:0], ad, uint32(i), ciphertext, config)
+		obfs.Wrap(data)
+		putPacketBuf(sealBuf)
+		putPacketBuf(packetBuf)
+	}
+}
+
+// BenchmarkMarshalData - сборка DATA-пакета: MarshalTo против шаблона
+// заголовка сессии
+func BenchmarkMarshalData(b *testing.B) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	payload := make([]byte, 144)
+	buf := make([]byte, 0, MaxPacketSize)
+
+	b.Run("MarshalTo", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			NewDataPacket(connID, uint32(i), payload, false).MarshalTo(buf, config)
+		}
+	})
+	// Шаблон уже загружен как AD для шифрования
+	b.Run("template", func(b *testing.B) {
+		var slot atomic.Pointer[dataAD]
+		header := loadDataAD(&slot, connID, false)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			appendDataPacket(buf, header, uint32(i), payload, config)
+		}
+	})
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	data, _ := NewDataPacket(connID, 1, make([]byte, 144), false).Marshal(config)
+
+	b.Run("copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			Unmarshal(data, len(connID))
+		}
+	})
+	b.Run("view", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			UnmarshalView(data, len(connID))
+		}
+	})
+}
+
+func BenchmarkDataAD(b *testing.B) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+
+	b.Run("build", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			newDataAD(connID)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		var slot atomic.Pointer[dataAD]
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loadDataAD(&slot, connID, i&1 == 0)
+		}
+	})
+}
@@ -0,0 +1,399 @@
+package gametunnel
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Запись пакетов в pcapng (captureFile)
+// ====================================================================
+//
+// Отладочный режим: с captureFile транспорт пишет каждый отправленный
+// и принятый пакет в файл pcapng - сверить маскировку с настоящими
+// QUIC/DTLS в Wireshark и разобрать несовместимость с DPI по дампу.
+// В файле три интерфейса, по стадии пакета:
+//
+//	0 wire       - LINKTYPE_RAW: датаграмма как на проводе, после
+//	               обфускации, с IPv4/IPv6 и UDP заголовком из
+//	               адресов сокета (Wireshark сам разбирает QUIC и DTLS)
+//	1 gametunnel - LINKTYPE_USER0: пакет GameTunnel до обфускации
+//	               (после снятия обёртки у принятых)
+//	2 plaintext  - LINKTYPE_USER1: открытый текст DATA-пакета; без
+//	               capturePlaintext - нули той же длины (видны размеры
+//	               и тайминги, но не данные пользователя)
+//
+// Метаданные для своего диссектора (USER0/USER1 в Wireshark
+// назначаются через DLT_USER): направление - в epb_flags, в
+// комментарии пакета - режим обфускации, тип пакета, первые 4 байта
+// connection ID и номер пакета:
+//
+//	"quic-mimic DATA conn=1a2b3c4d pkt=17"
+//
+// Файл открывается один раз на процесс для каждого пути (при этом
+// усекается), все inbound и outbound с тем же captureFile пишут в
+// него. Запись синхронная, под мьютексом файла, и размер файла не
+// ограничен: режим для отладки, не для продакшена.
+//
+// ====================================================================
+
+// Типы блоков и опции pcapng
+const (
+	pcapngSectionHeader  = 0x0A0D0D0A
+	pcapngInterfaceDesc  = 0x00000001
+	pcapngEnhancedPacket = 0x00000006
+	pcapngByteOrderMagic = 0x1A2B3C4D
+	pcapngOptEnd         = 0
+	pcapngOptComment     = 1
+	pcapngOptIfName      = 2
+	pcapngOptIfDesc      = 3
+	pcapngOptShbUserAppl = 4
+	pcapngOptEpbFlags    = 2
+	pcapngFlagInbound    = 1
+	pcapngFlagOutbound   = 2
+	pcapngLinkTypeRaw    = 101
+	pcapngLinkTypeUser0  = 147
+	pcapngLinkTypeUser1  = 148
+)
+
+// Номера интерфейсов файла - стадии пакета
+const (
+	captureWireIface      = 0
+	capturePacketIface    = 1
+	capturePlaintextIface = 2
+)
+
+// captureInterfaces - интерфейсы файла в порядке номеров
+var captureInterfaces = []struct {
+	linkType    uint16
+	name, descr string
+}{
+	{pcapngLinkTypeRaw, "wire", "datagrams after obfuscation"},
+	{pcapngLinkTypeUser0, "gametunnel", "GameTunnel packets before obfuscation"},
+	{pcapngLinkTypeUser1, "plaintext", "decrypted DATA payloads"},
+}
+
+// captureErrorLog - ошибки записи файла (warning)
+var captureErrorLog logThrottle
+
+// captureFile - открытый файл pcapng
+type captureFile struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// captureFiles - открытые файлы по пути
+var captureFiles = struct {
+	sync.Mutex
+	files map[string]*captureFile
+}{files: make(map[string]*captureFile)}
+
+// openCaptureFile открывает файл pcapng пути path или возвращает уже
+// открытый
+func openCaptureFile(path string) (*captureFile, error) {
+	captureFiles.Lock()
+	defer captureFiles.Unlock()
+
+	if f := captureFiles.files[path]; f != nil {
+		return f, nil
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	// Заголовок секции и описания интерфейсов
+	header := appendSectionHeader(nil)
+	for _, iface := range captureInterfaces {
+		header = appendInterfaceDesc(header, iface.linkType, iface.name, iface.descr)
+	}
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	f := &captureFile{file: file}
+	captureFiles.files[path] = f
+	return f, nil
+}
+
+// writePacket дописывает пакет интерфейса iface
+func (f *captureFile) writePacket(iface uint32, out bool, data []byte, comment string) {
+	block := appendEnhancedPacket(nil, iface, time.Now(), out, data, comment)
+	f.mu.Lock()
+	_, err := f.file.Write(block)
+	f.mu.Unlock()
+	if err != nil {
+		captureErrorLog.logf(log.Severity_Warning, "captureFile %s: %v", f.file.Name(), err)
+	}
+}
+
+// packetTap - запись пакетов хаба или клиента (nil - запись выключена)
+type packetTap struct {
+	file *captureFile
+
+	// mode - имя обфускатора для комментариев (задаёт NewObfuscator)
+	mode string
+
+	// connIDLen - длина connection ID для разбора заголовка
+	connIDLen int
+
+	// plaintext - писать открытый текст как есть, а не нули
+	plaintext bool
+}
+
+// newPacketTap - запись пакетов по config (nil без captureFile)
+func newPacketTap(config *Config) (*packetTap, error) {
+	if config.CaptureFile == "" {
+		return nil, nil
+	}
+	file, err := openCaptureFile(config.CaptureFile)
+	if err != nil {
+		return nil, fmt.Errorf("open captureFile: %w", err)
+	}
+	return &packetTap{
+		file:      file,
+		connIDLen: int(config.ConnectionIdLength),
+		plaintext: config.CapturePlaintext,
+	}, nil
+}
+
+// wire записывает датаграмму между local и remote как на проводе
+func (t *packetTap) wire(out bool, local, remote *net.UDPAddr, datagram []byte) {
+	if t == nil || local == nil || remote == nil {
+		return
+	}
+	src, dst := local, remote
+	if !out {
+		src, dst = remote, local
+	}
+	t.file.writePacket(captureWireIface, out, appendIPUDP(nil, src, dst, datagram), "")
+}
+
+// packet записывает пакет GameTunnel до обфускации
+func (t *packetTap) packet(out bool, data []byte) {
+	if t == nil {
+		return
+	}
+	t.file.writePacket(capturePacketIface, out, data, t.describe(data))
+}
+
+// payload записывает открытый текст DATA-пакета pktNum сессии connID
+func (t *packetTap) payload(out bool, connID []byte, pktNum uint32, plaintext []byte) {
+	if t == nil {
+		return
+	}
+	data := plaintext
+	if !t.plaintext {
+		data = make([]byte, len(plaintext))
+	}
+	comment := fmt.Sprintf("%s DATA conn=%s pkt=%d", t.mode, shortConnID(connID), pktNum)
+	if !t.plaintext {
+		comment += " redacted"
+	}
+	t.file.writePacket(capturePlaintextIface, out, data, comment)
+}
+
+// describe - комментарий пакета GameTunnel: тип, connection ID, номер
+func (t *packetTap) describe(data []byte) string {
+	// Сжатый заголовок: только номер контекста (compact.go)
+	if len(data) >= compactHeaderSize && isCompactPacket(data[0]) {
+		return fmt.Sprintf("%s DATA compact ctx=%d", t.mode, data[1])
+	}
+	offset := FlagsSize + VersionSize
+	if len(data) < offset+t.connIDLen+PacketNumberSize {
+		return t.mode + " short"
+	}
+	pktType, _, err := DecodeFlags(data[0])
+	if err != nil {
+		return t.mode + " invalid"
+	}
+	connID := data[offset : offset+t.connIDLen]
+	pktNum := binary.BigEndian.Uint32(data[offset+t.connIDLen:])
+	return fmt.Sprintf("%s %s conn=%s pkt=%d", t.mode, pktType, shortConnID(connID), pktNum)
+}
+
+// shortConnID - первые 4 байта connection ID в hex
+func shortConnID(connID []byte) string {
+	if len(connID) > 4 {
+		connID = connID[:4]
+	}
+	return hex.EncodeToString(connID)
+}
+
+// captureObfuscator - обфускатор, записывающий пакеты GameTunnel
+// до обёртки и после её снятия
+type captureObfuscator struct {
+	Obfuscator
+	tap *packetTap
+}
+
+func (o *captureObfuscator) Wrap(packet []byte) ([]byte, error) {
+	o.tap.packet(true, packet)
+	return o.Obfuscator.Wrap(packet)
+}
+
+// WrapHandshake - пакет хэндшейка, обёрнутый как у обфускатора
+func (o *captureObfuscator) WrapHandshake(packet []byte, limit int) ([]byte, error) {
+	o.tap.packet(true, packet)
+	return wrapHandshake(o.Obfuscator, packet, limit)
+}
+
+func (o *captureObfuscator) Unwrap(data []byte) ([]byte, error) {
+	packet, err := o.Obfuscator.Unwrap(data)
+	if err == nil {
+		o.tap.packet(false, packet)
+	}
+	return packet, err
+}
+
+// tapOf - запись пакетов обфускатора (nil - запись выключена)
+func tapOf(obfs Obfuscator) *packetTap {
+	if co, ok := obfs.(*captureObfuscator); ok {
+		return co.tap
+	}
+	return nil
+}
+
+// udpLocalAddr - локальный адрес сокета
+func udpLocalAddr(conn PacketConn) *net.UDPAddr {
+	addr, _ := conn.LocalAddr().(*net.UDPAddr)
+	return addr
+}
+
+// appendSectionHeader дописывает Section Header Block
+func appendSectionHeader(b []byte) []byte {
+	body := binary.LittleEndian.AppendUint32(nil, pcapngByteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // major
+	body = binary.LittleEndian.AppendUint16(body, 0) // minor
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0))
+	body = appendOption(body, pcapngOptShbUserAppl, []byte("xray gametunnel"))
+	body = appendOption(body, pcapngOptEnd, nil)
+	return appendBlock(b, pcapngSectionHeader, body)
+}
+
+// appendInterfaceDesc дописывает Interface Description Block
+func appendInterfaceDesc(b []byte, linkType uint16, name, descr string) []byte {
+	body := binary.LittleEndian.AppendUint16(nil, linkType)
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, 0) // snaplen без ограничения
+	body = appendOption(body, pcapngOptIfName, []byte(name))
+	body = appendOption(body, pcapngOptIfDesc, []byte(descr))
+	body = appendOption(body, pcapngOptEnd, nil)
+	return appendBlock(b, pcapngInterfaceDesc, body)
+}
+
+// appendEnhancedPacket дописывает Enhanced Packet Block (время в мкс)
+func appendEnhancedPacket(b []byte, iface uint32, ts time.Time, out bool, data []byte, comment string) []byte {
+	micros := uint64(ts.UnixMicro())
+	body := binary.LittleEndian.AppendUint32(nil, iface)
+	body = binary.LittleEndian.AppendUint32(body, uint32(micros>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(micros))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(data)))
+	body = appendPadded(body, data)
+
+	flags := uint32(pcapngFlagInbound)
+	if out {
+		flags = pcapngFlagOutbound
+	}
+	if comment != "" {
+		body = appendOption(body, pcapngOptComment, []byte(comment))
+	}
+	body = appendOption(body, pcapngOptEpbFlags, binary.LittleEndian.AppendUint32(nil, flags))
+	body = appendOption(body, pcapngOptEnd, nil)
+	return appendBlock(b, pcapngEnhancedPacket, body)
+}
+
+// appendBlock оборачивает тело блока: тип, длина, тело, длина
+func appendBlock(b []byte, blockType uint32, body []byte) []byte {
+	total := uint32(12 + len(body))
+	b = binary.LittleEndian.AppendUint32(b, blockType)
+	b = binary.LittleEndian.AppendUint32(b, total)
+	b = append(b, body...)
+	return binary.LittleEndian.AppendUint32(b, total)
+}
+
+// appendOption дописывает опцию: код, длина, значение до 4 байт
+func appendOption(b []byte, code uint16, value []byte) []byte {
+	b = binary.LittleEndian.AppendUint16(b, code)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(value)))
+	return appendPadded(b, value)
+}
+
+// appendPadded дописывает data с выравниванием до 4 байт
+func appendPadded(b, data []byte) []byte {
+	b = append(b, data...)
+	for pad := (4 - len(data)%4) % 4; pad > 0; pad-- {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// appendIPUDP дописывает IP и UDP заголовки и payload датаграммы
+// IPv4 - если адрес получателя IPv4 (сокет dual-stack тоже)
+func appendIPUDP(b []byte, src, dst *net.UDPAddr, payload []byte) []byte {
+	udpLen := 8 + len(payload)
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if dstIP != nil {
+		if srcIP == nil {
+			srcIP = net.IPv4zero.To4()
+		}
+		b = append(b, 0x45, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(20+udpLen))
+		b = append(b, 0, 0, 0x40, 0, 64, 17, 0, 0) // id, DF, TTL, UDP, checksum
+		b = append(b, srcIP...)
+		b = append(b, dstIP...)
+		header := b[len(b)-20:]
+		binary.BigEndian.PutUint16(header[10:], ^onesSum(0, header))
+	} else {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		if srcIP == nil {
+			srcIP = net.IPv6zero
+		}
+		b = append(b, 0x60, 0, 0, 0)
+		b = binary.BigEndian.AppendUint16(b, uint16(udpLen))
+		b = append(b, 17, 64) // UDP, hop limit
+		b = append(b, srcIP...)
+		b = append(b, dstIP...)
+	}
+
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, uint16(src.Port))
+	b = binary.BigEndian.AppendUint16(b, uint16(dst.Port))
+	b = binary.BigEndian.AppendUint16(b, uint16(udpLen))
+	b = append(b, 0, 0)
+	b = append(b, payload...)
+
+	// Контрольная сумма UDP по псевдозаголовку
+	sum := onesSum(0, srcIP)
+	sum = onesSum(uint32(sum), dstIP)
+	sum = onesSum(uint32(sum)+17+uint32(udpLen), b[start:])
+	checksum := ^sum
+	if checksum == 0 {
+		checksum = 0xffff
+	}
+	binary.BigEndian.PutUint16(b[start+6:], checksum)
+	return b
+}
+
+// onesSum - сумма в обратном коде 16-битных слов data поверх sum
+func onesSum(sum uint32, data []byte) uint16 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(data[i])<<8 | uint32(data[i+1])
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return uint16(sum)
+}
@@ -0,0 +1,161 @@
+package gametunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capturedPacket - Enhanced Packet Block из дампа
+type capturedPacket struct {
+	iface   uint32
+	out     bool
+	data    []byte
+	comment string
+}
+
+// readCapture разбирает дамп pcapng: число описаний интерфейсов и
+// пакеты; недописанный последний блок пропускается
+func readCapture(t *testing.T, path string) (int, []capturedPacket) {
+	t.Helper()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 12 || binary.LittleEndian.Uint32(raw) != pcapngSectionHeader ||
+		binary.LittleEndian.Uint32(raw[8:]) != pcapngByteOrderMagic {
+		t.Fatalf("no pcapng section header: % x", raw[:min(len(raw), 12)])
+	}
+
+	var ifaces int
+	var packets []capturedPacket
+	for len(raw) >= 12 {
+		blockType := binary.LittleEndian.Uint32(raw)
+		total := int(binary.LittleEndian.Uint32(raw[4:]))
+		if total < 12 || total%4 != 0 || total > len(raw) {
+			break
+		}
+		if binary.LittleEndian.Uint32(raw[total-4:]) != uint32(total) {
+			t.Fatalf("block trailer mismatch")
+		}
+		body := raw[8 : total-4]
+
+		switch blockType {
+		case pcapngInterfaceDesc:
+			ifaces++
+		case pcapngEnhancedPacket:
+			capLen := int(binary.LittleEndian.Uint32(body[12:]))
+			pkt := capturedPacket{
+				iface: binary.LittleEndian.Uint32(body),
+				data:  body[20 : 20+capLen],
+			}
+			opts := body[20+(capLen+3)/4*4:]
+			for len(opts) >= 4 {
+				code := binary.LittleEndian.Uint16(opts)
+				n := int(binary.LittleEndian.Uint16(opts[2:]))
+				if code == pcapngOptEnd {
+					break
+				}
+				value := opts[4 : 4+n]
+				switch code {
+				case pcapngOptComment:
+					pkt.comment = string(value)
+				case pcapngOptEpbFlags:
+					pkt.out = binary.LittleEndian.Uint32(value)&3 == pcapngFlagOutbound
+				}
+				opts = opts[4+(n+3)/4*4:]
+			}
+			packets = append(packets, pkt)
+		}
+		raw = raw[total:]
+	}
+	return ifaces, packets
+}
+
+func TestCaptureFile(t *testing.T) {
+	for _, plaintext := range []bool{false, true} {
+		config := DefaultConfig()
+		config.Key = "capture"
+		config.CaptureFile = filepath.Join(t.TempDir(), "gametunnel.pcapng")
+		config.CapturePlaintext = plaintext
+
+		l, accepted := startTestListener(t, config)
+		client, server := dialTestClient(t, l, config, accepted)
+
+		marker := []byte("capture-marker-0123456789")
+		if _, err := client.Write(marker); err != nil {
+			t.Fatal(err)
+		}
+		readWithTimeout(t, server, 1500)
+		if _, err := server.Write(marker); err != nil {
+			t.Fatal(err)
+		}
+		readWithTimeout(t, client, 1500)
+		// Отправитель пишет в дамп в своей горутине - даём ей дописать
+		time.Sleep(50 * time.Millisecond)
+
+		ifaces, packets := readCapture(t, config.CaptureFile)
+		if ifaces != len(captureInterfaces) {
+			t.Errorf("%d interfaces, want %d", ifaces, len(captureInterfaces))
+		}
+
+		// Каждая стадия - в обе стороны
+		type stage struct {
+			iface uint32
+			out   bool
+		}
+		seen := make(map[stage]bool)
+		port := uint16(l.Addr().(*net.UDPAddr).Port)
+		var markers int
+		for _, pkt := range packets {
+			seen[stage{pkt.iface, pkt.out}] = true
+			switch pkt.iface {
+			case captureWireIface:
+				if pkt.data[0] != 0x45 || pkt.data[9] != 17 {
+					t.Fatalf("wire packet is not IPv4/UDP: % x", pkt.data[:20])
+				}
+				src, dst := binary.BigEndian.Uint16(pkt.data[20:]), binary.BigEndian.Uint16(pkt.data[22:])
+				if src != port && dst != port {
+					t.Errorf("wire packet %d -> %d misses server port %d", src, dst, port)
+				}
+			case capturePacketIface:
+				if !strings.HasPrefix(pkt.comment, "quic-mimic ") || !strings.Contains(pkt.comment, " conn=") {
+					t.Errorf("packet comment %q", pkt.comment)
+				}
+			case capturePlaintextIface:
+				if bytes.Equal(pkt.data, marker) {
+					markers++
+				}
+				if !plaintext && !strings.HasSuffix(pkt.comment, " redacted") {
+					t.Errorf("payload comment %q", pkt.comment)
+				}
+			}
+		}
+		for iface := uint32(0); iface < uint32(len(captureInterfaces)); iface++ {
+			for _, out := range []bool{false, true} {
+				if !seen[stage{iface, out}] {
+					t.Errorf("plaintext=%t: no packets on interface %d (out=%t)", plaintext, iface, out)
+				}
+			}
+		}
+
+		// Две стороны в одном процессе: каждая запись видна дважды
+		want := 0
+		if plaintext {
+			want = 4
+		}
+		if markers != want {
+			t.Errorf("plaintext=%t: marker captured %d times, want %d", plaintext, markers, want)
+		}
+		if _, err := newPacketTap(&Config{CaptureFile: t.TempDir()}); err == nil {
+			t.Error("directory accepted as captureFile")
+		}
+		client.Close()
+	}
+}
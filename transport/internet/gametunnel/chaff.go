@@ -0,0 +1,106 @@
+package gametunnel
+
+import (
+	mrand "math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Покрывающий трафик клиента (chaff)
+// ====================================================================
+//
+// Молчащий туннель тоже выдаёт себя: паузы и всплески повторяют
+// активность приложения, а редкие keep-alive - периодичный маячок.
+// С chaffBudget > 0 клиент в простое шлёт пустые DATA-пакеты с
+// padding, не больше chaffBudget байт в секунду.
+//
+// Интервалы между пакетами случайные, экспоненциальные (поток
+// Пуассона): у такого потока нет периода, за который можно
+// зацепиться. Средний интервал - размер пакета с худшим padding,
+// делённый на бюджет, так что фактический расход не выше бюджета.
+//
+// Пока клиент сам отправляет данные, покрывающий пакет не нужен -
+// тик пропускается. Сервер пакеты с пустым открытым текстом
+// расшифровывает (это обычный DATA, с учётом и продлением сессии) и
+// отбрасывает, приложению они не передаются.
+//
+// ====================================================================
+
+// minChaffInterval - нижняя граница среднего интервала покрывающих
+// пакетов: бюджет сверх неё не превращает клиент в генератор флуда
+const minChaffInterval = 10 * time.Millisecond
+
+// chaffInterval - средний интервал покрывающих пакетов для бюджета
+func chaffInterval(config *Config) time.Duration {
+	interval := time.Duration(config.packetOverhead()) * time.Second / time.Duration(config.ChaffBudget)
+	if interval < minChaffInterval {
+		interval = minChaffInterval
+	}
+	return interval
+}
+
+// chaffDelay - случайная пауза до следующего пакета со средним mean
+func chaffDelay(mean time.Duration) time.Duration {
+	return time.Duration(mrand.ExpFloat64() * float64(mean))
+}
+
+// chaffLoop - таймер покрывающего трафика соединения
+// Бюджет - из снимка настроек (tunables.go), 0 - выключен до смены
+func (c *GameTunnelClientConn) chaffLoop() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		tuned := c.tunedConfig()
+		var mean time.Duration
+		if tuned.config.ChaffBudget > 0 {
+			mean = chaffInterval(tuned.config)
+			timer.Reset(chaffDelay(mean))
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-tuned.changed:
+			timer.Stop()
+		case now := <-timer.C:
+			// Данные уходили за последний интервал - маскировать нечего
+			if atomic.LoadInt64(&c.lastSendAt) <= now.Add(-mean).UnixNano() {
+				c.sendChaff()
+			}
+		}
+	}
+}
+
+// sendChaff отправляет пустой DATA-пакет мимо очереди приоритетов
+// Не меняет lastSendAt: покрывающий трафик - не признак живого пути
+func (c *GameTunnelClientConn) sendChaff() {
+	session := c.session()
+	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
+	ad := loadDataAD(&session.dataAD, session.ConnectionID, c.config.EnablePadding)
+
+	sealBuf, packetBuf := getPacketBuf(), getPacketBuf()
+	defer putPacketBuf(sealBuf)
+	defer putPacketBuf(packetBuf)
+
+	ciphertext, err := session.Keys.EncryptTo((*sealBuf)[:0], nil, pktNum, ad)
+	if err != nil {
+		return
+	}
+	data := appendDataPacket((*packetBuf)[:0], ad, pktNum, ciphertext, c.config)
+
+	wrapped, err := c.obfs.Wrap(data)
+	if err != nil {
+		return
+	}
+	if _, err := c.write(wrapped, PriorityLow); err == nil {
+		atomic.AddUint64(&c.chaffSent, 1)
+	}
+}
+
+// GetChaffSent возвращает число отправленных покрывающих пакетов
+func (c *GameTunnelClientConn) GetChaffSent() uint64 {
+	return atomic.LoadUint64(&c.chaffSent)
+}
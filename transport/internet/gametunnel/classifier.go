@@ -0,0 +1,260 @@
+package gametunnel
+
+import (
+	"sync"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// ====================================================================
+// Классификация потоков
+// ====================================================================
+//
+// Размер отдельного пакета - плохой признак: хвостовой сегмент
+// TCP-загрузки маленький, а видеокадр большой. Поведение потока
+// говорит больше:
+//   - частота пакетов и регулярность интервалов
+//   - средний размер пакета (EWMA)
+//   - порт/протокол назначения из контекста xray (если известен)
+//
+// Голос (Opus, игровые голосовые чаты, RTP) - маленькие пакеты
+// со строгим интервалом 20-60ms, т.е. 20-50 pps. Такой поток
+// отмечается как Voice и закрепляется в High: отдельный крупный
+// пакет внутри него не меняет класс. Для устойчивости признак
+// имеет гистерезис - снимается только при явном уходе за пределы.
+//
+// Classifier - подключаемая стратегия. По умолчанию используется
+// SizeClassifier (прежнее поведение), HeuristicClassifier
+// включается в конфиге ("classifier": "heuristic") или
+// программно через SetClassifier на Hub / клиентском соединении.
+//
+// ====================================================================
+
+// ClassifierType - встроенная стратегия классификации
+type ClassifierType int32
+
+const (
+	// ClassifierType_SIZE - классификация по размеру пакета
+	ClassifierType_SIZE ClassifierType = 0
+
+	// ClassifierType_HEURISTIC - классификация по поведению потока
+	ClassifierType_HEURISTIC ClassifierType = 1
+)
+
+const (
+	// flowEWMAWeight - вес нового наблюдения в EWMA (1/8, как SRTT в TCP)
+	flowEWMAWeight = 0.125
+
+	// flowWarmupPackets - сколько пакетов нужно для поведенческих выводов
+	flowWarmupPackets = 8
+
+	// flowIdleReset - после такой паузы поток считается новым
+	flowIdleReset = 5 * time.Second
+
+	// bulkMinRate - частота (pps), начиная с которой поток больших
+	// пакетов считается загрузкой
+	bulkMinRate = 100
+
+	// interactiveMaxRate - частота (pps), выше которой поток маленьких
+	// пакетов уже не похож на игру (тики серверов - 20-128 Гц)
+	interactiveMaxRate = 200
+
+	// Границы голосового потока: вход в режим Voice
+	voiceMinRate = 20
+	voiceMaxRate = 50
+	voiceMaxSize = 300
+
+	// Границы выхода из режима Voice (гистерезис)
+	voiceExitMinRate = 15
+	voiceExitMaxRate = 65
+	voiceExitMaxSize = 400
+)
+
+// FlowInfo - наблюдаемые характеристики потока для Classifier
+type FlowInfo struct {
+	// Destination - цель проксируемого соединения (если известна)
+	Destination xnet.Destination
+
+	// Packets - пакетов в потоке с момента начала наблюдения
+	Packets uint64
+
+	// AvgSize - EWMA размера пакета (байт)
+	AvgSize float64
+
+	// AvgInterval - EWMA интервала между пакетами
+	AvgInterval time.Duration
+
+	// IntervalJitter - EWMA отклонения интервала от среднего
+	IntervalJitter time.Duration
+
+	// Voice - поток похож на голос/RTP (маленькие регулярные пакеты)
+	Voice bool
+}
+
+// Rate возвращает оценку частоты пакетов (pps)
+func (f *FlowInfo) Rate() float64 {
+	if f.AvgInterval <= 0 {
+		return 0
+	}
+	return float64(time.Second) / float64(f.AvgInterval)
+}
+
+// Classifier определяет приоритет исходящего пакета
+type Classifier interface {
+	// Classify возвращает уровень приоритета для payload потока flow
+	Classify(flow *FlowInfo, payload []byte) PriorityLevel
+}
+
+// NewClassifier создаёт встроенный классификатор по конфигу
+func NewClassifier(config *Config) Classifier {
+	switch config.Classifier {
+	case ClassifierType_HEURISTIC:
+		return &HeuristicClassifier{Mode: config.Priority}
+	default:
+		return &SizeClassifier{Mode: config.Priority}
+	}
+}
+
+// ClassifierTypeFromString парсит строковое значение классификатора
+func ClassifierTypeFromString(s string) ClassifierType {
+	switch s {
+	case "heuristic", "flow", "HEURISTIC":
+		return ClassifierType_HEURISTIC
+	default:
+		return ClassifierType_SIZE
+	}
+}
+
+// SizeClassifier - классификация по размеру пакета (по умолчанию)
+type SizeClassifier struct {
+	Mode PriorityMode
+}
+
+// Classify реализует Classifier
+func (c *SizeClassifier) Classify(flow *FlowInfo, payload []byte) PriorityLevel {
+	return ClassifyPacket(c.Mode, payload)
+}
+
+// HeuristicClassifier - классификация по поведению потока
+type HeuristicClassifier struct {
+	Mode PriorityMode
+}
+
+// Classify реализует Classifier
+func (c *HeuristicClassifier) Classify(flow *FlowInfo, payload []byte) PriorityLevel {
+	if c.Mode == PriorityMode_NONE {
+		return PriorityMedium
+	}
+
+	// 1. Голос закреплён в High независимо от размера пакета
+	if flow.Voice {
+		return PriorityHigh
+	}
+
+	// 2. Порт назначения: DNS и игровые серверы
+	if flow.Destination.IsValid() {
+		switch {
+		case flow.Destination.Port == 53, flow.Destination.Port == 853:
+			return PriorityHigh
+		case flow.Destination.Network == xnet.Network_UDP && isGamePort(flow.Destination.Port):
+			return PriorityHigh
+		}
+	}
+
+	// 3. Поведение потока (после прогрева)
+	if flow.Packets >= flowWarmupPackets {
+		rate := flow.Rate()
+
+		// Поток больших частых пакетов - загрузка: даже маленький
+		// хвостовой сегмент не должен попасть в High
+		if flow.AvgSize > MediumPriorityMaxSize && rate >= bulkMinRate {
+			if c.Mode == PriorityMode_STREAMING {
+				return PriorityMedium
+			}
+			return PriorityLow
+		}
+
+		// Маленькие пакеты с умеренной частотой - интерактив
+		if flow.AvgSize <= HighPriorityMaxSize && rate <= interactiveMaxRate {
+			return PriorityHigh
+		}
+	}
+
+	// 4. Нет поведенческих признаков - по размеру
+	return ClassifyPacket(c.Mode, payload)
+}
+
+// classifierHolder - обёртка для хранения Classifier в atomic.Value
+// (atomic.Value требует одинаковый конкретный тип при каждом Store)
+type classifierHolder struct {
+	Classifier
+}
+
+// flowTracker накапливает характеристики потока
+type flowTracker struct {
+	info     FlowInfo
+	lastSeen time.Time
+
+	mu sync.Mutex
+}
+
+// newFlowTracker создаёт трекер для потока с известной целью
+func newFlowTracker(dest xnet.Destination) *flowTracker {
+	return &flowTracker{
+		info: FlowInfo{Destination: dest},
+	}
+}
+
+// observe учитывает пакет размером size и возвращает снимок FlowInfo
+func (ft *flowTracker) observe(size int, now time.Time) FlowInfo {
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+
+	// Долгая пауза - это уже другой характер трафика
+	if !ft.lastSeen.IsZero() && now.Sub(ft.lastSeen) > flowIdleReset {
+		ft.info = FlowInfo{Destination: ft.info.Destination}
+	}
+
+	if ft.info.Packets == 0 {
+		ft.info.AvgSize = float64(size)
+	} else {
+		ft.info.AvgSize += flowEWMAWeight * (float64(size) - ft.info.AvgSize)
+
+		interval := now.Sub(ft.lastSeen)
+		if ft.info.Packets == 1 {
+			ft.info.AvgInterval = interval
+		} else {
+			deviation := interval - ft.info.AvgInterval
+			if deviation < 0 {
+				deviation = -deviation
+			}
+			ft.info.IntervalJitter += time.Duration(flowEWMAWeight * float64(deviation-ft.info.IntervalJitter))
+			ft.info.AvgInterval += time.Duration(flowEWMAWeight * float64(interval-ft.info.AvgInterval))
+		}
+	}
+
+	ft.info.Packets++
+	ft.lastSeen = now
+	ft.info.Voice = detectVoice(&ft.info)
+
+	return ft.info
+}
+
+// detectVoice обновляет признак голосового потока с гистерезисом
+func detectVoice(f *FlowInfo) bool {
+	if f.Packets < flowWarmupPackets {
+		return false
+	}
+
+	rate := f.Rate()
+	if f.Voice {
+		return rate >= voiceExitMinRate && rate <= voiceExitMaxRate &&
+			f.AvgSize <= voiceExitMaxSize &&
+			f.IntervalJitter <= f.AvgInterval/2
+	}
+
+	return rate >= voiceMinRate && rate <= voiceMaxRate &&
+		f.AvgSize <= voiceMaxSize &&
+		f.IntervalJitter <= f.AvgInterval/4
+}
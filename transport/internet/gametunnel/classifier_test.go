@@ -0,0 +1,223 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// feedFlow прогоняет через трекер count пакетов размером size с интервалом interval
+func feedFlow(ft *flowTracker, count, size int, interval time.Duration) FlowInfo {
+	now := time.Now()
+	var info FlowInfo
+	for i := 0; i < count; i++ {
+		info = ft.observe(size, now)
+		now = now.Add(interval)
+	}
+	return info
+}
+
+func TestFlowTrackerObserve(t *testing.T) {
+	ft := newFlowTracker(xnet.Destination{})
+
+	// 60 Гц, 100 байт - типичный игровой тик
+	info := feedFlow(ft, 32, 100, 16*time.Millisecond)
+
+	if info.Packets != 32 {
+		t.Errorf("Packets: got %d, want 32", info.Packets)
+	}
+	if info.AvgSize != 100 {
+		t.Errorf("AvgSize: got %.1f, want 100", info.AvgSize)
+	}
+	if info.AvgInterval != 16*time.Millisecond {
+		t.Errorf("AvgInterval: got %v, want 16ms", info.AvgInterval)
+	}
+	if info.IntervalJitter != 0 {
+		t.Errorf("IntervalJitter: got %v, want 0 for a regular flow", info.IntervalJitter)
+	}
+}
+
+func TestFlowTrackerIdleReset(t *testing.T) {
+	ft := newFlowTracker(xnet.Destination{})
+	now := time.Now()
+
+	ft.observe(1400, now)
+	ft.observe(1400, now.Add(time.Millisecond))
+
+	info := ft.observe(100, now.Add(flowIdleReset+time.Second))
+	if info.Packets != 1 || info.AvgSize != 100 {
+		t.Errorf("Flow should restart after idle: got packets=%d avgSize=%.1f",
+			info.Packets, info.AvgSize)
+	}
+}
+
+func TestHeuristicClassifierBulkFlow(t *testing.T) {
+	c := &HeuristicClassifier{Mode: PriorityMode_GAMING}
+	ft := newFlowTracker(xnet.Destination{})
+
+	// Загрузка: 1400 байт, 1000 pps
+	info := feedFlow(ft, 64, 1400, time.Millisecond)
+
+	// Маленький хвостовой сегмент не должен попасть в High
+	if got := c.Classify(&info, make([]byte, 60)); got != PriorityLow {
+		t.Errorf("Small tail of bulk flow: got %d, want Low", got)
+	}
+
+	// Size-классификатор ошибается именно здесь
+	size := &SizeClassifier{Mode: PriorityMode_GAMING}
+	if got := size.Classify(&info, make([]byte, 60)); got != PriorityHigh {
+		t.Errorf("SizeClassifier: got %d, want High", got)
+	}
+
+	c.Mode = PriorityMode_STREAMING
+	if got := c.Classify(&info, make([]byte, 1400)); got != PriorityMedium {
+		t.Errorf("Bulk in streaming mode: got %d, want Medium", got)
+	}
+}
+
+func TestHeuristicClassifierInteractiveFlow(t *testing.T) {
+	c := &HeuristicClassifier{Mode: PriorityMode_GAMING}
+	ft := newFlowTracker(xnet.Destination{})
+
+	// Игра: ~120 байт, 64 Гц; отдельный крупный снапшот остаётся High
+	info := feedFlow(ft, 64, 120, 15625*time.Microsecond)
+
+	if got := c.Classify(&info, make([]byte, 900)); got != PriorityHigh {
+		t.Errorf("Snapshot in interactive flow: got %d, want High", got)
+	}
+}
+
+func TestHeuristicClassifierDestination(t *testing.T) {
+	c := &HeuristicClassifier{Mode: PriorityMode_GAMING}
+	large := make([]byte, 1400)
+
+	tests := []struct {
+		name string
+		dest xnet.Destination
+		want PriorityLevel
+	}{
+		{"dns", xnet.UDPDestination(xnet.LocalHostIP, 53), PriorityHigh},
+		{"dot", xnet.TCPDestination(xnet.LocalHostIP, 853), PriorityHigh},
+		{"steam", xnet.UDPDestination(xnet.LocalHostIP, 27015), PriorityHigh},
+		{"steam over tcp", xnet.TCPDestination(xnet.LocalHostIP, 27015), PriorityLow},
+		{"https", xnet.TCPDestination(xnet.LocalHostIP, 443), PriorityLow},
+	}
+
+	for _, tt := range tests {
+		info := FlowInfo{Destination: tt.dest}
+		if got := c.Classify(&info, large); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHeuristicClassifierWarmup(t *testing.T) {
+	c := &HeuristicClassifier{Mode: PriorityMode_GAMING}
+	ft := newFlowTracker(xnet.Destination{})
+
+	// До прогрева - классификация по размеру
+	info := feedFlow(ft, flowWarmupPackets-1, 1400, time.Millisecond)
+	if got := c.Classify(&info, make([]byte, 60)); got != PriorityHigh {
+		t.Errorf("Before warmup: got %d, want High (size-based)", got)
+	}
+}
+
+func TestNewClassifier(t *testing.T) {
+	config := DefaultConfig()
+	if _, ok := NewClassifier(config).(*SizeClassifier); !ok {
+		t.Error("Default classifier should be SizeClassifier")
+	}
+
+	config.Classifier = ClassifierTypeFromString("heuristic")
+	if _, ok := NewClassifier(config).(*HeuristicClassifier); !ok {
+		t.Error("'heuristic' should create HeuristicClassifier")
+	}
+
+	if ClassifierTypeFromString("") != ClassifierType_SIZE {
+		t.Error("Default classifier type should be SIZE")
+	}
+}
+
+// fixedClassifier - классификатор с постоянным ответом для тестов
+type fixedClassifier PriorityLevel
+
+func (c fixedClassifier) Classify(flow *FlowInfo, payload []byte) PriorityLevel {
+	return PriorityLevel(c)
+}
+
+func TestHubSetClassifier(t *testing.T) {
+	config := DefaultConfig()
+	h := NewHub(config, nil)
+
+	session := &Session{
+		Streams: make(map[uint16]*Stream),
+		flow:    newFlowTracker(xnet.Destination{}),
+	}
+
+	h.SetClassifier(fixedClassifier(PriorityLow))
+	if got := h.classify(session, make([]byte, 10)); got != PriorityLow {
+		t.Errorf("Custom classifier: got %d, want Low", got)
+	}
+
+	// Закреплённый приоритет потока важнее классификатора
+	if err := setStreamPriority(session.Streams, 0, PriorityHigh, config.MaxStreams); err != nil {
+		t.Fatal(err)
+	}
+	if got := h.classify(session, make([]byte, 10)); got != PriorityHigh {
+		t.Errorf("Pinned stream: got %d, want High", got)
+	}
+}
+
+func TestVoiceFlowDetection(t *testing.T) {
+	c := &HeuristicClassifier{Mode: PriorityMode_GAMING}
+	ft := newFlowTracker(xnet.Destination{})
+	now := time.Now()
+
+	// Opus: 80 байт каждые 20ms (50 pps)
+	var info FlowInfo
+	for i := 0; i < 32; i++ {
+		info = ft.observe(80, now)
+		now = now.Add(20 * time.Millisecond)
+	}
+	if !info.Voice {
+		t.Fatalf("Regular 50 pps flow of small packets should be voice: %+v", info)
+	}
+
+	// Отдельный крупный пакет не снимает закрепление
+	info = ft.observe(1200, now.Add(-10*time.Millisecond))
+	if !info.Voice {
+		t.Errorf("Occasional large packet must not unpin voice: %+v", info)
+	}
+	if got := c.Classify(&info, make([]byte, 1200)); got != PriorityHigh {
+		t.Errorf("Large packet of voice flow: got %d, want High", got)
+	}
+
+	// Поток перерос в загрузку - признак снимается
+	for i := 0; i < 64; i++ {
+		info = ft.observe(1400, now)
+		now = now.Add(time.Millisecond)
+	}
+	if info.Voice {
+		t.Errorf("Bulk flow should lose voice flag: %+v", info)
+	}
+}
+
+func TestVoiceFlowRequiresRegularity(t *testing.T) {
+	ft := newFlowTracker(xnet.Destination{})
+	now := time.Now()
+
+	// Те же 40 pps в среднем, но интервалы 5ms/45ms - не голос
+	var info FlowInfo
+	for i := 0; i < 32; i++ {
+		info = ft.observe(80, now)
+		if i%2 == 0 {
+			now = now.Add(5 * time.Millisecond)
+		} else {
+			now = now.Add(45 * time.Millisecond)
+		}
+	}
+	if info.Voice {
+		t.Errorf("Irregular flow should not be voice: %+v", info)
+	}
+}
@@ -0,0 +1,242 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Сокеты по классам приоритета
+// ====================================================================
+//
+// Приоритеты PriorityQueue кончаются на сокете: дальше игровой пакет
+// стоит в одной очереди ядра с загрузкой, которая забила SO_SNDBUF, а
+// роутеры с очередями по 5-tuple (fq_codel, cake) видят один поток.
+// С classSockets = true клиент шлёт High и служебные пакеты через
+// сокет хэндшейка, а Medium и Low - через второй, bulk-сокет к тому же
+// серверу. У каждого сокета свой буфер отправки: highWriteBufferSize
+// (0 = classHighWriteBuffer) и bulkWriteBufferSize (0 =
+// writeBufferSize). С enableDscp каждый сокет несёт маркировку своих
+// классов (dscp.go), и сокет хэндшейка больше не переключает DSCP.
+//
+// Сервер связывает сокеты по Connection ID. bulk-сокет регистрируется
+// PATH_ADD (multipath.go) с пометкой класса:
+//
+//	клиент -> сервер: CONTROL [0x09][AEAD(token | 0x01)]
+//	сервер -> клиент: CONTROL [0x08][AEAD(то же тело)]
+//
+// и становится bulk-путём сессии: Medium и Low сервер шлёт на него,
+// High - на адрес сокета хэндшейка, без копий. Пакеты с bulk-адреса
+// сессию не переносят. PATH_ADD повторяется раз в classSocketRefresh:
+// он держит маппинг NAT сокета, а путь на сервере - живым
+// (multipathPathIdle). Старый сервер пометки не знает и регистрирует
+// сокет как путь multipath: по нему приходят копии High, клиент
+// читает оба сокета.
+//
+// До ответа сервера и после переподключения всё идёт через сокет
+// хэндшейка. После смены сети bulk-пакеты с нового адреса до
+// следующего PATH_ADD сервер принимает за миграцию сессии. С
+// multipath не совмещается.
+//
+// ====================================================================
+
+const (
+	// classHighWriteBuffer - SO_SNDBUF сокета хэндшейка по умолчанию:
+	// игровой пакет не ждёт за мегабайтами в ядре
+	classHighWriteBuffer = 64 * 1024
+
+	// classSocketRefresh - период PATH_ADD bulk-сокета
+	classSocketRefresh = time.Second
+
+	// pathKindBulk - пометка bulk-пути в теле PATH_ADD
+	pathKindBulk = 0x01
+)
+
+// classSockets - bulk-сокет клиента
+type classSockets struct {
+	// bulk - сокет Medium и Low (nil, пока не открыт)
+	// joined - сервер подтвердил bulk-путь текущей сессии
+	bulk   atomic.Pointer[dscpMarker]
+	joined atomic.Bool
+
+	// sent - пакетов отправлено через bulk-сокет (atomic)
+	sent uint64
+
+	// token - токен последнего PATH_ADD
+	mu    sync.Mutex
+	token [pathTokenSize]byte
+}
+
+// newClassSockets создаёт состояние сокетов по классам (nil - выключены)
+func newClassSockets(config *Config) *classSockets {
+	if !config.ClassSockets {
+		return nil
+	}
+	return &classSockets{}
+}
+
+// highWriteBuffer - SO_SNDBUF сокета хэндшейка с classSockets
+func highWriteBuffer(config *Config) int {
+	if config.HighWriteBufferSize > 0 {
+		return int(config.HighWriteBufferSize)
+	}
+	return classHighWriteBuffer
+}
+
+// bulkWriteBuffer - SO_SNDBUF bulk-сокета
+func bulkWriteBuffer(config *Config) int {
+	if config.BulkWriteBufferSize > 0 {
+		return int(config.BulkWriteBufferSize)
+	}
+	if config.WriteBufferSize > 0 {
+		return int(config.WriteBufferSize)
+	}
+	return socketBufferSize
+}
+
+// bulkPath - тело PATH_ADD относится к bulk-пути
+func bulkPath(body []byte) bool {
+	return len(body) == pathTokenSize+1 && body[pathTokenSize] == pathKindBulk
+}
+
+// answered засчитывает PATH_RESPONSE на последний PATH_ADD bulk-сокета
+func (cs *classSockets) answered(body []byte) bool {
+	if !bulkPath(body) {
+		return false
+	}
+	cs.mu.Lock()
+	matched := subtle.ConstantTimeCompare(body[:pathTokenSize], cs.token[:]) == 1
+	cs.mu.Unlock()
+	if matched {
+		cs.joined.Store(true)
+	}
+	return matched
+}
+
+// reset - новая сессия (reconnect.go) должна заново
+// зарегистрировать bulk-сокет
+func (cs *classSockets) reset() {
+	cs.joined.Store(false)
+}
+
+// close закрывает bulk-сокет
+func (cs *classSockets) close() {
+	cs.joined.Store(false)
+	if sock := cs.bulk.Swap(nil); sock != nil {
+		sock.conn.Close()
+	}
+}
+
+// writeBulk отправляет датаграмму через bulk-сокет; false - сокет не
+// открыт, не подтверждён сервером или отказал
+func (c *GameTunnelClientConn) writeBulk(b []byte, level PriorityLevel) (int, bool) {
+	cs := c.classes
+	sock := cs.bulk.Load()
+	if sock == nil || !cs.joined.Load() {
+		return 0, false
+	}
+	n, err := sock.Write(b, level)
+	if err != nil {
+		return 0, false
+	}
+	atomic.AddUint64(&cs.sent, 1)
+	return n, true
+}
+
+// classSocketLoop принимает пакеты bulk-сокета и регистрирует его
+func (c *GameTunnelClientConn) classSocketLoop() {
+	cs := c.classes
+	buf := make([]byte, MaxPacketSize)
+	var refreshedAt time.Time
+
+	for c.ctx.Err() == nil {
+		if now := time.Now(); now.Sub(refreshedAt) >= classSocketRefresh {
+			refreshedAt = now
+			c.registerBulk()
+		}
+
+		sock := cs.bulk.Load()
+		if sock == nil {
+			sleepContext(c.ctx, classSocketRefresh)
+			continue
+		}
+
+		sock.conn.SetReadDeadline(time.Now().Add(classSocketRefresh))
+		n, err := sock.conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				// Сокет умер - откроем новый при следующем PATH_ADD
+				if cs.bulk.CompareAndSwap(sock, nil) {
+					cs.joined.Store(false)
+					sock.conn.Close()
+				}
+			}
+			continue
+		}
+
+		sock.captured(false, nil, buf[:n])
+		c.handlePacket(buf[:n])
+	}
+}
+
+// registerBulk открывает bulk-сокет к текущему адресу сервера и шлёт
+// по нему PATH_ADD
+func (c *GameTunnelClientConn) registerBulk() {
+	cs := c.classes
+	serverAddr := c.session().serverAddr
+
+	sock := cs.bulk.Load()
+	// Переподключение к другому адресу сервера - сокет к прежнему не годится
+	if sock != nil && sock.conn.RemoteAddr().String() != serverAddr.String() {
+		if cs.bulk.CompareAndSwap(sock, nil) {
+			cs.joined.Store(false)
+			sock.conn.Close()
+		}
+		sock = nil
+	}
+	if sock == nil {
+		conn, err := dialPathSocket(c.ctx, nil, serverAddr, c.config, c.sockopt)
+		if err != nil {
+			return
+		}
+		if udp, ok := conn.(*net.UDPConn); ok {
+			udp.SetWriteBuffer(bulkWriteBuffer(c.config))
+		}
+		sock = newDSCPMarker(conn, c.config)
+		c.pathMu.Lock()
+		if atomic.LoadInt32(&c.closed) == 1 {
+			c.pathMu.Unlock()
+			conn.Close()
+			return
+		}
+		cs.bulk.Store(sock)
+		c.pathMu.Unlock()
+	}
+
+	var token [pathTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return
+	}
+	cs.mu.Lock()
+	cs.token = token
+	cs.mu.Unlock()
+
+	wrapped, err := c.sealControl(0x09, append(token[:], pathKindBulk))
+	if err != nil {
+		return
+	}
+	sock.Write(wrapped, PriorityLow)
+}
+
+// GetBulkPackets возвращает число пакетов, отправленных через
+// bulk-сокет (0 без classSockets)
+func (c *GameTunnelClientConn) GetBulkPackets() uint64 {
+	if c.classes == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.classes.sent)
+}
@@ -0,0 +1,78 @@
+package gametunnel
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClassSockets(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "class-sockets"
+	config.ClassSockets = true
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.classes.joined.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("bulk socket not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	bulkAddr := client.classes.bulk.Load().conn.LocalAddr().String()
+
+	// Сервер шлёт Medium и Low на bulk-сокет, High - на сокет хэндшейка
+	session := l.hub.GetSession(client.session().ConnectionID)
+	if _, addr := session.route(nil, PriorityLow); addr.String() != bulkAddr {
+		t.Errorf("Low routed to %s, want bulk socket %s", addr, bulkAddr)
+	}
+	if _, addr := session.route(nil, PriorityHigh); addr.String() != client.LocalAddr().String() {
+		t.Errorf("High routed to %s, want %s", addr, client.LocalAddr())
+	}
+
+	// Пакеты bulk-сокета сессию не переносят
+	client.SetClassifier(fixedClassifier(PriorityLow))
+	if _, err := client.Write([]byte("bulk")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, server, 2048); string(got) != "bulk" {
+		t.Errorf("server got %q", got)
+	}
+	if client.GetBulkPackets() == 0 {
+		t.Error("Low packet not sent through the bulk socket")
+	}
+	session.mu.RLock()
+	remote := session.RemoteAddr.String()
+	session.mu.RUnlock()
+	if remote != client.LocalAddr().String() {
+		t.Errorf("session moved to %s", remote)
+	}
+
+	if _, err := server.Write([]byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, client, 2048); string(got) != "reply" {
+		t.Errorf("client got %q", got)
+	}
+}
+
+func TestClassSocketsConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.ClassSockets = true
+	config.Multipath = true
+	if err := config.Validate(); err == nil || !strings.Contains(err.Error(), "classSockets") {
+		t.Errorf("classSockets with multipath: %v", err)
+	}
+
+	if got := bulkWriteBuffer(DefaultConfig()); got != socketBufferSize {
+		t.Errorf("bulk buffer %d, want %d", got, socketBufferSize)
+	}
+	config = DefaultConfig()
+	config.ClassSockets = true
+	if opts := dialSocketOptions(config, nil); opts.writeBuffer != classHighWriteBuffer {
+		t.Errorf("handshake socket buffer %d, want %d", opts.writeBuffer, classHighWriteBuffer)
+	}
+}
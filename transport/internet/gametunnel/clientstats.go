@@ -0,0 +1,181 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Статистика клиентского соединения
+// ====================================================================
+//
+// GetStats собирает состояние туннеля одним снимком - для GUI-клиентов
+// (форки v2rayN/NekoBox), которые показывают игроку здоровье канала:
+// RTT, потери, трафик, режим обфускации, число переподключений.
+//
+// RTT и потери считаются по keep-alive: ответ сервера даёт замер RTT,
+// keep-alive без ответа - потерю. Оба значения - экспоненциальное
+// скользящее среднее с весом 1/8 (как srtt в TCP), поэтому один
+// потерянный пакет не пугает игрока скачком до 100%. Пока данные идут
+// в обе стороны, вместо keep-alive RTT меряет Ping; джиттер и оценка
+// качества 0-100 - в Quality (quality.go).
+//
+// Сессионные ключи выводятся только в рукопожатии, отдельного rekey
+// нет. Поколение ключей (KeyEpoch) меняется вместе с сессией: 0 -
+// ключи первого рукопожатия, +1 на каждое переподключение.
+//
+// WriteClientStats выводит снимки всех живых соединений процесса в
+// JSON; тот же дамп отдаёт /clients на сервере метрик (metrics.go).
+//
+// ProbedMTU - MTU пути, измеренный с mtuProbe (mtu.go).
+// Coalesced - записи, склеенные с coalesceWindow (coalesce.go).
+//
+// ====================================================================
+
+// statsEWMAShift - вес нового замера в скользящих средних (1/8)
+const statsEWMAShift = 3
+
+// lossScale - фиксированная точка оценки потерь (1.0 = lossScale)
+const lossScale = 1 << 16
+
+// clientTraffic - счётчики трафика одного соединения
+type clientTraffic struct {
+	bytesSent   uint64
+	bytesRecv   uint64
+	packetsSent uint64
+	packetsRecv uint64
+
+	// Замеры RTT, джиттера и потерь (quality.go)
+	linkQuality
+
+	// drops - отброшенные пакеты по причинам (drops.go)
+	drops dropCounters
+}
+
+// ClientStats - снимок состояния клиентского соединения
+type ClientStats struct {
+	RemoteAddr    string        `json:"remoteAddr"`
+	LocalAddr     string        `json:"localAddr"`
+	Health        SessionHealth `json:"health"`
+	RTT           time.Duration `json:"rtt"`
+	Loss          float64       `json:"loss"`
+	BytesSent     uint64        `json:"bytesSent"`
+	BytesRecv     uint64        `json:"bytesRecv"`
+	PacketsSent   uint64        `json:"packetsSent"`
+	PacketsRecv   uint64        `json:"packetsRecv"`
+	Obfuscation   string        `json:"obfuscation"`
+	KeyEpoch      uint64        `json:"keyEpoch"`
+	Reconnects    uint64        `json:"reconnects"`
+	Rebinds       uint64        `json:"rebinds"`
+	SharedStreams int           `json:"sharedStreams,omitempty"`
+	Paths         []PathStats   `json:"paths,omitempty"`
+	BulkPackets   uint64        `json:"bulkPackets,omitempty"`
+	ProbedMTU     int           `json:"probedMTU,omitempty"`
+	Coalesced     uint64        `json:"coalescedWrites,omitempty"`
+	InboundDrops  uint64        `json:"inboundDrops,omitempty"`
+	InboundPeak   int           `json:"inboundHighWater,omitempty"`
+
+	// Drops - отброшенные пакеты по причинам (drops.go)
+	Drops map[string]uint64 `json:"drops,omitempty"`
+
+	// Quality - RTT, джиттер, потери и оценка канала 0-100
+	// (quality.go); nil - замеров ещё не было
+	Quality *LinkQuality `json:"quality,omitempty"`
+
+	// Padding - адаптивный padding (padding.go); nil - paddingBackoff
+	// не задан
+	Padding *PaddingStats `json:"padding,omitempty"`
+
+	// KeepAliveTune - подбор интервала keep-alive (nattune.go); nil -
+	// keepAliveTune выключен
+	KeepAliveTune *KeepAliveTuneStats `json:"keepAliveTune,omitempty"`
+}
+
+// countSent / countRecv учитывают пакет данных соединения
+func (t *clientTraffic) countSent(n int) {
+	atomic.AddUint64(&t.packetsSent, 1)
+	atomic.AddUint64(&t.bytesSent, uint64(n))
+}
+
+func (t *clientTraffic) countRecv(n int) {
+	atomic.AddUint64(&t.packetsRecv, 1)
+	atomic.AddUint64(&t.bytesRecv, uint64(n))
+}
+
+// GetStats возвращает снимок состояния соединения
+func (c *GameTunnelClientConn) GetStats() ClientStats {
+	reconnects := c.GetReconnects()
+	stats := ClientStats{
+		RemoteAddr:  c.RemoteAddr().String(),
+		LocalAddr:   c.LocalAddr().String(),
+		Health:      c.Health(),
+		RTT:         time.Duration(atomic.LoadInt64(&c.traffic.srtt)),
+		Loss:        c.traffic.lossRatio(),
+		BytesSent:   atomic.LoadUint64(&c.traffic.bytesSent),
+		BytesRecv:   atomic.LoadUint64(&c.traffic.bytesRecv),
+		PacketsSent: atomic.LoadUint64(&c.traffic.packetsSent),
+		PacketsRecv: atomic.LoadUint64(&c.traffic.packetsRecv),
+		Obfuscation: c.obfs.Name(),
+		KeyEpoch:    reconnects,
+		Reconnects:  reconnects,
+		Rebinds:     c.GetRebinds(),
+		Paths:       c.GetPathStats(),
+		BulkPackets: c.GetBulkPackets(),
+		ProbedMTU:   c.GetProbedMTU(),
+		Coalesced:   c.GetCoalescedWrites(),
+		Drops:       c.traffic.drops.snapshot(),
+		Quality:     c.traffic.snapshot(c.session().timestamps),
+	}
+	inbound := c.session().inbound.stats()
+	stats.InboundDrops, stats.InboundPeak = inbound.Dropped, inbound.HighWater
+	if c.mux != nil {
+		stats.SharedStreams, _ = c.GetSharedStreams()
+	}
+	if c.config.PaddingBackoff != PaddingBackoff_NONE {
+		padding := c.padding.stats(c.config)
+		stats.Padding = &padding
+	}
+	stats.KeepAliveTune = c.GetKeepAliveTune()
+	return stats
+}
+
+// clientStatsSnapshot снимает статистику всех живых клиентских
+// соединений процесса, по адресу сервера и локальному адресу
+func clientStatsSnapshot() []ClientStats {
+	metrics.mu.Lock()
+	clients := make([]*GameTunnelClientConn, 0, len(metrics.clients))
+	for c := range metrics.clients {
+		clients = append(clients, c)
+	}
+	metrics.mu.Unlock()
+
+	stats := make([]ClientStats, 0, len(clients))
+	for _, c := range clients {
+		stats = append(stats, c.GetStats())
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].RemoteAddr != stats[j].RemoteAddr {
+			return stats[i].RemoteAddr < stats[j].RemoteAddr
+		}
+		return stats[i].LocalAddr < stats[j].LocalAddr
+	})
+	return stats
+}
+
+// WriteClientStats выводит статистику всех клиентских соединений в JSON
+func WriteClientStats(w io.Writer) error {
+	return json.NewEncoder(w).Encode(clientStatsSnapshot())
+}
+
+// ClientStatsHandler возвращает http.Handler, отдающий статистику
+// клиентских соединений в JSON
+func ClientStatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		WriteClientStats(w)
+	})
+}
@@ -0,0 +1,106 @@
+package gametunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientStats(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "client-stats"
+	config.Obfuscation = ObfuscationMode_WEBRTC_MIMIC
+	config.KeepAliveInterval = 1
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		client.Write([]byte("ping"))
+		readWithTimeout(t, server, 4)
+	}
+	server.Write([]byte("pong!"))
+	readWithTimeout(t, client, 5)
+
+	// Простой - keep-alive с ответом даёт замер RTT
+	deadline := time.Now().Add(5 * time.Second)
+	for client.GetStats().RTT == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	stats := client.GetStats()
+	if stats.PacketsSent != 3 || stats.BytesSent != 12 {
+		t.Errorf("sent %d packets / %d bytes, want 3 / 12", stats.PacketsSent, stats.BytesSent)
+	}
+	if stats.PacketsRecv != 1 || stats.BytesRecv != 5 {
+		t.Errorf("recv %d packets / %d bytes, want 1 / 5", stats.PacketsRecv, stats.BytesRecv)
+	}
+	if stats.RTT <= 0 || stats.RTT > time.Second {
+		t.Errorf("rtt = %v", stats.RTT)
+	}
+	if stats.Loss != 0 {
+		t.Errorf("loss = %v on loopback", stats.Loss)
+	}
+	if stats.Obfuscation != "webrtc-mimic" {
+		t.Errorf("obfuscation = %q", stats.Obfuscation)
+	}
+	if stats.Health != SessionHealth_HEALTHY || stats.KeyEpoch != 0 || stats.Reconnects != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// JSON-дамп содержит это соединение
+	rec := httptest.NewRecorder()
+	ClientStatsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/clients", nil))
+	var dumped []ClientStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &dumped); err != nil {
+		t.Fatalf("decode dump: %v\n%s", err, rec.Body.String())
+	}
+	found := false
+	for _, s := range dumped {
+		if s.LocalAddr == stats.LocalAddr {
+			found = true
+			if s.BytesSent != stats.BytesSent || s.Obfuscation != stats.Obfuscation {
+				t.Errorf("dumped %+v, want %+v", s, stats)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("connection %s missing from dump", stats.LocalAddr)
+	}
+	if !bytes.Contains(rec.Body.Bytes(), []byte(`"health":"healthy"`)) {
+		t.Errorf("health not encoded by name: %s", rec.Body.String())
+	}
+}
+
+func TestClientTrafficLossEstimate(t *testing.T) {
+	var traffic clientTraffic
+
+	// Каждый второй keep-alive без ответа - оценка сходится к 50%
+	for i := 0; i < 200; i++ {
+		if i%2 == 0 {
+			traffic.observeLoss(lossScale)
+		} else {
+			traffic.observeReply(20 * time.Millisecond)
+		}
+	}
+	if loss := traffic.lossRatio(); loss < 0.4 || loss > 0.6 {
+		t.Errorf("loss = %v, want ~0.5", loss)
+	}
+
+	// Одна потеря после ровной работы - не скачок до 100%
+	traffic = clientTraffic{}
+	for i := 0; i < 50; i++ {
+		traffic.observeReply(20 * time.Millisecond)
+	}
+	traffic.observeLoss(lossScale)
+	if loss := traffic.lossRatio(); loss > 0.2 {
+		t.Errorf("single loss gave %v", loss)
+	}
+	if rtt := time.Duration(traffic.srtt); rtt != 20*time.Millisecond {
+		t.Errorf("srtt = %v", rtt)
+	}
+}
@@ -0,0 +1,614 @@
+package gametunnel
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Кластер: сессия переезжает на другой сервер
+// ====================================================================
+//
+// За anycast или UDP-балансировщиком пакеты клиента после смены
+// маршрута приходят на другой сервер, который не знает Connection ID
+// и отбрасывает их ("unknown connection ID") - клиент ждёт таймаута
+// и переподключается. В режиме кластера хабы публикуют состояние
+// сессий (ключи, счётчики, адрес) в общее хранилище по Connection ID,
+// а сервер, получивший пакет чужой сессии, подхватывает её.
+//
+// Хранилище - ClusterStore: встроенный gossip по UDP между серверами
+// (clusterListen/clusterPeers) или внешнее (например Redis) через
+// Hub.SetClusterStore. Записи зашифрованы ключом из PSK, как и
+// снапшоты (snapshot.go): без key кластер не работает.
+//
+// Подхват: запись берётся по Connection ID, пакет должен
+// расшифроваться её ключами и иметь номер больше сохранённого.
+// Keep-alive не аутентифицирован и сессию не переносит - переносит
+// первый DATA или CONTROL. Новый владелец публикует запись со
+// следующим поколением (epoch); прежний, увидев её, молча закрывает
+// свою копию.
+//
+// Повтор nonce: прежний владелец мог отправить ещё сколько-то пакетов
+// после публикации. Счётчик отправки подхваченной сессии сдвигается
+// на clusterCounterGap*(clusterNode+1): разные номера узлов дают
+// непересекающиеся диапазоны, а публикация повторяется, как только
+// сессия отправила четверть сдвига.
+//
+// Ограничения: пакет, перехваченный после последней публикации,
+// может один раз увести сессию повтором (окно - clusterInterval);
+// сжатые заголовки и общие сессии (mux) не переносятся; протокол
+// поверх (VLESS и т.п.) видит новое соединение.
+//
+// ====================================================================
+
+const (
+	// clusterMagic - заголовок записи сессии (формат версии 1)
+	clusterMagic = "GTC1"
+
+	// clusterHKDFInfo - контекст HKDF для ключа записей
+	clusterHKDFInfo = "gametunnel cluster v1"
+
+	// clusterCounterGap - сдвиг счётчика отправки на номер узла
+	clusterCounterGap = 1 << 18
+
+	// maxClusterNode - наибольший номер узла: сдвиги всех узлов
+	// укладываются в 1<<24 (snapshotCounterGap)
+	maxClusterNode = 63
+
+	// defaultClusterInterval - период публикации по умолчанию
+	defaultClusterInterval = 5 * time.Second
+
+	// clusterLookupRate - поисков чужих сессий в секунду: пакеты
+	// с выдуманными ID не должны заваливать хранилище запросами
+	clusterLookupRate = 200
+
+	// gossipMagic / gossipHKDFInfo - сообщение gossip и его ключ
+	gossipMagic    = "GTG1"
+	gossipHKDFInfo = "gametunnel cluster gossip v1"
+
+	// gossipEntryTTL - срок записи gossip без обновлений
+	gossipEntryTTL = 15 * time.Minute
+
+	// gossipMaxSkew - допустимый возраст сообщения gossip: старые
+	// отбрасываются как повтор (часы серверов синхронизированы)
+	gossipMaxSkew = 30 * time.Second
+
+	// maxGossipMessage - наибольшее сообщение gossip
+	maxGossipMessage = 8192
+)
+
+// ClusterStore - общее хранилище сессий серверов кластера
+// Значения непрозрачны: хаб сам шифрует записи
+type ClusterStore interface {
+	// Get возвращает запись сессии или nil, если её нет
+	Get(id []byte) ([]byte, error)
+
+	// Put заменяет запись сессии
+	Put(id, data []byte) error
+
+	// Delete удаляет запись сессии
+	Delete(id []byte) error
+}
+
+// ClusterWatcher - хранилище, сообщающее о записях других серверов
+// Без него прежний владелец отпускает сессию при следующей публикации
+type ClusterWatcher interface {
+	// Watch регистрирует fn для каждой новой записи
+	Watch(fn func(id, data []byte))
+}
+
+// ClusterStats - статистика кластера хаба
+type ClusterStats struct {
+	Node     uint32 `json:"node"`
+	Adopted  uint64 `json:"adopted"`
+	Released uint64 `json:"released"`
+}
+
+// clusterRecord - запись сессии в хранилище до шифрования
+type clusterRecord struct {
+	Owner   string               `json:"owner"`
+	Epoch   uint64               `json:"epoch"`
+	Session sessionSnapshotEntry `json:"session"`
+}
+
+// hubCluster - участие хаба в кластере
+type hubCluster struct {
+	store    ClusterStore
+	aead     cipher.AEAD
+	node     uint32
+	owner    string
+	interval time.Duration
+
+	// closer - встроенный gossip, закрывается вместе с хабом
+	closer io.Closer
+
+	// adoptMu - один подхват за раз: два пакета одной сессии не
+	// поднимают её дважды
+	adoptMu sync.Mutex
+
+	// lookupSecond / lookups - поиски в хранилище за текущую секунду
+	lookupSecond int64
+	lookups      int32
+}
+
+// newHubCluster создаёт участие в кластере по настройкам config
+func newHubCluster(config *Config, store ClusterStore) (*hubCluster, error) {
+	if config.Key == "" {
+		return nil, fmt.Errorf("cluster mode requires key")
+	}
+	aead, err := stateAEAD(config.Key, clusterHKDFInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := defaultClusterInterval
+	if config.ClusterInterval > 0 {
+		interval = time.Duration(config.ClusterInterval) * time.Second
+	}
+	return &hubCluster{
+		store: store,
+		aead:  aead,
+		node:  config.ClusterNode,
+		// Владелец - номер узла: после перезапуска сервер узнаёт
+		// свои записи
+		owner:    fmt.Sprintf("node%d", config.ClusterNode),
+		interval: interval,
+	}, nil
+}
+
+// SetClusterStore подключает общее хранилище сессий кластера
+// (например Redis) вместо gossip clusterListen. Вызывать до Start
+func (h *Hub) SetClusterStore(store ClusterStore) error {
+	cluster, err := newHubCluster(h.getConfig(), store)
+	if err != nil {
+		return err
+	}
+	h.cluster = cluster
+	if watcher, ok := store.(ClusterWatcher); ok {
+		watcher.Watch(h.clusterRecordSeen)
+	}
+	return nil
+}
+
+// startGossip подключает встроенный gossip clusterListen/clusterPeers
+func (h *Hub) startGossip(config *Config) error {
+	store, err := newGossipStore(config)
+	if err != nil {
+		return err
+	}
+	if err := h.SetClusterStore(store); err != nil {
+		store.Close()
+		return err
+	}
+	h.cluster.closer = store
+	return nil
+}
+
+// allowLookup - поиск в хранилище укладывается в clusterLookupRate
+func (c *hubCluster) allowLookup(now time.Time) bool {
+	second := now.Unix()
+	if atomic.SwapInt64(&c.lookupSecond, second) != second {
+		atomic.StoreInt32(&c.lookups, 0)
+	}
+	return atomic.AddInt32(&c.lookups, 1) <= clusterLookupRate
+}
+
+// open расшифровывает запись сессии id
+func (c *hubCluster) open(id, data []byte) (*clusterRecord, error) {
+	rec := &clusterRecord{}
+	if err := openState(c.aead, clusterMagic, "cluster record", data, rec); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(rec.Session.ID, id) {
+		return nil, fmt.Errorf("cluster record of another session")
+	}
+	return rec, nil
+}
+
+// get читает запись сессии id; nil - записи нет
+func (c *hubCluster) get(id []byte) (*clusterRecord, error) {
+	data, err := c.store.Get(id)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return c.open(id, data)
+}
+
+// publish записывает состояние сессии от имени этого сервера
+func (c *hubCluster) publish(session *Session) error {
+	data, err := sealState(c.aead, clusterMagic, &clusterRecord{
+		Owner:   c.owner,
+		Epoch:   atomic.LoadUint64(&session.clusterEpoch),
+		Session: snapshotEntry(session),
+	})
+	if err != nil {
+		return err
+	}
+	return c.store.Put(session.ID, data)
+}
+
+// movedAway - запись rec другого сервера новее копии session
+// Равные поколения - два сервера подхватили сессию одновременно:
+// уступают оба, следующий пакет клиента выберет одного
+func (c *hubCluster) movedAway(rec *clusterRecord, session *Session) bool {
+	return rec != nil && rec.Owner != c.owner &&
+		rec.Epoch >= atomic.LoadUint64(&session.clusterEpoch)
+}
+
+// forget удаляет запись закрытой сессии, если она ещё своя
+func (c *hubCluster) forget(session *Session) {
+	if c == nil || session.Keys == nil || session.streams != nil {
+		return
+	}
+	rec, err := c.get(session.ID)
+	if err != nil || rec == nil || rec.Owner != c.owner {
+		return
+	}
+	if err := c.store.Delete(session.ID); err != nil {
+		logf(log.Severity_Debug, "%s cluster record not deleted: %v", sessionTag(session.ID), err)
+	}
+}
+
+// adoptSession подхватывает сессию другого сервера кластера по её
+// пакету data; nil - сессии нет или пакет не её
+func (h *Hub) adoptSession(sock *dscpMarker, data []byte, pktType PacketType, connID []byte, remoteAddr *net.UDPAddr) *Session {
+	c := h.cluster
+	if c == nil || pktType == PacketType_KEEPALIVE || h.IsDraining() || !c.allowLookup(time.Now()) {
+		return nil
+	}
+	c.adoptMu.Lock()
+	defer c.adoptMu.Unlock()
+	if session := h.sessions.get(connID); session != nil {
+		return session
+	}
+
+	rec, err := c.get(connID)
+	if err != nil {
+		logf(log.Severity_Debug, "%s cluster lookup failed: %v", sessionTag(connID), err)
+		return nil
+	}
+	if rec == nil || rec.Owner == c.owner || !clusterPacketValid(&rec.Session, data, pktType, len(connID)) {
+		return nil
+	}
+
+	entry := rec.Session
+	entry.RemoteAddr = remoteAddr.String()
+	entry.LastActiveAt = time.Now()
+	session := h.restoreEntry(entry, clusterCounterGap*(c.node+1), 0, sock)
+	if session == nil {
+		return nil
+	}
+	atomic.StoreUint64(&session.clusterEpoch, rec.Epoch+1)
+	if err := c.publish(session); err != nil {
+		logf(log.Severity_Warning, "%s adopted session not published: %v", sessionTag(connID), err)
+	}
+	h.count(func(c *sideCounters) *uint64 { return &c.clusterAdopted }, 1)
+	logf(log.Severity_Info, "%s session adopted from cluster %s (%s)", sessionTag(connID), rec.Owner, remoteAddr)
+	return session
+}
+
+// clusterPacketValid - пакет data расшифровывается ключами записи
+// и новее последнего принятого прежним владельцем
+func clusterPacketValid(entry *sessionSnapshotEntry, data []byte, pktType PacketType, connIDLen int) bool {
+	keys, err := sessionKeysFromRaw(entry.SendKey, entry.RecvKey)
+	if err != nil {
+		return false
+	}
+	pkt, err := UnmarshalView(data, connIDLen)
+	if err != nil || pkt.PacketNumber <= entry.RecvPacketNum {
+		return false
+	}
+	body := pkt.Payload
+	if pktType == PacketType_CONTROL {
+		if len(body) < 2 {
+			return false
+		}
+		body = body[1:]
+	}
+	adLen := FlagsSize + VersionSize + connIDLen
+	if len(data) < adLen {
+		return false
+	}
+	plaintext, err := decryptPlain(keys, body, pkt.PacketNumber, data[:adLen])
+	if err != nil {
+		return false
+	}
+	putPlainBuf(plaintext)
+	return true
+}
+
+// releaseSession молча закрывает сессию, подхваченную сервером owner
+func (h *Hub) releaseSession(session *Session, owner string) {
+	if h.sessions.get(session.ID) != session {
+		return
+	}
+	h.closeSession(session.ID, CloseReason_MIGRATED)
+	h.count(func(c *sideCounters) *uint64 { return &c.clusterReleased }, 1)
+	logf(log.Severity_Info, "%s session moved to cluster %s", sessionTag(session.ID), owner)
+}
+
+// clusterRecordSeen - хранилище получило запись другого сервера
+func (h *Hub) clusterRecordSeen(id, data []byte) {
+	session := h.sessions.get(id)
+	if session == nil {
+		return
+	}
+	rec, err := h.cluster.open(id, data)
+	if err == nil && h.cluster.movedAway(rec, session) {
+		h.releaseSession(session, rec.Owner)
+	}
+}
+
+// clusterLoop периодически публикует сессии в кластер
+func (h *Hub) clusterLoop() {
+	ticker := time.NewTicker(h.cluster.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h.publishSessions(false)
+	}
+}
+
+// publishSessions публикует изменившиеся сессии (all - все) и
+// отпускает подхваченные другими серверами
+func (h *Hub) publishSessions(all bool) {
+	c := h.cluster
+	for _, session := range h.sessions.snapshot() {
+		if session.Keys == nil || session.streams != nil || atomic.LoadInt32(&session.closed) == 1 {
+			continue
+		}
+		session.mu.RLock()
+		active := session.LastActiveAt.UnixNano()
+		session.mu.RUnlock()
+		sent := atomic.LoadUint32(&session.SendPacketNum)
+		// Сервер, отправивший много без ответа клиента, публикует
+		// счётчик заранее: подхват не должен повторить nonce
+		if !all && active == session.clusterActive && sent-session.clusterSent < clusterCounterGap/4 {
+			continue
+		}
+
+		rec, err := c.get(session.ID)
+		if err != nil {
+			logf(log.Severity_Debug, "%s cluster lookup failed: %v", sessionTag(session.ID), err)
+			continue
+		}
+		if c.movedAway(rec, session) {
+			h.releaseSession(session, rec.Owner)
+			continue
+		}
+		if err := c.publish(session); err != nil {
+			logf(log.Severity_Debug, "%s cluster record not published: %v", sessionTag(session.ID), err)
+			continue
+		}
+		session.clusterActive = active
+		session.clusterSent = sent
+	}
+}
+
+// leaveCluster - остановка хаба: живые сессии публикуются для
+// других серверов, после drain записи удаляются
+func (h *Hub) leaveCluster() {
+	c := h.cluster
+	if c == nil {
+		return
+	}
+	if atomic.LoadInt32(&h.handedOff) == 0 {
+		if h.IsDraining() {
+			for _, session := range h.sessions.snapshot() {
+				c.forget(session)
+			}
+		} else {
+			h.publishSessions(true)
+		}
+	}
+	if c.closer != nil {
+		c.closer.Close()
+	}
+}
+
+// clusterStats возвращает статистику кластера (nil - выключен)
+func (h *Hub) clusterStats() *ClusterStats {
+	if h.cluster == nil {
+		return nil
+	}
+	return &ClusterStats{
+		Node:     h.cluster.node,
+		Adopted:  atomic.LoadUint64(&h.counters.clusterAdopted),
+		Released: atomic.LoadUint64(&h.counters.clusterReleased),
+	}
+}
+
+// gossipMessage - сообщение gossip до шифрования (Data nil - удаление)
+type gossipMessage struct {
+	ID   []byte    `json:"id"`
+	Data []byte    `json:"data,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+// gossipEntry - запись gossip (data nil - удалена)
+type gossipEntry struct {
+	data []byte
+	at   time.Time
+}
+
+// gossipStore - записи кластера в памяти каждого сервера, изменения
+// рассылаются всем clusterPeers по UDP
+// Порядок изменений - по времени отправителя: часы серверов должны
+// быть синхронизированы
+type gossipStore struct {
+	conn  *net.UDPConn
+	peers []*net.UDPAddr
+	aead  cipher.AEAD
+
+	mu      sync.Mutex
+	entries map[string]gossipEntry
+	sweptAt time.Time
+	watch   func(id, data []byte)
+
+	done chan struct{}
+}
+
+// newGossipStore открывает сокет clusterListen и начинает приём
+func newGossipStore(config *Config) (*gossipStore, error) {
+	if config.Key == "" {
+		return nil, fmt.Errorf("cluster mode requires key")
+	}
+	aead, err := stateAEAD(config.Key, gossipHKDFInfo)
+	if err != nil {
+		return nil, err
+	}
+	laddr, err := net.ResolveUDPAddr("udp", config.ClusterListen)
+	if err != nil {
+		return nil, fmt.Errorf("cluster listen %q: %w", config.ClusterListen, err)
+	}
+	peers := make([]*net.UDPAddr, 0, len(config.ClusterPeers))
+	for _, peer := range config.ClusterPeers {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return nil, fmt.Errorf("cluster peer %q: %w", peer, err)
+		}
+		peers = append(peers, addr)
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return nil, fmt.Errorf("cluster listen: %w", err)
+	}
+
+	s := &gossipStore{
+		conn:    conn,
+		peers:   peers,
+		aead:    aead,
+		entries: make(map[string]gossipEntry),
+		sweptAt: time.Now(),
+		done:    make(chan struct{}),
+	}
+	go goLabeled("cluster.gossip", s.receiveLoop)
+	return s, nil
+}
+
+// Get возвращает запись сессии или nil
+func (s *gossipStore) Get(id []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[string(id)]
+	if !ok || time.Since(entry.at) > gossipEntryTTL {
+		return nil, nil
+	}
+	return entry.data, nil
+}
+
+// Put записывает сессию и рассылает запись
+func (s *gossipStore) Put(id, data []byte) error {
+	return s.update(gossipMessage{ID: id, Data: data, At: time.Now()})
+}
+
+// Delete удаляет сессию и рассылает удаление
+func (s *gossipStore) Delete(id []byte) error {
+	return s.update(gossipMessage{ID: id, At: time.Now()})
+}
+
+// Watch регистрирует fn для записей, пришедших от других серверов
+func (s *gossipStore) Watch(fn func(id, data []byte)) {
+	s.mu.Lock()
+	s.watch = fn
+	s.mu.Unlock()
+}
+
+// Close останавливает приём
+func (s *gossipStore) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	return err
+}
+
+// update применяет своё изменение и рассылает его
+func (s *gossipStore) update(msg gossipMessage) error {
+	s.mu.Lock()
+	s.entries[string(msg.ID)] = gossipEntry{data: msg.Data, at: msg.At}
+	s.sweepLocked(msg.At)
+	s.mu.Unlock()
+
+	data, err := sealState(s.aead, gossipMagic, &msg)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxGossipMessage {
+		return fmt.Errorf("cluster record too large: %d bytes", len(data))
+	}
+	var errs []error
+	for _, peer := range s.peers {
+		if _, err := s.conn.WriteToUDP(data, peer); err != nil {
+			errs = append(errs, fmt.Errorf("send to %s: %w", peer, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sweepLocked раз в gossipEntryTTL убирает устаревшие записи
+// Вызывается под s.mu
+func (s *gossipStore) sweepLocked(now time.Time) {
+	if now.Sub(s.sweptAt) < gossipEntryTTL {
+		return
+	}
+	s.sweptAt = now
+	for id, entry := range s.entries {
+		if now.Sub(entry.at) > gossipEntryTTL {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// receiveLoop принимает изменения других серверов
+func (s *gossipStore) receiveLoop() {
+	defer close(s.done)
+	buf := make([]byte, maxGossipMessage)
+	for {
+		n, _, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		var msg gossipMessage
+		if err := openState(s.aead, gossipMagic, "cluster gossip", buf[:n], &msg); err != nil {
+			continue
+		}
+		s.apply(msg)
+	}
+}
+
+// apply принимает изменение, если оно новее известного
+func (s *gossipStore) apply(msg gossipMessage) {
+	now := time.Now()
+	if msg.At.Before(now.Add(-gossipMaxSkew)) || msg.At.After(now.Add(gossipMaxSkew)) {
+		return
+	}
+	s.mu.Lock()
+	if entry, ok := s.entries[string(msg.ID)]; ok && !msg.At.After(entry.at) {
+		s.mu.Unlock()
+		return
+	}
+	s.entries[string(msg.ID)] = gossipEntry{data: msg.Data, at: msg.At}
+	s.sweepLocked(now)
+	watch := s.watch
+	s.mu.Unlock()
+
+	if watch != nil && msg.Data != nil {
+		watch(msg.ID, msg.Data)
+	}
+}
@@ -0,0 +1,257 @@
+package gametunnel
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memClusterStore - хранилище кластера в памяти
+type memClusterStore struct {
+	mu      sync.Mutex
+	records map[string][]byte
+}
+
+func newMemClusterStore() *memClusterStore {
+	return &memClusterStore{records: make(map[string][]byte)}
+}
+
+func (s *memClusterStore) Get(id []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[string(id)], nil
+}
+
+func (s *memClusterStore) Put(id, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[string(id)] = append([]byte(nil), data...)
+	return nil
+}
+
+func (s *memClusterStore) Delete(id []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, string(id))
+	return nil
+}
+
+// clusterControl - CONTROL клиента с номером pktNum, запечатанный keys
+func clusterControl(t *testing.T, h *Hub, connID []byte, pktNum uint32, keys *SessionKeys) []byte {
+	t.Helper()
+	sealed, err := keys.Encrypt([]byte("ping"), pktNum, controlAdditionalData(connID, pktNum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := NewControlPacket(connID, pktNum, append([]byte{0x07}, sealed...)).Marshal(h.getConfig())
+	wrapped, _ := h.obfs.Wrap(data)
+	return wrapped
+}
+
+func TestClusterAdoptsAuthenticatedPacket(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "cluster"
+	store := newMemClusterStore()
+
+	l1, _ := startTestListener(t, config)
+	if err := l1.hub.SetClusterStore(store); err != nil {
+		t.Fatal(err)
+	}
+	config2 := *config
+	config2.ClusterNode = 1
+	l2, _ := startTestListener(t, &config2)
+	if err := l2.hub.SetClusterStore(store); err != nil {
+		t.Fatal(err)
+	}
+	h1, h2 := l1.hub, l2.hub
+
+	from := newSink(t).LocalAddr().(*net.UDPAddr)
+	session, clientKeys := newRoamingSession(t, h1, from)
+	session.SendPacketNum = 100
+	session.RecvPacketNum = 5
+	if err := h1.cluster.publish(session); err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep-alive, старый номер и чужой ключ сессию не переносят
+	keepAlive, _ := NewKeepAlivePacket(session.ID, 6).Marshal(config)
+	wrapped, _ := h2.obfs.Wrap(keepAlive)
+	otherKeys, _ := DeriveSessionKeys([32]byte{1}, config.Key, true)
+	for name, pkt := range map[string][]byte{
+		"keep-alive": wrapped,
+		"old packet": clusterControl(t, h2, session.ID, 5, clientKeys),
+		"wrong key":  clusterControl(t, h2, session.ID, 6, otherKeys),
+	} {
+		if _, _, err := h2.RoutePacket(pkt, from); err == nil || h2.GetSession(session.ID) != nil {
+			t.Errorf("%s adopted the session (err %v)", name, err)
+		}
+	}
+
+	if _, _, err := h2.RoutePacket(clusterControl(t, h2, session.ID, 6, clientKeys), from); err != nil {
+		t.Fatalf("authenticated packet: %v", err)
+	}
+	adopted := h2.GetSession(session.ID)
+	if adopted == nil {
+		t.Fatal("session not adopted")
+	}
+	if want := uint32(100 + 2*clusterCounterGap); atomic.LoadUint32(&adopted.SendPacketNum) < want {
+		t.Errorf("SendPacketNum %d, want at least %d", adopted.SendPacketNum, want)
+	}
+	rec, err := h2.cluster.get(session.ID)
+	if err != nil || rec.Owner != "node1" || rec.Epoch != 1 {
+		t.Fatalf("record after adoption: %+v %v", rec, err)
+	}
+
+	// Прежний владелец видит новую запись и отпускает сессию
+	h1.publishSessions(true)
+	if h1.GetSession(session.ID) != nil {
+		t.Error("old owner kept the session")
+	}
+	if rec, _ := h2.cluster.get(session.ID); rec == nil || rec.Owner != "node1" {
+		t.Error("old owner removed the new record")
+	}
+	if h1.GetStats().Cluster.Released != 1 || h2.GetStats().Cluster.Adopted != 1 {
+		t.Errorf("cluster stats %+v / %+v", h1.GetStats().Cluster, h2.GetStats().Cluster)
+	}
+
+	// Закрытие у владельца удаляет запись
+	h2.closeSession(session.ID, CloseReason_KICKED)
+	if data, _ := store.Get(session.ID); data != nil {
+		t.Error("record of a closed session kept")
+	}
+}
+
+func TestClusterConfig(t *testing.T) {
+	if err := NewHub(DefaultConfig(), nil).SetClusterStore(newMemClusterStore()); err == nil {
+		t.Error("cluster without key accepted")
+	}
+
+	for field, mutate := range map[string]func(*Config){
+		"clusterNode":   func(c *Config) { c.ClusterNode = maxClusterNode + 1 },
+		"clusterPeers":  func(c *Config) { c.ClusterPeers = []string{"10.0.0.2:7000"} },
+		"clusterListen": func(c *Config) { c.ClusterListen = ":7000"; c.CompactHeaders = true },
+	} {
+		config := DefaultConfig()
+		mutate(config)
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), field) {
+			t.Errorf("%s: %v", field, err)
+		}
+	}
+}
+
+// udpRelay - балансировщик перед серверами кластера: пакеты клиента
+// уходят на текущий backend
+type udpRelay struct {
+	front, back *net.UDPConn
+	target      atomic.Pointer[net.UDPAddr]
+	client      atomic.Pointer[net.UDPAddr]
+}
+
+func startUDPRelay(t *testing.T, target *net.UDPAddr) *udpRelay {
+	t.Helper()
+	r := &udpRelay{front: newSink(t), back: newSink(t)}
+	r.target.Store(target)
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, from, err := r.front.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			r.client.Store(from)
+			r.back.WriteToUDP(buf[:n], r.target.Load())
+		}
+	}()
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			n, _, err := r.back.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if client := r.client.Load(); client != nil {
+				r.front.WriteToUDP(buf[:n], client)
+			}
+		}
+	}()
+	return r
+}
+
+// freeUDPAddr - свободный адрес на 127.0.0.1
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestClusterLoopbackMove(t *testing.T) {
+	gossip1, gossip2 := freeUDPAddr(t), freeUDPAddr(t)
+	config := DefaultConfig()
+	config.Key = "cluster"
+	config.ClusterInterval = 1
+	config1 := *config
+	config1.ClusterListen = gossip1
+	config1.ClusterPeers = []string{gossip2}
+	config2 := *config
+	config2.ClusterListen = gossip2
+	config2.ClusterPeers = []string{gossip1}
+	config2.ClusterNode = 1
+
+	l1, accepted1 := startTestListener(t, &config1)
+	l2, accepted2 := startTestListener(t, &config2)
+	relay := startUDPRelay(t, l1.Addr().(*net.UDPAddr))
+
+	client, _ := dialTestClientAt(t, relay.front.LocalAddr().(*net.UDPAddr), config, accepted1)
+	id := client.session().ConnectionID
+
+	// Запись сессии дошла до второго сервера
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if data, _ := l2.hub.cluster.store.Get(id); data != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("session record not gossiped")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Маршрут сменился: пакеты клиента приходят на второй сервер
+	relay.target.Store(l2.Addr().(*net.UDPAddr))
+	if _, err := client.Write([]byte("moved")); err != nil {
+		t.Fatal(err)
+	}
+	var server2 net.Conn
+	select {
+	case server2 = <-accepted2:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not adopted by the second server")
+	}
+	if got := readWithTimeout(t, server2, 2048); string(got) != "moved" {
+		t.Errorf("second server got %q", got)
+	}
+	if _, err := server2.Write([]byte("hello from node1")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, client, 2048); string(got) != "hello from node1" {
+		t.Errorf("client got %q", got)
+	}
+
+	// Первый сервер узнаёт о переезде по gossip
+	for l1.hub.GetSession(id) != nil {
+		if time.Now().After(deadline.Add(5 * time.Second)) {
+			t.Fatal("first server kept the session")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if stats := l2.hub.GetStats().Cluster; stats == nil || stats.Adopted != 1 || stats.Node != 1 {
+		t.Errorf("second server cluster stats %+v", stats)
+	}
+}
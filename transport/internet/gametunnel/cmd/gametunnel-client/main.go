@@ -0,0 +1,98 @@
+// gametunnel-client - автономный клиент GameTunnel: локальный SOCKS5,
+// TUN-устройство и/или проброс порта без полного xray (пакет standalone)
+//
+//	gametunnel-client -c client.json [-key K] [-obfuscation M] [-mtu N] [-port-range LO-HI]
+//	gametunnel-client -server HOST:PORT -forward ADDR [-network udp|tcp] [-key K] ...
+//
+// Флаги переопределяют поля файла, gametunnelSettings и переменные
+// GAMETUNNEL_* окружения. С -server и без -c файл не читается
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+	"github.com/xtls/xray-core/transport/internet/gametunnel/standalone"
+)
+
+// overrideFlags - флаги, переопределяющие переменные окружения
+var overrideFlags = map[string]string{
+	"key":         gametunnel.EnvKey,
+	"obfuscation": gametunnel.EnvObfuscation,
+	"mtu":         gametunnel.EnvMTU,
+	"port-range":  gametunnel.EnvPortRange,
+}
+
+func main() {
+	configPath := flag.String("c", "client.json", "path to the client config")
+	server := flag.String("server", "", "server host:port")
+	forward := flag.String("forward", "", "local address forwarded to gametunnel-server")
+	network := flag.String("network", "", "forward network: udp or tcp")
+	values := make(map[string]*string, len(overrideFlags))
+	for name, env := range overrideFlags {
+		values[name] = flag.String(name, "", "override "+env)
+	}
+	flag.Parse()
+
+	set := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	config := new(standalone.Config)
+	if set["c"] || !set["server"] {
+		var err error
+		if config, err = standalone.LoadConfig(*configPath); err != nil {
+			fail(err)
+		}
+	}
+	if set["server"] {
+		config.Server = *server
+	}
+	if set["forward"] {
+		if config.Forward == nil {
+			config.Forward = new(standalone.ForwardConfig)
+		}
+		config.Forward.Listen = *forward
+	}
+	if set["network"] && config.Forward != nil {
+		config.Forward.Network = *network
+	}
+
+	// Заданный флаг важнее переменной окружения, даже пустой
+	overrides := make(map[string]string)
+	for name, env := range overrideFlags {
+		if set[name] {
+			overrides[env] = *values[name]
+		}
+	}
+	config.LookupEnv = func(name string) (string, bool) {
+		if value, ok := overrides[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	if err := run(config); err != nil {
+		fail(err)
+	}
+}
+
+func run(config *standalone.Config) error {
+	client, err := standalone.NewClient(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return client.Run(ctx)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gametunnel-client:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,90 @@
+// gametunnel-server - автономный сервер GameTunnel: каждое соединение
+// пробрасывается к одной цели UDP/TCP без полного xray (пакет standalone)
+//
+//	gametunnel-server [-c server.json] [-listen ADDR] [-target HOST:PORT] [-network udp|tcp] [-key K] ...
+//
+// Флаги переопределяют поля файла, gametunnelSettings и переменные
+// GAMETUNNEL_* окружения; без -c конфигурация берётся только из флагов
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+	"github.com/xtls/xray-core/transport/internet/gametunnel/standalone"
+)
+
+// overrideFlags - флаги, переопределяющие переменные окружения
+var overrideFlags = map[string]string{
+	"key":         gametunnel.EnvKey,
+	"obfuscation": gametunnel.EnvObfuscation,
+	"mtu":         gametunnel.EnvMTU,
+	"port-range":  gametunnel.EnvPortRange,
+}
+
+func main() {
+	configPath := flag.String("c", "", "path to the server config")
+	listen := flag.String("listen", "", "GameTunnel address, default :443")
+	target := flag.String("target", "", "forward target host:port")
+	network := flag.String("network", "", "target network: udp or tcp")
+	values := make(map[string]*string, len(overrideFlags))
+	for name, env := range overrideFlags {
+		values[name] = flag.String(name, "", "override "+env)
+	}
+	flag.Parse()
+
+	config := new(standalone.ServerConfig)
+	if *configPath != "" {
+		var err error
+		if config, err = standalone.LoadServerConfig(*configPath); err != nil {
+			fail(err)
+		}
+	}
+
+	// Заданный флаг важнее файла и переменной окружения, даже пустой
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			config.Listen = *listen
+		case "target":
+			config.Target = *target
+		case "network":
+			config.Network = *network
+		}
+		if env, ok := overrideFlags[f.Name]; ok {
+			overrides[env] = *values[f.Name]
+		}
+	})
+	config.LookupEnv = func(name string) (string, bool) {
+		if value, ok := overrides[name]; ok {
+			return value, true
+		}
+		return os.LookupEnv(name)
+	}
+
+	if err := run(config); err != nil {
+		fail(err)
+	}
+}
+
+func run(config *standalone.ServerConfig) error {
+	server, err := standalone.NewServer(config)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return server.Run(ctx)
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "gametunnel-server:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,158 @@
+package gametunnel
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Склейка мелких записей клиента
+// ====================================================================
+//
+// Каждый Write - минимум одна датаграмма с 30+ байтами заголовка, тега
+// и padding. Интерактивный TCP внутри туннеля (SSH, чаты, телеметрия)
+// пишет по нескольку байт и платит этот оверхед на каждую запись.
+//
+// С coalesceWindow запись не-High приоритета не уходит сразу, а ждёт
+// в буфере соединения до coalesceWindow (1-3 мс, как Nagle, но без
+// ожидания ACK): всё, что успело прийти, уходит одним DATA-пакетом.
+// Полный пакет (maxPayload) отправляется сразу, не дожидаясь окна.
+//
+// High (игровые пакеты, DNS, закреплённый приоритет) задержку не
+// получает никогда: такая запись сначала выталкивает накопленное в
+// очередь High - порядок байтов потока сохраняется - и уходит сама.
+//
+// Окно задаётся конфигурацией и меняется на соединении
+// SetCoalesceWindow. На время 0-RTT склейки нет: ранние данные должны
+// уйти на ранних ключах (zerortt.go). Потоки общей сессии (mux.go)
+// пишут без склейки.
+//
+// ====================================================================
+
+// MaxCoalesceWindow - потолок окна склейки (мс)
+const MaxCoalesceWindow = 3
+
+// coalescer - буфер склейки записей соединения
+type coalescer struct {
+	// window - окно склейки (atomic, наносекунды, 0 - выключено)
+	// used - склейка включалась хоть раз (atomic)
+	window int64
+	used   int32
+
+	// mu - порядок записей: буфер и прямые отправки под одним локом
+	// buf / level - накопленные данные и их класс (самый срочный)
+	// armed - таймер отправки буфера запущен
+	mu    sync.Mutex
+	buf   []byte
+	level PriorityLevel
+	armed bool
+	timer *time.Timer
+
+	// writes - записей, ушедших в чужом пакете (atomic)
+	writes uint64
+}
+
+// SetCoalesceWindow меняет окно склейки записей соединения
+// 0 выключает склейку, накопленное уходит сразу
+func (c *GameTunnelClientConn) SetCoalesceWindow(d time.Duration) {
+	if limit := MaxCoalesceWindow * time.Millisecond; d > limit {
+		d = limit
+	}
+	if d < 0 {
+		d = 0
+	}
+	atomic.StoreInt64(&c.coalesce.window, int64(d))
+	if d == 0 {
+		c.flushCoalesced()
+	}
+}
+
+// GetCoalescedWrites возвращает число записей, склеенных с предыдущими
+func (c *GameTunnelClientConn) GetCoalescedWrites() uint64 {
+	return atomic.LoadUint64(&c.coalesce.writes)
+}
+
+// coalescing сообщает, идёт ли запись через буфер склейки
+func (c *GameTunnelClientConn) coalescing() bool {
+	return atomic.LoadInt64(&c.coalesce.window) > 0 && c.session().pending == nil
+}
+
+// writeCoalesced пишет b через буфер склейки
+func (c *GameTunnelClientConn) writeCoalesced(b []byte) (int, error) {
+	atomic.StoreInt32(&c.coalesce.used, 1)
+	level := c.classify(b)
+	maxPayload := c.maxPayload()
+
+	co := &c.coalesce
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	if level == PriorityHigh {
+		// Накопленное - раньше в потоке: той же очередью High, иначе
+		// очередь приоритетов отправит его после этой записи
+		co.level = PriorityHigh
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+		for written := 0; written < len(b); written += maxPayload {
+			end := min(written+maxPayload, len(b))
+			if err := c.enqueueData(0, b[written:end], level); err != nil {
+				return written, err
+			}
+		}
+		return len(b), nil
+	}
+
+	if len(co.buf) > 0 {
+		atomic.AddUint64(&co.writes, 1)
+		co.level = min(co.level, level)
+	} else {
+		co.level = level
+	}
+	co.buf = append(co.buf, b...)
+
+	// Полные пакеты - сразу, остаток ждёт окна
+	sent := 0
+	for len(co.buf)-sent >= maxPayload {
+		if err := c.enqueueData(0, co.buf[sent:sent+maxPayload], co.level); err != nil {
+			co.buf = co.buf[:0]
+			return len(b), err
+		}
+		sent += maxPayload
+	}
+	co.buf = append(co.buf[:0], co.buf[sent:]...)
+
+	if len(co.buf) > 0 && !co.armed {
+		co.armed = true
+		window := time.Duration(atomic.LoadInt64(&co.window))
+		if co.timer == nil {
+			co.timer = time.AfterFunc(window, c.flushCoalesced)
+		} else {
+			co.timer.Reset(window)
+		}
+	}
+	return len(b), nil
+}
+
+// flushCoalesced отправляет накопленное в буфере склейки
+func (c *GameTunnelClientConn) flushCoalesced() {
+	c.coalesce.mu.Lock()
+	defer c.coalesce.mu.Unlock()
+	c.flushLocked()
+}
+
+// flushLocked отправляет буфер склейки; вызывается под coalesce.mu
+func (c *GameTunnelClientConn) flushLocked() error {
+	co := &c.coalesce
+	if co.armed {
+		co.armed = false
+		co.timer.Stop()
+	}
+	if len(co.buf) == 0 {
+		return nil
+	}
+	err := c.enqueueData(0, co.buf, co.level)
+	co.buf = co.buf[:0]
+	return err
+}
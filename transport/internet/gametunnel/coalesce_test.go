@@ -0,0 +1,101 @@
+package gametunnel
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readFull читает size байт, сколько бы пакетов их ни принесло
+func readFull(t *testing.T, conn net.Conn, size int) []byte {
+	t.Helper()
+	var got []byte
+	for len(got) < size {
+		got = append(got, readWithTimeout(t, conn, size-len(got))...)
+	}
+	return got
+}
+
+func TestCoalesceSmallWrites(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "coalesce"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.CoalesceWindow = 3
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+	client.SetPriority(0, PriorityMedium)
+
+	before := atomic.LoadUint64(&client.traffic.packetsSent)
+	var want []byte
+	for i := 0; i < 10; i++ {
+		chunk := []byte{'a' + byte(i), 'A' + byte(i)}
+		want = append(want, chunk...)
+		client.Write(chunk)
+	}
+	if sent := atomic.LoadUint64(&client.traffic.packetsSent) - before; sent != 0 {
+		t.Errorf("%d packets sent before the window closed", sent)
+	}
+
+	if got := readFull(t, server, len(want)); !bytes.Equal(got, want) {
+		t.Fatalf("Server got %q, want %q", got, want)
+	}
+	if sent := atomic.LoadUint64(&client.traffic.packetsSent) - before; sent >= 10 {
+		t.Errorf("%d packets for 10 small writes", sent)
+	}
+	if client.GetCoalescedWrites() == 0 || client.GetStats().Coalesced != client.GetCoalescedWrites() {
+		t.Errorf("coalesced writes %d, stats %d", client.GetCoalescedWrites(), client.GetStats().Coalesced)
+	}
+}
+
+func TestCoalesceHighBypassesWindow(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "coalesce"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.CoalesceWindow = 3
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	// Medium ждёт окна, High выталкивает его раньше себя
+	client.SetPriority(0, PriorityMedium)
+	before := atomic.LoadUint64(&client.traffic.packetsSent)
+	client.Write([]byte("slow-"))
+	client.SetPriority(0, PriorityHigh)
+	client.Write([]byte("fast"))
+	if sent := atomic.LoadUint64(&client.traffic.packetsSent) - before; sent != 2 {
+		t.Errorf("%d packets sent, want buffered and High at once", sent)
+	}
+	if got := readFull(t, server, 9); string(got) != "slow-fast" {
+		t.Fatalf("Server got %q", got)
+	}
+
+	// Полный пакет не ждёт окна, остаток уходит по таймеру
+	client.SetPriority(0, PriorityLow)
+	client.SetCoalesceWindow(time.Second)
+	big := bytes.Repeat([]byte{'x'}, client.maxPayload()+10)
+	before = atomic.LoadUint64(&client.traffic.packetsSent)
+	client.Write(big)
+	if sent := atomic.LoadUint64(&client.traffic.packetsSent) - before; sent != 1 {
+		t.Errorf("%d packets sent for a full payload", sent)
+	}
+	start := time.Now()
+	if got := readFull(t, server, len(big)); !bytes.Equal(got, big) {
+		t.Fatalf("Server got %d bytes", len(got))
+	}
+	// Окно ограничено MaxCoalesceWindow
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Tail waited %v", elapsed)
+	}
+
+	// Выключение склейки отправляет хвост сразу
+	client.Write([]byte("tail"))
+	client.SetCoalesceWindow(0)
+	if got := readWithTimeout(t, server, 4); string(got) != "tail" {
+		t.Fatalf("Server got %q", got)
+	}
+}
@@ -0,0 +1,406 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Сжатие заголовков DATA (compactHeaders)
+// ====================================================================
+//
+// У игрового пакета в 40 байт заголовок (flags, version, Connection ID,
+// номер, длина) и тег AEAD почти удваивают размер. По образцу ROHC
+// заголовок, который от пакета к пакету не меняется, передаётся один
+// раз, а дальше - только номер контекста:
+//
+//	полный:  [flags 1][version 4][connID N][pktnum 4][len 2][шифротекст+тег]
+//	сжатый:  [cflags 1][ctx 1][pktnum 1-2][шифротекст+тег]
+//
+// Сжатый пакет - короткий заголовок QUIC (Form = 0, Fixed = 1), как
+// 1-RTT пакеты настоящего QUIC после хэндшейка; ctx на месте DCID.
+// Младшие биты cflags - длина номера минус 1, FlagPaddingBit - как в
+// полном заголовке (padding и его длина - в хвосте, как у полного
+// пакета). Номер пакета - младшие байты номера, восстанавливаются по
+// наибольшему принятому (RFC 9000, A.3); отправитель берёт 1 байт,
+// пока от последнего полного пакета прошло меньше compactShortDelta
+// номеров, иначе 2.
+//
+// Получатель собирает из сжатого пакета полный по заголовку
+// последнего принятого полного DATA и разбирает его как обычно: AD
+// AEAD - полный заголовок, поэтому чужой контекст или неверно
+// восстановленный номер пакет не расшифруют. Полный заголовок
+// уходит первым пакетом, после смены Connection ID и каждым
+// compactRefresh-ным: потерянный контекст восстанавливается сам.
+//
+// Сжимаются только пакеты класса High (игровой трафик): загрузкам
+// экономия в 20 байт не нужна. Режим согласуется в хэндшейке битом
+// helloCapCompact; сервер выдаёт номер контекста в расширении Server
+// Hello (1 байт) и маршрутизирует сжатые пакеты по адресу клиента и
+// номеру. После смены адреса клиента сжатые пакеты доходят со
+// следующего полного: клиент после rebind шлёт его сразу, при
+// смене NAT-маппинга теряется до compactRefresh пакетов.
+//
+// В режимах quic и raw сжатый пакет уходит без обёртки (QUIC
+// long header с SCID свёл бы экономию на нет), в webrtc - в том же
+// DTLS record, что и полный. Старый сервер незнакомый бит не
+// принимает и отвечает без сжатия.
+//
+// ====================================================================
+
+const (
+	// helloCapCompact - байт возможностей хэндшейка: сжатие
+	// заголовков; в Server Hello - номер контекста в расширении
+	helloCapCompact byte = 0x10
+
+	// compactIDSize - размер расширения с номером контекста
+	compactIDSize = 1
+
+	// compactRefresh - каждый какой DATA-пакет класса High уходит с
+	// полным заголовком
+	compactRefresh = 32
+
+	// compactShortDelta - с какого расстояния от последнего полного
+	// пакета номер кодируется 2 байтами; compactMaxDelta - с какого
+	// пакет уходит полным
+	compactShortDelta = 64
+	compactMaxDelta   = 1 << 14
+
+	// compactHeaderSize - cflags и номер контекста
+	compactHeaderSize = 2
+
+	// compactPNLenMask - биты cflags с длиной номера минус 1
+	compactPNLenMask = 0x03
+)
+
+// isCompactPacket - первый байт сжатого пакета (короткий заголовок)
+func isCompactPacket(first byte) bool {
+	return first&(FlagFormBit|FlagFixedBit) == FlagFixedBit
+}
+
+// compactState - контекст сжатия заголовков сессии, общий для обоих
+// направлений (nil - сжатие не согласовано)
+type compactState struct {
+	// id - номер контекста в сжатом заголовке
+	id byte
+
+	// sentAD - шаблон заголовка последнего полного DATA (отправка)
+	// fullPN - его номер; sent - пакетов High с тех пор (atomic)
+	sentAD atomic.Pointer[dataAD]
+	fullPN uint32
+	sent   uint32
+
+	// recvAD - заголовок последнего принятого полного DATA
+	recvAD atomic.Pointer[dataAD]
+
+	// bound - адрес контекста в compactTable (только сервер, под
+	// compactTable.mu); boundAddr - RemoteAddr сессии при привязке
+	bound     netip.AddrPort
+	boundAddr atomic.Pointer[net.UDPAddr]
+}
+
+// newCompactState создаёт контекст id; заголовок приёма до первого
+// полного пакета - по connID сессии
+func newCompactState(id byte, connID []byte) *compactState {
+	c := &compactState{id: id}
+	c.recvAD.Store(newDataAD(connID))
+	return c
+}
+
+// acceptCompact - контекст сжатия из Server Hello сессии connID (nil -
+// сервер сжатие не принял)
+func acceptCompact(hello *HandshakePayload, connID []byte) *compactState {
+	if hello.Capabilities&helloCapCompact == 0 || len(hello.CompactID) != compactIDSize {
+		return nil
+	}
+	return newCompactState(hello.CompactID[0], connID)
+}
+
+// pnLen решает, как отправить DATA-пакет pktNum класса High с
+// шаблоном заголовка ad и шифротекстом size байт: длина номера в
+// сжатом заголовке или 0 - полный заголовок
+func (c *compactState) pnLen(ad *dataAD, pktNum uint32, size int) int {
+	if c == nil {
+		return 0
+	}
+	delta := pktNum - atomic.LoadUint32(&c.fullPN)
+	n := 1
+	if delta >= compactShortDelta {
+		n = 2
+	}
+	// Короткий пакет QUIC должен нести образец защиты заголовка
+	full := c.sentAD.Load() != ad || delta >= compactMaxDelta ||
+		n+size < quicMinPayload || atomic.AddUint32(&c.sent, 1)%compactRefresh == 0
+	if full {
+		c.sentAD.Store(ad)
+		atomic.StoreUint32(&c.fullPN, pktNum)
+		atomic.StoreUint32(&c.sent, 0)
+		return 0
+	}
+	return n
+}
+
+// resync - следующий DATA уйдёт с полным заголовком (смена адреса)
+func (c *compactState) resync() {
+	if c != nil {
+		c.sentAD.Store(nil)
+	}
+}
+
+// received запоминает заголовок принятого DATA с Connection ID connID
+func (c *compactState) received(connID []byte) {
+	if c == nil {
+		return
+	}
+	if a := c.recvAD.Load(); a == nil || string(a.connID) != string(connID) {
+		c.recvAD.Store(newDataAD(connID))
+	}
+}
+
+// appendCompactPacket дописывает к dst сжатый DATA-пакет: заголовок
+// ad (loadDataAD) заменяется номером контекста id, номер пакета
+// сокращается до pnLen байт
+func appendCompactPacket(dst []byte, id byte, ad []byte, pktNum uint32, pnLen int, payload []byte, config *Config) []byte {
+	paddingSize := packetPaddingSize(ad[0]&FlagPaddingBit != 0, config)
+	size := compactHeaderSize + pnLen + len(payload)
+	if paddingSize > 0 {
+		size += paddingSize + PaddingLengthSize
+	}
+	dst, buf := growPacket(dst, size)
+	buf[0] = FlagFixedBit | ad[0]&FlagPaddingBit | byte(pnLen-1)
+	buf[1] = id
+	offset := compactHeaderSize
+	for i := pnLen - 1; i >= 0; i-- {
+		buf[offset] = byte(pktNum >> (8 * i))
+		offset++
+	}
+	offset += copy(buf[offset:], payload)
+	if paddingSize > 0 {
+		rand.Read(buf[offset : offset+paddingSize])
+		offset += paddingSize
+		binary.BigEndian.PutUint16(buf[offset:], uint16(paddingSize))
+	}
+	return dst
+}
+
+// expand восстанавливает из сжатого пакета полный DATA-пакет
+// largest - наибольший принятый номер пакета сессии
+// Пакет собирается на месте, если ёмкости packet хватает
+func (c *compactState) expand(packet []byte, largest uint32) ([]byte, error) {
+	if len(packet) < compactHeaderSize+1 {
+		return nil, fmt.Errorf("compact packet too short: %d bytes", len(packet))
+	}
+	pnLen := int(packet[0]&compactPNLenMask) + 1
+	if pnLen > 2 || len(packet) < compactHeaderSize+pnLen+AuthTagSize {
+		return nil, fmt.Errorf("malformed compact packet: %d bytes, packet number %d bytes", len(packet), pnLen)
+	}
+	if packet[1] != c.id {
+		return nil, fmt.Errorf("unknown compact context %d", packet[1])
+	}
+	recv := c.recvAD.Load()
+	if recv == nil {
+		return nil, errors.New("compact context not established")
+	}
+
+	padding := packet[0]&FlagPaddingBit != 0
+	header := recv.variants[0]
+	if padding {
+		header = recv.variants[1]
+	}
+
+	var truncated uint32
+	for _, b := range packet[compactHeaderSize : compactHeaderSize+pnLen] {
+		truncated = truncated<<8 | uint32(b)
+	}
+	pktNum := decodePacketNumber(largest, truncated, pnLen*8)
+
+	rest := packet[compactHeaderSize+pnLen:]
+	payloadLen := len(rest)
+	if padding {
+		if len(rest) < PaddingLengthSize {
+			return nil, errors.New("compact packet truncated: missing padding length")
+		}
+		payloadLen -= PaddingLengthSize + int(binary.BigEndian.Uint16(rest[len(rest)-PaddingLengthSize:]))
+		if payloadLen < AuthTagSize {
+			return nil, errors.New("compact packet truncated: padding longer than packet")
+		}
+	}
+
+	// Хвост сдвигается на место после полного заголовка; copy
+	// корректен для пересекающихся срезов
+	size := len(header) + PacketNumberSize + PayloadLengthSize + len(rest)
+	var full []byte
+	if cap(packet) >= size {
+		full = packet[:size]
+	} else {
+		full = make([]byte, size)
+	}
+	offset := len(header) + PacketNumberSize + PayloadLengthSize
+	copy(full[offset:], rest)
+	copy(full, header)
+	binary.BigEndian.PutUint32(full[len(header):], pktNum)
+	binary.BigEndian.PutUint16(full[len(header)+PacketNumberSize:], uint16(payloadLen))
+	return full, nil
+}
+
+// decodePacketNumber восстанавливает номер пакета по младшим bits
+// битам truncated и наибольшему принятому номеру (RFC 9000, A.3)
+func decodePacketNumber(largest, truncated uint32, bits int) uint32 {
+	expected := int64(largest) + 1
+	win := int64(1) << bits
+	hwin := win / 2
+	candidate := expected&^(win-1) | int64(truncated)
+	switch {
+	case candidate <= expected-hwin && candidate < 1<<32-win:
+		candidate += win
+	case candidate > expected+hwin && candidate >= win:
+		candidate -= win
+	}
+	return uint32(candidate)
+}
+
+// wrapCompact обфусцирует сжатый пакет: в webrtc - DTLS record, в
+// остальных режимах он уходит как есть
+func wrapCompact(obfs Obfuscator, packet []byte) ([]byte, error) {
+	inner := obfs
+	if co, ok := obfs.(*captureObfuscator); ok {
+		inner = co.Obfuscator
+	}
+	if _, ok := inner.(*WebRTCObfuscator); ok {
+		return obfs.Wrap(packet)
+	}
+	tapOf(obfs).packet(true, packet)
+	return packet, nil
+}
+
+// unwrapPacket снимает обфускацию с датаграммы; сжатый пакет в
+// режимах quic и raw приходит без обёртки
+func unwrapPacket(obfs Obfuscator, raw []byte) ([]byte, error) {
+	if len(raw) > 0 && isCompactPacket(raw[0]) {
+		tapOf(obfs).packet(false, raw)
+		return raw, nil
+	}
+	return obfs.Unwrap(raw)
+}
+
+// ====================================================================
+// Сервер: контексты по адресам клиентов
+// ====================================================================
+
+// compactKey - ключ контекста: адрес клиента и номер
+type compactKey struct {
+	addr netip.AddrPort
+	id   byte
+}
+
+// compactTable - сессии со сжатием по адресу клиента и номеру
+// контекста. Номера выдаются на адрес: за одним адресом обычно одна
+// сессия, и 256 номеров хватает и переподключениям
+type compactTable struct {
+	sessions map[compactKey]*Session
+	mu       sync.RWMutex
+}
+
+// newCompactTable создаёт пустую таблицу
+func newCompactTable() *compactTable {
+	return &compactTable{sessions: make(map[compactKey]*Session)}
+}
+
+// compactAddr - адрес клиента как ключ таблицы (IPv4 без IPv6-обёртки)
+func compactAddr(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// add выдаёт сессии свободный номер контекста на адресе addr
+// nil - все номера адреса заняты, сессия работает без сжатия
+func (t *compactTable) add(session *Session, addr *net.UDPAddr) *compactState {
+	ap := compactAddr(addr)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id := 0; id <= 0xFF; id++ {
+		key := compactKey{ap, byte(id)}
+		if _, taken := t.sessions[key]; !taken {
+			c := newCompactState(byte(id), session.ID)
+			c.bound = ap
+			c.boundAddr.Store(addr)
+			t.sessions[key] = session
+			return c
+		}
+	}
+	return nil
+}
+
+// restore возвращает восстановленной сессии (snapshot.go) её номер
+// контекста: клиент продолжает слать сжатые пакеты
+func (t *compactTable) restore(session *Session, addr *net.UDPAddr, id byte) *compactState {
+	c := newCompactState(id, session.ID)
+	c.bound = compactAddr(addr)
+	c.boundAddr.Store(addr)
+	t.mu.Lock()
+	t.sessions[compactKey{c.bound, id}] = session
+	t.mu.Unlock()
+	return c
+}
+
+// get возвращает сессию контекста id клиента addr (nil - нет)
+func (t *compactTable) get(addr *net.UDPAddr, id byte) *Session {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sessions[compactKey{compactAddr(addr), id}]
+}
+
+// follow переносит контекст сессии на её текущий адрес addr
+// Аутентифицированный пакет с адреса важнее прежнего владельца номера
+func (t *compactTable) follow(session *Session, addr *net.UDPAddr) {
+	c := session.compact
+	if c == nil || c.boundAddr.Load() == addr {
+		return
+	}
+	ap := compactAddr(addr)
+	t.mu.Lock()
+	if c.bound != ap {
+		if key := (compactKey{c.bound, c.id}); t.sessions[key] == session {
+			delete(t.sessions, key)
+		}
+		c.bound = ap
+		t.sessions[compactKey{ap, c.id}] = session
+	}
+	c.boundAddr.Store(addr)
+	t.mu.Unlock()
+}
+
+// remove снимает контекст закрытой сессии
+func (t *compactTable) remove(session *Session) {
+	c := session.compact
+	if c == nil {
+		return
+	}
+	t.mu.Lock()
+	if key := (compactKey{c.bound, c.id}); t.sessions[key] == session {
+		delete(t.sessions, key)
+	}
+	t.mu.Unlock()
+}
+
+// expandCompact восстанавливает полный пакет из сжатого от addr
+func (h *Hub) expandCompact(data []byte, addr *net.UDPAddr) ([]byte, error) {
+	if len(data) < compactHeaderSize {
+		return nil, dropped(dropTooShort, fmt.Errorf("compact packet too short: %d bytes", len(data)))
+	}
+	session := h.compact.get(addr, data[1])
+	if session == nil {
+		return nil, dropped(dropUnknownConnID, fmt.Errorf("unknown compact context %d from %s", data[1], addr))
+	}
+	full, err := session.compact.expand(data, session.ReplayWindow.largest())
+	if err != nil {
+		return nil, dropped(dropMalformed, err)
+	}
+	return full, nil
+}
@@ -0,0 +1,259 @@
+package gametunnel
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDecodePacketNumber(t *testing.T) {
+	cases := []struct {
+		largest, truncated uint32
+		bits               int
+		want               uint32
+	}{
+		// Пример RFC 9000, A.3
+		{0xa82f30ea, 0x9b32, 16, 0xa82f9b32},
+		{100, 101, 8, 101},
+		// Переход через границу окна вперёд и назад
+		{250, 3, 8, 259},
+		{260, 250, 8, 250},
+		// Опоздавший пакет за половиной окна - в прошлое, не в будущее
+		{1000, 1000 & 0xFF, 8, 1000},
+		{0, 5, 8, 5},
+		// У конца номеров не переполняемся
+		{0xFFFFFFF0, 0xF8, 8, 0xFFFFFFF8},
+	}
+	for _, tc := range cases {
+		if got := decodePacketNumber(tc.largest, tc.truncated, tc.bits); got != tc.want {
+			t.Errorf("decodePacketNumber(%#x, %#x, %d) = %#x, want %#x", tc.largest, tc.truncated, tc.bits, got, tc.want)
+		}
+	}
+}
+
+func TestCompactExpand(t *testing.T) {
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	ciphertext := bytes.Repeat([]byte{0xAB}, 40+AuthTagSize)
+
+	for _, padding := range []bool{false, true} {
+		config := DefaultConfig()
+		config.EnablePadding = padding
+		ad := newDataAD(connID).variants[0]
+		if padding {
+			ad = newDataAD(connID).variants[1]
+		}
+
+		for _, pnLen := range []int{1, 2} {
+			const pktNum = 0x1234
+			compact := appendCompactPacket(nil, 7, ad, pktNum, pnLen, ciphertext, config)
+			if !isCompactPacket(compact[0]) || IsQUICLike(compact[0]) {
+				t.Fatalf("compact first byte 0x%02x is not a short header", compact[0])
+			}
+			if !padding && len(compact) != compactHeaderSize+pnLen+len(ciphertext) {
+				t.Errorf("compact size %d, want %d", len(compact), compactHeaderSize+pnLen+len(ciphertext))
+			}
+
+			c := newCompactState(7, connID)
+			full, err := c.expand(compact, pktNum-3)
+			if err != nil {
+				t.Fatalf("padding=%v pnLen=%d: expand: %v", padding, pnLen, err)
+			}
+			pkt, err := UnmarshalView(full, len(connID))
+			if err != nil {
+				t.Fatalf("expanded packet: %v", err)
+			}
+			if pkt.Type != PacketType_DATA || pkt.HasPadding != padding || pkt.PacketNumber != pktNum ||
+				!bytes.Equal(pkt.ConnectionID, connID) || !bytes.Equal(pkt.Payload, ciphertext) {
+				t.Errorf("padding=%v pnLen=%d: expanded %+v", padding, pnLen, pkt)
+			}
+			if !bytes.Equal(full[:len(ad)], ad) {
+				t.Errorf("expanded header %x, want AD %x", full[:len(ad)], ad)
+			}
+
+			// Чужой контекст
+			if _, err := newCompactState(8, connID).expand(compact, pktNum); err == nil {
+				t.Error("packet of context 7 expanded by context 8")
+			}
+		}
+	}
+}
+
+func TestCompactExpandInPlace(t *testing.T) {
+	connID := []byte{9, 9, 9, 9, 9, 9, 9, 9}
+	config := DefaultConfig()
+	config.EnablePadding = false
+	ciphertext := bytes.Repeat([]byte{0xCD}, 24)
+
+	buf := make([]byte, 0, MaxPacketSize)
+	compact := appendCompactPacket(buf, 1, newDataAD(connID).variants[0], 42, 1, ciphertext, config)
+	orig := append([]byte(nil), compact...)
+
+	// Ёмкости нет - новый буфер, исходный пакет не тронут
+	tight := make([]byte, len(compact))
+	copy(tight, compact)
+	if _, err := newCompactState(1, connID).expand(tight, 41); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tight, orig) {
+		t.Error("expand without capacity changed the compact packet")
+	}
+
+	full, err := newCompactState(1, connID).expand(compact, 41)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &full[0] != &compact[0] {
+		t.Error("expand allocated despite enough capacity")
+	}
+	if pkt, err := UnmarshalView(full, len(connID)); err != nil || !bytes.Equal(pkt.Payload, ciphertext) {
+		t.Errorf("in-place expand broke the payload: %v", err)
+	}
+}
+
+func TestCompactPnLen(t *testing.T) {
+	a, b := newDataAD([]byte{1, 1, 1, 1}), newDataAD([]byte{2, 2, 2, 2})
+	c := newCompactState(0, a.connID)
+	size := 64
+
+	if n := c.pnLen(a, 1, size); n != 0 {
+		t.Fatalf("first packet pnLen %d, want full header", n)
+	}
+	full := 0
+	for pn := uint32(2); pn <= 1+3*compactRefresh; pn++ {
+		if c.pnLen(a, pn, size) == 0 {
+			full++
+		}
+	}
+	if full != 3 {
+		t.Errorf("%d full headers in %d packets, want 3", full, 3*compactRefresh)
+	}
+
+	// Смена Connection ID и resync - полный заголовок
+	if n := c.pnLen(b, 200, size); n != 0 {
+		t.Errorf("pnLen after connection ID change %d, want full header", n)
+	}
+	c.resync()
+	if n := c.pnLen(b, 201, size); n != 0 {
+		t.Errorf("pnLen after resync %d, want full header", n)
+	}
+
+	// Далеко от полного - 2 байта, очень далеко - полный
+	if n := c.pnLen(b, 201+compactShortDelta, size); n != 2 {
+		t.Errorf("pnLen at delta %d = %d, want 2", compactShortDelta, n)
+	}
+	if n := c.pnLen(b, 201+compactMaxDelta, size); n != 0 {
+		t.Errorf("pnLen at delta %d = %d, want full header", compactMaxDelta, n)
+	}
+
+	// Без образца защиты заголовка QUIC - полный
+	if n := c.pnLen(b, 202+compactMaxDelta, quicMinPayload-2); n != 0 {
+		t.Errorf("pnLen for %d-byte ciphertext %d, want full header", quicMinPayload-2, n)
+	}
+
+	var none *compactState
+	if n := none.pnLen(a, 5, size); n != 0 {
+		t.Errorf("nil state pnLen %d", n)
+	}
+}
+
+func TestCompactHeadersLoopback(t *testing.T) {
+	for _, mode := range []ObfuscationMode{ObfuscationMode_QUIC_MIMIC, ObfuscationMode_WEBRTC_MIMIC, ObfuscationMode_RAW} {
+		t.Run(NewObfuscator(mode, DefaultConfig()).Name(), func(t *testing.T) {
+			config := DefaultConfig()
+			config.Key = "compact"
+			config.Obfuscation = mode
+			config.Priority = PriorityMode_GAMING
+			config.CompactHeaders = true
+
+			l, accepted := startTestListener(t, config)
+			clientConfig := *config
+			client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+			if client.session().compact == nil {
+				t.Fatal("client did not negotiate compact headers")
+			}
+			session := l.hub.sessions.get(client.session().ConnectionID)
+			if session == nil || session.compact == nil {
+				t.Fatal("server session without compact context")
+			}
+
+			for i := 0; i < 2*compactRefresh; i++ {
+				request := []byte(fmt.Sprintf("player_move: seq=%d x=1 y=2", i))
+				if _, err := client.Write(request); err != nil {
+					t.Fatalf("client Write: %v", err)
+				}
+				if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+					t.Fatalf("server got %q, want %q", got, request)
+				}
+				response := []byte(fmt.Sprintf("world_state: tick=%d", i))
+				if _, err := server.Write(response); err != nil {
+					t.Fatalf("server Write: %v", err)
+				}
+				if got := readWithTimeout(t, client, 2048); !bytes.Equal(got, response) {
+					t.Fatalf("client got %q, want %q", got, response)
+				}
+			}
+
+			// Сжатые пакеты шли в обе стороны: после полного заголовка
+			// каждые compactRefresh пакетов счётчик не нулевой
+			if sent := atomic.LoadUint32(&client.session().compact.sent); sent == 0 {
+				t.Error("client sent no compact packets")
+			}
+			if sent := atomic.LoadUint32(&session.compact.sent); sent == 0 {
+				t.Error("server sent no compact packets")
+			}
+		})
+	}
+}
+
+func TestCompactHeadersNotNegotiated(t *testing.T) {
+	// Сжатие только у клиента - обычная сессия
+	config := DefaultConfig()
+	config.Key = "compact"
+	config.Priority = PriorityMode_GAMING
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	clientConfig.CompactHeaders = true
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	if client.session().compact != nil {
+		t.Fatal("client enabled compact headers without server support")
+	}
+	request := []byte("player_move: x=1 y=2")
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+		t.Errorf("server got %q, want %q", got, request)
+	}
+}
+
+func TestCompactTableFollow(t *testing.T) {
+	h := &Hub{compact: newCompactTable()}
+	first := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}
+	moved := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 5000}
+
+	s1 := &Session{ID: []byte{1, 1, 1, 1}}
+	s2 := &Session{ID: []byte{2, 2, 2, 2}}
+	s1.compact = h.compact.add(s1, first)
+	s2.compact = h.compact.add(s2, first)
+	if s1.compact.id == s2.compact.id {
+		t.Fatalf("two sessions behind %s share context %d", first, s1.compact.id)
+	}
+
+	h.compact.follow(s1, moved)
+	if h.compact.get(first, s1.compact.id) != nil || h.compact.get(moved, s1.compact.id) != s1 {
+		t.Error("context did not follow the session to the new address")
+	}
+	if h.compact.get(first, s2.compact.id) != s2 {
+		t.Error("neighbour context lost")
+	}
+
+	h.compact.remove(s1)
+	if h.compact.get(moved, s1.compact.id) != nil {
+		t.Error("context of removed session still routed")
+	}
+}
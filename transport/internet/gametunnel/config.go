@@ -1,6 +1,9 @@
 package gametunnel
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/xtls/xray-core/transport/internet"
 )
 
@@ -55,7 +58,8 @@ const (
 //	            "paddingRange": [40, 200],
 //	            "handshakeTimeout": 5,
 //	            "keepAliveInterval": 15,
-//	            "key": "my-secret-preshared-key"
+//	            "key": "my-secret-preshared-key",
+//	            "enableDscp": true
 //	        }
 //	    }
 //	}
@@ -115,50 +119,586 @@ type Config struct {
 	// Клиент и сервер должны иметь одинаковый ключ
 	// Если пустой - используется только Curve25519
 	Key string `json:"key"`
+
+	// EnableDscp - выставлять DSCP на исходящих UDP-пакетах по приоритету
+	// Домашние роутеры и QoS операторов ускоряют пакеты с EF-маркировкой
+	// По умолчанию выключено: часть операторов сбрасывает или режет
+	// трафик с нестандартным DSCP
+	EnableDscp bool `json:"enableDscp"`
+
+	// DscpHigh - DSCP для High-приоритета (0-63), по умолчанию 46 (EF)
+	DscpHigh uint32 `json:"dscpHigh"`
+
+	// DscpMedium - DSCP для Medium-приоритета (0-63), по умолчанию 18 (AF21)
+	DscpMedium uint32 `json:"dscpMedium"`
+
+	// DscpLow - DSCP для Low-приоритета (0-63), по умолчанию 8 (CS1)
+	DscpLow uint32 `json:"dscpLow"`
+
+	// SessionRateLimit - лимит скорости отправки одной сессии (байт/сек)
+	// 0 - без ограничений. На сервере для отдельного пользователя
+	// переопределяется через Hub.SetSessionRateLimit
+	SessionRateLimit uint64 `json:"sessionRateLimit"`
+
+	// SessionRateBurst - допустимый всплеск сверх лимита (байт)
+	// По умолчанию - 100ms трафика на скорости лимита
+	SessionRateBurst uint64 `json:"sessionRateBurst"`
+
+	// HighRateLimit, MediumRateLimit, LowRateLimit - потолки скорости
+	// классов приоритета (байт/сек), 0 - без ограничений
+	// Например, LowRateLimit придерживает загрузки, не трогая игры
+	HighRateLimit   uint64 `json:"highRateLimit"`
+	MediumRateLimit uint64 `json:"mediumRateLimit"`
+	LowRateLimit    uint64 `json:"lowRateLimit"`
+
+	// RateLimitPolicy - что делать с пакетом сверх лимита
+	// "drop" (по умолчанию) - отбросить, "queue" - задержать
+	RateLimitPolicy RateLimitPolicy `json:"rateLimitPolicy"`
+
+	// Classifier - стратегия классификации пакетов по приоритету
+	// "size" (по умолчанию) - по размеру пакета
+	// "heuristic" - по поведению потока: частота, регулярность,
+	// средний размер и порт назначения
+	Classifier ClassifierType `json:"classifier"`
+
+	// Scheduler - алгоритм разбора очереди приоритетов
+	// "priority" (по умолчанию) - строгий приоритет
+	// "edf" - ближайший дедлайн первым (бюджеты ниже)
+	Scheduler SchedulerType `json:"scheduler"`
+
+	// Бюджеты задержки классов для EDF (миллисекунды)
+	HighLatencyBudget   uint32 `json:"highLatencyBudget"`
+	MediumLatencyBudget uint32 `json:"mediumLatencyBudget"`
+	LowLatencyBudget    uint32 `json:"lowLatencyBudget"`
+
+	// EnablePacing - равномерно распределять отправку Low/Medium
+	// вместо пачек MTU-пакетов (против bufferbloat на узком месте)
+	EnablePacing bool `json:"enablePacing"`
+
+	// PacingRate - темп отправки (байт/сек)
+	// 0 - по оценке пропускной способности
+	PacingRate uint64 `json:"pacingRate"`
+
+	// DropPolicy - что отбрасывать при переполнении очереди
+	// "tail" (по умолчанию) - новый пакет, "head" - самый старый,
+	// "codel" - tail + отбрасывание Low по задержке в очереди
+	DropPolicy DropPolicy `json:"dropPolicy"`
+
+	// MaxSessionsPerIp - одновременных сессий с одного IP (0 = без лимита)
+	MaxSessionsPerIp uint32 `json:"maxSessionsPerIp"`
+
+	// HandshakesPerMinute - новых хэндшейков с одного IP в минуту
+	// (0 = без лимита). Защищает CPU от лавины ECDH
+	HandshakesPerMinute uint32 `json:"handshakesPerMinute"`
+
+	// IpBanDuration - блокировка IP, превысившего лимит хэндшейков
+	// (секунды, 0 = без блокировки)
+	IpBanDuration uint32 `json:"ipBanDuration"`
+
+	// AllowIps - если не пуст, хэндшейк разрешён только этим источникам
+	// DenyIps - источники, которым хэндшейк запрещён (проверяется первым)
+	// Записи - CIDR или "geoip:<код страны>", см. ipfilter.go
+	AllowIps []*IPRule `json:"allowIps"`
+	DenyIps  []*IPRule `json:"denyIps"`
+
+	// SessionSnapshotPath - файл зашифрованного снапшота сессий
+	// для восстановления после перезапуска ("" = выключено, нужен key)
+	SessionSnapshotPath string `json:"sessionSnapshotPath"`
+
+	// SessionSnapshotInterval - период сохранения снапшота
+	// (секунды, 0 = 30)
+	SessionSnapshotInterval uint32 `json:"sessionSnapshotInterval"`
+
+	// MetricsListen - адрес HTTP-сервера метрик Prometheus (/metrics),
+	// например "127.0.0.1:9101" ("" = выключен, см. metrics.go)
+	MetricsListen string `json:"metricsListen"`
+
+	// ApiListen - адрес HTTP API управления сессиями для панели
+	// ("" = выключен, см. management.go)
+	// ApiToken - Bearer-токен API ("" = без авторизации, только для
+	// apiListen на loopback)
+	ApiListen string `json:"apiListen"`
+	ApiToken  string `json:"apiToken"`
+
+	// QuotaBytes - квота трафика пользователя по умолчанию
+	// (байт, 0 = без квоты, только учёт, см. quota.go)
+	QuotaBytes uint64 `json:"quotaBytes"`
+
+	// QuotaAction - реакция на исчерпание квоты: throttle или close
+	QuotaAction QuotaAction `json:"quotaAction"`
+
+	// QuotaThrottleRate - скорость после исчерпания квоты в режиме
+	// throttle (байт/сек, 0 = 32 КБ/с)
+	QuotaThrottleRate uint64 `json:"quotaThrottleRate"`
+
+	// QuotaFlushInterval - период передачи учёта в UsageCallback
+	// (секунды, 0 = 60)
+	QuotaFlushInterval uint32 `json:"quotaFlushInterval"`
+
+	// DeadPeerInterval - молчание клиента, после которого сервер шлёт
+	// probe, и период повторных probe (секунды, 0 = 2 x keepAliveInterval)
+	// DeadPeerProbes - probe без ответа до удаления сессии (0 = 3)
+	// См. deadpeer.go
+	DeadPeerInterval uint32 `json:"deadPeerInterval"`
+	DeadPeerProbes   uint32 `json:"deadPeerProbes"`
+
+	// ExtraListen - дополнительные адреса "ip:port" для приёма пакетов
+	// тем же хабом, например ["0.0.0.0:8443", "[::]:443"] (multilisten.go)
+	ExtraListen []string `json:"extraListen"`
+
+	// ReceiveSockets - сокетов SO_REUSEPORT на каждый адрес, у каждого
+	// своя горутина приёма (0/1 = один сокет, см. multilisten.go)
+	ReceiveSockets uint32 `json:"receiveSockets"`
+
+	// MaxSessions - потолок активных сессий (0 = без лимита)
+	// MemoryBudgetMb - бюджет памяти на сессии, МБ (0 = без лимита)
+	// OverloadPolicy - что делать сверх потолка: reject или evict
+	// OverloadRetryAfter - retry-after для reject (секунды, 0 = 5)
+	// См. overload.go
+	MaxSessions        uint32         `json:"maxSessions"`
+	MemoryBudgetMb     uint32         `json:"memoryBudgetMb"`
+	OverloadPolicy     OverloadPolicy `json:"overloadPolicy"`
+	OverloadRetryAfter uint32         `json:"overloadRetryAfter"`
+
+	// SessionSenders - своя горутина отправки на каждую сессию вместо
+	// общего sendLoop хаба (sender.go)
+	SessionSenders bool `json:"sessionSenders"`
+
+	// DecryptWorkers - воркеров расшифровки входящих (decrypt_pool.go)
+	// 0 = по GOMAXPROCS, 1 = расшифровка в потоке приёма
+	DecryptWorkers uint32 `json:"decryptWorkers"`
+
+	// BlackholeThreshold - ошибок отправки или keep-alive без ответа
+	// подряд, после которых путь считается сломанным (0 = 3, health.go)
+	BlackholeThreshold uint32 `json:"blackholeThreshold"`
+
+	// IssueConnectionIds - ID сессии выбирает сервер и передаёт в
+	// Server Hello (connid.go). Включать после обновления клиентов
+	IssueConnectionIds bool `json:"issueConnectionIds"`
+
+	// ReconnectAttempts - попыток завести новую сессию после потери
+	// текущей (клиент, 0 = без переподключения)
+	// ReconnectBackoff - пауза перед первой попыткой (мс, 0 = 500),
+	// удваивается до ReconnectMaxBackoff (мс, 0 = 30000)
+	// См. reconnect.go
+	ReconnectAttempts   uint32 `json:"reconnectAttempts"`
+	ReconnectBackoff    uint32 `json:"reconnectBackoff"`
+	ReconnectMaxBackoff uint32 `json:"reconnectMaxBackoff"`
+
+	// ValidateMigration - адрес сессии меняет только аутентифицированный
+	// пакет, новый адрес проверяется PATH_CHALLENGE (сервер, roaming.go).
+	// Включать после обновления клиентов
+	ValidateMigration bool `json:"validateMigration"`
+
+	// Multipath - клиент держит второй путь к серверу и делит трафик
+	// между путями (multipath.go)
+	// MultipathLocalAddr - локальный IP второго пути (адрес сотового
+	// интерфейса, "" = выбирает ОС)
+	Multipath          bool   `json:"multipath"`
+	MultipathLocalAddr string `json:"multipathLocalAddr"`
+
+	// DontFragment - бит DF на пакетах сокетов сервера и клиента
+	// (Linux): слишком большой пакет теряется, а не режется на фрагменты
+	DontFragment bool `json:"dontFragment"`
+
+	// Endpoints - запасные адреса сервера "host:port", "[v6]:port" или
+	// "host" с портом outbound-а; Dial перебирает их вместе с адресом
+	// outbound-а по Happy Eyeballs (клиент, endpoints.go)
+	Endpoints []string `json:"endpoints"`
+
+	// SharedSession - соединения xray к одному серверу идут потоками
+	// одной сессии: один хэндшейк и один маппинг NAT на много
+	// соединений (клиент, mux.go)
+	SharedSession bool `json:"sharedSession"`
+
+	// EarlyData - 0-RTT: клиент запоминает ключ возобновления сервера и
+	// в следующем соединении шлёт данные, не дожидаясь Server Hello
+	// (клиент, zerortt.go)
+	EarlyData bool `json:"earlyData"`
+
+	// MtuProbe - клиент ставит DF и после хэндшейка измеряет MTU пути,
+	// уменьшая размер чанков под него (клиент, mtu.go)
+	MtuProbe bool `json:"mtuProbe"`
+
+	// Resolver - DNS-сервер для имён сервера: "https://..." (DoH) или
+	// "udp://ip:port" / "ip" (DNS по UDP); пусто - DNS xray по
+	// sockopt.domainStrategy или системный (клиент, resolve.go)
+	Resolver string `json:"resolver"`
+
+	// ResolveStrategy - семейства адресов имени сервера (клиент)
+	ResolveStrategy ResolveStrategy `json:"resolveStrategy"`
+
+	// CoalesceWindow - окно склейки мелких записей не-High приоритета,
+	// мс (0 - выключено, не больше MaxCoalesceWindow; клиент, coalesce.go)
+	CoalesceWindow uint32 `json:"coalesceWindow"`
+
+	// AppStreams - потоки приложения в соединении: OpenStream на
+	// клиенте, AcceptStream на сервере (клиент, streams.go)
+	AppStreams bool `json:"appStreams"`
+
+	// InboundWait - сколько запись в полную очередь чтения ждёт места,
+	// мс (0 - пакет отбрасывается сразу, не больше MaxInboundWait;
+	// inbound.go)
+	InboundWait uint32 `json:"inboundWait"`
+
+	// EncryptWorkers - воркеров шифрования исходящих записей сервера
+	// (0, 1 - шифрование в горутине писателя, encrypt_pool.go)
+	EncryptWorkers uint32 `json:"encryptWorkers"`
+
+	// SendBatch - пакетов в одной пачке sendmmsg сервера (0 - по
+	// умолчанию, 1 - без пачек, не больше MaxSendBatch; batch.go)
+	SendBatch uint32 `json:"sendBatch"`
+
+	// RecvBatch - датаграмм в одном recvmmsg сокета сервера (0 - по
+	// умолчанию, 1 - без пачек, не больше MaxRecvBatch; readbatch.go)
+	RecvBatch uint32 `json:"recvBatch"`
+
+	// Profile - набор настроек под сценарий: mobile, datacenter,
+	// stealth или custom (profile.go). Явно заданные поля важнее
+	Profile Profile `json:"profile"`
+
+	// ChaffBudget - покрывающий трафик клиента в простое, байт/сек
+	// (0 = выключен, chaff.go)
+	ChaffBudget uint32 `json:"chaffBudget"`
+
+	// ReadBufferSize / WriteBufferSize - SO_RCVBUF / SO_SNDBUF UDP
+	// сокетов, байт (0 = 4 МБ)
+	ReadBufferSize  uint32 `json:"readBufferSize"`
+	WriteBufferSize uint32 `json:"writeBufferSize"`
+
+	// Ttl - TTL / hop limit исходящих пакетов (0 = по умолчанию ОС)
+	// Dscp - DSCP всех пакетов сокета, когда enableDscp выключен
+	// (0 = не выставлять)
+	// BindInterface - интерфейс сокетов (SO_BINDTODEVICE, "" = из
+	// sockopt.interface xray)
+	// Fwmark - метка SO_MARK (0 = из sockopt.mark xray)
+	// Linux, см. sockopt.go
+	Ttl           uint32 `json:"ttl"`
+	Dscp          uint32 `json:"dscp"`
+	BindInterface string `json:"bindInterface"`
+	Fwmark        uint32 `json:"fwmark"`
+
+	// PortRange - порты сервера, например "20000-20010": listener
+	// слушает их все, клиент добавляет их в endpoints (portrange.go)
+	PortRange string `json:"portRange"`
+
+	// HandshakeMinSize / HandshakeMaxSize - размер датаграмм Client
+	// Hello и Server Hello после добивки, байт, отдельно от padding
+	// данных (0 = по режиму: 1200-1252 для quic, без добивки для
+	// webrtc и raw; только min - ровно min; obfs.go)
+	HandshakeMinSize uint32 `json:"handshakeMinSize"`
+	HandshakeMaxSize uint32 `json:"handshakeMaxSize"`
+
+	// CaptureFile - путь дампа pcapng для отладки: датаграммы на
+	// проводе, пакеты до обфускации и открытые данные (capture.go)
+	// Пусто - выключено. CapturePlaintext - писать данные как есть,
+	// иначе они заменяются нулями той же длины
+	CaptureFile      string `json:"captureFile"`
+	CapturePlaintext bool   `json:"capturePlaintext"`
+
+	// TracingEndpoint - коллектор OpenTelemetry для спанов хэндшейков
+	// и сессий, OTLP/HTTP: "http://collector:4318" (tracing.go)
+	TracingEndpoint string `json:"tracingEndpoint"`
+
+	// DebugListen - адрес отладочного HTTP-сервера: expvar
+	// (/debug/vars) и pprof (/debug/pprof/), например "127.0.0.1:6061"
+	// ("" = выключен, только loopback, см. debug.go)
+	DebugListen string `json:"debugListen"`
+
+	// AuditLog - журнал аудита сессий сервера: путь файла JSONL с
+	// ротацией, "syslog" - локальный syslog, "syslog://host:514" -
+	// удалённый по UDP ("" = выключен, см. audit.go)
+	// AuditIp - как писать адрес клиента: truncate (/24 и /48, по
+	// умолчанию), hash (HMAC с AuditSalt), full или none
+	// AuditMaxSize - размер файла до ротации, МБ (0 = 100),
+	// AuditMaxFiles - сколько старых файлов хранить (0 = 5)
+	AuditLog      string      `json:"auditLog"`
+	AuditIp       AuditIPMode `json:"auditIp"`
+	AuditSalt     string      `json:"auditSalt"`
+	AuditMaxSize  uint32      `json:"auditMaxSize"`
+	AuditMaxFiles uint32      `json:"auditMaxFiles"`
+
+	// SocketActivation - сервер берёт UDP-сокеты, переданные systemd
+	// (LISTEN_FDS), для совпадающих адресов; остальные адреса
+	// открывает сам (inherit.go)
+	// ListenFd - уже привязанный UDP-сокет с этим номером дескриптора
+	// вместо адреса inbound-а (0 = выключено, номер от 3)
+	SocketActivation bool   `json:"socketActivation"`
+	ListenFd         uint32 `json:"listenFd"`
+
+	// UpgradeSocket - unix-сокет передачи сокетов и сессий новому
+	// процессу сервера (upgrade.go); нужен key ("" = выключено)
+	UpgradeSocket string `json:"upgradeSocket"`
+
+	// CompactHeaders - сжатие заголовков DATA класса High до номера
+	// контекста и сокращённого номера пакета (compact.go); работает,
+	// если включено у клиента и у сервера
+	CompactHeaders bool `json:"compactHeaders"`
+
+	// Duplicate - поток 0 (само соединение) отправляет каждый
+	// High-пакет дважды (duplicate.go); действует на своей стороне
+	Duplicate bool `json:"duplicate"`
+
+	// PuzzleRate - Client Hello в секунду, сверх которых сервер
+	// требует от клиентов решить задачу (puzzle.go; 0 = выключено)
+	PuzzleRate uint32 `json:"puzzleRate"`
+
+	// Timestamps - метки времени отправки и эхо в каждом DATA для
+	// оценки односторонней задержки и bufferbloat (timestamps.go);
+	// нужен с обеих сторон, +10 байт на пакет
+	Timestamps bool `json:"timestamps"`
+
+	// PaddingBackoff - уменьшение padding под нагрузкой канала: none,
+	// linear, step (padding.go)
+	// PaddingBandwidth - ёмкость канала отправителя, байт/сек (0 - по
+	// оценке, не меньше 10 Мбит/с)
+	PaddingBackoff   PaddingBackoff `json:"paddingBackoff"`
+	PaddingBandwidth uint64         `json:"paddingBandwidth"`
+
+	// HubGroup - inbound-ы сервера с одинаковым именем делят один хаб,
+	// у каждого своя политика padding, лимитов и фильтра источников
+	// (policy.go; "" = свой хаб)
+	HubGroup string `json:"hubGroup"`
+
+	// KeepAliveTune - клиент ищет самый длинный интервал keep-alive,
+	// который держит маппинг NAT, пробами от сервера (nattune.go)
+	// KeepAliveMax - верхняя граница поиска в секундах (0 = 300)
+	KeepAliveTune bool   `json:"keepAliveTune"`
+	KeepAliveMax  uint32 `json:"keepAliveMax"`
+
+	// ClusterListen - адрес gossip кластера: серверы за anycast или
+	// балансировщиком продолжают сессии друг друга (cluster.go; "" -
+	// без кластера), ClusterPeers - адреса gossip других серверов
+	// ClusterNode - номер сервера в кластере (0-63, у каждого свой)
+	// ClusterInterval - период публикации сессий в секундах (0 = 5)
+	ClusterListen   string   `json:"clusterListen"`
+	ClusterPeers    []string `json:"clusterPeers"`
+	ClusterNode     uint32   `json:"clusterNode"`
+	ClusterInterval uint32   `json:"clusterInterval"`
+
+	// LbServerId - ID сервера для балансировщика в выданных Connection
+	// ID по схеме QUIC-LB (hex, 1-6 байт, lbroute.go; "" - без него)
+	// LbConfigId - номер конфигурации балансировщика (0-6) в старших
+	// битах первого байта ID
+	LbServerId string `json:"lbServerId"`
+	LbConfigId uint32 `json:"lbConfigId"`
+
+	// ClassSockets - клиент шлёт Medium и Low через отдельный сокет
+	// к серверу (classsock.go)
+	// HighWriteBufferSize - SO_SNDBUF сокета хэндшейка с classSockets,
+	// байт (0 = 64 КБ); BulkWriteBufferSize - SO_SNDBUF второго сокета
+	// (0 = writeBufferSize)
+	ClassSockets        bool   `json:"classSockets"`
+	HighWriteBufferSize uint32 `json:"highWriteBufferSize"`
+	BulkWriteBufferSize uint32 `json:"bulkWriteBufferSize"`
+
+	// Users - пользователи сервера с личными ключами: хэндшейк
+	// определяет пользователя сессии (users.go; пусто - общий key)
+	// UserKey - личный ключ пользователя клиента ("" - без него)
+	Users   []*User `json:"users"`
+	UserKey string  `json:"userKey"`
+
+	// Lenient - Validate исправляет недопустимые значения на значения
+	// по умолчанию вместо ошибки (для встраивания; сервер и клиент
+	// xray по умолчанию проверяют строго)
+	Lenient bool `json:"lenient"`
 }
 
 // DefaultConfig возвращает конфигурацию по умолчанию
 // с оптимальными настройками для gaming-трафика
 func DefaultConfig() *Config {
 	return &Config{
-		Obfuscation:        ObfuscationMode_QUIC_MIMIC,
-		Priority:           PriorityMode_GAMING,
-		MTU:                1400,
+		Obfuscation:         ObfuscationMode_QUIC_MIMIC,
+		Priority:            PriorityMode_GAMING,
+		MTU:                 1400,
 		MaxStreams:          16,
-		ConnectionIdLength: 8,
-		EnablePadding:      true,
-		PaddingMinSize:     40,
-		PaddingMaxSize:     200,
-		HandshakeTimeout:   5,
-		KeepAliveInterval:  15,
-		Key:                "",
+		ConnectionIdLength:  8,
+		EnablePadding:       true,
+		PaddingMinSize:      40,
+		PaddingMaxSize:      200,
+		HandshakeTimeout:    5,
+		KeepAliveInterval:   15,
+		Key:                 "",
+		EnableDscp:          false,
+		DscpHigh:            DefaultDscpHigh,
+		DscpMedium:          DefaultDscpMedium,
+		DscpLow:             DefaultDscpLow,
+		RateLimitPolicy:     RateLimitPolicy_DROP,
+		Classifier:          ClassifierType_SIZE,
+		Scheduler:           SchedulerType_PRIORITY,
+		HighLatencyBudget:   DefaultHighLatencyBudget,
+		MediumLatencyBudget: DefaultMediumLatencyBudget,
+		LowLatencyBudget:    DefaultLowLatencyBudget,
+		DropPolicy:          DropPolicy_TAIL,
 	}
 }
 
 // Validate проверяет корректность конфигурации
+// Нулевые MTU, MaxStreams, ConnectionIdLength и HandshakeTimeout - не
+// заданы, подставляются значения по умолчанию. Недопустимые значения:
+// в строгом режиме - ошибка со списком всех таких полей (сами поля не
+// меняются), с Lenient - замена на значения по умолчанию или потолок
 func (c *Config) Validate() error {
-	if c.MTU < 576 || c.MTU > 1500 {
+	var errs []error
+
+	// invalid - поле field со значением value вне want: ошибка или
+	// исправление fix
+	invalid := func(field string, value any, want string, fix func()) {
+		if c.Lenient {
+			fix()
+			return
+		}
+		errs = append(errs, fmt.Errorf("%s %v: %s", field, value, want))
+	}
+
+	if c.MTU == 0 {
 		c.MTU = 1400
+	} else if c.MTU < 576 || c.MTU > 1500 {
+		invalid("mtu", c.MTU, "want 576-1500", func() { c.MTU = 1400 })
 	}
-	if c.MaxStreams == 0 || c.MaxStreams > 256 {
+	if c.MaxStreams == 0 {
 		c.MaxStreams = 16
+	} else if c.MaxStreams > 256 {
+		invalid("maxStreams", c.MaxStreams, "want 1-256", func() { c.MaxStreams = 16 })
 	}
-	if c.ConnectionIdLength < 4 || c.ConnectionIdLength > 20 {
+	if c.ConnectionIdLength == 0 {
 		c.ConnectionIdLength = 8
+	} else if c.ConnectionIdLength < 4 || c.ConnectionIdLength > 20 {
+		invalid("connectionIdLength", c.ConnectionIdLength, "want 4-20", func() { c.ConnectionIdLength = 8 })
 	}
 	if c.PaddingMinSize > c.PaddingMaxSize {
-		c.PaddingMinSize = 40
-		c.PaddingMaxSize = 200
+		invalid("paddingMinSize", c.PaddingMinSize, fmt.Sprintf("above paddingMaxSize %d", c.PaddingMaxSize), func() {
+			c.PaddingMinSize = 40
+			c.PaddingMaxSize = 200
+		})
 	}
 	if c.HandshakeTimeout == 0 {
 		c.HandshakeTimeout = 5
 	}
-	return nil
+	if c.DscpHigh > MaxDscp {
+		invalid("dscpHigh", c.DscpHigh, "want 0-63", func() { c.DscpHigh = DefaultDscpHigh })
+	}
+	if c.DscpMedium > MaxDscp {
+		invalid("dscpMedium", c.DscpMedium, "want 0-63", func() { c.DscpMedium = DefaultDscpMedium })
+	}
+	if c.DscpLow > MaxDscp {
+		invalid("dscpLow", c.DscpLow, "want 0-63", func() { c.DscpLow = DefaultDscpLow })
+	}
+	if c.CoalesceWindow > MaxCoalesceWindow {
+		invalid("coalesceWindow", c.CoalesceWindow, fmt.Sprintf("want at most %d", MaxCoalesceWindow), func() { c.CoalesceWindow = MaxCoalesceWindow })
+	}
+	if c.InboundWait > MaxInboundWait {
+		invalid("inboundWait", c.InboundWait, fmt.Sprintf("want at most %d", MaxInboundWait), func() { c.InboundWait = MaxInboundWait })
+	}
+	if c.Ttl > 255 {
+		invalid("ttl", c.Ttl, "want 0-255", func() { c.Ttl = 0 })
+	}
+	if c.Dscp > MaxDscp {
+		invalid("dscp", c.Dscp, "want 0-63", func() { c.Dscp = 0 })
+	}
+	if c.PortRange != "" {
+		if _, _, err := parsePortRange(c.PortRange); err != nil {
+			invalid("portRange", c.PortRange, fmt.Sprintf("want \"port\" or \"lo-hi\", at most %d ports", maxPortRange), func() { c.PortRange = "" })
+		}
+	}
+	if c.HandshakeMaxSize > MaxPacketSize {
+		invalid("handshakeMaxSize", c.HandshakeMaxSize, fmt.Sprintf("want at most %d", MaxPacketSize), func() { c.HandshakeMaxSize = MaxPacketSize })
+	}
+	if c.HandshakeMinSize > MaxPacketSize {
+		invalid("handshakeMinSize", c.HandshakeMinSize, fmt.Sprintf("want at most %d", MaxPacketSize), func() { c.HandshakeMinSize = quicInitialMinSize })
+	}
+	if c.HandshakeMaxSize > 0 && c.HandshakeMinSize > c.HandshakeMaxSize {
+		invalid("handshakeMinSize", c.HandshakeMinSize, fmt.Sprintf("above handshakeMaxSize %d", c.HandshakeMaxSize), func() {
+			c.HandshakeMinSize = 0
+			c.HandshakeMaxSize = 0
+		})
+	}
+	if c.TracingEndpoint != "" {
+		if _, err := tracingURL(c.TracingEndpoint); err != nil {
+			invalid("tracingEndpoint", c.TracingEndpoint, "want http(s)://host:port", func() { c.TracingEndpoint = "" })
+		}
+	}
+	if c.AuditLog != "" {
+		if _, err := auditTarget(c.AuditLog); err != nil {
+			invalid("auditLog", c.AuditLog, "want file path, \"syslog\" or syslog://host:port", func() { c.AuditLog = "" })
+		}
+	}
+	if c.SendBatch > MaxSendBatch {
+		invalid("sendBatch", c.SendBatch, fmt.Sprintf("want at most %d", MaxSendBatch), func() { c.SendBatch = MaxSendBatch })
+	}
+	if c.RecvBatch > MaxRecvBatch {
+		invalid("recvBatch", c.RecvBatch, fmt.Sprintf("want at most %d", MaxRecvBatch), func() { c.RecvBatch = MaxRecvBatch })
+	}
+	if c.HubGroup != "" && c.UpgradeSocket != "" {
+		invalid("hubGroup", c.HubGroup, "not supported with upgradeSocket", func() { c.HubGroup = "" })
+	}
+	if c.KeepAliveMax > maxNATProbeGap {
+		invalid("keepAliveMax", c.KeepAliveMax, fmt.Sprintf("want at most %d", maxNATProbeGap), func() { c.KeepAliveMax = 0 })
+	}
+	if c.ClusterNode > maxClusterNode {
+		invalid("clusterNode", c.ClusterNode, fmt.Sprintf("want 0-%d", maxClusterNode), func() { c.ClusterNode = 0 })
+	}
+	if len(c.ClusterPeers) > 0 && c.ClusterListen == "" {
+		invalid("clusterPeers", c.ClusterPeers, "requires clusterListen", func() { c.ClusterPeers = nil })
+	}
+	if c.ClusterListen != "" && c.CompactHeaders {
+		invalid("clusterListen", c.ClusterListen, "not supported with compactHeaders", func() { c.ClusterListen = "" })
+	}
+	if c.LbServerId != "" {
+		if err := validLBServerID(c.LbServerId, c.ConnectionIdLength); err != nil {
+			invalid("lbServerId", c.LbServerId, err.Error(), func() { c.LbServerId = "" })
+		} else if !c.IssueConnectionIds {
+			invalid("lbServerId", c.LbServerId, "requires issueConnectionIds", func() { c.LbServerId = "" })
+		} else if c.CompactHeaders {
+			invalid("lbServerId", c.LbServerId, "not supported with compactHeaders", func() { c.LbServerId = "" })
+		}
+	}
+	if c.LbConfigId > maxLBConfigID {
+		invalid("lbConfigId", c.LbConfigId, fmt.Sprintf("want 0-%d", maxLBConfigID), func() { c.LbConfigId = 0 })
+	}
+	if c.ClassSockets && c.Multipath {
+		invalid("classSockets", c.ClassSockets, "not supported with multipath", func() { c.ClassSockets = false })
+	}
+	if c.ListenFd > 0 && c.ListenFd < 3 {
+		invalid("listenFd", c.ListenFd, "want 3 or above, 0-2 are stdio", func() { c.ListenFd = 0 })
+	}
+	if err := validUsers(c.Users); err != nil {
+		invalid("users", len(c.Users), err.Error(), func() { c.Users = nil })
+	}
+	if c.ApiListen != "" && c.ApiToken == "" && !loopbackAddr(c.ApiListen) {
+		invalid("apiListen", c.ApiListen, "requires apiToken unless on loopback", func() { c.ApiListen = "" })
+	}
+	if c.DebugListen != "" && !loopbackAddr(c.DebugListen) {
+		invalid("debugListen", c.DebugListen, "want a loopback address, pprof has no authorization", func() { c.DebugListen = "" })
+	}
+	return errors.Join(errs...)
 }
 
 // GetMaxPayloadSize возвращает максимальный размер полезной нагрузки
 // с учётом заголовков GameTunnel и обфускации
 func (c *Config) GetMaxPayloadSize() uint32 {
+	return c.maxPayloadFor(c.MTU)
+}
+
+// maxPayloadFor - размер полезной нагрузки пакета GameTunnel длиной mtu
+// (без обёртки обфускации); измеренный MTU пути передаёт mtu.go
+func (c *Config) maxPayloadFor(mtu uint32) uint32 {
+	overhead := c.packetOverhead()
+
+	// Защита от underflow: если overhead >= MTU, возвращаем минимум
+	if overhead >= mtu {
+		return 256
+	}
+
+	maxTotal := mtu - overhead
+	if maxTotal > 1200 {
+		maxTotal = 1200
+	}
+	return maxTotal
+}
+
+// packetOverhead - заголовок, auth tag и худший padding пакета GameTunnel
+func (c *Config) packetOverhead() uint32 {
 	// Заголовок GameTunnel: flags(1) + version(4) + connID(var) + pktNum(4) + payloadLen(2)
 	headerSize := uint32(1 + 4 + c.ConnectionIdLength + 4 + 2)
 	// Auth tag: Poly1305 = 16 байт
@@ -168,19 +708,28 @@ func (c *Config) GetMaxPayloadSize() uint32 {
 	if c.EnablePadding {
 		maxPaddingOverhead = c.PaddingMaxSize + 2
 	}
-
-	overhead := headerSize + authTagSize + maxPaddingOverhead
-
-	// Защита от underflow: если overhead >= MTU, возвращаем минимум
-	if overhead >= c.MTU {
-		return 256
+	// Метки времени в открытом тексте DATA (timestamps.go)
+	timestampOverhead := uint32(0)
+	if c.Timestamps {
+		timestampOverhead = timestampSize
 	}
+	return headerSize + authTagSize + maxPaddingOverhead + timestampOverhead
+}
 
-	maxTotal := c.MTU - overhead
-	if maxTotal > 1200 {
-		maxTotal = 1200
+// handshakeSizeRange - диапазон размера датаграмм хэндшейка режима
+// mode (0, 0 - без добивки)
+func (c *Config) handshakeSizeRange(mode ObfuscationMode) (lo, hi int) {
+	switch {
+	case c.HandshakeMinSize == 0 && c.HandshakeMaxSize == 0:
+		if mode == ObfuscationMode_WEBRTC_MIMIC || mode == ObfuscationMode_RAW {
+			return 0, 0
+		}
+		return quicInitialMinSize, quicInitialMaxSize
+	case c.HandshakeMaxSize == 0:
+		return int(c.HandshakeMinSize), int(c.HandshakeMinSize)
+	default:
+		return int(c.HandshakeMinSize), int(c.HandshakeMaxSize)
 	}
-	return maxTotal
 }
 
 // ObfuscationModeFromString парсит строковое значение режима обфускации
@@ -219,4 +768,4 @@ func init() {
 			return DefaultConfig()
 		},
 	)
-}
\ No newline at end of file
+}
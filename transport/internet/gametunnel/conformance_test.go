@@ -0,0 +1,245 @@
+package gametunnel
+
+import (
+	"context"
+	"crypto/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go"
+	"github.com/apernet/quic-go/qlog"
+	"github.com/apernet/quic-go/qlogwriter"
+	"github.com/pion/dtls/v3/pkg/protocol"
+	"github.com/pion/dtls/v3/pkg/protocol/recordlayer"
+
+	"github.com/xtls/xray-core/common/protocol/tls/cert"
+	"github.com/xtls/xray-core/transport/internet/tls"
+)
+
+// Совместимость обёрток с настоящими стеками: обёртку QUIC разбирает
+// сервер quic-go, обёртку DTLS - разбор record'ов pion/dtls. Тест
+// падает, если обфускатор начнёт слать пакеты, которые настоящий
+// стек отбросит ещё до расшифровки: ключей у них нет, так что
+// расшифровка - граница проверки.
+
+// quicDrops - причины отброшенных пакетов из qlog сервера quic-go
+type quicDrops struct {
+	mu      sync.Mutex
+	drops   []qlog.PacketDropped
+	nonQUIC int
+	changed chan struct{}
+}
+
+func (d *quicDrops) RecordEvent(event qlogwriter.Event) {
+	drop, ok := event.(qlog.PacketDropped)
+	if !ok {
+		return
+	}
+	d.mu.Lock()
+	d.drops = append(d.drops, drop)
+	d.mu.Unlock()
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (d *quicDrops) Close() error { return nil }
+
+func (d *quicDrops) AddProducer() qlogwriter.Recorder { return d }
+
+func (d *quicDrops) SupportsSchemas(string) bool { return true }
+
+// wait ждёт отброса очередного пакета и возвращает его
+func (d *quicDrops) wait(t *testing.T, seen int) qlog.PacketDropped {
+	t.Helper()
+
+	deadline := time.After(2 * time.Second)
+	for {
+		d.mu.Lock()
+		if len(d.drops) > seen {
+			drop := d.drops[seen]
+			d.mu.Unlock()
+			return drop
+		}
+		nonQUIC := d.nonQUIC
+		d.mu.Unlock()
+		if nonQUIC > 0 {
+			t.Fatal("quic-go classified the datagram as non-QUIC")
+		}
+		select {
+		case <-d.changed:
+		case <-time.After(10 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("quic-go neither accepted nor dropped the datagram")
+		}
+	}
+}
+
+// startQUICServer поднимает сервер quic-go; отброшенные им пакеты
+// пишутся в quicDrops
+func startQUICServer(t *testing.T, connIDLen int) (*net.UDPConn, *quicDrops) {
+	t.Helper()
+
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drops := &quicDrops{changed: make(chan struct{}, 1)}
+	tr := &quic.Transport{Conn: sock, ConnectionIDLength: connIDLen, Tracer: drops}
+	t.Cleanup(func() { tr.Close() })
+
+	ct, _ := cert.MustGenerate(nil, cert.CommonName("localhost"))
+	tlsConfig := (&tls.Config{Certificate: []*tls.Certificate{tls.ParseCertificate(ct)}}).GetTLSConfig()
+	tlsConfig.NextProtos = []string{"h3"}
+	ln, err := tr.Listen(tlsConfig, &quic.Config{
+		Tracer: func(context.Context, bool, quic.ConnectionID) qlogwriter.Trace { return drops },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	// Датаграммы без бита QUIC транспорт отдаёт сюда
+	go func() {
+		buf := make([]byte, MaxPacketSize)
+		for {
+			if _, _, err := tr.ReadNonQUICPacket(context.Background(), buf); err != nil {
+				return
+			}
+			drops.mu.Lock()
+			drops.nonQUIC++
+			drops.mu.Unlock()
+		}
+	}()
+
+	client, err := net.DialUDP("udp", nil, sock.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, drops
+}
+
+// conformancePackets - пакеты всех типов с новым Connection ID
+func conformancePackets(t *testing.T, config *Config) (hello []byte, rest [][]byte) {
+	t.Helper()
+
+	connID := make([]byte, config.ConnectionIdLength)
+	rand.Read(connID)
+	marshal := func(typ PacketType, pktNum uint32, payload []byte) []byte {
+		packet, err := (&Packet{Type: typ, ConnectionID: connID, PacketNumber: pktNum, Payload: payload}).Marshal(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+
+	ephemeral, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := &HandshakePayload{PublicKey: ephemeral.PublicKey, Timestamp: uint64(time.Now().UnixMilli())}
+	rand.Read(payload.Random[:])
+	hello = marshal(PacketType_HANDSHAKE, 0, payload.Marshal())
+	rest = [][]byte{
+		marshal(PacketType_DATA, 1, make([]byte, 64)),
+		marshal(PacketType_DATA, 2, make([]byte, 1100)),
+		marshal(PacketType_KEEPALIVE, 3, nil),
+		marshal(PacketType_CONTROL, 4, make([]byte, 17)),
+	}
+	return hello, rest
+}
+
+func TestQUICMimicConformance(t *testing.T) {
+	config := DefaultConfig()
+	client, drops := startQUICServer(t, int(config.ConnectionIdLength))
+	obfs := NewObfuscator(ObfuscationMode_QUIC_MIMIC, config)
+
+	// Обёртки случайны (SCID, добивка): несколько соединений
+	seen := 0
+	for i := 0; i < 8; i++ {
+		hello, rest := conformancePackets(t, config)
+
+		// Client Hello сервер принимает как Initial и создаёт
+		// соединение: отброшен он только при расшифровке
+		wrapped, err := wrapHandshake(obfs, hello, MaxPacketSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write(wrapped)
+		drop := drops.wait(t, seen)
+		seen++
+		if drop.Trigger != qlog.PacketDropPayloadDecryptError || drop.Header.PacketType != qlog.PacketTypeInitial {
+			t.Fatalf("client hello (%d bytes) dropped as %s %s, want a rejected Initial payload",
+				len(wrapped), drop.Header.PacketType, drop.Trigger)
+		}
+
+		// Остальные пакеты идут в это соединение: заголовок и версию
+		// стек разбирает, ключей для них у него нет
+		for _, packet := range rest {
+			wrapped, err := obfs.Wrap(packet)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Write(wrapped)
+			drop := drops.wait(t, seen)
+			seen++
+			switch drop.Trigger {
+			case qlog.PacketDropHeaderParseError, qlog.PacketDropUnexpectedVersion, qlog.PacketDropUnsupportedVersion:
+				t.Fatalf("type %d packet (%d bytes) dropped as %s", packet[0]>>FlagTypeShift&0x03, len(wrapped), drop.Trigger)
+			}
+		}
+	}
+}
+
+func TestWebRTCMimicConformance(t *testing.T) {
+	config := DefaultConfig()
+	obfs := NewObfuscator(ObfuscationMode_WEBRTC_MIMIC, config)
+	hello, rest := conformancePackets(t, config)
+
+	// parse - датаграмма одним record'ом DTLS 1.2
+	parse := func(name string, wrapped []byte) recordlayer.Header {
+		records, err := recordlayer.UnpackDatagram(wrapped)
+		if err != nil || len(records) != 1 {
+			t.Fatalf("%s: %d records: %v", name, len(records), err)
+		}
+		var header recordlayer.Header
+		if err := header.Unmarshal(records[0]); err != nil {
+			t.Fatalf("%s: record header: %v", name, err)
+		}
+		if header.Version != protocol.Version1_2 {
+			t.Fatalf("%s: version %v", name, header.Version)
+		}
+		return header
+	}
+
+	// Тело record'а Handshake - не сообщение хэндшейка DTLS (obfs.go),
+	// проверяется только record
+	wrapped, err := wrapHandshake(obfs, hello, MaxPacketSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := parse("client hello", wrapped); header.ContentType != protocol.ContentTypeHandshake || header.Epoch != 0 {
+		t.Errorf("client hello: content type %d epoch %d, want handshake in epoch 0", header.ContentType, header.Epoch)
+	}
+
+	for _, packet := range rest {
+		wrapped, err := obfs.Wrap(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		header := parse("data", wrapped)
+		var record recordlayer.RecordLayer
+		if err := record.Unmarshal(wrapped); err != nil {
+			t.Fatalf("data record: %v", err)
+		}
+		// Application Data в epoch 0 pion отбрасывает: до смены
+		// ключей данных не бывает
+		if header.ContentType != protocol.ContentTypeApplicationData || header.Epoch == 0 {
+			t.Errorf("data: content type %d epoch %d, want application data after epoch 0", header.ContentType, header.Epoch)
+		}
+	}
+}
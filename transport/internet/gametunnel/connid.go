@@ -0,0 +1,159 @@
+package gametunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Connection ID, выданные сервером (issueConnectionIds)
+// ====================================================================
+//
+// По умолчанию Connection ID выбирает клиент. Совпадение ID двух
+// клиентов не ломает чужую сессию - Client Hello с другим ключом
+// для занятого ID отклоняется (handleExistingHandshake) и
+// учитывается в connectionIdCollisions, - но второй клиент
+// не получает ответа и ждёт handshakeTimeout.
+//
+// С issueConnectionIds = true ID сессии выбирает сервер, как
+// DCID в QUIC:
+//
+//	клиент -> сервер: HANDSHAKE [initial ID]  Client Hello
+//	сервер -> клиент: HANDSHAKE [initial ID]  Server Hello + issued ID
+//	дальше в обе стороны:       [issued ID]
+//
+// Выданный ID проверяется на уникальность при регистрации: при
+// совпадении сервер выбирает другой. Initial ID клиента служит
+// только для сопоставления повторов Client Hello с сессией
+// (вместе с ключом клиента, поэтому совпадение initial ID двух
+// клиентов безопасно) и пакеты данных не маршрутизирует.
+//
+// Issued ID передаётся в хвосте payload Server Hello, после
+// полей HandshakePayload. Клиенты принимают его всегда; старые
+// клиенты хвост игнорируют и продолжают со своим ID, поэтому
+// включать режим на сервере можно только после обновления клиентов.
+//
+// С lbServerId выданный ID несёт ID сервера для балансировщика
+// (lbroute.go).
+//
+// ====================================================================
+
+// maxIssueAttempts - попыток выбрать свободный ID для сессии
+const maxIssueAttempts = 8
+
+// helloAliases - сессии по initial ID и ключу клиента
+type helloAliases struct {
+	sessions map[string]*Session
+	mu       sync.Mutex
+}
+
+// newHelloAliases создаёт пустую таблицу
+func newHelloAliases() *helloAliases {
+	return &helloAliases{sessions: make(map[string]*Session)}
+}
+
+// helloAliasKey - ключ таблицы: initial ID + ключ клиента
+func helloAliasKey(initialID []byte, publicKey [Curve25519KeySize]byte) string {
+	return string(initialID) + string(publicKey[:])
+}
+
+// get возвращает сессию Client Hello (nil, если нет)
+func (a *helloAliases) get(key string) *Session {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.sessions[key]
+}
+
+// putIfAbsent регистрирует сессию под ключом, если он свободен
+// Возвращает зарегистрированную сессию и true, если добавлена session
+func (a *helloAliases) putIfAbsent(key string, session *Session) (*Session, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if existing, ok := a.sessions[key]; ok {
+		return existing, false
+	}
+	a.sessions[key] = session
+	return session, true
+}
+
+// remove удаляет ключ, если он всё ещё указывает на session
+func (a *helloAliases) remove(key string, session *Session) {
+	a.mu.Lock()
+	if a.sessions[key] == session {
+		delete(a.sessions, key)
+	}
+	a.mu.Unlock()
+}
+
+// routeIssuedHello обрабатывает Client Hello в режиме issueConnectionIds:
+// повтор отвечает Server Hello существующей сессии, новый - создаёт её
+func (h *Hub) routeIssuedHello(sock *dscpMarker, data []byte, initialID []byte, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
+	pkt, err := Unmarshal(data, int(h.getConfig().ConnectionIdLength))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal handshake: %w", err)
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal handshake payload: %w", err)
+	}
+
+	if session := h.aliases.get(helloAliasKey(initialID, hello.PublicKey)); session != nil {
+		return h.handleExistingHandshake(session, data)
+	}
+	return h.admitHandshake(sock, data, initialID, remoteAddr)
+}
+
+// registerIssued регистрирует новую сессию под выданным сервером ID
+// Возвращает существующую сессию, если копия того же Client Hello
+// успела зарегистрироваться раньше
+func (h *Hub) registerIssued(session *Session) (*Session, error) {
+	session.initialID = session.ID
+	session.aliasKey = helloAliasKey(session.initialID, session.peerPublicKey)
+
+	// Сначала окончательный ID, потом псевдоним: найденная по
+	// псевдониму сессия уже не меняется
+	config := h.getConfig()
+	registered := false
+	for attempt := 0; attempt < maxIssueAttempts && !registered; attempt++ {
+		id, err := issueConnectionID(config)
+		if err != nil {
+			return nil, err
+		}
+		session.ID = id
+		if _, registered = h.sessions.putIfAbsent(session); !registered {
+			h.connIDCollision()
+		}
+	}
+	if !registered {
+		return nil, fmt.Errorf("no free connection ID after %d attempts", maxIssueAttempts)
+	}
+
+	if existing, created := h.aliases.putIfAbsent(session.aliasKey, session); !created {
+		// Выданный ID ещё никому не известен - снимаем без уведомлений
+		h.sessions.remove(session.ID)
+		return existing, nil
+	}
+	return nil, nil
+}
+
+// helloConnectionID - ID заголовка Server Hello: тот, что знает клиент
+func (s *Session) helloConnectionID() []byte {
+	if s.initialID != nil {
+		return s.initialID
+	}
+	return s.ID
+}
+
+// connIDCollision учитывает совпадение Connection ID
+func (h *Hub) connIDCollision() {
+	atomic.AddUint64(&h.idCollisions, 1)
+}
+
+// GetConnectionIDCollisions возвращает число совпадений Connection ID:
+// Client Hello другого клиента для занятого ID и повторные выборы ID
+func (h *Hub) GetConnectionIDCollisions() uint64 {
+	return atomic.LoadUint64(&h.idCollisions)
+}
@@ -0,0 +1,188 @@
+package gametunnel
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// readServerHelloIssued читает Server Hello и возвращает ID заголовка
+// и payload (с выданным ID)
+func readServerHelloIssued(t *testing.T, h *Hub, conn *net.UDPConn) ([]byte, *HandshakePayload) {
+	t.Helper()
+
+	buf := make([]byte, MaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Server Hello not received: %v", err)
+	}
+	data, _ := h.obfs.Unwrap(buf[:n])
+	pkt, err := Unmarshal(data, int(h.getConfig().ConnectionIdLength))
+	if err != nil || pkt.Type != PacketType_HANDSHAKE {
+		t.Fatalf("Not a Server Hello: %v", err)
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkt.ConnectionID, hello
+}
+
+func TestIssuedConnectionIDLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "connid"
+	config.IssueConnectionIds = true
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	session := l.hub.GetSession(client.session().ConnectionID)
+	if session == nil || session.initialID == nil {
+		t.Fatal("Client does not use the server-issued ID")
+	}
+	if bytes.Equal(session.initialID, session.ID) {
+		t.Error("Issued ID equals the client's initial ID")
+	}
+
+	client.Write([]byte("ping"))
+	if got := readWithTimeout(t, server, 4); string(got) != "ping" {
+		t.Fatalf("Server got %q", got)
+	}
+	server.Write([]byte("pong"))
+	if got := readWithTimeout(t, client, 4); string(got) != "pong" {
+		t.Fatalf("Client got %q", got)
+	}
+
+	l.hub.RemoveSession(session.ID)
+	if l.hub.aliases.get(session.aliasKey) != nil {
+		t.Error("Hello alias outlived the session")
+	}
+}
+
+func TestIssuedConnectionIDRetransmit(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "connid"
+	config.IssueConnectionIds = true
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	client := newHelloClient(t)
+	addr := client.LocalAddr().(*net.UDPAddr)
+
+	keyPair, _ := GenerateKeyPair()
+	initialID := []byte{1, 0, 1, 0, 1, 0, 1, 0}
+	hello := clientHello(t, h, initialID, keyPair.PublicKey)
+
+	h.RoutePacket(hello, addr)
+	headerID, first := readServerHelloIssued(t, h, client)
+	if !bytes.Equal(headerID, initialID) {
+		t.Errorf("Server Hello header %x, want client's ID %x", headerID, initialID)
+	}
+	if len(first.IssuedID) != int(config.ConnectionIdLength) {
+		t.Fatalf("Issued ID %x", first.IssuedID)
+	}
+
+	// Повтор Client Hello получает тот же ключ и тот же ID
+	h.RoutePacket(hello, addr)
+	_, second := readServerHelloIssued(t, h, client)
+	if second.PublicKey != first.PublicKey || !bytes.Equal(second.IssuedID, first.IssuedID) {
+		t.Error("Retransmitted hello got a different answer")
+	}
+	if h.GetActiveSessions() != 1 {
+		t.Fatalf("active=%d, want 1", h.GetActiveSessions())
+	}
+
+	// Пакеты маршрутизируются только по выданному ID
+	if h.GetSession(initialID) != nil || h.GetSession(first.IssuedID) == nil {
+		t.Error("Session not registered under the issued ID")
+	}
+}
+
+func TestIssuedConnectionIDSameInitialID(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "connid"
+	config.IssueConnectionIds = true
+	l, _ := startTestListener(t, config)
+	h := l.hub
+
+	// Два клиента случайно выбрали один и тот же ID
+	initialID := []byte{7, 7, 7, 7, 7, 7, 7, 7}
+	var issued [][]byte
+	for i := 0; i < 2; i++ {
+		client := newHelloClient(t)
+		keyPair, _ := GenerateKeyPair()
+		if _, _, err := h.RoutePacket(clientHello(t, h, initialID, keyPair.PublicKey), client.LocalAddr().(*net.UDPAddr)); err != nil {
+			t.Fatalf("Client %d: %v", i, err)
+		}
+		_, hello := readServerHelloIssued(t, h, client)
+		issued = append(issued, hello.IssuedID)
+	}
+
+	if bytes.Equal(issued[0], issued[1]) || h.GetActiveSessions() != 2 {
+		t.Errorf("issued=%x active=%d", issued, h.GetActiveSessions())
+	}
+}
+
+func TestClientChosenIDCollisionCounted(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "connid"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+
+	connID := []byte{8, 8, 8, 8, 8, 8, 8, 8}
+	first, _ := GenerateKeyPair()
+	second, _ := GenerateKeyPair()
+	h.RoutePacket(clientHello(t, h, connID, first.PublicKey), newHelloClient(t).LocalAddr().(*net.UDPAddr))
+	if _, _, err := h.RoutePacket(clientHello(t, h, connID, second.PublicKey), newHelloClient(t).LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Error("Colliding hello accepted")
+	}
+	if got := h.GetStats().IDCollisions; got != 1 {
+		t.Errorf("collisions=%d, want 1", got)
+	}
+}
+
+func TestIssuedConnectionIDConcurrentHellos(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "connid"
+	config.IssueConnectionIds = true
+	config.MaxSessionsPerIp = 1
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	client := newHelloClient(t)
+	addr := client.LocalAddr().(*net.UDPAddr)
+
+	keyPair, _ := GenerateKeyPair()
+	hello := clientHello(t, h, []byte{2, 4, 6, 8, 2, 4, 6, 8}, keyPair.PublicKey)
+
+	const copies = 8
+	var wg sync.WaitGroup
+	for i := 0; i < copies; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.RoutePacket(hello, addr)
+		}()
+	}
+	wg.Wait()
+
+	_, first := readServerHelloIssued(t, h, client)
+	for i := 1; i < copies; i++ {
+		if _, next := readServerHelloIssued(t, h, client); !bytes.Equal(next.IssuedID, first.IssuedID) {
+			t.Fatal("Concurrent hellos produced different sessions")
+		}
+	}
+	if h.GetActiveSessions() != 1 {
+		t.Fatalf("active=%d, want 1", h.GetActiveSessions())
+	}
+
+	// Проигравшие копии вернули слот IP
+	h.RemoveSession(first.IssuedID)
+	next, _ := GenerateKeyPair()
+	if _, _, err := h.RoutePacket(clientHello(t, h, []byte{1, 3, 5, 7, 1, 3, 5, 7}, next.PublicKey), addr); err != nil {
+		t.Fatalf("IP slot leaked: %v", err)
+	}
+}
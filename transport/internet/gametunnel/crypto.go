@@ -2,6 +2,7 @@ package gametunnel
 
 import (
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/binary"
@@ -29,6 +30,10 @@ import (
 //     - Server → Client key
 //   - Каждое направление имеет свой ключ (предотвращает reflection attacks)
 //
+// Подлинность Server Hello: HMAC-SHA256 с ключом PSK сессии
+//   - Занимает поле Random и покрывает ключ клиента и весь payload
+//   - Без PSK чужой ключ сервера клиенту не подсунуть
+//
 // Шифрование: ChaCha20-Poly1305 (RFC 8439)
 //   - AEAD: шифрование + аутентификация в одном
 //   - Nonce: 12 байт = 8 байт zeros + 4 байта Packet Number
@@ -53,6 +58,13 @@ const (
 	// HKDFInfoServer - HKDF info для ключа шифрования сервер → клиент
 	HKDFInfoServer = "gametunnel server-to-client"
 
+	// serverHelloInfo - контекст HMAC Server Hello (sealServerHello)
+	serverHelloInfo = "gametunnel server hello"
+
+	// handshakeControlInfo - контекст HMAC BUSY и RETRY
+	// (sealHandshakeControl)
+	handshakeControlInfo = "gametunnel handshake control"
+
 	// HKDFSalt - статическая соль для HKDF
 	// В реальном протоколе можно обновлять при ротации ключей
 	HKDFSalt = "GameTunnel-v1-salt"
@@ -92,8 +104,35 @@ type HandshakePayload struct {
 	// Используется для защиты от replay старых хэндшейков
 	Timestamp uint64
 
-	// Random - 32 случайных байта для энтропии
+	// Random - 32 случайных байта для энтропии; в Server Hello -
+	// подпись ответа (sealServerHello)
 	Random [32]byte
+
+	// IssuedID - Connection ID, выданный сервером (только Server Hello,
+	// пусто = клиент продолжает со своим ID, connid.go)
+	IssuedID []byte
+
+	// Capabilities - байт возможностей после IssuedID (0 = не передаётся,
+	// mux.go)
+	Capabilities byte
+
+	// ResumeKey - ключ возобновления сервера (Server Hello с
+	// helloCapResume, zerortt.go)
+	// EarlyKeyID - ID ключа возобновления: в Client Hello - ключ ранних
+	// данных, в Server Hello - ранние данные приняты (helloCapEarly)
+	ResumeKey  []byte
+	EarlyKeyID []byte
+
+	// CompactID - номер контекста сжатия заголовков (Server Hello с
+	// helloCapCompact, compact.go)
+	CompactID []byte
+
+	// UserID - ID пользователя (Client Hello с helloCapUser, users.go)
+	UserID []byte
+
+	// Puzzle - решение задачи сервера: токен и nonce (Client Hello с
+	// helloCapPuzzle, puzzle.go)
+	Puzzle []byte
 }
 
 // GenerateKeyPair создаёт новую пару ключей Curve25519
@@ -153,6 +192,11 @@ func ComputeSharedSecret(myPrivate, theirPublic [Curve25519KeySize]byte) ([Curve
 //   - Client: SendKey = client-to-server, RecvKey = server-to-client
 //   - Server: SendKey = server-to-client, RecvKey = client-to-server
 func DeriveSessionKeys(sharedSecret [Curve25519KeySize]byte, psk string, isClient bool) (*SessionKeys, error) {
+	return deriveKeys(sharedSecret, psk, isClient, HKDFInfoClient, HKDFInfoServer)
+}
+
+// deriveKeys - DeriveSessionKeys с заданными HKDF info направлений
+func deriveKeys(sharedSecret [Curve25519KeySize]byte, psk string, isClient bool, infoClient, infoServer string) (*SessionKeys, error) {
 	// Формируем входной ключевой материал: sharedSecret + PSK (если есть)
 	ikm := make([]byte, Curve25519KeySize)
 	copy(ikm, sharedSecret[:])
@@ -176,13 +220,13 @@ func DeriveSessionKeys(sharedSecret [Curve25519KeySize]byte, psk string, isClien
 	serverToClientKey := make([]byte, KeySize)
 
 	// Ключ клиент → сервер
-	hkdfReader := hkdf.New(sha256.New, ikm, salt, []byte(HKDFInfoClient))
+	hkdfReader := hkdf.New(sha256.New, ikm, salt, []byte(infoClient))
 	if _, err := io.ReadFull(hkdfReader, clientToServerKey); err != nil {
 		return nil, fmt.Errorf("derive client-to-server key: %w", err)
 	}
 
 	// Ключ сервер → клиент
-	hkdfReader = hkdf.New(sha256.New, ikm, salt, []byte(HKDFInfoServer))
+	hkdfReader = hkdf.New(sha256.New, ikm, salt, []byte(infoServer))
 	if _, err := io.ReadFull(hkdfReader, serverToClientKey); err != nil {
 		return nil, fmt.Errorf("derive server-to-client key: %w", err)
 	}
@@ -216,22 +260,35 @@ func DeriveSessionKeys(sharedSecret [Curve25519KeySize]byte, psk string, isClien
 // packetNumber используется для построения nonce
 // additionalData - заголовок пакета (аутентифицируется, но не шифруется)
 func (sk *SessionKeys) Encrypt(payload []byte, packetNumber uint32, additionalData []byte) ([]byte, error) {
+	return sk.EncryptTo(nil, payload, packetNumber, additionalData)
+}
+
+// EncryptTo дописывает шифротекст payload в dst и возвращает
+// расширенный срез (dst и payload не должны пересекаться, кроме
+// dst[:0] == payload[:0])
+func (sk *SessionKeys) EncryptTo(dst, payload []byte, packetNumber uint32, additionalData []byte) ([]byte, error) {
 	nonce := buildNonce(packetNumber)
 
 	// ChaCha20-Poly1305 AEAD:
 	// - Шифрует payload
 	// - Аутентифицирует additionalData + payload
 	// - Добавляет 16-байтный Poly1305 tag
-	ciphertext := sk.sendCipher.Seal(nil, nonce, payload, additionalData)
+	ciphertext := sk.sendCipher.Seal(dst, nonce, payload, additionalData)
 
 	return ciphertext, nil
 }
 
 // Decrypt расшифровывает payload пакета
 func (sk *SessionKeys) Decrypt(ciphertext []byte, packetNumber uint32, additionalData []byte) ([]byte, error) {
+	return sk.DecryptTo(nil, ciphertext, packetNumber, additionalData)
+}
+
+// DecryptTo дописывает открытый текст в dst и возвращает расширенный
+// срез (правила пересечения - как у EncryptTo)
+func (sk *SessionKeys) DecryptTo(dst, ciphertext []byte, packetNumber uint32, additionalData []byte) ([]byte, error) {
 	nonce := buildNonce(packetNumber)
 
-	plaintext, err := sk.recvCipher.Open(nil, nonce, ciphertext, additionalData)
+	plaintext, err := sk.recvCipher.Open(dst, nonce, ciphertext, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("decrypt: authentication failed (possible tampering or wrong key)")
 	}
@@ -250,9 +307,12 @@ func buildNonce(packetNumber uint32) []byte {
 }
 
 // MarshalHandshake сериализует HandshakePayload в байты
-// Формат: [PublicKey 32][Timestamp 8][Random 32] = 72 байта
+// Формат: [PublicKey 32][Timestamp 8][Random 32] = 72 байта,
+// в Server Hello за ними может идти [IssuedID], в обоих - расширения
+// [ResumeKey][EarlyKeyID][CompactID][UserID][Puzzle] и [Capabilities]
 func (h *HandshakePayload) Marshal() []byte {
-	buf := make([]byte, Curve25519KeySize+8+32)
+	buf := make([]byte, Curve25519KeySize+8+32,
+		Curve25519KeySize+8+32+len(h.IssuedID)+len(h.ResumeKey)+len(h.EarlyKeyID)+len(h.CompactID)+len(h.UserID)+len(h.Puzzle)+1)
 	offset := 0
 
 	copy(buf[offset:], h.PublicKey[:])
@@ -263,6 +323,15 @@ func (h *HandshakePayload) Marshal() []byte {
 
 	copy(buf[offset:], h.Random[:])
 
+	buf = append(buf, h.IssuedID...)
+	buf = append(buf, h.ResumeKey...)
+	buf = append(buf, h.EarlyKeyID...)
+	buf = append(buf, h.CompactID...)
+	buf = append(buf, h.UserID...)
+	buf = append(buf, h.Puzzle...)
+	if h.Capabilities != 0 {
+		buf = append(buf, h.Capabilities)
+	}
 	return buf
 }
 
@@ -284,10 +353,62 @@ func UnmarshalHandshake(data []byte) (*HandshakePayload, error) {
 	offset += 8
 
 	copy(h.Random[:], data[offset:offset+32])
+	offset += 32
+
+	if len(data) > offset {
+		h.IssuedID = append([]byte(nil), data[offset:]...)
+	}
 
 	return h, nil
 }
 
+// splitCapabilities отделяет байт возможностей и расширения от хвоста
+// payload
+// UnmarshalHandshake кладёт весь хвост в IssuedID; ID всегда длины
+// connIDLen (не меньше 4), а длину расширений задают биты
+// возможностей, поэтому хвост оканчивается байтом возможностей, если
+// без него и расширений остаётся 0 или connIDLen байт
+// fromServer - разбирается Server Hello (расширения направлений разные)
+// allowed - биты, которые может нести хвост: Server Hello отвечает
+// только на запрошенные, иначе это последний байт выданного ID
+func (h *HandshakePayload) splitCapabilities(connIDLen int, fromServer bool, allowed byte) {
+	n := len(h.IssuedID)
+	if n == 0 {
+		return
+	}
+	caps := h.IssuedID[n-1]
+	if caps == 0 || caps&^allowed != 0 {
+		return
+	}
+	resumeLen, earlyLen, compactLen, userLen, puzzleLen := helloExtLens(caps, fromServer)
+	rest := n - 1 - resumeLen - earlyLen - compactLen - userLen - puzzleLen
+	if rest != 0 && rest != connIDLen {
+		return
+	}
+
+	tail := h.IssuedID
+	h.Capabilities = caps
+	if resumeLen > 0 {
+		h.ResumeKey = tail[rest : rest+resumeLen]
+	}
+	if earlyLen > 0 {
+		h.EarlyKeyID = tail[rest+resumeLen : rest+resumeLen+earlyLen]
+	}
+	if compactLen > 0 {
+		h.CompactID = tail[n-1-puzzleLen-userLen-compactLen : n-1-puzzleLen-userLen]
+	}
+	if userLen > 0 {
+		h.UserID = tail[n-1-puzzleLen-userLen : n-1-puzzleLen]
+	}
+	if puzzleLen > 0 {
+		h.Puzzle = tail[n-1-puzzleLen : n-1]
+	}
+	h.IssuedID = nil
+	if rest > 0 {
+		h.IssuedID = tail[:rest]
+	}
+}
+
 // NewHandshakePayload создаёт HandshakePayload с текущим временем
 func NewHandshakePayload(publicKey [Curve25519KeySize]byte, timestamp uint64) *HandshakePayload {
 	h := &HandshakePayload{
@@ -300,3 +421,65 @@ func NewHandshakePayload(publicKey [Curve25519KeySize]byte, timestamp uint64) *H
 
 	return h
 }
+
+// helloRandomOffset - смещение поля Random в payload хэндшейка
+const helloRandomOffset = Curve25519KeySize + 8
+
+// sealServerHello подписывает Server Hello: поле Random заменяется
+// HMAC-SHA256 с ключом psk от ключа клиента clientKey и остального
+// payload
+func sealServerHello(payload []byte, psk string, clientKey [Curve25519KeySize]byte) {
+	copy(payload[helloRandomOffset:], serverHelloMAC(payload, psk, clientKey))
+}
+
+// serverHelloAuthentic проверяет подпись Server Hello (sealServerHello)
+func serverHelloAuthentic(payload []byte, psk string, clientKey [Curve25519KeySize]byte) bool {
+	if len(payload) < helloRandomOffset+32 {
+		return false
+	}
+	return hmac.Equal(payload[helloRandomOffset:helloRandomOffset+32], serverHelloMAC(payload, psk, clientKey))
+}
+
+// handshakeControlMACSize - подпись BUSY и RETRY: HMAC-SHA256,
+// усечённый до 16 байт
+const handshakeControlMACSize = 16
+
+// sealHandshakeControl дописывает к payload BUSY или RETRY HMAC-SHA256
+// с ключом psk от ключа клиента clientKey и payload
+// Ключей сессии ещё нет: подпись, как у Server Hello, знает только
+// сторона с PSK, видевшая Client Hello
+func sealHandshakeControl(payload []byte, psk string, clientKey [Curve25519KeySize]byte) []byte {
+	return append(payload, handshakeControlMAC(payload, psk, clientKey)...)
+}
+
+// openHandshakeControl проверяет подпись BUSY или RETRY и возвращает
+// payload без неё; false - подпись не сошлась
+func openHandshakeControl(payload []byte, psk string, clientKey [Curve25519KeySize]byte) ([]byte, bool) {
+	n := len(payload) - handshakeControlMACSize
+	if n < 1 {
+		return nil, false
+	}
+	if !hmac.Equal(payload[n:], handshakeControlMAC(payload[:n], psk, clientKey)) {
+		return nil, false
+	}
+	return payload[:n], true
+}
+
+// handshakeControlMAC - усечённый HMAC payload BUSY или RETRY
+func handshakeControlMAC(payload []byte, psk string, clientKey [Curve25519KeySize]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(psk))
+	mac.Write([]byte(handshakeControlInfo))
+	mac.Write(clientKey[:])
+	mac.Write(payload)
+	return mac.Sum(nil)[:handshakeControlMACSize]
+}
+
+// serverHelloMAC - HMAC Server Hello без поля Random
+func serverHelloMAC(payload []byte, psk string, clientKey [Curve25519KeySize]byte) []byte {
+	mac := hmac.New(sha256.New, []byte(psk))
+	mac.Write([]byte(serverHelloInfo))
+	mac.Write(clientKey[:])
+	mac.Write(payload[:helloRandomOffset])
+	mac.Write(payload[helloRandomOffset+32:])
+	return mac.Sum(nil)
+}
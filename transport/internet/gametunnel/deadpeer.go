@@ -0,0 +1,195 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Обнаружение мёртвых клиентов (dead-peer detection)
+// ====================================================================
+//
+// Keep-alive шлёт только клиент. Если NAT-маппинг клиента умер,
+// сервер узнаёт об этом лишь через sessionTimeout и всё это время
+// продолжает ставить данные в очередь сессии.
+//
+// Хаб сам проверяет сессии, молчащие дольше deadPeerInterval:
+//
+//	сервер -> клиент: CONTROL [0x04][AEAD(token)]  - probe
+//	клиент -> сервер: CONTROL [0x05][AEAD(token)]  - ответ
+//
+// token - 8 случайных байт, каждая сторона шифрует своим ключом
+// отправки с заголовком пакета в качестве additional data (как
+// CLOSE в drain.go): ответить на probe может только владелец
+// ключей сессии.
+//
+// Любой пакет от клиента обнуляет счётчик проверок. Probe без
+// ответа переводит сессию в degraded (health.go), после
+// deadPeerProbes проверок без ответа сессия удаляется.
+//
+// Старые клиенты не отвечают на 0x04, но они и не молчат: их
+// keep-alive приходит чаще, чем deadPeerInterval по умолчанию
+// (2 x keepAliveInterval).
+//
+// ====================================================================
+
+const (
+	// probeTokenSize - размер токена проверки
+	probeTokenSize = 8
+
+	// defaultDeadPeerProbes - проверок без ответа до удаления сессии
+	defaultDeadPeerProbes = 3
+
+	// defaultDeadPeerInterval - период проверок при выключенном keep-alive
+	defaultDeadPeerInterval = 30 * time.Second
+)
+
+// deadPeerTiming возвращает период проверок и их предельное число
+func deadPeerTiming(config *Config) (time.Duration, uint32) {
+	interval := time.Duration(config.DeadPeerInterval) * time.Second
+	if interval == 0 {
+		interval = 2 * time.Duration(config.KeepAliveInterval) * time.Second
+	}
+	if interval == 0 {
+		interval = defaultDeadPeerInterval
+	}
+
+	probes := config.DeadPeerProbes
+	if probes == 0 {
+		probes = defaultDeadPeerProbes
+	}
+	return interval, probes
+}
+
+// probeInterval - период проверок по текущему снимку конфига: с
+// keepAliveInterval меняется и он (tunables.go)
+func (h *Hub) probeInterval() time.Duration {
+	interval, _ := deadPeerTiming(h.getConfig())
+	return interval
+}
+
+// deadPeerLoop периодически проверяет молчащие сессии
+func (h *Hub) deadPeerLoop() {
+	interval := h.probeInterval()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h.probeIdleSessions(time.Now())
+		if next := h.probeInterval(); next != interval {
+			interval = next
+			ticker.Reset(interval)
+		}
+	}
+}
+
+// probeIdleSessions шлёт probe сессиям, молчащим дольше probeInterval,
+// и удаляет те, что не ответили на probeLimit проверок подряд
+func (h *Hub) probeIdleSessions(now time.Time) {
+	var dead [][]byte
+	probeInterval := h.probeInterval()
+
+	for _, session := range h.sessions.snapshot() {
+		session.mu.RLock()
+		idle := now.Sub(session.LastActiveAt)
+		active := session.State == SessionState_ACTIVE
+		recv := session.PacketsRecv
+		session.mu.RUnlock()
+
+		if !active {
+			continue
+		}
+		if idle < session.keepAliveSpan(probeInterval, 2) {
+			atomic.StoreUint32(&session.probesMissed, 0)
+			// Замер качества - только пока идут данные: Pong сам
+			// обновляет LastActiveAt и не должен держать сессию живой
+			if recv != session.pingRecv {
+				session.pingRecv = recv
+				h.sendPing(session, now)
+			}
+			continue
+		}
+		missed := atomic.LoadUint32(&session.probesMissed)
+		if missed >= h.probeLimit {
+			dead = append(dead, session.ID)
+			continue
+		}
+		// Предыдущий probe остался без ответа - путь под подозрением
+		if missed > 0 && session.path.markDegraded() {
+			h.sessionDegraded(session)
+		}
+
+		if err := h.sendProbe(session); err == nil {
+			atomic.AddUint32(&session.probesMissed, 1)
+		}
+	}
+
+	for _, id := range dead {
+		h.closeSession(id, CloseReason_DEAD_PEER)
+		atomic.AddUint64(&metrics.server.deadPeers, 1)
+		atomic.AddUint64(&h.deadPeers, 1)
+	}
+}
+
+// sendProbe отправляет сессии аутентифицированный probe
+func (h *Hub) sendProbe(session *Session) error {
+	var token [probeTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return fmt.Errorf("probe token: %w", err)
+	}
+
+	session.mu.Lock()
+	session.probeToken = token
+	session.mu.Unlock()
+
+	return h.sendSealedControl(session, 0x04, token[:])
+}
+
+// sendPing отправляет сессии Ping для замера качества канала
+// (quality.go)
+func (h *Hub) sendPing(session *Session, now time.Time) error {
+	return h.sendSealedControl(session, 0x01, session.quality.pingSent(now))
+}
+
+// handleProbeAck проверяет ответ клиента на probe
+// sealed - payload без байта команды, ad - заголовок пакета
+func (h *Hub) handleProbeAck(session *Session, sealed []byte, pktNum uint32, ad []byte) error {
+	if session.Keys == nil {
+		return fmt.Errorf("session has no keys")
+	}
+
+	// Окно replay сдвигает только расшифрованный пакет: подделка с
+	// большим номером не отсечёт настоящие
+	token, err := session.Keys.Decrypt(sealed, pktNum, ad)
+	if err != nil {
+		h.count(func(c *sideCounters) *uint64 { return &c.decryptFailures }, 1)
+		return fmt.Errorf("decrypt probe ack: %w", err)
+	}
+	if session.ReplayWindow != nil && !session.ReplayWindow.Check(pktNum) {
+		return fmt.Errorf("replay detected: packet %d", pktNum)
+	}
+
+	session.mu.RLock()
+	want := session.probeToken
+	session.mu.RUnlock()
+
+	if subtle.ConstantTimeCompare(token, want[:]) != 1 {
+		return fmt.Errorf("probe ack token mismatch")
+	}
+	atomic.StoreUint32(&session.probesMissed, 0)
+	h.markAuthenticated(session)
+	return nil
+}
+
+// GetDeadPeers возвращает число сессий, удалённых как мёртвые
+func (h *Hub) GetDeadPeers() uint64 {
+	return atomic.LoadUint64(&h.deadPeers)
+}
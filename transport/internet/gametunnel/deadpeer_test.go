@@ -0,0 +1,133 @@
+package gametunnel
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDeadPeerTiming(t *testing.T) {
+	config := DefaultConfig()
+	if interval, probes := deadPeerTiming(config); interval != 30*time.Second || probes != 3 {
+		t.Errorf("Default: %v, %d", interval, probes)
+	}
+
+	config.KeepAliveInterval = 0
+	if interval, _ := deadPeerTiming(config); interval != defaultDeadPeerInterval {
+		t.Errorf("Without keep-alive: %v", interval)
+	}
+
+	config.DeadPeerInterval = 5
+	config.DeadPeerProbes = 2
+	if interval, probes := deadPeerTiming(config); interval != 5*time.Second || probes != 2 {
+		t.Errorf("Explicit: %v, %d", interval, probes)
+	}
+}
+
+// idleFor сдвигает время последней активности сессии в прошлое
+func idleFor(session *Session, d time.Duration) {
+	session.mu.Lock()
+	session.LastActiveAt = time.Now().Add(-d)
+	session.mu.Unlock()
+}
+
+func TestDeadPeerRemovesSilentSession(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "deadpeer"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+
+	// Клиента за адресом нет: probe уходят в пустоту
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	keys, _ := DeriveSessionKeys([32]byte{1}, config.Key, false)
+	session := h.newSession([]byte{9, 9, 9, 9, 9, 9, 9, 9}, sink.LocalAddr().(*net.UDPAddr), keys)
+	h.sessions.put(session)
+	atomic.AddInt32(&h.activeSessions, 1)
+
+	before := atomic.LoadUint64(&metrics.server.deadPeers)
+	idleFor(session, time.Hour)
+	for i := uint32(0); i < h.probeLimit; i++ {
+		h.probeIdleSessions(time.Now())
+		if h.GetSession(session.ID) == nil {
+			t.Fatalf("Session removed after %d probes", i+1)
+		}
+	}
+	if n := atomic.LoadUint32(&session.probesMissed); n != h.probeLimit {
+		t.Fatalf("probesMissed: got %d, want %d", n, h.probeLimit)
+	}
+
+	h.probeIdleSessions(time.Now())
+	if h.GetSession(session.ID) != nil {
+		t.Fatal("Dead session not removed")
+	}
+	if h.GetDeadPeers() != 1 || atomic.LoadUint64(&metrics.server.deadPeers) != before+1 {
+		t.Error("Dead peer not counted")
+	}
+}
+
+func TestDeadPeerProbeAnswered(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "deadpeer"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	dialTestClient(t, l, &clientConfig, accepted)
+
+	session := l.hub.sessions.snapshot()[0]
+	idleFor(session, time.Hour)
+	l.hub.probeIdleSessions(time.Now())
+	if atomic.LoadUint32(&session.probesMissed) != 1 {
+		t.Fatal("Probe not sent")
+	}
+
+	// Клиент отвечает аутентифицированным 0x05 - счётчик обнуляется
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint32(&session.probesMissed) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Probe ack not received")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if l.hub.GetSession(session.ID) == nil {
+		t.Error("Live session removed")
+	}
+}
+
+func TestProbeAckRejectsWrongToken(t *testing.T) {
+	serverKeys, _ := DeriveSessionKeys([32]byte{2}, "k", false)
+	clientKeys, _ := DeriveSessionKeys([32]byte{2}, "k", true)
+
+	h := NewHub(DefaultConfig(), nil)
+	session := &Session{ID: []byte{1, 2, 3, 4, 5, 6, 7, 8}, Keys: serverKeys, ReplayWindow: NewReplayWindow()}
+	session.probeToken = [probeTokenSize]byte{1, 2, 3}
+	session.probesMissed = 2
+
+	ad := controlAdditionalData(session.ID, 1)
+	sealed, _ := clientKeys.Encrypt([]byte{7, 7, 7, 7, 7, 7, 7, 7}, 1, ad)
+	if err := h.handleProbeAck(session, sealed, 1, ad); err == nil {
+		t.Error("Wrong token accepted")
+	}
+
+	// Подделка с далёким номером не сдвигает окно replay
+	forged := controlAdditionalData(session.ID, 1<<20)
+	if err := h.handleProbeAck(session, make([]byte, 32), 1<<20, forged); err == nil {
+		t.Error("Forged ack accepted")
+	}
+
+	ad = controlAdditionalData(session.ID, 2)
+	sealed, _ = clientKeys.Encrypt(session.probeToken[:], 2, ad)
+	if err := h.handleProbeAck(session, sealed, 2, ad); err != nil {
+		t.Fatalf("Valid ack rejected: %v", err)
+	}
+	if session.probesMissed != 0 {
+		t.Error("probesMissed not reset")
+	}
+	if err := h.handleProbeAck(session, sealed, 2, ad); err == nil {
+		t.Error("Replayed ack accepted")
+	}
+}
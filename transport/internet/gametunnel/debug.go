@@ -0,0 +1,191 @@
+package gametunnel
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	runtimepprof "runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Отладочный HTTP-сервер: expvar и pprof
+// ====================================================================
+//
+// debugListen открывает на отдельном адресе:
+//
+//	/debug/vars    - expvar: счётчики транспорта ("gametunnel") и
+//	                 стандартные memstats/cmdline
+//	/debug/pprof/  - pprof: CPU, heap, goroutine, block, mutex, trace
+//
+// Авторизации у сервера нет, а pprof отдаёт командную строку и
+// позволяет нагрузить процесс профилированием, поэтому
+// Config.Validate разрешает debugListen только на loopback. Снаружи
+// - через SSH-туннель.
+//
+// Listener и Dialer с одинаковым debugListen делят один сервер;
+// закрывает его последний из них (sharedServer).
+//
+// Фоновые горутины транспорта помечены pprof-меткой gametunnel
+// ("hub.send", "listener.receive", "client.keepalive", ...) - всегда,
+// не только с debugListen: метка ничего не стоит. В профиле CPU
+// нагруженного сервера время делится по подсистемам:
+//
+//	go tool pprof -tagfocus=gametunnel=hub.send http://HOST/debug/pprof/profile
+//
+// Дочерние горутины наследуют метку родителя.
+//
+// ====================================================================
+
+// pprofLabel - ключ pprof-метки фоновых горутин транспорта
+const pprofLabel = "gametunnel"
+
+// goLabeled выполняет loop с pprof-меткой name
+func goLabeled(name string, loop func()) {
+	runtimepprof.Do(context.Background(), runtimepprof.Labels(pprofLabel, name), func(context.Context) {
+		loop()
+	})
+}
+
+// sharedServer - HTTP-сервер на адресе, общий для Listener и Dialer
+// с одинаковым адресом в конфиге
+// users - число держателей: последний release закрывает сервер
+type sharedServer struct {
+	servers *sharedServers
+	addr    string
+	server  *http.Server
+	users   int
+}
+
+// sharedServers - запущенные общие серверы одного вида по адресу
+type sharedServers struct {
+	// name - вид сервера в ошибках ("debug", "metrics")
+	name string
+
+	mu      sync.Mutex
+	running map[string]*sharedServer
+}
+
+// acquire возвращает сервер на addr, запуская его с обработчиком
+// handler, если он ещё не запущен (addr "" - nil)
+// Держатель отпускает сервер через release
+func (s *sharedServers) acquire(addr string, handler func() http.Handler) (*sharedServer, error) {
+	if addr == "" {
+		return nil, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if srv, ok := s.running[addr]; ok {
+		srv.users++
+		return srv, nil
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s listen %s: %w", s.name, addr, err)
+	}
+	srv := &sharedServer{
+		servers: s,
+		addr:    addr,
+		server:  &http.Server{Handler: handler(), ReadHeaderTimeout: 5 * time.Second},
+		users:   1,
+	}
+	if s.running == nil {
+		s.running = make(map[string]*sharedServer)
+	}
+	s.running[addr] = srv
+	go srv.server.Serve(ln)
+	return srv, nil
+}
+
+// release отпускает сервер; последний держатель его закрывает
+// Безопасен для nil (адрес не задан)
+func (srv *sharedServer) release() {
+	if srv == nil {
+		return
+	}
+	s := srv.servers
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	srv.users--
+	if srv.users > 0 {
+		return
+	}
+	delete(s.running, srv.addr)
+	srv.server.Close()
+}
+
+// debugServers - запущенные отладочные серверы
+var (
+	debugServers = &sharedServers{name: "debug"}
+	publishOnce  sync.Once
+)
+
+// acquireDebugServer возвращает сервер expvar и pprof на addr,
+// запуская его при первом держателе
+func acquireDebugServer(addr string) (*sharedServer, error) {
+	return debugServers.acquire(addr, func() http.Handler {
+		// expvar.Publish паникует на повторном имени
+		publishOnce.Do(func() {
+			expvar.Publish(pprofLabel, expvar.Func(debugVars))
+		})
+
+		mux := http.NewServeMux()
+		mux.Handle("/debug/vars", expvar.Handler())
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		return mux
+	})
+}
+
+// debugVars - счётчики транспорта для expvar
+func debugVars() any {
+	sessions, _, _, _ := metrics.gauges()
+	return map[string]any{
+		metricsSideServer: metrics.server.vars(sessions[metricsSideServer]),
+		metricsSideClient: metrics.client.vars(sessions[metricsSideClient]),
+	}
+}
+
+// vars - счётчики стороны по именам, как в JSON статистики
+func (c *sideCounters) vars(sessions int64) map[string]any {
+	load := func(v *uint64) uint64 { return atomic.LoadUint64(v) }
+	return map[string]any{
+		"activeSessions":    sessions,
+		"handshakes":        load(&c.handshakes),
+		"handshakeFailures": load(&c.handshakeFailures),
+		"decryptFailures":   load(&c.decryptFailures),
+		"bytesSent":         load(&c.bytesSent),
+		"bytesRecv":         load(&c.bytesRecv),
+		"packetsSent":       load(&c.packetsSent),
+		"packetsRecv":       load(&c.packetsRecv),
+		"deadPeers":         load(&c.deadPeers),
+		"shedSessions":      load(&c.sessionsShed),
+		"evictedSessions":   load(&c.sessionsEvicted),
+		"degradedPaths":     load(&c.degradedPaths),
+		"migrations":        load(&c.migrations),
+		"spoofedHellos":     load(&c.spoofedHellos),
+		"inboundDrops":      load(&c.inboundDrops),
+		"duplicatesSent":    load(&c.duplicatesSent),
+		"puzzles":           load(&c.puzzles),
+		"handshakeNanos":    load(&c.handshakeNanos),
+		"paddingReduced":    load(&c.paddingReduced),
+		"paddingSaved":      load(&c.paddingSaved),
+		"natProbes":         load(&c.natProbes),
+		"natProbesLost":     load(&c.natProbesLost),
+		"clusterAdopted":    load(&c.clusterAdopted),
+		"clusterReleased":   load(&c.clusterReleased),
+		"drops":             c.drops.snapshot(),
+	}
+}
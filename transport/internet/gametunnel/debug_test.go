@@ -0,0 +1,94 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// debugGet - тело ответа отладочного сервера addr на path
+func debugGet(t *testing.T, addr, path string) string {
+	t.Helper()
+	resp, err := http.Get("http://" + addr + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", path, resp.Status)
+	}
+	return string(body)
+}
+
+func TestDebugServer(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("probe listen: %v", err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+
+	config := DefaultConfig()
+	config.Key = "debug"
+	config.DebugListen = addr
+	l, accepted := startTestListener(t, config)
+	client, _ := dialTestClient(t, l, config, accepted)
+
+	// Второй держатель с тем же адресом - тот же сервер
+	second, err := acquireDebugServer(addr)
+	if err != nil {
+		t.Fatalf("second acquireDebugServer: %v", err)
+	}
+	if second != l.debug || second != client.debug {
+		t.Error("holders of one address got different servers")
+	}
+
+	var vars struct {
+		GameTunnel map[string]map[string]any `json:"gametunnel"`
+	}
+	if err := json.Unmarshal([]byte(debugGet(t, addr, "/debug/vars")), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.GameTunnel[metricsSideServer]["handshakes"] == nil || vars.GameTunnel[metricsSideClient]["activeSessions"] == nil {
+		t.Errorf("transport counters missing from expvar: %v", vars.GameTunnel)
+	}
+
+	// Горутины транспорта помечены подсистемой
+	goroutines := debugGet(t, addr, "/debug/pprof/goroutine?debug=1")
+	for _, label := range []string{`"gametunnel":"hub.send"`, `"gametunnel":"listener.receive"`, `"gametunnel":"client.receive"`} {
+		if !strings.Contains(goroutines, label) {
+			t.Errorf("no goroutine labelled %s", label)
+		}
+	}
+
+	// Сервер закрывает последний держатель
+	second.release()
+	client.Close()
+	debugGet(t, addr, "/debug/vars")
+	l.Close()
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("debug server still listening after its last holder closed")
+	}
+}
+
+func TestDebugListenLoopbackOnly(t *testing.T) {
+	for addr, ok := range map[string]bool{
+		"127.0.0.1:6061": true,
+		"[::1]:6061":     true,
+		"localhost:6061": true,
+		"0.0.0.0:6061":   false,
+		":6061":          false,
+		"10.0.0.1:6061":  false,
+	} {
+		config := DefaultConfig()
+		config.DebugListen = addr
+		if err := config.Validate(); (err == nil) != ok {
+			t.Errorf("debugListen %s: %v", addr, err)
+		}
+	}
+}
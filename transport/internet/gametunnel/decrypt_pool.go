@@ -0,0 +1,185 @@
+package gametunnel
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Пул расшифровки входящих пакетов
+// ====================================================================
+//
+// Без пула receiveLoop сам разбирает и расшифровывает каждый
+// пакет: суммарная пропускная способность расшифровки упирается в
+// одно ядро на сокет.
+//
+// С пулом receiveLoop только снимает обфускацию, читает
+// Connection ID и кладёт пакет в очередь воркера, выбранного по
+// хэшу Connection ID. Unmarshal, расшифровка и хэндшейки идут в
+// воркерах параллельно, а пакеты одной сессии всегда попадают к
+// одному воркеру - порядок внутри сессии сохраняется.
+//
+// decryptWorkers:
+//   0 - по числу GOMAXPROCS (на одном ядре пул не нужен)
+//   1 - без пула, расшифровка в receiveLoop
+//   N - N воркеров
+//
+// Очередь воркера ограничена decryptQueueSize; переполненная
+// очередь отбрасывает пакет, как переполненный буфер сокета.
+//
+// ====================================================================
+
+// decryptQueueSize - пакетов в очереди одного воркера
+const decryptQueueSize = 256
+
+// decryptJob - пакет, ожидающий расшифровки
+// buf - буфер пула с датаграммой, освобождается после разбора
+type decryptJob struct {
+	sock *dscpMarker
+	data []byte
+	addr *net.UDPAddr
+	buf  *[]byte
+}
+
+// decryptPool - очереди воркеров расшифровки
+type decryptPool struct {
+	queues []chan decryptJob
+
+	// dropped - пакетов отброшено из-за полной очереди
+	dropped uint64
+}
+
+// decryptWorkerCount возвращает число воркеров (0 = без пула)
+func decryptWorkerCount(config *Config) int {
+	workers := int(config.DecryptWorkers)
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers <= 1 {
+		return 0
+	}
+	return workers
+}
+
+// newDecryptPool создаёт пул из workers очередей (nil при workers == 0)
+func newDecryptPool(workers int) *decryptPool {
+	if workers == 0 {
+		return nil
+	}
+	p := &decryptPool{queues: make([]chan decryptJob, workers)}
+	for i := range p.queues {
+		p.queues[i] = make(chan decryptJob, decryptQueueSize)
+	}
+	return p
+}
+
+// startDecryptWorkers запускает воркеры пула в горутинах хаба
+func (h *Hub) startDecryptWorkers() {
+	if h.decrypt == nil {
+		return
+	}
+	for _, queue := range h.decrypt.queues {
+		queue := queue
+		h.goLoop("decrypt", func() { h.decryptLoop(queue) })
+	}
+}
+
+// decryptLoop - воркер: разбирает пакеты своей очереди
+func (h *Hub) decryptLoop(queue chan decryptJob) {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case job := <-queue:
+			h.deliverInbound(h.routeUnwrapped(job.sock, job.data, job.addr))
+			putPacketBuf(job.buf)
+		}
+	}
+}
+
+// receivePacket обрабатывает датаграмму (*buf)[:n], принятую сокетом
+// sock: сразу или через пул расшифровки
+// buf - буфер пула (bufpool.go): receivePacket возвращает его в пул,
+// когда пакет разобран
+func (h *Hub) receivePacket(sock *dscpMarker, buf *[]byte, n int, addr *net.UDPAddr) {
+	raw := (*buf)[:n]
+	// Фильтр оператора (filter.go)
+	if h.filterPacket(raw, addr) {
+		putPacketBuf(buf)
+		return
+	}
+	if h.decrypt == nil {
+		h.deliverInbound(h.routePacket(sock, raw, addr))
+		putPacketBuf(buf)
+		return
+	}
+
+	data, err := unwrapPacket(h.obfs, raw)
+	if err != nil {
+		invalidPacketLog.logf(log.Severity_Debug, "dropped packet from %s: unwrap: %v", addr, err)
+		h.drop(dropNotQUICLike)
+		putPacketBuf(buf)
+		return
+	}
+	// Сжатый пакет ставится в очередь полным: очередь выбирается по
+	// Connection ID (compact.go)
+	if len(data) > 0 && isCompactPacket(data[0]) {
+		if data, err = h.expandCompact(data, addr); err != nil {
+			invalidPacketLog.logf(log.Severity_Debug, "dropped packet from %s: %v", addr, err)
+			h.drop(dropReasonOf(err))
+			putPacketBuf(buf)
+			return
+		}
+	}
+	connIDLen := int(h.getConfig().ConnectionIdLength)
+	offset := FlagsSize + VersionSize
+	if len(data) < offset+connIDLen {
+		h.drop(dropTooShort)
+		putPacketBuf(buf)
+		return
+	}
+
+	queues := h.decrypt.queues
+	queue := queues[connIDHash(data[offset:offset+connIDLen])%uint32(len(queues))]
+	select {
+	case queue <- decryptJob{sock: sock, data: data, addr: addr, buf: buf}:
+	default:
+		atomic.AddUint64(&h.decrypt.dropped, 1)
+		h.drop(dropQueueOverflow)
+		putPacketBuf(buf)
+	}
+}
+
+// deliverInbound передаёт расшифрованные данные в сессию
+// Ошибка разбора - невалидный пакет (сканер, мусор): в журнал на
+// уровне debug, в счётчик причины (drops.go) и дальше не идёт
+func (h *Hub) deliverInbound(session *Session, plaintext []byte, err error) {
+	if err != nil {
+		h.drop(dropReasonOf(err))
+	}
+	if err != nil && !errors.Is(err, errDecrypt) {
+		invalidPacketLog.logf(log.Severity_Debug, "dropped packet: %v", err)
+	}
+	if err != nil || session == nil || len(plaintext) == 0 {
+		return
+	}
+	// Общая сессия: кадр в поток (mux.go)
+	if session.streams != nil {
+		h.deliverStream(session, plaintext)
+		return
+	}
+	// Буфер переполнен - пакет потерян, для UDP это нормально
+	h.pushInbound(session, plaintext)
+}
+
+// GetDecryptQueueDrops - пакетов отброшено переполненным пулом расшифровки
+func (h *Hub) GetDecryptQueueDrops() uint64 {
+	if h.decrypt == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&h.decrypt.dropped)
+}
@@ -0,0 +1,77 @@
+package gametunnel
+
+import (
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestDecryptWorkerCount(t *testing.T) {
+	config := DefaultConfig()
+
+	config.DecryptWorkers = 1
+	if n := decryptWorkerCount(config); n != 0 {
+		t.Errorf("decryptWorkers=1: got %d, want inline", n)
+	}
+	config.DecryptWorkers = 4
+	if n := decryptWorkerCount(config); n != 4 {
+		t.Errorf("decryptWorkers=4: got %d", n)
+	}
+
+	config.DecryptWorkers = 0
+	want := runtime.GOMAXPROCS(0)
+	if want == 1 {
+		want = 0
+	}
+	if n := decryptWorkerCount(config); n != want {
+		t.Errorf("Auto: got %d, want %d", n, want)
+	}
+}
+
+func TestDecryptPoolPreservesSessionOrder(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := DefaultConfig()
+	config.Key = "pool"
+	config.DecryptWorkers = 4
+	l, accepted := startTestListener(t, config)
+	if l.hub.decrypt == nil || len(l.hub.decrypt.queues) != 4 {
+		t.Fatal("Decrypt pool not created")
+	}
+	clientConfig := *config
+
+	const sessions, packets = 3, 50
+	type pair struct {
+		client *GameTunnelClientConn
+		server net.Conn
+	}
+	var pairs []pair
+	for i := 0; i < sessions; i++ {
+		client, server := dialTestClient(t, l, &clientConfig, accepted)
+		pairs = append(pairs, pair{client, server})
+	}
+
+	// Сессии пишут вперемешку - воркеры обрабатывают их параллельно
+	for n := 0; n < packets; n++ {
+		for i, p := range pairs {
+			if _, err := p.client.Write([]byte{byte(i), byte(n)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for i, p := range pairs {
+		for n := 0; n < packets; n++ {
+			got := readWithTimeout(t, p.server, 2)
+			if got[0] != byte(i) || got[1] != byte(n) {
+				t.Fatalf("Session %d: got packet %v, want #%d", i, got, n)
+			}
+		}
+	}
+
+	for _, p := range pairs {
+		p.client.Close()
+	}
+	l.Close()
+	waitGoroutines(t, before)
+}
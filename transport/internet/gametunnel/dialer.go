@@ -1,16 +1,21 @@
 package gametunnel
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/xtls/xray-core/common/log"
 	xnet "github.com/xtls/xray-core/common/net"
-	"github.com/xtls/xray-core/common/signal/done"
+	"github.com/xtls/xray-core/common/session"
 	"github.com/xtls/xray-core/transport/internet"
 	"github.com/xtls/xray-core/transport/internet/stat"
 )
@@ -35,31 +40,173 @@ import (
 
 // GameTunnelClientConn - клиентское соединение с сервером
 type GameTunnelClientConn struct {
-	// conn - UDP-сокет к серверу
-	conn *net.UDPConn
-
 	// config - конфигурация транспорта
 	config *Config
 
-	// session - клиентская сессия
-	session *ClientSession
+	// current - клиентская сессия (atomic: переподключение заменяет
+	// её новой, reconnect.go)
+	current atomic.Pointer[ClientSession]
 
 	// obfs - обфускатор трафика
 	obfs Obfuscator
 
-	// done - сигнал завершения
-	done *done.Instance
-
-	// readBuf - буфер для чтения
-	readBuf    []byte
-	readOffset int
+	// sock - UDP-сокет к серверу с DSCP-маркировкой по приоритету
+	// Заменяется новым при поломке пути (rebind, health.go)
+	sock atomic.Pointer[dscpMarker]
+
+	// queue - очередь приоритетов исходящих DATA-пакетов
+	// Write ставит пакеты в очередь, на провод их выводит sendLoop
+	queue *PriorityQueue
+
+	// bandwidth - оценка исходящей пропускной способности
+	bandwidth *BandwidthEstimator
+
+	// pacer - сглаживание отправки Low/Medium (nil = выключено)
+	pacer *pacer
+
+	// padding - уровень padding по загрузке канала, paddingVariant -
+	// конфиг с уменьшенным padding (padding.go)
+	padding        *paddingGovernor
+	paddingVariant atomic.Pointer[paddingVariant]
+
+	// limiter - лимит скорости отправки соединения (nil = без ограничений)
+	limiter *tokenBucket
+
+	// classLimiters - потолки скорости классов приоритета
+	classLimiters [PriorityLevels]*tokenBucket
+
+	// rateLimited - пакетов отброшено лимитами скорости
+	rateLimited uint64
+
+	// traffic - трафик, RTT и потери соединения (clientstats.go)
+	traffic clientTraffic
+
+	// connectedAt - время завершения Dial (начало спана сессии, tracing.go)
+	connectedAt time.Time
+
+	// xstats - счётчики stats xray (nil - выключены, xraystats.go)
+	xstats *xrayStats
+
+	// mtu - измерение MTU пути (mtu.go, nil - выключено)
+	mtu *mtuProber
+
+	// natTune - подбор интервала keep-alive (nattune.go, nil - выключен)
+	natTune *natTuner
+
+	// keepAliveSentAt - время последнего keep-alive без ответа
+	// (UnixNano, 0 = ответ получен) - для замера RTT
+	// keepAlivesSent - отправлено keep-alive (keepalive.go)
+	// lastSendAt - время последней отправки данных (UnixNano)
+	keepAliveSentAt int64
+	keepAlivesSent  uint64
+	lastSendAt      int64
+
+	// piggyback - запросы keep-alive в DATA-пакетах (piggyback.go)
+	piggyback keepAlivePiggyback
+
+	// chaffSent - покрывающих пакетов отправлено (chaff.go)
+	chaffSent uint64
+
+	// tuned - снимок настроек, меняемых на ходу (tunables.go)
+	// tuneMu сериализует их смену
+	tuned  atomic.Pointer[tunedConfig]
+	tuneMu sync.Mutex
+
+	// path - признаки поломки пути до сервера (health.go)
+	// keepAlivesMissed - keep-alive подряд без ответа (atomic)
+	// lastRecvAt - время последнего пакета сервера (UnixNano)
+	// rebinds - переоткрытий сокета
+	// pathMu сериализует замену сокета и его закрытие в shutdown
+	path             pathHealth
+	keepAlivesMissed uint32
+	lastRecvAt       int64
+	rebinds          uint64
+	pathMu           sync.Mutex
+
+	// pathToken - токен последнего PATH_CHALLENGE (под pathMu, roaming.go)
+	// pathChallenged - ответ на него ещё не пришёл
+	// pathValidations - путей, подтверждённых сервером
+	// addrCheckedAt - время проверки локального адреса (UnixNano)
+	pathToken       [pathTokenSize]byte
+	pathChallenged  bool
+	pathValidations uint64
+	addrCheckedAt   int64
+
+	// multipath - второй путь и планировщик (nil - выключен, multipath.go)
+	multipath *multipath
+
+	// classes - bulk-сокет Medium и Low (nil - выключен, classsock.go)
+	classes *classSockets
+
+	// sockopt - streamSettings.sockopt xray для всех сокетов к серверу
+	// (nil - не задан)
+	sockopt *internet.SocketConfig
+
+	// endpoints - адреса сервера для переподключения (endpoints.go)
+	// dest / resolver - адрес outbound-а и резолвер имён сервера:
+	// переподключение разрешает их заново (resolve.go)
+	endpoints []*net.UDPAddr
+	dest      xnet.Destination
+	resolver  *serverResolver
+
+	// metricsServer и debug - серверы метрик и отладки, которые
+	// держит соединение (nil = выключен, metrics.go, debug.go)
+	metricsServer *sharedServer
+	debug         *sharedServer
+
+	// mux - потоки общей сессии (nil - сессия одного соединения, mux.go)
+	// sharedKey - ключ сессии в пуле общих сессий
+	mux       *streamMux
+	sharedKey sharedKey
+
+	// streams - потоки приложения (nil - не согласованы, streams.go)
+	streams *streamMux
+
+	// coalesce - склейка мелких записей (coalesce.go)
+	coalesce coalescer
+
+	// earlyMu - смена ранних ключей на обычные ждёт начатые Write
+	// earlyData / earlySize - записанное до Server Hello 0-RTT
+	// earlyDone - закрыт, когда Server Hello принят и отклонённые
+	// ранние данные отправлены заново (nil без 0-RTT)
+	// earlyState - состояние 0-RTT (atomic, zerortt.go)
+	earlyMu    sync.Mutex
+	earlyData  [][]byte
+	earlySize  int
+	earlyDone  chan struct{}
+	earlyState int32
+
+	// resumable - поток можно продолжить в новой сессии (reconnect.go)
+	// reconnecting - идёт переподключение (atomic)
+	// reconnects - успешных переподключений
+	// opening - начало потока для повтора в новой сессии
+	resumable    bool
+	reconnecting int32
+	reconnects   uint64
+	opening      atomic.Pointer[[]byte]
+
+	// classifier - стратегия классификации (classifierHolder)
+	classifier atomic.Value
+
+	// rest - остатки пакетов после Read
+	rest readRest
+
+	// closeReason - причина закрытия от сервера (CloseReason)
+	closeReason int32
+
+	// observers - наблюдатели событий соединения (observer.go)
+	// authenticated - от сервера пришёл расшифрованный пакет (atomic)
+	observers     sessionObservers
+	authenticated int32
 
 	closed int32
 
-	// closeCh - сигнал закрытия для горутин (безопаснее чем close(inbound))
-	closeCh chan struct{}
-
-	mu     sync.Mutex
+	// ctx / cancel - время жизни горутин соединения
+	// (отмена безопаснее, чем close(inbound))
+	// wg - учёт горутин: Close возвращается после их завершения
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // ClientSession - сессия на стороне клиента
@@ -79,11 +226,39 @@ type ClientSession struct {
 	// ReplayWindow - защита от replay-атак
 	ReplayWindow *ReplayWindow
 
-	// inbound - канал входящих расшифрованных данных
-	inbound chan []byte
+	// inbound - очередь входящих расшифрованных данных (inbound.go)
+	inbound *inboundRing
 
 	// serverAddr - адрес сервера
 	serverAddr *net.UDPAddr
+
+	// Streams - потоки с явно заданным приоритетом
+	Streams map[uint16]*Stream
+
+	// shared - сервер согласился вести в сессии потоки (mux.go)
+	// appStreams - сервер согласился на потоки приложения (streams.go)
+	shared     bool
+	appStreams bool
+
+	// pending - хэндшейк 0-RTT ещё ждёт Server Hello, Keys - ранние
+	// ключи (nil - хэндшейк завершён, zerortt.go)
+	pending *pendingHello
+
+	// flow - характеристики исходящего потока для Classifier
+	flow *flowTracker
+
+	// dataAD - готовые AD пакетов данных (packet.go)
+	dataAD atomic.Pointer[dataAD]
+
+	// compact - контекст сжатия заголовков (nil - не согласовано,
+	// compact.go)
+	compact *compactState
+
+	// timestamps - метки времени в DATA (nil - не согласованы,
+	// timestamps.go)
+	timestamps *delayTrend
+
+	mu sync.RWMutex
 }
 
 // Dial устанавливает соединение с сервером GameTunnel
@@ -91,60 +266,220 @@ type ClientSession struct {
 func Dial(ctx context.Context, dest xnet.Destination, streamSettings *internet.MemoryStreamConfig) (stat.Connection, error) {
 	// Получаем конфигурацию
 	config := DefaultConfig()
+	var sockopt *internet.SocketConfig
 	if streamSettings != nil {
-		if c, ok := streamSettings.ProtocolSettings.(*Config); ok {
-			config = c
+		var err error
+		if config, err = configFromStream(streamSettings.ProtocolSettings); err != nil {
+			return nil, fmt.Errorf("invalid GameTunnel config: %w", err)
 		}
+		sockopt = streamSettings.SocketSettings
 	}
 
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid GameTunnel config: %w", err)
 	}
 
-	// Получаем адрес сервера
-	serverAddr := &net.UDPAddr{
-		IP:   dest.Address.IP(),
-		Port: int(dest.Port),
+	// Серверы метрик и отладки держит созданное соединение; отказ
+	// Dial и поток уже открытой общей сессии их отпускают
+	metricsServer, err := acquireMetricsServer(config.MetricsListen)
+	if err != nil {
+		return nil, err
+	}
+	debug, err := acquireDebugServer(config.DebugListen)
+	if err != nil {
+		metricsServer.release()
+		return nil, err
+	}
+	kept := false
+	defer func() {
+		if !kept {
+			metricsServer.release()
+			debug.release()
+		}
+	}()
+
+	// Файл дампа открываем сразу: ошибка пути - ошибка конфига
+	if _, err := newPacketTap(config); err != nil {
+		return nil, err
+	}
+
+	// Общая сессия с этим сервером уже есть - новый поток в ней (mux.go)
+	key := sharedKey{addr: dest.NetAddr(), config: config}
+	if config.SharedSession {
+		if stream, ok := sharedSessions.openStream(key); ok {
+			return stream, nil
+		}
 	}
 
-	// Создаём UDP-сокет
-	conn, err := net.DialUDP("udp", nil, serverAddr)
+	// Спан установки соединения, хэндшейки - дочерние (tracing.go)
+	ctx, span := startSpan(ctx, config, "gametunnel.dial", spanKindClient)
+	span.set("server.address", dest.NetAddr())
+
+	// Адрес outbound-а и запасные адреса сервера - IP или имена
+	// (endpoints.go, resolve.go)
+	resolver, err := newServerResolver(config, sockopt)
+	if err != nil {
+		span.end(err)
+		return nil, err
+	}
+	endpoints, err := resolveEndpoints(ctx, dest, config, resolver)
 	if err != nil {
-		return nil, fmt.Errorf("dial UDP %s: %w", serverAddr.String(), err)
+		span.end(err)
+		return nil, err
 	}
+	span.set("gametunnel.endpoints", len(endpoints))
 
-	// Устанавливаем буферы сокета
-	conn.SetReadBuffer(4 * 1024 * 1024)
-	conn.SetWriteBuffer(4 * 1024 * 1024)
+	// Второй путь открывается после хэндшейка (multipath.go)
+	mp, err := newMultipath(config)
+	if err != nil {
+		span.end(err)
+		return nil, err
+	}
 
 	// Создаём обфускатор
 	obfs := NewObfuscator(config.Obfuscation, config)
+	span.set("gametunnel.obfuscation", obfs.Name())
+
+	// Сокет с sockopt из streamSettings и хэндшейк с первым ответившим
+	// адресом; с ключом возобновления - 0-RTT без ожидания ответа
+	// (zerortt.go)
+	dialed, early := dialEarly(ctx, endpoints, config, obfs, sockopt)
+	span.set("gametunnel.early_data", early)
+	if !early {
+		dialed, err = dialEndpoints(ctx, endpoints, config, obfs, sockopt)
+		span.set("gametunnel.attempts", dialed.attempts)
+		if err != nil {
+			span.end(err)
+			return nil, err
+		}
+	}
+	conn, clientSession, serverAddr := dialed.conn, dialed.session, dialed.addr
+	span.set("network.peer.address", serverAddr.String())
+	span.set("gametunnel.connection_id", hex.EncodeToString(clientSession.ConnectionID))
+	span.end(nil)
+
+	// Цель проксируемого соединения - для эвристик классификатора
+	var target xnet.Destination
+	if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		target = outbounds[0].Target
+	}
+	clientSession.flow = newFlowTracker(target)
+	clientSession.serverAddr = serverAddr
 
-	// Выполняем хэндшейк
-	clientSession, err := performHandshake(conn, config, obfs)
-	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("handshake failed: %w", err)
+	// Подсказка xray: DNS и игровые порты получают High для потока 0
+	if level, ok := priorityHintFromContext(ctx); ok {
+		setStreamPriority(clientSession.Streams, 0, level, config.MaxStreams)
+	}
+	// Дублирование High соединения (duplicate.go)
+	if config.Duplicate {
+		setStreamDuplicate(clientSession.Streams, 0, true, config.MaxStreams)
 	}
 
 	// Создаём клиентское соединение
 	gtConn := &GameTunnelClientConn{
-		conn:    conn,
-		config:  config,
-		session: clientSession,
-		obfs:    obfs,
-		done:    done.New(),
-		closeCh: make(chan struct{}),
+		config:        config,
+		obfs:          obfs,
+		queue:         newPriorityQueueFromConfig(config),
+		bandwidth:     NewBandwidthEstimator(),
+		limiter:       newTokenBucket(config.SessionRateLimit, config.SessionRateBurst),
+		lastRecvAt:    time.Now().UnixNano(),
+		connectedAt:   time.Now(),
+		xstats:        newXrayStats(ctx, false),
+		multipath:     mp,
+		classes:       newClassSockets(config),
+		sockopt:       sockopt,
+		endpoints:     endpoints,
+		dest:          dest,
+		resolver:      resolver,
+		debug:         debug,
+		metricsServer: metricsServer,
+	}
+	kept = true
+	if clientSession.shared {
+		gtConn.mux = newStreamMux()
+		gtConn.sharedKey = key
+	}
+	if clientSession.appStreams {
+		gtConn.streams = newStreamMux()
+	}
+	if early {
+		gtConn.earlyState = earlyStatePending
+		gtConn.earlyDone = make(chan struct{})
+	}
+	gtConn.current.Store(clientSession)
+	gtConn.tuned.Store(newTunedConfig(config))
+	gtConn.sock.Store(newDSCPMarker(conn, config))
+	gtConn.SetCoalesceWindow(time.Duration(config.CoalesceWindow) * time.Millisecond)
+
+	// Поток к TCP-цели после новой сессии не восстановить (reconnect.go)
+	// Потоки общей сессии и потоки приложения гибнут на сервере вместе
+	// с ней
+	gtConn.resumable = target.Network != xnet.Network_TCP && gtConn.mux == nil && gtConn.streams == nil
+	gtConn.classLimiters = newClassLimiters(config)
+	gtConn.classifier.Store(classifierHolder{NewClassifier(config)})
+	gtConn.pacer = newPacer(config, gtConn.bandwidth)
+	gtConn.padding = newPaddingGovernor(gtConn.bandwidth, &metrics.client)
+	gtConn.mtu = newMTUProber(config)
+	gtConn.natTune = newNATTuner(config)
+	metrics.registerClient(gtConn)
+	logf(log.Severity_Info, "%s connected to %s (%s, early data %t)", sessionTag(clientSession.ConnectionID), serverAddr, obfs.Name(), early)
+
+	if observer, ok := sessionObserverFromContext(ctx); ok {
+		gtConn.AddSessionObserver(observer)
+		observer.SessionCreated(gtConn.info())
 	}
 
 	// Запускаем горутину приёма пакетов
-	go gtConn.receiveLoop()
+	// Сеть в памяти (memnet.go) - и для сокетов переподключения
+	gtConn.ctx, gtConn.cancel = context.WithCancel(ContextWithMemNetwork(context.Background(), memNetworkFromContext(ctx)))
+	gtConn.goLoop("receive", gtConn.receiveLoop)
+
+	// Запускаем горутину отправки
+	gtConn.goLoop("send", gtConn.sendLoop)
+
+	if mp != nil {
+		gtConn.goLoop("multipath", gtConn.multipathLoop)
+	}
+	if gtConn.classes != nil {
+		gtConn.goLoop("class-sockets", gtConn.classSocketLoop)
+	}
+
+	// Таймеры keep-alive (keepalive.go) и покрывающего трафика
+	// (chaff.go); с нулевым интервалом или бюджетом ждут SetTunables
+	gtConn.goLoop("keepalive", gtConn.keepAliveLoop)
+	gtConn.goLoop("chaff", gtConn.chaffLoop)
+
+	// Повторы Client Hello 0-RTT до Server Hello
+	if early {
+		gtConn.goLoop("early-hello", gtConn.earlyHelloLoop)
+	}
+
+	// Фаза измерения MTU пути (mtu.go)
+	if gtConn.mtu != nil {
+		gtConn.goLoop("mtu-probe", gtConn.mtuProbeLoop)
+	}
+
+	// Общая сессия: xray получает её первый поток, сессия - в пул
+	if gtConn.mux != nil {
+		stream, err := gtConn.openStream()
+		if err != nil {
+			gtConn.Close()
+			return nil, err
+		}
+		sharedSessions.add(gtConn)
+		return stream, nil
+	}
 
 	return gtConn, nil
 }
 
 // performHandshake выполняет хэндшейк с сервером
-func performHandshake(conn *net.UDPConn, config *Config, obfs Obfuscator) (*ClientSession, error) {
+// Отмена ctx и его дедлайн прерывают ожидание Server Hello
+func performHandshake(ctx context.Context, conn PacketConn, config *Config, obfs Obfuscator) (*ClientSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	// 1. Генерируем пару ключей
 	keyPair, err := GenerateKeyPair()
 	if err != nil {
@@ -163,64 +498,89 @@ func performHandshake(conn *net.UDPConn, config *Config, obfs Obfuscator) (*Clie
 		uint64(time.Now().Unix()),
 	)
 
-	clientHello := NewHandshakePacket(connID, 0, handshakePayload.Marshal())
-	clientHelloData, err := clientHello.Marshal(config)
-	if err != nil {
-		return nil, fmt.Errorf("marshal client hello: %w", err)
-	}
-
-	// 4. Обфусцируем и отправляем Client Hello
-	wrapped, err := obfs.Wrap(clientHelloData)
-	if err != nil {
-		return nil, fmt.Errorf("wrap client hello: %w", err)
-	}
-
-	_, err = conn.Write(wrapped)
-	if err != nil {
-		return nil, fmt.Errorf("send client hello: %w", err)
+	// Общая сессия - просим сервер вести в ней потоки (mux.go)
+	if config.SharedSession {
+		handshakePayload.Capabilities = helloCapMux
+	} else if config.AppStreams {
+		// Потоки приложения (streams.go)
+		handshakePayload.Capabilities = helloCapStreams
 	}
-
-	// 5. Ждём Server Hello
-	conn.SetReadDeadline(time.Now().Add(time.Duration(config.HandshakeTimeout) * time.Second))
-
-	buf := make([]byte, MaxPacketSize)
-	n, err := conn.Read(buf)
-	if err != nil {
-		return nil, fmt.Errorf("receive server hello: %w (timeout=%ds)",
-			err, config.HandshakeTimeout)
+	// 0-RTT - просим ключ возобновления для следующих Dial (zerortt.go)
+	if config.EarlyData {
+		handshakePayload.Capabilities |= helloCapResume
 	}
-
-	// Сбрасываем дедлайн
-	conn.SetReadDeadline(time.Time{})
-
-	// 6. Деобфусцируем и парсим Server Hello
-	unwrapped, err := obfs.Unwrap(buf[:n])
-	if err != nil {
-		return nil, fmt.Errorf("unwrap server hello: %w", err)
+	// Сжатие заголовков (compact.go)
+	if config.CompactHeaders {
+		handshakePayload.Capabilities |= helloCapCompact
 	}
-
-	serverHelloPkt, err := Unmarshal(unwrapped, int(config.ConnectionIdLength))
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal server hello: %w", err)
+	// Метки времени в DATA (timestamps.go)
+	if config.Timestamps {
+		handshakePayload.Capabilities |= helloCapTimestamps
 	}
+	// Пользователь с личным ключом (users.go)
+	config.helloUser(handshakePayload)
+
+	server := conn.RemoteAddr().(*net.UDPAddr)
+	var serverHandshake *HandshakePayload
+	var sharedSecret [Curve25519KeySize]byte
+	for retries := 0; ; retries++ {
+		// 4. Обфусцируем и отправляем Client Hello
+		wrapped, err := buildClientHello(connID, handshakePayload, config, obfs)
+		if err != nil {
+			return nil, err
+		}
+		tapOf(obfs).wire(true, udpLocalAddr(conn), server, wrapped)
+		if _, err := conn.Write(wrapped); err != nil {
+			return nil, fmt.Errorf("send client hello: %w", err)
+		}
 
-	if serverHelloPkt.Type != PacketType_HANDSHAKE {
-		return nil, fmt.Errorf("expected handshake packet, got type %d", serverHelloPkt.Type)
+		// 5-7. Ждём Server Hello и вычисляем общий секрет
+		// Ответ без подписи ключом сессии или без пригодного ключа -
+		// подделка: ждём настоящий дальше
+		_, err = readServerHello(ctx, conn, connID, config, obfs, func(pkt *Packet) error {
+			if !serverHelloAuthentic(pkt.Payload, config.sessionPSK(), keyPair.PublicKey) {
+				return errors.New("server hello not signed with the session key")
+			}
+			hello, err := UnmarshalHandshake(pkt.Payload)
+			if err != nil {
+				return fmt.Errorf("unmarshal server handshake: %w", err)
+			}
+			secret, err := ComputeSharedSecret(keyPair.PrivateKey, hello.PublicKey)
+			if err != nil {
+				return fmt.Errorf("compute shared secret: %w", err)
+			}
+			serverHandshake, sharedSecret = hello, secret
+			return nil
+		}, func(payload []byte) ([]byte, bool) {
+			return openHandshakeControl(payload, config.sessionPSK(), keyPair.PublicKey)
+		})
+
+		// Сервер под нагрузкой ответил задачей - решаем и повторяем
+		// Client Hello с решением (puzzle.go)
+		var retry *puzzleRetry
+		if !errors.As(err, &retry) || retries == puzzleMaxRetries {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		if handshakePayload.Puzzle, err = solvePuzzle(ctx, retry.token, keyPair.PublicKey); err != nil {
+			return nil, err
+		}
+		handshakePayload.Capabilities |= helloCapPuzzle
 	}
 
-	serverHandshake, err := UnmarshalHandshake(serverHelloPkt.Payload)
-	if err != nil {
-		return nil, fmt.Errorf("unmarshal server handshake: %w", err)
+	// Сервер выдал свой Connection ID - дальше работаем с ним (connid.go)
+	serverHandshake.splitCapabilities(int(config.ConnectionIdLength), true, handshakePayload.Capabilities&^(helloCapPuzzle|helloCapUser))
+	if len(serverHandshake.ResumeKey) == Curve25519KeySize {
+		storeEarlyTicket(conn.RemoteAddr().(*net.UDPAddr), config, serverHandshake.ResumeKey)
 	}
-
-	// 7. Вычисляем общий секрет
-	sharedSecret, err := ComputeSharedSecret(keyPair.PrivateKey, serverHandshake.PublicKey)
-	if err != nil {
-		return nil, fmt.Errorf("compute shared secret: %w", err)
+	if len(serverHandshake.IssuedID) == int(config.ConnectionIdLength) {
+		connID = serverHandshake.IssuedID
 	}
 
 	// 8. Деривируем ключи (isClient=true)
-	sessionKeys, err := DeriveSessionKeys(sharedSecret, config.Key, true)
+	sessionKeys, err := DeriveSessionKeys(sharedSecret, config.sessionPSK(), true)
 	if err != nil {
 		return nil, fmt.Errorf("derive session keys: %w", err)
 	}
@@ -231,31 +591,128 @@ func performHandshake(conn *net.UDPConn, config *Config, obfs Obfuscator) (*Clie
 		Keys:          sessionKeys,
 		SendPacketNum: 1, // 0 использован для Client Hello
 		ReplayWindow:  NewReplayWindow(),
-		inbound:       make(chan []byte, 256),
+		inbound:       newInboundRing(config.InboundWait),
+		Streams:       make(map[uint16]*Stream),
+		shared:        config.SharedSession && serverHandshake.Capabilities&helloCapMux != 0,
+		appStreams:    config.AppStreams && !config.SharedSession && serverHandshake.Capabilities&helloCapStreams != 0,
+		compact:       acceptCompact(serverHandshake, connID),
+		timestamps:    acceptTimestamps(serverHandshake),
 	}
 
 	return clientSession, nil
 }
 
+// buildClientHello собирает обфусцированный Client Hello с payload
+func buildClientHello(connID []byte, payload *HandshakePayload, config *Config, obfs Obfuscator) ([]byte, error) {
+	data, err := NewHandshakePacket(connID, 0, payload.Marshal()).Marshal(config)
+	if err != nil {
+		return nil, fmt.Errorf("marshal client hello: %w", err)
+	}
+	wrapped, err := wrapHandshake(obfs, data, 0)
+	if err != nil {
+		return nil, fmt.Errorf("wrap client hello: %w", err)
+	}
+	return wrapped, nil
+}
+
+// readServerHello ждёт Server Hello для connID до HandshakeTimeout или
+// дедлайна ctx, если он раньше
+// Пакеты, которые не разбираются или относятся к другому Connection ID
+// (запоздавшие ответы прошлых попыток, мусор), пропускаются. Пропускаются
+// и подделки - Server Hello, который не принял verify, и BUSY или
+// RETRY, подпись которых не снял openControl (nil - любые): спуфер не
+// сорвёт и не задержит хэндшейк и не подсунет свой ключ
+func readServerHello(ctx context.Context, conn PacketConn, connID []byte, config *Config, obfs Obfuscator, verify func(*Packet) error, openControl func([]byte) ([]byte, bool)) (*Packet, error) {
+	deadline := time.Now().Add(time.Duration(config.HandshakeTimeout) * time.Second)
+	ctxDeadline := false
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline, ctxDeadline = d, true
+	}
+	conn.SetReadDeadline(deadline)
+	defer conn.SetReadDeadline(time.Time{})
+
+	// Отмена ctx будит заблокированный Read
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	buf := make([]byte, MaxPacketSize)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, fmt.Errorf("receive server hello: %w", ctxErr)
+			}
+			// Дедлайн сокета - это дедлайн ctx, таймер ctx мог не успеть
+			if ctxDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, fmt.Errorf("receive server hello: %w", context.DeadlineExceeded)
+			}
+			return nil, fmt.Errorf("receive server hello: %w (timeout=%ds)",
+				err, config.HandshakeTimeout)
+		}
+		tapOf(obfs).wire(false, udpLocalAddr(conn), from, buf[:n])
+
+		unwrapped, err := obfs.Unwrap(buf[:n])
+		if err != nil {
+			continue
+		}
+		pkt, err := Unmarshal(unwrapped, int(config.ConnectionIdLength))
+		if err != nil || !bytes.Equal(pkt.ConnectionID, connID) {
+			continue
+		}
+
+		switch pkt.Type {
+		case PacketType_HANDSHAKE:
+			if verify != nil && verify(pkt) != nil {
+				atomic.AddUint64(&metrics.client.spoofedHellos, 1)
+				continue
+			}
+			return pkt, nil
+		case PacketType_CONTROL:
+			payload := pkt.Payload
+			if openControl != nil {
+				var ok bool
+				if payload, ok = openControl(payload); !ok {
+					atomic.AddUint64(&metrics.client.spoofedHellos, 1)
+					continue
+				}
+			}
+			// Сервер перегружен - отказ с retry-after (overload.go)
+			if busy, ok := parseBusy(payload); ok {
+				return nil, busy
+			}
+			// Сервер под нагрузкой - задача хэндшейка (puzzle.go)
+			if retry, ok := parseRetry(payload); ok {
+				return nil, retry
+			}
+		}
+	}
+}
+
 // receiveLoop - цикл приёма пакетов от сервера
 func (c *GameTunnelClientConn) receiveLoop() {
 	buf := make([]byte, MaxPacketSize)
 
 	for {
-		if atomic.LoadInt32(&c.closed) == 1 {
+		if c.ctx.Err() != nil {
 			return
 		}
 
-		// Устанавливаем дедлайн для периодической проверки closed
-		c.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, err := c.conn.Read(buf)
+		// Сокет мог смениться (rebind): старый закрыт, читаем новый
+		sock := c.socket()
+		conn := sock.conn
+
+		// Дедлайн чтения - таймер keep-alive при простое
+		conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+		n, err := conn.Read(buf)
 		if err != nil {
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				// Проверяем, нужно ли отправить keep-alive
-				c.maybeKeepAlive()
+				// Пауза в приёме: не сменилась ли сеть (roaming.go)
+				c.checkLocalAddr(time.Now())
 				continue
 			}
-			if atomic.LoadInt32(&c.closed) == 1 {
+			if c.ctx.Err() != nil {
 				return
 			}
 			continue
@@ -264,55 +721,90 @@ func (c *GameTunnelClientConn) receiveLoop() {
 		if n == 0 {
 			continue
 		}
+		sock.captured(false, nil, buf[:n])
 
-		// Копируем данные
-		packet := make([]byte, n)
-		copy(packet, buf[:n])
-
-		// Обрабатываем пакет
-		c.handlePacket(packet)
+		// handlePacket пакет не хранит - без копии (bufpool.go)
+		c.handlePacket(buf[:n])
 	}
 }
 
 // handlePacket обрабатывает входящий пакет от сервера
 func (c *GameTunnelClientConn) handlePacket(rawData []byte) {
 	// Деобфусцируем входящий пакет
-	data, err := c.obfs.Unwrap(rawData)
+	data, err := unwrapPacket(c.obfs, rawData)
 	if err != nil {
+		invalidPacketLog.logf(log.Severity_Debug, "%s dropped packet from server: unwrap: %v", sessionTag(c.session().ConnectionID), err)
+		c.drop(dropNotQUICLike)
 		return
 	}
 
-	if len(data) == 0 || !IsQUICLike(data[0]) {
+	// Сжатый заголовок - восстанавливаем полный (compact.go)
+	if len(data) > 0 && isCompactPacket(data[0]) {
+		session := c.session()
+		if session.compact == nil {
+			c.drop(dropNotQUICLike)
+			return
+		}
+		if data, err = session.compact.expand(data, session.ReplayWindow.largest()); err != nil {
+			invalidPacketLog.logf(log.Severity_Debug, "%s dropped packet from server: %v", sessionTag(session.ConnectionID), err)
+			c.drop(dropMalformed)
+			return
+		}
+	}
+
+	if len(data) == 0 {
+		c.drop(dropTooShort)
+		return
+	}
+	if !IsQUICLike(data[0]) {
+		invalidPacketLog.logf(log.Severity_Debug, "%s dropped packet from server: not a GameTunnel packet", sessionTag(c.session().ConnectionID))
+		c.drop(dropNotQUICLike)
 		return
 	}
 
 	pktType, _, err := DecodeFlags(data[0])
 	if err != nil {
+		c.drop(dropMalformed)
 		return
 	}
+	c.pathRecovered()
 
 	switch pktType {
 	case PacketType_DATA:
 		c.handleDataPacket(data)
 
 	case PacketType_KEEPALIVE:
-		// Сервер ответил на keep-alive - ничего не делаем
+		// Сервер ответил на keep-alive - замеряем RTT
+		if sentAt := atomic.SwapInt64(&c.keepAliveSentAt, 0); sentAt != 0 {
+			rtt := time.Since(time.Unix(0, sentAt))
+			metrics.observeRTT(rtt)
+			c.traffic.observeReply(rtt)
+		}
+		c.piggyback.answered()
 		return
 
 	case PacketType_CONTROL:
 		c.handleControlPacket(data)
+
+	case PacketType_HANDSHAKE:
+		// Server Hello сессии 0-RTT (zerortt.go)
+		c.handleServerHello(data)
 	}
 }
 
 // handleDataPacket расшифровывает и передаёт данные
 func (c *GameTunnelClientConn) handleDataPacket(data []byte) {
-	pkt, err := Unmarshal(data, int(c.config.ConnectionIdLength))
+	session := c.session()
+	// Без копий: payload сразу расшифровывается в буфер пула (bufpool.go)
+	pkt, err := UnmarshalView(data, int(c.config.ConnectionIdLength))
 	if err != nil {
+		c.drop(dropMalformed)
 		return
 	}
 
 	// Anti-replay: проверяем что пакет не дубликат
-	if c.session.ReplayWindow != nil && !c.session.ReplayWindow.Check(pkt.PacketNumber) {
+	if session.ReplayWindow != nil && !session.ReplayWindow.Check(pkt.PacketNumber) {
+		c.drop(dropReplay)
 		return
 	}
 
@@ -320,31 +812,58 @@ func (c *GameTunnelClientConn) handleDataPacket(data []byte) {
 	connIDLen := int(c.config.ConnectionIdLength)
 	adLen := FlagsSize + VersionSize + connIDLen
 	if len(data) < adLen {
+		c.drop(dropTooShort)
 		return
 	}
 	additionalData := data[:adLen]
 
 	// Расшифровываем
-	plaintext, err := c.session.Keys.Decrypt(pkt.Payload, pkt.PacketNumber, additionalData)
+	plaintext, err := decryptPlain(session.Keys, pkt.Payload, pkt.PacketNumber, additionalData)
 	if err != nil {
+		atomic.AddUint64(&metrics.client.decryptFailures, 1)
+		c.drop(dropDecrypt)
+		decryptFailureLog.logf(log.Severity_Warning, "%s packet %d from server not decrypted (key mismatch or corruption): %v",
+			sessionTag(session.ConnectionID), pkt.PacketNumber, err)
 		return
 	}
+	c.markAuthenticated()
+	session.compact.received(pkt.ConnectionID)
+	if plaintext, err = session.timestamps.open(plaintext, timestampNow()); err != nil {
+		c.drop(dropMalformed)
+		return
+	}
+	tapOf(c.obfs).payload(false, session.ConnectionID, pkt.PacketNumber, plaintext)
+	atomic.AddUint64(&metrics.client.packetsRecv, 1)
+	atomic.AddUint64(&metrics.client.bytesRecv, uint64(len(plaintext)))
+	c.xstats.add("", 0, uint64(len(plaintext)))
+	c.traffic.countRecv(len(plaintext))
 
 	// Обновляем счётчик
-	atomic.StoreUint32(&c.session.RecvPacketNum, pkt.PacketNumber)
+	atomic.StoreUint32(&session.RecvPacketNum, pkt.PacketNumber)
+
+	// Пустой DATA - покрывающий трафик (chaff.go), приложению не нужен
+	if len(plaintext) == 0 {
+		return
+	}
 
-	// Передаём данные в канал чтения (безопасно через closeCh)
-	select {
-	case <-c.closeCh:
+	// Общая сессия: кадр в поток (mux.go)
+	if c.mux != nil {
+		c.deliverStream(plaintext)
 		return
-	case c.session.inbound <- plaintext:
-	default:
-		// Буфер полон - дропаем (нормально для UDP)
 	}
+	// Потоки приложения: кадр в поток или соединению (streams.go)
+	if c.streams != nil {
+		c.deliverAppStream(session, plaintext)
+		return
+	}
+
+	// Передаём данные в очередь чтения; полная - потеря, как в UDP
+	c.pushInbound(session, plaintext)
 }
 
 // handleControlPacket обрабатывает управляющий пакет
 func (c *GameTunnelClientConn) handleControlPacket(data []byte) {
+	session := c.session()
 	pkt, err := Unmarshal(data, int(c.config.ConnectionIdLength))
 	if err != nil {
 		return
@@ -355,57 +874,119 @@ func (c *GameTunnelClientConn) handleControlPacket(data []byte) {
 	}
 
 	switch pkt.Payload[0] {
-	case 0x00: // Close - сервер закрыл соединение
-		c.Close()
-
-	case 0x01: // Ping - отвечаем Pong
-		pktNum := atomic.AddUint32(&c.session.SendPacketNum, 1)
-		pong := NewControlPacket(c.session.ConnectionID, pktNum, []byte{0x02})
-		response, err := pong.Marshal(c.config)
-		if err == nil {
-			wrapped, wErr := c.obfs.Wrap(response)
-			if wErr == nil {
-				c.conn.Write(wrapped)
+	case 0x03: // Close с причиной - принимаем только с валидной подписью
+		adLen := FlagsSize + VersionSize + int(c.config.ConnectionIdLength)
+		if len(data) < adLen {
+			return
+		}
+		reason, err := openClose(session.Keys, pkt.Payload[1:], pkt.PacketNumber, data[:adLen])
+		if err != nil {
+			return
+		}
+		if session.ReplayWindow != nil && !session.ReplayWindow.Check(pkt.PacketNumber) {
+			return
+		}
+		c.markAuthenticated()
+
+		// Сервер перезапускается - заводим новую сессию (reconnect.go)
+		if reason == CloseReason_SERVER_SHUTDOWN && c.startReconnect() {
+			return
+		}
+		atomic.StoreInt32(&c.closeReason, int32(reason))
+		c.shutdown()
+
+	case 0x04: // Probe живости от сервера - отвечаем тем же токеном
+		if token, ok := c.openControl(session, pkt, data); ok {
+			c.sendSealedControl(0x05, token)
+		}
+
+	case 0x07: // PATH_CHALLENGE к новому адресу (roaming.go)
+		if token, ok := c.openControl(session, pkt, data); ok {
+			c.sendSealedControl(0x08, token)
+		}
+
+	case 0x08: // PATH_RESPONSE на наш PATH_CHALLENGE
+		if token, ok := c.openControl(session, pkt, data); ok {
+			c.handlePathResponse(token)
+		}
+
+	case 0x0A: // STREAM_CLOSE - сервер закрыл поток общей сессии (mux.go)
+		if body, ok := c.openControl(session, pkt, data); ok {
+			c.handleStreamClose(body)
+		}
+
+	case controlRetry: // Задача сервера на Client Hello 0-RTT (puzzle.go)
+		if retry, ok := c.openRetry(session, pkt.Payload); ok {
+			select {
+			case session.pending.retry <- retry:
+			default:
+			}
+		}
+
+	case 0x0C: // MTU_ACK - дошедшая проба MTU (mtu.go)
+		if body, ok := c.openControl(session, pkt, data); ok && c.mtu != nil {
+			c.mtu.ack(body)
+		}
+
+	case 0x0F: // Ответ на пробу NAT (nattune.go)
+		if body, ok := c.openControl(session, pkt, data); ok && c.natTune != nil {
+			c.natTune.ack(body, atomic.LoadInt64(&c.lastSendAt))
+		}
+
+	case 0x02: // Pong на наш Ping (quality.go)
+		if body, ok := c.openControl(session, pkt, data); ok {
+			if rtt, ok := c.traffic.pongReceived(body, time.Now()); ok {
+				metrics.observeRTT(rtt)
 			}
 		}
+
+	case 0x01: // Ping замера качества (quality.go) - Pong с тем же телом
+		if body, ok := c.openControl(session, pkt, data); ok {
+			c.sendSealedControl(0x02, body)
+		}
 	}
 }
 
-// maybeKeepAlive отправляет keep-alive если нужно
-func (c *GameTunnelClientConn) maybeKeepAlive() {
-	if c.config.KeepAliveInterval == 0 {
-		return
+// sendSealedControl отправляет CONTROL [cmd][AEAD(body)] ключами сессии
+func (c *GameTunnelClientConn) sendSealedControl(cmd byte, body []byte) error {
+	wrapped, err := c.sealControl(cmd, body)
+	if err != nil {
+		return err
 	}
+	_, err = c.write(wrapped, PriorityHigh)
+	return err
+}
 
-	pktNum := atomic.AddUint32(&c.session.SendPacketNum, 1)
-	keepAlive := NewKeepAlivePacket(c.session.ConnectionID, pktNum)
+// sealControl собирает обфусцированный CONTROL [cmd][AEAD(body)]
+func (c *GameTunnelClientConn) sealControl(cmd byte, body []byte) ([]byte, error) {
+	session := c.session()
+	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
+	ad := controlAdditionalData(session.ConnectionID, pktNum)
 
-	data, err := keepAlive.Marshal(c.config)
+	sealed, err := session.Keys.Encrypt(body, pktNum, ad)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("encrypt control 0x%02x: %w", cmd, err)
 	}
 
+	payload := make([]byte, 0, 1+len(sealed))
+	payload = append(payload, cmd)
+	payload = append(payload, sealed...)
+
+	data, err := NewControlPacket(session.ConnectionID, pktNum, payload).Marshal(c.config)
+	if err != nil {
+		return nil, fmt.Errorf("marshal control 0x%02x: %w", cmd, err)
+	}
 	wrapped, err := c.obfs.Wrap(data)
 	if err != nil {
-		return
+		return nil, fmt.Errorf("wrap control 0x%02x: %w", cmd, err)
 	}
-
-	c.conn.Write(wrapped)
+	return wrapped, nil
 }
 
 // Read читает расшифрованные данные от сервера
 func (c *GameTunnelClientConn) Read(b []byte) (int, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Проверяем остаток в буфере
-	if c.readOffset < len(c.readBuf) {
-		n := copy(b, c.readBuf[c.readOffset:])
-		c.readOffset += n
-		if c.readOffset >= len(c.readBuf) {
-			c.readBuf = nil
-			c.readOffset = 0
-		}
+	// Проверяем остаток
+	if n, ok := c.rest.read(b); ok {
 		return n, nil
 	}
 
@@ -413,21 +994,8 @@ func (c *GameTunnelClientConn) Read(b []byte) (int, error) {
 		return 0, io.EOF
 	}
 
-	// Блокируемся с проверкой закрытия через closeCh
-	select {
-	case data, ok := <-c.session.inbound:
-		if !ok {
-			return 0, io.EOF
-		}
-		n := copy(b, data)
-		if n < len(data) {
-			c.readBuf = data
-			c.readOffset = n
-		}
-		return n, nil
-	case <-c.closeCh:
-		return 0, io.EOF
-	}
+	// Блокируемся с проверкой закрытия через ctx
+	return c.rest.readPacket(b, c.session().inbound, c.ctx.Done())
 }
 
 // Write отправляет данные серверу через зашифрованный туннель
@@ -435,8 +1003,31 @@ func (c *GameTunnelClientConn) Write(b []byte) (int, error) {
 	if atomic.LoadInt32(&c.closed) == 1 {
 		return 0, io.ErrClosedPipe
 	}
+	// С потоками приложения соединение - поток 0 (streams.go)
+	if c.streams != nil {
+		return c.writeStream(0, b)
+	}
+	c.recordOpening(b)
+	release, err := c.holdEarly(b)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	return c.writeData(b)
+}
+
+// writeData режет данные на DATA-пакеты или отдаёт их склейке
+func (c *GameTunnelClientConn) writeData(b []byte) (int, error) {
+	// Склейка мелких записей (coalesce.go); после её выключения хвост
+	// буфера уходит раньше этой записи
+	if c.coalescing() {
+		return c.writeCoalesced(b)
+	}
+	if atomic.LoadInt32(&c.coalesce.used) == 1 {
+		c.flushCoalesced()
+	}
 
-	maxPayload := int(c.config.GetMaxPayloadSize())
+	maxPayload := c.maxPayload()
 	totalWritten := 0
 
 	for totalWritten < len(b) {
@@ -444,88 +1035,251 @@ func (c *GameTunnelClientConn) Write(b []byte) (int, error) {
 		if end > len(b) {
 			end = len(b)
 		}
+		if err := c.sendData(b[totalWritten:end]); err != nil {
+			return totalWritten, err
+		}
+		totalWritten = end
+	}
 
-		chunk := b[totalWritten:end]
-		pktNum := atomic.AddUint32(&c.session.SendPacketNum, 1)
+	return totalWritten, nil
+}
 
-		// Формируем additional data
-		connIDLen := int(c.config.ConnectionIdLength)
-		tempPkt := NewDataPacket(c.session.ConnectionID, pktNum, nil, c.config.EnablePadding)
-		tempFlags := tempPkt.EncodeFlags()
-		ad := make([]byte, FlagsSize+VersionSize+connIDLen)
-		ad[0] = tempFlags
-		ad[1] = byte(FakeQUICVersion >> 24)
-		ad[2] = byte(FakeQUICVersion >> 16)
-		ad[3] = byte(FakeQUICVersion >> 8)
-		ad[4] = byte(FakeQUICVersion)
-		copy(ad[FlagsSize+VersionSize:], c.session.ConnectionID)
+// sendData шифрует чанк в DATA-пакет и ставит в очередь приоритетов,
+// классифицируя по открытому тексту
+func (c *GameTunnelClientConn) sendData(chunk []byte) error {
+	return c.enqueueData(0, chunk, c.classify(chunk))
+}
 
-		// Шифруем
-		ciphertext, err := c.session.Keys.Encrypt(chunk, pktNum, ad)
-		if err != nil {
-			return totalWritten, fmt.Errorf("encrypt: %w", err)
+// enqueueData шифрует чанк потока id в DATA-пакет и ставит в очередь
+// класса level
+func (c *GameTunnelClientConn) enqueueData(id uint16, chunk []byte, level PriorityLevel) error {
+	session := c.session()
+	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
+
+	// Additional data - из кэша сессии, он же шаблон заголовка пакета;
+	// пакет может нести запрос keep-alive (piggyback.go)
+	ad, keepAlive := c.takeKeepAlive(loadDataAD(&session.dataAD, session.ConnectionID, c.config.EnablePadding))
+
+	// Шифротекст и пакет - в буферах пула (bufpool.go)
+	sealBuf, packetBuf := getPacketBuf(), getPacketBuf()
+	defer putPacketBuf(sealBuf)
+	defer putPacketBuf(packetBuf)
+
+	// Шифруем; метки времени - перед данными (timestamps.go)
+	start := latencyStart()
+	tapOf(c.obfs).payload(true, session.ConnectionID, pktNum, chunk)
+	plainBuf := getPacketBuf()
+	defer putPacketBuf(plainBuf)
+	plaintext := session.timestamps.seal((*plainBuf)[:0], chunk, timestampNow())
+	ciphertext, err := session.Keys.EncryptTo((*sealBuf)[:0], plaintext, pktNum, ad)
+	if err != nil {
+		return fmt.Errorf("encrypt: %w", err)
+	}
+
+	// Собираем пакет по шаблону заголовка; High - со сжатым
+	// заголовком, если он согласован (compact.go)
+	pnLen := 0
+	if level == PriorityHigh && !keepAlive {
+		pnLen = session.compact.pnLen(session.dataAD.Load(), pktNum, len(ciphertext))
+	}
+	// Padding - по загрузке канала (padding.go)
+	config := c.padding.config(&c.paddingVariant, c.config, session.timestamps)
+	var data, wrapped []byte
+	if pnLen > 0 {
+		data = appendCompactPacket((*packetBuf)[:0], session.compact.id, ad, pktNum, pnLen, ciphertext, config)
+		wrapped, err = wrapCompact(c.obfs, data)
+	} else {
+		data = appendDataPacket((*packetBuf)[:0], ad, pktNum, ciphertext, config)
+		wrapped, err = c.obfs.Wrap(data)
+	}
+	if err != nil {
+		return fmt.Errorf("wrap: %w", err)
+	}
+	wrapped = detach(wrapped, data)
+	metrics.clientLatency.since(latencyEncrypt, level, start)
+
+	// Переполнение очереди - потеря пакета, как и для любого UDP:
+	// отброшенный пакет отправленным не считается
+	if !c.queue.enqueue(wrapped, level, nil, session.duplicated(id, level)) {
+		return nil
+	}
+	atomic.AddUint64(&metrics.client.packetsSent, 1)
+	atomic.AddUint64(&metrics.client.bytesSent, uint64(len(chunk)))
+	c.xstats.add("", uint64(len(chunk)), 0)
+	c.traffic.countSent(len(chunk))
+	return nil
+}
+
+// classify определяет приоритет исходящего чанка
+// Закреплённый приоритет потока важнее решения Classifier
+func (c *GameTunnelClientConn) classify(chunk []byte) PriorityLevel {
+	session := c.session()
+	flow := session.flow.observe(len(chunk), time.Now())
+
+	session.mu.RLock()
+	level, pinned := lookupStreamPriority(session.Streams, 0)
+	session.mu.RUnlock()
+
+	if pinned {
+		return level
+	}
+	return c.classifier.Load().(classifierHolder).Classify(&flow, chunk)
+}
+
+// SetClassifier заменяет стратегию классификации исходящих пакетов
+func (c *GameTunnelClientConn) SetClassifier(classifier Classifier) {
+	c.classifier.Store(classifierHolder{classifier})
+}
+
+// SetPriority закрепляет приоритет потока streamID: 0 - само
+// соединение, иначе открытый поток OpenStream
+// PriorityAuto возвращает классификацию по размеру пакета
+func (c *GameTunnelClientConn) SetPriority(streamID uint16, level PriorityLevel) error {
+	session := c.session()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	// Под mu: закрытие потока снимает его приоритет после нас
+	if err := checkStream(c.streams, streamID); err != nil {
+		return err
+	}
+	return setStreamPriority(session.Streams, streamID, level, c.config.MaxStreams)
+}
+
+// GetQueueStats возвращает статистику очереди отправки по классам
+func (c *GameTunnelClientConn) GetQueueStats() PriorityQueueStats {
+	return c.queue.GetStats()
+}
+
+// sendLoop выводит пакеты из очереди приоритетов на провод
+func (c *GameTunnelClientConn) sendLoop() {
+	for {
+		pkt := c.queue.DequeueBlocking()
+		if pkt == nil {
+			return
 		}
+		metrics.clientLatency.sample(pkt)
 
-		// Собираем пакет
-		pkt := NewDataPacket(c.session.ConnectionID, pktNum, ciphertext, c.config.EnablePadding)
-		data, err := pkt.Marshal(c.config)
-		if err != nil {
-			return totalWritten, fmt.Errorf("marshal: %w", err)
+		if atomic.LoadInt32(&c.closed) == 1 {
+			continue
 		}
 
-		// Обфусцируем
-		wrapped, err := c.obfs.Wrap(data)
-		if err != nil {
-			return totalWritten, fmt.Errorf("wrap: %w", err)
+		wait, ok := admitPacket(c.config.RateLimitPolicy, len(pkt.Data), c.limiter, c.classLimiters[pkt.Priority])
+		if !ok {
+			atomic.AddUint64(&c.rateLimited, 1)
+			continue
+		}
+		if pace := c.pacer.delay(len(pkt.Data), pkt.Priority, c.session().timestamps); pace > wait {
+			wait = pace
+		}
+		if wait > 0 && !sleepContext(c.ctx, wait) {
+			return
 		}
 
-		// Отправляем
-		_, err = c.conn.Write(wrapped)
+		n, err := c.write(pkt.Data, pkt.Priority)
 		if err != nil {
-			return totalWritten, fmt.Errorf("send: %w", err)
+			continue
 		}
-
-		totalWritten = end
+		if pkt.Duplicate {
+			n += c.writeCopy(pkt.Data, pkt.Priority)
+		}
+		atomic.StoreInt64(&c.lastSendAt, time.Now().UnixNano())
+		c.bandwidth.RecordBytes(uint64(n))
 	}
+}
 
-	return totalWritten, nil
+// CloseReason возвращает причину, с которой сервер закрыл соединение
+// CloseReason_NORMAL, если сервер причину не сообщал
+func (c *GameTunnelClientConn) CloseReason() CloseReason {
+	return CloseReason(atomic.LoadInt32(&c.closeReason))
 }
 
 // Close закрывает клиентское соединение
+// Возвращается после завершения горутин соединения
 func (c *GameTunnelClientConn) Close() error {
+	if atomic.LoadInt32(&c.coalesce.used) == 1 {
+		c.flushCoalesced()
+	}
+	c.shutdown()
+	c.wg.Wait()
+	return nil
+}
+
+// goLoop запускает горутину соединения с учётом в wg
+// name - pprof-метка горутины (debug.go)
+func (c *GameTunnelClientConn) goLoop(name string, loop func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		goLabeled("client."+name, loop)
+	}()
+}
+
+// shutdown закрывает соединение, не дожидаясь горутин
+// Вызывается и из receiveLoop (CLOSE от сервера), поэтому не ждёт wg
+func (c *GameTunnelClientConn) shutdown() {
 	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
-		return nil
+		return
 	}
+	session := c.session()
+	logf(log.Severity_Info, "%s connection closed: %s", sessionTag(session.ConnectionID), c.CloseReason())
 
 	// Отправляем Control Close серверу
-	pktNum := atomic.AddUint32(&c.session.SendPacketNum, 1)
-	closePkt := NewControlPacket(c.session.ConnectionID, pktNum, []byte{0x00})
-	data, err := closePkt.Marshal(c.config)
-	if err == nil {
-		wrapped, wErr := c.obfs.Wrap(data)
-		if wErr == nil {
-			c.conn.Write(wrapped)
-		}
+	if wrapped, err := c.closeControl(); err == nil {
+		c.write(wrapped, PriorityHigh)
 	}
 
+	metrics.unregisterClient(c)
+
 	// Сигнализируем горутинам о закрытии
-	close(c.closeCh)
+	c.cancel()
+	c.queue.Close()
 
 	// Закрываем сокет (receiveLoop завершится по ошибке чтения)
-	c.conn.Close()
-	c.done.Close()
+	// Под pathMu: rebind не подменит его после закрытия
+	c.pathMu.Lock()
+	c.socket().conn.Close()
+	if c.multipath != nil {
+		c.multipath.close()
+	}
+	if c.classes != nil {
+		c.classes.close()
+	}
+	c.pathMu.Unlock()
 
-	return nil
+	// Потоки общей сессии получают EOF (mux.go)
+	if c.mux != nil {
+		sharedSessions.remove(c)
+		c.mux.closeAll()
+	}
+	if c.streams != nil {
+		c.streams.closeAll()
+	}
+	// Непрочитанное больше никому не нужно (inbound.go)
+	c.session().inbound.discard()
+
+	if !c.observers.empty() {
+		info, reason := c.info(), c.CloseReason()
+		c.observers.each(func(o SessionObserver) { o.SessionClosed(info, reason) })
+	}
+	c.traceSession()
+	c.metricsServer.release()
+	c.debug.release()
+}
+
+// closeControl собирает обфусцированный аутентифицированный CLOSE
+// сессии (drain.go)
+func (c *GameTunnelClientConn) closeControl() ([]byte, error) {
+	return c.sealControl(0x03, []byte{byte(CloseReason_NORMAL)})
 }
 
 // LocalAddr возвращает локальный адрес
 func (c *GameTunnelClientConn) LocalAddr() net.Addr {
-	return c.conn.LocalAddr()
+	return c.socket().conn.LocalAddr()
 }
 
 // RemoteAddr возвращает адрес сервера
 func (c *GameTunnelClientConn) RemoteAddr() net.Addr {
-	return c.conn.RemoteAddr()
+	return c.socket().conn.RemoteAddr()
 }
 
 // SetDeadline - заглушка для net.Conn
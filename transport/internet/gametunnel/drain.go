@@ -0,0 +1,221 @@
+package gametunnel
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Плавная остановка сервера (drain)
+// ====================================================================
+//
+// Hub.Stop закрывает сессии молча: клиенты узнают об этом только
+// по таймауту keep-alive. Drain останавливает сервер аккуратно:
+//   1. перестаёт принимать новые хэндшейки
+//   2. ждёт, пока очереди сессий уйдут в сеть (не дольше ctx)
+//   3. шлёт каждой сессии CLOSE(ServerShutdown)
+//   4. останавливает хаб
+//
+// CLOSE отправляется после сброса очередей, чтобы клиент не закрыл
+// соединение раньше, чем получит последние данные.
+//
+// Формат управляющего payload:
+//
+//	[0x03][AEAD(reason)]
+//
+// Причина зашифрована ключами сессии с заголовком пакета в
+// качестве additional data: подделать закрытие чужой сессии,
+// зная только Connection ID, нельзя. Тем же CLOSE(Normal) закрывают
+// сессию GameTunnelConn.Close и клиент; открытого Close протокол
+// не принимает.
+//
+// ====================================================================
+
+// CloseReason - причина закрытия сессии
+type CloseReason int32
+
+const (
+	// CloseReason_NORMAL - обычное закрытие
+	CloseReason_NORMAL CloseReason = 0
+
+	// CloseReason_SERVER_SHUTDOWN - сервер останавливается
+	CloseReason_SERVER_SHUTDOWN CloseReason = 1
+
+	// CloseReason_KICKED - сессия закрыта оператором (API управления)
+	CloseReason_KICKED CloseReason = 2
+
+	// CloseReason_QUOTA_EXCEEDED - исчерпана квота трафика (quota.go)
+	CloseReason_QUOTA_EXCEEDED CloseReason = 3
+
+	// CloseReason_EVICTED - сессия вытеснена по лимиту сессий (overload.go)
+	CloseReason_EVICTED CloseReason = 4
+
+	// Локальные причины - только для наблюдателей (observer.go),
+	// клиенту не отправляются: он уже не отвечает
+
+	// CloseReason_TIMEOUT - сессия молчала дольше sessionTimeout
+	CloseReason_TIMEOUT CloseReason = 5
+
+	// CloseReason_DEAD_PEER - клиент не ответил на probe (deadpeer.go)
+	CloseReason_DEAD_PEER CloseReason = 6
+
+	// CloseReason_MIGRATED - сессию подхватил другой сервер кластера
+	// (cluster.go)
+	CloseReason_MIGRATED CloseReason = 7
+)
+
+// String - имя причины для журнала
+func (r CloseReason) String() string {
+	switch r {
+	case CloseReason_NORMAL:
+		return "normal"
+	case CloseReason_SERVER_SHUTDOWN:
+		return "server shutdown"
+	case CloseReason_KICKED:
+		return "kicked"
+	case CloseReason_QUOTA_EXCEEDED:
+		return "quota exceeded"
+	case CloseReason_EVICTED:
+		return "evicted"
+	case CloseReason_TIMEOUT:
+		return "timeout"
+	case CloseReason_DEAD_PEER:
+		return "dead peer"
+	case CloseReason_MIGRATED:
+		return "migrated"
+	default:
+		return fmt.Sprintf("reason %d", int32(r))
+	}
+}
+
+const (
+	// defaultDrainTimeout - предел ожидания, если у ctx нет дедлайна
+	defaultDrainTimeout = 10 * time.Second
+
+	// drainPollInterval - период проверки очередей при drain
+	drainPollInterval = 10 * time.Millisecond
+)
+
+// Drain плавно останавливает хаб: новые хэндшейки отклоняются,
+// очереди сессий сбрасываются (ограничено ctx), клиентам
+// отправляется CLOSE(ServerShutdown), затем вызывается Stop.
+// Возвращает ошибку, если очереди не успели опустеть
+func (h *Hub) Drain(ctx context.Context) error {
+	if atomic.LoadInt32(&h.closed) == 1 {
+		return nil
+	}
+	atomic.StoreInt32(&h.draining, 1)
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultDrainTimeout)
+		defer cancel()
+	}
+
+	err := h.waitQueuesFlushed(ctx)
+
+	for _, session := range h.sessions.snapshot() {
+		h.sendClose(session, CloseReason_SERVER_SHUTDOWN)
+	}
+
+	h.Stop()
+	return err
+}
+
+// IsDraining - хаб в режиме drain и не принимает новые сессии
+func (h *Hub) IsDraining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// waitQueuesFlushed ждёт опустошения очередей всех сессий
+func (h *Hub) waitQueuesFlushed(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		queued := 0
+		for _, session := range h.sessions.snapshot() {
+			queued += session.queue.Len()
+		}
+		if queued == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("drain: %d packets still queued: %w", queued, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendClose отправляет сессии аутентифицированный CLOSE с причиной
+func (h *Hub) sendClose(session *Session, reason CloseReason) error {
+	return h.sendSealedControl(session, 0x03, []byte{byte(reason)})
+}
+
+// sendSealedControl отправляет CONTROL [cmd][AEAD(body)] ключами сессии
+func (h *Hub) sendSealedControl(session *Session, cmd byte, body []byte) error {
+	wrapped, err := h.sealControl(session, cmd, body)
+	if err != nil {
+		return err
+	}
+	_, err = h.writeToSession(session, wrapped, PriorityHigh)
+	return err
+}
+
+// sealControl собирает обфусцированный CONTROL [cmd][AEAD(body)]
+func (h *Hub) sealControl(session *Session, cmd byte, body []byte) ([]byte, error) {
+	if session.Keys == nil {
+		return nil, fmt.Errorf("session has no keys")
+	}
+
+	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
+	ad := controlAdditionalData(session.ID, pktNum)
+
+	sealed, err := session.Keys.Encrypt(body, pktNum, ad)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt control 0x%02x: %w", cmd, err)
+	}
+
+	payload := make([]byte, 0, 1+len(sealed))
+	payload = append(payload, cmd)
+	payload = append(payload, sealed...)
+
+	data, err := NewControlPacket(session.ID, pktNum, payload).Marshal(h.getConfig())
+	if err != nil {
+		return nil, fmt.Errorf("marshal control 0x%02x: %w", cmd, err)
+	}
+	wrapped, err := h.obfs.Wrap(data)
+	if err != nil {
+		return nil, fmt.Errorf("wrap control 0x%02x: %w", cmd, err)
+	}
+	return wrapped, nil
+}
+
+// controlAdditionalData - заголовок CONTROL-пакета как additional data
+func controlAdditionalData(connID []byte, pktNum uint32) []byte {
+	flags := NewControlPacket(connID, pktNum, nil).EncodeFlags()
+	ad := make([]byte, FlagsSize+VersionSize+len(connID))
+	ad[0] = flags
+	ad[1] = byte(FakeQUICVersion >> 24)
+	ad[2] = byte(FakeQUICVersion >> 16)
+	ad[3] = byte(FakeQUICVersion >> 8)
+	ad[4] = byte(FakeQUICVersion)
+	copy(ad[FlagsSize+VersionSize:], connID)
+	return ad
+}
+
+// openClose расшифровывает причину из payload CLOSE (без байта команды)
+func openClose(keys *SessionKeys, sealed []byte, pktNum uint32, ad []byte) (CloseReason, error) {
+	plaintext, err := keys.Decrypt(sealed, pktNum, ad)
+	if err != nil {
+		return CloseReason_NORMAL, fmt.Errorf("decrypt close: %w", err)
+	}
+	if len(plaintext) != 1 {
+		return CloseReason_NORMAL, fmt.Errorf("invalid close payload length %d", len(plaintext))
+	}
+	return CloseReason(plaintext[0]), nil
+}
@@ -0,0 +1,136 @@
+package gametunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenerDrainAndStop(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "drain"
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Данные, поставленные в очередь до drain, должны дойти
+	if _, err := server.Write([]byte("last words")); err != nil {
+		t.Fatalf("server Write: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := l.DrainAndStop(ctx); err != nil {
+		t.Fatalf("DrainAndStop: %v", err)
+	}
+
+	if got := readWithTimeout(t, client, 2048); string(got) != "last words" {
+		t.Errorf("client got %q before close", got)
+	}
+
+	// После CLOSE(ServerShutdown) клиент закрывается сам
+	deadline := time.Now().Add(5 * time.Second)
+	for client.CloseReason() != CloseReason_SERVER_SHUTDOWN {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not receive ServerShutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := client.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Read after shutdown: got %v, want EOF", err)
+	}
+}
+
+func TestHubDrainRejectsHandshakes(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	h.Start()
+
+	if err := h.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain of idle hub: %v", err)
+	}
+	if !h.IsDraining() {
+		t.Fatal("Hub should report draining")
+	}
+
+	// Пакет хэндшейка с неизвестным Connection ID
+	connID := make([]byte, h.getConfig().ConnectionIdLength)
+	data, err := NewHandshakePacket(connID, 1, make([]byte, 64)).Marshal(h.getConfig())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	if _, _, err := h.RoutePacket(data, addr); err == nil {
+		t.Error("Handshake accepted while draining")
+	}
+}
+
+func TestCloseFrameAuthentication(t *testing.T) {
+	var secret [Curve25519KeySize]byte
+	secret[0] = 42
+	serverKeys, err := DeriveSessionKeys(secret, "drain", false)
+	if err != nil {
+		t.Fatalf("DeriveSessionKeys: %v", err)
+	}
+	clientKeys, err := DeriveSessionKeys(secret, "drain", true)
+	if err != nil {
+		t.Fatalf("DeriveSessionKeys: %v", err)
+	}
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	ad := controlAdditionalData(connID, 7)
+	sealed, err := serverKeys.Encrypt([]byte{byte(CloseReason_SERVER_SHUTDOWN)}, 7, ad)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+
+	reason, err := openClose(clientKeys, sealed, 7, ad)
+	if err != nil || reason != CloseReason_SERVER_SHUTDOWN {
+		t.Fatalf("openClose: reason=%d err=%v", reason, err)
+	}
+
+	// Подмена Connection ID в заголовке ломает подпись
+	forged := controlAdditionalData([]byte{9, 9, 9, 9, 9, 9, 9, 9}, 7)
+	if _, err := openClose(clientKeys, sealed, 7, forged); err == nil {
+		t.Error("Close frame with forged header accepted")
+	}
+}
+
+func TestUnsealedCloseIgnored(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "drain"
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	id := client.session().ConnectionID
+
+	// Открытый Close с адреса клиента: off-path подделка, знающая
+	// только Connection ID
+	data, _ := NewControlPacket(id, 1<<20, []byte{0x00}).Marshal(config)
+	wrapped, _ := l.hub.obfs.Wrap(data)
+	client.socket().conn.Write(wrapped)
+	time.Sleep(100 * time.Millisecond)
+
+	if l.hub.GetSession(id) == nil {
+		t.Fatal("unsealed Close removed the session")
+	}
+	if _, err := server.Write([]byte("still here")); err != nil {
+		t.Fatalf("server Write: %v", err)
+	}
+	if got := readWithTimeout(t, client, 2048); string(got) != "still here" {
+		t.Fatalf("client got %q", got)
+	}
+
+	// Запечатанный CLOSE клиента закрывает сессию на сервере
+	client.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for l.hub.GetSession(id) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("sealed Close did not remove the session")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
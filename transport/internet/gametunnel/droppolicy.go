@@ -0,0 +1,182 @@
+package gametunnel
+
+import (
+	"math"
+	"time"
+)
+
+// ====================================================================
+// Политики отбрасывания пакетов очереди
+// ====================================================================
+//
+// tail  - полная очередь отбрасывает новый пакет (по умолчанию)
+// head  - полная очередь отбрасывает самый старый пакет класса:
+//         для игр свежее состояние ценнее устаревшего
+// codel - tail для переполнения плюс CoDel для Low: если пакеты
+//         Low ждут в очереди дольше codelTarget на протяжении
+//         codelInterval, головные пакеты отбрасываются со
+//         всё возрастающей частотой (interval/√count), пока
+//         задержка не вернётся к цели
+//
+// Счётчики разделяют причины потерь:
+//   overflow - не хватило места в очереди класса
+//   bump     - Low вытеснен ради High (tryBump)
+//   codel    - Low отброшен по задержке
+//
+// ====================================================================
+
+// DropPolicy - политика отбрасывания при переполнении
+type DropPolicy int32
+
+const (
+	// DropPolicy_TAIL - отбросить новый пакет
+	DropPolicy_TAIL DropPolicy = 0
+
+	// DropPolicy_HEAD - отбросить самый старый пакет класса
+	DropPolicy_HEAD DropPolicy = 1
+
+	// DropPolicy_CODEL - tail-drop + CoDel для Low
+	DropPolicy_CODEL DropPolicy = 2
+)
+
+const (
+	// codelTarget - допустимая задержка Low в очереди
+	codelTarget = 5 * time.Millisecond
+
+	// codelInterval - сколько задержка должна превышать цель
+	// до начала отбрасывания (порядка худшего RTT)
+	codelInterval = 100 * time.Millisecond
+)
+
+// DropPolicyFromString парсит строковое значение политики отбрасывания
+func DropPolicyFromString(s string) DropPolicy {
+	switch s {
+	case "head", "head-drop", "HEAD":
+		return DropPolicy_HEAD
+	case "codel", "CODEL":
+		return DropPolicy_CODEL
+	default:
+		return DropPolicy_TAIL
+	}
+}
+
+// SetDropPolicy переключает политику отбрасывания очереди
+func (pq *PriorityQueue) SetDropPolicy(policy DropPolicy) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.dropPolicy = policy
+	pq.codel = codelState{}
+}
+
+// SetLowDropHead включает вытеснение старых пакетов Low при
+// переполнении, какой бы ни была dropPolicy
+func (pq *PriorityQueue) SetLowDropHead(enabled bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.lowDropHead = enabled
+}
+
+// codelState - состояние CoDel (RFC 8289) для очереди Low
+type codelState struct {
+	// firstAbove - момент, когда задержка выше цели станет «устойчивой»
+	firstAbove time.Time
+
+	// dropNext - время следующего отбрасывания в режиме dropping
+	dropNext time.Time
+
+	// count - отбрасываний в текущем эпизоде
+	count uint32
+
+	// dropping - идёт эпизод отбрасывания
+	dropping bool
+}
+
+// controlLaw - время следующего отбрасывания: t + interval/√count
+func (c *codelState) controlLaw(t time.Time) time.Time {
+	return t.Add(time.Duration(float64(codelInterval) / math.Sqrt(float64(c.count))))
+}
+
+// shouldDrop решает, отбросить ли головной пакет с задержкой sojourn
+func (c *codelState) shouldDrop(sojourn time.Duration, now time.Time) bool {
+	if sojourn < codelTarget {
+		c.firstAbove = time.Time{}
+		c.dropping = false
+		return false
+	}
+
+	if c.firstAbove.IsZero() {
+		c.firstAbove = now.Add(codelInterval)
+		return false
+	}
+
+	if !c.dropping {
+		if now.Before(c.firstAbove) {
+			return false
+		}
+		c.dropping = true
+		// Недавний эпизод - продолжаем с близкой частотой
+		if c.count > 2 && now.Sub(c.dropNext) < 8*codelInterval {
+			c.count -= 2
+		} else {
+			c.count = 1
+		}
+		c.dropNext = c.controlLaw(now)
+		return true
+	}
+
+	if now.Before(c.dropNext) {
+		return false
+	}
+	c.count++
+	c.dropNext = c.controlLaw(c.dropNext)
+	return true
+}
+
+// reset сбрасывает ожидание при опустевшей очереди
+func (c *codelState) reset() {
+	c.firstAbove = time.Time{}
+	c.dropping = false
+}
+
+// popLocked извлекает головной пакет класса с учётом CoDel
+// Вызывается под mu.Lock. Может вернуть nil, если CoDel
+// отбросил все пакеты класса
+func (pq *PriorityQueue) popLocked(level PriorityLevel) *PriorityPacket {
+	ring := pq.queues[level]
+	if pq.dropPolicy != DropPolicy_CODEL || level != PriorityLow {
+		return ring.Pop()
+	}
+
+	now := time.Now()
+	for {
+		pkt := ring.Pop()
+		if pkt == nil {
+			pq.codel.reset()
+			return nil
+		}
+		if ring.Len() == 0 {
+			// Последний пакет не отбрасываем: очередь и так разгружена
+			pq.codel.reset()
+			return pkt
+		}
+		if !pq.codel.shouldDrop(now.Sub(pkt.EnqueuedAt), now) {
+			return pkt
+		}
+		pq.droppedCodel++
+		pq.recordDropLocked(PriorityLow)
+	}
+}
+
+// pushHeadDropLocked кладёт пакет в полную очередь, вытесняя
+// самый старый пакет того же класса. Вызывается под mu.Lock
+func (pq *PriorityQueue) pushHeadDropLocked(pkt *PriorityPacket) bool {
+	ring := pq.queues[pkt.Priority]
+	if ring.Pop() == nil {
+		return false
+	}
+	pq.droppedOverflow++
+	pq.recordDropLocked(pkt.Priority)
+	return ring.Push(pkt)
+}
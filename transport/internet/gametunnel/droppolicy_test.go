@@ -0,0 +1,136 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDropPolicyTail(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+
+	for i := 0; i < MediumQueueSize; i++ {
+		pq.EnqueueWithPriority([]byte{byte(i)}, PriorityMedium, nil)
+	}
+	if pq.EnqueueWithPriority([]byte{0xFF}, PriorityMedium, nil) {
+		t.Fatal("Full queue should reject the packet")
+	}
+
+	stats := pq.GetStats()
+	if stats.DroppedOverflow != 1 || stats.DroppedBump != 0 || stats.Dropped != 1 {
+		t.Errorf("Unexpected drop counters: %+v", stats)
+	}
+
+	// Голова не тронута
+	if pkt := pq.Dequeue(); pkt.Data[0] != 0 {
+		t.Errorf("Tail-drop must keep the oldest packet, got %d", pkt.Data[0])
+	}
+}
+
+func TestDropPolicyHead(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+	pq.SetDropPolicy(DropPolicy_HEAD)
+
+	for i := 0; i < MediumQueueSize; i++ {
+		pq.EnqueueWithPriority([]byte{byte(i)}, PriorityMedium, nil)
+	}
+	if !pq.EnqueueWithPriority([]byte{0xFF}, PriorityMedium, nil) {
+		t.Fatal("Head-drop should accept the new packet")
+	}
+
+	if pkt := pq.Dequeue(); pkt.Data[0] != 1 {
+		t.Errorf("Oldest packet should be dropped, head is %d", pkt.Data[0])
+	}
+	if stats := pq.GetStats(); stats.DroppedOverflow != 1 {
+		t.Errorf("DroppedOverflow: got %d, want 1", stats.DroppedOverflow)
+	}
+}
+
+func TestDropBumpCounter(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+
+	pq.EnqueueWithPriority([]byte("low"), PriorityLow, nil)
+	for i := 0; i < HighQueueSize; i++ {
+		pq.EnqueueWithPriority([]byte("high"), PriorityHigh, nil)
+	}
+
+	// High полна - вытесняем Low, пакет должен попасть в очередь
+	if !pq.EnqueueWithPriority([]byte("urgent"), PriorityHigh, nil) {
+		t.Fatal("High packet should bump a Low one")
+	}
+
+	stats := pq.GetStats()
+	if stats.DroppedBump != 1 || stats.DroppedOverflow != 0 {
+		t.Errorf("Unexpected drop counters: %+v", stats)
+	}
+	if stats.HighQueued != HighQueueSize+1 || stats.LowQueued != 0 {
+		t.Errorf("Bumped High should take the spare slot: %+v", stats)
+	}
+
+	// Low больше нет - вытеснять нечего, это уже переполнение
+	if pq.EnqueueWithPriority([]byte("urgent"), PriorityHigh, nil) {
+		t.Fatal("Nothing to bump, packet should be dropped")
+	}
+	if stats := pq.GetStats(); stats.DroppedOverflow != 1 {
+		t.Errorf("DroppedOverflow: got %d, want 1", stats.DroppedOverflow)
+	}
+}
+
+func TestDropPolicyCoDel(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+	pq.SetDropPolicy(DropPolicy_CODEL)
+
+	// Стоячая очередь Low: все пакеты давно ждут
+	old := time.Now().Add(-time.Second)
+	for i := 0; i < 20; i++ {
+		pq.EnqueueWithPriority([]byte{byte(i)}, PriorityLow, nil)
+	}
+	pq.mu.Lock()
+	for i := 0; i < pq.queues[PriorityLow].size; i++ {
+		idx := (pq.queues[PriorityLow].head + i) % pq.queues[PriorityLow].cap
+		pq.queues[PriorityLow].buf[idx].EnqueuedAt = old
+	}
+	// Задержка выше цели уже дольше interval
+	pq.codel.firstAbove = time.Now().Add(-time.Millisecond)
+	pq.mu.Unlock()
+
+	if pkt := pq.Dequeue(); pkt == nil {
+		t.Fatal("CoDel should still deliver a packet")
+	}
+	if stats := pq.GetStats(); stats.DroppedCodel == 0 {
+		t.Error("CoDel should drop packets from a standing Low queue")
+	}
+}
+
+func TestCoDelNoDropBelowTarget(t *testing.T) {
+	var c codelState
+	now := time.Now()
+
+	for i := 0; i < 100; i++ {
+		if c.shouldDrop(time.Millisecond, now.Add(time.Duration(i)*time.Millisecond)) {
+			t.Fatal("Sojourn below target must not drop")
+		}
+	}
+
+	// Выше цели, но меньше interval - ещё не отбрасываем
+	if c.shouldDrop(20*time.Millisecond, now) {
+		t.Error("First above-target packet must not drop")
+	}
+	if c.shouldDrop(20*time.Millisecond, now.Add(codelInterval/2)) {
+		t.Error("Drop before interval elapsed")
+	}
+	if !c.shouldDrop(20*time.Millisecond, now.Add(codelInterval+time.Millisecond)) {
+		t.Error("Persistent delay above target should drop")
+	}
+}
+
+func TestDropPolicyFromString(t *testing.T) {
+	if DropPolicyFromString("head") != DropPolicy_HEAD {
+		t.Error("'head' should parse to HEAD")
+	}
+	if DropPolicyFromString("codel") != DropPolicy_CODEL {
+		t.Error("'codel' should parse to CODEL")
+	}
+	if DropPolicyFromString("") != DropPolicy_TAIL {
+		t.Error("Default policy should be TAIL")
+	}
+}
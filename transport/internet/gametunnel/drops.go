@@ -0,0 +1,135 @@
+package gametunnel
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Причины отброса пакетов
+// ====================================================================
+//
+// Невалидный пакет приёмник молча отбрасывает: сканеры и мусор не
+// должны ни получать ответа, ни забивать журнал. Чтобы отброс был
+// виден, каждый считается по причине - отдельным атомарным счётчиком
+// на хабе (HubStats.Drops), на клиенте (ClientStats.Drops) и в
+// метрике gametunnel_dropped_packets_total{side,reason}:
+//
+//	not_quic_like   - датаграмма не снимается обфускацией или флаги
+//	                  не похожи на QUIC (сканер, чужой протокол)
+//	too_short       - короче заголовка или Connection ID
+//	unknown_conn_id - Connection ID нет в таблице сессий
+//	decrypt         - AEAD не прошёл (чужой ключ, порча)
+//	replay          - номер пакета уже был или вне окна
+//	queue_overflow  - очередь чтения или пул расшифровки переполнены
+//	rejected        - Client Hello отклонён допуском (drain, фильтр
+//	                  IP, лимиты)
+//	malformed       - прочие ошибки разбора
+//	puzzle          - Client Hello без решения задачи под нагрузкой
+//	                  (ответ RETRY, puzzle.go)
+//	filtered        - отброшен фильтром оператора (filter.go)
+//
+// Хаб классифицирует ошибку RoutePacket по dropError, клиент считает
+// на месте отброса. Текст ошибок не меняется.
+//
+// ====================================================================
+
+// dropReason - причина отброса пакета
+type dropReason int
+
+const (
+	dropNotQUICLike dropReason = iota
+	dropTooShort
+	dropUnknownConnID
+	dropDecrypt
+	dropReplay
+	dropQueueOverflow
+	dropRejected
+	dropMalformed
+	dropPuzzle
+	dropFiltered
+
+	// dropReasons - число причин
+	dropReasons
+)
+
+// dropReasonNames - имена причин в статистике и метриках
+var dropReasonNames = [dropReasons]string{
+	dropNotQUICLike:   "not_quic_like",
+	dropTooShort:      "too_short",
+	dropUnknownConnID: "unknown_conn_id",
+	dropDecrypt:       "decrypt",
+	dropReplay:        "replay",
+	dropQueueOverflow: "queue_overflow",
+	dropRejected:      "rejected",
+	dropMalformed:     "malformed",
+	dropPuzzle:        "puzzle",
+	dropFiltered:      "filtered",
+}
+
+// String - имя причины
+func (r dropReason) String() string {
+	if r < 0 || r >= dropReasons {
+		return "unknown"
+	}
+	return dropReasonNames[r]
+}
+
+// dropCounters - отброшенные пакеты по причинам
+type dropCounters [dropReasons]uint64
+
+// add учитывает отброшенный пакет
+func (d *dropCounters) add(reason dropReason) {
+	atomic.AddUint64(&d[reason], 1)
+}
+
+// snapshot - ненулевые счётчики по именам причин (nil - отбросов не было)
+func (d *dropCounters) snapshot() map[string]uint64 {
+	var out map[string]uint64
+	for reason := range d {
+		if n := atomic.LoadUint64(&d[reason]); n > 0 {
+			if out == nil {
+				out = make(map[string]uint64)
+			}
+			out[dropReason(reason).String()] = n
+		}
+	}
+	return out
+}
+
+// dropError - ошибка разбора с причиной отброса; текст - как у err
+type dropError struct {
+	reason dropReason
+	err    error
+}
+
+func (e *dropError) Error() string { return e.err.Error() }
+func (e *dropError) Unwrap() error { return e.err }
+
+// dropped помечает ошибку разбора причиной отброса
+func dropped(reason dropReason, err error) error {
+	return &dropError{reason: reason, err: err}
+}
+
+// dropReasonOf - причина отброса пакета с ошибкой err
+func dropReasonOf(err error) dropReason {
+	var de *dropError
+	if errors.As(err, &de) {
+		return de.reason
+	}
+	if errors.Is(err, errDecrypt) {
+		return dropDecrypt
+	}
+	return dropMalformed
+}
+
+// drop учитывает пакет, отброшенный хабом
+func (h *Hub) drop(reason dropReason) {
+	h.count(func(c *sideCounters) *uint64 { return &c.drops[reason] }, 1)
+}
+
+// drop учитывает пакет, отброшенный клиентом
+func (c *GameTunnelClientConn) drop(reason dropReason) {
+	c.traffic.drops.add(reason)
+	metrics.client.drops.add(reason)
+}
@@ -0,0 +1,117 @@
+package gametunnel
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitDrops ждёт want отброшенных пакетов с причиной reason
+func waitDrops(t *testing.T, drops func() map[string]uint64, reason dropReason, want uint64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for drops()[reason.String()] < want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := drops()[reason.String()]; got < want {
+		t.Errorf("%s drops = %d, want %d (all: %v)", reason, got, want, drops())
+	}
+}
+
+func TestDropReasonOf(t *testing.T) {
+	tests := []struct {
+		err  error
+		want dropReason
+	}{
+		{dropped(dropUnknownConnID, errDecrypt), dropUnknownConnID},
+		{errDecrypt, dropDecrypt},
+		{net.ErrClosed, dropMalformed},
+	}
+	for _, tt := range tests {
+		if got := dropReasonOf(tt.err); got != tt.want {
+			t.Errorf("dropReasonOf(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+	if err := dropped(dropReplay, errDecrypt); err.Error() != errDecrypt.Error() {
+		t.Errorf("dropped changes the message: %q", err)
+	}
+}
+
+func TestDropCounters(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "drops"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+
+	client.Write([]byte("hello"))
+	readWithTimeout(t, server, 1500)
+	server.Write([]byte("hello"))
+	readWithTimeout(t, client, 1500)
+
+	raw, err := net.DialUDP("udp", nil, l.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer raw.Close()
+	send := func(packet []byte) {
+		t.Helper()
+		if _, err := raw.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Мусор сканера
+	send(bytes.Repeat([]byte{0x00}, 64))
+	// Пакет данных несуществующей сессии
+	pkt := &Packet{Type: PacketType_DATA, ConnectionID: bytes.Repeat([]byte{0xAB}, int(config.ConnectionIdLength)), Payload: make([]byte, 32)}
+	data, err := pkt.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := l.hub.obfs.Wrap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(wrapped)
+	// Заголовок без тела: добивку обёртки QUIC отрезаем
+	if wrapped, err = l.hub.obfs.Wrap(data[:FlagsSize+VersionSize+int(config.ConnectionIdLength)]); err != nil {
+		t.Fatal(err)
+	}
+	send(wrapped[:len(wrapped)-quicMinPayload])
+
+	hubDrops := func() map[string]uint64 { return l.hub.GetStats().Drops }
+	waitDrops(t, hubDrops, dropNotQUICLike, 1)
+	waitDrops(t, hubDrops, dropUnknownConnID, 1)
+	waitDrops(t, hubDrops, dropTooShort, 1)
+
+	// Повтор уже принятого клиентом пакета сервера
+	session := l.hub.sessions.get(client.session().ConnectionID)
+	if session == nil {
+		t.Fatal("no server session")
+	}
+	last := atomic.LoadUint32(&session.SendPacketNum)
+	for i := 0; i < 2; i++ {
+		replayed, err := l.hub.sealData(session, config, []byte("again"), last, PriorityHigh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.hub.queueData(session, replayed, 5, PriorityHigh, false)
+	}
+	waitDrops(t, func() map[string]uint64 { return client.GetStats().Drops }, dropReplay, 2)
+
+	var metricsOut strings.Builder
+	if err := WriteMetrics(&metricsOut); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`gametunnel_dropped_packets_total{side="server",reason="unknown_conn_id"} `,
+		`gametunnel_dropped_packets_total{side="client",reason="replay"} `,
+	} {
+		if !strings.Contains(metricsOut.String(), line) {
+			t.Errorf("metrics miss %q", line)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package gametunnel
+
+import (
+	"net"
+	"sync"
+	"syscall"
+)
+
+// ====================================================================
+// DSCP/TOS маркировка исходящих датаграмм
+// ====================================================================
+//
+// PriorityQueue классифицирует пакеты, но ядро и сеть об этом
+// ничего не знают. Домашние роутеры (WMM, SQM) и QoS операторов
+// смотрят на поле DSCP в IP-заголовке.
+//
+// dscpMarker выставляет DSCP на сокете перед каждой отправкой
+// в соответствии с уровнем приоритета пакета:
+//   High   → EF   (46) - Expedited Forwarding, игры/VoIP
+//   Medium → AF21 (18) - Low-latency data, веб/стриминг
+//   Low    → CS1  (8)  - Lower Effort, загрузки
+//
+// setsockopt вызывается только при смене класса: подряд идущие
+// пакеты одного приоритета не стоят лишнего syscall.
+//
+// ====================================================================
+
+const (
+	// DSCP по умолчанию для уровней приоритета
+	DefaultDscpHigh   = 46 // EF
+	DefaultDscpMedium = 18 // AF21
+	DefaultDscpLow    = 8  // CS1
+
+	// MaxDscp - DSCP занимает 6 старших бит поля TOS
+	MaxDscp = 63
+)
+
+// dscpMarker выставляет DSCP на UDP-сокете по приоритету пакета
+type dscpMarker struct {
+	conn PacketConn
+	raw  syscall.RawConn
+
+	// codes - DSCP для каждого уровня приоритета
+	codes [PriorityLevels]uint8
+
+	// ipv6 - сокет IPv6 (нужен IPV6_TCLASS вместо IP_TOS)
+	ipv6 bool
+
+	// batch - пакетная отправка sendmmsg (nil - нет, batch.go)
+	batch batchConn
+
+	// tap - запись датаграмм в дамп (nil - выключена, capture.go)
+	tap *packetTap
+
+	// inbound - тег inbound-а xray, которому принадлежит сокет
+	// (policy.go)
+	inbound string
+
+	// enabled - маркировка включена в конфиге и поддерживается сокетом
+	enabled bool
+
+	// current - текущий DSCP на сокете (-1 = не выставлялся)
+	current int
+
+	// mu сериализует пару setsockopt + write: иначе другая горутина
+	// может сменить DSCP между ними
+	mu sync.Mutex
+}
+
+// newDSCPMarker создаёт маркер для сокета
+// Если маркировка отключена - возвращает маркер, пишущий напрямую
+func newDSCPMarker(conn PacketConn, config *Config) *dscpMarker {
+	m := &dscpMarker{
+		conn:    conn,
+		current: -1,
+	}
+	if conn != nil {
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+			m.ipv6 = true
+		}
+		m.batch = newBatchConn(conn, m.ipv6)
+		m.tap, _ = newPacketTap(config)
+	}
+
+	if !config.EnableDscp {
+		return m
+	}
+
+	// Сокет в памяти (memnet.go) опций IP не имеет
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return m
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return m
+	}
+
+	m.raw = raw
+	m.enabled = true
+	m.codes[PriorityHigh] = uint8(config.DscpHigh)
+	m.codes[PriorityMedium] = uint8(config.DscpMedium)
+	m.codes[PriorityLow] = uint8(config.DscpLow)
+
+	return m
+}
+
+// markLocked выставляет DSCP для уровня приоритета. Вызывается под mu.
+func (m *dscpMarker) markLocked(level PriorityLevel) {
+	if level >= PriorityLevels {
+		level = PriorityLow
+	}
+
+	code := int(m.codes[level])
+	if code == m.current {
+		return
+	}
+
+	// Ошибка не фатальна: пакет уйдёт с прежней маркировкой
+	if err := setDSCP(m.raw, code, m.ipv6); err != nil {
+		return
+	}
+	m.current = code
+}
+
+// captured записывает в дамп датаграмму сокета с адресом remote
+// (nil - адрес подключённого сокета)
+func (m *dscpMarker) captured(out bool, remote *net.UDPAddr, b []byte) {
+	if m.tap == nil {
+		return
+	}
+	if remote == nil {
+		remote, _ = m.conn.RemoteAddr().(*net.UDPAddr)
+	}
+	m.tap.wire(out, udpLocalAddr(m.conn), remote, b)
+}
+
+// WriteToUDP отправляет датаграмму с DSCP, соответствующим приоритету
+func (m *dscpMarker) WriteToUDP(b []byte, addr *net.UDPAddr, level PriorityLevel) (int, error) {
+	m.captured(true, addr, b)
+	if !m.enabled {
+		return m.conn.WriteToUDP(b, addr)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.markLocked(level)
+	return m.conn.WriteToUDP(b, addr)
+}
+
+// Write отправляет датаграмму через подключённый сокет (клиент)
+func (m *dscpMarker) Write(b []byte, level PriorityLevel) (int, error) {
+	m.captured(true, nil, b)
+	if !m.enabled {
+		return m.conn.Write(b)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.markLocked(level)
+	return m.conn.Write(b)
+}
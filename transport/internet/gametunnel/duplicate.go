@@ -0,0 +1,166 @@
+package gametunnel
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Дублирование High-пакетов потока
+// ====================================================================
+//
+// Поток в режиме дублирования отправляет каждый свой пакет класса
+// High дважды. Потерянным он считается, только если потеряны обе
+// копии: при случайных потерях p эффективные потери - p², ценой
+// двойного трафика класса High (в игре - единицы килобайт в
+// секунду).
+//
+// Режим включается на поток:
+//   - приложение вызывает SetDuplicate(streamID, on) на соединении
+//     или SetDuplicate(on) на потоке OpenStream/AcceptStream;
+//   - duplicate = true в конфиге включает его для потока 0 (самого
+//     соединения) на своей стороне.
+//
+// Вторая копия - та же датаграмма, с тем же номером пакета:
+//   - с multipath копия по второму пути уже уходит (multipath.go),
+//     и лишняя не добавляется - копии идут разными маршрутами;
+//   - без второго пути копия уходит сразу за оригиналом по тому же
+//     сокету.
+//
+// Получатель отбрасывает вторую копию anti-replay окном (replay.go),
+// в статистике отброшенных она учитывается как replay. Отправленные
+// копии считает счётчик duplicatesSent.
+//
+// Пакеты Medium и Low потока не дублируются: их теряет и
+// восстанавливает транспорт внутри туннеля (TCP, QUIC).
+//
+// ====================================================================
+
+// setStreamDuplicate включает или выключает дублирование потока
+// Вызывается под мьютексом владельца таблицы
+func setStreamDuplicate(streams map[uint16]*Stream, streamID uint16, on bool, maxStreams uint32) error {
+	stream, exists := streams[streamID]
+	if !exists {
+		if !on {
+			return nil
+		}
+		if uint32(len(streams)) >= maxStreams {
+			return fmt.Errorf("too many streams: limit %d", maxStreams)
+		}
+		stream = &Stream{ID: streamID, Active: true}
+		streams[streamID] = stream
+	}
+	stream.Duplicate = on
+	return nil
+}
+
+// lookupStreamDuplicate сообщает, дублируются ли пакеты потока
+// Вызывается под мьютексом владельца таблицы
+func lookupStreamDuplicate(streams map[uint16]*Stream, streamID uint16) bool {
+	stream, exists := streams[streamID]
+	return exists && stream.Duplicate
+}
+
+// ====================================================================
+// Клиент
+// ====================================================================
+
+// SetDuplicate включает дублирование High-пакетов потока streamID
+// (0 - само соединение, stream_priority.go)
+func (c *GameTunnelClientConn) SetDuplicate(streamID uint16, on bool) error {
+	session := c.session()
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if err := checkStream(c.streams, streamID); err != nil {
+		return err
+	}
+	return setStreamDuplicate(session.Streams, streamID, on, c.config.MaxStreams)
+}
+
+// duplicated сообщает, дублировать ли пакет потока id класса level
+func (s *ClientSession) duplicated(id uint16, level PriorityLevel) bool {
+	if level != PriorityHigh {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return lookupStreamDuplicate(s.Streams, id)
+}
+
+// copiesHigh сообщает, что планировщик сейчас сам копирует High на
+// оба пути (nil - multipath выключен)
+func (mp *multipath) copiesHigh(now time.Time) bool {
+	if mp == nil {
+		return false
+	}
+	primary, secondary := mp.route(PriorityHigh, now)
+	return primary && secondary
+}
+
+// writeCopy отправляет вторую копию датаграммы дублирующего потока
+// Возвращает записанные байты (0 - копия не понадобилась или не ушла)
+func (c *GameTunnelClientConn) writeCopy(b []byte, level PriorityLevel) int {
+	if c.multipath.copiesHigh(time.Now()) {
+		return 0
+	}
+	n, err := c.writePrimary(b, level)
+	if err != nil {
+		return 0
+	}
+	atomic.AddUint64(&metrics.client.duplicatesSent, 1)
+	return n
+}
+
+// ====================================================================
+// Сервер
+// ====================================================================
+
+// SetDuplicate включает дублирование High-пакетов потока streamID
+// (0 - само соединение, stream_priority.go)
+func (c *GameTunnelConn) SetDuplicate(streamID uint16, on bool) error {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if err := checkStream(c.appStreams(), streamID); err != nil {
+		return err
+	}
+	return setStreamDuplicate(c.session.Streams, streamID, on, c.hub.getConfig().MaxStreams)
+}
+
+// duplicated сообщает, дублировать ли пакет потока id класса level
+func (s *Session) duplicated(id uint16, level PriorityLevel) bool {
+	if level != PriorityHigh {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return lookupStreamDuplicate(s.Streams, id)
+}
+
+// copiesHigh сообщает, что у сессии есть живой дополнительный путь:
+// High и так уходит копией по нему (multipath.go)
+func (s *Session) copiesHigh() bool {
+	now := time.Now().UnixNano()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, path := range s.altPaths {
+		if !path.bulk && now-atomic.LoadInt64(&path.lastSeen) <= int64(multipathPathIdle) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeCopy отправляет вторую копию датаграммы дублирующего потока
+// Возвращает записанные байты (0 - копия не понадобилась или не ушла)
+func (h *Hub) writeCopy(session *Session, b []byte, level PriorityLevel) int {
+	if session.copiesHigh() {
+		return 0
+	}
+	n, err := h.writeToSession(session, b, level)
+	if err != nil {
+		return 0
+	}
+	h.count(func(c *sideCounters) *uint64 { return &c.duplicatesSent }, 1)
+	return n
+}
@@ -0,0 +1,103 @@
+package gametunnel
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSetStreamDuplicate(t *testing.T) {
+	streams := make(map[uint16]*Stream)
+
+	// Выключение несуществующего потока таблицу не растит
+	if err := setStreamDuplicate(streams, 3, false, 2); err != nil || len(streams) != 0 {
+		t.Fatalf("off for unknown stream: err %v, %d streams", err, len(streams))
+	}
+	if err := setStreamDuplicate(streams, 3, true, 2); err != nil || !lookupStreamDuplicate(streams, 3) {
+		t.Fatalf("stream 3 not duplicated: %v", err)
+	}
+	// Приоритет и дублирование - независимые свойства потока
+	if err := setStreamPriority(streams, 3, PriorityAuto, 2); err != nil || !lookupStreamDuplicate(streams, 3) {
+		t.Error("unpinning priority turned duplication off")
+	}
+	if _, pinned := lookupStreamPriority(streams, 3); pinned {
+		t.Error("duplication pinned the stream priority")
+	}
+
+	setStreamDuplicate(streams, 4, true, 2)
+	if err := setStreamDuplicate(streams, 5, true, 2); err == nil {
+		t.Error("stream limit not enforced")
+	}
+	setStreamDuplicate(streams, 3, false, 2)
+	if lookupStreamDuplicate(streams, 3) || lookupStreamDuplicate(streams, 5) {
+		t.Error("duplication still reported")
+	}
+}
+
+func TestDuplicateLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "duplicate"
+	config.Priority = PriorityMode_GAMING
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	clientConfig.Duplicate = true
+	client, serverConn := dialTestClient(t, l, &clientConfig, accepted)
+	server := serverConn.(*GameTunnelConn)
+
+	const packets = 10
+	send := func(from io.Writer, prefix string) {
+		t.Helper()
+		for i := 0; i < packets; i++ {
+			msg := []byte(fmt.Sprintf("%s: seq=%d", prefix, i))
+			if _, err := from.Write(msg); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+		}
+	}
+
+	// Клиент -> сервер: каждый пакет дважды, приложению - один раз
+	sentBefore := atomic.LoadUint64(&metrics.client.duplicatesSent)
+	send(client, "player_move")
+	for i := 0; i < packets; i++ {
+		want := []byte(fmt.Sprintf("player_move: seq=%d", i))
+		if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, want) {
+			t.Fatalf("server got %q, want %q", got, want)
+		}
+	}
+	waitDrops(t, func() map[string]uint64 { return l.hub.GetStats().Drops }, dropReplay, packets)
+	if sent := atomic.LoadUint64(&metrics.client.duplicatesSent) - sentBefore; sent < packets {
+		t.Errorf("client sent %d copies, want %d", sent, packets)
+	}
+
+	// Сервер -> клиент: по умолчанию без копий, после SetDuplicate - с ними
+	if err := server.SetDuplicate(0, true); err != nil {
+		t.Fatal(err)
+	}
+	send(server, "world_state")
+	for i := 0; i < packets; i++ {
+		want := []byte(fmt.Sprintf("world_state: seq=%d", i))
+		if got := readWithTimeout(t, client, 2048); !bytes.Equal(got, want) {
+			t.Fatalf("client got %q, want %q", got, want)
+		}
+	}
+	waitDrops(t, func() map[string]uint64 { return client.GetStats().Drops }, dropReplay, packets)
+	if sent := l.hub.GetStats().DuplicatesSent; sent != packets {
+		t.Errorf("server sent %d copies, want %d", sent, packets)
+	}
+
+	// Не High - не дублируется
+	if err := client.SetPriority(0, PriorityLow); err != nil {
+		t.Fatal(err)
+	}
+	sentBefore = atomic.LoadUint64(&metrics.client.duplicatesSent)
+	send(client, "download")
+	for i := 0; i < packets; i++ {
+		readWithTimeout(t, server, 2048)
+	}
+	if sent := atomic.LoadUint64(&metrics.client.duplicatesSent) - sentBefore; sent != 0 {
+		t.Errorf("client duplicated %d Low packets", sent)
+	}
+}
@@ -0,0 +1,199 @@
+package gametunnel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Пул шифрования исходящих пакетов
+// ====================================================================
+//
+// Приём уже раскладывается по ядрам (decrypt_pool.go), а отправка
+// шифрует в горутине писателя: большой Write одной сессии (загрузка,
+// WriteMultiBuffer от xray) шифрует пакет за пакетом на одном ядре, и
+// поток одной сессии упирается в скорость ChaCha20-Poly1305 одного
+// ядра.
+//
+// С пулом Write режет данные на чанки и отдаёт их воркерам пула
+// (общего для всех сессий хаба) окном до encryptWindow пакетов на
+// воркер. Писатель забирает готовые пакеты строго в порядке номеров и
+// ставит в очередь сессии, пока воркеры шифруют следующие: шифрование
+// идёт параллельно и впереди записи в сокет, а порядок пакетов сессии
+// остаётся прежним.
+//
+// Номер пакета, квота и приоритет назначаются писателем до передачи
+// в пул - в порядке потока, как без пула.
+//
+// encryptWorkers:
+//   0, 1 - без пула, шифрование в горутине писателя
+//   N    - N воркеров
+//
+// Запись не длиннее одного пакета пул не использует: ожидание воркера
+// дороже шифрования одного пакета.
+//
+// ====================================================================
+
+const (
+	// encryptQueueSize - пакетов в очереди пула
+	encryptQueueSize = 256
+
+	// encryptWindow - пакетов одной записи в пуле на воркер
+	encryptWindow = 2
+)
+
+var errHubStopped = errors.New("hub stopped")
+
+// sealJob - чанк, ожидающий шифрования в пуле
+// wrapped и err заполняет воркер, затем сигналит done
+type sealJob struct {
+	session *Session
+	config  *Config
+	payload []byte
+	pktNum  uint32
+	level   PriorityLevel
+
+	wrapped []byte
+	err     error
+	done    chan struct{}
+}
+
+// sealJobs - пул заданий (канал done переиспользуется)
+var sealJobs = sync.Pool{
+	New: func() any {
+		return &sealJob{done: make(chan struct{}, 1)}
+	},
+}
+
+// encryptPool - очередь воркеров шифрования
+type encryptPool struct {
+	jobs    chan *sealJob
+	workers int
+}
+
+// newEncryptPool создаёт пул из workers воркеров (nil - без пула)
+func newEncryptPool(workers uint32) *encryptPool {
+	if workers <= 1 {
+		return nil
+	}
+	return &encryptPool{
+		jobs:    make(chan *sealJob, encryptQueueSize),
+		workers: int(workers),
+	}
+}
+
+// startEncryptWorkers запускает воркеры пула в горутинах хаба
+func (h *Hub) startEncryptWorkers() {
+	if h.encrypt == nil {
+		return
+	}
+	for i := 0; i < h.encrypt.workers; i++ {
+		h.goLoop("encrypt", h.encryptLoop)
+	}
+}
+
+// encryptLoop - воркер: шифрует задания очереди пула
+func (h *Hub) encryptLoop() {
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case job := <-h.encrypt.jobs:
+			start := latencyStart()
+			job.wrapped, job.err = h.sealData(job.session, job.config, job.payload, job.pktNum, job.level)
+			metrics.serverLatency.since(latencyEncrypt, job.level, start)
+			job.done <- struct{}{}
+		}
+	}
+}
+
+// sendChunks отправляет b сессии пакетами до maxPayload байт
+// Возвращает, сколько байт поставлено в очередь
+func (h *Hub) sendChunks(session *Session, b []byte, maxPayload int) (int, error) {
+	if h.encrypt == nil || len(b) <= maxPayload {
+		written := 0
+		for written < len(b) {
+			end := min(written+maxPayload, len(b))
+			if err := h.SendToSession(session, b[written:end]); err != nil {
+				return written, fmt.Errorf("send to session: %w", err)
+			}
+			written = end
+		}
+		return written, nil
+	}
+
+	written, err := h.sealChunks(session, b, maxPayload)
+	if err != nil {
+		return written, fmt.Errorf("send to session: %w", err)
+	}
+	return written, nil
+}
+
+// sealChunks шифрует чанки b в пуле и ставит их в очередь сессии по
+// порядку номеров
+func (h *Hub) sealChunks(session *Session, b []byte, maxPayload int) (int, error) {
+	if !session.isActive() {
+		return 0, fmt.Errorf("session not active")
+	}
+
+	config := h.padding.config(&session.paddingVariant, h.sessionConfig(session), session.timestamps)
+	window := h.encrypt.workers * encryptWindow
+	pending := make([]*sealJob, 0, window)
+	next, written := 0, 0
+
+	// quotaErr - квота исчерпана на чанке next: уже отданные воркерам
+	// чанки отправляются; sealErr - чанк не зашифрован: следующие за
+	// ним отбрасываются, чтобы не нарушить порядок
+	var quotaErr, sealErr error
+
+	for {
+		// Окно: чанки уходят воркерам, пока писатель ждёт первый
+		for quotaErr == nil && sealErr == nil && next < len(b) && len(pending) < window {
+			end := min(next+maxPayload, len(b))
+			chunk := b[next:end]
+			if quotaErr = h.chargeQuota(session, uint64(len(chunk)), 0); quotaErr != nil {
+				break
+			}
+
+			job := sealJobs.Get().(*sealJob)
+			job.session, job.config, job.payload = session, config, chunk
+			job.pktNum = atomic.AddUint32(&session.SendPacketNum, 1)
+			job.level = h.classify(session, chunk)
+			select {
+			case h.encrypt.jobs <- job:
+			case <-h.ctx.Done():
+				return written, errHubStopped
+			}
+			pending = append(pending, job)
+			next = end
+		}
+		if len(pending) == 0 {
+			if sealErr != nil {
+				return written, sealErr
+			}
+			return written, quotaErr
+		}
+
+		// Голова окна - по порядку номеров
+		job := pending[0]
+		pending = append(pending[:0], pending[1:]...)
+		select {
+		case <-job.done:
+		case <-h.ctx.Done():
+			// Задание может быть ещё у воркера - в пул не возвращаем
+			return written, errHubStopped
+		}
+		if sealErr == nil && job.err != nil {
+			sealErr = job.err
+		}
+		if sealErr == nil {
+			h.queueData(session, job.wrapped, len(job.payload), job.level, session.duplicated(0, job.level))
+			written += len(job.payload)
+		}
+
+		*job = sealJob{done: job.done}
+		sealJobs.Put(job)
+	}
+}
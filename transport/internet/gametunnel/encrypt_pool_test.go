@@ -0,0 +1,151 @@
+package gametunnel
+
+import (
+	"bytes"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// newSealingHub создаёт хаб с пулом шифрования из workers воркеров и
+// активную сессию; третий результат - ключи клиента этой сессии
+func newSealingHub(t testing.TB, workers uint32) (*Hub, *Session, *SessionKeys) {
+	t.Helper()
+
+	config := DefaultConfig()
+	config.EncryptWorkers = workers
+	h := NewHub(config, nil)
+	h.startEncryptWorkers()
+	t.Cleanup(func() {
+		h.cancel()
+		h.wg.Wait()
+	})
+
+	clientKP, _ := GenerateKeyPair()
+	serverKP, _ := GenerateKeyPair()
+	sharedSecret, _ := ComputeSharedSecret(clientKP.PrivateKey, serverKP.PublicKey)
+	serverKeys, _ := DeriveSessionKeys(sharedSecret, "", false)
+	clientKeys, _ := DeriveSessionKeys(sharedSecret, "", true)
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+
+	session := &Session{
+		ID:      connID,
+		State:   SessionState_ACTIVE,
+		Keys:    serverKeys,
+		Streams: make(map[uint16]*Stream),
+		flow:    newFlowTracker(xnet.Destination{}),
+		queue:   NewPriorityQueue(PriorityMode_GAMING),
+	}
+	// Один класс: порядок очереди - порядок постановки
+	setStreamPriority(session.Streams, 0, PriorityMedium, config.MaxStreams)
+	return h, session, clientKeys
+}
+
+// openQueued расшифровывает пакеты очереди сессии по порядку
+func openQueued(t *testing.T, h *Hub, session *Session, keys *SessionKeys) [][]byte {
+	t.Helper()
+
+	var chunks [][]byte
+	lastNum := uint32(0)
+	for pkt := session.queue.Dequeue(); pkt != nil; pkt = session.queue.Dequeue() {
+		data, err := h.obfs.Unwrap(pkt.Data)
+		if err != nil {
+			t.Fatalf("unwrap: %v", err)
+		}
+		parsed, err := Unmarshal(data, len(session.ID))
+		if err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if parsed.PacketNumber <= lastNum {
+			t.Fatalf("packet %d queued after %d", parsed.PacketNumber, lastNum)
+		}
+		lastNum = parsed.PacketNumber
+		plaintext, err := keys.Decrypt(parsed.Payload, parsed.PacketNumber, data[:FlagsSize+VersionSize+len(session.ID)])
+		if err != nil {
+			t.Fatalf("decrypt packet %d: %v", parsed.PacketNumber, err)
+		}
+		chunks = append(chunks, plaintext)
+	}
+	return chunks
+}
+
+func TestEncryptPoolOrdered(t *testing.T) {
+	h, session, clientKeys := newSealingHub(t, 4)
+
+	// Длиннее окна пула: чанки 0..39, последний короче
+	const maxPayload = 100
+	payload := make([]byte, 0, 40*maxPayload)
+	for i := 0; i < 40; i++ {
+		payload = append(payload, bytes.Repeat([]byte{byte(i)}, maxPayload)...)
+	}
+	payload = payload[:len(payload)-30]
+
+	written, err := h.sendChunks(session, payload, maxPayload)
+	if err != nil || written != len(payload) {
+		t.Fatalf("sendChunks wrote %d of %d: %v", written, len(payload), err)
+	}
+
+	chunks := openQueued(t, h, session, clientKeys)
+	if len(chunks) != 40 {
+		t.Fatalf("%d packets queued, want 40", len(chunks))
+	}
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, payload) {
+		t.Error("chunks reordered or corrupted")
+	}
+	if session.PacketsSent != 40 || session.BytesSent != uint64(len(payload)) {
+		t.Errorf("session stats: %d packets, %d bytes", session.PacketsSent, session.BytesSent)
+	}
+}
+
+func TestEncryptPoolInactiveSession(t *testing.T) {
+	h, session, clientKeys := newSealingHub(t, 4)
+	session.State = SessionState_CLOSING
+
+	if written, err := h.sendChunks(session, make([]byte, 3000), 100); err == nil || written != 0 {
+		t.Fatalf("write to a closing session: %d, %v", written, err)
+	}
+	if chunks := openQueued(t, h, session, clientKeys); len(chunks) != 0 {
+		t.Errorf("%d packets queued", len(chunks))
+	}
+}
+
+func TestEncryptPoolDisabled(t *testing.T) {
+	for _, workers := range []uint32{0, 1} {
+		if newEncryptPool(workers) != nil {
+			t.Errorf("encryptWorkers %d started a pool", workers)
+		}
+	}
+
+	// Без пула - та же отправка в горутине писателя
+	h, session, clientKeys := newSealingHub(t, 0)
+	if _, err := h.sendChunks(session, make([]byte, 250), 100); err != nil {
+		t.Fatal(err)
+	}
+	if chunks := openQueued(t, h, session, clientKeys); len(chunks) != 3 {
+		t.Errorf("%d packets queued, want 3", len(chunks))
+	}
+}
+
+func BenchmarkSendChunks(b *testing.B) {
+	const maxPayload = 1200
+	payload := make([]byte, 64*1024)
+
+	for _, bench := range []struct {
+		name    string
+		workers uint32
+	}{
+		{"inline", 0},
+		{"pool", 4},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			h, session, _ := newSealingHub(b, bench.workers)
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.sendChunks(session, payload, maxPayload)
+				for session.queue.Dequeue() != nil {
+				}
+			}
+		})
+	}
+}
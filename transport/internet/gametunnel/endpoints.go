@@ -0,0 +1,245 @@
+package gametunnel
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// ====================================================================
+// Несколько адресов сервера: Happy Eyeballs и failover
+// ====================================================================
+//
+// Кроме адреса outbound-а xray клиент может знать запасные адреса
+// сервера (endpoints): "host:port", "[2001:db8::1]:443" или просто
+// "host" с портом outbound-а. Имена разрешаются во все их IPv4 и IPv6
+// (resolve.go).
+//
+// Dial перебирает адреса как в RFC 8305: семейства чередуются
+// (IPv6, IPv4, IPv6, ...), следующая попытка стартует через
+// endpointAttemptDelay или сразу после провала предыдущей, не
+// дожидаясь её таймаута. Попытка - это сокет и полный хэндшейк:
+// UDP "соединяется" всегда, живость пути показывает только Server
+// Hello. Побеждает первый хэндшейк, остальные попытки отменяются.
+//
+// Сработавший адрес запоминается на уровне процесса для этого набора
+// адресов: следующие Dial начинают с него, и клиент за сломанным IPv6
+// не платит задержку на каждом соединении. Переподключение
+// (reconnect.go) заново перебирает тот же список - сессия переезжает
+// на живой сервер группы.
+//
+// ====================================================================
+
+// endpointAttemptDelay - пауза перед следующей попыткой (RFC 8305)
+const endpointAttemptDelay = 250 * time.Millisecond
+
+// lastEndpoints - сработавший адрес по набору адресов сервера
+var lastEndpoints sync.Map // string -> string
+
+// resolveEndpoints собирает адреса сервера: dest (адрес outbound-а,
+// IP или имя) и config.Endpoints, без повторов, с учётом
+// resolveStrategy (resolve.go)
+func resolveEndpoints(ctx context.Context, dest xnet.Destination, config *Config, r *serverResolver) ([]*net.UDPAddr, error) {
+	var addrs []*net.UDPAddr
+	seen := map[string]bool{}
+	add := func(host string, port int) error {
+		ips, err := r.lookup(ctx, host)
+		if err != nil {
+			return err
+		}
+		for _, ip := range ips {
+			addr := &net.UDPAddr{IP: ip, Port: port}
+			if !seen[addr.String()] {
+				seen[addr.String()] = true
+				addrs = append(addrs, addr)
+			}
+		}
+		return nil
+	}
+
+	primaryHost, primaryPort := dest.Address.String(), int(dest.Port)
+	if dest.Address.Family().IsIP() {
+		primaryHost = dest.Address.IP().String()
+	}
+	if err := add(primaryHost, primaryPort); err != nil {
+		return nil, fmt.Errorf("resolve server %s: %w", dest.Address, err)
+	}
+
+	// Порты portRange - тот же сервер на других портах (portrange.go)
+	endpoints := append(portRangeAddrs(config, primaryHost, primaryPort), config.Endpoints...)
+	for _, endpoint := range endpoints {
+		host, port := endpoint, primaryPort
+		if h, p, err := net.SplitHostPort(endpoint); err == nil {
+			n, err := strconv.Atoi(p)
+			if err != nil || n <= 0 || n > 65535 {
+				return nil, fmt.Errorf("endpoint %q: invalid port", endpoint)
+			}
+			host, port = h, n
+		}
+		if err := add(host, port); err != nil {
+			return nil, fmt.Errorf("endpoint %q: %w", endpoint, err)
+		}
+	}
+
+	addrs = applyResolveStrategy(addrs, r.family)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolve server %s: no addresses for strategy %d", dest.Address, r.family)
+	}
+	return orderEndpoints(addrs), nil
+}
+
+// orderEndpoints чередует семейства адресов, начиная с семейства
+// первого, и ставит первым последний сработавший адрес
+func orderEndpoints(addrs []*net.UDPAddr) []*net.UDPAddr {
+	if len(addrs) < 2 {
+		return addrs
+	}
+
+	if last, ok := lastEndpoints.Load(endpointsKey(addrs)); ok {
+		for i, addr := range addrs {
+			if addr.String() == last.(string) {
+				addrs = append([]*net.UDPAddr{addr}, append(addrs[:i:i], addrs[i+1:]...)...)
+				break
+			}
+		}
+	}
+
+	first, other := []*net.UDPAddr{}, []*net.UDPAddr{}
+	ipv4 := addrs[0].IP.To4() != nil
+	for _, addr := range addrs {
+		if (addr.IP.To4() != nil) == ipv4 {
+			first = append(first, addr)
+		} else {
+			other = append(other, addr)
+		}
+	}
+
+	ordered := make([]*net.UDPAddr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(other); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(other) {
+			ordered = append(ordered, other[i])
+		}
+	}
+	return ordered
+}
+
+// endpointsKey - ключ набора адресов, не зависящий от их порядка
+func endpointsKey(addrs []*net.UDPAddr) string {
+	keys := make([]string, len(addrs))
+	for i, addr := range addrs {
+		keys[i] = addr.String()
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// dialResult - итог попытки хэндшейка с одним адресом
+type dialResult struct {
+	conn    PacketConn
+	session *ClientSession
+	addr    *net.UDPAddr
+	err     error
+
+	// attempts - начато попыток хэндшейка (dialEndpoints)
+	attempts int
+}
+
+// dialEndpoints заводит сессию с первым ответившим адресом сервера
+func dialEndpoints(ctx context.Context, addrs []*net.UDPAddr, config *Config, obfs Obfuscator, sockopt *internet.SocketConfig) (dialResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(addrs))
+	attempt := func(addr *net.UDPAddr) {
+		hctx, span := startSpan(ctx, config, "gametunnel.handshake", spanKindClient)
+		span.set("network.peer.address", addr.String())
+		conn, err := dialPathSocket(ctx, nil, addr, config, sockopt)
+		if err != nil {
+			err = fmt.Errorf("dial UDP %s: %w", addr, err)
+			span.end(err)
+			results <- dialResult{addr: addr, err: err}
+			return
+		}
+		start := time.Now()
+		session, err := performHandshake(hctx, conn, config, obfs)
+		if err != nil {
+			conn.Close()
+			// Отменённые проигравшие попытки - не отказ сервера
+			if ctx.Err() == nil || len(addrs) == 1 {
+				atomic.AddUint64(&metrics.client.handshakeFailures, 1)
+				span.end(err)
+			} else {
+				span.set("gametunnel.abandoned", true)
+				span.end(nil)
+			}
+			results <- dialResult{addr: addr, err: fmt.Errorf("handshake failed: %w", err)}
+			return
+		}
+		span.set("gametunnel.rtt_ms", float64(time.Since(start))/float64(time.Millisecond))
+		span.set("gametunnel.connection_id", hex.EncodeToString(session.ConnectionID))
+		span.end(nil)
+		results <- dialResult{conn: conn, session: session, addr: addr}
+	}
+
+	next, running := 0, 0
+	start := func() {
+		go attempt(addrs[next])
+		next++
+		running++
+	}
+	start()
+
+	delay := time.NewTimer(endpointAttemptDelay)
+	defer delay.Stop()
+
+	var lastErr error
+	for running > 0 {
+		select {
+		case <-delay.C:
+			if next < len(addrs) && ctx.Err() == nil {
+				start()
+				delay.Reset(endpointAttemptDelay)
+			}
+
+		case r := <-results:
+			running--
+			if r.err == nil {
+				atomic.AddUint64(&metrics.client.handshakes, 1)
+				if len(addrs) > 1 {
+					lastEndpoints.Store(endpointsKey(addrs), r.addr.String())
+				}
+				// Опоздавшие победители закрываются
+				go func(n int) {
+					for ; n > 0; n-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(running)
+				r.attempts = next
+				return r, nil
+			}
+
+			lastErr = r.err
+			// Провал - следующая попытка сразу, не дожидаясь паузы
+			if next < len(addrs) && ctx.Err() == nil {
+				start()
+				delay.Reset(endpointAttemptDelay)
+			}
+		}
+	}
+	return dialResult{attempts: next}, lastErr
+}
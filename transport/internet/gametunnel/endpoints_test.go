@@ -0,0 +1,102 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+func TestOrderEndpoints(t *testing.T) {
+	v4a := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 443}
+	v4b := &net.UDPAddr{IP: net.ParseIP("192.0.2.2"), Port: 443}
+	v6a := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	v6b := &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 443}
+
+	// Семейства чередуются, начиная с семейства первого адреса
+	got := orderEndpoints([]*net.UDPAddr{v6a, v6b, v4a, v4b})
+	want := []*net.UDPAddr{v6a, v4a, v6b, v4b}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order %v, want %v", got, want)
+		}
+	}
+
+	// Последний сработавший адрес - первым
+	lastEndpoints.Store(endpointsKey(got), v4b.String())
+	defer lastEndpoints.Delete(endpointsKey(got))
+	got = orderEndpoints([]*net.UDPAddr{v6a, v6b, v4a, v4b})
+	want = []*net.UDPAddr{v4b, v6a, v4a, v6b}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order %v, want %v", got, want)
+		}
+	}
+}
+
+func TestDialFailsOverToLiveEndpoint(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "endpoints"
+	l, accepted := startTestListener(t, config)
+	live := l.Addr().(*net.UDPAddr)
+
+	// Адрес outbound-а молчит, живой сервер - в endpoints
+	dead := newSink(t).LocalAddr().(*net.UDPAddr)
+	clientConfig := *config
+	clientConfig.HandshakeTimeout = 30
+	clientConfig.Endpoints = []string{live.String()}
+	dest := xnet.UDPDestination(xnet.IPAddress(dead.IP), xnet.Port(dead.Port))
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: &clientConfig}
+
+	start := time.Now()
+	conn, err := Dial(context.Background(), dest, streamSettings)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client := conn.(*GameTunnelClientConn)
+	defer client.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Failover took %v", elapsed)
+	}
+	if got := client.session().serverAddr.String(); got != live.String() {
+		t.Errorf("Connected to %s, want %s", got, live)
+	}
+
+	server := <-accepted
+	client.Write([]byte("hello"))
+	if got := readWithTimeout(t, server, 5); string(got) != "hello" {
+		t.Fatalf("Server got %q", got)
+	}
+
+	// Следующий Dial начинает с сработавшего адреса
+	addrs, err := resolveEndpoints(context.Background(), dest, &clientConfig, &serverResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addrs[0].String() != live.String() {
+		t.Errorf("First endpoint %s, want remembered %s", addrs[0], live)
+	}
+}
+
+func TestResolveEndpoints(t *testing.T) {
+	primary := xnet.UDPDestination(xnet.LocalHostIP, 443)
+	config := DefaultConfig()
+	config.Endpoints = []string{"127.0.0.1", "127.0.0.2:8443", "[::1]:443"}
+
+	addrs, err := resolveEndpoints(context.Background(), primary, config, &serverResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Повтор адреса outbound-а отброшен, порт по умолчанию - его
+	if len(addrs) != 3 {
+		t.Fatalf("Endpoints %v, want 3", addrs)
+	}
+
+	config.Endpoints = []string{"127.0.0.1:http"}
+	if _, err := resolveEndpoints(context.Background(), primary, config, &serverResolver{}); err == nil {
+		t.Error("Invalid port accepted")
+	}
+}
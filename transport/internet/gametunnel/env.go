@@ -0,0 +1,104 @@
+package gametunnel
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// Переопределения из окружения (Docker, Kubernetes)
+// ====================================================================
+//
+// В контейнере ключ удобнее передать секретом в переменной окружения,
+// чем шаблонизировать JSON конфига. Поверх конфига из файла при
+// запуске накладываются:
+//
+//	GAMETUNNEL_KEY         - key
+//	GAMETUNNEL_KEY_FILE    - key из файла (смонтированный секрет),
+//	                         концевые пробелы и перевод строки
+//	                         отбрасываются
+//	GAMETUNNEL_OBFUSCATION - obfuscation: quic, webrtc, raw
+//	GAMETUNNEL_MTU         - mtu
+//	GAMETUNNEL_PORT_RANGE  - portRange, "20000-20010" (portrange.go)
+//
+// Старшинство, от слабого к сильному: значения по умолчанию, профиль,
+// gametunnelSettings из файла, окружение, флаги командной строки
+// автономного клиента (они подменяют lookup). Пустая переменная
+// считается заданной: GAMETUNNEL_KEY="" - это пустой ключ.
+//
+// Окружение действует на все inbound и outbound gametunnel процесса
+// xray, а у автономного клиента - на его единственное соединение.
+// Конфиг, переданный встраивающим кодом готовым *Config, окружение
+// не трогает: для него есть ApplyEnv.
+//
+// Непонятное значение (GAMETUNNEL_MTU=abc, неизвестный режим
+// обфускации) - ошибка запуска, а не молча выбранное значение по
+// умолчанию.
+//
+// ====================================================================
+
+// Переменные окружения с переопределениями
+const (
+	EnvKey         = "GAMETUNNEL_KEY"
+	EnvKeyFile     = "GAMETUNNEL_KEY_FILE"
+	EnvObfuscation = "GAMETUNNEL_OBFUSCATION"
+	EnvMTU         = "GAMETUNNEL_MTU"
+	EnvPortRange   = "GAMETUNNEL_PORT_RANGE"
+)
+
+// ApplyEnv накладывает на конфиг переопределения GAMETUNNEL_*
+// lookup - os.LookupEnv или своя функция (флаги, тесты)
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	key, hasKey := lookup(EnvKey)
+	keyFile, hasKeyFile := lookup(EnvKeyFile)
+	switch {
+	case hasKey && hasKeyFile:
+		return fmt.Errorf("%s and %s are both set", EnvKey, EnvKeyFile)
+	case hasKey:
+		c.Key = key
+	case hasKeyFile:
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("%s: %w", EnvKeyFile, err)
+		}
+		c.Key = strings.TrimRight(string(data), " \t\r\n")
+	}
+
+	if value, ok := lookup(EnvObfuscation); ok {
+		mode, err := parseObfuscationMode(value)
+		if err != nil {
+			return fmt.Errorf("%s %q: %w", EnvObfuscation, value, err)
+		}
+		c.Obfuscation = mode
+	}
+
+	if value, ok := lookup(EnvMTU); ok {
+		mtu, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s %q: want a number", EnvMTU, value)
+		}
+		c.MTU = uint32(mtu)
+	}
+
+	if value, ok := lookup(EnvPortRange); ok {
+		if value != "" {
+			if _, _, err := parsePortRange(value); err != nil {
+				return fmt.Errorf("%s: %w", EnvPortRange, err)
+			}
+		}
+		c.PortRange = value
+	}
+	return nil
+}
+
+// parseObfuscationMode - ObfuscationModeFromString без молчаливого
+// QUIC для неизвестных имён
+func parseObfuscationMode(s string) (ObfuscationMode, error) {
+	mode := ObfuscationModeFromString(s)
+	if mode == ObfuscationMode_QUIC_MIMIC && !strings.HasPrefix(strings.ToLower(s), "quic") {
+		return mode, fmt.Errorf("want quic, webrtc or raw")
+	}
+	return mode, nil
+}
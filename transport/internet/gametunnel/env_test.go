@@ -0,0 +1,84 @@
+package gametunnel
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// envMap - lookup из словаря вместо окружения процесса
+func envMap(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "from-file"
+	config.MTU = 1300
+	err := config.ApplyEnv(envMap(map[string]string{
+		EnvKey:         "from-env",
+		EnvObfuscation: "webrtc",
+		EnvPortRange:   "20000-20003",
+	}))
+	if err != nil {
+		t.Fatalf("ApplyEnv: %v", err)
+	}
+	if config.Key != "from-env" || config.Obfuscation != ObfuscationMode_WEBRTC_MIMIC || config.PortRange != "20000-20003" {
+		t.Errorf("key %q, obfuscation %v, port range %q", config.Key, config.Obfuscation, config.PortRange)
+	}
+	// Не заданное в окружении остаётся из файла
+	if config.MTU != 1300 {
+		t.Errorf("mtu %d, want the file value", config.MTU)
+	}
+
+	// Ключ из смонтированного секрета
+	path := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(path, []byte("secret-from-file\n"), 0o600)
+	config = DefaultConfig()
+	if err := config.ApplyEnv(envMap(map[string]string{EnvKeyFile: path, EnvMTU: "1280"})); err != nil {
+		t.Fatalf("ApplyEnv key file: %v", err)
+	}
+	if config.Key != "secret-from-file" || config.MTU != 1280 {
+		t.Errorf("key %q, mtu %d", config.Key, config.MTU)
+	}
+
+	for _, vars := range []map[string]string{
+		{EnvMTU: "abc"},
+		{EnvObfuscation: "tls"},
+		{EnvPortRange: "20010-20000"},
+		{EnvKey: "a", EnvKeyFile: path},
+		{EnvKeyFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if err := DefaultConfig().ApplyEnv(envMap(vars)); err == nil {
+			t.Errorf("%v accepted", vars)
+		}
+	}
+}
+
+func TestConfigFromStreamEnv(t *testing.T) {
+	t.Setenv(EnvKey, "injected")
+	t.Setenv(EnvMTU, "1350")
+
+	config, err := configFromStream(&Settings{Key: "file", Mtu: 1400})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Key != "injected" || config.MTU != 1350 {
+		t.Errorf("key %q, mtu %d", config.Key, config.MTU)
+	}
+
+	// Готовый Config встраивающего кода окружение не трогает
+	own := DefaultConfig()
+	own.Key = "own"
+	if config, _ := configFromStream(own); config.Key != "own" {
+		t.Errorf("own config key %q", config.Key)
+	}
+
+	t.Setenv(EnvMTU, "large")
+	if _, err := configFromStream(&Settings{}); err == nil {
+		t.Error("invalid GAMETUNNEL_MTU accepted")
+	}
+}
@@ -0,0 +1,76 @@
+package gametunnel
+
+import (
+	"net"
+)
+
+// ====================================================================
+// Фильтр пакетов перед маршрутизацией
+// ====================================================================
+//
+// PacketFilter видит каждую датаграмму, принятую сокетами listener,
+// до Hub.RoutePacket: до снятия обфускации, поиска сессии и
+// расшифровки. Так подключаются свои списки блокировки, синхронизация
+// с отбросом в eBPF/XDP или ловушки для сканеров - без изменений в
+// маршрутизации.
+//
+//	Verdict_PASS - пакет идёт дальше как обычно
+//	Verdict_DROP - пакет отброшен и учтён как drop с причиной filtered
+//
+// Фильтр вызывается из горутин приёма (по одной на сокет) параллельно
+// и должен возвращаться быстро: медленный фильтр задерживает весь
+// приём сокета. raw - буфер пула, после возврата он переиспользуется;
+// ловушке, которой нужен пакет, - копировать. Фильтр заменяется на
+// работающем listener (nil - без фильтра).
+//
+// ====================================================================
+
+// Verdict - решение фильтра о датаграмме
+type Verdict int32
+
+const (
+	// Verdict_PASS - датаграмма идёт в маршрутизацию
+	Verdict_PASS Verdict = 0
+
+	// Verdict_DROP - датаграмма отброшена
+	Verdict_DROP Verdict = 1
+)
+
+// PacketFilter решает судьбу датаграммы raw от from до маршрутизации
+type PacketFilter interface {
+	FilterPacket(raw []byte, from *net.UDPAddr) Verdict
+}
+
+// PacketFilterFunc - функция как PacketFilter
+type PacketFilterFunc func(raw []byte, from *net.UDPAddr) Verdict
+
+// FilterPacket вызывает f
+func (f PacketFilterFunc) FilterPacket(raw []byte, from *net.UDPAddr) Verdict {
+	return f(raw, from)
+}
+
+// packetFilterHolder - обёртка PacketFilter для atomic.Value
+type packetFilterHolder struct {
+	PacketFilter
+}
+
+// SetPacketFilter задаёт фильтр датаграмм хаба (nil - без фильтра)
+// Безопасно вызывать на работающем Hub
+func (h *Hub) SetPacketFilter(f PacketFilter) {
+	h.packetFilter.Store(packetFilterHolder{f})
+}
+
+// SetPacketFilter задаёт фильтр датаграмм listener
+func (l *Listener) SetPacketFilter(f PacketFilter) {
+	l.hub.SetPacketFilter(f)
+}
+
+// filterPacket - true, если фильтр отбросил датаграмму
+func (h *Hub) filterPacket(raw []byte, from *net.UDPAddr) bool {
+	holder, _ := h.packetFilter.Load().(packetFilterHolder)
+	if holder.PacketFilter == nil || holder.FilterPacket(raw, from) == Verdict_PASS {
+		return false
+	}
+	h.drop(dropFiltered)
+	return true
+}
@@ -0,0 +1,42 @@
+package gametunnel
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPacketFilter(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "filter"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	blocked := client.LocalAddr().(*net.UDPAddr)
+	l.SetPacketFilter(PacketFilterFunc(func(raw []byte, from *net.UDPAddr) Verdict {
+		if from.Port == blocked.Port {
+			return Verdict_DROP
+		}
+		return Verdict_PASS
+	}))
+	if _, err := client.Write([]byte("blocked")); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for l.hub.GetStats().Drops[dropFiltered.String()] == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("filtered packet not counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Без фильтра пакеты снова доходят; отброшенный потерян
+	l.SetPacketFilter(nil)
+	if _, err := client.Write([]byte("open")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, server, 2048); string(got) != "open" {
+		t.Errorf("server got %q", got)
+	}
+}
@@ -0,0 +1,429 @@
+package gametunnel
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Fuzz-цели разборщиков провода: всё, что они читают, приходит с
+// открытого UDP-порта от кого угодно. Без -fuzz цели прогоняют только
+// затравки (обычный go test); поиск:
+//
+//	go test -run '^$' -fuzz FuzzQUICUnwrap -fuzztime 1m ./transport/internet/gametunnel
+//
+// Найденные входы go test кладёт в testdata/fuzz/<цель>/ - их
+// коммитят вместе с исправлением, дальше они проверяются всегда.
+
+// fuzzConfig - конфиг затравок: Connection ID 8 байт, padding
+func fuzzConfig() *Config {
+	config := DefaultConfig()
+	config.Validate()
+	return config
+}
+
+// fuzzPackets - настоящие пакеты GameTunnel всех типов до обфускации
+func fuzzPackets(f *testing.F) [][]byte {
+	config := fuzzConfig()
+	connID := bytes.Repeat([]byte{0x5a}, int(config.ConnectionIdLength))
+
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	hello := NewHandshakePayload(keys.PublicKey, 1_700_000_000_000)
+
+	packets := []*Packet{
+		NewHandshakePacket(connID, 0, hello.Marshal()),
+		NewDataPacket(connID, 1, []byte("game state update"), true),
+		NewDataPacket(connID, 2, nil, false),
+		NewKeepAlivePacket(connID, 3),
+		NewControlPacket(connID, 4, []byte{0x00}),
+	}
+	var out [][]byte
+	for _, p := range packets {
+		data, err := p.Marshal(config)
+		if err != nil {
+			f.Fatal(err)
+		}
+		out = append(out, data)
+	}
+	return out
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, data := range fuzzPackets(f) {
+		f.Add(data, uint8(8))
+	}
+	f.Add([]byte{}, uint8(8))
+	f.Add([]byte{0xc0, 0, 0, 0, 1}, uint8(4))
+
+	f.Fuzz(func(t *testing.T, data []byte, idLen uint8) {
+		connIDLen := 4 + int(idLen)%17
+		p, err := Unmarshal(data, connIDLen)
+		view, viewErr := UnmarshalView(data, connIDLen)
+		if (err == nil) != (viewErr == nil) {
+			t.Fatalf("Unmarshal err %v, UnmarshalView err %v", err, viewErr)
+		}
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(p.Payload, view.Payload) || !bytes.Equal(p.ConnectionID, view.ConnectionID) {
+			t.Fatal("Unmarshal and UnmarshalView disagree")
+		}
+
+		// Разобранный пакет собирается обратно в тот же
+		config := &Config{ConnectionIdLength: uint32(connIDLen)}
+		p.HasPadding = false
+		again, err := p.Marshal(config)
+		if err != nil {
+			t.Fatalf("marshal parsed packet: %v", err)
+		}
+		q, err := Unmarshal(again, connIDLen)
+		if err != nil {
+			t.Fatalf("unmarshal remarshaled packet: %v", err)
+		}
+		if q.Type != p.Type || q.PacketNumber != p.PacketNumber ||
+			!bytes.Equal(q.ConnectionID, p.ConnectionID) || !bytes.Equal(q.Payload, p.Payload) {
+			t.Fatalf("round trip changed packet: %+v -> %+v", p, q)
+		}
+	})
+}
+
+func FuzzDecodeQUICVarint(f *testing.F) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1073741823, 1073741824, 1<<62 - 1} {
+		f.Add(encodeQUICVarint(v))
+	}
+	f.Add([]byte{})
+	f.Add([]byte{0x40})
+	f.Add([]byte{0xc0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		value, n, err := decodeQUICVarint(data)
+		if err != nil {
+			return
+		}
+		if n != 1<<(data[0]>>6) || n > len(data) {
+			t.Fatalf("decoded %d bytes of %d with prefix %d", n, len(data), data[0]>>6)
+		}
+		got, m, err := decodeQUICVarint(encodeQUICVarint(value))
+		if err != nil || got != value || m > n {
+			t.Fatalf("re-encode %d: got %d in %d bytes (was %d), %v", value, got, m, n, err)
+		}
+	})
+}
+
+// fuzzUnwrap - общая часть целей обфускаторов: Unwrap не паникует, а
+// то, что он вернул, проходит Wrap и Unwrap без изменений
+func fuzzUnwrap(f *testing.F, mode ObfuscationMode) {
+	config := fuzzConfig()
+	obfs := NewObfuscator(mode, config)
+	for i, data := range fuzzPackets(f) {
+		wrapped, err := obfs.Wrap(data)
+		if i == 0 {
+			wrapped, err = wrapHandshake(obfs, data, MaxPacketSize)
+		}
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(wrapped)
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		packet, err := obfs.Unwrap(data)
+		if err != nil {
+			return
+		}
+		if len(packet) > len(data)+FlagsSize+VersionSize {
+			t.Fatalf("unwrapped %d bytes from %d", len(packet), len(data))
+		}
+		packet = bytes.Clone(packet)
+		wrapped, err := obfs.Wrap(packet)
+		if err != nil {
+			return
+		}
+		again, err := obfs.Unwrap(wrapped)
+		if err != nil {
+			t.Fatalf("unwrap own wrap: %v", err)
+		}
+		if mode == ObfuscationMode_QUIC_MIMIC {
+			// QUIC переносит только connIDLen байт DCID и добивает
+			// короткий payload до quicMinPayload: Unwrap отдаёт всё в
+			// пределах Payload Length, поэтому сверяем начало и длину
+			header := FlagsSize + VersionSize + int(config.ConnectionIdLength)
+			if len(packet) >= header && (!bytes.HasPrefix(again[5:], packet[5:]) || len(again) != max(len(packet), header+quicMinPayload)) {
+				t.Fatalf("round trip changed packet:\n%x\n%x", packet, again)
+			}
+			return
+		}
+		if !bytes.Equal(again, packet) {
+			t.Fatalf("round trip changed packet:\n%x\n%x", packet, again)
+		}
+	})
+}
+
+func FuzzQUICUnwrap(f *testing.F) {
+	fuzzUnwrap(f, ObfuscationMode_QUIC_MIMIC)
+}
+
+func FuzzWebRTCUnwrap(f *testing.F) {
+	fuzzUnwrap(f, ObfuscationMode_WEBRTC_MIMIC)
+}
+
+func FuzzUnmarshalHandshake(f *testing.F) {
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	hello := NewHandshakePayload(keys.PublicKey, 1_700_000_000_000)
+	f.Add(hello.Marshal())
+	hello.IssuedID = bytes.Repeat([]byte{0x11}, 8)
+	hello.EarlyKeyID = bytes.Repeat([]byte{0x22}, earlyKeyIDSize)
+	hello.Capabilities = helloCapEarly
+	f.Add(hello.Marshal())
+	f.Add(make([]byte, Curve25519KeySize+8+31))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := UnmarshalHandshake(data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(h.Marshal(), data) {
+			t.Fatal("round trip changed handshake payload")
+		}
+
+		// Разбор хвоста: любые длины ID и любые биты возможностей
+		for _, fromServer := range []bool{false, true} {
+			for _, connIDLen := range []int{4, 8, 20} {
+				split := *h
+				split.splitCapabilities(connIDLen, fromServer, 0xff)
+				if n := len(split.IssuedID); n != 0 && n != connIDLen && n != len(h.IssuedID) {
+					t.Fatalf("split issued ID of %d bytes from %d (connIDLen %d)", n, len(h.IssuedID), connIDLen)
+				}
+			}
+		}
+	})
+}
+
+func FuzzSplitCapabilities(f *testing.F) {
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	server := NewHandshakePayload(keys.PublicKey, 0)
+	server.IssuedID = bytes.Repeat([]byte{0x11}, 8)
+	server.ResumeKey = keys.PublicKey[:]
+	server.EarlyKeyID = bytes.Repeat([]byte{0x22}, earlyKeyIDSize)
+	server.CompactID = []byte{7}
+	server.Capabilities = helloCapMux | helloCapResume | helloCapEarly | helloCapCompact
+	f.Add(server.Marshal()[Curve25519KeySize+8+32:], uint8(8), true)
+	client := NewHandshakePayload(keys.PublicKey, 0)
+	client.EarlyKeyID = bytes.Repeat([]byte{0x22}, earlyKeyIDSize)
+	client.UserID = bytes.Repeat([]byte{0x33}, userIDSize)
+	client.Puzzle = make([]byte, puzzleExtSize)
+	client.Capabilities = helloCapEarly | helloCapUser | helloCapPuzzle
+	f.Add(client.Marshal()[Curve25519KeySize+8+32:], uint8(8), false)
+	f.Add([]byte{helloCapsKnown}, uint8(4), false)
+
+	f.Fuzz(func(t *testing.T, tail []byte, idLen uint8, fromServer bool) {
+		connIDLen := 4 + int(idLen)%17
+		h := &HandshakePayload{IssuedID: bytes.Clone(tail)}
+		h.splitCapabilities(connIDLen, fromServer, helloCapsKnown)
+		if h.Capabilities == 0 {
+			if !bytes.Equal(h.IssuedID, tail) {
+				t.Fatalf("tail without capabilities changed: %x -> %x", tail, h.IssuedID)
+			}
+			return
+		}
+
+		// Поля расширений - куски хвоста нужной длины и в его порядке
+		resume, early, compact, user, puzzle := helloExtLens(h.Capabilities, fromServer)
+		if len(h.ResumeKey) != resume || len(h.EarlyKeyID) != early || len(h.CompactID) != compact ||
+			len(h.UserID) != user || len(h.Puzzle) != puzzle {
+			t.Fatalf("extension lengths do not match capabilities %#x", h.Capabilities)
+		}
+		if n := len(h.IssuedID); n != 0 && n != connIDLen {
+			t.Fatalf("issued ID of %d bytes (connIDLen %d)", n, connIDLen)
+		}
+		if again := h.Marshal()[Curve25519KeySize+8+32:]; !bytes.Equal(again, tail) {
+			t.Fatalf("split tail does not marshal back:\n%x\n%x", tail, again)
+		}
+	})
+}
+
+func FuzzAcceptEarly(f *testing.F) {
+	base := newResumption()
+	if base == nil {
+		f.Fatal("no resumption key")
+	}
+	now := time.Unix(1_700_000_000, 0)
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		f.Fatal(err)
+	}
+	hello := NewHandshakePayload(keys.PublicKey, uint64(now.Unix()))
+	hello.EarlyKeyID = base.id[:]
+	hello.Capabilities = helloCapEarly
+	f.Add(hello.Marshal())
+	hello.Timestamp += uint64(earlyDataWindow/time.Second) + 1
+	f.Add(hello.Marshal())
+	f.Add(make([]byte, Curve25519KeySize+8+32+earlyKeyIDSize+1))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := UnmarshalHandshake(data)
+		if err != nil {
+			return
+		}
+		h.splitCapabilities(8, false, helloCapsKnown)
+
+		// Свой strike register на вход: повтор сверяется только с ним
+		r := &resumption{keyPair: base.keyPair, id: base.id, strikes: make(map[[Curve25519KeySize]byte]time.Time)}
+		if r.acceptEarly(h, "fuzz", now) == nil {
+			return
+		}
+		if !bytes.Equal(h.EarlyKeyID, base.id[:]) {
+			t.Fatalf("accepted early key ID %x, want %x", h.EarlyKeyID, base.id)
+		}
+		if r.acceptEarly(h, "fuzz", now) != nil {
+			t.Fatal("accepted a replayed 0-RTT Client Hello")
+		}
+	})
+}
+
+func FuzzCompactExpand(f *testing.F) {
+	connID := bytes.Repeat([]byte{0x5a}, 8)
+	ciphertext := make([]byte, 40)
+	for _, padding := range []bool{false, true} {
+		config := fuzzConfig()
+		config.EnablePadding = padding
+		ad := newDataAD(connID).variants[0]
+		if padding {
+			ad = newDataAD(connID).variants[1]
+		}
+		for pnLen := 1; pnLen <= 2; pnLen++ {
+			f.Add(appendCompactPacket(nil, 7, ad, 1000, pnLen, ciphertext, config), uint32(990))
+		}
+	}
+	f.Add([]byte{FlagFixedBit, 7}, uint32(0))
+
+	f.Fuzz(func(t *testing.T, data []byte, largest uint32) {
+		c := newCompactState(7, connID)
+		full, err := c.expand(bytes.Clone(data), largest)
+		if err != nil {
+			return
+		}
+
+		// Восстановленный пакет - DATA сессии с номером рядом с largest
+		pkt, err := Unmarshal(full, len(connID))
+		if err != nil {
+			t.Fatalf("expanded packet does not parse: %v\n%x", err, full)
+		}
+		if pkt.Type != PacketType_DATA || !bytes.Equal(pkt.ConnectionID, connID) {
+			t.Fatalf("expanded %+v", pkt)
+		}
+		pnLen := int(data[0]&compactPNLenMask) + 1
+		if diff := int64(pkt.PacketNumber) - int64(largest) - 1; diff > 1<<(pnLen*8) || diff < -1<<(pnLen*8) {
+			t.Fatalf("packet number %d too far from %d for %d bytes", pkt.PacketNumber, largest, pnLen)
+		}
+		if !bytes.HasPrefix(data[compactHeaderSize+pnLen:], pkt.Payload) {
+			t.Fatal("expanded payload differs from the compact one")
+		}
+	})
+}
+
+// sealRawState шифрует plaintext как sealState, но без JSON: цели
+// кластера проверяют разбор уже расшифрованных записей
+func sealRawState(t testing.TB, info, magic string, plaintext []byte) (cipher.AEAD, []byte) {
+	aead, err := stateAEAD("fuzz", info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aead.NonceSize())
+	out := append([]byte(magic), nonce...)
+	return aead, aead.Seal(out, nonce, plaintext, []byte(magic))
+}
+
+func FuzzClusterRecord(f *testing.F) {
+	connID := bytes.Repeat([]byte{0x5a}, 8)
+	sendKey, recvKey := bytes.Repeat([]byte{1}, KeySize), bytes.Repeat([]byte{2}, KeySize)
+	record, err := json.Marshal(&clusterRecord{Owner: "a", Epoch: 1, Session: sessionSnapshotEntry{
+		ID: connID, SendKey: sendKey, RecvKey: recvKey, RecvPacketNum: 3,
+	}})
+	if err != nil {
+		f.Fatal(err)
+	}
+	client, err := sessionKeysFromRaw(recvKey, sendKey)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, pktNum := range []uint32{2, 5} {
+		sealed, err := client.Encrypt([]byte("ping"), pktNum, controlAdditionalData(connID, pktNum))
+		if err != nil {
+			f.Fatal(err)
+		}
+		data, _ := NewControlPacket(connID, pktNum, append([]byte{0x07}, sealed...)).Marshal(fuzzConfig())
+		f.Add(record, data, true)
+	}
+	f.Add([]byte(`{"session":{"id":"WlpaWlpaWlo=","sendKey":"AQ==","recvKey":""}}`), []byte{}, false)
+
+	f.Fuzz(func(t *testing.T, plaintext, packet []byte, control bool) {
+		aead, data := sealRawState(t, clusterHKDFInfo, clusterMagic, plaintext)
+		c := &hubCluster{aead: aead}
+		rec, err := c.open(connID, data)
+		if err != nil {
+			return
+		}
+		if !bytes.Equal(rec.Session.ID, connID) {
+			t.Fatalf("opened record of session %x", rec.Session.ID)
+		}
+		pktType := PacketType_DATA
+		if control {
+			pktType = PacketType_CONTROL
+		}
+		if !clusterPacketValid(&rec.Session, packet, pktType, len(connID)) {
+			return
+		}
+		pkt, err := UnmarshalView(packet, len(connID))
+		if err != nil || pkt.PacketNumber <= rec.Session.RecvPacketNum {
+			t.Fatalf("accepted packet %+v after %d (%v)", pkt, rec.Session.RecvPacketNum, err)
+		}
+	})
+}
+
+func FuzzGossipMessage(f *testing.F) {
+	msg, err := json.Marshal(&gossipMessage{ID: []byte{1, 2, 3}, Data: []byte("record"), At: time.Now()})
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(msg)
+	f.Add([]byte(`{"id":null}`))
+
+	f.Fuzz(func(t *testing.T, plaintext []byte) {
+		aead, data := sealRawState(t, gossipHKDFInfo, gossipMagic, plaintext)
+
+		// Испорченная датаграмма не открывается
+		tampered := bytes.Clone(data)
+		tampered[len(tampered)-1] ^= 1
+		var msg gossipMessage
+		if openState(aead, gossipMagic, "cluster gossip", tampered, &msg) == nil {
+			t.Fatal("opened a tampered gossip message")
+		}
+		if openState(aead, gossipMagic, "cluster gossip", data, &msg) != nil {
+			return
+		}
+
+		// Свежая запись применяется и уходит наблюдателю
+		s := &gossipStore{entries: make(map[string]gossipEntry), sweptAt: time.Now()}
+		var watched []byte
+		s.Watch(func(id, data []byte) { watched = data })
+		msg.At = time.Now()
+		s.apply(msg)
+		got, _ := s.Get(msg.ID)
+		if !bytes.Equal(got, msg.Data) || !bytes.Equal(watched, msg.Data) {
+			t.Fatalf("applied gossip %q, stored %q, watched %q", msg.Data, got, watched)
+		}
+	})
+}
@@ -2,8 +2,15 @@ package gametunnel
 
 import (
 	"bytes"
+	"context"
+	"strings"
 	"testing"
 	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
 )
 
 // ====================================================================
@@ -623,6 +630,98 @@ func TestPriorityQueueStats(t *testing.T) {
 	}
 }
 
+func TestPriorityQueueDequeueBlocking(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+
+	got := make(chan *PriorityPacket, 1)
+	go func() {
+		got <- pq.DequeueBlocking()
+	}()
+
+	// Отправитель должен проснуться от Enqueue, а не от таймера
+	time.Sleep(10 * time.Millisecond)
+	pq.EnqueueWithPriority([]byte("high"), PriorityHigh, nil)
+
+	select {
+	case pkt := <-got:
+		if pkt == nil || string(pkt.Data) != "high" {
+			t.Errorf("DequeueBlocking: expected 'high', got %v", pkt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DequeueBlocking did not wake up on Enqueue")
+	}
+
+	// После Close ожидание прерывается
+	go func() {
+		got <- pq.DequeueBlocking()
+	}()
+	pq.Close()
+
+	select {
+	case pkt := <-got:
+		if pkt != nil {
+			t.Errorf("DequeueBlocking after Close: expected nil, got %v", pkt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("DequeueBlocking did not return after Close")
+	}
+}
+
+func TestStreamPriorityPinning(t *testing.T) {
+	streams := map[uint16]*Stream{0: {ID: 0, Active: true}}
+
+	if _, pinned := lookupStreamPriority(streams, 0); pinned {
+		t.Error("Default stream should not be pinned")
+	}
+
+	if err := setStreamPriority(streams, 0, PriorityLow, 16); err != nil {
+		t.Fatalf("setStreamPriority: %v", err)
+	}
+	if level, pinned := lookupStreamPriority(streams, 0); !pinned || level != PriorityLow {
+		t.Errorf("Stream 0: got (%d, %v), want (Low, true)", level, pinned)
+	}
+
+	if err := setStreamPriority(streams, 0, PriorityAuto, 16); err != nil {
+		t.Fatalf("setStreamPriority(Auto): %v", err)
+	}
+	if _, pinned := lookupStreamPriority(streams, 0); pinned {
+		t.Error("PriorityAuto should unpin the stream")
+	}
+
+	if err := setStreamPriority(streams, 1, PriorityLevel(7), 16); err == nil {
+		t.Error("Invalid level should be rejected")
+	}
+	if err := setStreamPriority(streams, 1, PriorityHigh, 1); err == nil {
+		t.Error("Stream limit should be enforced")
+	}
+}
+
+func TestPriorityHintFromContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		target xnet.Destination
+		level  PriorityLevel
+		hinted bool
+	}{
+		{"DNS", xnet.UDPDestination(xnet.IPAddress([]byte{8, 8, 8, 8}), 53), PriorityHigh, true},
+		{"Steam", xnet.UDPDestination(xnet.IPAddress([]byte{1, 2, 3, 4}), 27016), PriorityHigh, true},
+		{"Steam over TCP", xnet.TCPDestination(xnet.IPAddress([]byte{1, 2, 3, 4}), 27016), 0, false},
+		{"HTTPS", xnet.TCPDestination(xnet.DomainAddress("example.com"), 443), 0, false},
+	}
+
+	for _, tt := range tests {
+		ctx := session.ContextWithOutbounds(context.Background(), []*session.Outbound{{Target: tt.target}})
+		level, hinted := priorityHintFromContext(ctx)
+		if hinted != tt.hinted || level != tt.level {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", tt.name, level, hinted, tt.level, tt.hinted)
+		}
+	}
+
+	if _, hinted := priorityHintFromContext(context.Background()); hinted {
+		t.Error("Empty context should give no hint")
+	}
+}
+
 // ====================================================================
 // Тесты конфигурации
 // ====================================================================
@@ -649,6 +748,7 @@ func TestConfigValidation(t *testing.T) {
 		MTU:                9999, // Невалидный
 		MaxStreams:         0,    // Невалидный
 		ConnectionIdLength: 2,   // Невалидный
+		Lenient:            true,
 	}
 
 	config.Validate()
@@ -664,6 +764,39 @@ func TestConfigValidation(t *testing.T) {
 	}
 }
 
+func TestConfigValidationStrict(t *testing.T) {
+	config := DefaultConfig()
+	config.MTU = 14000
+	config.DscpLow = 64
+	config.SendBatch = MaxSendBatch + 1
+
+	// Ошибка перечисляет все недопустимые поля и их не меняет
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, field := range []string{"mtu 14000", "dscpLow 64", "sendBatch 257"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("error %q does not mention %s", err, field)
+		}
+	}
+	if config.MTU != 14000 {
+		t.Errorf("strict Validate changed MTU to %d", config.MTU)
+	}
+
+	// Незаданные значения - по умолчанию, не ошибка
+	if err := (&Config{}).Validate(); err != nil {
+		t.Errorf("zero config: %v", err)
+	}
+
+	// Запуск со строгой проверкой не проходит
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+	if l, err := ListenGameTunnel(context.Background(), xnet.LocalHostIP, 0, streamSettings, func(stat.Connection) {}); err == nil {
+		l.Close()
+		t.Error("listener started with an invalid config")
+	}
+}
+
 func TestObfuscationModeFromString(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -792,6 +925,7 @@ func BenchmarkEncrypt(b *testing.B) {
 	payload := make([]byte, 128) // Типичный игровой пакет
 	ad := make([]byte, 13)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		keys.Encrypt(payload, uint32(i), ad)
@@ -809,6 +943,7 @@ func BenchmarkDecrypt(b *testing.B) {
 	ad := make([]byte, 13)
 	ciphertext, _ := clientKeys.Encrypt(payload, 1, ad)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		serverKeys.Decrypt(ciphertext, 1, ad)
@@ -820,6 +955,7 @@ func BenchmarkMarshalPacket(b *testing.B) {
 	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
 	payload := make([]byte, 128)
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		pkt := NewDataPacket(connID, uint32(i), payload, false)
@@ -834,6 +970,7 @@ func BenchmarkQUICObfuscate(b *testing.B) {
 	data, _ := pkt.Marshal(config)
 	obfs := &QUICObfuscator{connIDLen: int(config.ConnectionIdLength)}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		obfs.Wrap(data)
@@ -852,6 +989,7 @@ func BenchmarkFullPipeline(b *testing.B) {
 	ad := make([]byte, 13)
 	obfs := &QUICObfuscator{connIDLen: int(config.ConnectionIdLength)}
 
+	b.ReportAllocs()
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		ciphertext, _ := clientKeys.Encrypt(payload, uint32(i), ad)
@@ -0,0 +1,35 @@
+#!/bin/sh
+# Сборка привязок gomobile: gametunnel.aar (Android) и
+# GameTunnel.xcframework (iOS, только на macOS с Xcode)
+#
+#   ./build.sh [android|ios|all] [каталог вывода]
+#
+# gomobile bind требует golang.org/x/mobile в go.mod: скрипт
+# добавляет его на время сборки и возвращает go.mod/go.sum на место
+set -eu
+
+target=${1:-all}
+out=${2:-build}
+pkg=./transport/internet/gametunnel/gtmobile
+
+cd "$(dirname "$0")/../../../.."
+mkdir -p "$out"
+
+go install golang.org/x/mobile/cmd/gomobile@latest
+go install golang.org/x/mobile/cmd/gobind@latest
+gomobile init
+
+cp go.mod go.mod.gtmobile
+cp go.sum go.sum.gtmobile
+trap 'mv go.mod.gtmobile go.mod; mv go.sum.gtmobile go.sum' EXIT
+go get golang.org/x/mobile/bind@latest
+
+# -trimpath и -s -w: без путей сборки и отладочной информации
+if [ "$target" = android ] || [ "$target" = all ]; then
+	gomobile bind -target=android -androidapi 21 -javapkg=com.gametunnel \
+		-trimpath -ldflags='-s -w' -o "$out/gametunnel.aar" "$pkg"
+fi
+if [ "$target" = ios ] || [ "$target" = all ]; then
+	gomobile bind -target=ios,iossimulator -prefix=GT \
+		-trimpath -ldflags='-s -w' -o "$out/GameTunnel.xcframework" "$pkg"
+fi
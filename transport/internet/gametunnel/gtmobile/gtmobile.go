@@ -0,0 +1,223 @@
+// Package gtmobile - API GameTunnel для мобильных приложений (gomobile)
+package gtmobile
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+	"github.com/xtls/xray-core/transport/internet/gametunnel/standalone"
+)
+
+// ====================================================================
+// Привязки gomobile для Android и iOS
+// ====================================================================
+//
+// VPN-приложение (Android VpnService, iOS NEPacketTunnelProvider)
+// встраивает GameTunnel библиотекой: gomobile bind собирает пакет в
+// AAR и XCFramework (build.sh). API ограничен типами, которые
+// понимает gomobile: строки, []byte, int, bool, интерфейсы и
+// указатели на структуры пакета.
+//
+// Два режима, конфиг - JSON автономного клиента (standalone.Config):
+//
+//   - Connect - одно соединение с gametunnel-server (проброс точка-
+//     точка): приложение пишет данные Write, ответы приходят в
+//     PacketHandler.OnPacket
+//   - StartSocks - локальный SOCKS5 с VLESS к обычному серверу xray;
+//     на него приложение направляет tun2socks
+//
+// Probe меряет маршрут до сервера конфига без соединения: приложение
+// пробует свои серверы и подключается к лучшему.
+//
+// Платформа сообщает о себе сама:
+//
+//   - SetPlatform - Protect выводит сокеты клиента из туннеля
+//     приложения (VpnService.protect); без этого пакеты к серверу
+//     ушли бы в тот же туннель
+//   - NetworkChanged - смена сети (ConnectivityManager,
+//     NWPathMonitor): все соединения сразу переходят на новый сокет,
+//     сессии продолжаются без хэндшейка (roaming.go)
+//
+// ====================================================================
+
+// Platform - функции приложения, которые вызывает GameTunnel
+type Platform interface {
+	// Protect выводит сокет fd из VPN приложения; false - сокет
+	// открыть нельзя
+	Protect(fd int) bool
+}
+
+// PacketHandler - приёмник данных соединения Connect
+type PacketHandler interface {
+	// OnPacket - данные от сервера; срез действителен только до
+	// возврата из вызова
+	OnPacket(data []byte)
+
+	// OnClose - соединение закрыто; reason - причина ("" - Close)
+	OnClose(reason string)
+}
+
+// SetPlatform задаёт функции приложения (nil - снять)
+// Вызывается до Connect и StartSocks
+func SetPlatform(platform Platform) {
+	applyPlatformLimits()
+	if platform == nil {
+		gametunnel.SetSocketProtector(nil)
+		return
+	}
+	gametunnel.SetSocketProtector(func(fd uintptr) bool {
+		return platform.Protect(int(fd))
+	})
+}
+
+// NetworkChanged сообщает о смене сети: все соединения процесса
+// переоткрывают сокеты
+func NetworkChanged() {
+	gametunnel.NetworkChanged()
+}
+
+// Stats возвращает статистику всех соединений процесса в JSON
+// (массив gametunnel.ClientStats)
+func Stats() string {
+	var buf bytes.Buffer
+	gametunnel.WriteClientStats(&buf)
+	return buf.String()
+}
+
+// parseConfig разбирает JSON конфигурации клиента
+func parseConfig(configJSON string) (*standalone.Config, error) {
+	config := new(standalone.Config)
+	if err := json.Unmarshal([]byte(configJSON), config); err != nil {
+		return nil, fmt.Errorf("parse config: %w", err)
+	}
+	return config, nil
+}
+
+// forwardClient создаёт клиента проброса точка-точка по configJSON
+func forwardClient(configJSON string) (*standalone.Client, error) {
+	config, err := parseConfig(configJSON)
+	if err != nil {
+		return nil, err
+	}
+	// Connect - тот же проброс точка-точка, только вместо
+	// локального порта данные даёт приложение
+	config.Socks, config.Tun = "", nil
+	if config.Forward == nil {
+		config.Forward = new(standalone.ForwardConfig)
+	}
+	return standalone.NewClient(config)
+}
+
+// probeTimeout - предел Probe
+const probeTimeout = 5 * time.Second
+
+// Probe меряет маршрут до сервера конфигурации configJSON без
+// соединения; результат - JSON gametunnel.ProbeResult
+func Probe(configJSON string) (string, error) {
+	client, err := forwardClient(configJSON)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	result, err := client.Probe(ctx)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Tunnel - соединение Connect
+type Tunnel struct {
+	conn    net.Conn
+	handler PacketHandler
+
+	// closed - вызван Close: ошибка чтения - не причина закрытия
+	closed int32
+	done   chan struct{}
+}
+
+// Connect подключается к gametunnel-server по конфигурации
+// configJSON; данные сервера идут в handler
+func Connect(configJSON string, handler PacketHandler) (*Tunnel, error) {
+	if handler == nil {
+		return nil, errors.New("nil packet handler")
+	}
+	client, err := forwardClient(configJSON)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Tunnel{conn: conn, handler: handler, done: make(chan struct{})}
+	go t.receive()
+	return t, nil
+}
+
+// receive передаёт данные сервера обработчику до закрытия
+func (t *Tunnel) receive() {
+	defer close(t.done)
+
+	buf := make([]byte, gametunnel.MaxPacketSize)
+	for {
+		n, err := t.conn.Read(buf)
+		if err != nil {
+			reason := err.Error()
+			if atomic.LoadInt32(&t.closed) == 1 {
+				reason = ""
+			}
+			t.handler.OnClose(reason)
+			return
+		}
+		t.handler.OnPacket(buf[:n])
+	}
+}
+
+// Write отправляет данные серверу
+func (t *Tunnel) Write(data []byte) error {
+	_, err := t.conn.Write(data)
+	return err
+}
+
+// NetworkChanged сообщает о смене сети этому соединению
+func (t *Tunnel) NetworkChanged() {
+	if c, ok := t.conn.(*gametunnel.GameTunnelClientConn); ok {
+		c.NetworkChanged()
+	}
+}
+
+// Stats возвращает статистику соединения в JSON
+// (gametunnel.ClientStats)
+func (t *Tunnel) Stats() string {
+	c, ok := t.conn.(*gametunnel.GameTunnelClientConn)
+	if !ok {
+		return "{}"
+	}
+	data, err := json.Marshal(c.GetStats())
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// Close закрывает соединение; OnClose придёт с пустой причиной
+func (t *Tunnel) Close() error {
+	atomic.StoreInt32(&t.closed, 1)
+	err := t.conn.Close()
+	<-t.done
+	return err
+}
@@ -0,0 +1,167 @@
+package gtmobile
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+	"github.com/xtls/xray-core/transport/internet/gametunnel/standalone"
+)
+
+// countingPlatform считает защищённые сокеты
+type countingPlatform struct {
+	protected int32
+}
+
+func (p *countingPlatform) Protect(fd int) bool {
+	atomic.AddInt32(&p.protected, 1)
+	return fd > 0
+}
+
+// recordingHandler собирает данные и причину закрытия
+type recordingHandler struct {
+	packets chan string
+	closed  chan string
+}
+
+func (h *recordingHandler) OnPacket(data []byte)  { h.packets <- string(data) }
+func (h *recordingHandler) OnClose(reason string) { h.closed <- reason }
+
+// startEchoServer поднимает gametunnel-server к UDP-эхо
+func startEchoServer(t *testing.T) string {
+	t.Helper()
+
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := target.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			target.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	server, err := standalone.NewServer(&standalone.ServerConfig{Listen: "127.0.0.1:0", Target: target.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ln, err := server.Listen(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		ln.Close()
+	})
+	return ln.Addr().String()
+}
+
+func TestConnect(t *testing.T) {
+	platform := &countingPlatform{}
+	SetPlatform(platform)
+	t.Cleanup(func() { SetPlatform(nil) })
+
+	server := startEchoServer(t)
+	handler := &recordingHandler{packets: make(chan string, 8), closed: make(chan string, 1)}
+	tunnel, err := Connect(`{"server": "`+server+`"}`, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&platform.protected) == 0 {
+		t.Error("socket not protected")
+	}
+
+	echo := func(msg string) {
+		t.Helper()
+		if err := tunnel.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-handler.packets:
+			if got != msg {
+				t.Fatalf("got %q, want %q", got, msg)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no echo for %q", msg)
+		}
+	}
+	echo("tick")
+
+	// Смена сети от платформы: новый защищённый сокет, та же сессия
+	before := atomic.LoadInt32(&platform.protected)
+	NetworkChanged()
+	echo("after handover")
+	if atomic.LoadInt32(&platform.protected) == before {
+		t.Error("network change did not open a new protected socket")
+	}
+
+	var stats gametunnel.ClientStats
+	if err := json.Unmarshal([]byte(tunnel.Stats()), &stats); err != nil || stats.PacketsSent == 0 || stats.Rebinds == 0 {
+		t.Errorf("stats %+v: %v", stats, err)
+	}
+	if all := Stats(); !strings.Contains(all, stats.RemoteAddr) {
+		t.Errorf("process stats %s miss the tunnel", all)
+	}
+
+	tunnel.Close()
+	if reason := <-handler.closed; reason != "" {
+		t.Errorf("close reason %q after Close", reason)
+	}
+}
+
+func TestConnectValidation(t *testing.T) {
+	handler := &recordingHandler{}
+	for _, config := range []string{`{`, `{"server": "no-port"}`} {
+		if _, err := Connect(config, handler); err == nil {
+			t.Errorf("Connect(%s) accepted", config)
+		}
+	}
+	if _, err := Connect(`{"server": "127.0.0.1:443"}`, nil); err == nil {
+		t.Error("nil handler accepted")
+	}
+}
+
+func TestStartSocks(t *testing.T) {
+	socks, err := StartSocks(`{"server": "127.0.0.1:443", "uuid": "b831381d-6324-4d53-ad4f-8cda48b30811"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", socks.Addr())
+	if err != nil {
+		t.Fatalf("dial socks %s: %v", socks.Addr(), err)
+	}
+	conn.Close()
+	if err := socks.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+	if _, err := StartSocks(`{"server": "127.0.0.1:443"}`); err == nil {
+		t.Error("socks without uuid accepted")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	server := startEchoServer(t)
+	out, err := Probe(`{"server": "` + server + `"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result gametunnel.ProbeResult
+	if err := json.Unmarshal([]byte(out), &result); err != nil || result.Received == 0 || result.RTT <= 0 {
+		t.Errorf("probe %s: %v", out, err)
+	}
+	if _, err := Probe(`{"server": "no-port"}`); err == nil {
+		t.Error("probe of an address without port")
+	}
+}
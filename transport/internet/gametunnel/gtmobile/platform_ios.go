@@ -0,0 +1,19 @@
+package gtmobile
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// iosMemoryLimit - мягкий предел памяти Go в Network Extension:
+// iOS завершает расширение за 50 МБ, сборщик должен успеть раньше
+const iosMemoryLimit = 40 << 20
+
+var platformLimitsOnce sync.Once
+
+// applyPlatformLimits выставляет предел памяти расширения
+func applyPlatformLimits() {
+	platformLimitsOnce.Do(func() {
+		debug.SetMemoryLimit(iosMemoryLimit)
+	})
+}
@@ -0,0 +1,6 @@
+//go:build !ios
+
+package gtmobile
+
+// applyPlatformLimits - на остальных платформах пределов нет
+func applyPlatformLimits() {}
@@ -0,0 +1,58 @@
+package gtmobile
+
+import (
+	"context"
+	"net"
+
+	"github.com/xtls/xray-core/transport/internet/gametunnel/standalone"
+)
+
+// defaultSocksListen - адрес SOCKS5 без socks в конфиге: свободный
+// порт loopback, его возвращает Addr
+const defaultSocksListen = "127.0.0.1:0"
+
+// Socks - локальный SOCKS5 StartSocks
+type Socks struct {
+	ln     net.Listener
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// StartSocks поднимает локальный SOCKS5 (TCP и UDP) с VLESS поверх
+// GameTunnel по конфигурации configJSON (server, uuid, socks,
+// gametunnelSettings)
+func StartSocks(configJSON string) (*Socks, error) {
+	config, err := parseConfig(configJSON)
+	if err != nil {
+		return nil, err
+	}
+	if config.Socks == "" {
+		config.Socks = defaultSocksListen
+	}
+	config.Tun, config.Forward = nil, nil
+	client, err := standalone.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("tcp", config.Socks)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Socks{ln: ln, cancel: cancel, done: make(chan error, 1)}
+	go func() { s.done <- client.ServeSocks(ctx, ln) }()
+	return s, nil
+}
+
+// Addr возвращает адрес SOCKS5 "ip:port"
+func (s *Socks) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close останавливает SOCKS5; открытые соединения закрываются сами
+// с закрытием сторон
+func (s *Socks) Close() error {
+	s.cancel()
+	return <-s.done
+}
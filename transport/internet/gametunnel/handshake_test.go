@@ -0,0 +1,605 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// clientHello собирает обфусцированный Client Hello для хаба
+func clientHello(t *testing.T, h *Hub, connID []byte, pub [Curve25519KeySize]byte) []byte {
+	t.Helper()
+
+	payload := NewHandshakePayload(pub, uint64(time.Now().Unix())).Marshal()
+	data, err := NewHandshakePacket(connID, 0, payload).Marshal(h.getConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := h.obfs.Wrap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wrapped
+}
+
+// readServerHelloKey читает Server Hello и возвращает ключ сервера
+func readServerHelloKey(t *testing.T, h *Hub, conn *net.UDPConn) [Curve25519KeySize]byte {
+	t.Helper()
+
+	buf := make([]byte, MaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Server Hello not received: %v", err)
+	}
+	data, _ := h.obfs.Unwrap(buf[:n])
+	pkt, err := Unmarshal(data, int(h.getConfig().ConnectionIdLength))
+	if err != nil || pkt.Type != PacketType_HANDSHAKE {
+		t.Fatalf("Not a Server Hello: %v", err)
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hello.PublicKey
+}
+
+// newHelloClient открывает UDP-сокет, который играет роль клиента
+func newHelloClient(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHandshakeDuplicateHello(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "handshake"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	client := newHelloClient(t)
+	addr := client.LocalAddr().(*net.UDPAddr)
+
+	keyPair, _ := GenerateKeyPair()
+	connID := []byte{1, 1, 2, 2, 3, 3, 4, 4}
+	hello := clientHello(t, h, connID, keyPair.PublicKey)
+
+	if _, _, err := h.RoutePacket(hello, addr); err != nil {
+		t.Fatal(err)
+	}
+	first := readServerHelloKey(t, h, client)
+
+	// Повтор (клиент не дождался ответа или сеть задублировала пакет)
+	// получает тот же Server Hello - ключи сессии не меняются
+	session := h.GetSession(connID)
+	keys := session.Keys
+	if _, _, err := h.RoutePacket(hello, addr); err != nil {
+		t.Fatal(err)
+	}
+	if second := readServerHelloKey(t, h, client); second != first {
+		t.Error("Duplicate hello got a different server key")
+	}
+	if h.GetSession(connID) != session || session.Keys != keys {
+		t.Error("Duplicate hello replaced the session")
+	}
+	if h.GetActiveSessions() != 1 || h.GetStats().Handshakes != 1 {
+		t.Errorf("active=%d handshakes=%d", h.GetActiveSessions(), h.GetStats().Handshakes)
+	}
+}
+
+func TestHandshakeDifferentKeyIgnored(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "handshake"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	client := newHelloClient(t)
+	addr := client.LocalAddr().(*net.UDPAddr)
+
+	owner, _ := GenerateKeyPair()
+	connID := []byte{5, 5, 6, 6, 7, 7, 8, 8}
+	if _, _, err := h.RoutePacket(clientHello(t, h, connID, owner.PublicKey), addr); err != nil {
+		t.Fatal(err)
+	}
+	readServerHelloKey(t, h, client)
+
+	// Чужой Client Hello с тем же Connection ID с другого адреса
+	intruder, _ := GenerateKeyPair()
+	other := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: addr.Port + 1}
+	if _, _, err := h.RoutePacket(clientHello(t, h, connID, intruder.PublicKey), other); err == nil {
+		t.Error("Hello with a different key accepted")
+	}
+
+	session := h.GetSession(connID)
+	if session.RemoteAddr.String() != addr.String() {
+		t.Errorf("Session moved to %s", session.RemoteAddr)
+	}
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := client.Read(make([]byte, MaxPacketSize)); err == nil {
+		t.Error("Server Hello resent for a different key")
+	}
+}
+
+func TestHandshakeConcurrentHellos(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "handshake"
+	config.MaxSessionsPerIp = 1
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	client := newHelloClient(t)
+	addr := client.LocalAddr().(*net.UDPAddr)
+
+	keyPair, _ := GenerateKeyPair()
+	connID := []byte{9, 9, 8, 8, 7, 7, 6, 6}
+	hello := clientHello(t, h, connID, keyPair.PublicKey)
+
+	// Копии одного Client Hello с разных сокетов приёма
+	const copies = 8
+	var wg sync.WaitGroup
+	for i := 0; i < copies; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.RoutePacket(hello, addr)
+		}()
+	}
+	wg.Wait()
+
+	first := readServerHelloKey(t, h, client)
+	for i := 1; i < copies; i++ {
+		if key := readServerHelloKey(t, h, client); key != first {
+			t.Fatal("Concurrent hellos produced divergent server keys")
+		}
+	}
+	if h.GetActiveSessions() != 1 {
+		t.Fatalf("active=%d, want 1", h.GetActiveSessions())
+	}
+
+	// Проигравшие копии вернули слот IP: после закрытия сессии
+	// новый клиент с того же IP проходит maxSessionsPerIp = 1
+	h.RemoveSession(connID)
+	next, _ := GenerateKeyPair()
+	if _, _, err := h.RoutePacket(clientHello(t, h, []byte{1, 2, 3, 4, 5, 6, 7, 8}, next.PublicKey), addr); err != nil {
+		t.Fatalf("IP slot leaked: %v", err)
+	}
+}
+
+func TestReadServerHelloSkipsStrayPackets(t *testing.T) {
+	config := DefaultConfig()
+	server := newHelloClient(t)
+	conn, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	obfs := NewObfuscator(config.Obfuscation, config)
+
+	// Сервер узнаёт адрес клиента
+	conn.Write([]byte{0})
+	_, clientAddr, _ := server.ReadFromUDP(make([]byte, 16))
+
+	connID := []byte{4, 3, 2, 1, 4, 3, 2, 1}
+	keyPair, _ := GenerateKeyPair()
+	helloFor := func(id []byte) []byte {
+		payload := NewHandshakePayload(keyPair.PublicKey, 0).Marshal()
+		data, _ := NewHandshakePacket(id, 1, payload).Marshal(config)
+		wrapped, _ := obfs.Wrap(data)
+		return wrapped
+	}
+
+	// Мусор и запоздавший ответ на чужой Connection ID приходят раньше
+	server.WriteToUDP([]byte("garbage"), clientAddr)
+	server.WriteToUDP(helloFor([]byte{0, 0, 0, 0, 0, 0, 0, 0}), clientAddr)
+	server.WriteToUDP(helloFor(connID), clientAddr)
+
+	pkt, err := readServerHello(context.Background(), conn, connID, config, obfs, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pkt.ConnectionID, connID) {
+		t.Errorf("Got hello for %x", pkt.ConnectionID)
+	}
+}
+
+// helloHandshake - performHandshake клиента, которому тест отвечает
+// своими Server Hello
+type helloHandshake struct {
+	server     *net.UDPConn
+	clientAddr *net.UDPAddr
+	connID     []byte
+	clientKey  [Curve25519KeySize]byte
+	done       chan helloResult
+}
+
+type helloResult struct {
+	session *ClientSession
+	err     error
+}
+
+// startHelloHandshake запускает хэндшейк и читает его Client Hello
+func startHelloHandshake(t *testing.T, config *Config, obfs Obfuscator) *helloHandshake {
+	t.Helper()
+	server := newHelloClient(t)
+	conn, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	hs := &helloHandshake{server: server, done: make(chan helloResult, 1)}
+	go func() {
+		session, err := performHandshake(context.Background(), conn, config, obfs)
+		hs.done <- helloResult{session, err}
+	}()
+
+	buf := make([]byte, MaxPacketSize)
+	n, clientAddr, err := server.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := obfs.Unwrap(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := Unmarshal(data, int(config.ConnectionIdLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs.clientAddr, hs.connID, hs.clientKey = clientAddr, pkt.ConnectionID, hello.PublicKey
+	return hs
+}
+
+// reply отправляет клиенту Server Hello с ключом pub, подписанный psk
+// для ключа клиента clientKey
+func (hs *helloHandshake) reply(config *Config, obfs Obfuscator, pub [Curve25519KeySize]byte, psk string, clientKey [Curve25519KeySize]byte) {
+	payload := NewHandshakePayload(pub, 0).Marshal()
+	sealServerHello(payload, psk, clientKey)
+	data, _ := NewHandshakePacket(hs.connID, 1, payload).Marshal(config)
+	wrapped, _ := obfs.Wrap(data)
+	hs.server.WriteToUDP(wrapped, hs.clientAddr)
+}
+
+func TestHandshakeRejectsForgedServerHello(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "signed"
+	obfs := NewObfuscator(config.Obfuscation, config)
+	hs := startHelloHandshake(t, config, obfs)
+
+	// Подделки знают Connection ID, но не PSK: без подписи, с чужим
+	// PSK и с подписью для другого ключа клиента
+	before := atomic.LoadUint64(&metrics.client.spoofedHellos)
+	forged, _ := GenerateKeyPair()
+	other, _ := GenerateKeyPair()
+	unsigned := NewHandshakePayload(forged.PublicKey, 0).Marshal()
+	data, _ := NewHandshakePacket(hs.connID, 1, unsigned).Marshal(config)
+	wrapped, _ := obfs.Wrap(data)
+	hs.server.WriteToUDP(wrapped, hs.clientAddr)
+	hs.reply(config, obfs, forged.PublicKey, "guess", hs.clientKey)
+	hs.reply(config, obfs, forged.PublicKey, config.Key, other.PublicKey)
+
+	genuine, _ := GenerateKeyPair()
+	hs.reply(config, obfs, genuine.PublicKey, config.Key, hs.clientKey)
+
+	r := <-hs.done
+	if r.err != nil {
+		t.Fatalf("Handshake aborted by a forged hello: %v", r.err)
+	}
+	secret, _ := ComputeSharedSecret(genuine.PrivateKey, hs.clientKey)
+	keys, _ := DeriveSessionKeys(secret, config.Key, false)
+	if r.session.Keys.RecvKey != keys.SendKey {
+		t.Error("Accepted a forged Server Hello")
+	}
+	if got := atomic.LoadUint64(&metrics.client.spoofedHellos) - before; got < 3 {
+		t.Errorf("Forged hellos counted %d, want 3", got)
+	}
+}
+
+func TestHandshakeIgnoresForgedBusyAndRetry(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "signed"
+	obfs := NewObfuscator(config.Obfuscation, config)
+	hs := startHelloHandshake(t, config, obfs)
+
+	// BUSY и RETRY без подписи и с чужим PSK не прерывают хэндшейк
+	before := atomic.LoadUint64(&metrics.client.spoofedHellos)
+	busy := []byte{controlBusy, 0, 7}
+	retry := append([]byte{controlRetry}, make([]byte, puzzleTokenSize)...)
+	for _, payload := range [][]byte{
+		busy,
+		retry,
+		sealHandshakeControl(append([]byte(nil), busy...), "guess", hs.clientKey),
+		sealHandshakeControl(append([]byte(nil), retry...), "guess", hs.clientKey),
+	} {
+		data, _ := NewControlPacket(hs.connID, 0, payload).Marshal(config)
+		wrapped, _ := obfs.Wrap(data)
+		hs.server.WriteToUDP(wrapped, hs.clientAddr)
+	}
+
+	genuine, _ := GenerateKeyPair()
+	hs.reply(config, obfs, genuine.PublicKey, config.Key, hs.clientKey)
+
+	r := <-hs.done
+	if r.err != nil {
+		t.Fatalf("Handshake aborted by a forged control packet: %v", r.err)
+	}
+	if got := atomic.LoadUint64(&metrics.client.spoofedHellos) - before; got < 4 {
+		t.Errorf("Forged control packets counted %d, want 4", got)
+	}
+}
+
+func TestHandshakeAcceptsSignedBusy(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "signed"
+	obfs := NewObfuscator(config.Obfuscation, config)
+	hs := startHelloHandshake(t, config, obfs)
+
+	payload := sealHandshakeControl([]byte{controlBusy, 0, 7}, config.Key, hs.clientKey)
+	data, _ := NewControlPacket(hs.connID, 0, payload).Marshal(config)
+	wrapped, _ := obfs.Wrap(data)
+	hs.server.WriteToUDP(wrapped, hs.clientAddr)
+
+	r := <-hs.done
+	var busy *ServerBusyError
+	if !errors.As(r.err, &busy) || busy.RetryAfter != 7*time.Second {
+		t.Fatalf("Handshake error %v, want BUSY with retry-after 7s", r.err)
+	}
+}
+
+func TestHandshakeSkipsUnusableServerHello(t *testing.T) {
+	config := DefaultConfig()
+	obfs := NewObfuscator(config.Obfuscation, config)
+	hs := startHelloHandshake(t, config, obfs)
+
+	// Нулевой ключ (low-order point) общего секрета не даёт - клиент
+	// ждёт следующий ответ
+	var zero [Curve25519KeySize]byte
+	genuine, _ := GenerateKeyPair()
+	for _, pub := range [][Curve25519KeySize]byte{zero, genuine.PublicKey} {
+		hs.reply(config, obfs, pub, config.Key, hs.clientKey)
+	}
+
+	r := <-hs.done
+	if r.err != nil {
+		t.Fatalf("Handshake aborted by a forged hello: %v", r.err)
+	}
+	if !bytes.Equal(r.session.ConnectionID, hs.connID) {
+		t.Errorf("Session ID %x, want %x", r.session.ConnectionID, hs.connID)
+	}
+}
+
+func TestDialHonorsContext(t *testing.T) {
+	// Сервер, который никогда не отвечает на Client Hello
+	sink := newSink(t)
+	addr := sink.LocalAddr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	config := DefaultConfig()
+	config.Key = "handshake"
+	config.HandshakeTimeout = 30
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+
+	// Дедлайн ctx раньше HandshakeTimeout
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := Dial(ctx, dest, streamSettings); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Dial with deadline: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Dial took %v despite the ctx deadline", elapsed)
+	}
+
+	// Отмена посреди ожидания Server Hello
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := Dial(ctx, dest, streamSettings); !errors.Is(err, context.Canceled) {
+		t.Errorf("Dial with cancel: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Dial took %v after cancel", elapsed)
+	}
+}
+
+func TestHandshakeObfuscation(t *testing.T) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	keyPair, _ := GenerateKeyPair()
+	payload := NewHandshakePayload(keyPair.PublicKey, uint64(time.Now().Unix())).Marshal()
+	hello, err := NewHandshakePacket(connID, 0, payload).Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []ObfuscationMode{ObfuscationMode_QUIC_MIMIC, ObfuscationMode_WEBRTC_MIMIC, ObfuscationMode_RAW} {
+		obfs := NewObfuscator(mode, config)
+		wrapped, err := wrapHandshake(obfs, hello, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", obfs.Name(), err)
+		}
+
+		switch mode {
+		case ObfuscationMode_QUIC_MIMIC:
+			// Initial клиента - не меньше 1200 байт, как у браузеров
+			if len(wrapped) < quicInitialMinSize || len(wrapped) > quicInitialMaxSize {
+				t.Errorf("quic: handshake datagram %d bytes", len(wrapped))
+			}
+		case ObfuscationMode_WEBRTC_MIMIC:
+			if wrapped[0] != dtlsContentTypeHandshake || wrapped[3] != 0 || wrapped[4] != 0 {
+				t.Errorf("webrtc: record type %d epoch %x, want handshake epoch 0", wrapped[0], wrapped[3:5])
+			}
+		}
+
+		unwrapped, err := obfs.Unwrap(wrapped)
+		if err != nil {
+			t.Fatalf("%s: unwrap: %v", obfs.Name(), err)
+		}
+		pkt, err := Unmarshal(unwrapped, int(config.ConnectionIdLength))
+		if err != nil || pkt.Type != PacketType_HANDSHAKE || !bytes.Equal(pkt.Payload, payload) {
+			t.Errorf("%s: handshake not restored: %v", obfs.Name(), err)
+		}
+	}
+
+	// Данные добивку хэндшейка не получают
+	quic := NewObfuscator(ObfuscationMode_QUIC_MIMIC, config)
+	data, _ := NewDataPacket(connID, 1, []byte("move"), false).Marshal(config)
+	if wrapped, _ := quic.Wrap(data); len(wrapped) >= quicInitialMinSize {
+		t.Errorf("data packet padded to %d bytes", len(wrapped))
+	}
+
+	// Потолок - MTU пути
+	small := *config
+	small.MTU = 900
+	wrapped, _ := wrapHandshake(NewObfuscator(ObfuscationMode_QUIC_MIMIC, &small), hello, 0)
+	if len(wrapped) != 900 {
+		t.Errorf("handshake with mtu 900: %d bytes", len(wrapped))
+	}
+}
+
+func TestServerHelloAmplificationLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "handshake"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	client := newHelloClient(t)
+	addr := client.LocalAddr().(*net.UDPAddr)
+	buf := make([]byte, MaxPacketSize)
+
+	keyPair, _ := GenerateKeyPair()
+	payload := NewHandshakePayload(keyPair.PublicKey, uint64(time.Now().Unix())).Marshal()
+	data, _ := NewHandshakePacket([]byte{9, 9, 8, 8, 7, 7, 6, 6}, 0, payload).Marshal(config)
+
+	// Короткий Client Hello (старый клиент) - ответ не больше трёх его размеров
+	short, _ := h.obfs.Wrap(data)
+	if _, _, err := h.RoutePacket(short, addr); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n > 3*len(short) {
+		t.Errorf("server hello %d bytes for %d-byte client hello", n, len(short))
+	}
+
+	// Полный Initial - Server Hello тоже размера Initial
+	padded, _ := wrapHandshake(h.obfs, data, 0)
+	if _, _, err := h.RoutePacket(padded, addr); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if n < quicInitialMinSize {
+		t.Errorf("server hello %d bytes, want at least %d", n, quicInitialMinSize)
+	}
+}
+
+func TestHandshakeSizeTarget(t *testing.T) {
+	config := DefaultConfig()
+	connID, _ := GenerateConnectionID(int(config.ConnectionIdLength))
+	keyPair, _ := GenerateKeyPair()
+	payload := NewHandshakePayload(keyPair.PublicKey, uint64(time.Now().Unix())).Marshal()
+	hello, _ := NewHandshakePacket(connID, 0, payload).Marshal(config)
+
+	// По умолчанию webrtc и raw хэндшейк не добивают
+	for _, mode := range []ObfuscationMode{ObfuscationMode_WEBRTC_MIMIC, ObfuscationMode_RAW} {
+		wrapped, _ := wrapHandshake(NewObfuscator(mode, config), hello, 0)
+		if len(wrapped) > len(hello)+dtlsHeaderSize {
+			t.Errorf("mode %v: default handshake padded to %d bytes", mode, len(wrapped))
+		}
+	}
+
+	// Явная цель действует во всех режимах, пакет восстанавливается
+	target := *config
+	target.HandshakeMinSize = 1300
+	target.HandshakeMaxSize = 1320
+	for _, mode := range []ObfuscationMode{ObfuscationMode_QUIC_MIMIC, ObfuscationMode_WEBRTC_MIMIC, ObfuscationMode_RAW} {
+		obfs := NewObfuscator(mode, &target)
+		wrapped, err := wrapHandshake(obfs, hello, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", obfs.Name(), err)
+		}
+		if len(wrapped) < 1300 || len(wrapped) > 1320 {
+			t.Errorf("%s: handshake datagram %d bytes, want 1300-1320", obfs.Name(), len(wrapped))
+		}
+		unwrapped, err := obfs.Unwrap(wrapped)
+		if err != nil {
+			t.Fatalf("%s: unwrap: %v", obfs.Name(), err)
+		}
+		if pkt, err := Unmarshal(unwrapped, int(config.ConnectionIdLength)); err != nil || !bytes.Equal(pkt.Payload, payload) {
+			t.Errorf("%s: handshake not restored: %v", obfs.Name(), err)
+		}
+		// Данные цель хэндшейка не трогает
+		if data, _ := obfs.Wrap(hello); len(data) >= 1300 {
+			t.Errorf("%s: data packet padded to %d bytes", obfs.Name(), len(data))
+		}
+	}
+
+	// Только min - ровно min
+	fixed := *config
+	fixed.HandshakeMinSize = 1280
+	if wrapped, _ := wrapHandshake(NewObfuscator(ObfuscationMode_QUIC_MIMIC, &fixed), hello, 0); len(wrapped) != 1280 {
+		t.Errorf("handshakeMinSize 1280: %d bytes", len(wrapped))
+	}
+
+	for _, bad := range [][2]uint32{{1300, 1250}, {0, 1600}, {1600, 0}} {
+		c := DefaultConfig()
+		c.HandshakeMinSize, c.HandshakeMaxSize = bad[0], bad[1]
+		if err := c.Validate(); err == nil {
+			t.Errorf("handshake size %v accepted", bad)
+		}
+	}
+}
+
+func TestHubHandshakeSizeTarget(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "handshake"
+	config.Obfuscation = ObfuscationMode_RAW
+	config.HandshakeMinSize = 1250
+	l, accepted := startTestListener(t, config)
+	h := l.hub
+
+	// Добитые хэндшейки в режиме raw проходят целиком
+	conn, server := dialTestClient(t, l, config, accepted)
+	defer conn.Close()
+	conn.Write([]byte("padded"))
+	if got := readWithTimeout(t, server, 64); string(got) != "padded" {
+		t.Errorf("server read %q", got)
+	}
+
+	// Server Hello хаба - той же цели, что и Client Hello клиента
+	client := newHelloClient(t)
+	keyPair, _ := GenerateKeyPair()
+	payload := NewHandshakePayload(keyPair.PublicKey, uint64(time.Now().Unix())).Marshal()
+	data, _ := NewHandshakePacket([]byte{9, 9, 8, 8, 7, 7, 6, 6}, 0, payload).Marshal(config)
+	hello, _ := wrapHandshake(h.obfs, data, 0)
+	if len(hello) != 1250 {
+		t.Fatalf("client hello %d bytes", len(hello))
+	}
+	if _, _, err := h.RoutePacket(hello, client.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, MaxPacketSize)
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1250 {
+		t.Errorf("server hello %d bytes, want 1250", n)
+	}
+}
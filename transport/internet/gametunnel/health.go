@@ -0,0 +1,288 @@
+package gametunnel
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Обнаружение «чёрной дыры» на пути (path health)
+// ====================================================================
+//
+// Молчащая сессия может быть просто простаивающей, а может быть
+// сломанной: NAT забыл маппинг, Wi-Fi сменился на LTE, файрвол
+// начал глотать UDP. Снаружи оба случая выглядят одинаково -
+// пакетов нет. Признаки сломанного пути:
+//
+//   - blackholeThreshold ошибок отправки подряд (сокет, маршрут);
+//   - probe (сервер) или keep-alive (клиент) без ответа.
+//
+// Сервер помечает такую сессию degraded и продолжает проверять её
+// probe (deadpeer.go): ответ клиента или любой его пакет снимает
+// отметку, deadPeerProbes проверок без ответа удаляют сессию.
+//
+// Клиент, заметив blackholeThreshold keep-alive без ответа или
+// ошибок отправки подряд, открывает новый сокет (новый локальный
+// порт, новый маппинг NAT) и продолжает ту же сессию: сервер
+// принимает смену адреса по первому пакету (connection migration).
+//
+// Состояние видно панели в SessionStats.health:
+//
+//	healthy  - трафик идёт
+//	idle     - клиент молчит дольше deadPeerInterval, путь не под подозрением
+//	degraded - ошибки отправки или проверки без ответа
+//
+// ====================================================================
+
+// defaultBlackholeThreshold - признаков подряд до отметки degraded
+const defaultBlackholeThreshold = 3
+
+// SessionHealth - состояние пути сессии
+type SessionHealth int32
+
+const (
+	// SessionHealth_HEALTHY - трафик идёт
+	SessionHealth_HEALTHY SessionHealth = 0
+
+	// SessionHealth_IDLE - клиент молчит, признаков поломки нет
+	SessionHealth_IDLE SessionHealth = 1
+
+	// SessionHealth_DEGRADED - путь похож на «чёрную дыру»
+	SessionHealth_DEGRADED SessionHealth = 2
+)
+
+// sessionHealthNames - имена состояний в JSON статистики
+var sessionHealthNames = map[SessionHealth]string{
+	SessionHealth_HEALTHY:  "healthy",
+	SessionHealth_IDLE:     "idle",
+	SessionHealth_DEGRADED: "degraded",
+}
+
+// String возвращает имя состояния
+func (s SessionHealth) String() string {
+	if name, ok := sessionHealthNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// MarshalText - в JSON состояние пишется строкой ("idle")
+func (s SessionHealth) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText разбирает имя состояния (клиенты API панели)
+func (s *SessionHealth) UnmarshalText(text []byte) error {
+	for health, name := range sessionHealthNames {
+		if name == string(text) {
+			*s = health
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown session health %q", text)
+}
+
+// blackholeThreshold возвращает порог признаков поломки пути
+func blackholeThreshold(config *Config) uint32 {
+	if config.BlackholeThreshold == 0 {
+		return defaultBlackholeThreshold
+	}
+	return config.BlackholeThreshold
+}
+
+// pathHealth - признаки поломки пути одной стороны (atomic)
+type pathHealth struct {
+	// sendErrors - ошибок отправки подряд
+	sendErrors uint32
+
+	// degraded - путь отмечен как сломанный
+	degraded int32
+}
+
+// sendFailed учитывает результат отправки
+// Возвращает true, если ошибок подряд набралось threshold
+func (p *pathHealth) sendFailed(err error, threshold uint32) bool {
+	if err == nil {
+		atomic.StoreUint32(&p.sendErrors, 0)
+		return false
+	}
+	return atomic.AddUint32(&p.sendErrors, 1) >= threshold
+}
+
+// markDegraded отмечает путь сломанным
+// Возвращает true при переходе (путь был исправен)
+func (p *pathHealth) markDegraded() bool {
+	return atomic.CompareAndSwapInt32(&p.degraded, 0, 1)
+}
+
+// recovered снимает отметку: от другой стороны пришёл пакет
+func (p *pathHealth) recovered() {
+	atomic.StoreInt32(&p.degraded, 0)
+}
+
+// isDegraded - путь отмечен как сломанный
+func (p *pathHealth) isDegraded() bool {
+	return atomic.LoadInt32(&p.degraded) == 1
+}
+
+// health возвращает состояние сессии на момент now
+// Вызывается под session.mu (читает LastActiveAt)
+func (s *Session) health(now time.Time) SessionHealth {
+	if s.path.isDegraded() {
+		return SessionHealth_DEGRADED
+	}
+	if s.idleAfter > 0 && now.Sub(s.LastActiveAt) >= s.idleAfter {
+		return SessionHealth_IDLE
+	}
+	return SessionHealth_HEALTHY
+}
+
+// sessionDegraded учитывает переход сессии в degraded
+func (h *Hub) sessionDegraded(session *Session) {
+	logf(log.Severity_Info, "%s path to client degraded", sessionTag(session.ID))
+	atomic.AddUint64(&h.degradedPaths, 1)
+	atomic.AddUint64(&metrics.server.degradedPaths, 1)
+}
+
+// GetDegradedPaths возвращает число переходов сессий в degraded
+func (h *Hub) GetDegradedPaths() uint64 {
+	return atomic.LoadUint64(&h.degradedPaths)
+}
+
+// socket возвращает текущий сокет клиентского соединения
+func (c *GameTunnelClientConn) socket() *dscpMarker {
+	return c.sock.Load()
+}
+
+// writePrimary отправляет датаграмму основным путём и учитывает ошибки
+func (c *GameTunnelClientConn) writePrimary(b []byte, level PriorityLevel) (int, error) {
+	if c.multipath != nil {
+		atomic.AddUint64(&c.multipath.sent[pathPrimary], 1)
+	}
+	start := latencyStart()
+	n, err := c.socket().Write(b, level)
+	metrics.clientLatency.since(latencyWrite, level, start)
+	if err != nil {
+		sendErrorLog.logf(log.Severity_Warning, "%s send failed: %v", sessionTag(c.session().ConnectionID), err)
+	}
+	if c.path.sendFailed(err, blackholeThreshold(c.config)) {
+		c.checkPath()
+	}
+	return n, err
+}
+
+// pathRecovered - от сервера пришёл пакет, путь исправен
+func (c *GameTunnelClientConn) pathRecovered() {
+	atomic.StoreInt64(&c.lastRecvAt, time.Now().UnixNano())
+	atomic.StoreUint32(&c.keepAlivesMissed, 0)
+	c.path.recovered()
+}
+
+// checkPath переоткрывает сокет, если признаков поломки набралось
+// blackholeThreshold
+func (c *GameTunnelClientConn) checkPath() {
+	threshold := blackholeThreshold(c.config)
+	if atomic.LoadUint32(&c.path.sendErrors) < threshold &&
+		atomic.LoadUint32(&c.keepAlivesMissed) < threshold {
+		return
+	}
+	if c.path.markDegraded() {
+		atomic.AddUint64(&metrics.client.degradedPaths, 1)
+		logf(log.Severity_Info, "%s path to server degraded, rebinding socket", sessionTag(c.session().ConnectionID))
+		c.rebind()
+		return
+	}
+
+	// Путь не ожил и после rebind: сервер потерял сессию или
+	// недоступен - заводим новую (reconnect.go), иначе снова rebind
+	if c.startReconnect() {
+		return
+	}
+	c.rebind()
+}
+
+// rebind открывает новый сокет к серверу и продолжает сессию с него
+// Новый локальный порт - новый маппинг NAT; сервер переносит сессию
+// на новый адрес по первому пакету. Отметка degraded остаётся до
+// ответа сервера
+func (c *GameTunnelClientConn) rebind() {
+	c.pathMu.Lock()
+	if atomic.LoadInt32(&c.closed) == 1 {
+		c.pathMu.Unlock()
+		return
+	}
+
+	// Другая горутина уже переоткрыла сокет по тем же признакам
+	threshold := blackholeThreshold(c.config)
+	if atomic.LoadUint32(&c.path.sendErrors) < threshold &&
+		atomic.LoadUint32(&c.keepAlivesMissed) < threshold {
+		c.pathMu.Unlock()
+		return
+	}
+
+	// Счётчики обнуляются и при неудаче: следующая попытка - после
+	// очередных threshold признаков, а не на каждой отправке
+	atomic.StoreUint32(&c.path.sendErrors, 0)
+	atomic.StoreUint32(&c.keepAlivesMissed, 0)
+	atomic.StoreInt64(&c.keepAliveSentAt, 0)
+
+	old, err := c.swapSocketLocked()
+	c.pathMu.Unlock()
+	if err != nil {
+		// Сети нет совсем (режим полёта) - ждём следующих признаков
+		return
+	}
+	c.socketMoved(old)
+}
+
+// swapSocketLocked открывает новый сокет к серверу и делает его текущим
+// Вызывается под pathMu, возвращает прежний сокет
+func (c *GameTunnelClientConn) swapSocketLocked() (*dscpMarker, error) {
+	conn, err := c.dialServerSocket(c.session().serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	return c.sock.Swap(newDSCPMarker(conn, c.config)), nil
+}
+
+// socketMoved закрывает прежний сокет и проверяет новый путь
+// PATH_CHALLENGE (roaming.go) - он же переносит сессию на сервере
+func (c *GameTunnelClientConn) socketMoved(old *dscpMarker) {
+	from := old.conn.LocalAddr().String()
+	old.conn.Close()
+	atomic.AddUint64(&c.rebinds, 1)
+	atomic.AddUint64(&metrics.client.migrations, 1)
+
+	// Для клиента from - прежний локальный адрес
+	if !c.observers.empty() {
+		info := c.info()
+		c.observers.each(func(o SessionObserver) { o.SessionMigrated(info, from) })
+	}
+	// Новый адрес сервер узнаёт из полного заголовка (compact.go)
+	c.session().compact.resync()
+	// Новая сеть - другой NAT (nattune.go)
+	c.natTune.reset()
+	c.validatePath()
+}
+
+// Health возвращает состояние пути до сервера
+// idle - сервер молчит дольше deadPeerInterval
+func (c *GameTunnelClientConn) Health() SessionHealth {
+	if c.path.isDegraded() {
+		return SessionHealth_DEGRADED
+	}
+	idleAfter, _ := deadPeerTiming(c.tunedConfig().config)
+	if time.Since(time.Unix(0, atomic.LoadInt64(&c.lastRecvAt))) >= idleAfter {
+		return SessionHealth_IDLE
+	}
+	return SessionHealth_HEALTHY
+}
+
+// GetRebinds возвращает число переоткрытий сокета: из-за поломки
+// пути или смены сети
+func (c *GameTunnelClientConn) GetRebinds() uint64 {
+	return atomic.LoadUint64(&c.rebinds)
+}
@@ -0,0 +1,136 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionHealthStates(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "health"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+
+	// Клиента за адресом нет: probe уходят в пустоту
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	keys, _ := DeriveSessionKeys([32]byte{4}, config.Key, false)
+	session := h.newSession([]byte{4, 4, 4, 4, 4, 4, 4, 4}, sink.LocalAddr().(*net.UDPAddr), keys)
+	h.sessions.put(session)
+
+	if got := session.GetStats().Health; got != SessionHealth_HEALTHY {
+		t.Fatalf("Fresh session: %s", got)
+	}
+
+	// Молчание без признаков поломки - idle, а не degraded
+	idleFor(session, time.Hour)
+	h.probeIdleSessions(time.Now())
+	if got := session.GetStats().Health; got != SessionHealth_IDLE {
+		t.Fatalf("Silent session after first probe: %s", got)
+	}
+
+	// Probe остался без ответа
+	h.probeIdleSessions(time.Now())
+	if got := session.GetStats().Health; got != SessionHealth_DEGRADED {
+		t.Fatalf("Unanswered probe: %s", got)
+	}
+	stats := h.GetStats()
+	if stats.DegradedPaths != 1 || stats.SessionsByHealth["degraded"] != 1 {
+		t.Errorf("degradedPaths=%d byHealth=%v", stats.DegradedPaths, stats.SessionsByHealth)
+	}
+
+	// Пакет клиента снимает отметку
+	data, _ := NewKeepAlivePacket(session.ID, 1).Marshal(config)
+	wrapped, _ := h.obfs.Wrap(data)
+	if _, _, err := h.RoutePacket(wrapped, sink.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	if got := session.GetStats().Health; got != SessionHealth_HEALTHY {
+		t.Errorf("After client packet: %s", got)
+	}
+
+	// В JSON панели состояние - строка
+	encoded, _ := json.Marshal(session.GetStats())
+	if !strings.Contains(string(encoded), `"health":"healthy"`) {
+		t.Errorf("JSON: %s", encoded)
+	}
+}
+
+func TestSessionHealthSendErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.BlackholeThreshold = 2
+	l, _ := startTestListener(t, config)
+	h := l.hub
+
+	// Адрес, на который отправка невозможна
+	broken := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 0}
+	session := h.newSession([]byte{6, 6, 6, 6, 6, 6, 6, 6}, broken, nil)
+	h.sessions.put(session)
+
+	h.writeToSession(session, []byte{1}, PriorityHigh)
+	if session.path.isDegraded() {
+		t.Fatal("Degraded below threshold")
+	}
+	h.writeToSession(session, []byte{1}, PriorityHigh)
+	if !session.path.isDegraded() || h.GetDegradedPaths() != 1 {
+		t.Fatalf("degraded=%v paths=%d", session.path.isDegraded(), h.GetDegradedPaths())
+	}
+}
+
+func TestClientRebindsBrokenSocket(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "health"
+	l, accepted := startTestListener(t, config)
+	serverObs := &recordingObserver{}
+	l.AddSessionObserver(serverObs)
+
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+	clientObs := &recordingObserver{}
+	client.AddSessionObserver(clientObs)
+
+	client.Write([]byte("before"))
+	readWithTimeout(t, server, 6)
+	oldAddr := client.LocalAddr().String()
+
+	// Сокет клиента умер (смена сети): отправки падают с ошибкой
+	client.socket().conn.Close()
+	for i := 0; i < defaultBlackholeThreshold; i++ {
+		client.sendSealedControl(0x05, []byte("x"))
+	}
+	if client.GetRebinds() != 1 {
+		t.Fatalf("rebinds=%d, want 1", client.GetRebinds())
+	}
+	clientObs.waitEvents(t, "migrated "+oldAddr+" -> "+client.RemoteAddr().String())
+
+	// Новый путь подтверждён ответом сервера на PATH_CHALLENGE
+	waitPathValidated(t, client)
+	if client.Health() != SessionHealth_HEALTHY {
+		t.Errorf("Health after path validation: %s", client.Health())
+	}
+
+	// Та же сессия продолжается с нового адреса в обе стороны
+	client.Write([]byte("after"))
+	if got := readWithTimeout(t, server, 5); string(got) != "after" {
+		t.Fatalf("Server got %q", got)
+	}
+	serverObs.waitEvents(t, "created", "authenticated",
+		fmt.Sprintf("migrated %s -> %s", oldAddr, client.LocalAddr()))
+
+	server.Write([]byte("reply"))
+	if got := readWithTimeout(t, client, 5); string(got) != "reply" {
+		t.Fatalf("Client got %q", got)
+	}
+	if client.Health() != SessionHealth_HEALTHY {
+		t.Errorf("Health after server reply: %s", client.Health())
+	}
+}
@@ -1,13 +1,21 @@
 package gametunnel
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/xtls/xray-core/common/log"
+	xnet "github.com/xtls/xray-core/common/net"
 )
 
+// errDecrypt - пакет сессии не расшифрован (в журнал уже записано)
+var errDecrypt = errors.New("decrypt")
+
 // ====================================================================
 // Hub - менеджер сессий GameTunnel
 // ====================================================================
@@ -58,6 +66,13 @@ type Session struct {
 	// LocalKeyPair - локальная пара ключей для хэндшейка
 	LocalKeyPair *KeyPair
 
+	// peerPublicKey - публичный ключ из Client Hello, создавшего сессию
+	// Повтор хэндшейка получает Server Hello только с этим же ключом
+	peerPublicKey [Curve25519KeySize]byte
+
+	// helloPSK - PSK сессии, им подписывается Server Hello (crypto.go)
+	helloPSK string
+
 	// SendPacketNum - счётчик исходящих пакетов (atomic)
 	SendPacketNum uint32
 
@@ -88,9 +103,134 @@ type Session struct {
 	// Streams - активные мультиплексированные потоки
 	Streams map[uint16]*Stream
 
-	// inbound - канал для входящих расшифрованных данных
-	// xray-core читает из этого канала
-	inbound chan []byte
+	// limiter - лимит скорости отправки сессии (nil = без ограничений)
+	limiter *tokenBucket
+
+	// limiterPinned - лимит задан SetSessionRateLimit, перезагрузка
+	// конфига его не трогает
+	limiterPinned bool
+
+	// User - пользователь сессии для учёта трафика ("" = не задан)
+	// usage - счётчики и квота (пользователя или собственные, quota.go)
+	// userBound - пользователь определён хэндшейком (atomic, 0/1,
+	// users.go)
+	User      string
+	usage     *userUsage
+	userBound int32
+
+	// InboundTag - тег inbound-а xray, принявшего хэндшейк
+	// policy - его политика (nil - настройки хаба), policyConfig -
+	// конфиг с её padding (policy.go); не меняются после регистрации
+	InboundTag   string
+	policy       *InboundPolicy
+	policyConfig atomic.Pointer[policyConfig]
+
+	// paddingVariant - конфиг с уменьшенным padding (padding.go)
+	paddingVariant atomic.Pointer[paddingVariant]
+
+	// quotaHit - к сессии применена реакция на исчерпание квоты (atomic)
+	// unthrottled / unthrottledPinned - лимит до снижения скорости
+	quotaHit          int32
+	unthrottled       *tokenBucket
+	unthrottledPinned bool
+
+	// probeToken - токен последнего probe (deadpeer.go)
+	// probesMissed - probe подряд без ответа (atomic)
+	probeToken   [probeTokenSize]byte
+	probesMissed uint32
+
+	// quality - замеры канала по Ping сервера (quality.go)
+	// pingRecv - PacketsRecv на прошлой проверке; только deadPeerLoop
+	quality  linkQuality
+	pingRecv uint64
+
+	// initialID - ID из Client Hello, если ID сессии выдал сервер
+	// aliasKey - ключ сессии в Hub.aliases (connid.go)
+	initialID []byte
+	aliasKey  string
+
+	// path - признаки поломки пути до клиента (health.go)
+	// idleAfter - молчание, после которого сессия считается idle
+	path      pathHealth
+	idleAfter time.Duration
+
+	// keepAliveIdle - интервал keep-alive, о котором сообщил клиент с
+	// keepAliveTune (наносекунды, atomic; 0 - по конфигу), natProbe -
+	// отложенный ответ на его пробу NAT (nattune.go)
+	keepAliveIdle int64
+	natProbe      atomic.Pointer[time.Timer]
+
+	// clusterEpoch - поколение записи сессии в кластере (atomic),
+	// clusterActive / clusterSent - LastActiveAt и счётчик отправки
+	// последней публикации (только clusterLoop, cluster.go)
+	clusterEpoch  uint64
+	clusterActive int64
+	clusterSent   uint32
+
+	// pathToken - токен PATH_CHALLENGE к новому адресу (roaming.go)
+	// validatedAddr / validatedSock - последний проверенный путь, на
+	// который сессия вернётся без ответа (nil - проверка не идёт)
+	pathToken     [pathTokenSize]byte
+	validatedAddr *net.UDPAddr
+	validatedSock *dscpMarker
+
+	// altPaths - дополнительные адреса клиента (multipath.go)
+	// primarySeen - время последнего пакета с RemoteAddr (UnixNano, atomic)
+	altPaths    []*sessionPath
+	primarySeen int64
+
+	// streams - потоки общей сессии (nil - обычная сессия, mux.go)
+	// streamAccept - потоки приложения, ждущие AcceptStream (nil -
+	// потоки не согласованы, streams.go)
+	streams      *streamMux
+	streamAccept chan *muxStream
+
+	// earlyKeys - ранние ключи 0-RTT до первого пакета на обычных
+	// (zerortt.go)
+	// resumeRequested - клиент просил ключ возобновления
+	// earlyAccepted - ранние данные приняты (ответ в Server Hello)
+	earlyKeys       atomic.Pointer[SessionKeys]
+	resumeRequested bool
+	earlyAccepted   bool
+
+	// authenticated - от клиента пришёл расшифрованный пакет (atomic,
+	// observer.go)
+	authenticated int32
+
+	// flow - характеристики исходящего потока для Classifier
+	flow *flowTracker
+
+	// compact - контекст сжатия заголовков (nil - не согласовано,
+	// compact.go)
+	compact *compactState
+
+	// timestamps - метки времени в DATA (nil - не согласованы,
+	// timestamps.go)
+	timestamps *delayTrend
+
+	// dataAD - готовые AD пакетов данных (packet.go)
+	dataAD atomic.Pointer[dataAD]
+
+	// queue - очередь исходящих пакетов сессии
+	queue *PriorityQueue
+
+	// scheduled - сессия стоит в круге отправки хаба (atomic)
+	scheduled int32
+
+	// ownSender - очередь разбирает своя горутина сессии (atomic,
+	// sender.go), а не sendLoop хаба
+	ownSender int32
+
+	// ip - IP клиента при хэндшейке (ключ лимитов ipGuard)
+	ip string
+
+	// sock - сокет, через который клиент писал последним: ответы
+	// уходят с того же адреса (multilisten.go). Под mu
+	sock *dscpMarker
+
+	// inbound - очередь входящих расшифрованных данных (inbound.go)
+	// xray-core читает из неё
+	inbound *inboundRing
 
 	// closed - флаг закрытия
 	closed int32
@@ -107,6 +247,13 @@ type Stream struct {
 	// 0 = высший (игры), 1 = средний (веб), 2 = низкий (загрузки)
 	Priority uint8
 
+	// Pinned - приоритет задан явно (SetPriority или подсказка xray)
+	// Пакеты закреплённого потока не классифицируются по размеру
+	Pinned bool
+
+	// Duplicate - High-пакеты потока уходят дважды (duplicate.go)
+	Duplicate bool
+
 	// BytesSent - отправлено байт в этом потоке
 	BytesSent uint64
 
@@ -120,110 +267,348 @@ type Stream struct {
 // Hub - менеджер всех сессий
 type Hub struct {
 	// sessions - карта Connection ID → Session
-	// Ключ - hex-строка от Connection ID для быстрого поиска
-	sessions map[string]*Session
+	// Ключ - байты Connection ID, карта шардирована (session_map.go)
+	sessions *sessionMap
 
-	// config - конфигурация транспорта
-	config *Config
+	// config - конфигурация транспорта (*Config)
+	// Перезагрузка подменяет указатель целиком (copy-on-write, reload.go),
+	// поэтому функция читает getConfig() один раз и работает со снимком
+	config atomic.Pointer[Config]
 
 	// conn - UDP-сокет для отправки/получения
-	conn *net.UDPConn
+	conn PacketConn
 
 	// obfs - обфускатор трафика (Wrap на выход, Unwrap на вход)
 	obfs Obfuscator
 
+	// dscp - отправка с DSCP-маркировкой по приоритету
+	// Основной сокет; сессии отвечают через свой Session.sock
+	dscp *dscpMarker
+
+	// xstats - счётчики stats xray (nil - выключены, xraystats.go)
+	xstats *xrayStats
+
 	// onNewSession - callback при создании новой сессии
 	// Вызывается после успешного хэндшейка
 	onNewSession func(*Session)
 
+	// onNewStream - callback при открытии потока общей сессии (mux.go)
+	// false - соединение не принято, поток закрывается
+	onNewStream func(*Session, *muxStream) bool
+
+	// observers - внешние наблюдатели событий сессий (observer.go)
+	observers sessionObservers
+
 	// cleanupInterval - интервал очистки мёртвых сессий
 	cleanupInterval time.Duration
 
-	// sessionTimeout - таймаут неактивной сессии
-	sessionTimeout time.Duration
-
 	// stats
-	totalSessions   uint64
-	activeSessions  int32
+	totalSessions  uint64
+	activeSessions int32
+
+	// decrypt - пул расшифровки входящих (nil = в receiveLoop,
+	// decrypt_pool.go)
+	decrypt *decryptPool
+
+	// encrypt - пул шифрования исходящих (nil = в горутине писателя,
+	// encrypt_pool.go)
+	encrypt *encryptPool
+
+	// senders - горутины отправки сессий (sender.go)
+	// Ждём после закрытия сессий в Stop: горутина выходит по
+	// закрытию очереди
+	senders sync.WaitGroup
+
+	// sendQueue - круг сессий с исходящими пакетами
+	// SendToSession только ставит пакет в очередь сессии, на провод
+	// пакеты выводит sendLoop, обходя сессии по кругу
+	sendQueue *sessionRoundRobin
+
+	// bandwidth - оценка исходящей пропускной способности
+	bandwidth *BandwidthEstimator
+
+	// pacer - сглаживание отправки Low/Medium (nil = выключено)
+	pacer *pacer
+
+	// padding - уровень padding по загрузке канала (padding.go)
+	padding *paddingGovernor
+
+	// classLimiters - потолки скорости классов приоритета по всем сессиям
+	// Подменяются целиком при перезагрузке конфига
+	classLimiters atomic.Pointer[[PriorityLevels]*tokenBucket]
+
+	// classifier - стратегия классификации (classifierHolder)
+	classifier atomic.Value
+
+	// policy - выбор политик новых сессий (policyHolder, policy.go)
+	policy atomic.Value
+
+	// packetFilter - фильтр датаграмм до маршрутизации
+	// (packetFilterHolder, filter.go)
+	packetFilter atomic.Value
+
+	// probeLimit - проверок без ответа до удаления сессии (deadpeer.go)
+	// deadPeers - сессий удалено как мёртвые
+	probeLimit uint32
+	deadPeers  uint64
+
+	// degradedPaths - переходов сессий в degraded (health.go)
+	degradedPaths uint64
+
+	// migrationsReverted - переносов сессий, отменённых проверкой
+	// пути (roaming.go)
+	migrationsReverted uint64
+
+	// aliases - сессии по ID из Client Hello (issueConnectionIds)
+	// idCollisions - совпадений Connection ID (connid.go)
+	aliases      *helloAliases
+	idCollisions uint64
+
+	// compact - сессии со сжатием заголовков по адресу и номеру
+	// контекста (compact.go)
+	compact *compactTable
+
+	// puzzle - нагрузка хэндшейками и ключ токенов задач (puzzle.go)
+	puzzle *puzzleGuard
+
+	// resumption - ключ возобновления для 0-RTT (nil - 0-RTT
+	// недоступен, zerortt.go)
+	resumption *resumption
+
+	// shed / evicted - хэндшейков отклонено и сессий вытеснено
+	// лимитом сессий (overload.go)
+	shed    uint64
+	evicted uint64
+
+	// counters - счётчики этого хаба для HubStats (hub_stats.go)
+	// Каждый пишется вместе с процессным metrics.server
+	counters sideCounters
+
+	// quotas - учёт трафика пользователей (quota.go)
+	// usageCallback - получатель учёта (*UsageCallback)
+	quotas        *quotaTracker
+	usageCallback atomic.Pointer[UsageCallback]
+
+	// reloadMu - сериализует перезагрузки конфига (reload.go)
+	reloadMu sync.Mutex
+
+	// rateLimited - пакетов отброшено лимитами скорости
+	rateLimited uint64
+
+	// sendBatches - пачек, отправленных одним sendmmsg (batch.go)
+	sendBatches uint64
+
+	// recvBatches - пачек больше одной датаграммы, принятых одним
+	// recvmmsg (readbatch.go)
+	recvBatches uint64
+
+	// ipGuard - лимиты сессий и хэндшейков на IP (nil = без лимитов)
+	ipGuard *ipGuard
+
+	// ipFilter - allow/deny фильтр источников (nil = без фильтра)
+	ipFilter atomic.Pointer[ipFilter]
+
+	// users - пользователи с личными ключами (nil = без них, users.go)
+	users atomic.Pointer[userTable]
 
-	// priorityQueue - очередь с приоритизацией исходящих пакетов
-	// Используется inline: при отправке low-priority пакета
-	// сначала отправляются накопленные high-priority
-	priorityQueue *PriorityQueue
+	// draining - хаб в режиме drain (drain.go)
+	draining int32
+
+	// snapshots - снапшоты сессий (nil = выключены, snapshot.go)
+	snapshots *sessionSnapshotter
+
+	// cluster - общие сессии серверов кластера (nil = выключен,
+	// cluster.go)
+	cluster *hubCluster
+
+	// handedOff - сессии переданы новому процессу (upgrade.go):
+	// Stop не пишет снапшот и не сообщает о закрытии
+	handedOff int32
+
+	// audit - журнал аудита сессий (nil = выключен, audit.go)
+	audit *auditLog
+
+	// ctx / cancel - время жизни фоновых горутин хаба
+	// wg - учёт горутин: Stop возвращается после их завершения
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 
-	mu     sync.RWMutex
 	closed int32
 }
 
 // NewHub создаёт новый менеджер сессий
-func NewHub(config *Config, conn *net.UDPConn) *Hub {
+func NewHub(config *Config, conn PacketConn) *Hub {
 	h := &Hub{
-		sessions:        make(map[string]*Session),
-		config:          config,
+		sessions:        newSessionMap(),
 		conn:            conn,
 		obfs:            NewObfuscator(config.Obfuscation, config),
-		priorityQueue:   NewPriorityQueue(config.Priority),
+		dscp:            newDSCPMarker(conn, config),
+		sendQueue:       newSessionRoundRobin(),
+		ipGuard:         newIPGuard(config),
+		quotas:          newQuotaTracker(),
+		bandwidth:       NewBandwidthEstimator(),
+		decrypt:         newDecryptPool(decryptWorkerCount(config)),
+		encrypt:         newEncryptPool(config.EncryptWorkers),
+		aliases:         newHelloAliases(),
+		compact:         newCompactTable(),
+		puzzle:          newPuzzleGuard(),
+		resumption:      newResumption(),
 		cleanupInterval: 30 * time.Second,
-		sessionTimeout:  time.Duration(config.KeepAliveInterval*3) * time.Second,
 	}
 
-	// Если keepalive отключён, ставим таймаут 5 минут
-	if config.KeepAliveInterval == 0 {
-		h.sessionTimeout = 5 * time.Minute
-	}
+	h.config.Store(config)
+	h.users.Store(newUserTable(config))
+	classLimiters := newClassLimiters(config)
+	h.classLimiters.Store(&classLimiters)
+	h.classifier.Store(classifierHolder{NewClassifier(config)})
+	h.pacer = newPacer(config, h.bandwidth)
+	h.padding = newPaddingGovernor(h.bandwidth, &metrics.server)
+	_, h.probeLimit = deadPeerTiming(config)
+	h.ctx, h.cancel = context.WithCancel(context.Background())
 
 	return h
 }
 
+// getConfig возвращает текущий снимок конфигурации
+func (h *Hub) getConfig() *Config {
+	return h.config.Load()
+}
+
+// sessionTimeout - таймаут неактивной сессии: три интервала keep-alive
+// текущего снимка, без keep-alive - 5 минут
+func (h *Hub) sessionTimeout() time.Duration {
+	interval := h.getConfig().KeepAliveInterval
+	if interval == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(interval*3) * time.Second
+}
+
 // Start запускает фоновые горутины хаба
 func (h *Hub) Start() {
+	metrics.registerHub(h)
+
 	// Горутина очистки мёртвых сессий
-	go h.cleanupLoop()
+	h.goLoop("cleanup", h.cleanupLoop)
+
+	// Горутина отправки: разбирает очереди сессий
+	h.goLoop("send", h.sendLoop)
+
+	// Горутина снапшотов сессий
+	if h.snapshots != nil {
+		h.goLoop("snapshot", h.snapshotLoop)
+	}
+
+	// Горутина публикации сессий в кластер
+	if h.cluster != nil {
+		h.goLoop("cluster", h.clusterLoop)
+	}
+
+	// Горутина сброса учёта трафика
+	h.goLoop("usage", h.usageLoop)
+
+	// Горутина проверки молчащих клиентов
+	h.goLoop("dead-peer", h.deadPeerLoop)
+
+	// Воркеры расшифровки входящих и шифрования исходящих
+	h.startDecryptWorkers()
+	h.startEncryptWorkers()
+}
+
+// goLoop запускает фоновую горутину хаба с учётом в wg
+// name - pprof-метка горутины (debug.go)
+func (h *Hub) goLoop(name string, loop func()) {
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		goLabeled("hub."+name, loop)
+	}()
 }
 
 // Stop останавливает хаб и закрывает все сессии
+// Возвращается после завершения всех фоновых горутин хаба
 func (h *Hub) Stop() {
 	if !atomic.CompareAndSwapInt32(&h.closed, 0, 1) {
 		return
 	}
 
-	h.mu.Lock()
-	defer h.mu.Unlock()
+	h.cancel()
+	h.sendQueue.close()
+	h.wg.Wait()
+	metrics.unregisterHub(h)
+
+	// Живые сессии остаются другим серверам кластера (cluster.go)
+	h.leaveCluster()
+
+	sessions := h.sessions.drain()
+	h.flushUsage()
+
+	// Последний снапшот: после drain клиенты уже закрыты -
+	// сохраняем пустой, иначе - живые сессии для перезапуска
+	handedOff := atomic.LoadInt32(&h.handedOff) == 1
+	if h.snapshots != nil && !handedOff {
+		if h.IsDraining() {
+			h.saveSessions(nil, true)
+		} else {
+			h.saveSessions(sessions, true)
+		}
+	}
 
-	for id, session := range h.sessions {
+	for _, session := range sessions {
 		session.Close()
-		delete(h.sessions, id)
+		if !handedOff {
+			h.notifyClosed(session, CloseReason_SERVER_SHUTDOWN)
+		}
 	}
+	h.senders.Wait()
 }
 
 // RoutePacket направляет входящий пакет в соответствующую сессию
 // Возвращает сессию и расшифрованный payload
 // Если сессия не найдена и это Handshake - создаёт новую
 func (h *Hub) RoutePacket(rawData []byte, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
+	return h.routePacket(h.dscp, rawData, remoteAddr)
+}
+
+// routePacket - RoutePacket для пакета, принятого сокетом sock
+func (h *Hub) routePacket(sock *dscpMarker, rawData []byte, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
 	// Деобфускация входящего пакета
-	data, err := h.obfs.Unwrap(rawData)
+	data, err := unwrapPacket(h.obfs, rawData)
 	if err != nil {
-		return nil, nil, fmt.Errorf("unwrap: %w", err)
+		return nil, nil, dropped(dropNotQUICLike, fmt.Errorf("unwrap: %w", err))
+	}
+	return h.routeUnwrapped(sock, data, remoteAddr)
+}
+
+// routeUnwrapped - routePacket для уже деобфусцированного пакета
+func (h *Hub) routeUnwrapped(sock *dscpMarker, data []byte, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
+	// Сжатый заголовок - восстанавливаем полный (compact.go)
+	if len(data) > 0 && isCompactPacket(data[0]) {
+		full, err := h.expandCompact(data, remoteAddr)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = full
 	}
 
 	if len(data) < MinPacketSize {
-		return nil, nil, fmt.Errorf("packet too short: %d bytes", len(data))
+		return nil, nil, dropped(dropTooShort, fmt.Errorf("packet too short: %d bytes", len(data)))
 	}
 
 	// Быстрая проверка: это GameTunnel пакет?
 	if !IsQUICLike(data[0]) {
-		return nil, nil, fmt.Errorf("not a GameTunnel packet: invalid flags 0x%02x", data[0])
+		return nil, nil, dropped(dropNotQUICLike, fmt.Errorf("not a GameTunnel packet: invalid flags 0x%02x", data[0]))
 	}
 
 	// Извлекаем Connection ID из заголовка
-	connIDLen := int(h.config.ConnectionIdLength)
+	connIDLen := int(h.getConfig().ConnectionIdLength)
 	connIDOffset := FlagsSize + VersionSize // после flags + version
 	if len(data) < connIDOffset+connIDLen {
-		return nil, nil, fmt.Errorf("packet too short for connection ID")
+		return nil, nil, dropped(dropTooShort, fmt.Errorf("packet too short for connection ID"))
 	}
 
 	connID := data[connIDOffset : connIDOffset+connIDLen]
-	connIDKey := fmt.Sprintf("%x", connID)
 
 	// Декодируем тип пакета
 	pktType, _, err := DecodeFlags(data[0])
@@ -231,53 +616,154 @@ func (h *Hub) RoutePacket(rawData []byte, remoteAddr *net.UDPAddr) (*Session, []
 		return nil, nil, fmt.Errorf("decode flags: %w", err)
 	}
 
+	// Client Hello при выдаче ID сервером несёт ID клиента, которого
+	// нет в таблице сессий (connid.go)
+	if pktType == PacketType_HANDSHAKE && h.getConfig().IssueConnectionIds {
+		return h.routeIssuedHello(sock, data, connID, remoteAddr)
+	}
+
 	// Ищем существующую сессию
-	h.mu.RLock()
-	session, exists := h.sessions[connIDKey]
-	h.mu.RUnlock()
+	session := h.sessions.get(connID)
 
 	// Если сессия не найдена
-	if !exists {
+	if session == nil {
 		if pktType == PacketType_HANDSHAKE {
-			// Новый клиент - начинаем хэндшейк
-			return h.handleNewHandshake(data, connID, remoteAddr)
+			return h.admitHandshake(sock, data, connID, remoteAddr)
 		}
-		return nil, nil, fmt.Errorf("unknown connection ID: %s", connIDKey)
+		// Сессия другого сервера кластера (cluster.go)
+		if session = h.adoptSession(sock, data, pktType, connID, remoteAddr); session == nil {
+			return nil, nil, dropped(dropUnknownConnID, fmt.Errorf("unknown connection ID: %x", connID))
+		}
+	}
+
+	// Повтор хэндшейка не трогает адрес сессии: Client Hello не
+	// аутентифицирован и не должен уводить чужую сессию
+	if pktType == PacketType_HANDSHAKE {
+		return h.handleExistingHandshake(session, data)
 	}
 
 	// Обновляем адрес клиента (поддержка connection migration)
+	// С validateMigration адрес меняет только аутентифицированный
+	// пакет, и новый путь проверяется (roaming.go)
+	validate := h.getConfig().ValidateMigration
+	now := time.Now()
+	var migratedFrom string
+	moved, onAltPath := false, false
 	session.mu.Lock()
 	if session.RemoteAddr.String() != remoteAddr.String() {
-		// Клиент сменил IP (переключение WiFi/Mobile)
-		session.RemoteAddr = remoteAddr
+		path := session.altPath(remoteAddr)
+		switch {
+		case path != nil:
+			// Дополнительный путь multipath-сессии (multipath.go)
+			path.sock = sock
+			atomic.StoreInt64(&path.lastSeen, now.UnixNano())
+			onAltPath = true
+		case pktType == PacketType_CONTROL && controlCommand(data, connIDLen) == 0x09:
+			// PATH_ADD добавляет путь, а не переносит сессию
+			onAltPath = true
+		case validate:
+			moved = true
+		default:
+			// Клиент сменил IP (переключение WiFi/Mobile)
+			migratedFrom = session.RemoteAddr.String()
+			session.RemoteAddr = remoteAddr
+		}
+	}
+	if !moved && !onAltPath {
+		// Клиент мог перейти на другой адрес сервера (port hopping)
+		session.sock = sock
+		atomic.StoreInt64(&session.primarySeen, now.UnixNano())
 	}
-	session.LastActiveAt = time.Now()
+	session.LastActiveAt = now
 	session.mu.Unlock()
+	if !moved {
+		session.path.recovered()
+	}
+
+	if migratedFrom != "" {
+		h.count(func(c *sideCounters) *uint64 { return &c.migrations }, 1)
+		h.notifyMigrated(session, migratedFrom)
+	}
 
 	// Обработка по типу пакета
+	var (
+		routed  *Session
+		payload []byte
+	)
 	switch pktType {
-	case PacketType_HANDSHAKE:
-		// Повторный хэндшейк - клиент мог потерять ответ
-		return h.handleExistingHandshake(session, data)
-
 	case PacketType_DATA:
-		return h.handleDataPacket(session, data)
+		routed, payload, err = h.handleDataPacket(session, data)
 
 	case PacketType_KEEPALIVE:
-		return h.handleKeepAlive(session, data)
+		routed, payload, err = h.handleKeepAlive(session, data)
 
 	case PacketType_CONTROL:
-		return h.handleControlPacket(session, data)
+		routed, payload, err = h.handleControlPacket(session, data, sock, remoteAddr)
 
 	default:
 		return nil, nil, fmt.Errorf("unknown packet type: %d", pktType)
 	}
+
+	if moved && err == nil && authenticatedPacket(pktType, data, connIDLen) {
+		h.migrateSession(session, sock, remoteAddr)
+	}
+	return routed, payload, err
+}
+
+// admitHandshake проверяет допуск нового клиента и начинает хэндшейк
+func (h *Hub) admitHandshake(sock *dscpMarker, data []byte, connID []byte, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
+	// В режиме drain новые сессии не принимаем
+	if h.IsDraining() {
+		h.count(func(c *sideCounters) *uint64 { return &c.handshakeFailures }, 1)
+		return nil, nil, dropped(dropRejected, fmt.Errorf("handshake rejected: server draining"))
+	}
+
+	// Фильтр и лимиты IP проверяем до ECDH - отказ ничего не стоит
+	if err := h.ipFilter.Load().check(remoteAddr.IP); err != nil {
+		h.count(func(c *sideCounters) *uint64 { return &c.handshakeFailures }, 1)
+		return nil, nil, dropped(dropRejected, fmt.Errorf("handshake rejected: %w", err))
+	}
+	// Политика inbound-а - тоже до ECDH (policy.go)
+	policy, err := h.resolvePolicy(sock, remoteAddr)
+	if err != nil {
+		h.count(func(c *sideCounters) *uint64 { return &c.handshakeFailures }, 1)
+		return nil, nil, dropped(dropRejected, fmt.Errorf("handshake rejected: %w", err))
+	}
+	// Задача хэндшейка под нагрузкой - до лимитов IP: RETRY слота
+	// не занимает (puzzle.go)
+	if err := h.checkPuzzle(sock, data, connID, remoteAddr, policy); err != nil {
+		return nil, nil, dropped(dropPuzzle, fmt.Errorf("handshake deferred: %w", err))
+	}
+	ip := remoteAddr.IP.String()
+	if err := h.ipGuard.admitHandshake(ip, time.Now()); err != nil {
+		h.count(func(c *sideCounters) *uint64 { return &c.handshakeFailures }, 1)
+		return nil, nil, dropped(dropRejected, fmt.Errorf("handshake rejected: %w", err))
+	}
+	if err := h.admitSession(sock, data, connID, remoteAddr, policy); err != nil {
+		h.ipGuard.sessionClosed(ip)
+		h.count(func(c *sideCounters) *uint64 { return &c.handshakeFailures }, 1)
+		return nil, nil, dropped(dropRejected, fmt.Errorf("handshake rejected: %w", err))
+	}
+
+	// Новый клиент - начинаем хэндшейк
+	start := time.Now()
+	session, payload, err := h.handleNewHandshake(sock, data, connID, remoteAddr, policy)
+	h.count(func(c *sideCounters) *uint64 { return &c.handshakeNanos }, uint64(time.Since(start)))
+	h.traceAccept(start, remoteAddr, session, err)
+	if err != nil && session == nil {
+		// Сессия не создана - возвращаем зарезервированный слот
+		h.ipGuard.sessionClosed(ip)
+		h.count(func(c *sideCounters) *uint64 { return &c.handshakeFailures }, 1)
+	}
+	return session, payload, err
 }
 
 // handleNewHandshake обрабатывает хэндшейк от нового клиента
-func (h *Hub) handleNewHandshake(data []byte, connID []byte, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
+// policy - политика inbound-а сокета sock (nil - настройки хаба)
+// nil-сессия в ответе - сессия не зарегистрирована (слот IP свободен)
+func (h *Hub) handleNewHandshake(sock *dscpMarker, data []byte, connID []byte, remoteAddr *net.UDPAddr, policy *InboundPolicy) (*Session, []byte, error) {
 	// Парсим пакет
-	pkt, err := Unmarshal(data, int(h.config.ConnectionIdLength))
+	pkt, err := Unmarshal(data, int(h.getConfig().ConnectionIdLength))
 	if err != nil {
 		return nil, nil, fmt.Errorf("unmarshal handshake: %w", err)
 	}
@@ -287,6 +773,7 @@ func (h *Hub) handleNewHandshake(data []byte, connID []byte, remoteAddr *net.UDP
 	if err != nil {
 		return nil, nil, fmt.Errorf("unmarshal handshake payload: %w", err)
 	}
+	clientHandshake.splitCapabilities(int(h.getConfig().ConnectionIdLength), false, helloCapsKnown)
 
 	// Генерируем серверную пару ключей
 	serverKeyPair, err := GenerateKeyPair()
@@ -300,49 +787,85 @@ func (h *Hub) handleNewHandshake(data []byte, connID []byte, remoteAddr *net.UDP
 		return nil, nil, fmt.Errorf("compute shared secret: %w", err)
 	}
 
+	// Пользователь - по ID в Client Hello (users.go)
+	user, psk, err := h.helloUser(clientHandshake, policy)
+	if err != nil {
+		return nil, nil, dropped(dropRejected, fmt.Errorf("handshake rejected: %w", err))
+	}
+
 	// Деривируем ключи сессии (isClient=false, мы сервер)
-	sessionKeys, err := DeriveSessionKeys(sharedSecret, h.config.Key, false)
+	sessionKeys, err := DeriveSessionKeys(sharedSecret, psk, false)
 	if err != nil {
 		return nil, nil, fmt.Errorf("derive session keys: %w", err)
 	}
 
 	// Создаём сессию
-	session := &Session{
-		ID:           make([]byte, len(connID)),
-		State:        SessionState_ACTIVE,
-		RemoteAddr:   remoteAddr,
-		Keys:         sessionKeys,
-		LocalKeyPair: serverKeyPair,
-		ReplayWindow: NewReplayWindow(),
-		CreatedAt:    time.Now(),
-		LastActiveAt: time.Now(),
-		Streams:      make(map[uint16]*Stream),
-		inbound:      make(chan []byte, 256),
+	session := h.newSession(connID, remoteAddr, sessionKeys)
+	session.LocalKeyPair = serverKeyPair
+	session.peerPublicKey = clientHandshake.PublicKey
+	session.helloPSK = psk
+	session.sock = sock
+	h.applyPolicy(session, sock, policy)
+	if clientHandshake.Capabilities&helloCapMux != 0 {
+		session.streams = newStreamMux()
+	} else if clientHandshake.Capabilities&helloCapStreams != 0 {
+		// Потоки приложения (streams.go)
+		session.streams = newStreamMux()
+		session.streamAccept = make(chan *muxStream, streamAcceptBacklog)
 	}
-	copy(session.ID, connID)
-
-	// Создаём поток по умолчанию (stream 0)
-	session.Streams[0] = &Stream{
-		ID:       0,
-		Priority: 0,
-		Active:   true,
+	// 0-RTT: ключ возобновления и ранние данные (zerortt.go)
+	session.resumeRequested = clientHandshake.Capabilities&helloCapResume != 0 && h.resumption != nil
+	if clientHandshake.Capabilities&helloCapEarly != 0 {
+		if early := h.resumption.acceptEarly(clientHandshake, psk, time.Now()); early != nil {
+			session.earlyKeys.Store(early)
+			session.earlyAccepted = true
+		}
+	}
+	// Сжатие заголовков: номер контекста до регистрации - сессия
+	// видна другим горутинам уже с ним (compact.go)
+	if clientHandshake.Capabilities&helloCapCompact != 0 && h.getConfig().CompactHeaders {
+		session.compact = h.compact.add(session, remoteAddr)
+	}
+	// Метки времени в DATA (timestamps.go)
+	if clientHandshake.Capabilities&helloCapTimestamps != 0 && h.getConfig().Timestamps {
+		session.timestamps = &delayTrend{}
 	}
 
-	// Регистрируем сессию
-	connIDKey := fmt.Sprintf("%x", connID)
-	h.mu.Lock()
-	h.sessions[connIDKey] = session
+	// Регистрируем сессию. Копии Client Hello могут обрабатываться
+	// параллельно (несколько сокетов приёма) - побеждает первая,
+	// остальные отвечают её Server Hello, и у клиента один набор ключей
+	var existing *Session
+	if h.getConfig().IssueConnectionIds {
+		if existing, err = h.registerIssued(session); err != nil {
+			return nil, nil, fmt.Errorf("issue connection ID: %w", err)
+		}
+	} else if registered, created := h.sessions.putIfAbsent(session); !created {
+		existing = registered
+	}
+	if existing != nil {
+		// Слот IP, занятый routePacket, уже держит победившая сессия
+		h.ipGuard.sessionClosed(session.ip)
+		h.compact.remove(session)
+		if _, _, err := h.handleExistingHandshake(existing, data); err != nil {
+			return existing, nil, err
+		}
+		return existing, nil, nil
+	}
 	atomic.AddInt32(&h.activeSessions, 1)
 	atomic.AddUint64(&h.totalSessions, 1)
-	h.mu.Unlock()
+	h.count(func(c *sideCounters) *uint64 { return &c.handshakes }, 1)
+	h.startSender(session)
+	h.bindHelloUser(session, user)
 
-	// Отправляем Server Hello
-	err = h.sendServerHello(session, serverKeyPair)
+	// Отправляем Server Hello. Сессия уже зарегистрирована: при
+	// ошибке клиент повторит Client Hello и получит её ответ
+	err = h.sendServerHello(session, serverKeyPair, len(data))
 	if err != nil {
-		return nil, nil, fmt.Errorf("send server hello: %w", err)
+		return session, nil, fmt.Errorf("send server hello: %w", err)
 	}
 
 	// Вызываем callback
+	h.notifyCreated(session)
 	if h.onNewSession != nil {
 		h.onNewSession(session)
 	}
@@ -351,10 +874,25 @@ func (h *Hub) handleNewHandshake(data []byte, connID []byte, remoteAddr *net.UDP
 }
 
 // handleExistingHandshake обрабатывает повторный хэндшейк
+// Server Hello повторяется только для того же ключа клиента:
+// ответ на чужой ключ дал бы клиенту ключи, не совпадающие с сессией
 func (h *Hub) handleExistingHandshake(session *Session, data []byte) (*Session, []byte, error) {
+	pkt, err := Unmarshal(data, int(h.getConfig().ConnectionIdLength))
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal handshake: %w", err)
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unmarshal handshake payload: %w", err)
+	}
+	if hello.PublicKey != session.peerPublicKey {
+		h.connIDCollision()
+		return nil, nil, fmt.Errorf("handshake for connection ID %x with a different key", session.ID)
+	}
+
 	// Клиент мог не получить Server Hello - отправляем повторно
 	if session.LocalKeyPair != nil {
-		err := h.sendServerHello(session, session.LocalKeyPair)
+		err := h.sendServerHello(session, session.LocalKeyPair, len(data))
 		if err != nil {
 			return nil, nil, fmt.Errorf("resend server hello: %w", err)
 		}
@@ -364,39 +902,66 @@ func (h *Hub) handleExistingHandshake(session *Session, data []byte) (*Session,
 
 // handleDataPacket обрабатывает пакет с данными
 func (h *Hub) handleDataPacket(session *Session, data []byte) (*Session, []byte, error) {
-	if session.State != SessionState_ACTIVE {
-		return nil, nil, fmt.Errorf("session not active: state=%d", session.State)
+	if !session.isActive() {
+		return nil, nil, errors.New("session not active")
 	}
 
-	// Парсим пакет
-	pkt, err := Unmarshal(data, int(h.config.ConnectionIdLength))
+	// Парсим пакет без копий: payload сразу расшифровывается в
+	// буфер пула открытого текста (bufpool.go)
+	pkt, err := UnmarshalView(data, int(h.getConfig().ConnectionIdLength))
 	if err != nil {
 		return nil, nil, fmt.Errorf("unmarshal data packet: %w", err)
 	}
 
 	// Anti-replay: проверяем что пакет не дубликат
 	if session.ReplayWindow != nil && !session.ReplayWindow.Check(pkt.PacketNumber) {
-		return nil, nil, fmt.Errorf("replay detected: packet %d", pkt.PacketNumber)
+		return nil, nil, dropped(dropReplay, fmt.Errorf("replay detected: packet %d", pkt.PacketNumber))
 	}
 
 	// Формируем additional data для AEAD (заголовок до payload)
-	connIDLen := int(h.config.ConnectionIdLength)
+	connIDLen := int(h.getConfig().ConnectionIdLength)
 	adLen := FlagsSize + VersionSize + connIDLen
 	additionalData := data[:adLen]
 
 	// Расшифровываем payload
-	plaintext, err := session.Keys.Decrypt(pkt.Payload, pkt.PacketNumber, additionalData)
+	plaintext, err := session.decrypt(pkt.Payload, pkt.PacketNumber, additionalData)
 	if err != nil {
-		return nil, nil, fmt.Errorf("decrypt: %w", err)
+		h.count(func(c *sideCounters) *uint64 { return &c.decryptFailures }, 1)
+		decryptFailureLog.logf(log.Severity_Warning, "%s packet %d not decrypted (key mismatch or corruption): %v",
+			sessionTag(session.ID), pkt.PacketNumber, err)
+		return nil, nil, fmt.Errorf("%w: %w", errDecrypt, err)
 	}
+	h.markAuthenticated(session)
+	opened, err := session.timestamps.open(plaintext, timestampNow())
+	if err != nil {
+		putPlainBuf(plaintext)
+		return nil, nil, err
+	}
+	plaintext = opened
+	tapOf(h.obfs).payload(false, session.ID, pkt.PacketNumber, plaintext)
+	h.answerKeepAliveRequest(session, data)
 
 	// Обновляем статистику
 	session.mu.Lock()
 	session.RecvPacketNum = pkt.PacketNumber
 	session.PacketsRecv++
 	session.BytesRecv += uint64(len(plaintext))
+	remoteAddr := session.RemoteAddr
 	session.mu.Unlock()
 
+	// Контекст сжатия - по последнему заголовку и адресу (compact.go)
+	if session.compact != nil {
+		session.compact.received(pkt.ConnectionID)
+		h.compact.follow(session, remoteAddr)
+	}
+	h.count(func(c *sideCounters) *uint64 { return &c.packetsRecv }, 1)
+	h.count(func(c *sideCounters) *uint64 { return &c.bytesRecv }, uint64(len(plaintext)))
+
+	if err := h.chargeQuota(session, 0, uint64(len(plaintext))); err != nil {
+		putPlainBuf(plaintext)
+		return nil, nil, err
+	}
+
 	return session, plaintext, nil
 }
 
@@ -407,7 +972,7 @@ func (h *Hub) handleKeepAlive(session *Session, data []byte) (*Session, []byte,
 	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
 	keepAlive := NewKeepAlivePacket(session.ID, pktNum)
 
-	response, err := keepAlive.Marshal(h.config)
+	response, err := keepAlive.Marshal(h.getConfig())
 	if err != nil {
 		return nil, nil, fmt.Errorf("marshal keepalive response: %w", err)
 	}
@@ -417,7 +982,7 @@ func (h *Hub) handleKeepAlive(session *Session, data []byte) (*Session, []byte,
 		return nil, nil, fmt.Errorf("wrap keepalive: %w", err)
 	}
 
-	_, err = h.conn.WriteToUDP(wrapped, session.RemoteAddr)
+	_, err = h.writeToSession(session, wrapped, PriorityHigh)
 	if err != nil {
 		return nil, nil, fmt.Errorf("send keepalive response: %w", err)
 	}
@@ -426,8 +991,9 @@ func (h *Hub) handleKeepAlive(session *Session, data []byte) (*Session, []byte,
 }
 
 // handleControlPacket обрабатывает управляющий пакет
-func (h *Hub) handleControlPacket(session *Session, data []byte) (*Session, []byte, error) {
-	pkt, err := Unmarshal(data, int(h.config.ConnectionIdLength))
+// sock и remoteAddr - путь, по которому пакет пришёл
+func (h *Hub) handleControlPacket(session *Session, data []byte, sock *dscpMarker, remoteAddr *net.UDPAddr) (*Session, []byte, error) {
+	pkt, err := Unmarshal(data, int(h.getConfig().ConnectionIdLength))
 	if err != nil {
 		return nil, nil, fmt.Errorf("unmarshal control packet: %w", err)
 	}
@@ -439,55 +1005,176 @@ func (h *Hub) handleControlPacket(session *Session, data []byte) (*Session, []by
 	}
 
 	switch pkt.Payload[0] {
-	case 0x00: // Close - закрытие сессии
+	case 0x03: // Close - клиент закрыл сессию, только с валидной подписью
+		// Открытый Close мог бы подделать любой, кто знает Connection ID
+		if _, err := h.openControl(session, pkt, data); err != nil {
+			return nil, nil, err
+		}
 		h.RemoveSession(session.ID)
 		return session, nil, nil
 
-	case 0x01: // Ping - запрос пинга
-		// Отвечаем Pong
-		pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
-		pongPayload := []byte{0x02} // Pong
-		pong := NewControlPacket(session.ID, pktNum, pongPayload)
-		response, err := pong.Marshal(h.config)
-		if err == nil {
-			wrapped, wErr := h.obfs.Wrap(response)
-			if wErr == nil {
-				h.conn.WriteToUDP(wrapped, session.RemoteAddr)
-			}
+	case 0x01: // Ping замера качества (quality.go) - Pong с тем же телом
+		// Открытый Ping не принимаем: Pong на него отдал бы замеры
+		// любому, кто знает Connection ID
+		body, err := h.openControl(session, pkt, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := h.sendSealedControl(session, 0x02, body); err != nil {
+			return nil, nil, fmt.Errorf("send pong: %w", err)
 		}
 		return session, nil, nil
 
-	case 0x02: // Pong - ответ на пинг
-		// Можно замерить RTT
+	case 0x02: // Pong - ответ на Ping сервера (quality.go)
+		body, err := h.openControl(session, pkt, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		session.quality.pongReceived(body, time.Now())
+		return session, nil, nil
+
+	case 0x05: // Ответ на probe (deadpeer.go)
+		adLen := FlagsSize + VersionSize + int(h.getConfig().ConnectionIdLength)
+		if len(data) < adLen {
+			return nil, nil, fmt.Errorf("probe ack too short")
+		}
+		if err := h.handleProbeAck(session, pkt.Payload[1:], pkt.PacketNumber, data[:adLen]); err != nil {
+			return nil, nil, err
+		}
+		return session, nil, nil
+
+	case 0x07: // PATH_CHALLENGE клиента (roaming.go)
+		if err := h.answerPathChallenge(session, pkt, data, sock, remoteAddr); err != nil {
+			return nil, nil, err
+		}
+		return session, nil, nil
+
+	case 0x08: // PATH_RESPONSE на проверку нового адреса
+		if err := h.handlePathResponse(session, pkt, data); err != nil {
+			return nil, nil, err
+		}
+		return session, nil, nil
+
+	case 0x09: // PATH_ADD - дополнительный путь клиента (multipath.go)
+		if err := h.addPath(session, pkt, data, sock, remoteAddr); err != nil {
+			return nil, nil, err
+		}
+		return session, nil, nil
+
+	case 0x0A: // STREAM_CLOSE - клиент закрыл поток общей сессии (mux.go)
+		if err := h.handleStreamClose(session, pkt, data); err != nil {
+			return nil, nil, err
+		}
+		return session, nil, nil
+
+	case 0x0B: // MTU_PROBE - проба MTU пути клиента (mtu.go)
+		if err := h.answerMTUProbe(session, pkt, data); err != nil {
+			return nil, nil, err
+		}
+		return session, nil, nil
+
+	case 0x0E: // NAT_PROBE - проба маппинга NAT клиента (nattune.go)
+		if err := h.handleNATProbe(session, pkt, data); err != nil {
+			return nil, nil, err
+		}
 		return session, nil, nil
 	}
 
 	return session, nil, nil
 }
 
+// newSession создаёт активную сессию с готовыми ключами
+// Используется хэндшейком и восстановлением из снапшота
+func (h *Hub) newSession(connID []byte, remoteAddr *net.UDPAddr, keys *SessionKeys) *Session {
+	config := h.getConfig()
+	session := &Session{
+		ID:           make([]byte, len(connID)),
+		State:        SessionState_ACTIVE,
+		RemoteAddr:   remoteAddr,
+		Keys:         keys,
+		ReplayWindow: NewReplayWindow(),
+		CreatedAt:    time.Now(),
+		LastActiveAt: time.Now(),
+		Streams:      make(map[uint16]*Stream),
+		inbound:      newInboundRing(config.InboundWait),
+		limiter:      newTokenBucket(config.SessionRateLimit, config.SessionRateBurst),
+		usage:        &userUsage{quota: config.QuotaBytes},
+		flow:         newFlowTracker(xnet.Destination{}),
+		queue:        newPriorityQueueFromConfig(config),
+		ip:           remoteAddr.IP.String(),
+		sock:         h.dscp,
+		idleAfter:    h.probeInterval(),
+		primarySeen:  time.Now().UnixNano(),
+	}
+	copy(session.ID, connID)
+
+	// Создаём поток по умолчанию (stream 0)
+	session.Streams[0] = &Stream{
+		ID:        0,
+		Priority:  0,
+		Duplicate: config.Duplicate,
+		Active:    true,
+	}
+
+	return session
+}
+
 // sendServerHello отправляет Server Hello клиенту
-func (h *Hub) sendServerHello(session *Session, keyPair *KeyPair) error {
+// helloSize - размер Client Hello: ответ не больше трёх его размеров
+func (h *Hub) sendServerHello(session *Session, keyPair *KeyPair, helloSize int) error {
 	// Формируем handshake payload с нашим публичным ключом
 	handshakePayload := NewHandshakePayload(
 		keyPair.PublicKey,
 		uint64(time.Now().Unix()),
 	)
 
+	// ID, выданный сервером, - в хвосте payload (connid.go)
+	if session.initialID != nil {
+		handshakePayload.IssuedID = session.ID
+	}
+	// Согласие на потоки в сессии (mux.go)
+	if session.streamAccept != nil {
+		handshakePayload.Capabilities = helloCapStreams
+	} else if session.streams != nil {
+		handshakePayload.Capabilities = helloCapMux
+	}
+	// Ключ возобновления и принятие ранних данных (zerortt.go)
+	if session.resumeRequested {
+		handshakePayload.Capabilities |= helloCapResume
+		handshakePayload.ResumeKey = h.resumption.keyPair.PublicKey[:]
+	}
+	if session.earlyAccepted {
+		handshakePayload.Capabilities |= helloCapEarly
+		handshakePayload.EarlyKeyID = h.resumption.id[:]
+	}
+	// Номер контекста сжатия заголовков (compact.go)
+	if session.compact != nil {
+		handshakePayload.Capabilities |= helloCapCompact
+		handshakePayload.CompactID = []byte{session.compact.id}
+	}
+	if session.timestamps != nil {
+		handshakePayload.Capabilities |= helloCapTimestamps
+	}
+
+	// Подпись ключом сессии: клиент не примет ответ без PSK
+	payload := handshakePayload.Marshal()
+	sealServerHello(payload, session.helloPSK, session.peerPublicKey)
+
 	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
-	pkt := NewHandshakePacket(session.ID, pktNum, handshakePayload.Marshal())
+	pkt := NewHandshakePacket(session.helloConnectionID(), pktNum, payload)
 
-	data, err := pkt.Marshal(h.config)
+	data, err := pkt.Marshal(h.getConfig())
 	if err != nil {
 		return fmt.Errorf("marshal server hello: %w", err)
 	}
 
-	// Обфусцируем перед отправкой
-	wrapped, err := h.obfs.Wrap(data)
+	// Обфусцируем перед отправкой: как пакет хэндшейка, а не данных
+	wrapped, err := wrapHandshake(h.obfs, data, 3*helloSize)
 	if err != nil {
 		return fmt.Errorf("wrap server hello: %w", err)
 	}
 
-	_, err = h.conn.WriteToUDP(wrapped, session.RemoteAddr)
+	_, err = h.writeToSession(session, wrapped, PriorityHigh)
 	if err != nil {
 		return fmt.Errorf("send server hello: %w", err)
 	}
@@ -497,98 +1184,340 @@ func (h *Hub) sendServerHello(session *Session, keyPair *KeyPair) error {
 
 // SendToSession отправляет зашифрованные данные клиенту
 func (h *Hub) SendToSession(session *Session, payload []byte) error {
-	if session.State != SessionState_ACTIVE {
+	return h.sendToSession(session, 0, payload, PriorityAuto)
+}
+
+// sendToSession отправляет payload потока id классом level
+// PriorityAuto - классификация по открытому тексту (classify)
+func (h *Hub) sendToSession(session *Session, id uint16, payload []byte, level PriorityLevel) error {
+	if !session.isActive() {
 		return fmt.Errorf("session not active")
 	}
 
+	if err := h.chargeQuota(session, uint64(len(payload)), 0); err != nil {
+		return err
+	}
+
+	config := h.padding.config(&session.paddingVariant, h.sessionConfig(session), session.timestamps)
 	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
+	start := latencyStart()
 
-	// Формируем additional data (заголовок)
-	tempPkt := NewDataPacket(session.ID, pktNum, nil, h.config.EnablePadding)
-	tempFlags := tempPkt.EncodeFlags()
-	connIDLen := int(h.config.ConnectionIdLength)
-	ad := make([]byte, FlagsSize+VersionSize+connIDLen)
-	ad[0] = tempFlags
-	ad[1] = byte(FakeQUICVersion >> 24)
-	ad[2] = byte(FakeQUICVersion >> 16)
-	ad[3] = byte(FakeQUICVersion >> 8)
-	ad[4] = byte(FakeQUICVersion)
-	copy(ad[FlagsSize+VersionSize:], session.ID)
-
-	// Шифруем payload
-	ciphertext, err := session.Keys.Encrypt(payload, pktNum, ad)
+	// Классифицируем по открытому тексту: от класса зависит сжатие
+	// заголовка
+	if level == PriorityAuto {
+		level = h.classify(session, payload)
+	}
+	wrapped, err := h.sealData(session, config, payload, pktNum, level)
 	if err != nil {
-		return fmt.Errorf("encrypt: %w", err)
+		return err
 	}
+	metrics.serverLatency.since(latencyEncrypt, level, start)
+	h.queueData(session, wrapped, len(payload), level, session.duplicated(id, level))
+	return nil
+}
 
-	// Собираем пакет
-	pkt := NewDataPacket(session.ID, pktNum, ciphertext, h.config.EnablePadding)
-	data, err := pkt.Marshal(h.config)
+// sealData шифрует payload в обфусцированный DATA-пакет с номером pktNum
+// Пакет класса High уходит со сжатым заголовком, если сессия его
+// согласовала (compact.go)
+func (h *Hub) sealData(session *Session, config *Config, payload []byte, pktNum uint32, level PriorityLevel) ([]byte, error) {
+	// Additional data (заголовок) - из кэша сессии, он же шаблон
+	// заголовка пакета
+	ad := loadDataAD(&session.dataAD, session.ID, config.EnablePadding)
+
+	// Шифротекст и пакет - в буферах пула (bufpool.go)
+	sealBuf, packetBuf := getPacketBuf(), getPacketBuf()
+	defer putPacketBuf(sealBuf)
+	defer putPacketBuf(packetBuf)
+
+	// Шифруем payload; метки времени - перед ним (timestamps.go)
+	tapOf(h.obfs).payload(true, session.ID, pktNum, payload)
+	plainBuf := getPacketBuf()
+	defer putPacketBuf(plainBuf)
+	plaintext := session.timestamps.seal((*plainBuf)[:0], payload, timestampNow())
+	ciphertext, err := session.Keys.EncryptTo((*sealBuf)[:0], plaintext, pktNum, ad)
 	if err != nil {
-		return fmt.Errorf("marshal data packet: %w", err)
+		return nil, fmt.Errorf("encrypt: %w", err)
+	}
+
+	// Сжатый заголовок вместо полного (compact.go)
+	if level == PriorityHigh {
+		if pnLen := session.compact.pnLen(session.dataAD.Load(), pktNum, len(ciphertext)); pnLen > 0 {
+			data := appendCompactPacket((*packetBuf)[:0], session.compact.id, ad, pktNum, pnLen, ciphertext, config)
+			wrapped, err := wrapCompact(h.obfs, data)
+			if err != nil {
+				return nil, fmt.Errorf("wrap: %w", err)
+			}
+			return detach(wrapped, data), nil
+		}
 	}
 
+	// Собираем пакет по шаблону заголовка
+	data := appendDataPacket((*packetBuf)[:0], ad, pktNum, ciphertext, config)
+
 	// Обфусцируем
 	wrapped, err := h.obfs.Wrap(data)
 	if err != nil {
-		return fmt.Errorf("wrap: %w", err)
+		return nil, fmt.Errorf("wrap: %w", err)
+	}
+	return detach(wrapped, data), nil
+}
+
+// queueData ставит готовый пакет в очередь сессии, отправит sendLoop
+// duplicate - пакет дублирующего потока (duplicate.go)
+// Переполнение очереди - потеря пакета, как и для любого UDP:
+// отброшенный пакет отправленным не считается
+func (h *Hub) queueData(session *Session, wrapped []byte, size int, level PriorityLevel, duplicate bool) {
+	if !session.queue.enqueue(wrapped, level, session, duplicate) {
+		return
+	}
+	if atomic.LoadInt32(&session.ownSender) == 0 {
+		h.sendQueue.schedule(session)
+	}
+
+	// Статистика
+	session.mu.Lock()
+	session.PacketsSent++
+	session.BytesSent += uint64(size)
+	session.mu.Unlock()
+	h.count(func(c *sideCounters) *uint64 { return &c.packetsSent }, 1)
+	h.count(func(c *sideCounters) *uint64 { return &c.bytesSent }, uint64(size))
+}
+
+// classify определяет приоритет исходящего пакета сессии
+// Закреплённый приоритет потока важнее решения Classifier
+func (h *Hub) classify(session *Session, payload []byte) PriorityLevel {
+	flow := session.flow.observe(len(payload), time.Now())
+
+	session.mu.RLock()
+	level, pinned := lookupStreamPriority(session.Streams, 0)
+	session.mu.RUnlock()
+
+	if pinned {
+		return level
 	}
+	return h.classifier.Load().(classifierHolder).Classify(&flow, payload)
+}
 
-	// Inline-приоритизация: кладём пакет в очередь,
-	// затем сразу достаём и отправляем все готовые (по приоритету).
-	// Это даёт приоритизацию без отдельной горутины:
-	// high-priority пакеты выходят из очереди раньше low-priority.
-	if h.config.Priority != PriorityMode_NONE {
-		h.priorityQueue.Enqueue(wrapped, session)
+// SetClassifier заменяет стратегию классификации исходящих пакетов
+// Безопасно вызывать на работающем Hub
+func (h *Hub) SetClassifier(c Classifier) {
+	h.classifier.Store(classifierHolder{c})
+}
 
-		// Drain: отправляем все пакеты из очереди по приоритету
+// sendLoop выводит пакеты из очередей сессий на провод
+// Сессии обслуживаются по кругу, внутри сессии High-пакеты
+// обгоняют накопленные загрузки - тяжёлая сессия не задерживает
+// игровой трафик соседей
+// Пакеты, не ждущие лимитов, уходят пачками (batch.go)
+func (h *Hub) sendLoop() {
+	batch := newSendBatch(sendBatchSize(h.getConfig()))
+	// Сессии, придержанные лимитом скорости или pacing, ждут здесь,
+	// не останавливая отправку остальным (session_queue.go)
+	var parked parkedSessions
+	wake := time.NewTimer(time.Hour)
+	wake.Stop()
+	defer wake.Stop()
+	for {
+		var wakeC <-chan time.Time
+		if d, ok := parked.until(time.Now()); ok {
+			wake.Reset(d)
+			wakeC = wake.C
+		}
+		session, open := h.sendQueue.nextUntil(wakeC)
+		if !open {
+			return
+		}
+
+		// Обходим круг без ожидания, пока в нём есть пакеты
 		for {
-			queued := h.priorityQueue.Dequeue()
-			if queued == nil {
+			// Отложенные сессии, чьё время пришло, - вне очереди
+			for p, ok := parked.popReady(time.Now()); ok; p, ok = parked.popReady(time.Now()) {
+				if atomic.LoadInt32(&p.session.closed) == 1 {
+					continue
+				}
+				// Пауза pacing уже выдержана - второй раз токены не берём
+				if p.paced {
+					h.sendAdmitted(batch, p.session, p.pkt)
+				} else {
+					h.sendPaced(batch, &parked, p.session, p.pkt)
+				}
+			}
+			if session == nil {
 				break
 			}
-			if queued.Session == nil || atomic.LoadInt32(&queued.Session.closed) == 1 {
-				continue
+			if pkt, ok := h.admitNext(session, &parked); ok {
+				h.sendPaced(batch, &parked, session, pkt)
 			}
-			queued.Session.mu.RLock()
-			addr := queued.Session.RemoteAddr
-			queued.Session.mu.RUnlock()
-			h.conn.WriteToUDP(queued.Data, addr)
-		}
-	} else {
-		_, err = h.conn.WriteToUDP(wrapped, session.RemoteAddr)
-		if err != nil {
-			return fmt.Errorf("send: %w", err)
+			session = h.sendQueue.tryNext()
 		}
+		h.flushBatch(batch)
 	}
+}
 
-	// Статистика
-	session.mu.Lock()
-	session.PacketsSent++
-	session.BytesSent += uint64(len(payload))
-	session.mu.Unlock()
+// admitNext берёт следующий пакет сессии из круга и проверяет лимиты
+// Пакет, которому лимит велит ждать, откладывается в parked вместе с
+// сессией; false - пакета нет, он отброшен или отложен
+// Допущенный пакет сессия держит вне круга: вернёт её sendAdmitted
+func (h *Hub) admitNext(session *Session, parked *parkedSessions) (*PriorityPacket, bool) {
+	// Закрытая сессия выпадает из круга вместе с очередью
+	if atomic.LoadInt32(&session.closed) == 1 {
+		return nil, false
+	}
 
-	return nil
+	pkt := h.sendQueue.take(session)
+	if pkt == nil {
+		h.sendQueue.requeue(session)
+		return nil, false
+	}
+	metrics.serverLatency.sample(pkt)
+
+	session.mu.RLock()
+	limiter := session.limiter
+	session.mu.RUnlock()
+
+	classLimiters := h.classLimiters.Load()
+	wait, ok := admitPacket(h.getConfig().RateLimitPolicy, len(pkt.Data), limiter, classLimiters[pkt.Priority])
+	if !ok {
+		atomic.AddUint64(&h.rateLimited, 1)
+		h.sendQueue.requeue(session)
+		return nil, false
+	}
+	if wait > 0 {
+		parked.park(session, pkt, time.Now().Add(wait), false)
+		return nil, false
+	}
+	return pkt, true
+}
+
+// sendPaced отправляет допущенный пакет сессии после паузы
+// сглаживания (pacing.go)
+// Пакет, которому pacer велит ждать, откладывается в parked вместе с
+// сессией: общий отправитель не спит, и High соседей не ждут
+func (h *Hub) sendPaced(batch *sendBatch, parked *parkedSessions, session *Session, pkt *PriorityPacket) {
+	if pace := h.pacer.delay(len(pkt.Data), pkt.Priority, session.timestamps); pace > 0 {
+		parked.park(session, pkt, time.Now().Add(pace), true)
+		return
+	}
+	h.sendAdmitted(batch, session, pkt)
+}
+
+// sendAdmitted добавляет пакет в пачку и возвращает сессию в круг
+func (h *Hub) sendAdmitted(batch *sendBatch, session *Session, pkt *PriorityPacket) {
+	h.addToBatch(batch, session, pkt)
+	if batch.full() {
+		h.flushBatch(batch)
+	}
+	h.sendQueue.requeue(session)
+}
+
+// writeToSession отправляет датаграмму клиенту сессии через сокет,
+// на который клиент писал последним
+func (h *Hub) writeToSession(session *Session, b []byte, level PriorityLevel) (int, error) {
+	sock, addr := session.route(b, level)
+	start := latencyStart()
+	n, err := sock.WriteToUDP(b, addr, level)
+	metrics.serverLatency.since(latencyWrite, level, start)
+	h.pathResult(session, err)
+	return n, err
+}
+
+// route выбирает сокет и адрес для датаграммы сессии
+func (s *Session) route(b []byte, level PriorityLevel) (*dscpMarker, *net.UDPAddr) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.altPaths) > 0 {
+		return s.routePaths(b, level, s.sock, s.RemoteAddr)
+	}
+	return s.sock, s.RemoteAddr
+}
+
+// pathResult учитывает результат отправки в здоровье пути сессии
+func (h *Hub) pathResult(session *Session, err error) {
+	if err != nil {
+		sendErrorLog.logf(log.Severity_Warning, "%s send failed: %v", sessionTag(session.ID), err)
+	}
+	if session.path.sendFailed(err, blackholeThreshold(h.getConfig())) && session.path.markDegraded() {
+		h.sessionDegraded(session)
+	}
+}
+
+// wrote учитывает отправленную sendLoop датаграмму
+func (h *Hub) wrote(session *Session, n int, err error) {
+	h.pathResult(session, err)
+	if err == nil {
+		h.bandwidth.RecordBytes(uint64(n))
+	}
 }
 
 // GetSession возвращает сессию по Connection ID
 func (h *Hub) GetSession(connID []byte) *Session {
-	key := fmt.Sprintf("%x", connID)
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	return h.sessions[key]
+	return h.sessions.get(connID)
 }
 
 // RemoveSession удаляет сессию
 func (h *Hub) RemoveSession(connID []byte) {
-	key := fmt.Sprintf("%x", connID)
-	h.mu.Lock()
-	if session, exists := h.sessions[key]; exists {
+	h.closeSession(connID, CloseReason_NORMAL)
+}
+
+// closeSession удаляет сессию и сообщает наблюдателям причину
+func (h *Hub) closeSession(connID []byte, reason CloseReason) {
+	if session := h.sessions.remove(connID); session != nil {
 		session.Close()
-		delete(h.sessions, key)
+		if session.aliasKey != "" {
+			h.aliases.remove(session.aliasKey, session)
+		}
+		h.compact.remove(session)
+		if reason != CloseReason_MIGRATED {
+			h.cluster.forget(session)
+		}
 		atomic.AddInt32(&h.activeSessions, -1)
+		h.ipGuard.sessionClosed(session.ip)
+		h.notifyClosed(session, reason)
+		h.traceSession(session, reason)
+	}
+}
+
+// SetSessionRateLimit задаёт лимит скорости отправки для сессии
+// rate в байт/сек, 0 - снять ограничение. Используется для
+// индивидуальных лимитов пользователей поверх значения из конфига
+func (h *Hub) SetSessionRateLimit(connID []byte, rate, burst uint64) error {
+	session := h.GetSession(connID)
+	if session == nil {
+		return fmt.Errorf("unknown connection ID: %x", connID)
+	}
+
+	session.mu.Lock()
+	session.limiter = newTokenBucket(rate, burst)
+	session.limiterPinned = true
+	session.mu.Unlock()
+	return nil
+}
+
+// GetSessionStats возвращает статистику всех активных сессий
+func (h *Hub) GetSessionStats() []SessionStats {
+	sessions := h.sessions.snapshot()
+
+	stats := make([]SessionStats, 0, len(sessions))
+	for _, session := range sessions {
+		stats = append(stats, session.GetStats())
 	}
-	h.mu.Unlock()
+	return stats
+}
+
+// GetIPGuardStats возвращает статистику лимитов на IP и активные блокировки
+func (h *Hub) GetIPGuardStats() IPGuardStats {
+	return h.ipGuard.stats(time.Now())
+}
+
+// GetIPFilterStats возвращает счётчики правил фильтра источников
+func (h *Hub) GetIPFilterStats() IPFilterStats {
+	return h.ipFilter.Load().stats()
+}
+
+// GetRateLimitedPackets возвращает количество пакетов, отброшенных лимитами
+func (h *Hub) GetRateLimitedPackets() uint64 {
+	return atomic.LoadUint64(&h.rateLimited)
 }
 
 // GetActiveSessions возвращает количество активных сессий
@@ -606,37 +1535,39 @@ func (h *Hub) cleanupLoop() {
 	ticker := time.NewTicker(h.cleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if atomic.LoadInt32(&h.closed) == 1 {
+	for {
+		select {
+		case <-h.ctx.Done():
 			return
+		case <-ticker.C:
 		}
 
 		now := time.Now()
-		var toRemove []string
+		var toRemove [][]byte
 
-		h.mu.RLock()
-		for key, session := range h.sessions {
+		for _, session := range h.sessions.snapshot() {
 			session.mu.RLock()
-			if now.Sub(session.LastActiveAt) > h.sessionTimeout {
-				toRemove = append(toRemove, key)
+			if now.Sub(session.LastActiveAt) > session.keepAliveSpan(h.sessionTimeout(), 3) {
+				toRemove = append(toRemove, session.ID)
 			}
 			session.mu.RUnlock()
 		}
-		h.mu.RUnlock()
 
 		// Удаляем мёртвые сессии
-		for _, key := range toRemove {
-			h.mu.Lock()
-			if session, exists := h.sessions[key]; exists {
-				session.Close()
-				delete(h.sessions, key)
-				atomic.AddInt32(&h.activeSessions, -1)
-			}
-			h.mu.Unlock()
+		for _, id := range toRemove {
+			h.closeSession(id, CloseReason_TIMEOUT)
 		}
 	}
 }
 
+// isActive - сессия в состоянии ACTIVE
+// State меняется под mu (Close), читать его без блокировки нельзя
+func (s *Session) isActive() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.State == SessionState_ACTIVE
+}
+
 // Close закрывает сессию
 func (s *Session) Close() {
 	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
@@ -646,34 +1577,40 @@ func (s *Session) Close() {
 	s.mu.Lock()
 	s.State = SessionState_CLOSED
 	s.mu.Unlock()
+	s.inbound.close()
+	if t := s.natProbe.Load(); t != nil {
+		t.Stop()
+	}
+
+	// Отпускаем горутину отправки сессии (sender.go)
+	if s.queue != nil {
+		s.queue.Close()
+	}
 
-	close(s.inbound)
+	// Потоки общей сессии получают EOF (mux.go)
+	if s.streams != nil {
+		s.streams.closeAll()
+	}
 }
 
 // Read читает расшифрованные данные из сессии
 // Реализует интерфейс, совместимый с xray-core
 func (s *Session) Read(buf []byte) (int, error) {
-	data, ok := <-s.inbound
+	p, ok := s.inbound.popPacket(nil)
 	if !ok {
 		return 0, fmt.Errorf("session closed")
 	}
 
-	n := copy(buf, data)
+	n := copy(buf, p.data)
+	p.release()
 	return n, nil
 }
 
 // PushInbound добавляет расшифрованные данные в очередь чтения
+// data переходит очереди без копии: вызывающий его больше не меняет
+// Полная очередь - ошибка, пакет потерян (или ждёт inboundWait)
 func (s *Session) PushInbound(data []byte) error {
-	if atomic.LoadInt32(&s.closed) == 1 {
-		return fmt.Errorf("session closed")
-	}
-
-	select {
-	case s.inbound <- data:
-		return nil
-	default:
-		return fmt.Errorf("inbound buffer full, dropping packet")
-	}
+	return s.inbound.push(data)
 }
 
 // GetStats возвращает статистику сессии
@@ -681,30 +1618,79 @@ func (s *Session) GetStats() SessionStats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	queueDepth := 0
+	var queueStats PriorityQueueStats
+	if s.queue != nil {
+		queueStats = s.queue.GetStats()
+		queueDepth = queueStats.HighQueued + queueStats.MediumQueued + queueStats.LowQueued
+	}
+
 	return SessionStats{
-		ConnectionID: fmt.Sprintf("%x", s.ID),
-		RemoteAddr:   s.RemoteAddr.String(),
-		State:        s.State,
-		BytesSent:    s.BytesSent,
-		BytesRecv:    s.BytesRecv,
-		PacketsSent:  s.PacketsSent,
-		PacketsRecv:  s.PacketsRecv,
-		CreatedAt:    s.CreatedAt,
-		LastActiveAt: s.LastActiveAt,
+		ConnectionID:  fmt.Sprintf("%x", s.ID),
+		RemoteAddr:    s.RemoteAddr.String(),
+		State:         s.State,
+		BytesSent:     s.BytesSent,
+		BytesRecv:     s.BytesRecv,
+		PacketsSent:   s.PacketsSent,
+		PacketsRecv:   s.PacketsRecv,
+		CreatedAt:     s.CreatedAt,
+		LastActiveAt:  s.LastActiveAt,
 		ActiveStreams: len(s.Streams),
+		QueueDepth:    queueDepth,
+		Health:        s.health(time.Now()),
+		Paths:         1 + len(s.altPaths),
+		Queue:         queueStats,
+		Inbound:       s.inbound.stats(),
+		Quality:       s.quality.snapshot(s.timestamps),
+		InboundTag:    s.InboundTag,
 	}
 }
 
 // SessionStats - статистика сессии для панели управления
 type SessionStats struct {
-	ConnectionID string       `json:"connectionId"`
-	RemoteAddr   string       `json:"remoteAddr"`
-	State        SessionState `json:"state"`
-	BytesSent    uint64       `json:"bytesSent"`
-	BytesRecv    uint64       `json:"bytesRecv"`
-	PacketsSent  uint64       `json:"packetsSent"`
-	PacketsRecv  uint64       `json:"packetsRecv"`
-	CreatedAt    time.Time    `json:"createdAt"`
-	LastActiveAt time.Time    `json:"lastActiveAt"`
-	ActiveStreams int         `json:"activeStreams"`
-}
\ No newline at end of file
+	ConnectionID  string       `json:"connectionId"`
+	RemoteAddr    string       `json:"remoteAddr"`
+	State         SessionState `json:"state"`
+	BytesSent     uint64       `json:"bytesSent"`
+	BytesRecv     uint64       `json:"bytesRecv"`
+	PacketsSent   uint64       `json:"packetsSent"`
+	PacketsRecv   uint64       `json:"packetsRecv"`
+	CreatedAt     time.Time    `json:"createdAt"`
+	LastActiveAt  time.Time    `json:"lastActiveAt"`
+	ActiveStreams int          `json:"activeStreams"`
+	QueueDepth    int          `json:"queueDepth"`
+
+	// Health - состояние пути: отличает простой (idle) от поломки
+	// (degraded), см. health.go
+	Health SessionHealth `json:"health"`
+
+	// Paths - адресов клиента: 1 + дополнительные пути multipath
+	Paths int `json:"paths"`
+
+	// Queue - счётчики и время ожидания очереди сессии по классам
+	Queue PriorityQueueStats `json:"queue"`
+
+	// Inbound - очередь чтения: заполнение, пик и потери (inbound.go)
+	Inbound InboundStats `json:"inbound"`
+
+	// Quality - RTT, джиттер, потери и оценка канала 0-100 по Ping
+	// сервера (quality.go); nil - замеров ещё не было
+	Quality *LinkQuality `json:"quality,omitempty"`
+
+	// InboundTag - тег inbound-а xray сессии (policy.go)
+	InboundTag string `json:"inboundTag,omitempty"`
+}
+
+// sleepContext ждёт d или отмены ctx
+// Возвращает false, если ожидание прервано отменой
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,151 @@
+package gametunnel
+
+import (
+	"encoding/hex"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Сводная статистика хаба (HubStats)
+// ====================================================================
+//
+// Счётчики metrics.server общие на процесс: в нём может работать
+// несколько inbound. Хаб ведёт такие же счётчики для себя
+// (Hub.counters) - их и отдаёт GetStats: хэндшейки, ошибки
+// расшифровки, байты и пакеты по направлениям.
+//
+// Распределения считаются по снимку таблицы сессий в момент
+// вызова: число сессий по состояниям, по состоянию пути
+// (health.go) и top-N сессий по трафику
+// (sent + recv). N по умолчанию - defaultStatsTopN, в API - ?top=N.
+//
+// JSON-теги HubStats задают формат GET /stats; состояния сессий в
+// sessionsByState - строками ("active"), а не числами.
+//
+// ====================================================================
+
+// defaultStatsTopN - размер top-N сессий в GetStats
+const defaultStatsTopN = 10
+
+// TrafficStats - трафик хаба по направлениям
+// Sent - клиентам, Recv - от клиентов (полезная нагрузка)
+type TrafficStats struct {
+	BytesSent   uint64 `json:"bytesSent"`
+	BytesRecv   uint64 `json:"bytesRecv"`
+	PacketsSent uint64 `json:"packetsSent"`
+	PacketsRecv uint64 `json:"packetsRecv"`
+}
+
+// SessionTraffic - трафик одной сессии для top-N
+type SessionTraffic struct {
+	ConnectionID string `json:"connectionId"`
+	RemoteAddr   string `json:"remoteAddr"`
+	User         string `json:"user,omitempty"`
+	TrafficStats
+}
+
+// total - байт в обе стороны
+func (s *SessionTraffic) total() uint64 {
+	return s.BytesSent + s.BytesRecv
+}
+
+// sessionStateNames - имена состояний в JSON статистики
+var sessionStateNames = map[SessionState]string{
+	SessionState_HANDSHAKE: "handshake",
+	SessionState_ACTIVE:    "active",
+	SessionState_CLOSING:   "closing",
+	SessionState_CLOSED:    "closed",
+}
+
+// String возвращает имя состояния
+func (s SessionState) String() string {
+	if name, ok := sessionStateNames[s]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// count увеличивает счётчик хаба и процессный счётчик сервера
+func (h *Hub) count(field func(*sideCounters) *uint64, n uint64) {
+	atomic.AddUint64(field(&h.counters), n)
+	atomic.AddUint64(field(&metrics.server), n)
+}
+
+// GetStatsTop возвращает сводную статистику с top-n сессий по трафику
+// n <= 0 - без списка сессий
+func (h *Hub) GetStatsTop(n int) HubStats {
+	stats := HubStats{
+		ActiveSessions:     h.GetActiveSessions(),
+		TotalSessions:      h.GetTotalSessions(),
+		RateLimited:        h.GetRateLimitedPackets(),
+		Draining:           h.IsDraining(),
+		DeadPeers:          h.GetDeadPeers(),
+		ShedSessions:       h.GetShedSessions(),
+		Evicted:            h.GetEvictedSessions(),
+		Handshakes:         atomic.LoadUint64(&h.counters.handshakes),
+		HandshakeFailures:  atomic.LoadUint64(&h.counters.handshakeFailures),
+		DecryptFailures:    atomic.LoadUint64(&h.counters.decryptFailures),
+		DecryptQueueDrops:  h.GetDecryptQueueDrops(),
+		DegradedPaths:      h.GetDegradedPaths(),
+		IDCollisions:       h.GetConnectionIDCollisions(),
+		Migrations:         atomic.LoadUint64(&h.counters.migrations),
+		MigrationsReverted: h.GetMigrationsReverted(),
+		InboundDrops:       atomic.LoadUint64(&h.counters.inboundDrops),
+		DuplicatesSent:     atomic.LoadUint64(&h.counters.duplicatesSent),
+		SendBatches:        h.GetSendBatches(),
+		RecvBatches:        h.GetRecvBatches(),
+		Traffic: TrafficStats{
+			BytesSent:   atomic.LoadUint64(&h.counters.bytesSent),
+			BytesRecv:   atomic.LoadUint64(&h.counters.bytesRecv),
+			PacketsSent: atomic.LoadUint64(&h.counters.packetsSent),
+			PacketsRecv: atomic.LoadUint64(&h.counters.packetsRecv),
+		},
+		SessionsByState:  make(map[string]int),
+		SessionsByHealth: make(map[string]int),
+		IPGuard:          h.GetIPGuardStats(),
+		IPFilter:         h.GetIPFilterStats(),
+		Puzzle:           h.puzzleStats(),
+		Padding:          h.padding.stats(h.getConfig()),
+		Cluster:          h.clusterStats(),
+		Drops:            h.counters.drops.snapshot(),
+	}
+
+	now := time.Now()
+	sessions := h.sessions.snapshot()
+	traffic := make([]SessionTraffic, 0, len(sessions))
+	for _, session := range sessions {
+		session.mu.RLock()
+		stats.SessionsByState[session.State.String()]++
+		stats.SessionsByHealth[session.health(now).String()]++
+		if n > 0 {
+			traffic = append(traffic, SessionTraffic{
+				ConnectionID: hex.EncodeToString(session.ID),
+				RemoteAddr:   session.RemoteAddr.String(),
+				User:         session.User,
+				TrafficStats: TrafficStats{
+					BytesSent:   session.BytesSent,
+					BytesRecv:   session.BytesRecv,
+					PacketsSent: session.PacketsSent,
+					PacketsRecv: session.PacketsRecv,
+				},
+			})
+		}
+		session.mu.RUnlock()
+		stats.InboundMemory += uint64(session.inbound.memory())
+	}
+	stats.MemoryUsage = h.memoryUsage(stats.InboundMemory)
+	stats.MemoryBudget = uint64(h.getConfig().MemoryBudgetMb) * 1024 * 1024
+
+	if n > 0 {
+		sort.Slice(traffic, func(i, j int) bool {
+			return traffic[i].total() > traffic[j].total()
+		})
+		if len(traffic) > n {
+			traffic = traffic[:n]
+		}
+		stats.TopSessions = traffic
+	}
+	return stats
+}
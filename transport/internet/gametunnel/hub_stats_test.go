@@ -0,0 +1,84 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHubStatsTopSessions(t *testing.T) {
+	h, first := newQuotaHub(DefaultConfig())
+	first.BytesSent, first.BytesRecv = 100, 50
+
+	second := h.newSession([]byte{8, 7, 6, 5, 4, 3, 2, 1}, first.RemoteAddr, nil)
+	second.BytesSent = 1000
+	second.State = SessionState_CLOSING
+	h.sessions.put(second)
+
+	stats := h.GetStatsTop(1)
+	if stats.SessionsByState["active"] != 1 || stats.SessionsByState["closing"] != 1 {
+		t.Errorf("SessionsByState: %v", stats.SessionsByState)
+	}
+	if len(stats.TopSessions) != 1 || stats.TopSessions[0].BytesSent != 1000 {
+		t.Fatalf("TopSessions: %+v", stats.TopSessions)
+	}
+	if stats := h.GetStatsTop(0); stats.TopSessions != nil {
+		t.Errorf("top=0: %+v", stats.TopSessions)
+	}
+}
+
+func TestHubStatsCounters(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "stats"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	client.Write([]byte("hello"))
+	readWithTimeout(t, server, 5)
+	server.Write([]byte("world!"))
+	readWithTimeout(t, client, 6)
+
+	// Пакет данных второй сессии без её ключа не расшифровывается
+	second, _ := dialTestClient(t, l, &clientConfig, accepted)
+	forged := &Packet{Type: PacketType_DATA, ConnectionID: second.session().ConnectionID, PacketNumber: 1000, Payload: make([]byte, 32)}
+	data, err := forged.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := l.hub.obfs.Wrap(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spoofer := newHelloClient(t)
+	spoofer.WriteToUDP(wrapped, l.Addr().(*net.UDPAddr))
+
+	api := NewManagementHandler(l.hub, "")
+	var stats HubStats
+	for i := 0; i < 100; i++ {
+		rec := apiRequest(t, api, "GET", "/stats?top=5", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+			t.Fatalf("GET /stats: %d %s", rec.Code, rec.Body)
+		}
+		if stats.DecryptFailures > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if stats.Handshakes != 2 || stats.DecryptFailures != 1 {
+		t.Errorf("Handshakes=%d DecryptFailures=%d", stats.Handshakes, stats.DecryptFailures)
+	}
+	if stats.Traffic.BytesRecv != 5 || stats.Traffic.BytesSent != 6 || stats.Traffic.PacketsRecv != 1 {
+		t.Errorf("Traffic: %+v", stats.Traffic)
+	}
+	if len(stats.TopSessions) != 2 || stats.SessionsByState["active"] != 2 {
+		t.Errorf("Sessions: %+v %v", stats.TopSessions, stats.SessionsByState)
+	}
+
+	if rec := apiRequest(t, api, "GET", "/stats?top=x", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid top: got %d, want 400", rec.Code)
+	}
+}
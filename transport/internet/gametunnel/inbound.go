@@ -0,0 +1,489 @@
+package gametunnel
+
+import (
+	"errors"
+	"io"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Очередь чтения сессии (inbound)
+// ====================================================================
+//
+// Расшифрованные данные ждут Read в кольце на inboundCapacity пакетов.
+// Кольцо заменило канал: переполнение больше не молчаливо - сессия
+// считает потерянные пакеты и пик заполнения (SessionStats.Inbound,
+// ClientStats), хаб и процесс - общий счётчик
+// gametunnel_inbound_drops_total.
+//
+// Слоты выделяются лениво: первый пакет берёт из пула массив на
+// inboundInitial слотов, переполнение удваивает его до
+// inboundCapacity. Вычитанное кольцо больше начального, закрытое или
+// отброшенное клиентом отдаёт массив обратно - простаивающая сессия
+// не держит ни слотов, ни пакетов.
+//
+// Расшифрованные пакеты лежат в буферах пула открытого текста
+// (bufpool.go); кольцо помнит, что буфер из пула, и возвращает его
+// туда при отбрасывании, а Read - после копирования пакета целиком.
+// Занятая очередью память (слоты и буферы) - InboundStats.Memory, по
+// хабу - HubStats.InboundMemory. Хвост пакета после неполного Read
+// держит буфер до дочитывания; ReadLease отдаёт буфер читателю до
+// Release (lease.go).
+//
+// Переполнение:
+//
+//	inboundWait = 0  - пакет отбрасывается сразу (как UDP)
+//	inboundWait = N  - запись ждёт места до N мс (не больше
+//	                   MaxInboundWait), потом отбрасывает
+//
+// Ожидание нужно надёжным потокам (TCP через туннель): короткий
+// провал читателя не рвёт поток потерей. Цена - ждёт и горутина
+// приёма: на сервере это receiveLoop сокета или воркер пула
+// расшифровки со всеми его сессиями, поэтому окно ограничено.
+//
+// Закрытое кольцо отдаёт оставшиеся данные, потом EOF - как
+// закрытый канал.
+//
+// ====================================================================
+
+const (
+	// inboundCapacity - пакетов в очереди чтения сессии
+	inboundCapacity = 256
+
+	// inboundInitial - слотов кольца при первом пакете
+	inboundInitial = 8
+
+	// inboundSlotSize - байт на слот (inboundPacket с выравниванием)
+	inboundSlotSize = 32
+
+	// MaxInboundWait - потолок ожидания места в очереди (мс)
+	MaxInboundWait = 100
+)
+
+var (
+	errInboundClosed = errors.New("session closed")
+	errInboundFull   = errors.New("inbound buffer full, dropping packet")
+)
+
+// inboundSlots - пулы массивов слотов по размеру:
+// inboundInitial << i, i = 0..5 (до inboundCapacity)
+var inboundSlots [6]sync.Pool
+
+// getSlots берёт массив на n слотов (n = inboundInitial << i)
+func getSlots(n int) *[]inboundPacket {
+	if slots, ok := inboundSlots[slotClass(n)].Get().(*[]inboundPacket); ok {
+		return slots
+	}
+	slots := make([]inboundPacket, n)
+	return &slots
+}
+
+// putSlots возвращает пустой массив слотов в пул
+func putSlots(slots *[]inboundPacket) {
+	inboundSlots[slotClass(len(*slots))].Put(slots)
+}
+
+// slotClass - номер пула для массива на n слотов
+func slotClass(n int) int {
+	return bits.Len(uint(n/inboundInitial)) - 1
+}
+
+// inboundPacket - пакет в очереди чтения
+// owned - data целиком из пула открытого текста (bufpool.go):
+// прочитанный целиком или отброшенный пакет возвращается туда
+type inboundPacket struct {
+	data  []byte
+	owned bool
+}
+
+// release возвращает буфер пакета в пул, если он оттуда
+func (p inboundPacket) release() {
+	if p.owned {
+		putPlainBuf(p.data)
+	}
+}
+
+// InboundStats - состояние очереди чтения сессии
+type InboundStats struct {
+	Queued    int    `json:"queued"`
+	HighWater int    `json:"highWater"`
+	Dropped   uint64 `json:"dropped"`
+	Memory    int    `json:"memoryBytes"`
+	Leased    int    `json:"leased,omitempty"`
+}
+
+// inboundRing - кольцо расшифрованных данных, ожидающих Read
+type inboundRing struct {
+	// mu - слоты, голова, число пакетов и их байты
+	// slots - &emptySlots, пока кольцо пустое
+	mu     sync.Mutex
+	slots  *[]inboundPacket
+	head   int
+	count  int
+	bytes  int
+	closed bool
+
+	// ready - сигнал читателям, space - сигнал ждущим места (буфер 1)
+	ready chan struct{}
+	space chan struct{}
+
+	// wait - ожидание места при переполнении (0 - отбросить сразу)
+	wait time.Duration
+
+	// highWater - пик заполнения, dropped - потеряно пакетов,
+	// leased - пакетов в аренде у читателя (atomic, lease.go)
+	highWater int64
+	dropped   uint64
+	leased    int64
+}
+
+// newInboundRing создаёт очередь чтения; wait - ожидание места, мс
+// Слоты выделяются при первом пакете
+func newInboundRing(waitMs uint32) *inboundRing {
+	return &inboundRing{
+		slots: &emptySlots,
+		ready: make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+		wait:  time.Duration(min(waitMs, MaxInboundWait)) * time.Millisecond,
+	}
+}
+
+// wake будит одного ждущего на ch
+func wake(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// push ставит data в очередь; data остаётся за вызывающим
+// errInboundFull - места нет (и не появилось за wait), пакет потерян
+func (r *inboundRing) push(data []byte) error {
+	return r.pushPacket(inboundPacket{data: data})
+}
+
+// pushOwned ставит в очередь открытый текст из пула (bufpool.go)
+// Не поставленный пакет сразу возвращается в пул
+func (r *inboundRing) pushOwned(data []byte) error {
+	p := inboundPacket{data: data, owned: true}
+	err := r.pushPacket(p)
+	if err != nil {
+		p.release()
+	}
+	return err
+}
+
+// pushPacket ставит p в очередь, при нехватке слотов растит кольцо
+func (r *inboundRing) pushPacket(p inboundPacket) error {
+	r.mu.Lock()
+	if !r.closed && r.count == inboundCapacity && r.wait > 0 {
+		r.waitSpace()
+	}
+	if r.closed {
+		r.mu.Unlock()
+		return errInboundClosed
+	}
+	if r.count == inboundCapacity {
+		r.mu.Unlock()
+		atomic.AddUint64(&r.dropped, 1)
+		return errInboundFull
+	}
+	if r.count == len(*r.slots) {
+		r.growLocked()
+	}
+	slots := *r.slots
+	slots[(r.head+r.count)%len(slots)] = p
+	r.count++
+	r.bytes += cap(p.data)
+	if int64(r.count) > atomic.LoadInt64(&r.highWater) {
+		atomic.StoreInt64(&r.highWater, int64(r.count))
+	}
+	more := r.count < inboundCapacity
+	r.mu.Unlock()
+
+	wake(r.ready)
+	if more {
+		// Место осталось - следующему ждущему писателю
+		wake(r.space)
+	}
+	return nil
+}
+
+// growLocked удваивает массив слотов (от inboundInitial до
+// inboundCapacity); вызывается под mu
+func (r *inboundRing) growLocked() {
+	old := *r.slots
+	grown := getSlots(max(2*len(old), inboundInitial))
+	for i := 0; i < r.count; i++ {
+		j := (r.head + i) % len(old)
+		(*grown)[i] = old[j]
+		old[j] = inboundPacket{}
+	}
+	if r.slots != &emptySlots {
+		putSlots(r.slots)
+	}
+	r.slots, r.head = grown, 0
+}
+
+// waitSpace ждёт освобождения места до wait; вызывается под mu
+func (r *inboundRing) waitSpace() {
+	timer := time.NewTimer(r.wait)
+	defer timer.Stop()
+	for !r.closed && r.count == inboundCapacity {
+		r.mu.Unlock()
+		select {
+		case <-r.space:
+		case <-timer.C:
+			r.mu.Lock()
+			return
+		}
+		r.mu.Lock()
+	}
+}
+
+// pop забирает данные следующего пакета, ожидая его до отмены cancel
+// Буфер переходит к вызывающему и в пул не возвращается
+// false - кольцо закрыто и вычитано или ожидание отменено
+func (r *inboundRing) pop(cancel <-chan struct{}) ([]byte, bool) {
+	p, ok := r.popPacket(cancel)
+	return p.data, ok
+}
+
+// popPacket забирает следующий пакет, ожидая его до отмены cancel
+// Вызывающий возвращает буфер в пул (release), когда он не нужен
+func (r *inboundRing) popPacket(cancel <-chan struct{}) (inboundPacket, bool) {
+	for {
+		r.mu.Lock()
+		if r.count > 0 {
+			return r.takeLocked(), true
+		}
+		if r.closed {
+			r.releaseLocked()
+			r.mu.Unlock()
+			wake(r.ready)
+			return inboundPacket{}, false
+		}
+		r.mu.Unlock()
+
+		select {
+		case <-r.ready:
+		case <-cancel:
+			return inboundPacket{}, false
+		}
+	}
+}
+
+// tryPop забирает данные следующего пакета, если он уже в очереди
+// Буфер переходит к вызывающему, как в pop
+func (r *inboundRing) tryPop() ([]byte, bool) {
+	p, ok := r.tryPopPacket()
+	return p.data, ok
+}
+
+// tryPopPacket забирает следующий пакет, если он уже в очереди
+// Буфер возвращает в пул вызывающий, как в popPacket
+func (r *inboundRing) tryPopPacket() (inboundPacket, bool) {
+	r.mu.Lock()
+	if r.count == 0 {
+		r.mu.Unlock()
+		return inboundPacket{}, false
+	}
+	return r.takeLocked(), true
+}
+
+// takeLocked снимает голову кольца и отпускает mu
+// Вычитанное кольцо больше inboundInitial отдаёт слоты в пул: всплеск
+// не держит 256 слотов у сессии, которая потом молчит
+func (r *inboundRing) takeLocked() inboundPacket {
+	slots := *r.slots
+	p := slots[r.head]
+	slots[r.head] = inboundPacket{}
+	r.head = (r.head + 1) % len(slots)
+	r.count--
+	r.bytes -= cap(p.data)
+	more := r.count > 0
+	if !more && (r.closed || len(slots) > inboundInitial) {
+		r.releaseLocked()
+	}
+	r.mu.Unlock()
+
+	if more {
+		// Для других читателей
+		wake(r.ready)
+	}
+	wake(r.space)
+	return p
+}
+
+// close закрывает кольцо: push отказывает, pop отдаёт остаток и EOF
+func (r *inboundRing) close() {
+	r.mu.Lock()
+	r.closed = true
+	r.releaseLocked()
+	r.mu.Unlock()
+	wake(r.ready)
+	wake(r.space)
+}
+
+// discard закрывает кольцо, отбрасывая непрочитанное
+func (r *inboundRing) discard() {
+	r.mu.Lock()
+	r.closed = true
+	slots := *r.slots
+	for i := 0; i < r.count; i++ {
+		j := (r.head + i) % len(slots)
+		slots[j].release()
+		slots[j] = inboundPacket{}
+	}
+	r.count, r.bytes = 0, 0
+	r.releaseLocked()
+	r.mu.Unlock()
+	wake(r.ready)
+	wake(r.space)
+}
+
+// releaseLocked возвращает слоты пустого кольца в пул; вызывается под mu
+func (r *inboundRing) releaseLocked() {
+	if r.slots == &emptySlots || r.count != 0 {
+		return
+	}
+	putSlots(r.slots)
+	r.slots, r.head = &emptySlots, 0
+}
+
+// emptySlots - слоты пустого кольца (массив в пуле или не выделен)
+var emptySlots []inboundPacket
+
+// pushInbound ставит данные в очередь чтения сессии хаба
+func (h *Hub) pushInbound(session *Session, data []byte) {
+	if errors.Is(session.inbound.pushOwned(data), errInboundFull) {
+		h.count(func(c *sideCounters) *uint64 { return &c.inboundDrops }, 1)
+		h.drop(dropQueueOverflow)
+	}
+}
+
+// pushInbound ставит данные в очередь чтения соединения
+func (c *GameTunnelClientConn) pushInbound(session *ClientSession, data []byte) {
+	if errors.Is(session.inbound.pushOwned(data), errInboundFull) {
+		atomic.AddUint64(&metrics.client.inboundDrops, 1)
+		c.drop(dropQueueOverflow)
+	}
+}
+
+// stats возвращает состояние очереди (nil - пустое)
+func (r *inboundRing) stats() InboundStats {
+	if r == nil {
+		return InboundStats{}
+	}
+	r.mu.Lock()
+	queued, memory := r.count, r.memoryLocked()
+	r.mu.Unlock()
+	return InboundStats{
+		Queued:    queued,
+		HighWater: int(atomic.LoadInt64(&r.highWater)),
+		Dropped:   atomic.LoadUint64(&r.dropped),
+		Memory:    memory,
+		Leased:    int(atomic.LoadInt64(&r.leased)),
+	}
+}
+
+// memory - байт, занятых очередью: слоты и буферы пакетов
+func (r *inboundRing) memory() int {
+	if r == nil {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.memoryLocked()
+}
+
+func (r *inboundRing) memoryLocked() int {
+	return len(*r.slots)*inboundSlotSize + r.bytes
+}
+
+// readRest - остатки пакетов, не поместившихся в буфер Read
+// Блокировка только на копирование: ожидание пакета идёт без неё,
+// поэтому конкурентные Read и Close не ждут заблокированного читателя.
+// Параллельные читатели могут сохранить по остатку сразу - остатки
+// отдаются в порядке сохранения. Остаток держит пакет целиком: буфер
+// пула возвращается, когда дочитан хвост
+type readRest struct {
+	mu    sync.Mutex
+	parts []restPart
+}
+
+// restPart - пакет с непрочитанным хвостом с off
+type restPart struct {
+	p   inboundPacket
+	off int
+}
+
+// read копирует в b начало первого остатка; false - остатков нет
+func (r *readRest) read(b []byte) (int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.parts) == 0 {
+		return 0, false
+	}
+	part := &r.parts[0]
+	n := copy(b, part.p.data[part.off:])
+	part.off += n
+	if part.off == len(part.p.data) {
+		part.p.release()
+		r.parts[0] = restPart{}
+		r.parts = r.parts[1:]
+	}
+	return n, true
+}
+
+// take забирает первый остаток: пакет и начало хвоста в нём
+func (r *readRest) take() (inboundPacket, int, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.parts) == 0 {
+		return inboundPacket{}, 0, false
+	}
+	part := r.parts[0]
+	r.parts[0] = restPart{}
+	r.parts = r.parts[1:]
+	return part.p, part.off, true
+}
+
+// keep сохраняет хвост пакета p с off; прочитанный целиком пакет
+// возвращается в пул
+func (r *readRest) keep(p inboundPacket, off int) {
+	if off >= len(p.data) {
+		p.release()
+		return
+	}
+	r.mu.Lock()
+	r.parts = append(r.parts, restPart{p: p, off: off})
+	r.mu.Unlock()
+}
+
+// readPacket копирует в b следующий пакет inbound, сохраняя хвост
+// Ожидание пакета - до закрытия кольца или cancel (тогда EOF)
+func (r *readRest) readPacket(b []byte, inbound *inboundRing, cancel <-chan struct{}) (int, error) {
+	p, ok := inbound.popPacket(cancel)
+	if !ok {
+		return 0, io.EOF
+	}
+	return r.copyPacket(b, p), nil
+}
+
+// copyPacket копирует в b пакет p, сохраняя хвост
+func (r *readRest) copyPacket(b []byte, p inboundPacket) int {
+	n := copy(b, p.data)
+	r.keep(p, n)
+	return n
+}
+
+// pending - есть непрочитанный остаток
+func (r *readRest) pending() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.parts) > 0
+}
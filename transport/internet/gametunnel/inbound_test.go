@@ -0,0 +1,389 @@
+package gametunnel
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInboundRingOverflow(t *testing.T) {
+	r := newInboundRing(0)
+	for i := 0; i < inboundCapacity; i++ {
+		if err := r.push([]byte{byte(i)}); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	if err := r.push([]byte("late")); !errors.Is(err, errInboundFull) {
+		t.Fatalf("push into a full ring: %v", err)
+	}
+	if stats := r.stats(); stats.Queued != inboundCapacity || stats.HighWater != inboundCapacity || stats.Dropped != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	// Порядок FIFO, закрытое кольцо отдаёт остаток, потом EOF
+	if data, _ := r.pop(nil); data[0] != 0 {
+		t.Fatalf("first pop %v", data)
+	}
+	r.close()
+	if err := r.push([]byte("closed")); !errors.Is(err, errInboundClosed) {
+		t.Errorf("push after close: %v", err)
+	}
+	for i := 1; i < inboundCapacity; i++ {
+		data, ok := r.pop(nil)
+		if !ok || data[0] != byte(i) {
+			t.Fatalf("pop %d: %v %v", i, data, ok)
+		}
+	}
+	if _, ok := r.pop(nil); ok {
+		t.Error("pop from a drained closed ring")
+	}
+	if r.stats().HighWater != inboundCapacity {
+		t.Error("high watermark reset")
+	}
+}
+
+func TestInboundRingWait(t *testing.T) {
+	// Ожидание ограничено MaxInboundWait
+	r := newInboundRing(1000)
+	for i := 0; i < inboundCapacity; i++ {
+		r.push([]byte{byte(i)})
+	}
+
+	// Читатель освобождает место - ждущая запись проходит
+	pushed := make(chan error, 1)
+	go func() { pushed <- r.push([]byte("waited")) }()
+	time.Sleep(20 * time.Millisecond)
+	r.pop(nil)
+	select {
+	case err := <-pushed:
+		if err != nil {
+			t.Fatalf("waiting push: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiting push did not finish")
+	}
+
+	// Без читателя запись сдаётся через wait
+	start := time.Now()
+	if err := r.push([]byte("dropped")); !errors.Is(err, errInboundFull) {
+		t.Fatalf("push: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < MaxInboundWait*time.Millisecond*9/10 || elapsed > 500*time.Millisecond {
+		t.Errorf("push gave up after %v", elapsed)
+	}
+
+	// Закрытие отпускает ждущую запись
+	go func() { pushed <- r.push([]byte("closing")) }()
+	time.Sleep(20 * time.Millisecond)
+	r.discard()
+	if err := <-pushed; !errors.Is(err, errInboundClosed) {
+		t.Errorf("push on close: %v", err)
+	}
+	if r.stats().Queued != 0 {
+		t.Error("discard left data")
+	}
+}
+
+func TestInboundRingConcurrentReaders(t *testing.T) {
+	r := newInboundRing(MaxInboundWait)
+	cancel := make(chan struct{})
+
+	const total = 2000
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				data, ok := r.pop(cancel)
+				if !ok {
+					return
+				}
+				mu.Lock()
+				seen[string(data)] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < total; i++ {
+		if err := r.push([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("push %d: %v", i, err)
+		}
+	}
+	r.close()
+	wg.Wait()
+
+	if len(seen) != total {
+		t.Errorf("readers got %d of %d packets", len(seen), total)
+	}
+
+	// Отмена прерывает ожидание пустого кольца
+	idle := newInboundRing(0)
+	close(cancel)
+	if _, ok := idle.pop(cancel); ok {
+		t.Error("pop returned data from an empty ring")
+	}
+}
+
+func TestInboundRingLazySlots(t *testing.T) {
+	r := newInboundRing(0)
+	if r.memory() != 0 {
+		t.Fatalf("new ring holds %d bytes", r.memory())
+	}
+
+	// Первый пакет - начальные слоты, рост вдвое со сдвинутой головой
+	// сохраняет порядок
+	r.push([]byte("a"))
+	if got := len(*r.slots); got != inboundInitial {
+		t.Fatalf("%d slots after first push", got)
+	}
+	r.pop(nil)
+	for i := 0; i < 3*inboundInitial; i++ {
+		r.push([]byte{byte(i)})
+	}
+	if got := len(*r.slots); got != 4*inboundInitial {
+		t.Fatalf("%d slots for %d packets", got, 3*inboundInitial)
+	}
+	if got := r.stats().Memory; got < 4*inboundInitial*inboundSlotSize {
+		t.Errorf("memory %d", got)
+	}
+	for i := 0; i < 3*inboundInitial; i++ {
+		if data, _ := r.tryPop(); data[0] != byte(i) {
+			t.Fatalf("pop %d: %v", i, data)
+		}
+	}
+
+	// Вычитанный всплеск отдаёт слоты
+	if r.memory() != 0 {
+		t.Errorf("drained ring holds %d bytes", r.memory())
+	}
+	r.push([]byte("b"))
+	r.close()
+	r.pop(nil)
+	if r.memory() != 0 {
+		t.Errorf("drained closed ring holds %d bytes", r.memory())
+	}
+}
+
+func TestPlainBufPool(t *testing.T) {
+	for _, c := range []struct{ size, capacity int }{
+		{0, 256}, {256, 256}, {257, 512}, {1000, 1024}, {1100, MaxPacketSize},
+	} {
+		if b := getPlainBuf(c.size); len(b) != 0 || cap(b) != c.capacity {
+			t.Errorf("buffer for %d bytes: len %d cap %d", c.size, len(b), c.capacity)
+		}
+	}
+	if getPlainBuf(MaxPacketSize+1) != nil {
+		t.Error("pooled buffer above MaxPacketSize")
+	}
+
+	clientKP, _ := GenerateKeyPair()
+	serverKP, _ := GenerateKeyPair()
+	secret, _ := ComputeSharedSecret(clientKP.PrivateKey, serverKP.PublicKey)
+	clientKeys, _ := DeriveSessionKeys(secret, "", true)
+	serverKeys, _ := DeriveSessionKeys(secret, "", false)
+	ad := []byte("header")
+	payload := bytes.Repeat([]byte{7}, 300)
+	sealed, _ := clientKeys.Encrypt(payload, 1, ad)
+
+	plaintext, err := decryptPlain(serverKeys, sealed, 1, ad)
+	if err != nil || !bytes.Equal(plaintext, payload) || cap(plaintext) != 512 {
+		t.Fatalf("decryptPlain: cap %d, %v", cap(plaintext), err)
+	}
+	if _, err := decryptPlain(serverKeys, sealed, 2, ad); err == nil {
+		t.Error("decrypted with a wrong packet number")
+	}
+
+	// Read целиком возвращает буфер в пул, неполный - сохраняет хвост
+	r := newInboundRing(0)
+	r.pushOwned(plaintext)
+	var rest readRest
+	b := make([]byte, 100)
+	if n, _ := rest.readPacket(b, r, nil); n != 100 {
+		t.Fatalf("read %d", n)
+	}
+	if p, off, _ := rest.take(); len(p.data)-off != 200 || !p.owned {
+		t.Errorf("tail %d bytes, owned %t", len(p.data)-off, p.owned)
+	}
+	if got := r.memory(); got != inboundInitial*inboundSlotSize {
+		t.Errorf("read ring holds %d bytes", got)
+	}
+}
+
+func TestHubMemoryStats(t *testing.T) {
+	config := DefaultConfig()
+	config.MemoryBudgetMb = 4
+	h := NewHub(config, nil)
+	session := newQueuedSession(1)
+	session.State = SessionState_ACTIVE
+	session.inbound = newInboundRing(0)
+	h.sessions.put(session)
+	atomic.AddInt32(&h.activeSessions, 1)
+
+	session.inbound.pushOwned(append(getPlainBuf(100), make([]byte, 100)...))
+	stats := h.GetStats()
+	if want := uint64(inboundInitial*inboundSlotSize + 256); stats.InboundMemory != want {
+		t.Errorf("inbound memory %d, want %d", stats.InboundMemory, want)
+	}
+	if stats.MemoryUsage != sessionIdleMemory+stats.InboundMemory || stats.MemoryBudget != 4<<20 {
+		t.Errorf("usage %d, budget %d", stats.MemoryUsage, stats.MemoryBudget)
+	}
+}
+
+func TestInboundDropsCounted(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "inbound"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+	defer client.Close()
+
+	// Клиент не читает: очередь заполняется, остальное теряется
+	for i := 0; i < inboundCapacity*2; i++ {
+		server.Write([]byte("state"))
+		if i%64 == 0 {
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for client.GetStats().InboundDrops == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	stats := client.GetStats()
+	if stats.InboundDrops == 0 || stats.InboundPeak != inboundCapacity {
+		t.Errorf("client drops %d, high watermark %d", stats.InboundDrops, stats.InboundPeak)
+	}
+	if got := readWithTimeout(t, client, 5); string(got) != "state" {
+		t.Errorf("Client got %q", got)
+	}
+}
+
+func TestReadRest(t *testing.T) {
+	var rest readRest
+	if _, ok := rest.read(make([]byte, 4)); ok {
+		t.Fatal("read from empty rest")
+	}
+
+	// Остатки отдаются по порядку, хвост первого - раньше второго
+	rest.keep(inboundPacket{data: []byte("abcdef")}, 0)
+	rest.keep(inboundPacket{}, 0)
+	rest.keep(inboundPacket{data: []byte("xgh")}, 1)
+	b := make([]byte, 4)
+	var got []byte
+	for {
+		n, ok := rest.read(b)
+		if !ok {
+			break
+		}
+		got = append(got, b[:n]...)
+	}
+	if string(got) != "abcdefgh" {
+		t.Errorf("rest read %q", got)
+	}
+
+	rest.keep(inboundPacket{data: []byte("whole")}, 0)
+	if p, off, ok := rest.take(); !ok || string(p.data[off:]) != "whole" {
+		t.Errorf("take %q %v", p.data[off:], ok)
+	}
+}
+
+func TestReadUnblockedByClose(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "read-close"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+	defer client.Close()
+
+	// Заблокированные Read обеих сторон завершаются по Close
+	for name, conn := range map[string]net.Conn{"server": server, "client": client} {
+		errs := make(chan error, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				_, err := conn.Read(make([]byte, 16))
+				errs <- err
+			}()
+		}
+		time.Sleep(20 * time.Millisecond)
+		conn.Close()
+		for i := 0; i < 2; i++ {
+			select {
+			case err := <-errs:
+				if err != io.EOF {
+					t.Errorf("%s: Read after Close: %v", name, err)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("%s: Read blocked after Close", name)
+			}
+		}
+	}
+}
+
+func TestConcurrentReadWriteClose(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "read-concurrent"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+	defer client.Close()
+
+	const packets, size = 100, 100
+	var read int64
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			// Маленький буфер: остатки делят параллельные читатели
+			b := make([]byte, 7)
+			for {
+				n, err := server.Read(b)
+				atomic.AddInt64(&read, int64(n))
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	var writers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for j := 0; j < packets/2; j++ {
+				client.Write(bytes.Repeat([]byte{'x'}, size))
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+	writers.Wait()
+
+	// Всё записанное дочитано, ни один байт остатков не потерян
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt64(&read) < packets*size && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt64(&read); got != packets*size {
+		t.Errorf("readers got %d bytes, want %d", got, packets*size)
+	}
+
+	// Close при заблокированных читателях и идущей записи
+	go server.Write([]byte("late"))
+	server.Close()
+	done := make(chan struct{})
+	go func() {
+		readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("readers blocked after Close")
+	}
+}
@@ -0,0 +1,197 @@
+package gametunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ====================================================================
+// Унаследованные сокеты: systemd socket activation и listenFd
+// ====================================================================
+//
+// Сервер может не открывать сокет сам, а взять уже привязанный:
+//
+//   - socketActivation - сокеты из .socket-юнита systemd
+//     (ListenDatagram=443). systemd передаёт их дескрипторами с 3
+//     (LISTEN_FDS, LISTEN_PID - наш PID). Каждый адрес listener-а
+//     (inbound, extraListen, portRange) забирает переданные сокеты
+//     с тем же адресом и портом, остальные адреса открываются как
+//     обычно. Сокет забирает только один listener
+//   - listenFd - дескриптор с явным номером вместо адреса inbound-а:
+//     его передаёт супервизор или прошлый процесс
+//
+// Так сервер слушает привилегированный порт, не работая от root, а
+// перезапуск не закрывает порт: сокет держит systemd, датаграммы
+// ждут нового процесса в буфере ядра. Сессии переживают перезапуск
+// со снимками sessionSnapshotPath.
+//
+// Опции сокетов (sockopt.go) выставляются на унаследованный сокет
+// после bind. Только Unix: в Windows дескриптор сокета не
+// наследуется так.
+//
+// ====================================================================
+
+// listenFdsStart - первый дескриптор systemd (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// inheritedSockets - UDP-сокеты, переданные процессу
+type inheritedSockets struct {
+	mu    sync.Mutex
+	conns []*net.UDPConn
+}
+
+var (
+	// systemdSockets - сокеты LISTEN_FDS, читаются при первом
+	// обращении
+	systemdSockets     *inheritedSockets
+	systemdSocketsErr  error
+	systemdSocketsOnce sync.Once
+)
+
+// loadSystemdSockets возвращает сокеты LISTEN_FDS процесса
+// Переменные окружения снимаются, чтобы их не унаследовали дочерние
+// процессы
+func loadSystemdSockets() (*inheritedSockets, error) {
+	systemdSocketsOnce.Do(func() {
+		systemdSockets, systemdSocketsErr = newInheritedSockets(os.LookupEnv, listenFdsStart)
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	return systemdSockets, systemdSocketsErr
+}
+
+// newInheritedSockets разбирает LISTEN_PID / LISTEN_FDS из lookup и
+// забирает UDP-сокеты с дескриптора first; остальные дескрипторы
+// (TCP, unix) не трогает. Без LISTEN_PID или с чужим PID сокетов нет
+func newInheritedSockets(lookup func(string) (string, bool), first int) (*inheritedSockets, error) {
+	s := &inheritedSockets{}
+	// Запуск не через systemd: все адреса открываются как обычно
+	pidEnv, ok := lookup("LISTEN_PID")
+	if !ok {
+		return s, nil
+	}
+	if pid, err := strconv.Atoi(pidEnv); err != nil || pid != os.Getpid() {
+		return s, nil
+	}
+	fdsEnv, _ := lookup("LISTEN_FDS")
+	n, err := strconv.Atoi(fdsEnv)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("socket activation: LISTEN_FDS %q", fdsEnv)
+	}
+
+	for fd := first; fd < first+n; fd++ {
+		if conn, err := fileUDPConn(uintptr(fd)); err == nil {
+			s.conns = append(s.conns, conn)
+		}
+	}
+	return s, nil
+}
+
+// fileUDPConn оборачивает UDP-сокет fd; дескриптор fd закрывается,
+// соединение держит его копию
+func fileUDPConn(fd uintptr) (*net.UDPConn, error) {
+	f := os.NewFile(fd, "listen-fd-"+strconv.Itoa(int(fd)))
+	if f == nil {
+		return nil, fmt.Errorf("fd %d: invalid descriptor", fd)
+	}
+	defer f.Close()
+
+	pc, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("fd %d: %w", fd, err)
+	}
+	conn, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, fmt.Errorf("fd %d: not a UDP socket", fd)
+	}
+	return conn, nil
+}
+
+// take забирает сокеты с адресом addr; порт 0 не совпадает ни с чем
+func (s *inheritedSockets) take(addr *net.UDPAddr) []*net.UDPConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var taken []*net.UDPConn
+	rest := s.conns[:0]
+	for _, conn := range s.conns {
+		if sameListenAddr(conn.LocalAddr().(*net.UDPAddr), addr) {
+			taken = append(taken, conn)
+		} else {
+			rest = append(rest, conn)
+		}
+	}
+	s.conns = rest
+	return taken
+}
+
+// sameListenAddr - сокет bound слушает адрес addr
+// 0.0.0.0 и :: считаются одним адресом: ListenDatagram=443 в
+// systemd - dual-stack [::]:443
+func sameListenAddr(bound, addr *net.UDPAddr) bool {
+	if addr.Port == 0 || bound.Port != addr.Port {
+		return false
+	}
+	if len(addr.IP) == 0 || addr.IP.IsUnspecified() {
+		return bound.IP.IsUnspecified()
+	}
+	return bound.IP.Equal(addr.IP)
+}
+
+// adoptSocket выставляет опции opts на уже привязанный сокет conn
+// sockopt xray к нему не применяются: их выставляет системный listener
+// до bind, а сокет открыл родительский процесс
+func adoptSocket(conn *net.UDPConn, opts *socketOptions) error {
+	if err := opts.apply(conn); err != nil {
+		return fmt.Errorf("inherited socket %s: %w", conn.LocalAddr(), err)
+	}
+	return nil
+}
+
+// listenFd берёт сокет дескриптора fd (listenFd)
+func listenFd(fd uint32, opts *socketOptions) (*net.UDPConn, error) {
+	conn, err := fileUDPConn(uintptr(fd))
+	if err != nil {
+		return nil, fmt.Errorf("listenFd: %w", err)
+	}
+	if err := adoptSocket(conn, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// adopt забирает сокеты для addr и выставляет на них opts (nil -
+// их нет, открыть самому)
+func (s *inheritedSockets) adopt(addr *net.UDPAddr, opts *socketOptions) ([]PacketConn, error) {
+	taken := s.take(addr)
+	conns := make([]PacketConn, 0, len(taken))
+	for i, conn := range taken {
+		if err := adoptSocket(conn, opts); err != nil {
+			for _, c := range taken[i:] {
+				c.Close()
+			}
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// closeRest закрывает сокеты, не нужные ни одному адресу
+func (s *inheritedSockets) closeRest() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
@@ -0,0 +1,180 @@
+package gametunnel
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// passFd передаёт сокет conn дескриптором to, как systemd; to = 0 -
+// любой свободный номер. Сам conn закрывается
+func passFd(t *testing.T, conn interface {
+	File() (*os.File, error)
+	Close() error
+}, to int) int {
+	t.Helper()
+
+	f, err := conn.File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	defer conn.Close()
+
+	fd := to
+	if to == 0 {
+		fd, err = unix.Dup(int(f.Fd()))
+	} else {
+		err = unix.Dup3(int(f.Fd()), to, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fd
+}
+
+func TestListenFd(t *testing.T) {
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := sock.LocalAddr().(*net.UDPAddr)
+
+	config := DefaultConfig()
+	config.Key = "listen-fd"
+	config.ListenFd = uint32(passFd(t, sock, 0))
+	l, accepted := startTestListener(t, config)
+
+	// Адрес inbound-а (порт 0) не используется: слушает переданный сокет
+	if got := l.Addr().(*net.UDPAddr); !got.IP.Equal(addr.IP) || got.Port != addr.Port {
+		t.Fatalf("Addr: got %v, want %v", got, addr)
+	}
+
+	clientConfig := *config
+	clientConfig.ListenFd = 0
+	client, server := dialTestClientAt(t, addr, &clientConfig, accepted)
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	if got := readWithTimeout(t, server, 64); string(got) != "ping" {
+		t.Fatalf("server got %q", got)
+	}
+}
+
+func TestListenFdInvalid(t *testing.T) {
+	config := DefaultConfig()
+	config.ListenFd = 2
+	if err := config.Validate(); err == nil {
+		t.Error("listenFd 2 (stderr) accepted")
+	}
+
+	// Дескриптор не сокет
+	f, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := unix.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenFd(uint32(fd), listenSocketOptions(config, nil)); err == nil {
+		t.Error("listenFd accepted /dev/null")
+	}
+}
+
+func TestInheritedSockets(t *testing.T) {
+	const first = 200
+
+	// LISTEN_FDS=3: два UDP-сокета и TCP-сокет, который пропускается
+	var ports []int
+	for i := 0; i < 2; i++ {
+		sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports = append(ports, sock.LocalAddr().(*net.UDPAddr).Port)
+		passFd(t, sock, first+i)
+	}
+	tcp, err := net.ListenTCP("tcp4", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	passFd(t, tcp, first+2)
+	defer unix.Close(first + 2)
+
+	env := map[string]string{
+		"LISTEN_PID": strconv.Itoa(os.Getpid()),
+		"LISTEN_FDS": "3",
+	}
+	lookup := func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+	sockets, err := newInheritedSockets(lookup, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets.conns) != 2 {
+		t.Fatalf("inherited %d sockets, want 2 UDP", len(sockets.conns))
+	}
+	defer func() {
+		for _, conn := range sockets.conns {
+			conn.Close()
+		}
+	}()
+
+	// Порт 0 и чужой адрес не совпадают; сокет забирается один раз
+	if got := sockets.take(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}); len(got) != 0 {
+		t.Errorf("port 0 took %d sockets", len(got))
+	}
+	if got := sockets.take(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: ports[0]}); len(got) != 0 {
+		t.Errorf("other address took %d sockets", len(got))
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: ports[0]}
+	taken := sockets.take(addr)
+	if len(taken) != 1 || taken[0].LocalAddr().(*net.UDPAddr).Port != ports[0] {
+		t.Fatalf("take %v: got %d sockets", addr, len(taken))
+	}
+	defer taken[0].Close()
+	if err := adoptSocket(taken[0], listenSocketOptions(DefaultConfig(), nil)); err != nil {
+		t.Errorf("adoptSocket: %v", err)
+	}
+	if got := sockets.take(addr); len(got) != 0 {
+		t.Errorf("socket taken twice")
+	}
+	if len(sockets.conns) != 1 {
+		t.Errorf("%d sockets left, want 1", len(sockets.conns))
+	}
+
+	// Чужой LISTEN_PID - сокеты другого процесса
+	env["LISTEN_PID"] = "1"
+	if other, err := newInheritedSockets(lookup, first); err != nil || len(other.conns) != 0 {
+		t.Errorf("foreign LISTEN_PID: %d sockets, %v", len(other.conns), err)
+	}
+}
+
+func TestSameListenAddr(t *testing.T) {
+	bound := func(ip string) *net.UDPAddr { return &net.UDPAddr{IP: net.ParseIP(ip), Port: 443} }
+	tests := []struct {
+		bound, addr *net.UDPAddr
+		want        bool
+	}{
+		{bound("::"), &net.UDPAddr{Port: 443}, true},
+		{bound("::"), bound("0.0.0.0"), true},
+		{bound("0.0.0.0"), bound("::"), true},
+		{bound("10.0.0.1"), bound("10.0.0.1"), true},
+		{bound("10.0.0.1"), bound("0.0.0.0"), false},
+		{bound("::"), bound("10.0.0.1"), false},
+		{bound("::"), &net.UDPAddr{Port: 444}, false},
+		{&net.UDPAddr{IP: net.IPv6unspecified}, &net.UDPAddr{}, false},
+	}
+	for _, tt := range tests {
+		if got := sameListenAddr(tt.bound, tt.addr); got != tt.want {
+			t.Errorf("sameListenAddr(%v, %v) = %v, want %v", tt.bound, tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package gametunnel
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/app/router"
+)
+
+// ====================================================================
+// Фильтр источников: allow/deny списки и GeoIP
+// ====================================================================
+//
+// Оператор может ограничить, с каких адресов разрешён хэндшейк:
+//   - denyIps  - источник из списка отклоняется
+//   - allowIps - если список не пуст, принимаются только
+//                источники из него
+//
+// Записи - CIDR или "geoip:<код страны>" из geoip.dat xray,
+// как в правилах роутинга. Каждая запись - отдельное правило
+// со своим счётчиком срабатываний.
+//
+// Проверка выполняется в Hub.RoutePacket до любой криптографии.
+//
+// ====================================================================
+
+// IPRule - одно правило фильтра источников
+type IPRule struct {
+	// Name - исходная запись конфига ("10.0.0.0/8", "geoip:ru")
+	Name string `json:"name"`
+
+	// GeoIP - диапазоны правила в формате роутера xray
+	GeoIP *router.GeoIP `json:"-"`
+}
+
+// ipFilterRule - правило с построенным матчером и счётчиком
+type ipFilterRule struct {
+	name    string
+	matcher router.GeoIPMatcher
+	hits    uint64
+}
+
+// IPRuleStats - счётчик одного правила
+type IPRuleStats struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Hits   uint64 `json:"hits"`
+}
+
+// IPFilterStats - статистика фильтра источников
+type IPFilterStats struct {
+	Rules []IPRuleStats `json:"rules"`
+
+	// RejectedNotAllowed - источник не попал ни в одно allow-правило
+	RejectedNotAllowed uint64 `json:"rejectedNotAllowed"`
+}
+
+// ipFilter - allow/deny фильтр источников хэндшейков
+type ipFilter struct {
+	allow []*ipFilterRule
+	deny  []*ipFilterRule
+
+	rejectedNotAllowed uint64
+}
+
+// newIPFilter строит фильтр из конфига; nil, если списки пусты
+func newIPFilter(config *Config) (*ipFilter, error) {
+	if len(config.AllowIps) == 0 && len(config.DenyIps) == 0 {
+		return nil, nil
+	}
+
+	allow, err := buildIPRules(config.AllowIps)
+	if err != nil {
+		return nil, fmt.Errorf("allowIps: %w", err)
+	}
+	deny, err := buildIPRules(config.DenyIps)
+	if err != nil {
+		return nil, fmt.Errorf("denyIps: %w", err)
+	}
+
+	return &ipFilter{allow: allow, deny: deny}, nil
+}
+
+// buildIPRules строит матчеры правил
+func buildIPRules(rules []*IPRule) ([]*ipFilterRule, error) {
+	built := make([]*ipFilterRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule == nil {
+			continue
+		}
+		geoip := rule.GeoIP
+		if geoip == nil {
+			// Правило без диапазонов (например из API перезагрузки):
+			// Name должен быть IP или CIDR
+			cidr, err := parseRuleCIDR(rule.Name)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+			}
+			geoip = &router.GeoIP{Cidr: []*router.CIDR{cidr}}
+		}
+		matcher, err := router.BuildOptimizedGeoIPMatcher(geoip)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		built = append(built, &ipFilterRule{name: rule.Name, matcher: matcher})
+	}
+	return built, nil
+}
+
+// parseRuleCIDR разбирает запись "1.2.3.4" или "10.0.0.0/8"
+// geoip:-записи требуют geoip.dat и строятся загрузчиком конфига
+func parseRuleCIDR(name string) (*router.CIDR, error) {
+	if ip := net.ParseIP(name); ip != nil {
+		if v4 := ip.To4(); v4 != nil {
+			return &router.CIDR{Ip: v4, Prefix: 32}, nil
+		}
+		return &router.CIDR{Ip: ip, Prefix: 128}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(name)
+	if err != nil {
+		return nil, fmt.Errorf("not an IP or CIDR")
+	}
+	ones, _ := ipNet.Mask.Size()
+	ip := ipNet.IP
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return &router.CIDR{Ip: ip, Prefix: uint32(ones)}, nil
+}
+
+// check проверяет, разрешён ли хэндшейк с адреса ip
+func (f *ipFilter) check(ip net.IP) error {
+	if f == nil {
+		return nil
+	}
+
+	for _, rule := range f.deny {
+		if rule.matcher.Match(ip) {
+			atomic.AddUint64(&rule.hits, 1)
+			return fmt.Errorf("source %s denied by rule %q", ip, rule.name)
+		}
+	}
+
+	if len(f.allow) == 0 {
+		return nil
+	}
+
+	for _, rule := range f.allow {
+		if rule.matcher.Match(ip) {
+			atomic.AddUint64(&rule.hits, 1)
+			return nil
+		}
+	}
+
+	atomic.AddUint64(&f.rejectedNotAllowed, 1)
+	return fmt.Errorf("source %s not in allow list", ip)
+}
+
+// stats возвращает счётчики правил
+func (f *ipFilter) stats() IPFilterStats {
+	if f == nil {
+		return IPFilterStats{}
+	}
+
+	stats := IPFilterStats{
+		RejectedNotAllowed: atomic.LoadUint64(&f.rejectedNotAllowed),
+	}
+	for _, rule := range f.allow {
+		stats.Rules = append(stats.Rules, IPRuleStats{Name: rule.name, Action: "allow", Hits: atomic.LoadUint64(&rule.hits)})
+	}
+	for _, rule := range f.deny {
+		stats.Rules = append(stats.Rules, IPRuleStats{Name: rule.name, Action: "deny", Hits: atomic.LoadUint64(&rule.hits)})
+	}
+	return stats
+}
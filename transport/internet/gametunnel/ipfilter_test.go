@@ -0,0 +1,79 @@
+package gametunnel
+
+import (
+	"net"
+	"testing"
+
+	"github.com/xtls/xray-core/app/router"
+)
+
+// cidrRule создаёт правило из одного CIDR
+func cidrRule(name string, ip net.IP, prefix uint32) *IPRule {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return &IPRule{
+		Name: name,
+		GeoIP: &router.GeoIP{
+			Cidr: []*router.CIDR{{Ip: ip, Prefix: prefix}},
+		},
+	}
+}
+
+func TestIPFilterDisabled(t *testing.T) {
+	f, err := newIPFilter(DefaultConfig())
+	if err != nil || f != nil {
+		t.Fatalf("Empty lists should disable filter: %v, %v", f, err)
+	}
+	if err := f.check(net.ParseIP("1.2.3.4")); err != nil {
+		t.Errorf("Disabled filter rejected: %v", err)
+	}
+}
+
+func TestIPFilterAllowDeny(t *testing.T) {
+	config := DefaultConfig()
+	config.AllowIps = []*IPRule{cidrRule("10.0.0.0/8", net.ParseIP("10.0.0.0"), 8)}
+	config.DenyIps = []*IPRule{cidrRule("10.66.0.0/16", net.ParseIP("10.66.0.0"), 16)}
+
+	f, err := newIPFilter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.check(net.ParseIP("10.1.2.3")); err != nil {
+		t.Errorf("Allowed source rejected: %v", err)
+	}
+	if err := f.check(net.ParseIP("10.66.1.1")); err == nil {
+		t.Error("Deny rule must win over allow")
+	}
+	if err := f.check(net.ParseIP("192.168.1.1")); err == nil {
+		t.Error("Source outside allow list should be rejected")
+	}
+
+	stats := f.stats()
+	if stats.RejectedNotAllowed != 1 {
+		t.Errorf("RejectedNotAllowed: got %d, want 1", stats.RejectedNotAllowed)
+	}
+	for _, rule := range stats.Rules {
+		if rule.Hits != 1 {
+			t.Errorf("Rule %s (%s): got %d hits, want 1", rule.Name, rule.Action, rule.Hits)
+		}
+	}
+}
+
+func TestIPFilterDenyOnly(t *testing.T) {
+	config := DefaultConfig()
+	config.DenyIps = []*IPRule{cidrRule("2001:db8::/32", net.ParseIP("2001:db8::"), 32)}
+
+	f, err := newIPFilter(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.check(net.ParseIP("2001:db8::1")); err == nil {
+		t.Error("Denied IPv6 source accepted")
+	}
+	if err := f.check(net.ParseIP("8.8.8.8")); err != nil {
+		t.Errorf("Without allow list other sources pass: %v", err)
+	}
+}
@@ -0,0 +1,305 @@
+package gametunnel
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ====================================================================
+// Ограничения на IP: сессии и хэндшейки
+// ====================================================================
+//
+// Каждый новый хэндшейк стоит серверу генерации ключей и ECDH.
+// Без ограничений один IP может открыть сколько угодно сессий
+// и загрузить CPU вычислениями. ipGuard проверяет новый
+// хэндшейк ДО любой криптографии:
+//   - maxSessionsPerIp    - одновременных сессий с одного IP
+//   - handshakesPerMinute - новых хэндшейков с одного IP в минуту
+//   - ipBanDuration       - IP, превысивший лимит хэндшейков,
+//                           блокируется на это время целиком
+//
+// Состояние IP хранится в LRU ограниченного размера: при наплыве
+// адресов вытесняются давно не появлявшиеся IP без активных сессий.
+// Если все записи держат сессии, хэндшейк с нового IP отклоняется -
+// таблица не растёт сверх ipGuardMaxEntries.
+//
+// Блокировки лежат отдельно от LRU и не вытесняются наплывом новых
+// адресов. Их таблица тоже ограничена (ipGuardMaxBans): при
+// переполнении сначала удаляются истёкшие, затем та, что истекает
+// раньше всех.
+//
+// ====================================================================
+
+const (
+	// ipGuardMaxEntries - максимум отслеживаемых IP в LRU
+	ipGuardMaxEntries = 4096
+
+	// ipGuardMaxBans - максимум одновременных блокировок
+	ipGuardMaxBans = 4096
+
+	// handshakeWindow - окно подсчёта хэндшейков
+	handshakeWindow = time.Minute
+)
+
+// ipEntry - состояние одного IP
+type ipEntry struct {
+	ip string
+
+	// sessions - активные (и устанавливаемые) сессии
+	sessions uint32
+
+	// windowStart / handshakes - хэндшейки в текущем окне
+	windowStart time.Time
+	handshakes  uint32
+
+	// rejected - отклонённых хэндшейков с этого IP
+	rejected uint64
+
+	elem *list.Element
+}
+
+// ipBan - блокировка IP
+// rejected - отклонённых хэндшейков IP, включая блокировку
+type ipBan struct {
+	until    time.Time
+	rejected uint64
+}
+
+// IPBan - активная блокировка IP
+type IPBan struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"until"`
+	Rejected uint64    `json:"rejected"`
+}
+
+// IPGuardStats - статистика ограничений на IP
+type IPGuardStats struct {
+	// TrackedIPs - IP в LRU
+	TrackedIPs int `json:"trackedIps"`
+
+	// RejectedHandshakes - всего отклонённых хэндшейков
+	RejectedHandshakes uint64 `json:"rejectedHandshakes"`
+
+	// RejectedSessionLimit - отклонено из-за maxSessionsPerIp
+	RejectedSessionLimit uint64 `json:"rejectedSessionLimit"`
+
+	// RejectedRateLimit - отклонено из-за handshakesPerMinute
+	RejectedRateLimit uint64 `json:"rejectedRateLimit"`
+
+	// RejectedBanned - отклонено из-за блокировки IP
+	RejectedBanned uint64 `json:"rejectedBanned"`
+
+	// RejectedTableFull - отклонено: все отслеживаемые IP держат
+	// сессии, новый IP некуда записать
+	RejectedTableFull uint64 `json:"rejectedTableFull"`
+
+	// Bans - активные блокировки
+	Bans []IPBan `json:"bans"`
+}
+
+// ipGuard - лимиты сессий и хэндшейков на IP
+type ipGuard struct {
+	maxSessions   uint32
+	maxHandshakes uint32
+	banDuration   time.Duration
+
+	entries map[string]*ipEntry
+
+	// lru - порядок обращений, в начале - самые свежие
+	lru *list.List
+
+	// bans - активные блокировки, не больше ipGuardMaxBans
+	bans map[string]*ipBan
+
+	rejectedSessionLimit uint64
+	rejectedRateLimit    uint64
+	rejectedBanned       uint64
+	rejectedTableFull    uint64
+
+	mu sync.Mutex
+}
+
+// newIPGuard создаёт ограничитель; nil, если все лимиты выключены
+func newIPGuard(config *Config) *ipGuard {
+	if config.MaxSessionsPerIp == 0 && config.HandshakesPerMinute == 0 {
+		return nil
+	}
+	return &ipGuard{
+		maxSessions:   config.MaxSessionsPerIp,
+		maxHandshakes: config.HandshakesPerMinute,
+		banDuration:   time.Duration(config.IpBanDuration) * time.Second,
+		entries:       make(map[string]*ipEntry),
+		lru:           list.New(),
+		bans:          make(map[string]*ipBan),
+	}
+}
+
+// entryLocked возвращает (создаёт) запись IP и поднимает её в LRU
+// nil - таблица полна и вытеснить некого
+func (g *ipGuard) entryLocked(ip string) *ipEntry {
+	if e, ok := g.entries[ip]; ok {
+		g.lru.MoveToFront(e.elem)
+		return e
+	}
+
+	if len(g.entries) >= ipGuardMaxEntries && !g.evictLocked() {
+		return nil
+	}
+
+	e := &ipEntry{ip: ip}
+	e.elem = g.lru.PushFront(e)
+	g.entries[ip] = e
+	return e
+}
+
+// evictLocked вытесняет самый старый IP без активных сессий
+// false - все IP держат сессии
+// Блокировки хранятся отдельно (bans) и вытеснение их не снимает
+func (g *ipGuard) evictLocked() bool {
+	for elem := g.lru.Back(); elem != nil; elem = elem.Prev() {
+		e := elem.Value.(*ipEntry)
+		if e.sessions == 0 {
+			g.lru.Remove(elem)
+			delete(g.entries, e.ip)
+			return true
+		}
+	}
+	return false
+}
+
+// banLocked блокирует IP до until
+// Полная таблица сначала освобождается от истёкших блокировок, затем
+// от той, что истекает раньше всех
+func (g *ipGuard) banLocked(ip string, until time.Time, rejected uint64, now time.Time) {
+	if _, ok := g.bans[ip]; !ok && len(g.bans) >= ipGuardMaxBans {
+		var soonest string
+		for banned, ban := range g.bans {
+			if !now.Before(ban.until) {
+				delete(g.bans, banned)
+				continue
+			}
+			if soonest == "" || ban.until.Before(g.bans[soonest].until) {
+				soonest = banned
+			}
+		}
+		if len(g.bans) >= ipGuardMaxBans {
+			delete(g.bans, soonest)
+		}
+	}
+	g.bans[ip] = &ipBan{until: until, rejected: rejected}
+}
+
+// admitHandshake решает, принимать ли новый хэндшейк с IP
+// При успехе резервирует слот сессии - его нужно вернуть через
+// sessionClosed, если сессия так и не была создана
+func (g *ipGuard) admitHandshake(ip string, now time.Time) error {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if ban, ok := g.bans[ip]; ok {
+		if now.Before(ban.until) {
+			ban.rejected++
+			g.rejectedBanned++
+			return fmt.Errorf("ip %s banned until %s", ip, ban.until.Format(time.RFC3339))
+		}
+		delete(g.bans, ip)
+	}
+
+	e := g.entryLocked(ip)
+	if e == nil {
+		g.rejectedTableFull++
+		return fmt.Errorf("ip %s not admitted: %d tracked IPs all hold sessions", ip, ipGuardMaxEntries)
+	}
+
+	if g.maxHandshakes > 0 {
+		if now.Sub(e.windowStart) >= handshakeWindow {
+			e.windowStart = now
+			e.handshakes = 0
+		}
+		if e.handshakes >= g.maxHandshakes {
+			e.rejected++
+			g.rejectedRateLimit++
+			if g.banDuration > 0 {
+				g.banLocked(ip, now.Add(g.banDuration), e.rejected, now)
+			}
+			return fmt.Errorf("ip %s exceeded %d handshakes per minute", ip, g.maxHandshakes)
+		}
+		e.handshakes++
+	}
+
+	if g.maxSessions > 0 && e.sessions >= g.maxSessions {
+		e.rejected++
+		g.rejectedSessionLimit++
+		return fmt.Errorf("ip %s reached session limit %d", ip, g.maxSessions)
+	}
+
+	e.sessions++
+	return nil
+}
+
+// sessionClosed освобождает слот сессии IP
+func (g *ipGuard) sessionClosed(ip string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if e, ok := g.entries[ip]; ok && e.sessions > 0 {
+		e.sessions--
+	}
+}
+
+// sessionRestored занимает слот сессии, восстановленной из снапшота
+// Лимиты не проверяются - сессия уже существовала до перезапуска
+func (g *ipGuard) sessionRestored(ip string) {
+	if g == nil {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Полная таблица - восстановленная сессия остаётся без учёта:
+	// отклонять её поздно
+	if e := g.entryLocked(ip); e != nil {
+		e.sessions++
+	}
+}
+
+// stats возвращает снимок статистики с активными блокировками
+func (g *ipGuard) stats(now time.Time) IPGuardStats {
+	if g == nil {
+		return IPGuardStats{}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := IPGuardStats{
+		TrackedIPs:           len(g.entries),
+		RejectedSessionLimit: g.rejectedSessionLimit,
+		RejectedRateLimit:    g.rejectedRateLimit,
+		RejectedBanned:       g.rejectedBanned,
+		RejectedTableFull:    g.rejectedTableFull,
+	}
+	stats.RejectedHandshakes = stats.RejectedSessionLimit + stats.RejectedRateLimit + stats.RejectedBanned + stats.RejectedTableFull
+
+	for ip, ban := range g.bans {
+		if now.Before(ban.until) {
+			stats.Bans = append(stats.Bans, IPBan{
+				IP:       ip,
+				Until:    ban.until,
+				Rejected: ban.rejected,
+			})
+		}
+	}
+	return stats
+}
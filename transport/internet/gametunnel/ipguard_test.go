@@ -0,0 +1,175 @@
+package gametunnel
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIPGuardDisabled(t *testing.T) {
+	g := newIPGuard(DefaultConfig())
+	if g != nil {
+		t.Fatal("IP guard should be off by default")
+	}
+	if err := g.admitHandshake("10.0.0.1", time.Now()); err != nil {
+		t.Errorf("Disabled guard rejected handshake: %v", err)
+	}
+	g.sessionClosed("10.0.0.1")
+}
+
+func TestIPGuardSessionLimit(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSessionsPerIp = 2
+	g := newIPGuard(config)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if err := g.admitHandshake("10.0.0.1", now); err != nil {
+			t.Fatalf("Session %d: %v", i, err)
+		}
+	}
+	if err := g.admitHandshake("10.0.0.1", now); err == nil {
+		t.Fatal("Third session should be rejected")
+	}
+
+	// Другой IP не затронут
+	if err := g.admitHandshake("10.0.0.2", now); err != nil {
+		t.Errorf("Other IP rejected: %v", err)
+	}
+
+	// Освободили слот - снова можно
+	g.sessionClosed("10.0.0.1")
+	if err := g.admitHandshake("10.0.0.1", now); err != nil {
+		t.Errorf("Slot should be free after close: %v", err)
+	}
+
+	if stats := g.stats(now); stats.RejectedSessionLimit != 1 || stats.RejectedHandshakes != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
+
+func TestIPGuardHandshakeRateAndBan(t *testing.T) {
+	config := DefaultConfig()
+	config.HandshakesPerMinute = 3
+	config.IpBanDuration = 300
+	g := newIPGuard(config)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if err := g.admitHandshake("10.0.0.1", now); err != nil {
+			t.Fatalf("Handshake %d: %v", i, err)
+		}
+	}
+	if err := g.admitHandshake("10.0.0.1", now); err == nil {
+		t.Fatal("Handshake over the limit should be rejected")
+	}
+
+	// Окно сменилось, но IP заблокирован
+	later := now.Add(handshakeWindow + time.Second)
+	if err := g.admitHandshake("10.0.0.1", later); err == nil {
+		t.Fatal("Banned IP should be rejected")
+	}
+
+	stats := g.stats(later)
+	if stats.RejectedRateLimit != 1 || stats.RejectedBanned != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if len(stats.Bans) != 1 || stats.Bans[0].IP != "10.0.0.1" || stats.Bans[0].Rejected != 2 {
+		t.Errorf("Ban not surfaced: %+v", stats.Bans)
+	}
+
+	// Блокировка истекла
+	if err := g.admitHandshake("10.0.0.1", now.Add(6*time.Minute)); err != nil {
+		t.Errorf("Ban should expire: %v", err)
+	}
+}
+
+func TestIPGuardLRUEviction(t *testing.T) {
+	config := DefaultConfig()
+	config.HandshakesPerMinute = 100
+	g := newIPGuard(config)
+	now := time.Now()
+
+	// Первый IP держит сессию - его вытеснять нельзя
+	g.admitHandshake("10.0.0.1", now)
+
+	for i := 0; i < ipGuardMaxEntries+10; i++ {
+		ip := fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+		g.admitHandshake(ip, now)
+		g.sessionClosed(ip)
+	}
+
+	g.mu.Lock()
+	_, kept := g.entries["10.0.0.1"]
+	n := len(g.entries)
+	g.mu.Unlock()
+
+	if !kept {
+		t.Error("IP with active session must not be evicted")
+	}
+	if n > ipGuardMaxEntries {
+		t.Errorf("LRU grew beyond limit: %d", n)
+	}
+}
+
+func TestIPGuardRefusesWhenFull(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxSessionsPerIp = 1
+	g := newIPGuard(config)
+	now := time.Now()
+
+	// Все записи держат сессии - вытеснять некого
+	for i := 0; i < ipGuardMaxEntries; i++ {
+		if err := g.admitHandshake(fmt.Sprintf("10.%d.%d.1", i/256, i%256), now); err != nil {
+			t.Fatalf("IP %d: %v", i, err)
+		}
+	}
+	if err := g.admitHandshake("192.168.0.1", now); err == nil {
+		t.Fatal("New IP admitted into a full table")
+	}
+	if stats := g.stats(now); stats.TrackedIPs != ipGuardMaxEntries || stats.RejectedTableFull != 1 {
+		t.Errorf("Unexpected stats: tracked %d, table full %d", stats.TrackedIPs, stats.RejectedTableFull)
+	}
+
+	// Освободился слот - новый IP занимает его место
+	g.sessionClosed("10.0.0.1")
+	if err := g.admitHandshake("192.168.0.1", now); err != nil {
+		t.Errorf("New IP rejected after a slot freed: %v", err)
+	}
+}
+
+func TestIPGuardBansOutliveEviction(t *testing.T) {
+	config := DefaultConfig()
+	config.HandshakesPerMinute = 1
+	config.IpBanDuration = 300
+	g := newIPGuard(config)
+	now := time.Now()
+
+	g.admitHandshake("10.0.0.1", now)
+	g.sessionClosed("10.0.0.1")
+	if err := g.admitHandshake("10.0.0.1", now); err == nil {
+		t.Fatal("Handshake over the limit should be rejected")
+	}
+
+	// Наплыв новых адресов вытесняет запись IP, но не блокировку
+	for i := 0; i < ipGuardMaxEntries+10; i++ {
+		ip := fmt.Sprintf("192.168.%d.%d", i/256, i%256)
+		g.admitHandshake(ip, now)
+		g.sessionClosed(ip)
+	}
+	if err := g.admitHandshake("10.0.0.1", now); err == nil {
+		t.Error("Ban lifted by LRU eviction")
+	}
+
+	// Блокировок не больше ipGuardMaxBans: вытесняется ближайшая к
+	// истечению
+	for i := 0; i < ipGuardMaxBans; i++ {
+		g.banLocked(fmt.Sprintf("172.16.%d.%d", i/256, i%256), now.Add(time.Hour), 0, now)
+	}
+	if n := len(g.bans); n != ipGuardMaxBans {
+		t.Errorf("Ban table size %d, want %d", n, ipGuardMaxBans)
+	}
+	if _, ok := g.bans["10.0.0.1"]; ok {
+		t.Error("Soonest ban kept in a full table")
+	}
+}
@@ -0,0 +1,135 @@
+package gametunnel
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Keep-alive клиента
+// ====================================================================
+//
+// Keep-alive держит маппинг NAT, меряет RTT и замечает поломку пути
+// (health.go). Отправляет его отдельный таймер раз в keepAliveInterval
+// секунд со случайным разбросом ±20%: строго периодичный маячок
+// легко опознаётся DPI.
+//
+// Пока данные идут в обе стороны, keep-alive не нужен: маппинг NAT
+// обновляется самим трафиком, а путь заведомо жив. Такой тик
+// пропускается. Если клиент только отправляет, а сервер молчит,
+// keep-alive уходит - его ответ (или его отсутствие) и есть признак
+// состояния пути. С padding вместо отдельного keep-alive запрос
+// несёт следующий DATA-пакет (piggyback.go).
+//
+// С keepAliveTune интервал подбирается по маппингу NAT (nattune.go):
+// вместо keep-alive уходит проба, и до её ответа таймер молчит.
+//
+// ====================================================================
+
+// keepAliveLoop - таймер keep-alive соединения
+// Интервал - из снимка настроек (tunables.go): при смене снимка таймер
+// перезапускается, интервал 0 выключает keep-alive до следующей смены
+func (c *GameTunnelClientConn) keepAliveLoop() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		tuned := c.tunedConfig()
+		interval := time.Duration(tuned.config.KeepAliveInterval) * time.Second
+		if interval > 0 {
+			if wait, ok := c.natTune.pending(time.Now()); ok {
+				timer.Reset(wait)
+			} else {
+				timer.Reset(jitterDuration(c.natTune.interval(interval)))
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-tuned.changed:
+			timer.Stop()
+		case now := <-timer.C:
+			c.keepAliveTick(now, interval)
+		}
+	}
+}
+
+// keepAliveTick - срабатывание таймера keep-alive
+// interval - keepAliveInterval снимка настроек
+func (c *GameTunnelClientConn) keepAliveTick(now time.Time, interval time.Duration) {
+	switch {
+	case c.trafficFlowing(now, c.natTune.interval(interval)):
+		// Ответ на прошлый keep-alive больше не нужен - путь жив;
+		// RTT меряет Ping (quality.go)
+		atomic.StoreInt64(&c.keepAliveSentAt, 0)
+		c.sendPing(now)
+	case c.natProbeHold(now, interval):
+		// Проба NAT ждёт ответа - keep-alive освежил бы маппинг
+	case c.startNATProbe(now, interval):
+		// Проба ушла вместо keep-alive (nattune.go)
+	case c.piggybackKeepAlive(now, c.natTune.interval(interval)):
+		// Запрос keep-alive уйдёт в следующем DATA-пакете (piggyback.go)
+	default:
+		c.sendKeepAlive()
+		c.announceKeepAlive(interval)
+	}
+}
+
+// trafficFlowing - за последний interval клиент отправлял данные и
+// получал пакеты сервера
+func (c *GameTunnelClientConn) trafficFlowing(now time.Time, interval time.Duration) bool {
+	since := now.Add(-interval).UnixNano()
+	return atomic.LoadInt64(&c.lastSendAt) > since &&
+		atomic.LoadInt64(&c.lastRecvAt) > since
+}
+
+// sendKeepAlive отправляет отдельный keep-alive
+func (c *GameTunnelClientConn) sendKeepAlive() {
+	session := c.session()
+
+	pktNum := atomic.AddUint32(&session.SendPacketNum, 1)
+	keepAlive := NewKeepAlivePacket(session.ConnectionID, pktNum)
+
+	data, err := keepAlive.Marshal(c.config)
+	if err != nil {
+		return
+	}
+
+	wrapped, err := c.obfs.Wrap(data)
+	if err != nil {
+		return
+	}
+
+	c.keepAliveOut()
+	atomic.StoreInt32(&c.piggyback.pending, 0)
+	atomic.AddUint64(&c.keepAlivesSent, 1)
+	c.write(wrapped, PriorityHigh)
+}
+
+// keepAliveOut учитывает уходящий запрос keep-alive, отдельный или в
+// DATA-пакете: засчитывает оставшийся без ответа предыдущий
+func (c *GameTunnelClientConn) keepAliveOut() {
+	// Предыдущий keep-alive остался без ответа - признак поломки пути
+	if atomic.LoadInt64(&c.keepAliveSentAt) != 0 {
+		atomic.AddUint32(&c.keepAlivesMissed, 1)
+		c.traffic.observeLoss(lossScale)
+		c.checkPath()
+	}
+
+	// Замер RTT - только если предыдущий keep-alive уже получил ответ
+	atomic.CompareAndSwapInt64(&c.keepAliveSentAt, 0, time.Now().UnixNano())
+}
+
+// sendPing отправляет Ping для замера качества канала (quality.go)
+// Ping без ответа - потеря в оценке качества, но не признак поломки
+// пути: путь проверяют keep-alive и ошибки отправки
+func (c *GameTunnelClientConn) sendPing(now time.Time) {
+	c.sendSealedControl(0x01, c.traffic.pingSent(now))
+}
+
+// GetKeepAlivesSent возвращает число отправленных keep-alive
+func (c *GameTunnelClientConn) GetKeepAlivesSent() uint64 {
+	return atomic.LoadUint64(&c.keepAlivesSent)
+}
@@ -0,0 +1,60 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepAliveSkippedWhileTrafficFlows(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "keepalive"
+	config.KeepAliveInterval = 1
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	// Данные в обе стороны чаще интервала - keep-alive не нужен
+	stop := time.Now().Add(2500 * time.Millisecond)
+	for time.Now().Before(stop) {
+		client.Write([]byte("up"))
+		readWithTimeout(t, server, 2)
+		server.Write([]byte("down"))
+		readWithTimeout(t, client, 4)
+		time.Sleep(100 * time.Millisecond)
+	}
+	if sent := client.GetKeepAlivesSent(); sent != 0 {
+		t.Errorf("%d keep-alives sent while traffic flowed", sent)
+	}
+
+	// Простой: keep-alive раз в интервал (±20%), а не на каждом
+	// таймауте чтения
+	time.Sleep(2500 * time.Millisecond)
+	if sent := client.GetKeepAlivesSent(); sent < 1 || sent > 3 {
+		t.Errorf("%d keep-alives in 2.5s idle, want 1..3", sent)
+	}
+}
+
+func TestKeepAliveTrafficFlowing(t *testing.T) {
+	c := &GameTunnelClientConn{}
+	now := time.Now()
+	recent := now.Add(-100 * time.Millisecond).UnixNano()
+	stale := now.Add(-2 * time.Second).UnixNano()
+
+	tests := []struct {
+		sent, recv int64
+		want       bool
+	}{
+		{recent, recent, true},
+		{recent, stale, false}, // сервер молчит - путь надо проверить
+		{stale, recent, false},
+		{0, 0, false},
+	}
+	for _, tt := range tests {
+		c.lastSendAt, c.lastRecvAt = tt.sent, tt.recv
+		if got := c.trafficFlowing(now, time.Second); got != tt.want {
+			t.Errorf("sent=%d recv=%d: got %v", tt.sent, tt.recv, got)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package gametunnel
+
+import (
+	"bufio"
+	"fmt"
+	"math/bits"
+	mrand "math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Гистограммы задержек стадий отправки
+// ====================================================================
+//
+// Регрессия задержки прячется в средних: лишняя аллокация в
+// шифровании или медленный sendmmsg видны только в хвосте
+// распределения. Поэтому отправка меряет по классам приоритета три
+// стадии:
+//
+//	queue   - от постановки в очередь приоритетов до извлечения
+//	encrypt - шифрование, сборка и обфускация пакета DATA
+//	write   - вызов сокета (sendmsg или sendmmsg пачки)
+//
+// Меряется в среднем один пакет из latencySampleRate (случайная
+// выборка): time.Now на каждом пакете дороже самих счётчиков. Корзины
+// логарифмические, как у HdrHistogram с точностью до октавы: от 1 мкс
+// до 2^22 мкс (~4 с), дальше - переполнение. Счётчики атомарные, без
+// блокировок.
+//
+// Метрика - gametunnel_stage_latency_seconds{side,stage,class}
+// (metrics.go).
+//
+// ====================================================================
+
+const (
+	// latencySampleRate - меряется один пакет из latencySampleRate
+	latencySampleRate = 64
+
+	// latencyBuckets - корзин гистограммы: верхняя граница корзины i -
+	// 1 мкс << i; последняя корзина - переполнение
+	latencyBuckets = 23
+)
+
+// latencyStage - стадия отправки пакета
+type latencyStage int
+
+const (
+	latencyQueue latencyStage = iota
+	latencyEncrypt
+	latencyWrite
+
+	// latencyStages - число стадий
+	latencyStages
+)
+
+// latencyStageNames - имена стадий в метриках
+var latencyStageNames = [latencyStages]string{
+	latencyQueue:   "queue",
+	latencyEncrypt: "encrypt",
+	latencyWrite:   "write",
+}
+
+// latencyHistogram - гистограмма задержек (atomic)
+type latencyHistogram struct {
+	// counts - замеров в корзинах, последняя - переполнение
+	counts [latencyBuckets + 1]uint64
+
+	// sum - сумма замеров (наносекунды)
+	sum int64
+}
+
+// latencyBucket - корзина для задержки d
+func latencyBucket(d time.Duration) int {
+	if d <= time.Microsecond {
+		return 0
+	}
+	// Микросекунды с округлением вверх: 1.5 мкс - не в корзине 1 мкс
+	us := uint64((d + time.Microsecond - 1) / time.Microsecond)
+	return min(bits.Len64(us-1), latencyBuckets)
+}
+
+// latencyBound - верхняя граница корзины i
+func latencyBound(i int) time.Duration {
+	return time.Microsecond << i
+}
+
+// observe учитывает одну задержку
+func (h *latencyHistogram) observe(d time.Duration) {
+	atomic.AddUint64(&h.counts[latencyBucket(d)], 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// stageLatency - гистограммы стадий по классам одной стороны
+type stageLatency [latencyStages][PriorityLevels]latencyHistogram
+
+// latencyStart - начало замера: нулевое время, если пакет не попал в
+// выборку
+func latencyStart() time.Time {
+	if mrand.Uint32()%latencySampleRate != 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// since учитывает стадию, начатую в start (latencyStart)
+func (s *stageLatency) since(stage latencyStage, level PriorityLevel, start time.Time) {
+	if start.IsZero() || level >= PriorityLevels {
+		return
+	}
+	s[stage][level].observe(time.Since(start))
+}
+
+// sample учитывает ожидание в очереди пакета pkt, если он попал в
+// выборку
+func (s *stageLatency) sample(pkt *PriorityPacket) {
+	if mrand.Uint32()%latencySampleRate != 0 {
+		return
+	}
+	s.since(latencyQueue, pkt.Priority, pkt.EnqueuedAt)
+}
+
+// writeLatency выводит гистограммы стадий стороны side
+func writeLatency(w *bufio.Writer, name, side string, s *stageLatency) {
+	for stage := range s {
+		for level := range s[stage] {
+			h := &s[stage][level]
+			labels := fmt.Sprintf("side=%q,stage=%q,class=%q", side, latencyStageNames[stage], metricsClassNames[level])
+			var cumulative uint64
+			for i := 0; i < latencyBuckets; i++ {
+				cumulative += atomic.LoadUint64(&h.counts[i])
+				fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, latencyBound(i).Seconds(), cumulative)
+			}
+			cumulative += atomic.LoadUint64(&h.counts[latencyBuckets])
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+			fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, time.Duration(atomic.LoadInt64(&h.sum)).Seconds())
+			fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, cumulative)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package gametunnel
+
+import (
+	"bufio"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLatencyBucket(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 0},
+		{time.Microsecond, 0},
+		{1500 * time.Nanosecond, 1},
+		{2 * time.Microsecond, 1},
+		{3 * time.Microsecond, 2},
+		{time.Millisecond, 10},
+		{latencyBound(latencyBuckets - 1), latencyBuckets - 1},
+		{time.Minute, latencyBuckets},
+	}
+	for _, tt := range tests {
+		if got := latencyBucket(tt.d); got != tt.want {
+			t.Errorf("latencyBucket(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+		if tt.want < latencyBuckets && tt.d > latencyBound(tt.want) {
+			t.Errorf("%v above bound %v of bucket %d", tt.d, latencyBound(tt.want), tt.want)
+		}
+	}
+}
+
+func TestStageLatencySampling(t *testing.T) {
+	var s stageLatency
+	for i := 0; i < 64*200; i++ {
+		s.sample(&PriorityPacket{Priority: PriorityMedium, EnqueuedAt: time.Now().Add(-3 * time.Millisecond)})
+	}
+	h := &s[latencyQueue][PriorityMedium]
+	var total uint64
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+	}
+	// Выборка 1/64: около 200 замеров
+	if total < 100 || total > 400 {
+		t.Errorf("%d samples of %d packets", total, 64*200)
+	}
+	if h.counts[latencyBucket(3*time.Millisecond)] == 0 {
+		t.Errorf("3ms waits not in their bucket: %v", h.counts)
+	}
+
+	// Вне выборки и с неизвестным классом - без замера
+	s.since(latencyWrite, PriorityHigh, time.Time{})
+	s.since(latencyWrite, PriorityAuto, time.Now())
+	if n := atomic.LoadUint64(&s[latencyWrite][PriorityHigh].counts[0]); n != 0 {
+		t.Errorf("unsampled write recorded: %d", n)
+	}
+
+	var out strings.Builder
+	w := bufio.NewWriter(&out)
+	writeLatency(w, "lat", "server", &s)
+	w.Flush()
+	if !strings.Contains(out.String(), `lat_count{side="server",stage="queue",class="medium"} `) {
+		t.Errorf("no queue series: %s", out.String())
+	}
+	if !strings.Contains(out.String(), `lat_bucket{side="server",stage="write",class="low",le="+Inf"} 0`) {
+		t.Errorf("no empty write series")
+	}
+}
+
+func TestStageLatencyMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "latency"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+
+	// Ответ на каждую запись - ровно в одну датаграмму; за 640
+	// пакетов выборка 1/64 наверняка что-то замерит
+	for i := 0; i < 640; i++ {
+		client.Write([]byte("ping"))
+		readWithTimeout(t, server, 1500)
+		server.Write([]byte("pong"))
+		readWithTimeout(t, client, 1500)
+	}
+
+	for _, side := range []*stageLatency{&metrics.serverLatency, &metrics.clientLatency} {
+		for stage := latencyStage(0); stage < latencyStages; stage++ {
+			var total uint64
+			for level := range side[stage] {
+				for i := range side[stage][level].counts {
+					total += atomic.LoadUint64(&side[stage][level].counts[i])
+				}
+			}
+			if total == 0 {
+				t.Errorf("no %s samples", latencyStageNames[stage])
+			}
+		}
+	}
+
+	var out strings.Builder
+	if err := WriteMetrics(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "# TYPE gametunnel_stage_latency_seconds histogram") {
+		t.Error("stage latency missing from metrics")
+	}
+}
@@ -0,0 +1,128 @@
+package gametunnel
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// ====================================================================
+// Connection ID для балансировщика (QUIC-LB)
+// ====================================================================
+//
+// Балансировщик без общего состояния (ECMP, Katran, IPVS и т.п.)
+// хэширует 4-tuple, и после смены адреса клиента пакеты его сессии
+// уходят на другой сервер. С lbServerId сервер встраивает свой ID в
+// выданные Connection ID (issueConnectionIds) по схеме plaintext
+// QUIC-LB (draft-ietf-quic-load-balancers):
+//
+//	байт 0:          lbConfigId (3 бита) | длина ID - 1 (5 бит)
+//	байты 1..N:      lbServerId
+//	остальные:       случайные (не меньше lbMinNonce байт)
+//
+// Балансировщик читает ID сервера из Connection ID пакета
+// (PacketConnectionID + DecodeLBConnectionID) и отправляет пакет на
+// этот сервер при любом адресе клиента. ID, которые не разбираются
+// (Client Hello со случайным ID клиента, чужой lbConfigId, неизвестный
+// сервер), балансировщик распределяет консистентным хэшем по самому
+// Connection ID: повторы Client Hello попадают на тот же сервер.
+//
+// lbConfigId позволяет сменить нумерацию серверов без разрыва сессий:
+// балансировщик держит старую и новую конфигурации под разными
+// номерами. Номер 7 зарезервирован QUIC-LB за немаршрутизируемыми ID.
+//
+// ID сервера виден наблюдателю в каждом пакете, как и у настоящего
+// QUIC за таким балансировщиком. Сжатые заголовки (compactHeaders)
+// Connection ID не несут и с lbServerId не совместимы.
+//
+// ====================================================================
+
+const (
+	// maxLBServerID - наибольшая длина ID сервера
+	maxLBServerID = 6
+
+	// lbMinNonce - наименьшая случайная часть Connection ID
+	lbMinNonce = 4
+
+	// maxLBConfigID - наибольший номер конфигурации (7 - немаршрутизируемый)
+	maxLBConfigID = 6
+)
+
+// LBRoute - маршрут, закодированный в Connection ID
+type LBRoute struct {
+	// ConfigID - номер конфигурации балансировщика (lbConfigId)
+	ConfigID byte
+
+	// ServerID - ID сервера (lbServerId)
+	ServerID []byte
+}
+
+// validLBServerID проверяет lbServerId для Connection ID длины connIDLen
+func validLBServerID(serverID string, connIDLen uint32) error {
+	id, err := hex.DecodeString(serverID)
+	if err != nil || len(id) == 0 || len(id) > maxLBServerID {
+		return fmt.Errorf("want 1-%d hex bytes", maxLBServerID)
+	}
+	if need := 1 + len(id) + lbMinNonce; int(connIDLen) < need {
+		return fmt.Errorf("needs connectionIdLength %d or more", need)
+	}
+	return nil
+}
+
+// issueConnectionID выбирает Connection ID новой сессии: случайный
+// или с маршрутом lbServerId
+func issueConnectionID(config *Config) ([]byte, error) {
+	length := int(config.ConnectionIdLength)
+	id, err := GenerateConnectionID(length)
+	if err != nil || config.LbServerId == "" {
+		return id, err
+	}
+	serverID, err := hex.DecodeString(config.LbServerId)
+	if err != nil {
+		return nil, fmt.Errorf("lbServerId: %w", err)
+	}
+	id[0] = byte(config.LbConfigId)<<5 | byte(length-1)&0x1f
+	copy(id[1:], serverID)
+	return id, nil
+}
+
+// DecodeLBConnectionID извлекает маршрут из Connection ID
+// serverIDLen - длина ID серверов в конфигурации балансировщика.
+// ok = false - ID не маршрутизируемый: балансировщик выбирает сервер
+// хэшем Connection ID
+func DecodeLBConnectionID(connID []byte, serverIDLen int) (route LBRoute, ok bool) {
+	if len(connID) == 0 || serverIDLen <= 0 || serverIDLen > maxLBServerID {
+		return LBRoute{}, false
+	}
+	configID := connID[0] >> 5
+	if configID > maxLBConfigID || int(connID[0]&0x1f) != len(connID)-1 ||
+		len(connID) < 1+serverIDLen+lbMinNonce {
+		return LBRoute{}, false
+	}
+	return LBRoute{ConfigID: configID, ServerID: connID[1 : 1+serverIDLen]}, true
+}
+
+// PacketConnectionID возвращает Connection ID датаграммы на проводе
+// в режиме обфускации mode, без снятия обёртки
+// ok = false - датаграмма без Connection ID (сжатый заголовок, мусор)
+func PacketConnectionID(datagram []byte, mode ObfuscationMode, connIDLen int) (connID []byte, ok bool) {
+	// Заголовок GameTunnel за обёрткой DTLS
+	header := 0
+	if mode == ObfuscationMode_WEBRTC_MIMIC {
+		header = dtlsHeaderSize
+	}
+	if len(datagram) <= header || !IsQUICLike(datagram[header]) {
+		return nil, false
+	}
+	offset := header + FlagsSize + VersionSize
+	// QUIC: DCID с байтом длины перед ним
+	if mode == ObfuscationMode_QUIC_MIMIC {
+		if len(datagram) <= offset || int(datagram[offset]) != connIDLen {
+			return nil, false
+		}
+		offset++
+	}
+	if len(datagram) < offset+connIDLen {
+		return nil, false
+	}
+	return datagram[offset : offset+connIDLen], true
+}
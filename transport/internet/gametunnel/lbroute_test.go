@@ -0,0 +1,84 @@
+package gametunnel
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLBConnectionID(t *testing.T) {
+	config := DefaultConfig()
+	config.IssueConnectionIds = true
+	config.LbServerId = "0a0b"
+	config.LbConfigId = 2
+	if err := config.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := issueConnectionID(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route, ok := DecodeLBConnectionID(id, 2)
+	if !ok || route.ConfigID != 2 || !bytes.Equal(route.ServerID, []byte{0x0a, 0x0b}) {
+		t.Errorf("route %+v %v from %x", route, ok, id)
+	}
+
+	// Случайный ID клиента с немаршрутизируемым номером конфигурации
+	if _, ok := DecodeLBConnectionID([]byte{0xe7, 1, 2, 3, 4, 5, 6, 7}, 2); ok {
+		t.Error("config 7 decoded as routable")
+	}
+	// Длина в первом байте не совпадает
+	if _, ok := DecodeLBConnectionID([]byte{0x43, 1, 2, 3, 4, 5, 6, 7}, 2); ok {
+		t.Error("wrong length decoded as routable")
+	}
+
+	// Connection ID пакета виден балансировщику во всех обёртках
+	data, _ := NewKeepAlivePacket(id, 1).Marshal(config)
+	for _, mode := range []ObfuscationMode{ObfuscationMode_QUIC_MIMIC, ObfuscationMode_WEBRTC_MIMIC, ObfuscationMode_RAW} {
+		wrapped, _ := NewObfuscator(mode, config).Wrap(data)
+		got, ok := PacketConnectionID(wrapped, mode, len(id))
+		if !ok || !bytes.Equal(got, id) {
+			t.Errorf("mode %d: connection ID %x %v, want %x", mode, got, ok, id)
+		}
+	}
+	if _, ok := PacketConnectionID([]byte{0x17, 0xfe}, ObfuscationMode_WEBRTC_MIMIC, len(id)); ok {
+		t.Error("connection ID found in a truncated datagram")
+	}
+}
+
+func TestLBConfig(t *testing.T) {
+	for _, c := range []struct {
+		mutate func(*Config)
+		want   string
+	}{
+		{func(c *Config) { c.LbServerId = "zz" }, "hex bytes"},
+		{func(c *Config) { c.LbServerId = "01020304050607" }, "hex bytes"},
+		{func(c *Config) { c.LbServerId = "010203040506" }, "connectionIdLength 11"},
+		{func(c *Config) { c.LbServerId = "01" }, "requires issueConnectionIds"},
+		{func(c *Config) { c.LbServerId = "01"; c.IssueConnectionIds = true; c.CompactHeaders = true }, "compactHeaders"},
+		{func(c *Config) { c.LbConfigId = 7 }, "lbConfigId"},
+	} {
+		config := DefaultConfig()
+		c.mutate(config)
+		if err := config.Validate(); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("want %q, got %v", c.want, err)
+		}
+	}
+}
+
+func TestLBConnectionIDLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "lb"
+	config.IssueConnectionIds = true
+	config.LbServerId = "c0ffee"
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, _ := dialTestClient(t, l, &clientConfig, accepted)
+
+	route, ok := DecodeLBConnectionID(client.session().ConnectionID, 3)
+	if !ok || !bytes.Equal(route.ServerID, []byte{0xc0, 0xff, 0xee}) {
+		t.Errorf("issued ID %x does not route to the server", client.session().ConnectionID)
+	}
+}
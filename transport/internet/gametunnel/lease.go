@@ -0,0 +1,99 @@
+package gametunnel
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Аренда принятых пакетов: чтение без копии с возвратом в пул
+// ====================================================================
+//
+// Read копирует пакет в буфер читателя, ReadMultiBuffer - в буфер
+// xray. Читателю, которому копия не нужна (встраивание: игровой
+// сервер разбирает пакет на месте), ReadLease отдаёт сам буфер
+// очереди чтения в аренду:
+//
+//	lease, err := conn.ReadLease()
+//	handle(lease.Bytes())
+//	lease.Release()
+//
+// Владение буфером переходит явно: до pop - у очереди (отброшенный
+// пакет она возвращает в пул сама), после ReadLease - у читателя до
+// Release, после Release - снова у пула открытого текста (bufpool.go).
+// Bytes после Release использовать нельзя: буфер уже принадлежит
+// следующему пакету. Release без пула (PushInbound) и повторный
+// Release ничего не делают; забытый Release - не утечка, буфер
+// заберёт GC, но пул его больше не увидит.
+//
+// Очередь считает пакеты в аренде (InboundStats.Leased): растущее
+// число - читатель, забывающий Release.
+//
+// ====================================================================
+
+// PacketLease - принятый пакет, отданный читателю без копии
+// Копировать значение нельзя: Release копии вернёт буфер в пул дважды
+type PacketLease struct {
+	data []byte
+	p    inboundPacket
+	ring *inboundRing
+}
+
+// PacketLeaser - чтение пакетов в аренду
+type PacketLeaser interface {
+	ReadLease() (PacketLease, error)
+}
+
+var (
+	_ PacketLeaser = (*GameTunnelConn)(nil)
+	_ PacketLeaser = (*GameTunnelClientConn)(nil)
+)
+
+// Bytes - данные пакета; действительны до Release
+func (l *PacketLease) Bytes() []byte {
+	return l.data
+}
+
+// Release возвращает буфер пакета в пул
+func (l *PacketLease) Release() {
+	if l.ring == nil {
+		return
+	}
+	atomic.AddInt64(&l.ring.leased, -1)
+	l.p.release()
+	*l = PacketLease{}
+}
+
+// lease отдаёт в аренду хвост пакета p с off
+func (r *inboundRing) lease(p inboundPacket, off int) PacketLease {
+	atomic.AddInt64(&r.leased, 1)
+	return PacketLease{data: p.data[off:], p: p, ring: r}
+}
+
+// ReadLease ждёт следующий пакет и отдаёт его буфер в аренду
+// Хвост пакета после неполного Read - первым
+func (c *GameTunnelConn) ReadLease() (PacketLease, error) {
+	return readLease(&c.rest, c.session.inbound, c.done, &c.closed)
+}
+
+// ReadLease ждёт следующий пакет и отдаёт его буфер в аренду
+// Хвост пакета после неполного Read - первым
+func (c *GameTunnelClientConn) ReadLease() (PacketLease, error) {
+	return readLease(&c.rest, c.session().inbound, c.ctx.Done(), &c.closed)
+}
+
+// readLease - ReadLease соединения: остаток прошлого Read или пакет
+// inbound (ожидание до закрытия или cancel)
+func readLease(rest *readRest, inbound *inboundRing, cancel <-chan struct{}, closed *int32) (PacketLease, error) {
+	if p, off, ok := rest.take(); ok {
+		return inbound.lease(p, off), nil
+	}
+	if atomic.LoadInt32(closed) == 1 {
+		return PacketLease{}, io.EOF
+	}
+	p, ok := inbound.popPacket(cancel)
+	if !ok {
+		return PacketLease{}, io.EOF
+	}
+	return inbound.lease(p, 0), nil
+}
@@ -0,0 +1,74 @@
+package gametunnel
+
+import (
+	"io"
+	"testing"
+)
+
+func TestPacketLease(t *testing.T) {
+	r := newInboundRing(0)
+	r.pushOwned(append(getPlainBuf(5), "state"...))
+	var rest readRest
+	var closed int32
+
+	lease, err := readLease(&rest, r, nil, &closed)
+	if err != nil || string(lease.Bytes()) != "state" {
+		t.Fatalf("lease %q, %v", lease.Bytes(), err)
+	}
+	if got := r.stats().Leased; got != 1 {
+		t.Errorf("leased %d, want 1", got)
+	}
+	lease.Release()
+	lease.Release()
+	if got := r.stats().Leased; got != 0 || lease.Bytes() != nil {
+		t.Errorf("after Release: leased %d, bytes %q", got, lease.Bytes())
+	}
+
+	// Хвост неполного Read - первым
+	r.pushOwned(append(getPlainBuf(8), "snapshot"...))
+	r.push([]byte("delta"))
+	b := make([]byte, 4)
+	if n, _ := rest.readPacket(b, r, nil); n != 4 {
+		t.Fatalf("read %d", n)
+	}
+	for _, want := range []string{"shot", "delta"} {
+		lease, err := readLease(&rest, r, nil, &closed)
+		if err != nil || string(lease.Bytes()) != want {
+			t.Fatalf("lease %q, %v, want %q", lease.Bytes(), err, want)
+		}
+		lease.Release()
+	}
+
+	closed = 1
+	if _, err := readLease(&rest, r, nil, &closed); err != io.EOF {
+		t.Errorf("lease after close: %v", err)
+	}
+}
+
+func TestReadLeaseLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "lease"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+
+	if _, err := client.Write([]byte("to server")); err != nil {
+		t.Fatal(err)
+	}
+	lease, err := server.(*GameTunnelConn).ReadLease()
+	if err != nil || string(lease.Bytes()) != "to server" {
+		t.Fatalf("server lease %q, %v", lease.Bytes(), err)
+	}
+	lease.Release()
+
+	if _, err := server.Write([]byte("to client")); err != nil {
+		t.Fatal(err)
+	}
+	lease, err = client.ReadLease()
+	if err != nil || string(lease.Bytes()) != "to client" {
+		t.Fatalf("client lease %q, %v", lease.Bytes(), err)
+	}
+	lease.Release()
+	if got := client.session().inbound.stats().Leased; got != 0 {
+		t.Errorf("client leased %d after Release", got)
+	}
+}
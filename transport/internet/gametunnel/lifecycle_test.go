@@ -0,0 +1,98 @@
+package gametunnel
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// waitGoroutines ждёт, пока число горутин вернётся к исходному
+// Горутины рантайма и net/http могут завершаться с задержкой
+func waitGoroutines(t *testing.T, before int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= before {
+			return
+		}
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			buf = buf[:runtime.Stack(buf, true)]
+			t.Fatalf("goroutine leak: %d before, %d after\n%s", before, n, buf)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubStopWaitsForLoops(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	h := NewHub(DefaultConfig(), nil)
+	h.Start()
+
+	// cleanupInterval 30s: раньше cleanupLoop жил до следующего тика
+	done := make(chan struct{})
+	go func() {
+		h.Stop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Stop did not return")
+	}
+
+	waitGoroutines(t, before)
+}
+
+func TestListenerAndClientNoLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := DefaultConfig()
+	config.Key = "lifecycle"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	readWithTimeout(t, server, 64)
+
+	client.Close()
+	server.Close()
+	l.Close()
+
+	waitGoroutines(t, before)
+}
+
+func TestListenerCloseWithBlockedReader(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := DefaultConfig()
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Читатель сервера висит в Read - Close должен его разбудить
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 16))
+		readDone <- err
+	}()
+
+	l.Close()
+	select {
+	case err := <-readDone:
+		if err == nil {
+			t.Error("Read after listener Close should fail")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server Read not woken by Close")
+	}
+
+	client.Close()
+	waitGoroutines(t, before)
+}
@@ -5,13 +5,14 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	xnet "github.com/xtls/xray-core/common/net"
-	"github.com/xtls/xray-core/common/signal/done"
 	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
 )
 
 // ====================================================================
@@ -24,11 +25,14 @@ import (
 //
 // Жизненный цикл:
 //   1. ListenGameTunnel() создаёт UDP-сокет и Hub
-//   2. receiveLoop() читает пакеты из UDP-сокета
+//   2. receiveLoop() читает пакеты из UDP-сокета (по одному на
+//      каждый адрес, multilisten.go)
 //   3. Каждый пакет маршрутизируется через Hub.RoutePacket()
 //   4. Новые сессии передаются в addConn callback xray-core
 //   5. Данные сессий расшифровываются и передаются выше
-//   6. Close() останавливает всё
+//   6. Close() останавливает всё и дожидается горутин,
+//      DrainAndStop() - с уведомлением клиентов и сбросом
+//      очередей (drain.go)
 //
 // ====================================================================
 
@@ -37,8 +41,12 @@ type Listener struct {
 	// config - конфигурация транспорта
 	config *Config
 
-	// conn - UDP-сокет
-	conn *net.UDPConn
+	// conn - основной UDP-сокет (адрес inbound xray)
+	conn PacketConn
+
+	// sockets - все сокеты listener: основной и extraListen
+	// (multilisten.go), у каждого свой receiveLoop
+	sockets []*listenSocket
 
 	// hub - менеджер сессий
 	hub *Hub
@@ -49,8 +57,34 @@ type Listener struct {
 	// addr - адрес, на котором слушаем
 	addr net.Addr
 
-	// done - сигнал завершения
-	done *done.Instance
+	// tag - тег inbound-а xray ("" - без тега)
+	// group - группа inbound-ов с общим хабом (nil - хаб свой, policy.go)
+	tag   string
+	group *hubGroup
+
+	// accepted - новые соединения для addConn
+	// receiveLoop не ждёт xray-core: передача идёт через acceptLoop
+	accepted chan stat.Connection
+
+	// ctx / cancel - время жизни горутин listener
+	// wg - учёт горутин: Close возвращается после их завершения
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// api - HTTP API управления (nil = выключен, management.go)
+	api *http.Server
+
+	// metricsServer - сервер /metrics (nil = выключен, metrics.go)
+	metricsServer *sharedServer
+
+	// debug - отладочный сервер (nil = выключен, debug.go)
+	debug *sharedServer
+
+	// upgrade - upgradeSocket для следующего процесса (nil =
+	// выключен, upgrade.go); handedOff закрывается после передачи
+	upgrade   *upgradeServer
+	handedOff chan struct{}
 
 	// closed
 	closed int32
@@ -63,17 +97,16 @@ type Listener struct {
 type GameTunnelConn struct {
 	session *Session
 	hub     *Hub
-	config  *Config
 
-	// readBuf - буфер для чтения (данные из session.inbound)
-	readBuf    []byte
-	readOffset int
+	// rest - остатки пакетов после Read (данные из session.inbound)
+	// done - закрывается в Close, прерывает ожидание Read
+	rest readRest
+	done chan struct{}
 
 	local  net.Addr
 	remote net.Addr
 
 	closed int32
-	mu     sync.Mutex
 }
 
 // ListenGameTunnel создаёт и запускает Listener
@@ -81,10 +114,13 @@ type GameTunnelConn struct {
 func ListenGameTunnel(ctx context.Context, address xnet.Address, port xnet.Port, streamSettings *internet.MemoryStreamConfig, addConn internet.ConnHandler) (internet.Listener, error) {
 	// Получаем конфигурацию
 	config := DefaultConfig()
+	var sockopt *internet.SocketConfig
 	if streamSettings != nil {
-		if c, ok := streamSettings.ProtocolSettings.(*Config); ok {
-			config = c
+		var err error
+		if config, err = configFromStream(streamSettings.ProtocolSettings); err != nil {
+			return nil, fmt.Errorf("invalid GameTunnel config: %w", err)
 		}
+		sockopt = streamSettings.SocketSettings
 	}
 
 	// Валидируем конфиг
@@ -92,97 +128,310 @@ func ListenGameTunnel(ctx context.Context, address xnet.Address, port xnet.Port,
 		return nil, fmt.Errorf("invalid GameTunnel config: %w", err)
 	}
 
+	// Файл дампа открываем сразу: ошибка пути - ошибка конфига
+	if _, err := newPacketTap(config); err != nil {
+		return nil, err
+	}
+
+	// Фильтр источников строим до открытия сокета
+	ipFilter, err := newIPFilter(config)
+	if err != nil {
+		return nil, fmt.Errorf("build ip filter: %w", err)
+	}
+
+	// Работающий процесс передаёт сокеты и сессии (upgrade.go)
+	var prev *handoff
+	if config.UpgradeSocket != "" {
+		if prev, err = requestHandoff(config); err != nil {
+			return nil, err
+		}
+		defer prev.close()
+	}
+
 	// Создаём UDP-сокет
 	udpAddr := &net.UDPAddr{
 		IP:   address.IP(),
 		Port: int(port),
 	}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
+	// Опции сокетов: gametunnelSettings и sockopt xray (sockopt.go)
+	// Переданные сокеты (inherit.go) берутся первыми
+	opts := listenSocketOptions(config, sockopt)
+	switch {
+	case prev != nil:
+		opts.inherited = prev.sockets
+	case config.SocketActivation:
+		if opts.inherited, err = loadSystemdSockets(); err != nil {
+			return nil, err
+		}
+	}
+	var conns []PacketConn
+	switch {
+	case prev != nil && config.ListenFd > 0:
+		// Дескриптор listenFd забрал прошлый процесс: основной сокет
+		// пришёл с передачей
+		conns, err = listenGroup(ctx, prev.main, 1, opts)
+	case config.ListenFd > 0:
+		// Уже привязанный сокет вместо адреса inbound-а
+		var fdConn *net.UDPConn
+		if fdConn, err = listenFd(config.ListenFd, opts); err == nil {
+			conns = []PacketConn{fdConn}
+		}
+	default:
+		conns, err = listenGroup(ctx, udpAddr, config.ReceiveSockets, opts)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("listen UDP %s: %w", udpAddr.String(), err)
+		return nil, err
+	}
+	conn := conns[0]
+	// Порты portRange - как extraListen на адресе inbound-а (portrange.go)
+	extraListen := append(portRangeAddrs(config, udpAddr.IP.String(), conn.LocalAddr().(*net.UDPAddr).Port), config.ExtraListen...)
+	extra, err := openExtraSockets(ctx, extraListen, config, opts)
+	if err != nil {
+		for _, c := range conns {
+			c.Close()
+		}
+		return nil, err
 	}
 
-	// Устанавливаем размер буфера сокета
-	// Большой буфер важен для gaming-трафика при высокой нагрузке
-	conn.SetReadBuffer(4 * 1024 * 1024)  // 4MB read buffer
-	conn.SetWriteBuffer(4 * 1024 * 1024) // 4MB write buffer
-
-	// Создаём Hub
-	hub := NewHub(config, conn)
+	// Создаём Hub или входим в группу inbound-ов с общим хабом
+	// (policy.go): хаб группы создаёт и запускает первый inbound
+	tag := inboundTag(ctx)
+	var (
+		hub   *Hub
+		group *hubGroup
+		owner = true
+	)
+	if config.HubGroup != "" {
+		if group, owner, err = joinHubGroup(config, tag, conn); err != nil {
+			closeSockets(extra)
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		hub = group.hub
+	} else {
+		hub = NewHub(config, conn)
+		hub.ipFilter.Store(ipFilter)
+	}
+	mainDSCP := hub.dscp
+	if !owner {
+		mainDSCP = newDSCPMarker(conn, config)
+	}
+	sockets := []*listenSocket{{conn: conn, dscp: mainDSCP}}
+	for _, c := range conns[1:] {
+		sockets = append(sockets, &listenSocket{conn: c, dscp: newDSCPMarker(c, config)})
+	}
+	sockets = append(sockets, extra...)
+	for _, sock := range sockets {
+		sock.dscp.inbound = tag
+	}
+	// abort - отказ после входа в группу
+	abort := func() {
+		if group != nil {
+			group.leave(tag)
+		}
+		closeSockets(sockets)
+	}
+	if owner {
+		hub.xstats = newXrayStats(ctx, true)
+		if config.SessionSnapshotPath != "" {
+			if err := hub.SetSessionStore(NewFileSessionStore(config.SessionSnapshotPath)); err != nil {
+				abort()
+				return nil, fmt.Errorf("session snapshots: %w", err)
+			}
+		}
+		if config.ClusterListen != "" {
+			if err := hub.startGossip(config); err != nil {
+				abort()
+				return nil, fmt.Errorf("cluster: %w", err)
+			}
+		}
+		if hub.audit, err = newAuditLog(config, conn.LocalAddr()); err != nil {
+			abort()
+			return nil, err
+		}
+	}
 
 	listener := &Listener{
-		config:  config,
-		conn:    conn,
-		hub:     hub,
-		addConn: addConn,
-		addr:    conn.LocalAddr(),
-		done:    done.New(),
+		config:    config,
+		conn:      conn,
+		sockets:   sockets,
+		hub:       hub,
+		addConn:   addConn,
+		addr:      conn.LocalAddr(),
+		tag:       tag,
+		group:     group,
+		accepted:  make(chan stat.Connection, acceptBacklog),
+		handedOff: make(chan struct{}),
+	}
+	listener.ctx, listener.cancel = context.WithCancel(context.Background())
+
+	if config.ApiListen != "" {
+		listener.api, err = startManagementServer(config.ApiListen, hub, config.ApiToken)
+		if err != nil {
+			abort()
+			return nil, err
+		}
+	}
+	if listener.metricsServer, err = acquireMetricsServer(config.MetricsListen); err != nil {
+		if listener.api != nil {
+			listener.api.Close()
+		}
+		abort()
+		return nil, err
+	}
+	if listener.debug, err = acquireDebugServer(config.DebugListen); err != nil {
+		if listener.api != nil {
+			listener.api.Close()
+		}
+		listener.metricsServer.release()
+		abort()
+		return nil, err
+	}
+
+	// Устанавливаем callback для новых сессий и потоков; в группе
+	// их раздаёт listener-ам хаб группы по тегу inbound-а
+	switch {
+	case group == nil:
+		hub.onNewSession = listener.acceptSession
+		hub.onNewStream = func(_ *Session, stream *muxStream) bool {
+			return listener.acceptStream(stream)
+		}
+	case owner:
+		hub.onNewSession = group.acceptSession
+		hub.onNewStream = group.acceptStream
+	}
+	if group != nil {
+		group.attach(tag, listener)
 	}
 
-	// Устанавливаем callback для новых сессий
-	hub.onNewSession = func(session *Session) {
-		// Создаём GameTunnelConn и передаём в xray-core
-		gtConn := newGameTunnelConn(session, hub, config, listener.addr)
-		addConn(gtConn)
+	// Передача соединений в xray-core
+	listener.goLoop("accept", listener.acceptLoop)
+
+	// Восстанавливаем сессии прошлого запуска до приёма пакетов
+	// Битый или чужой снапшот не мешает старту - клиенты переподключатся
+	// Переданные сессии свежее снапшота: снапшот пишется сразу с ними
+	if prev != nil {
+		hub.restoreEntries(prev.sessions, handoffCounterGap, 0, sockets)
+		hub.SaveSnapshot()
+	} else if owner {
+		hub.RestoreSessions()
 	}
 
 	// Запускаем Hub
-	hub.Start()
+	if owner {
+		hub.Start()
+	}
 
-	// Запускаем цикл приёма пакетов
-	go listener.receiveLoop()
+	// Запускаем циклы приёма пакетов - по одному на сокет
+	for _, sock := range sockets {
+		sock := sock
+		listener.goLoop("receive", func() { listener.receiveLoop(sock) })
+	}
+
+	// Следующий процесс заберёт сокеты у нас; прошлый закрывается
+	// после подтверждения
+	if config.UpgradeSocket != "" {
+		if listener.upgrade, err = listenUpgrade(config); err != nil {
+			listener.Close()
+			return nil, err
+		}
+		go listener.serveUpgrade()
+	}
+	prev.ack()
 
 	return listener, nil
 }
 
-// receiveLoop - основной цикл приёма UDP-пакетов
-func (l *Listener) receiveLoop() {
-	buf := make([]byte, MaxPacketSize)
+// acceptBacklog - очередь соединений, ожидающих addConn
+const acceptBacklog = 128
+
+// acceptSession передаёт новую сессию в xray-core через acceptLoop
+func (l *Listener) acceptSession(session *Session) {
+	// Соединения общей сессии - её потоки (acceptStream)
+	if session.streams != nil && session.streamAccept == nil {
+		return
+	}
+
+	gtConn := newGameTunnelConn(session, l.hub, l.addr)
+	select {
+	case l.accepted <- gtConn:
+	default:
+		// xray-core не успевает разбирать новые соединения -
+		// сессию не держим, клиент переподключится
+		l.hub.RemoveSession(session.ID)
+	}
+}
+
+// acceptStream передаёт поток общей сессии в xray-core как
+// отдельное соединение (mux.go)
+// false - очередь полна, поток закрывается
+func (l *Listener) acceptStream(stream *muxStream) bool {
+	stream.local = l.addr
+	select {
+	case l.accepted <- stream:
+		return true
+	default:
+		return false
+	}
+}
+
+// goLoop запускает горутину listener с учётом в wg
+// name - pprof-метка горутины (debug.go)
+func (l *Listener) goLoop(name string, loop func()) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+		goLabeled("listener."+name, loop)
+	}()
+}
 
+// acceptLoop передаёт новые соединения в xray-core
+// addConn должен возвращаться: Close ждёт завершения acceptLoop
+func (l *Listener) acceptLoop() {
 	for {
-		if atomic.LoadInt32(&l.closed) == 1 {
+		select {
+		case <-l.ctx.Done():
 			return
+		case gtConn := <-l.accepted:
+			l.addConn(gtConn)
 		}
+	}
+}
 
-		// Читаем пакет из UDP-сокета
-		// Устанавливаем дедлайн чтобы периодически проверять closed
-		l.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, remoteAddr, err := l.conn.ReadFromUDP(buf)
+// receiveLoop - цикл приёма UDP-пакетов одного сокета
+// Завершается, когда Close закрывает сокет
+func (l *Listener) receiveLoop(sock *listenSocket) {
+	// Пачки recvmmsg (readbatch.go)
+	if size := recvBatchSize(l.hub.getConfig()); size > 1 && sock.dscp.batch != nil {
+		l.receiveBatches(sock, size)
+		return
+	}
+	for {
+		// Читаем пакет из UDP-сокета прямо в буфер пула (bufpool.go)
+		buf := getPacketBuf()
+		n, remoteAddr, err := sock.conn.ReadFromUDP(*buf)
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue // Таймаут - проверяем closed и читаем дальше
-			}
-			if atomic.LoadInt32(&l.closed) == 1 {
+			putPacketBuf(buf)
+			if l.ctx.Err() != nil {
 				return
 			}
-			// Логируем ошибку, но продолжаем работу
+			// Временная ошибка сокета - продолжаем работу
 			continue
 		}
 
 		if n == 0 {
+			putPacketBuf(buf)
 			continue
 		}
 
-		// Копируем данные (buf будет переиспользован)
-		packet := make([]byte, n)
-		copy(packet, buf[:n])
+		sock.dscp.captured(false, remoteAddr, (*buf)[:n])
 
-		// Маршрутизируем пакет через Hub
-		session, plaintext, err := l.hub.RoutePacket(packet, remoteAddr)
-		if err != nil {
-			// Невалидный пакет - игнорируем (может быть сканер или мусор)
-			continue
-		}
-
-		// Если есть расшифрованные данные - передаём в сессию
-		if session != nil && plaintext != nil && len(plaintext) > 0 {
-			if err := session.PushInbound(plaintext); err != nil {
-				// Буфер переполнен - пакет потерян
-				// Для UDP это нормальное поведение
-				continue
-			}
-		}
+		// Маршрутизируем пакет через Hub (сразу или через пул
+		// расшифровки, decrypt_pool.go); буфер вернёт в пул он
+		l.hub.receivePacket(sock.dscp, buf, n, remoteAddr)
 	}
 }
 
@@ -192,67 +441,74 @@ func (l *Listener) Addr() net.Addr {
 }
 
 // Close останавливает listener
+// Возвращается после завершения всех горутин listener и хаба
 func (l *Listener) Close() error {
 	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
 		return nil
 	}
 
-	l.hub.Stop()
-	l.conn.Close()
-	l.done.Close()
+	l.cancel()
+	l.closeUpgrade()
+	if l.api != nil {
+		l.api.Close()
+	}
+	l.metricsServer.release()
+	l.debug.release()
+	// Хаб группы останавливает последний inbound (policy.go)
+	if l.group == nil || l.group.leave(l.tag) {
+		l.hub.Stop()
+	}
+	closeSockets(l.sockets)
+	l.wg.Wait()
 
 	return nil
 }
 
+// DrainAndStop плавно останавливает listener: новые хэндшейки
+// отклоняются, очереди сессий сбрасываются (не дольше ctx),
+// клиенты получают CLOSE(ServerShutdown), затем сокет закрывается
+func (l *Listener) DrainAndStop(ctx context.Context) error {
+	if atomic.LoadInt32(&l.closed) == 1 {
+		return nil
+	}
+
+	// Хаб группы дренирует последний inbound, остальные закрывают
+	// свои сессии (policy.go)
+	if l.group != nil && l.group.size() > 1 {
+		return l.Close()
+	}
+	err := l.hub.Drain(ctx)
+	l.Close()
+	return err
+}
+
 // ====================================================================
 // GameTunnelConn - реализация net.Conn для xray-core
 // ====================================================================
 
-func newGameTunnelConn(session *Session, hub *Hub, config *Config, localAddr net.Addr) *GameTunnelConn {
+func newGameTunnelConn(session *Session, hub *Hub, localAddr net.Addr) *GameTunnelConn {
 	return &GameTunnelConn{
 		session: session,
 		hub:     hub,
-		config:  config,
 		local:   localAddr,
 		remote:  session.RemoteAddr,
+		done:    make(chan struct{}),
 	}
 }
 
 // Read читает расшифрованные данные из сессии
 // Реализует io.Reader для xray-core
 func (c *GameTunnelConn) Read(b []byte) (int, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	// Если в буфере есть остаток от прошлого чтения
-	if c.readOffset < len(c.readBuf) {
-		n := copy(b, c.readBuf[c.readOffset:])
-		c.readOffset += n
-		if c.readOffset >= len(c.readBuf) {
-			c.readBuf = nil
-			c.readOffset = 0
-		}
+	// Если есть остаток от прошлого чтения
+	if n, ok := c.rest.read(b); ok {
 		return n, nil
 	}
 
-	// Читаем новые данные из сессии
+	// Читаем новые данные из сессии; не влезшее - в остаток
 	if atomic.LoadInt32(&c.closed) == 1 {
 		return 0, io.EOF
 	}
-
-	data, ok := <-c.session.inbound
-	if !ok {
-		return 0, io.EOF
-	}
-
-	n := copy(b, data)
-	if n < len(data) {
-		// Не всё влезло - сохраняем остаток
-		c.readBuf = data
-		c.readOffset = n
-	}
-
-	return n, nil
+	return c.rest.readPacket(b, c.session.inbound, c.done)
 }
 
 // Write отправляет данные клиенту через зашифрованный туннель
@@ -262,25 +518,27 @@ func (c *GameTunnelConn) Write(b []byte) (int, error) {
 		return 0, io.ErrClosedPipe
 	}
 
-	// Разбиваем на чанки по максимальному размеру payload
-	maxPayload := int(c.config.GetMaxPayloadSize())
-	totalWritten := 0
-
-	for totalWritten < len(b) {
-		end := totalWritten + maxPayload
-		if end > len(b) {
-			end = len(b)
-		}
+	// С потоками приложения соединение - поток 0 (streams.go)
+	if c.session.streamAccept != nil {
+		return serverStreams{hub: c.hub, session: c.session}.writeStream(0, b)
+	}
 
-		chunk := b[totalWritten:end]
-		if err := c.hub.SendToSession(c.session, chunk); err != nil {
-			return totalWritten, fmt.Errorf("send to session: %w", err)
-		}
+	// Разбиваем на чанки по максимальному размеру payload
+	// (с пулом шифрования - параллельно, encrypt_pool.go)
+	maxPayload := int(c.hub.sessionConfig(c.session).GetMaxPayloadSize())
+	return c.hub.sendChunks(c.session, b, maxPayload)
+}
 
-		totalWritten = end
+// SetPriority закрепляет приоритет потока streamID: 0 - само
+// соединение, иначе принятый поток AcceptStream
+// PriorityAuto возвращает классификацию по размеру пакета
+func (c *GameTunnelConn) SetPriority(streamID uint16, level PriorityLevel) error {
+	c.session.mu.Lock()
+	defer c.session.mu.Unlock()
+	if err := checkStream(c.appStreams(), streamID); err != nil {
+		return err
 	}
-
-	return totalWritten, nil
+	return setStreamPriority(c.session.Streams, streamID, level, c.hub.getConfig().MaxStreams)
 }
 
 // Close закрывает соединение
@@ -288,18 +546,10 @@ func (c *GameTunnelConn) Close() error {
 	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
 		return nil
 	}
+	close(c.done)
 
-	// Отправляем Control Close клиенту
-	pktNum := atomic.AddUint32(&c.session.SendPacketNum, 1)
-	closePayload := []byte{0x00} // Close command
-	closePkt := NewControlPacket(c.session.ID, pktNum, closePayload)
-	data, err := closePkt.Marshal(c.config)
-	if err == nil {
-		wrapped, wErr := c.hub.obfs.Wrap(data)
-		if wErr == nil {
-			c.hub.conn.WriteToUDP(wrapped, c.session.RemoteAddr)
-		}
-	}
+	// Отправляем клиенту аутентифицированный CLOSE (drain.go)
+	c.hub.sendClose(c.session, CloseReason_NORMAL)
 
 	// Удаляем сессию
 	c.hub.RemoveSession(c.session.ID)
@@ -0,0 +1,104 @@
+package gametunnel
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/errors"
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Журнал транспорта
+// ====================================================================
+//
+// Сообщения идут в журнал xray (common/log), уровень отсекает
+// "loglevel" из конфига xray:
+//
+//   - debug   - отброшенные пакеты: мусор, сканеры, чужие и
+//     устаревшие connection ID, повторы, неразобранные ответы сервера
+//   - info    - жизнь сессий: создана, закрыта с причиной, смена
+//     адреса клиента, переподключение клиента
+//   - warning - то, что обычно значит поломку: пакеты сессии не
+//     расшифровываются (разные key), ошибки отправки в сокет
+//
+// Сообщение сессии начинается с короткого connection ID - первых
+// четырёх байт в hex, как в /sessions API управления: "[1a2b3c4d]".
+//
+// Ошибки отдельных пакетов бывают потоком - сканер или флуд дадут
+// тысячи строк в секунду. Такие сообщения идут через logThrottle: не
+// чаще одного за logThrottleInterval на вид, следующее сообщение
+// вида сообщает, сколько похожих пропущено. Встраивающий код без
+// обработчика журнала xray (standalone) ничего не платит: сообщения
+// без обработчика отбрасываются.
+//
+// ====================================================================
+
+// logThrottleInterval - не чаще одного сообщения вида за интервал
+const logThrottleInterval = time.Second
+
+// Виды сообщений об отдельных пакетах
+var (
+	// invalidPacketLog - пакет не разобран или не принят (debug)
+	invalidPacketLog logThrottle
+
+	// decryptFailureLog - пакет сессии не расшифрован (warning)
+	decryptFailureLog logThrottle
+
+	// sendErrorLog - ошибка записи в сокет (warning)
+	sendErrorLog logThrottle
+)
+
+// logf пишет сообщение уровня severity в журнал xray
+func logf(severity log.Severity, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	switch severity {
+	case log.Severity_Debug:
+		errors.LogDebug(context.Background(), msg)
+	case log.Severity_Info:
+		errors.LogInfo(context.Background(), msg)
+	case log.Severity_Warning:
+		errors.LogWarning(context.Background(), msg)
+	default:
+		errors.LogError(context.Background(), msg)
+	}
+}
+
+// sessionTag - префикс сообщений сессии: первые 4 байта connection ID
+func sessionTag(connID []byte) string {
+	return "[" + shortConnID(connID) + "]"
+}
+
+// logThrottle - ограничитель сообщений одного вида
+type logThrottle struct {
+	mu sync.Mutex
+
+	// last - время последнего записанного сообщения
+	last time.Time
+
+	// suppressed - пропущено с тех пор
+	suppressed uint64
+}
+
+// logf пишет сообщение, если с прошлого прошло logThrottleInterval,
+// иначе считает его пропущенным
+func (t *logThrottle) logf(severity log.Severity, format string, args ...any) {
+	now := time.Now()
+	t.mu.Lock()
+	if now.Sub(t.last) < logThrottleInterval {
+		t.suppressed++
+		t.mu.Unlock()
+		return
+	}
+	t.last = now
+	suppressed := t.suppressed
+	t.suppressed = 0
+	t.mu.Unlock()
+
+	if suppressed > 0 {
+		format += fmt.Sprintf(" (%d similar suppressed)", suppressed)
+	}
+	logf(severity, format, args...)
+}
@@ -0,0 +1,123 @@
+package gametunnel
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// logCapture - обработчик журнала xray, собирающий сообщения
+type logCapture struct {
+	mu       sync.Mutex
+	messages []string
+	stopped  bool
+}
+
+func (c *logCapture) Handle(msg log.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.stopped {
+		c.messages = append(c.messages, msg.String())
+	}
+}
+
+// matching - сообщения с подстрокой s
+func (c *logCapture) matching(s string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var found []string
+	for _, msg := range c.messages {
+		if strings.Contains(msg, s) {
+			found = append(found, msg)
+		}
+	}
+	return found
+}
+
+// find ждёт сообщение с подстрокой s
+func (c *logCapture) find(s string) string {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if found := c.matching(s); len(found) > 0 {
+			return found[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ""
+}
+
+// captureLog перехватывает журнал xray до конца теста
+// Обработчик не снять - после теста он молча отбрасывает сообщения
+func captureLog(t *testing.T) *logCapture {
+	capture := &logCapture{}
+	log.RegisterHandler(capture)
+	t.Cleanup(func() {
+		capture.mu.Lock()
+		capture.stopped = true
+		capture.mu.Unlock()
+	})
+	return capture
+}
+
+// resetThrottle разрешает следующее сообщение вида сразу
+func resetThrottle(t *logThrottle) {
+	t.mu.Lock()
+	t.last = time.Time{}
+	t.suppressed = 0
+	t.mu.Unlock()
+}
+
+func TestLogThrottle(t *testing.T) {
+	capture := captureLog(t)
+	var throttle logThrottle
+
+	for i := 0; i < 5; i++ {
+		throttle.logf(log.Severity_Debug, "bad packet %d", i)
+	}
+	if n := len(capture.matching("bad packet")); n != 1 {
+		t.Fatalf("%d messages within the interval, want 1", n)
+	}
+
+	throttle.mu.Lock()
+	throttle.last = time.Now().Add(-logThrottleInterval)
+	throttle.mu.Unlock()
+	throttle.logf(log.Severity_Debug, "bad packet %d", 5)
+	if msg := capture.matching("bad packet")[1]; !strings.Contains(msg, "bad packet 5 (4 similar suppressed)") || !strings.HasPrefix(msg, "[Debug]") {
+		t.Errorf("message %q", msg)
+	}
+}
+
+func TestSessionLog(t *testing.T) {
+	capture := captureLog(t)
+	resetThrottle(&invalidPacketLog)
+
+	config := DefaultConfig()
+	config.Key = "logging"
+	l, accepted := startTestListener(t, config)
+	client, _ := dialTestClient(t, l, config, accepted)
+	tag := sessionTag(client.session().ConnectionID)
+	if len(tag) != 10 {
+		t.Errorf("session tag %q", tag)
+	}
+
+	if capture.find(tag+" session from 127.0.0.1:") == "" || capture.find(tag+" connected to") == "" {
+		t.Errorf("no session messages for %s: %q", tag, capture.matching(""))
+	}
+
+	// Мусор от сканера - debug без сессии
+	scanner, _ := net.DialUDP("udp", nil, l.Addr().(*net.UDPAddr))
+	defer scanner.Close()
+	scanner.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	if msg := capture.find("dropped packet"); !strings.HasPrefix(msg, "[Debug]") {
+		t.Errorf("scanner packet: %q", msg)
+	}
+
+	l.hub.KickSession(client.session().ConnectionID)
+	if msg := capture.find(tag + " session closed: kicked"); !strings.HasPrefix(msg, "[Info]") {
+		t.Errorf("session close: %q", msg)
+	}
+}
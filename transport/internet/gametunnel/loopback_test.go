@@ -0,0 +1,126 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// ====================================================================
+// End-to-end тесты: Listener ↔ Dial через loopback UDP
+// ====================================================================
+
+// startTestListener поднимает Listener на случайном порту 127.0.0.1
+// Принятые серверные соединения складываются в канал
+func startTestListener(t testing.TB, config *Config) (*Listener, <-chan stat.Connection) {
+	t.Helper()
+
+	accepted := make(chan stat.Connection, 16)
+	streamSettings := &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: config,
+	}
+
+	l, err := ListenGameTunnel(context.Background(), xnet.LocalHostIP, 0, streamSettings,
+		func(conn stat.Connection) {
+			accepted <- conn
+		})
+	if err != nil {
+		t.Fatalf("ListenGameTunnel: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	return l.(*Listener), accepted
+}
+
+// dialTestClient подключается к Listener и дожидается серверной стороны
+func dialTestClient(t testing.TB, l *Listener, config *Config, accepted <-chan stat.Connection) (*GameTunnelClientConn, net.Conn) {
+	t.Helper()
+	return dialTestClientAt(t, l.Addr().(*net.UDPAddr), config, accepted)
+}
+
+// dialTestClientAt подключает клиента к конкретному адресу listener
+func dialTestClientAt(t testing.TB, addr *net.UDPAddr, config *Config, accepted <-chan stat.Connection) (*GameTunnelClientConn, net.Conn) {
+	t.Helper()
+
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	streamSettings := &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: config,
+	}
+
+	conn, err := Dial(context.Background(), dest, streamSettings)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	select {
+	case serverConn := <-accepted:
+		return conn.(*GameTunnelClientConn), serverConn
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not accept the session")
+	}
+	return nil, nil
+}
+
+// readWithTimeout читает из conn в отдельной горутине, чтобы тест не завис
+func readWithTimeout(t *testing.T, conn net.Conn, size int) []byte {
+	t.Helper()
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		buf := make([]byte, size)
+		n, err := conn.Read(buf)
+		ch <- result{buf[:n], err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("Read: %v", r.err)
+		}
+		return r.data
+	case <-time.After(5 * time.Second):
+		t.Fatal("read timed out")
+	}
+	return nil
+}
+
+func TestLoopbackEcho(t *testing.T) {
+	for _, mode := range []PriorityMode{PriorityMode_NONE, PriorityMode_GAMING} {
+		config := DefaultConfig()
+		config.Priority = mode
+		config.Key = "loopback"
+
+		l, accepted := startTestListener(t, config)
+		clientConfig := *config
+		client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+		request := []byte("player_move: x=1 y=2")
+		if _, err := client.Write(request); err != nil {
+			t.Fatalf("client Write: %v", err)
+		}
+		if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+			t.Errorf("mode %d: server got %q, want %q", mode, got, request)
+		}
+
+		response := []byte("world_state: tick=42")
+		if _, err := server.Write(response); err != nil {
+			t.Fatalf("server Write: %v", err)
+		}
+		if got := readWithTimeout(t, client, 2048); !bytes.Equal(got, response) {
+			t.Errorf("mode %d: client got %q, want %q", mode, got, response)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+package gametunnel
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ====================================================================
+// API управления для панели
+// ====================================================================
+//
+// SessionStats и остальная статистика рассчитаны на панель
+// управления, но раньше их никто не отдавал. API управления -
+// HTTP/JSON сервер на apiListen:
+//
+//	GET    /sessions       - ListSessions: статистика всех сессий
+//	GET    /sessions/{id}  - GetSessionStats: одна сессия (id - hex)
+//	DELETE /sessions/{id}  - KickSession: CLOSE(Kicked) и удаление
+//	GET    /stats          - HubStats: сводка по хабу (?top=N - размер
+//	                         списка сессий с наибольшим трафиком)
+//	POST   /reload         - перезагрузка конфига (reload.go)
+//	GET    /tunables       - настройки, меняемые на ходу (tunables.go)
+//	PATCH  /tunables       - сменить их: {"paddingMaxSize": 300}
+//	GET    /usage          - учёт трафика пользователей (quota.go)
+//	PUT    /users/{user}/quota - квота пользователя: {"bytes": N}
+//	DELETE /users/{user}/usage - обнулить учёт (новый период)
+//
+// Тело POST /reload - JSON в формате Config, поверх текущего
+// конфига. Передаются только меняемые поля, например
+//
+//	{"sessionRateLimit": 1000000, "allowIps": [{"name": "10.0.0.0/8"}]}
+//
+// Правила allowIps/denyIps задаются IP или CIDR; пустой список
+// снимает фильтр, отсутствующее поле оставляет текущий.
+//
+// Если задан apiToken, каждый запрос должен нести заголовок
+// "Authorization: Bearer <apiToken>". Без токена API открыт любому,
+// кто до него достучится (в том числе kick, reload и tunables),
+// поэтому Config.Validate разрешает его только на loopback.
+//
+// ====================================================================
+
+// HubStats - сводная статистика хаба
+type HubStats struct {
+	ActiveSessions int32  `json:"activeSessions"`
+	TotalSessions  uint64 `json:"totalSessions"`
+	RateLimited    uint64 `json:"rateLimited"`
+	Draining       bool   `json:"draining"`
+	DeadPeers      uint64 `json:"deadPeers"`
+	ShedSessions   uint64 `json:"shedSessions"`
+	Evicted        uint64 `json:"evictedSessions"`
+
+	// Счётчики этого хаба (hub_stats.go)
+	Handshakes         uint64       `json:"handshakes"`
+	HandshakeFailures  uint64       `json:"handshakeFailures"`
+	DecryptFailures    uint64       `json:"decryptFailures"`
+	DecryptQueueDrops  uint64       `json:"decryptQueueDrops"`
+	DegradedPaths      uint64       `json:"degradedPaths"`
+	IDCollisions       uint64       `json:"connectionIdCollisions"`
+	Migrations         uint64       `json:"migrations"`
+	MigrationsReverted uint64       `json:"migrationsReverted"`
+	InboundDrops       uint64       `json:"inboundDrops"`
+	DuplicatesSent     uint64       `json:"duplicatesSent"`
+	SendBatches        uint64       `json:"sendBatches"`
+	RecvBatches        uint64       `json:"recvBatches"`
+	InboundMemory      uint64       `json:"inboundMemoryBytes"`
+	MemoryUsage        uint64       `json:"memoryUsageBytes"`
+	MemoryBudget       uint64       `json:"memoryBudgetBytes"`
+	Traffic            TrafficStats `json:"traffic"`
+
+	// SessionsByState - число сессий по состояниям ("active": 3)
+	// SessionsByHealth - число сессий по состоянию пути ("idle": 2)
+	// TopSessions - сессии с наибольшим трафиком, по убыванию
+	SessionsByState  map[string]int   `json:"sessionsByState"`
+	SessionsByHealth map[string]int   `json:"sessionsByHealth"`
+	TopSessions      []SessionTraffic `json:"topSessions,omitempty"`
+
+	// Drops - отброшенные пакеты по причинам (drops.go)
+	Drops map[string]uint64 `json:"drops,omitempty"`
+
+	IPGuard  IPGuardStats  `json:"ipGuard"`
+	IPFilter IPFilterStats `json:"ipFilter"`
+	Puzzle   PuzzleStats   `json:"puzzle"`
+
+	// Padding - адаптивный padding хаба (padding.go)
+	Padding PaddingStats `json:"padding"`
+
+	// Cluster - сессии, переехавшие между серверами (cluster.go)
+	Cluster *ClusterStats `json:"cluster,omitempty"`
+}
+
+// GetStats возвращает сводную статистику хаба
+// с top-10 сессий по трафику (см. GetStatsTop)
+func (h *Hub) GetStats() HubStats {
+	return h.GetStatsTop(defaultStatsTopN)
+}
+
+// KickSession закрывает сессию по команде оператора:
+// клиент получает CLOSE(Kicked), сессия удаляется
+func (h *Hub) KickSession(connID []byte) error {
+	session := h.GetSession(connID)
+	if session == nil {
+		return fmt.Errorf("unknown connection ID: %x", connID)
+	}
+
+	// Ошибка отправки не мешает удалению: клиент узнает по таймауту
+	h.sendClose(session, CloseReason_KICKED)
+	h.closeSession(connID, CloseReason_KICKED)
+	return nil
+}
+
+// managementAPI - HTTP-обработчик API управления
+type managementAPI struct {
+	hub   *Hub
+	token string
+	mux   *http.ServeMux
+}
+
+// NewManagementHandler создаёт http.Handler API управления хабом
+// token - ожидаемый Bearer-токен ("" = без авторизации)
+func NewManagementHandler(h *Hub, token string) http.Handler {
+	api := &managementAPI{hub: h, token: token, mux: http.NewServeMux()}
+	api.mux.HandleFunc("GET /sessions", api.listSessions)
+	api.mux.HandleFunc("GET /sessions/{id}", api.getSession)
+	api.mux.HandleFunc("DELETE /sessions/{id}", api.kickSession)
+	api.mux.HandleFunc("GET /stats", api.stats)
+	api.mux.HandleFunc("POST /reload", api.reload)
+	api.mux.HandleFunc("GET /tunables", api.tunables)
+	api.mux.HandleFunc("PATCH /tunables", api.setTunables)
+	api.mux.HandleFunc("GET /usage", api.usage)
+	api.mux.HandleFunc("PUT /users/{user}/quota", api.setQuota)
+	api.mux.HandleFunc("DELETE /users/{user}/usage", api.resetUsage)
+	return api
+}
+
+// ServeHTTP проверяет токен и передаёт запрос маршрутизатору
+func (a *managementAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.token != "" {
+		want := "Bearer " + a.token
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(want)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *managementAPI) listSessions(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, a.hub.GetSessionStats())
+}
+
+func (a *managementAPI) getSession(w http.ResponseWriter, r *http.Request) {
+	connID, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid connection ID")
+		return
+	}
+	session := a.hub.GetSession(connID)
+	if session == nil {
+		writeAPIError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, session.GetStats())
+}
+
+func (a *managementAPI) kickSession(w http.ResponseWriter, r *http.Request) {
+	connID, err := hex.DecodeString(r.PathValue("id"))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid connection ID")
+		return
+	}
+	if err := a.hub.KickSession(connID); err != nil {
+		writeAPIError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *managementAPI) stats(w http.ResponseWriter, r *http.Request) {
+	top := defaultStatsTopN
+	if s := r.URL.Query().Get("top"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid top")
+			return
+		}
+		top = n
+	}
+	writeAPIJSON(w, http.StatusOK, a.hub.GetStatsTop(top))
+}
+
+func (a *managementAPI) reload(w http.ResponseWriter, r *http.Request) {
+	config := *a.hub.getConfig()
+
+	// Правила разбираем в новые срезы: декодер не должен писать в
+	// правила живого конфига. null/отсутствие - оставить текущие
+	config.AllowIps, config.DenyIps = nil, nil
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIBody)).Decode(&config); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid config: "+err.Error())
+		return
+	}
+	if config.AllowIps == nil {
+		config.AllowIps = a.hub.getConfig().AllowIps
+	}
+	if config.DenyIps == nil {
+		config.DenyIps = a.hub.getConfig().DenyIps
+	}
+
+	if err := a.hub.Reload(&config); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *managementAPI) tunables(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, a.hub.Tunables())
+}
+
+func (a *managementAPI) setTunables(w http.ResponseWriter, r *http.Request) {
+	var values map[string]json.RawMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIBody)).Decode(&values); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid tunables: "+err.Error())
+		return
+	}
+	if err := a.hub.SetTunables(values); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeAPIJSON(w, http.StatusOK, a.hub.Tunables())
+}
+
+func (a *managementAPI) usage(w http.ResponseWriter, r *http.Request) {
+	writeAPIJSON(w, http.StatusOK, a.hub.GetUsage())
+}
+
+func (a *managementAPI) setQuota(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Bytes uint64 `json:"bytes"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIBody)).Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid quota: "+err.Error())
+		return
+	}
+	a.hub.SetUserQuota(r.PathValue("user"), req.Bytes)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *managementAPI) resetUsage(w http.ResponseWriter, r *http.Request) {
+	if !a.hub.ResetUserUsage(r.PathValue("user")) {
+		writeAPIError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// maxAPIBody - предел размера тела запросов API
+const maxAPIBody = 1 << 20
+
+// writeAPIJSON отправляет JSON-ответ
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAPIError отправляет ошибку в формате {"error": "..."}
+func writeAPIError(w http.ResponseWriter, status int, msg string) {
+	writeAPIJSON(w, status, map[string]string{"error": msg})
+}
+
+// loopbackAddr - адрес "host:port" слушает только loopback
+// (127.0.0.0/8, ::1 или localhost); пустой хост - все интерфейсы
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// startManagementServer запускает API управления на addr
+func startManagementServer(addr string, h *Hub, token string) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("api listen %s: %w", addr, err)
+	}
+
+	server := &http.Server{
+		Handler:           NewManagementHandler(h, token),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go server.Serve(ln)
+	return server, nil
+}
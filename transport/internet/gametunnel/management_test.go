@@ -0,0 +1,103 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func apiRequest(t *testing.T, handler http.Handler, method, path, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestManagementAPI(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "api"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, _ := dialTestClient(t, l, &clientConfig, accepted)
+
+	api := NewManagementHandler(l.hub, "secret")
+
+	if rec := apiRequest(t, api, "GET", "/stats", ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Request without token: got %d, want 401", rec.Code)
+	}
+
+	rec := apiRequest(t, api, "GET", "/stats", "secret")
+	var stats HubStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || stats.ActiveSessions != 1 {
+		t.Fatalf("GET /stats: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = apiRequest(t, api, "GET", "/sessions", "secret")
+	var sessions []SessionStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &sessions); err != nil || len(sessions) != 1 {
+		t.Fatalf("GET /sessions: %d %s", rec.Code, rec.Body)
+	}
+	id := sessions[0].ConnectionID
+
+	if rec := apiRequest(t, api, "GET", "/sessions/"+id, "secret"); rec.Code != http.StatusOK {
+		t.Errorf("GET /sessions/%s: %d", id, rec.Code)
+	}
+	if rec := apiRequest(t, api, "GET", "/sessions/zz", "secret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid ID: got %d, want 400", rec.Code)
+	}
+
+	if rec := apiRequest(t, api, "DELETE", "/sessions/"+id, "secret"); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE /sessions/%s: %d %s", id, rec.Code, rec.Body)
+	}
+	if l.hub.GetActiveSessions() != 0 {
+		t.Error("Kicked session still active")
+	}
+	if rec := apiRequest(t, api, "DELETE", "/sessions/"+id, "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("Second kick: got %d, want 404", rec.Code)
+	}
+
+	// Клиент узнаёт причину закрытия
+	deadline := time.Now().Add(5 * time.Second)
+	for client.CloseReason() != CloseReason_KICKED {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not receive Kicked")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagementAPIRequiresTokenOffLoopback(t *testing.T) {
+	for _, tt := range []struct {
+		listen, token string
+		ok            bool
+	}{
+		{"127.0.0.1:9090", "", true},
+		{"[::1]:9090", "", true},
+		{"localhost:9090", "", true},
+		{"0.0.0.0:9090", "", false},
+		{":9090", "", false},
+		{"10.0.0.1:9090", "", false},
+		{"0.0.0.0:9090", "secret", true},
+	} {
+		config := DefaultConfig()
+		config.ApiListen = tt.listen
+		config.ApiToken = tt.token
+		if err := config.Validate(); (err == nil) != tt.ok {
+			t.Errorf("apiListen %q, token %q: %v", tt.listen, tt.token, err)
+		}
+	}
+
+	// Lenient выключает API, а не открывает его
+	config := DefaultConfig()
+	config.Lenient = true
+	config.ApiListen = ":9090"
+	if err := config.Validate(); err != nil || config.ApiListen != "" {
+		t.Errorf("lenient: apiListen %q, %v", config.ApiListen, err)
+	}
+}
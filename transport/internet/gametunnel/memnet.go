@@ -0,0 +1,697 @@
+package gametunnel
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"hash/fnv"
+	mrand "math/rand"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ====================================================================
+// Сеть в памяти для тестов (MemNetwork)
+// ====================================================================
+//
+// Транспорт работает с сокетом через PacketConn: это *net.UDPConn
+// или сокет MemNetwork - UDP без ядра, где потери, дубли,
+// переупорядочивание, задержка и MTU задаются тестом (MemLink).
+// Так сквозные тесты хэндшейка, миграции, переподключения и
+// поведения при потерях идут без настоящих сокетов, быстро и
+// воспроизводимо по условиям сети.
+//
+// Listener и Dial берут сокеты из сети, положенной в контекст:
+//
+//	mem := NewMemNetwork(MemLink{Loss: 0.05, Latency: 20 * time.Millisecond})
+//	defer mem.Close()
+//	ctx := ContextWithMemNetwork(context.Background(), mem)
+//	l, _ := ListenGameTunnel(ctx, xnet.LocalHostIP, 0, settings, addConn)
+//	conn, _ := Dial(ctx, dest, settings)
+//
+// Сокеты, которые клиент откроет позже (переподключение, multipath),
+// тоже открываются в этой сети. Адреса - обычные *net.UDPAddr
+// (127.0.0.1:20000 и дальше), поэтому фильтры, лимиты по IP и
+// журналы видят их как настоящие. Без SyscallConn: DSCP и sendmmsg
+// на сокетах в памяти выключены.
+//
+// Потери, дубли, джиттер и переупорядочивание берутся из генератора
+// своего у каждого направления (отправитель -> получатель) с зерном из
+// MemLink.Seed и адресов. Адреса сеть выдаёт по порядку, поэтому при
+// том же порядке отправки в направлении сценарий повторяется
+// датаграмма в датаграмму, как бы ни чередовались направления.
+//
+// Условия сети меняются на ходу (SetLink): тест рвёт связь, поднимает
+// потери или сужает MTU посреди сессии. MemConn.Rebind меняет порт
+// сокета, как NAT rebinding у клиента.
+//
+// ====================================================================
+
+// PacketConn - UDP-сокет транспорта: *net.UDPConn или сокет
+// MemNetwork
+type PacketConn interface {
+	net.Conn
+	ReadFromUDP(b []byte) (int, *net.UDPAddr, error)
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+}
+
+// MemLink - условия доставки в MemNetwork
+// Нулевое значение - идеальная сеть без задержки
+type MemLink struct {
+	// Latency - задержка в одну сторону, Jitter - случайная добавка
+	// к ней от 0 до Jitter (переупорядочивает соседние пакеты)
+	Latency time.Duration
+	Jitter  time.Duration
+
+	// Loss, Duplicate - доля потерянных и продублированных датаграмм
+	Loss      float64
+	Duplicate float64
+
+	// Reorder - доля датаграмм, задержанных ещё на ReorderDelay
+	// (0 = 5 мс): их обгоняют следующие
+	Reorder      float64
+	ReorderDelay time.Duration
+
+	// MTU - датаграммы длиннее молча теряются, как на пути с
+	// меньшим MTU (0 = без ограничения)
+	MTU int
+
+	// Bandwidth - пропускная способность пути к получателю, байт/сек:
+	// датаграммы проходят очередь узкого места по одной (0 = без
+	// ограничения). QueueLimit - предел ожидания в этой очереди,
+	// дальше - потеря, как у буфера роутера (0 = 100 мс)
+	Bandwidth  uint64
+	QueueLimit time.Duration
+
+	// Drop - дополнительный фильтр теста: true - датаграмма from -> to
+	// теряется (nil = без фильтра)
+	Drop func(from, to *net.UDPAddr, datagram []byte) bool
+
+	// Seed - зерно случайных решений сети (0 = memDefaultSeed); SetLink
+	// с тем же зерном продолжает последовательности направлений, с
+	// другим - начинает новые
+	Seed int64
+}
+
+// MemStats - счётчики датаграмм MemNetwork
+type MemStats struct {
+	Sent       uint64
+	Delivered  uint64
+	Lost       uint64
+	Duplicated uint64
+	Oversized  uint64
+
+	// Overflow - отброшено переполненной очередью узкого места
+	Overflow uint64
+
+	// Unreachable - адресату нет сокета или его очередь полна
+	Unreachable uint64
+}
+
+const (
+	// memFirstPort - первый порт, выдаваемый сокетам с портом 0
+	memFirstPort = 20000
+
+	// memInboxSize - датаграмм в очереди приёма сокета (буфер сокета)
+	memInboxSize = 4096
+
+	// memDefaultReorderDelay - задержка переупорядоченных датаграмм
+	memDefaultReorderDelay = 5 * time.Millisecond
+
+	// memDefaultQueueLimit - предел очереди узкого места по умолчанию
+	memDefaultQueueLimit = 100 * time.Millisecond
+
+	// memDefaultSeed - зерно генератора сети без MemLink.Seed
+	memDefaultSeed = 1
+)
+
+// memDatagram - датаграмма в пути
+type memDatagram struct {
+	at   time.Time
+	seq  uint64
+	to   *MemConn
+	from *net.UDPAddr
+	data []byte
+}
+
+// memQueue - датаграммы в пути по времени доставки (container/heap)
+type memQueue []*memDatagram
+
+func (q memQueue) Len() int { return len(q) }
+func (q memQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q memQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *memQueue) Push(x any)   { *q = append(*q, x.(*memDatagram)) }
+func (q *memQueue) Pop() any {
+	old := *q
+	d := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return d
+}
+
+// MemNetwork - сеть в памяти для тестов транспорта
+type MemNetwork struct {
+	mu       sync.Mutex
+	link     MemLink
+	rngs     map[memPath]*mrand.Rand
+	conns    map[string]*MemConn
+	nextPort int
+	pending  memQueue
+	seq      uint64
+
+	wake   chan struct{}
+	done   chan struct{}
+	closed sync.Once
+
+	stats MemStats
+}
+
+// NewMemNetwork создаёт сеть с условиями link
+// Close останавливает доставку и закрывает сокеты
+func NewMemNetwork(link MemLink) *MemNetwork {
+	n := &MemNetwork{
+		link:     link,
+		rngs:     make(map[memPath]*mrand.Rand),
+		conns:    make(map[string]*MemConn),
+		nextPort: memFirstPort,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go n.deliverLoop()
+	return n
+}
+
+// SetLink меняет условия доставки для следующих датаграмм
+func (n *MemNetwork) SetLink(link MemLink) {
+	n.mu.Lock()
+	if link.Seed != n.link.Seed {
+		n.rngs = make(map[memPath]*mrand.Rand)
+	}
+	n.link = link
+	n.mu.Unlock()
+}
+
+// memPath - направление доставки: отправитель -> получатель
+type memPath struct {
+	from, to string
+}
+
+// rngLocked - генератор случайных решений направления from -> to
+// Вызывается под mu
+func (n *MemNetwork) rngLocked(from, to *net.UDPAddr) *mrand.Rand {
+	path := memPath{from: from.String(), to: to.String()}
+	if rng := n.rngs[path]; rng != nil {
+		return rng
+	}
+	seed := n.link.Seed
+	if seed == 0 {
+		seed = memDefaultSeed
+	}
+	h := fnv.New64a()
+	h.Write([]byte(path.from + ">" + path.to))
+	rng := mrand.New(mrand.NewSource(seed ^ int64(h.Sum64())))
+	n.rngs[path] = rng
+	return rng
+}
+
+// Stats возвращает счётчики датаграмм
+func (n *MemNetwork) Stats() MemStats {
+	return MemStats{
+		Sent:        atomic.LoadUint64(&n.stats.Sent),
+		Delivered:   atomic.LoadUint64(&n.stats.Delivered),
+		Lost:        atomic.LoadUint64(&n.stats.Lost),
+		Duplicated:  atomic.LoadUint64(&n.stats.Duplicated),
+		Oversized:   atomic.LoadUint64(&n.stats.Oversized),
+		Overflow:    atomic.LoadUint64(&n.stats.Overflow),
+		Unreachable: atomic.LoadUint64(&n.stats.Unreachable),
+	}
+}
+
+// Close останавливает доставку и закрывает все сокеты сети
+func (n *MemNetwork) Close() error {
+	n.closed.Do(func() {
+		close(n.done)
+		n.mu.Lock()
+		conns := make([]*MemConn, 0, len(n.conns))
+		for _, c := range n.conns {
+			conns = append(conns, c)
+		}
+		n.mu.Unlock()
+		for _, c := range conns {
+			c.Close()
+		}
+	})
+	return nil
+}
+
+// ListenUDP открывает несвязанный сокет на addr (nil или порт 0 -
+// 127.0.0.1 и свободный порт)
+func (n *MemNetwork) ListenUDP(addr *net.UDPAddr) (*MemConn, error) {
+	return n.open(addr, nil)
+}
+
+// DialUDP открывает сокет, связанный с remote: Read принимает только
+// его датаграммы, Write шлёт ему
+// local - локальный адрес (nil - loopback семейства remote)
+func (n *MemNetwork) DialUDP(local, remote *net.UDPAddr) (*MemConn, error) {
+	if remote == nil {
+		return nil, fmt.Errorf("memnet dial: missing remote address")
+	}
+	return n.open(local, remote)
+}
+
+// open регистрирует сокет на local
+func (n *MemNetwork) open(local, remote *net.UDPAddr) (*MemConn, error) {
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if remote != nil && remote.IP.To4() == nil {
+		addr.IP = net.IPv6loopback
+	}
+	if local != nil {
+		if local.IP != nil {
+			addr.IP = local.IP
+		}
+		addr.Port = local.Port
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	select {
+	case <-n.done:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	if addr.Port == 0 {
+		addr.Port = n.freePortLocked(addr.IP)
+	} else if n.conns[addr.String()] != nil {
+		return nil, fmt.Errorf("memnet listen %s: %w", addr, syscall.EADDRINUSE)
+	}
+
+	c := &MemConn{
+		network:         n,
+		remote:          remote,
+		inbox:           make(chan *memDatagram, memInboxSize),
+		done:            make(chan struct{}),
+		deadlineChanged: make(chan struct{}),
+	}
+	c.local.Store(addr)
+	n.conns[addr.String()] = c
+	return c, nil
+}
+
+// freePortLocked выдаёт незанятый порт на ip. Вызывается под mu
+func (n *MemNetwork) freePortLocked(ip net.IP) int {
+	for {
+		port := n.nextPort
+		n.nextPort++
+		if n.nextPort > 65535 {
+			n.nextPort = memFirstPort
+		}
+		if n.conns[(&net.UDPAddr{IP: ip, Port: port}).String()] == nil {
+			return port
+		}
+	}
+}
+
+// lookupLocked - сокет адреса to: точный или на 0.0.0.0 / [::] того
+// же порта. Вызывается под mu
+func (n *MemNetwork) lookupLocked(to *net.UDPAddr) *MemConn {
+	if c := n.conns[to.String()]; c != nil {
+		return c
+	}
+	unspecified := net.IPv4zero
+	if to.IP.To4() == nil {
+		unspecified = net.IPv6unspecified
+	}
+	return n.conns[(&net.UDPAddr{IP: unspecified, Port: to.Port}).String()]
+}
+
+// send отправляет датаграмму from -> to по условиям сети
+func (n *MemNetwork) send(from, to *net.UDPAddr, b []byte) {
+	atomic.AddUint64(&n.stats.Sent, 1)
+
+	// Случайные решения - под mu и одинаковым числом на датаграмму:
+	// последовательность не зависит от исхода соседних
+	n.mu.Lock()
+	link := n.link
+	dst := n.lookupLocked(to)
+	rng := n.rngLocked(from, to)
+	lost := rng.Float64() < link.Loss
+	duplicated := rng.Float64() < link.Duplicate
+	delays := [2]time.Duration{link.delay(rng), link.delay(rng)}
+	n.mu.Unlock()
+
+	switch {
+	case dst == nil:
+		atomic.AddUint64(&n.stats.Unreachable, 1)
+		return
+	case link.MTU > 0 && len(b) > link.MTU:
+		atomic.AddUint64(&n.stats.Oversized, 1)
+		return
+	case lost,
+		link.Drop != nil && link.Drop(from, to, b):
+		atomic.AddUint64(&n.stats.Lost, 1)
+		return
+	}
+
+	// Узкое место: ожидание своей очереди и передача
+	var queued time.Duration
+	if link.Bandwidth > 0 {
+		var ok bool
+		if queued, ok = n.bottleneck(dst, len(b), &link); !ok {
+			atomic.AddUint64(&n.stats.Overflow, 1)
+			return
+		}
+	}
+
+	copies := 1
+	if duplicated {
+		atomic.AddUint64(&n.stats.Duplicated, 1)
+		copies = 2
+	}
+	for i := 0; i < copies; i++ {
+		n.schedule(&memDatagram{to: dst, from: from, data: append([]byte(nil), b...)}, queued+delays[i])
+	}
+}
+
+// bottleneck ставит size байт в очередь узкого места пути к dst
+// Возвращает, когда датаграмма будет передана (от текущего момента);
+// false - очередь длиннее QueueLimit, датаграмма теряется
+func (n *MemNetwork) bottleneck(dst *MemConn, size int, link *MemLink) (time.Duration, bool) {
+	limit := link.QueueLimit
+	if limit == 0 {
+		limit = memDefaultQueueLimit
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	now := time.Now()
+	start := dst.busyUntil
+	if start.Before(now) {
+		start = now
+	}
+	if start.Sub(now) > limit {
+		return 0, false
+	}
+	dst.busyUntil = start.Add(time.Duration(uint64(size) * uint64(time.Second) / link.Bandwidth))
+	return dst.busyUntil.Sub(now), true
+}
+
+// delay - задержка очередной датаграммы; rng - генератор направления
+// (под mu)
+func (l *MemLink) delay(rng *mrand.Rand) time.Duration {
+	d := l.Latency
+	jitter, reorder := rng.Int63n(int64(l.Jitter)+1), rng.Float64()
+	if l.Jitter > 0 {
+		d += time.Duration(jitter)
+	}
+	if reorder < l.Reorder {
+		if l.ReorderDelay > 0 {
+			d += l.ReorderDelay
+		} else {
+			d += memDefaultReorderDelay
+		}
+	}
+	return d
+}
+
+// schedule доставляет d сразу или ставит в очередь на delay
+func (n *MemNetwork) schedule(d *memDatagram, delay time.Duration) {
+	if delay <= 0 {
+		n.deliver(d)
+		return
+	}
+	n.mu.Lock()
+	n.seq++
+	d.at, d.seq = time.Now().Add(delay), n.seq
+	heap.Push(&n.pending, d)
+	first := n.pending[0] == d
+	n.mu.Unlock()
+	if first {
+		select {
+		case n.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// deliver кладёт датаграмму в очередь приёма сокета
+func (n *MemNetwork) deliver(d *memDatagram) {
+	select {
+	case <-d.to.done:
+		atomic.AddUint64(&n.stats.Unreachable, 1)
+	case d.to.inbox <- d:
+		atomic.AddUint64(&n.stats.Delivered, 1)
+	default:
+		// Буфер сокета полон - как в ядре, датаграмма теряется
+		atomic.AddUint64(&n.stats.Unreachable, 1)
+	}
+}
+
+// deliverLoop доставляет задержанные датаграммы в срок
+func (n *MemNetwork) deliverLoop() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		n.mu.Lock()
+		var due []*memDatagram
+		now := time.Now()
+		for len(n.pending) > 0 && !n.pending[0].at.After(now) {
+			due = append(due, heap.Pop(&n.pending).(*memDatagram))
+		}
+		wait := time.Hour
+		if len(n.pending) > 0 {
+			wait = n.pending[0].at.Sub(now)
+		}
+		n.mu.Unlock()
+
+		for _, d := range due {
+			n.deliver(d)
+		}
+
+		timer.Reset(wait)
+		select {
+		case <-n.done:
+			return
+		case <-n.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// memNetworkKey - ключ сети в контексте
+type memNetworkKey struct{}
+
+// ContextWithMemNetwork добавляет в контекст сеть, в которой
+// ListenGameTunnel и Dial откроют свои сокеты вместо настоящих UDP
+// n == nil - ctx без изменений
+func ContextWithMemNetwork(ctx context.Context, n *MemNetwork) context.Context {
+	if n == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, memNetworkKey{}, n)
+}
+
+// memNetworkFromContext - сеть из контекста (nil - настоящий UDP)
+func memNetworkFromContext(ctx context.Context) *MemNetwork {
+	n, _ := ctx.Value(memNetworkKey{}).(*MemNetwork)
+	return n
+}
+
+// MemConn - UDP-сокет MemNetwork (PacketConn и net.PacketConn)
+type MemConn struct {
+	network *MemNetwork
+	local   atomic.Pointer[net.UDPAddr]
+
+	// remote - адрес связанного сокета (nil - не связан)
+	remote *net.UDPAddr
+
+	// busyUntil - когда освободится узкое место пути к сокету
+	// (MemLink.Bandwidth), под mu сети
+	busyUntil time.Time
+
+	inbox     chan *memDatagram
+	done      chan struct{}
+	closeOnce sync.Once
+
+	// readDeadline - дедлайн чтения; deadlineChanged закрывается при
+	// его смене, чтобы прервать ждущее чтение
+	mu              sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+}
+
+// opError - ошибка операции op в виде ошибки net
+func (c *MemConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Source: c.LocalAddr(), Addr: c.remoteAddr(), Err: err}
+}
+
+// remoteAddr - адрес связанного сокета как net.Addr (nil без связи)
+func (c *MemConn) remoteAddr() net.Addr {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote
+}
+
+// ReadFromUDP принимает датаграмму
+func (c *MemConn) ReadFromUDP(b []byte) (int, *net.UDPAddr, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.readDeadline, c.deadlineChanged
+		c.mu.Unlock()
+
+		var timeout <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case d := <-c.inbox:
+			if timer != nil {
+				timer.Stop()
+			}
+			// Связанный сокет принимает только от своего адреса
+			if c.remote != nil && !(d.from.IP.Equal(c.remote.IP) && d.from.Port == c.remote.Port) {
+				continue
+			}
+			return copy(b, d.data), d.from, nil
+		case <-c.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, nil, c.opError("read", net.ErrClosed)
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-timeout:
+			return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+		}
+	}
+}
+
+// WriteToUDP отправляет датаграмму на addr
+func (c *MemConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-c.done:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+	if addr == nil {
+		return 0, c.opError("write", fmt.Errorf("missing destination address"))
+	}
+	c.network.send(c.local.Load(), addr, b)
+	return len(b), nil
+}
+
+// Read принимает датаграмму связанного сокета
+func (c *MemConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFromUDP(b)
+	return n, err
+}
+
+// Write отправляет датаграмму связанного сокета
+func (c *MemConn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, c.opError("write", fmt.Errorf("socket is not connected"))
+	}
+	return c.WriteToUDP(b, c.remote)
+}
+
+// ReadFrom - ReadFromUDP для net.PacketConn
+func (c *MemConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.ReadFromUDP(b)
+	if addr == nil {
+		return n, nil, err
+	}
+	return n, addr, err
+}
+
+// WriteTo - WriteToUDP для net.PacketConn
+func (c *MemConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, c.opError("write", fmt.Errorf("unsupported address %T", addr))
+	}
+	return c.WriteToUDP(b, udpAddr)
+}
+
+// Close закрывает сокет и освобождает адрес
+func (c *MemConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		n := c.network
+		n.mu.Lock()
+		if key := c.local.Load().String(); n.conns[key] == c {
+			delete(n.conns, key)
+		}
+		n.mu.Unlock()
+	})
+	return nil
+}
+
+// LocalAddr - адрес сокета (*net.UDPAddr)
+func (c *MemConn) LocalAddr() net.Addr {
+	return c.local.Load()
+}
+
+// RemoteAddr - адрес связанного сокета (*net.UDPAddr или nil)
+func (c *MemConn) RemoteAddr() net.Addr {
+	return c.remoteAddr()
+}
+
+// SetDeadline задаёт дедлайн чтения; запись не блокируется
+func (c *MemConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline задаёт дедлайн чтения и будит ждущее чтение
+func (c *MemConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline - запись в память не блокируется
+func (c *MemConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// Rebind переносит сокет на новый порт того же IP, как NAT
+// rebinding: датаграммы на прежний адрес больше не доходят
+func (c *MemConn) Rebind() *net.UDPAddr {
+	n := c.network
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	old := c.local.Load()
+	addr := &net.UDPAddr{IP: old.IP, Port: n.freePortLocked(old.IP)}
+	if n.conns[old.String()] == c {
+		delete(n.conns, old.String())
+	}
+	n.conns[addr.String()] = c
+	c.local.Store(addr)
+	return addr
+}
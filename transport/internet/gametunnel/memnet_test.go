@@ -0,0 +1,240 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// memPair - пара сокетов сети в памяти: a связан с b
+func memPair(t *testing.T, link MemLink) (*MemNetwork, *MemConn, *MemConn) {
+	t.Helper()
+	mem := NewMemNetwork(link)
+	t.Cleanup(func() { mem.Close() })
+	b, err := mem.ListenUDP(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := mem.DialUDP(nil, b.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mem, a, b
+}
+
+// memRead читает датаграмму с дедлайном timeout
+func memRead(c *MemConn, timeout time.Duration) ([]byte, *net.UDPAddr, error) {
+	c.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 2048)
+	n, from, err := c.ReadFromUDP(buf)
+	return buf[:n], from, err
+}
+
+func TestMemNetworkDelivery(t *testing.T) {
+	mem, a, b := memPair(t, MemLink{})
+
+	a.Write([]byte("hello"))
+	data, from, err := memRead(b, time.Second)
+	if err != nil || string(data) != "hello" || from.String() != a.LocalAddr().String() {
+		t.Fatalf("read %q from %v: %v", data, from, err)
+	}
+
+	// Ответ на связанный сокет; чужой отправитель им не принимается
+	b.WriteToUDP([]byte("reply"), a.LocalAddr().(*net.UDPAddr))
+	stranger, _ := mem.ListenUDP(nil)
+	stranger.WriteToUDP([]byte("spoof"), a.LocalAddr().(*net.UDPAddr))
+	if data, _, err := memRead(a, time.Second); err != nil || string(data) != "reply" {
+		t.Fatalf("connected read %q: %v", data, err)
+	}
+	if data, _, err := memRead(a, 50*time.Millisecond); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("connected socket accepted %q from a stranger: %v", data, err)
+	}
+
+	// Занятый адрес
+	if _, err := mem.ListenUDP(b.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Error("second socket on a bound address")
+	}
+
+	// Смена дедлайна будит ждущее чтение, Close - тоже
+	errc := make(chan error, 1)
+	go func() {
+		b.SetReadDeadline(time.Time{})
+		_, _, err := b.ReadFromUDP(make([]byte, 16))
+		errc <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	b.SetReadDeadline(time.Now())
+	if err := <-errc; !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("deadline read: %v", err)
+	}
+	b.Close()
+	if _, err := b.WriteToUDP([]byte("x"), a.LocalAddr().(*net.UDPAddr)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("write after close: %v", err)
+	}
+	a.Write([]byte("to closed"))
+	if mem.Stats().Unreachable == 0 {
+		t.Error("datagram to a closed socket not counted")
+	}
+}
+
+func TestMemNetworkLink(t *testing.T) {
+	mem, a, b := memPair(t, MemLink{MTU: 100})
+
+	a.Write(make([]byte, 101))
+	a.Write(make([]byte, 100))
+	if data, _, err := memRead(b, time.Second); err != nil || len(data) != 100 {
+		t.Fatalf("read %d bytes: %v", len(data), err)
+	}
+	if got := mem.Stats().Oversized; got != 1 {
+		t.Errorf("oversized %d, want 1", got)
+	}
+
+	mem.SetLink(MemLink{Loss: 1})
+	a.Write([]byte("lost"))
+	if _, _, err := memRead(b, 30*time.Millisecond); err == nil {
+		t.Error("datagram survived loss 1")
+	}
+
+	mem.SetLink(MemLink{Duplicate: 1})
+	a.Write([]byte("twice"))
+	for i := 0; i < 2; i++ {
+		if data, _, err := memRead(b, time.Second); err != nil || string(data) != "twice" {
+			t.Fatalf("copy %d: %q %v", i, data, err)
+		}
+	}
+
+	// Задержка и переупорядочивание: задержанная датаграмма приходит
+	// после следующей
+	mem.SetLink(MemLink{Latency: 30 * time.Millisecond})
+	start := time.Now()
+	a.Write([]byte("late"))
+	if _, _, err := memRead(b, time.Second); err != nil || time.Since(start) < 30*time.Millisecond {
+		t.Errorf("latency: read after %v, %v", time.Since(start), err)
+	}
+	mem.SetLink(MemLink{Reorder: 1, ReorderDelay: 50 * time.Millisecond})
+	a.Write([]byte("first"))
+	mem.SetLink(MemLink{})
+	a.Write([]byte("second"))
+	var order []string
+	for i := 0; i < 2; i++ {
+		data, _, err := memRead(b, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, string(data))
+	}
+	if order[0] != "second" || order[1] != "first" {
+		t.Errorf("order %v, want reordered", order)
+	}
+
+	// Узкое место 100 КБ/с: 10 датаграмм по 1000 байт идут 100 мс,
+	// сверх QueueLimit - теряются
+	mem.SetLink(MemLink{Bandwidth: 100_000, QueueLimit: 50 * time.Millisecond})
+	start = time.Now()
+	for i := 0; i < 10; i++ {
+		a.Write(make([]byte, 1000))
+	}
+	received := 0
+	for {
+		if _, _, err := memRead(b, 200*time.Millisecond); err != nil {
+			break
+		}
+		received++
+	}
+	if got := mem.Stats().Overflow; received != 6 || got != 4 {
+		t.Errorf("bottleneck: received %d, overflow %d, want 6 and 4", received, got)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Errorf("bottleneck drained in %v", elapsed)
+	}
+	mem.SetLink(MemLink{})
+
+	// Rebind: новый порт, на прежний датаграммы не доходят
+	old := a.LocalAddr().String()
+	moved := a.Rebind()
+	if moved.String() == old {
+		t.Fatal("rebind kept the address")
+	}
+	a.Write([]byte("moved"))
+	if _, from, err := memRead(b, time.Second); err != nil || from.String() != moved.String() {
+		t.Errorf("after rebind from %v: %v", from, err)
+	}
+}
+
+// startMemListener - listener в сети mem
+func startMemListener(t *testing.T, mem *MemNetwork, config *Config) (*Listener, context.Context, <-chan stat.Connection) {
+	t.Helper()
+	ctx := ContextWithMemNetwork(context.Background(), mem)
+	accepted := make(chan stat.Connection, 16)
+	settings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+	l, err := ListenGameTunnel(ctx, xnet.LocalHostIP, 0, settings, func(conn stat.Connection) { accepted <- conn })
+	if err != nil {
+		t.Fatalf("ListenGameTunnel: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.(*Listener), ctx, accepted
+}
+
+func TestMemNetworkEndToEnd(t *testing.T) {
+	// Задержка, джиттер и дубли: хэндшейк проходит, дубли отсекает
+	// защита от повторов
+	mem := NewMemNetwork(MemLink{Latency: 5 * time.Millisecond, Jitter: 2 * time.Millisecond, Duplicate: 0.3})
+	t.Cleanup(func() { mem.Close() })
+
+	config := DefaultConfig()
+	config.Key = "memnet"
+	l, ctx, accepted := startMemListener(t, mem, config)
+	if _, ok := l.conn.(*MemConn); !ok {
+		t.Fatalf("listener socket %T, want *MemConn", l.conn)
+	}
+
+	addr := l.Addr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	settings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+	conn, err := Dial(ctx, dest, settings)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	client := conn.(*GameTunnelClientConn)
+	var server net.Conn
+	select {
+	case server = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not accept the session")
+	}
+
+	for i := 0; i < 20; i++ {
+		msg := []byte{byte(i), 'g', 't'}
+		client.Write(msg)
+		if got := readWithTimeout(t, server, 1500); !bytes.Equal(got, msg) {
+			t.Fatalf("message %d: got %x", i, got)
+		}
+	}
+	if mem.Stats().Duplicated == 0 {
+		t.Error("no duplicates injected")
+	}
+
+	// NAT rebinding клиента: сервер переводит сессию на новый адрес
+	moved := client.socket().conn.(*MemConn).Rebind()
+	client.Write([]byte("after rebind"))
+	if got := readWithTimeout(t, server, 1500); string(got) != "after rebind" {
+		t.Fatalf("after rebind: %q", got)
+	}
+	session := l.hub.sessions.get(client.session().ConnectionID)
+	if session == nil || session.info().RemoteAddr != moved.String() {
+		t.Errorf("session not migrated to %v", moved)
+	}
+	server.Write([]byte("pong"))
+	if got := readWithTimeout(t, client, 1500); string(got) != "pong" {
+		t.Errorf("reply after migration: %q", got)
+	}
+}
@@ -0,0 +1,324 @@
+package gametunnel
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Метрики Prometheus
+// ====================================================================
+//
+// Реестр метрик транспорта общий для процесса: в нём счётчики
+// серверной (Hub/Listener) и клиентской (Dialer) сторон с меткой
+// side="server"/"client" и живые хабы и клиентские соединения,
+// из которых при каждом запросе снимаются gauge-метрики.
+//
+// Экспорт - текстовый формат Prometheus (version 0.0.4) без
+// внешних зависимостей:
+//   - WriteMetrics(w)  - вывести метрики в io.Writer
+//   - MetricsHandler() - http.Handler для встраивания
+//   - metricsListen    - адрес встроенного HTTP /metrics в конфиге
+//
+// RTT меряется клиентом по ответам сервера на keep-alive.
+//
+// ====================================================================
+
+// metricsSideServer / metricsSideClient - значения метки side
+const (
+	metricsSideServer = "server"
+	metricsSideClient = "client"
+)
+
+// sideCounters - счётчики одной стороны транспорта
+type sideCounters struct {
+	handshakes        uint64
+	handshakeFailures uint64
+	decryptFailures   uint64
+	bytesSent         uint64
+	bytesRecv         uint64
+	packetsSent       uint64
+	packetsRecv       uint64
+	deadPeers         uint64
+	sessionsShed      uint64
+	sessionsEvicted   uint64
+	degradedPaths     uint64
+	migrations        uint64
+	spoofedHellos     uint64
+	inboundDrops      uint64
+	duplicatesSent    uint64
+	puzzles           uint64
+	handshakeNanos    uint64
+	paddingReduced    uint64
+	paddingSaved      uint64
+	natProbes         uint64
+	natProbesLost     uint64
+	clusterAdopted    uint64
+	clusterReleased   uint64
+
+	// drops - отброшенные пакеты по причинам (drops.go)
+	drops dropCounters
+}
+
+// metricsRegistry - реестр метрик процесса
+type metricsRegistry struct {
+	server sideCounters
+	client sideCounters
+
+	hubs    map[*Hub]struct{}
+	clients map[*GameTunnelClientConn]struct{}
+
+	// rtt - RTT клиентских соединений по keep-alive
+	rtt QueueWaitHistogram
+
+	// serverLatency / clientLatency - задержки стадий отправки
+	// (latency.go)
+	serverLatency stageLatency
+	clientLatency stageLatency
+
+	mu sync.Mutex
+}
+
+// metrics - реестр метрик процесса
+var metrics = &metricsRegistry{
+	hubs:    make(map[*Hub]struct{}),
+	clients: make(map[*GameTunnelClientConn]struct{}),
+	rtt:     newQueueWaitHistogram(),
+}
+
+// registerHub / unregisterHub - хаб участвует в gauge-метриках
+func (m *metricsRegistry) registerHub(h *Hub) {
+	m.mu.Lock()
+	m.hubs[h] = struct{}{}
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) unregisterHub(h *Hub) {
+	m.mu.Lock()
+	delete(m.hubs, h)
+	m.mu.Unlock()
+}
+
+// registerClient / unregisterClient - клиентское соединение в метриках
+func (m *metricsRegistry) registerClient(c *GameTunnelClientConn) {
+	m.mu.Lock()
+	m.clients[c] = struct{}{}
+	m.mu.Unlock()
+}
+
+func (m *metricsRegistry) unregisterClient(c *GameTunnelClientConn) {
+	m.mu.Lock()
+	delete(m.clients, c)
+	m.mu.Unlock()
+}
+
+// observeRTT учитывает замер RTT клиента
+func (m *metricsRegistry) observeRTT(rtt time.Duration) {
+	m.mu.Lock()
+	m.rtt.observe(rtt)
+	m.mu.Unlock()
+}
+
+// gauges снимает мгновенные значения: сессии, глубину очередей
+// отправки и память очередей чтения
+func (m *metricsRegistry) gauges() (sessions map[string]int64, queued map[string][PriorityLevels]int64, inbound map[string]int64, rtt QueueWaitHistogram) {
+	m.mu.Lock()
+	hubs := make([]*Hub, 0, len(m.hubs))
+	for h := range m.hubs {
+		hubs = append(hubs, h)
+	}
+	clients := make([]*GameTunnelClientConn, 0, len(m.clients))
+	for c := range m.clients {
+		clients = append(clients, c)
+	}
+	rtt = m.rtt.clone()
+	m.mu.Unlock()
+
+	sessions = map[string]int64{metricsSideServer: 0, metricsSideClient: int64(len(clients))}
+	queued = map[string][PriorityLevels]int64{}
+	inbound = map[string]int64{}
+
+	var serverQueued [PriorityLevels]int64
+	for _, h := range hubs {
+		sessions[metricsSideServer] += int64(h.GetActiveSessions())
+		for _, session := range h.sessions.snapshot() {
+			lens := session.queue.classLens()
+			for level := range lens {
+				serverQueued[level] += int64(lens[level])
+			}
+			inbound[metricsSideServer] += int64(session.inbound.memory())
+		}
+	}
+	queued[metricsSideServer] = serverQueued
+
+	var clientQueued [PriorityLevels]int64
+	for _, c := range clients {
+		lens := c.queue.classLens()
+		for level := range lens {
+			clientQueued[level] += int64(lens[level])
+		}
+		if session := c.session(); session != nil {
+			inbound[metricsSideClient] += int64(session.inbound.memory())
+		}
+	}
+	queued[metricsSideClient] = clientQueued
+
+	return sessions, queued, inbound, rtt
+}
+
+// metricsClassNames - значения метки class
+var metricsClassNames = [PriorityLevels]string{"high", "medium", "low"}
+
+// WriteMetrics выводит метрики транспорта в текстовом формате Prometheus
+func WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	sides := []struct {
+		name     string
+		counters *sideCounters
+		latency  *stageLatency
+	}{
+		{metricsSideServer, &metrics.server, &metrics.serverLatency},
+		{metricsSideClient, &metrics.client, &metrics.clientLatency},
+	}
+
+	counter := func(name, help string, value func(*sideCounters) *uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, side := range sides {
+			fmt.Fprintf(bw, "%s{side=%q} %d\n", name, side.name, atomic.LoadUint64(value(side.counters)))
+		}
+	}
+	directional := func(name, help string, sent, recv func(*sideCounters) *uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, side := range sides {
+			fmt.Fprintf(bw, "%s{side=%q,direction=\"sent\"} %d\n", name, side.name, atomic.LoadUint64(sent(side.counters)))
+			fmt.Fprintf(bw, "%s{side=%q,direction=\"recv\"} %d\n", name, side.name, atomic.LoadUint64(recv(side.counters)))
+		}
+	}
+
+	counter("gametunnel_handshakes_total", "Completed handshakes.",
+		func(c *sideCounters) *uint64 { return &c.handshakes })
+	counter("gametunnel_handshake_failures_total", "Failed or rejected handshakes.",
+		func(c *sideCounters) *uint64 { return &c.handshakeFailures })
+	counter("gametunnel_decrypt_failures_total", "Packets that failed AEAD authentication.",
+		func(c *sideCounters) *uint64 { return &c.decryptFailures })
+	counter("gametunnel_dead_peers_total", "Sessions closed after unanswered liveness probes.",
+		func(c *sideCounters) *uint64 { return &c.deadPeers })
+	counter("gametunnel_sessions_shed_total", "Handshakes rejected by the session limit.",
+		func(c *sideCounters) *uint64 { return &c.sessionsShed })
+	counter("gametunnel_sessions_evicted_total", "Sessions evicted by the session limit.",
+		func(c *sideCounters) *uint64 { return &c.sessionsEvicted })
+	counter("gametunnel_degraded_paths_total", "Paths marked as black-holed by send errors or unanswered liveness checks.",
+		func(c *sideCounters) *uint64 { return &c.degradedPaths })
+	counter("gametunnel_migrations_total", "Sessions moved to a new client address or socket.",
+		func(c *sideCounters) *uint64 { return &c.migrations })
+	counter("gametunnel_spoofed_hellos_total", "Server Hellos, BUSY and RETRY ignored for a failed MAC or an unusable key.",
+		func(c *sideCounters) *uint64 { return &c.spoofedHellos })
+	counter("gametunnel_inbound_drops_total", "Decrypted packets dropped because the reader fell behind.",
+		func(c *sideCounters) *uint64 { return &c.inboundDrops })
+	counter("gametunnel_handshake_puzzles_total", "Handshake puzzles: sent in Retry by the server, solved by the client.",
+		func(c *sideCounters) *uint64 { return &c.puzzles })
+	bw.WriteString("# HELP gametunnel_handshake_cpu_seconds_total Time spent on new handshakes: key agreement on the server, puzzle solving on the client.\n# TYPE gametunnel_handshake_cpu_seconds_total counter\n")
+	for _, side := range sides {
+		fmt.Fprintf(bw, "gametunnel_handshake_cpu_seconds_total{side=%q} %g\n", side.name,
+			time.Duration(atomic.LoadUint64(&side.counters.handshakeNanos)).Seconds())
+	}
+	counter("gametunnel_duplicates_sent_total", "Second copies of High packets sent for duplicate streams.",
+		func(c *sideCounters) *uint64 { return &c.duplicatesSent })
+	counter("gametunnel_padding_reduced_total", "Data packets sent with padding reduced by paddingBackoff.",
+		func(c *sideCounters) *uint64 { return &c.paddingReduced })
+	counter("gametunnel_padding_saved_bytes_total", "Estimated padding bytes not sent because of paddingBackoff.",
+		func(c *sideCounters) *uint64 { return &c.paddingSaved })
+	counter("gametunnel_nat_probes_total", "NAT mapping probes of keepAliveTune: sent by the client, answered by the server.",
+		func(c *sideCounters) *uint64 { return &c.natProbes })
+	counter("gametunnel_nat_probes_lost_total", "NAT mapping probes whose delayed reply did not arrive.",
+		func(c *sideCounters) *uint64 { return &c.natProbesLost })
+	counter("gametunnel_cluster_adopted_total", "Sessions taken over from another cluster server by their connection ID.",
+		func(c *sideCounters) *uint64 { return &c.clusterAdopted })
+	counter("gametunnel_cluster_released_total", "Sessions handed to another cluster server that received their packets.",
+		func(c *sideCounters) *uint64 { return &c.clusterReleased })
+	bw.WriteString("# HELP gametunnel_dropped_packets_total Received packets discarded, by reason.\n# TYPE gametunnel_dropped_packets_total counter\n")
+	for _, side := range sides {
+		for reason := range side.counters.drops {
+			fmt.Fprintf(bw, "gametunnel_dropped_packets_total{side=%q,reason=%q} %d\n",
+				side.name, dropReason(reason), atomic.LoadUint64(&side.counters.drops[reason]))
+		}
+	}
+	directional("gametunnel_bytes_total", "Payload bytes by direction.",
+		func(c *sideCounters) *uint64 { return &c.bytesSent },
+		func(c *sideCounters) *uint64 { return &c.bytesRecv })
+	directional("gametunnel_packets_total", "Data packets by direction.",
+		func(c *sideCounters) *uint64 { return &c.packetsSent },
+		func(c *sideCounters) *uint64 { return &c.packetsRecv })
+
+	sessions, queued, inbound, rtt := metrics.gauges()
+
+	bw.WriteString("# HELP gametunnel_active_sessions Active sessions.\n# TYPE gametunnel_active_sessions gauge\n")
+	for _, side := range sides {
+		fmt.Fprintf(bw, "gametunnel_active_sessions{side=%q} %d\n", side.name, sessions[side.name])
+	}
+
+	bw.WriteString("# HELP gametunnel_queue_depth Packets waiting in send queues.\n# TYPE gametunnel_queue_depth gauge\n")
+	for _, side := range sides {
+		depth := queued[side.name]
+		for level, class := range metricsClassNames {
+			fmt.Fprintf(bw, "gametunnel_queue_depth{side=%q,class=%q} %d\n", side.name, class, depth[level])
+		}
+	}
+
+	bw.WriteString("# HELP gametunnel_inbound_memory_bytes Memory held by read queues: slots and queued packets.\n# TYPE gametunnel_inbound_memory_bytes gauge\n")
+	for _, side := range sides {
+		fmt.Fprintf(bw, "gametunnel_inbound_memory_bytes{side=%q} %d\n", side.name, inbound[side.name])
+	}
+
+	bw.WriteString("# HELP gametunnel_stage_latency_seconds Sampled time per packet in send stages: queue, encrypt, write.\n# TYPE gametunnel_stage_latency_seconds histogram\n")
+	for _, side := range sides {
+		writeLatency(bw, "gametunnel_stage_latency_seconds", side.name, side.latency)
+	}
+
+	writeHistogram(bw, "gametunnel_rtt_seconds", "Round-trip time measured by client keep-alives.",
+		`side="client"`, &rtt)
+
+	return bw.Flush()
+}
+
+// writeHistogram выводит гистограмму с корзинами QueueWaitBuckets
+func writeHistogram(w *bufio.Writer, name, help, labels string, h *QueueWaitHistogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative uint64
+	for i, bound := range QueueWaitBuckets {
+		cumulative += h.Counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound.Seconds(), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.Sum.Seconds())
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count)
+}
+
+// MetricsHandler возвращает http.Handler, отдающий метрики
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
+
+// metricsServers - запущенные HTTP-серверы метрик
+var metricsServers = &sharedServers{name: "metrics"}
+
+// acquireMetricsServer возвращает сервер /metrics и /clients на addr,
+// запуская его при первом держателе
+// Listener и Dialer с одинаковым metricsListen делят один сервер;
+// закрывает его последний из них (sharedServer, debug.go)
+func acquireMetricsServer(addr string) (*sharedServer, error) {
+	return metricsServers.acquire(addr, func() http.Handler {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", MetricsHandler())
+		mux.Handle("/clients", ClientStatsHandler())
+		return mux
+	})
+}
@@ -0,0 +1,149 @@
+package gametunnel
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// metricValue ищет значение строки метрики (имя с метками целиком)
+func metricValue(t *testing.T, text, series string) string {
+	t.Helper()
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, series+" ") {
+			return strings.TrimPrefix(line, series+" ")
+		}
+	}
+	t.Fatalf("series %s not found in:\n%s", series, text)
+	return ""
+}
+
+func TestMetricsLoopback(t *testing.T) {
+	handshakes := atomic.LoadUint64(&metrics.server.handshakes)
+	bytesRecv := atomic.LoadUint64(&metrics.server.bytesRecv)
+
+	config := DefaultConfig()
+	config.Key = "metrics"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	readWithTimeout(t, server, 64)
+
+	if got := atomic.LoadUint64(&metrics.server.handshakes); got != handshakes+1 {
+		t.Errorf("server handshakes: got %d, want %d", got, handshakes+1)
+	}
+	if got := atomic.LoadUint64(&metrics.server.bytesRecv); got != bytesRecv+4 {
+		t.Errorf("server bytes recv: got %d, want %d", got, bytesRecv+4)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMetrics(&buf); err != nil {
+		t.Fatalf("WriteMetrics: %v", err)
+	}
+	text := buf.String()
+	if v := metricValue(t, text, `gametunnel_active_sessions{side="server"}`); v == "0" {
+		t.Error("Active server sessions not reported")
+	}
+	if v := metricValue(t, text, `gametunnel_active_sessions{side="client"}`); v == "0" {
+		t.Error("Active client sessions not reported")
+	}
+	metricValue(t, text, `gametunnel_queue_depth{side="server",class="high"}`)
+	metricValue(t, text, `gametunnel_rtt_seconds_count{side="client"}`)
+}
+
+func TestMetricsHistogramFormat(t *testing.T) {
+	h := newQueueWaitHistogram()
+	h.observe(3 * time.Millisecond)
+	h.observe(2 * time.Second)
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeHistogram(w, "x_seconds", "test", `side="client"`, &h)
+	w.Flush()
+	text := buf.String()
+
+	if v := metricValue(t, text, `x_seconds_bucket{side="client",le="0.002"}`); v != "0" {
+		t.Errorf("le=0.002: got %s, want 0", v)
+	}
+	if v := metricValue(t, text, `x_seconds_bucket{side="client",le="0.005"}`); v != "1" {
+		t.Errorf("le=0.005: got %s, want 1", v)
+	}
+	if v := metricValue(t, text, `x_seconds_bucket{side="client",le="+Inf"}`); v != "2" {
+		t.Errorf("le=+Inf: got %s, want 2", v)
+	}
+	if v := metricValue(t, text, `x_seconds_count{side="client"}`); v != "2" {
+		t.Errorf("count: got %s, want 2", v)
+	}
+}
+
+func TestMetricsHTTPServer(t *testing.T) {
+	// Свободный порт: занимаем и сразу отпускаем
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("probe listen: %v", err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+
+	config := DefaultConfig()
+	config.Key = "metrics"
+	config.MetricsListen = addr
+	l, accepted := startTestListener(t, config)
+	client, _ := dialTestClient(t, l, config, accepted)
+
+	// Listener и клиент с тем же адресом - один сервер
+	if l.metricsServer == nil || l.metricsServer != client.metricsServer {
+		t.Error("holders of one address got different servers")
+	}
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "# TYPE gametunnel_handshakes_total counter") {
+		t.Errorf("Unexpected /metrics body:\n%s", body)
+	}
+
+	// Сервер закрывает последний держатель
+	client.Close()
+	if resp, err := http.Get("http://" + addr + "/clients"); err != nil {
+		t.Fatalf("GET /clients after client close: %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	l.Close()
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Error("metrics server still listening after its last holder closed")
+	}
+}
+
+func TestQueueOverflowNotCountedAsSent(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	session := h.newSession([]byte{1, 2, 3, 4, 5, 6, 7, 8}, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, nil)
+	// Отправителя нет: очередь только копится
+	atomic.StoreInt32(&session.ownSender, 1)
+
+	for i := 0; i <= MediumQueueSize; i++ {
+		h.queueData(session, []byte{byte(i)}, 1, PriorityMedium, false)
+	}
+
+	if session.PacketsSent != MediumQueueSize || session.BytesSent != MediumQueueSize {
+		t.Errorf("session sent %d packets / %d bytes, want %d", session.PacketsSent, session.BytesSent, MediumQueueSize)
+	}
+	if got := atomic.LoadUint64(&h.counters.packetsSent); got != MediumQueueSize {
+		t.Errorf("hub packetsSent = %d, want %d", got, MediumQueueSize)
+	}
+}
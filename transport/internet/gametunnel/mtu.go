@@ -0,0 +1,244 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Измерение MTU пути (клиент)
+// ====================================================================
+//
+// Если MTU пути меньше пакетов туннеля (LTE с вложенными туннелями,
+// PPPoE, корпоративный VPN), большие пакеты пропадают молча: ICMP
+// "fragmentation needed" до клиента часто не доходит, а фрагменты
+// режут middlebox-ы. Игра при этом живёт - её пакеты маленькие, - а
+// загрузки и голос рвутся.
+//
+// С mtuProbe клиент ставит DF на свои сокеты и сразу после хэндшейка
+// шлёт серверу пробы - sealed CONTROL нужной длины:
+//
+//	CONTROL: [0x0B][AEAD(token(8) + нули)] - MTU_PROBE, клиент -> сервер
+//	CONTROL: [0x0C][AEAD(token(8))]        - MTU_ACK, сервер -> клиент
+//
+// Размеры - лестница от длины полного пакета данных вниз; за раунд
+// уходят все ступени выше уже подтверждённой. Наибольшая дошедшая
+// проба и есть MTU пути (в байтах UDP-датаграммы); чанки Write
+// уменьшаются так, чтобы пакет данных в него помещался. Раундов
+// mtuProbeRounds - одиночная потеря пробы не занижает результат.
+//
+// Старый сервер MTU_PROBE не знает и молчит - размер остаётся из
+// конфигурации. Ответ короткий, поэтому измеряется путь к серверу;
+// обратный путь обычно симметричен, а пакеты сервера режет его MTU.
+//
+// ====================================================================
+
+const (
+	// mtuProbeTokenSize - длина токена пробы
+	mtuProbeTokenSize = 8
+
+	// mtuProbeRounds - число раундов проб
+	mtuProbeRounds = 3
+
+	// mtuProbeTimeout - ожидание ответов на раунд проб
+	mtuProbeTimeout = 400 * time.Millisecond
+
+	// mtuProbePoll - период ожидания Server Hello сессии 0-RTT
+	mtuProbePoll = 20 * time.Millisecond
+)
+
+// mtuProbeSizes - ступени проб (длина UDP-датаграммы) ниже полного
+// пакета: типичные MTU туннелей за вычетом заголовков IP/UDP
+var mtuProbeSizes = []int{1400, 1350, 1300, 1252, 1232, 1200, 1150, 1100, 1000, 900, 800, 700, 576}
+
+// mtuProber - состояние измерения MTU соединения
+type mtuProber struct {
+	mu      sync.Mutex
+	pending map[[mtuProbeTokenSize]byte]int // токен -> длина пробы
+	best    int                             // наибольшая дошедшая проба
+
+	// mtu - измеренный MTU пути (0 = ещё не измерен)
+	// payload - размер чанка под него
+	mtu     int32
+	payload int32
+}
+
+// newMTUProber создаёт измеритель MTU (nil - измерение выключено)
+func newMTUProber(config *Config) *mtuProber {
+	if !config.MtuProbe {
+		return nil
+	}
+	return &mtuProber{pending: make(map[[mtuProbeTokenSize]byte]int)}
+}
+
+// track запоминает отправленную пробу
+func (p *mtuProber) track(token [mtuProbeTokenSize]byte, size int) {
+	p.mu.Lock()
+	p.pending[token] = size
+	p.mu.Unlock()
+}
+
+// ack учитывает MTU_ACK сервера
+func (p *mtuProber) ack(body []byte) {
+	if len(body) != mtuProbeTokenSize {
+		return
+	}
+	var token [mtuProbeTokenSize]byte
+	copy(token[:], body)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	size, ok := p.pending[token]
+	if !ok {
+		return
+	}
+	delete(p.pending, token)
+	if size > p.best {
+		p.best = size
+	}
+}
+
+// confirmed возвращает наибольшую дошедшую пробу
+func (p *mtuProber) confirmed() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.best
+}
+
+// mtuProbeLadder возвращает размеры проб от ceiling вниз
+func mtuProbeLadder(ceiling int) []int {
+	sizes := []int{ceiling}
+	for _, size := range mtuProbeSizes {
+		if size < ceiling {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// wrapOverhead - сколько байт добавляет обёртка обфускации
+func wrapOverhead(obfs Obfuscator) int {
+	// Проба - не пакет соединения, в дамп её не пишем (capture.go)
+	if co, ok := obfs.(*captureObfuscator); ok {
+		obfs = co.Obfuscator
+	}
+	probe := make([]byte, 1000)
+	wrapped, err := obfs.Wrap(probe)
+	if err != nil || len(wrapped) < len(probe) {
+		return 0
+	}
+	return len(wrapped) - len(probe)
+}
+
+// mtuProbeLoop - фаза измерения MTU после хэндшейка
+func (c *GameTunnelClientConn) mtuProbeLoop() {
+	p := c.mtu
+
+	// Сессия 0-RTT: ответы придут на итоговых ключах после Server Hello
+	for c.session().pending != nil {
+		if !sleepContext(c.ctx, mtuProbePoll) {
+			return
+		}
+	}
+
+	overhead := wrapOverhead(c.obfs)
+	ceiling := int(c.config.GetMaxPayloadSize()+c.config.packetOverhead()) + overhead
+	sizes := mtuProbeLadder(ceiling)
+
+	for round := 0; round < mtuProbeRounds; round++ {
+		best := p.confirmed()
+		for _, size := range sizes {
+			if size <= best {
+				break
+			}
+			c.sendMTUProbe(size)
+		}
+		if !sleepContext(c.ctx, mtuProbeTimeout) {
+			return
+		}
+
+		best = p.confirmed()
+		if best > 0 {
+			c.applyMTU(best, overhead)
+		}
+		if best >= ceiling {
+			return
+		}
+	}
+}
+
+// sendMTUProbe отправляет MTU_PROBE длиной size байт
+// Проба идёт мимо учёта ошибок пути: EMSGSIZE на сокете с DF - это
+// ответ на вопрос, а не поломка
+func (c *GameTunnelClientConn) sendMTUProbe(size int) error {
+	var token [mtuProbeTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return fmt.Errorf("mtu probe token: %w", err)
+	}
+
+	// Длину обёртки и padding знаем только после сборки - подгоняем
+	// body, пока датаграмма не станет нужной длины
+	body := make([]byte, mtuProbeTokenSize)
+	copy(body, token[:])
+	var wrapped []byte
+	for i := 0; i < 3; i++ {
+		w, err := c.sealControl(0x0B, body)
+		if err != nil {
+			return err
+		}
+		wrapped = w
+		diff := size - len(wrapped)
+		if diff == 0 || len(body)+diff < mtuProbeTokenSize {
+			break
+		}
+		body = make([]byte, len(body)+diff)
+		copy(body, token[:])
+	}
+
+	c.mtu.track(token, len(wrapped))
+	_, err := c.socket().Write(wrapped, PriorityLow)
+	return err
+}
+
+// applyMTU уменьшает чанки под измеренный MTU пути
+func (c *GameTunnelClientConn) applyMTU(size, overhead int) {
+	payload := c.config.maxPayloadFor(uint32(size - overhead))
+	if limit := c.config.GetMaxPayloadSize(); payload > limit {
+		payload = limit
+	}
+	atomic.StoreInt32(&c.mtu.payload, int32(payload))
+	atomic.StoreInt32(&c.mtu.mtu, int32(size))
+}
+
+// maxPayload - размер чанка DATA: по измеренному MTU пути, если он есть
+func (c *GameTunnelClientConn) maxPayload() int {
+	if c.mtu != nil {
+		if n := atomic.LoadInt32(&c.mtu.payload); n > 0 {
+			return int(n)
+		}
+	}
+	return int(c.config.GetMaxPayloadSize())
+}
+
+// GetProbedMTU возвращает измеренный MTU пути (0 - не измерен)
+func (c *GameTunnelClientConn) GetProbedMTU() int {
+	if c.mtu == nil {
+		return 0
+	}
+	return int(atomic.LoadInt32(&c.mtu.mtu))
+}
+
+// answerMTUProbe отвечает на MTU_PROBE клиента его токеном
+func (h *Hub) answerMTUProbe(session *Session, pkt *Packet, data []byte) error {
+	body, err := h.openControl(session, pkt, data)
+	if err != nil {
+		return err
+	}
+	if len(body) < mtuProbeTokenSize {
+		return fmt.Errorf("mtu probe too short")
+	}
+	return h.sendSealedControl(session, 0x0C, body[:mtuProbeTokenSize])
+}
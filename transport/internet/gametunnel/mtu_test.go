@@ -0,0 +1,138 @@
+package gametunnel
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startMTURelay поднимает UDP-ретранслятор к server, который молча
+// теряет датаграммы клиента длиннее limit - как путь с малым MTU
+func startMTURelay(t *testing.T, server *net.UDPAddr, limit int) (*net.UDPAddr, *uint64) {
+	t.Helper()
+
+	front, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := net.DialUDP("udp", nil, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		front.Close()
+		back.Close()
+	})
+
+	var client atomic.Pointer[net.UDPAddr]
+	dropped := new(uint64)
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, from, err := front.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			client.Store(from)
+			if n > limit {
+				atomic.AddUint64(dropped, 1)
+				continue
+			}
+			back.Write(buf[:n])
+		}
+	}()
+	go func() {
+		buf := make([]byte, 65535)
+		for {
+			n, err := back.Read(buf)
+			if err != nil {
+				return
+			}
+			if from := client.Load(); from != nil {
+				front.WriteToUDP(buf[:n], from)
+			}
+		}
+	}()
+	return front.LocalAddr().(*net.UDPAddr), dropped
+}
+
+// waitProbedMTU ждёт окончания раунда проб MTU
+func waitProbedMTU(t *testing.T, client *GameTunnelClientConn) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.GetStats().ProbedMTU == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("path MTU not probed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return client.GetStats().ProbedMTU
+}
+
+func TestMTUProbe(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "mtu-probe"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.MtuProbe = true
+	client, _ := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Loopback пропускает полный пакет - размер чанка не меняется
+	ceiling := int(config.GetMaxPayloadSize()+config.packetOverhead()) + wrapOverhead(client.obfs)
+	if got := waitProbedMTU(t, client); got != ceiling {
+		t.Errorf("probed MTU = %d, want %d", got, ceiling)
+	}
+	if got := client.maxPayload(); got != int(config.GetMaxPayloadSize()) {
+		t.Errorf("max payload = %d on loopback", got)
+	}
+
+	// Без mtuProbe измерения нет
+	plain, _ := dialTestClient(t, l, config, accepted)
+	if plain.mtu != nil || plain.GetStats().ProbedMTU != 0 {
+		t.Error("MTU probed without mtuProbe")
+	}
+}
+
+func TestMTUProbeShrinksPayload(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "mtu-shrink"
+	// Client Hello под QUIC Initial сам длиной от 1200 байт; DTLS-запись
+	// хэндшейка короче и проходит путь в 1000 байт
+	config.Obfuscation = ObfuscationMode_WEBRTC_MIMIC
+	l, accepted := startTestListener(t, config)
+	relay, dropped := startMTURelay(t, l.Addr().(*net.UDPAddr), 1000)
+
+	clientConfig := *config
+	clientConfig.MtuProbe = true
+	client, server := dialTestClientAt(t, relay, &clientConfig, accepted)
+
+	if got := waitProbedMTU(t, client); got != 1000 {
+		t.Fatalf("probed MTU = %d, want 1000", got)
+	}
+	if atomic.LoadUint64(dropped) == 0 {
+		t.Error("relay dropped no oversized probes")
+	}
+	if got := client.maxPayload(); got >= int(config.GetMaxPayloadSize()) {
+		t.Errorf("max payload %d not reduced", got)
+	}
+
+	// Большая запись доходит целиком: каждый пакет влезает в путь
+	before := atomic.LoadUint64(dropped)
+	data := bytes.Repeat([]byte("x"), 3000)
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var got []byte
+	for len(got) < len(data) {
+		got = append(got, readWithTimeout(t, server, 2000)...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("received %d bytes, want %d", len(got), len(data))
+	}
+	if after := atomic.LoadUint64(dropped); after != before {
+		t.Errorf("%d data packets dropped after probing", after-before)
+	}
+}
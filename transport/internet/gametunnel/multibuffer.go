@@ -0,0 +1,128 @@
+package gametunnel
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/buf"
+)
+
+// ====================================================================
+// buf.Reader / buf.Writer для конвейера xray-core
+// ====================================================================
+//
+// Без этих методов xray оборачивает соединение в buf.SingleReader:
+// на каждый Read - новый 8К буфер и копия одного пакета в него, а
+// запись нескольких буферов идёт через net.Buffers по одному Write.
+//
+// ReadMultiBuffer: расшифрованный пакет копируется в буфер пула xray
+// (buf.New), а его буфер пула открытого текста (bufpool.go) сразу
+// возвращается на место. Копия - до MaxPacketSize байт, зато оба
+// пула работают по кругу: xray освобождает свой буфер после записи,
+// и занятый сервер не выделяет память на каждый пакет. Пакеты не из
+// пула (PushInbound) отдаются как unmanaged buf.Buffer без копии. За
+// вызов забирается всё, что уже ждёт в очереди чтения (до readBatch
+// пакетов); блокируется только ожидание первого. Границы пакетов
+// сохраняются: один пакет - один буфер.
+//
+// WriteMultiBuffer: каждый буфер режется на чанки по размеру payload
+// и шифруется прямо из буфера xray в буфер пула (EncryptTo), без
+// промежуточного []byte. После отправки буферы возвращаются в пул.
+//
+// ====================================================================
+
+// readBatch - пакетов в одном ReadMultiBuffer
+const readBatch = 64
+
+var (
+	_ buf.Reader = (*GameTunnelConn)(nil)
+	_ buf.Writer = (*GameTunnelConn)(nil)
+	_ buf.Reader = (*GameTunnelClientConn)(nil)
+	_ buf.Writer = (*GameTunnelClientConn)(nil)
+)
+
+// packetBuffer отдаёт хвост пакета p с off как buf.Buffer: пакет пула
+// - копией в буфер xray (сам пакет возвращается в пул), остальные -
+// без копии
+func packetBuffer(p inboundPacket, off int) *buf.Buffer {
+	data := p.data[off:]
+	if !p.owned || len(data) > buf.Size {
+		return buf.FromBytes(data[:len(data):len(data)])
+	}
+	b := buf.New()
+	b.Write(data)
+	p.release()
+	return b
+}
+
+// readQueued дописывает в mb пакеты, уже ждущие в очереди
+func readQueued(mb buf.MultiBuffer, inbound *inboundRing) buf.MultiBuffer {
+	for len(mb) < readBatch {
+		p, ok := inbound.tryPopPacket()
+		if !ok {
+			break
+		}
+		mb = append(mb, packetBuffer(p, 0))
+	}
+	return mb
+}
+
+// readMulti - ReadMultiBuffer соединения: остаток прошлого Read,
+// иначе пакет inbound (ожидание до закрытия или cancel), и всё, что
+// уже ждёт в очереди
+func readMulti(rest *readRest, inbound *inboundRing, cancel <-chan struct{}, closed *int32) (buf.MultiBuffer, error) {
+	// Остаток от прошлого Read - первым
+	if p, off, ok := rest.take(); ok {
+		return readQueued(buf.MultiBuffer{packetBuffer(p, off)}, inbound), nil
+	}
+
+	if atomic.LoadInt32(closed) == 1 {
+		return nil, io.EOF
+	}
+
+	p, ok := inbound.popPacket(cancel)
+	if !ok {
+		return nil, io.EOF
+	}
+	return readQueued(buf.MultiBuffer{packetBuffer(p, 0)}, inbound), nil
+}
+
+// ReadMultiBuffer реализует buf.Reader: пакеты из очереди в буферах xray
+func (c *GameTunnelConn) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	return readMulti(&c.rest, c.session.inbound, c.done, &c.closed)
+}
+
+// WriteMultiBuffer реализует buf.Writer; буферы mb освобождаются
+func (c *GameTunnelConn) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	defer buf.ReleaseMulti(mb)
+
+	for _, b := range mb {
+		if b.IsEmpty() {
+			continue
+		}
+		if _, err := c.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadMultiBuffer реализует buf.Reader: пакеты из очереди в буферах xray
+func (c *GameTunnelClientConn) ReadMultiBuffer() (buf.MultiBuffer, error) {
+	return readMulti(&c.rest, c.session().inbound, c.ctx.Done(), &c.closed)
+}
+
+// WriteMultiBuffer реализует buf.Writer; буферы mb освобождаются
+func (c *GameTunnelClientConn) WriteMultiBuffer(mb buf.MultiBuffer) error {
+	defer buf.ReleaseMulti(mb)
+
+	for _, b := range mb {
+		if b.IsEmpty() {
+			continue
+		}
+		if _, err := c.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package gametunnel
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/common/buf"
+)
+
+// readMultiWithTimeout читает из r, пока не наберёт size байт
+func readMultiWithTimeout(t *testing.T, r buf.Reader, size int) buf.MultiBuffer {
+	t.Helper()
+
+	ch := make(chan buf.MultiBuffer, 1)
+	go func() {
+		var all buf.MultiBuffer
+		for int(all.Len()) < size {
+			mb, err := r.ReadMultiBuffer()
+			if err != nil {
+				break
+			}
+			all = append(all, mb...)
+		}
+		ch <- all
+	}()
+	select {
+	case mb := <-ch:
+		return mb
+	case <-time.After(5 * time.Second):
+		t.Fatal("ReadMultiBuffer timed out")
+		return nil
+	}
+}
+
+func TestMultiBufferRoundTrip(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "multibuffer"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+	defer client.Close()
+
+	// xray берёт методы соединения, а не SingleReader
+	if _, ok := buf.NewReader(server).(*GameTunnelConn); !ok {
+		t.Fatal("buf.NewReader wrapped the server connection")
+	}
+	if _, ok := buf.NewWriter(client).(*GameTunnelClientConn); !ok {
+		t.Fatal("buf.NewWriter wrapped the client connection")
+	}
+
+	// Большой буфер режется на пакеты, мелкие приходят по одному
+	large := bytes.Repeat([]byte("L"), 3*int(config.GetMaxPayloadSize())+17)
+	mb := buf.MergeBytes(nil, large)
+	mb = append(mb, buf.FromBytes([]byte("tail")))
+	want := int(mb.Len())
+	if err := client.WriteMultiBuffer(mb); err != nil {
+		t.Fatalf("client WriteMultiBuffer: %v", err)
+	}
+
+	got := readMultiWithTimeout(t, server.(buf.Reader), want)
+	if int(got.Len()) != want {
+		t.Fatalf("server got %d bytes, want %d", got.Len(), want)
+	}
+	// Мелкий пакет классифицируется High и может обогнать крупные -
+	// порядок не проверяем, только границы пакетов
+	tail := false
+	for _, b := range got {
+		if b.Len() > int32(config.GetMaxPayloadSize()) {
+			t.Errorf("buffer of %d bytes exceeds a packet", b.Len())
+		}
+		tail = tail || b.String() == "tail"
+	}
+	if !tail {
+		t.Error("small buffer merged with another packet")
+	}
+	buf.ReleaseMulti(got)
+
+	// Обратно: сервер пишет буферами, клиент читает без копий
+	if err := server.(buf.Writer).WriteMultiBuffer(buf.MultiBuffer{buf.FromBytes([]byte("state"))}); err != nil {
+		t.Fatalf("server WriteMultiBuffer: %v", err)
+	}
+	got = readMultiWithTimeout(t, client, len("state"))
+	if got.String() != "state" {
+		t.Errorf("client got %q", got)
+	}
+}
+
+func TestMultiBufferAfterPartialRead(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "multibuffer"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+	defer client.Close()
+
+	server.Write([]byte("0123456789"))
+	if got := readWithTimeout(t, client, 4); string(got) != "0123" {
+		t.Fatalf("Read %q", got)
+	}
+
+	// Остаток Read - первым, потом очередь
+	got := readMultiWithTimeout(t, client, 6)
+	if got.String() != "456789" {
+		t.Errorf("ReadMultiBuffer %q", got)
+	}
+
+	client.Close()
+	if _, err := client.ReadMultiBuffer(); err != io.EOF {
+		t.Errorf("ReadMultiBuffer after Close: %v", err)
+	}
+}
+
+func TestInboundRingTryPop(t *testing.T) {
+	r := newInboundRing(0)
+	if _, ok := r.tryPop(); ok {
+		t.Fatal("tryPop from an empty ring")
+	}
+	r.push([]byte("a"))
+	r.push([]byte("b"))
+	mb := readQueued(nil, r)
+	if mb.String() != "ab" || len(mb) != 2 {
+		t.Errorf("readQueued %q in %d buffers", mb, len(mb))
+	}
+}
@@ -0,0 +1,158 @@
+package gametunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// ====================================================================
+// Несколько адресов и портов на одном Hub
+// ====================================================================
+//
+// Listener слушает адрес inbound xray и дополнительно каждый адрес
+// из extraListen ("0.0.0.0:8443", "[::]:443"). Все сокеты питают
+// один Hub: сессия не привязана к сокету, клиент может слать
+// пакеты на любой из адресов сервера (port hopping, переход
+// между IPv4 и IPv6).
+//
+// Ответ уходит через сокет, на который клиент писал последним
+// (Session.sock): иначе адрес источника не совпадёт с тем, куда
+// клиент отправлял, и NAT/файрвол клиента отбросят пакет.
+//
+// Один сокет и одна горутина приёма упираются в одно ядро. С
+// receiveSockets = N каждый адрес открывается N сокетами с
+// SO_REUSEPORT, у каждого свой receiveLoop. Ядро раскладывает
+// датаграммы по сокетам по хэшу адресов, так что пакеты одного
+// клиента всегда приходят в один сокет и не переупорядочиваются.
+// Хаб с шардированной таблицей сессий (session_map.go) не
+// становится общим узким местом. N имеет смысл брать порядка числа
+// ядер; без поддержки SO_REUSEPORT (Windows) открывается один сокет.
+// SO_REUSEPORT выставляет системный listener xray
+// (internet.ListenSystemPacket) на каждом сокете.
+//
+// ====================================================================
+
+// listenSocket - один UDP-сокет listener с DSCP-маркером
+type listenSocket struct {
+	conn PacketConn
+	dscp *dscpMarker
+}
+
+// listenUDP открывает UDP-сокет сервера на addr через
+// internet.ListenSystemPacket (с SO_REUSEPORT, где он есть) и
+// выставляет на нём опции opts (sockopt.go)
+func listenUDP(addr *net.UDPAddr, opts *socketOptions) (*net.UDPConn, error) {
+	if err := opts.checkInterface(); err != nil {
+		return nil, err
+	}
+	pc, err := internet.ListenSystemPacket(context.Background(), addr, opts.system)
+	if err != nil {
+		return nil, fmt.Errorf("listen UDP %s: %w", addr, err)
+	}
+	conn, ok := pc.(*net.UDPConn)
+	if !ok {
+		pc.Close()
+		return nil, fmt.Errorf("listen UDP %s: got %T", addr, pc)
+	}
+	if err := opts.apply(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("listen UDP %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// listenUDPGroup открывает n сокетов на addr
+// Первый сокет фиксирует порт (addr может быть с портом 0),
+// остальные привязываются к нему же. Если второй сокет на тот же
+// порт не открывается (нет SO_REUSEPORT), работаем одним
+func listenUDPGroup(addr *net.UDPAddr, n uint32, opts *socketOptions) ([]PacketConn, error) {
+	first, err := listenUDP(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	conns := []PacketConn{first}
+	bound := first.LocalAddr().(*net.UDPAddr)
+	for i := uint32(1); i < n; i++ {
+		conn, err := listenUDP(bound, opts)
+		if err != nil && i == 1 {
+			// Платформа без SO_REUSEPORT
+			return conns, nil
+		}
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+// listenGroup открывает сокеты адреса addr: в сети MemNetwork из ctx
+// (memnet.go) - один сокет, иначе listenUDPGroup
+func listenGroup(ctx context.Context, addr *net.UDPAddr, n uint32, opts *socketOptions) ([]PacketConn, error) {
+	if mem := memNetworkFromContext(ctx); mem != nil {
+		conn, err := mem.ListenUDP(addr)
+		if err != nil {
+			return nil, err
+		}
+		return []PacketConn{conn}, nil
+	}
+	if opts.inherited != nil {
+		conns, err := opts.inherited.adopt(addr, opts)
+		if err != nil || len(conns) > 0 {
+			return conns, err
+		}
+	}
+	return listenUDPGroup(addr, n, opts)
+}
+
+// openExtraSockets открывает сокеты для адресов extraListen
+// При ошибке уже открытые сокеты закрываются
+func openExtraSockets(ctx context.Context, addrs []string, config *Config, opts *socketOptions) ([]*listenSocket, error) {
+	sockets := make([]*listenSocket, 0, len(addrs))
+	for _, addr := range addrs {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			closeSockets(sockets)
+			return nil, fmt.Errorf("extraListen %q: %w", addr, err)
+		}
+		conns, err := listenGroup(ctx, udpAddr, config.ReceiveSockets, opts)
+		if err != nil {
+			closeSockets(sockets)
+			return nil, err
+		}
+		for _, conn := range conns {
+			sockets = append(sockets, &listenSocket{conn: conn, dscp: newDSCPMarker(conn, config)})
+		}
+	}
+	return sockets, nil
+}
+
+// closeSockets закрывает сокеты
+func closeSockets(sockets []*listenSocket) {
+	for _, s := range sockets {
+		s.conn.Close()
+	}
+}
+
+// Addrs возвращает все адреса, на которых слушает listener
+// Сокеты SO_REUSEPORT одного адреса дают одну запись
+func (l *Listener) Addrs() []net.Addr {
+	seen := make(map[string]bool, len(l.sockets))
+	addrs := make([]net.Addr, 0, len(l.sockets))
+	for _, s := range l.sockets {
+		addr := s.conn.LocalAddr()
+		if seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
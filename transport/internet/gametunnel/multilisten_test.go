@@ -0,0 +1,134 @@
+package gametunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestListenerExtraAddresses(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "multi"
+	config.ExtraListen = []string{"127.0.0.1:0", "127.0.0.1:0"}
+	l, accepted := startTestListener(t, config)
+
+	addrs := l.Addrs()
+	if len(addrs) != 3 {
+		t.Fatalf("Addrs: got %d, want 3", len(addrs))
+	}
+
+	// Клиент на подключённом UDP-сокете принимает ответы только с
+	// адреса, куда писал: эхо проходит, лишь если хаб отвечает
+	// через сокет extraListen
+	for i, addr := range addrs {
+		clientConfig := *config
+		client, server := dialTestClientAt(t, addr.(*net.UDPAddr), &clientConfig, accepted)
+
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatalf("addr %d: client Write: %v", i, err)
+		}
+		if got := readWithTimeout(t, server, 64); string(got) != "ping" {
+			t.Fatalf("addr %d: server got %q", i, got)
+		}
+		if _, err := server.Write([]byte("pong")); err != nil {
+			t.Fatalf("addr %d: server Write: %v", i, err)
+		}
+		if got := readWithTimeout(t, client, 64); string(got) != "pong" {
+			t.Fatalf("addr %d: client got %q", i, got)
+		}
+
+		session := server.(*GameTunnelConn).session
+		session.mu.RLock()
+		sock := session.sock
+		session.mu.RUnlock()
+		if sock != l.sockets[i].dscp {
+			t.Errorf("addr %d: session bound to wrong socket", i)
+		}
+	}
+
+	if n := l.hub.GetActiveSessions(); n != 3 {
+		t.Errorf("Active sessions in shared hub: got %d, want 3", n)
+	}
+}
+
+func TestListenerExtraAddressInvalid(t *testing.T) {
+	config := DefaultConfig()
+	config.ExtraListen = []string{"not-an-address"}
+	if _, err := openExtraSockets(context.Background(), config.ExtraListen, config, listenSocketOptions(config, nil)); err == nil {
+		t.Error("Invalid extraListen accepted")
+	}
+}
+
+func TestListenerReceiveSockets(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "reuseport"
+	config.ReceiveSockets = 4
+	l, accepted := startTestListener(t, config)
+
+	if len(l.Addrs()) != 1 {
+		t.Fatalf("Addrs: got %v, want one address", l.Addrs())
+	}
+	if runtime.GOOS == "linux" && len(l.sockets) != 4 {
+		t.Fatalf("Sockets: got %d, want 4", len(l.sockets))
+	}
+
+	// Клиенты с разными портами ядро раскладывает по разным сокетам;
+	// каждый должен получать ответы через свой
+	for i := 0; i < 8; i++ {
+		clientConfig := *config
+		client, server := dialTestClient(t, l, &clientConfig, accepted)
+		if _, err := server.Write([]byte("hello")); err != nil {
+			t.Fatalf("client %d: server Write: %v", i, err)
+		}
+		if got := readWithTimeout(t, client, 64); string(got) != "hello" {
+			t.Fatalf("client %d: got %q", i, got)
+		}
+	}
+}
+
+// BenchmarkListenerReceiveSockets - приём данных от 16 клиентов
+// одним сокетом и группой SO_REUSEPORT. Выигрыш виден только на
+// машине с несколькими ядрами (go test -bench ReceiveSockets -cpu 8)
+func BenchmarkListenerReceiveSockets(b *testing.B) {
+	for _, n := range []uint32{1, 4, 8} {
+		b.Run(fmt.Sprintf("sockets=%d", n), func(b *testing.B) {
+			config := DefaultConfig()
+			config.Key = "bench"
+			config.ReceiveSockets = n
+			l, accepted := startTestListener(b, config)
+
+			const clients = 16
+			conns := make([]*GameTunnelClientConn, clients)
+			for i := range conns {
+				clientConfig := *config
+				conns[i], _ = dialTestClient(b, l, &clientConfig, accepted)
+			}
+
+			payload := make([]byte, 200)
+			before := atomic.LoadUint64(&metrics.server.packetsRecv)
+			b.ResetTimer()
+
+			var wg sync.WaitGroup
+			for _, c := range conns {
+				wg.Add(1)
+				go func(c *GameTunnelClientConn) {
+					defer wg.Done()
+					for i := 0; i < b.N/clients+1; i++ {
+						c.Write(payload)
+					}
+				}(c)
+			}
+			wg.Wait()
+			time.Sleep(50 * time.Millisecond)
+
+			b.StopTimer()
+			received := atomic.LoadUint64(&metrics.server.packetsRecv) - before
+			b.ReportMetric(float64(received)/b.Elapsed().Seconds(), "pkts/s")
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Multipath: Wi-Fi и сотовая сеть одновременно
+// ====================================================================
+//
+// С multipath = true клиент держит к серверу два UDP-пути одной
+// сессии: основной (сокет хэндшейка) и дополнительный - сокет,
+// привязанный к multipathLocalAddr (адрес сотового интерфейса;
+// пусто - адрес выбирает ОС, пути различаются только портом и
+// маппингом NAT).
+//
+// Дополнительный путь регистрируется на сервере командой:
+//
+//	клиент -> сервер: CONTROL [0x09][AEAD(token)]  - PATH_ADD
+//	сервер -> клиент: CONTROL [0x08][AEAD(token)]  - PATH_RESPONSE
+//
+// PATH_ADD не переносит сессию (в отличие от любого другого пакета
+// с нового адреса): сервер запоминает адрес как ещё один путь
+// клиента, до maxSessionPaths штук.
+//
+// Раз в multipathProbeInterval клиент шлёт по каждому пути
+// PATH_CHALLENGE (roaming.go) и по ответам считает RTT (EWMA) и
+// долю потерь. Планировщик:
+//
+//   - High - копия по обоим путям: доходит первой та, что быстрее,
+//     вторую отбрасывает anti-replay окно получателя;
+//   - Medium/Low - путям по очереди, пропорционально
+//     (1 - потери) / RTT (smooth weighted round-robin);
+//   - путь без ответов 3 × multipathProbeInterval или с потерями
+//     выше multipathMaxLoss трафика не получает, пока не оживёт.
+//
+// Сервер так же копирует High на все живые пути клиента, а
+// остальное отправляет по пути, с которого клиент писал последним.
+//
+// ====================================================================
+
+const (
+	// maxSessionPaths - дополнительных путей клиента на сессию (сервер)
+	maxSessionPaths = 3
+
+	// multipathProbeInterval - период проверки путей клиентом
+	multipathProbeInterval = time.Second
+
+	// multipathPathIdle - молчание пути, после которого сервер не шлёт по нему
+	multipathPathIdle = 5 * multipathProbeInterval
+
+	// multipathMaxLoss - доля потерь, после которой путь не используется
+	multipathMaxLoss = 0.5
+)
+
+// Индексы путей клиента
+const (
+	pathPrimary   = 0
+	pathSecondary = 1
+	pathCount     = 2
+)
+
+// pathNames - имена путей в PathStats
+var pathNames = [pathCount]string{"primary", "secondary"}
+
+// controlCommand возвращает код команды CONTROL-пакета (-1, если payload пуст)
+func controlCommand(data []byte, connIDLen int) int {
+	offset := FlagsSize + VersionSize + connIDLen + PacketNumberSize + PayloadLengthSize
+	if len(data) <= offset {
+		return -1
+	}
+	return int(data[offset])
+}
+
+// sessionPath - дополнительный адрес клиента в multipath-сессии
+// addr и sock меняются под Session.mu
+type sessionPath struct {
+	addr *net.UDPAddr
+	sock *dscpMarker
+
+	// lastSeen - время последнего пакета с этого адреса (UnixNano, atomic)
+	lastSeen int64
+
+	// bulk - сокет клиента для Medium и Low (classsock.go)
+	bulk bool
+}
+
+// altPath возвращает дополнительный путь с адресом addr
+// Вызывается под session.mu
+func (s *Session) altPath(addr *net.UDPAddr) *sessionPath {
+	key := addr.String()
+	for _, path := range s.altPaths {
+		if path.addr.String() == key {
+			return path
+		}
+	}
+	return nil
+}
+
+// addPath регистрирует дополнительный путь PATH_ADD и отвечает по нему
+func (h *Hub) addPath(session *Session, pkt *Packet, data []byte, sock *dscpMarker, remoteAddr *net.UDPAddr) error {
+	token, err := h.openControl(session, pkt, data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixNano()
+	bulk := bulkPath(token)
+	session.mu.Lock()
+	if session.RemoteAddr.String() != remoteAddr.String() {
+		if path := session.altPath(remoteAddr); path != nil {
+			path.sock = sock
+			path.bulk = bulk
+			atomic.StoreInt64(&path.lastSeen, now)
+		} else {
+			session.altPaths = append(session.altPaths, &sessionPath{addr: remoteAddr, sock: sock, lastSeen: now, bulk: bulk})
+			if len(session.altPaths) > maxSessionPaths {
+				session.altPaths = dropStalestPath(session.altPaths)
+			}
+		}
+	}
+	session.mu.Unlock()
+
+	wrapped, err := h.sealControl(session, 0x08, token)
+	if err != nil {
+		return err
+	}
+	if _, err := sock.WriteToUDP(wrapped, remoteAddr, PriorityHigh); err != nil {
+		return fmt.Errorf("send path response: %w", err)
+	}
+	return nil
+}
+
+// dropStalestPath убирает путь, дольше всех молчавший
+func dropStalestPath(paths []*sessionPath) []*sessionPath {
+	stalest := 0
+	for i, path := range paths {
+		if atomic.LoadInt64(&path.lastSeen) < atomic.LoadInt64(&paths[stalest].lastSeen) {
+			stalest = i
+		}
+	}
+	return append(paths[:stalest], paths[stalest+1:]...)
+}
+
+// routePaths выбирает путь пакета multipath-сессии: High копируется
+// на все живые дополнительные пути, остальное уходит по пути, с
+// которого клиент писал последним. Живой bulk-путь (classsock.go)
+// получает Medium и Low и не получает копий High. Вызывается под s.mu
+func (s *Session) routePaths(b []byte, level PriorityLevel, sock *dscpMarker, addr *net.UDPAddr) (*dscpMarker, *net.UDPAddr) {
+	now := time.Now().UnixNano()
+	latest := atomic.LoadInt64(&s.primarySeen)
+	var bulk *sessionPath
+
+	for _, path := range s.altPaths {
+		seen := atomic.LoadInt64(&path.lastSeen)
+		if now-seen > int64(multipathPathIdle) {
+			continue
+		}
+		if path.bulk {
+			bulk = path
+			continue
+		}
+		if level == PriorityHigh {
+			path.sock.WriteToUDP(b, path.addr, level)
+			continue
+		}
+		if seen > latest {
+			latest = seen
+			sock, addr = path.sock, path.addr
+		}
+	}
+	if bulk != nil && level != PriorityHigh {
+		return bulk.sock, bulk.addr
+	}
+	return sock, addr
+}
+
+// pathState - путь клиента по результатам PATH_CHALLENGE
+// Поля под multipath.mu
+type pathState struct {
+	srtt       time.Duration
+	loss       float64
+	token      [pathTokenSize]byte
+	sentAt     time.Time
+	pending    bool
+	answeredAt time.Time
+
+	// credit - счёт smooth weighted round-robin
+	credit float64
+}
+
+// multipath - второй путь клиента и планировщик
+type multipath struct {
+	// second - сокет дополнительного пути (nil, пока не открыт)
+	// local - локальный адрес дополнительного пути
+	second atomic.Pointer[dscpMarker]
+	local  *net.UDPAddr
+
+	// sent - пакетов отправлено по путям (atomic)
+	sent [pathCount]uint64
+
+	mu     sync.Mutex
+	paths  [pathCount]pathState
+	joined bool
+}
+
+// newMultipath создаёт состояние multipath по конфигу (nil - выключен)
+func newMultipath(config *Config) (*multipath, error) {
+	if !config.Multipath {
+		return nil, nil
+	}
+	mp := &multipath{}
+	if config.MultipathLocalAddr != "" {
+		ip := net.ParseIP(config.MultipathLocalAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid multipathLocalAddr %q", config.MultipathLocalAddr)
+		}
+		mp.local = &net.UDPAddr{IP: ip}
+	}
+	return mp, nil
+}
+
+// alive - путь отвечает и теряет не больше multipathMaxLoss
+// Вызывается под mu
+func (p *pathState) alive(now time.Time) bool {
+	return !p.answeredAt.IsZero() &&
+		now.Sub(p.answeredAt) < 3*multipathProbeInterval &&
+		p.loss <= multipathMaxLoss
+}
+
+// weight - доля bulk-трафика пути
+func (p *pathState) weight() float64 {
+	rtt := p.srtt
+	if rtt < time.Millisecond {
+		rtt = time.Millisecond
+	}
+	return (1 - p.loss) / rtt.Seconds()
+}
+
+// route выбирает пути для пакета класса level
+func (mp *multipath) route(level PriorityLevel, now time.Time) (primary, secondary bool) {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	if !mp.joined || !mp.paths[pathSecondary].alive(now) {
+		return true, false
+	}
+	// Основной путь ещё не проверен (старт) или жив
+	primaryAlive := mp.paths[pathPrimary].answeredAt.IsZero() || mp.paths[pathPrimary].alive(now)
+	if !primaryAlive {
+		return false, true
+	}
+	if level == PriorityHigh {
+		return true, true
+	}
+
+	// Smooth weighted round-robin
+	total := 0.0
+	best := pathPrimary
+	for i := range mp.paths {
+		p := &mp.paths[i]
+		w := p.weight()
+		p.credit += w
+		total += w
+		if p.credit > mp.paths[best].credit {
+			best = i
+		}
+	}
+	mp.paths[best].credit -= total
+	return best == pathPrimary, best == pathSecondary
+}
+
+// nextProbe готовит токен проверки пути i; неотвеченная предыдущая
+// проверка засчитывается как потеря
+func (mp *multipath) nextProbe(i int, now time.Time) ([pathTokenSize]byte, error) {
+	var token [pathTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return token, fmt.Errorf("path token: %w", err)
+	}
+
+	mp.mu.Lock()
+	p := &mp.paths[i]
+	if p.pending {
+		p.loss = p.loss*7/8 + 1.0/8
+	}
+	p.token = token
+	p.sentAt = now
+	p.pending = true
+	mp.mu.Unlock()
+	return token, nil
+}
+
+// answered засчитывает PATH_RESPONSE, если токен принадлежит проверке пути
+func (mp *multipath) answered(token []byte, now time.Time) bool {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+
+	for i := range mp.paths {
+		p := &mp.paths[i]
+		if !p.pending || subtle.ConstantTimeCompare(token, p.token[:]) != 1 {
+			continue
+		}
+		sample := now.Sub(p.sentAt)
+		if p.srtt == 0 {
+			p.srtt = sample
+		} else {
+			p.srtt = (p.srtt*7 + sample) / 8
+		}
+		p.loss = p.loss * 7 / 8
+		p.pending = false
+		p.answeredAt = now
+		if i == pathSecondary {
+			mp.joined = true
+		}
+		return true
+	}
+	return false
+}
+
+// reset забывает состояние путей: новая сессия (reconnect.go) должна
+// заново зарегистрировать дополнительный путь
+func (mp *multipath) reset() {
+	mp.mu.Lock()
+	mp.paths = [pathCount]pathState{}
+	mp.joined = false
+	mp.mu.Unlock()
+}
+
+// close закрывает сокет дополнительного пути
+func (mp *multipath) close() {
+	if sock := mp.second.Swap(nil); sock != nil {
+		sock.conn.Close()
+	}
+}
+
+// write отправляет датаграмму по путям, выбранным планировщиком
+func (c *GameTunnelClientConn) write(b []byte, level PriorityLevel) (int, error) {
+	// Medium и Low - через bulk-сокет (classsock.go)
+	if c.classes != nil && level != PriorityHigh {
+		if n, ok := c.writeBulk(b, level); ok {
+			return n, nil
+		}
+	}
+	mp := c.multipath
+	if mp == nil {
+		return c.writePrimary(b, level)
+	}
+
+	primary, secondary := mp.route(level, time.Now())
+	if !secondary {
+		return c.writePrimary(b, level)
+	}
+
+	sn, serr := c.writeSecondary(b, level)
+	if !primary {
+		if serr == nil {
+			return sn, nil
+		}
+		// Дополнительный путь отказал - основной как запасной
+		return c.writePrimary(b, level)
+	}
+
+	n, err := c.writePrimary(b, level)
+	if err != nil && serr == nil {
+		return sn, nil
+	}
+	return n, err
+}
+
+// writeSecondary отправляет датаграмму дополнительным путём
+func (c *GameTunnelClientConn) writeSecondary(b []byte, level PriorityLevel) (int, error) {
+	sock := c.multipath.second.Load()
+	if sock == nil {
+		return 0, fmt.Errorf("secondary path not open")
+	}
+	atomic.AddUint64(&c.multipath.sent[pathSecondary], 1)
+	return sock.Write(b, level)
+}
+
+// multipathLoop принимает пакеты дополнительного пути и проверяет оба пути
+func (c *GameTunnelClientConn) multipathLoop() {
+	mp := c.multipath
+	buf := make([]byte, MaxPacketSize)
+	var probedAt time.Time
+
+	for c.ctx.Err() == nil {
+		if now := time.Now(); now.Sub(probedAt) >= multipathProbeInterval {
+			probedAt = now
+			c.probePaths(now)
+		}
+
+		sock := mp.second.Load()
+		if sock == nil {
+			// Интерфейса нет (сотовая сеть выключена) - пробуем позже
+			sleepContext(c.ctx, multipathProbeInterval)
+			continue
+		}
+
+		sock.conn.SetReadDeadline(time.Now().Add(multipathProbeInterval))
+		n, err := sock.conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+				// Сокет умер - откроем новый при следующей проверке
+				if mp.second.CompareAndSwap(sock, nil) {
+					sock.conn.Close()
+				}
+			}
+			continue
+		}
+
+		sock.captured(false, nil, buf[:n])
+		c.handlePacket(buf[:n])
+	}
+}
+
+// probePaths шлёт PATH_CHALLENGE по обоим путям; дополнительный путь
+// до регистрации на сервере шлёт PATH_ADD
+func (c *GameTunnelClientConn) probePaths(now time.Time) {
+	mp := c.multipath
+
+	if mp.second.Load() == nil {
+		conn, err := dialPathSocket(c.ctx, mp.local, c.session().serverAddr, c.config, c.sockopt)
+		if err != nil {
+			return
+		}
+		sock := newDSCPMarker(conn, c.config)
+		c.pathMu.Lock()
+		if atomic.LoadInt32(&c.closed) == 1 {
+			c.pathMu.Unlock()
+			conn.Close()
+			return
+		}
+		mp.second.Store(sock)
+		c.pathMu.Unlock()
+	}
+
+	for i := 0; i < pathCount; i++ {
+		token, err := mp.nextProbe(i, now)
+		if err != nil {
+			return
+		}
+
+		cmd := byte(0x07)
+		mp.mu.Lock()
+		if i == pathSecondary && !mp.joined {
+			cmd = 0x09
+		}
+		mp.mu.Unlock()
+
+		wrapped, err := c.sealControl(cmd, token[:])
+		if err != nil {
+			return
+		}
+		if i == pathPrimary {
+			c.writePrimary(wrapped, PriorityHigh)
+		} else {
+			c.writeSecondary(wrapped, PriorityHigh)
+		}
+	}
+}
+
+// PathStats - состояние одного пути multipath-соединения
+type PathStats struct {
+	Name      string        `json:"name"`
+	LocalAddr string        `json:"localAddr"`
+	RTT       time.Duration `json:"rtt"`
+	Loss      float64       `json:"loss"`
+	Packets   uint64        `json:"packets"`
+	Up        bool          `json:"up"`
+}
+
+// GetPathStats возвращает состояние путей (nil без multipath)
+func (c *GameTunnelClientConn) GetPathStats() []PathStats {
+	mp := c.multipath
+	if mp == nil {
+		return nil
+	}
+
+	locals := [pathCount]string{c.LocalAddr().String()}
+	if sock := mp.second.Load(); sock != nil {
+		locals[pathSecondary] = sock.conn.LocalAddr().String()
+	}
+
+	now := time.Now()
+	stats := make([]PathStats, pathCount)
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	for i := range mp.paths {
+		p := &mp.paths[i]
+		stats[i] = PathStats{
+			Name:      pathNames[i],
+			LocalAddr: locals[i],
+			RTT:       p.srtt,
+			Loss:      p.loss,
+			Packets:   atomic.LoadUint64(&mp.sent[i]),
+			Up:        p.alive(now) && (i == pathPrimary || mp.joined),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,130 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+)
+
+// upMultipath - оба пути зарегистрированы и отвечают с заданным RTT
+func upMultipath(primary, secondary time.Duration) *multipath {
+	now := time.Now()
+	mp := &multipath{joined: true}
+	mp.paths[pathPrimary] = pathState{srtt: primary, answeredAt: now}
+	mp.paths[pathSecondary] = pathState{srtt: secondary, answeredAt: now}
+	return mp
+}
+
+func TestMultipathRoute(t *testing.T) {
+	now := time.Now()
+
+	// High - по обоим путям
+	mp := upMultipath(10*time.Millisecond, 30*time.Millisecond)
+	if p, s := mp.route(PriorityHigh, now); !p || !s {
+		t.Errorf("High: primary=%v secondary=%v", p, s)
+	}
+
+	// Bulk делится пропорционально 1/RTT: 3:1
+	var counts [pathCount]int
+	for i := 0; i < 400; i++ {
+		if p, _ := mp.route(PriorityLow, now); p {
+			counts[pathPrimary]++
+		} else {
+			counts[pathSecondary]++
+		}
+	}
+	if counts[pathPrimary] != 300 || counts[pathSecondary] != 100 {
+		t.Errorf("Bulk split %v, want [300 100]", counts)
+	}
+
+	// Путь с большими потерями выключается
+	mp = upMultipath(10*time.Millisecond, 10*time.Millisecond)
+	mp.paths[pathSecondary].loss = 0.6
+	if p, s := mp.route(PriorityHigh, now); !p || s {
+		t.Errorf("Lossy secondary used: primary=%v secondary=%v", p, s)
+	}
+
+	// Основной путь замолчал - всё идёт дополнительным
+	mp = upMultipath(10*time.Millisecond, 10*time.Millisecond)
+	mp.paths[pathPrimary].answeredAt = now.Add(-time.Minute)
+	if p, s := mp.route(PriorityLow, now); p || !s {
+		t.Errorf("Dead primary used: primary=%v secondary=%v", p, s)
+	}
+
+	// До регистрации на сервере - только основной
+	mp = upMultipath(10*time.Millisecond, 10*time.Millisecond)
+	mp.joined = false
+	if p, s := mp.route(PriorityHigh, now); !p || s {
+		t.Errorf("Unjoined secondary used: primary=%v secondary=%v", p, s)
+	}
+}
+
+func TestMultipathProbeAccounting(t *testing.T) {
+	mp := &multipath{}
+	start := time.Now()
+
+	token, _ := mp.nextProbe(pathSecondary, start)
+	if mp.answered([]byte("wrong!!!"), start) {
+		t.Fatal("Foreign token accepted")
+	}
+	if !mp.answered(token[:], start.Add(20*time.Millisecond)) || !mp.joined {
+		t.Fatal("Probe answer not matched")
+	}
+	if mp.paths[pathSecondary].srtt != 20*time.Millisecond {
+		t.Errorf("srtt=%v", mp.paths[pathSecondary].srtt)
+	}
+
+	// Неотвеченная проверка - потеря
+	mp.nextProbe(pathSecondary, start)
+	mp.nextProbe(pathSecondary, start)
+	if loss := mp.paths[pathSecondary].loss; loss != 1.0/8 {
+		t.Errorf("loss=%v, want 1/8", loss)
+	}
+}
+
+func TestMultipathLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "multipath"
+	l, accepted := startTestListener(t, config)
+	serverObs := &recordingObserver{}
+	l.AddSessionObserver(serverObs)
+
+	clientConfig := *config
+	clientConfig.Multipath = true
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	// Второй путь регистрируется на сервере первой проверкой
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats := client.GetPathStats()
+		if stats[pathPrimary].Up && stats[pathSecondary].Up {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Paths not up: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	session := l.hub.GetSession(client.session().ConnectionID)
+	if got := session.GetStats().Paths; got != 2 {
+		t.Fatalf("Server sees %d paths, want 2", got)
+	}
+
+	// Поток идёт по обоим путям, без миграций на сервере
+	sentBefore := client.GetPathStats()[pathSecondary].Packets
+	for i := 0; i < 20; i++ {
+		client.Write([]byte("bulk"))
+		if got := readWithTimeout(t, server, 4); string(got) != "bulk" {
+			t.Fatalf("Server got %q", got)
+		}
+	}
+	if client.GetPathStats()[pathSecondary].Packets == sentBefore {
+		t.Error("Secondary path carried no data")
+	}
+
+	server.Write([]byte("reply"))
+	if got := readWithTimeout(t, client, 5); string(got) != "reply" {
+		t.Fatalf("Client got %q", got)
+	}
+	serverObs.waitEvents(t, "created", "authenticated")
+}
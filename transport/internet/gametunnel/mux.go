@@ -0,0 +1,600 @@
+package gametunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Общая сессия для нескольких соединений xray (sharedSession)
+// ====================================================================
+//
+// Обычно каждое проксируемое соединение xray - это свой UDP-сокет,
+// свой хэндшейк и своя сессия на сервере. С sharedSession = true
+// клиент держит одну сессию на сервер и ведёт соединения xray как
+// потоки внутри неё: хэндшейк один на много соединений, а NAT видит
+// один маппинг вместо десятков.
+//
+// Согласование - в хвосте payload хэндшейка (после IssuedID, если
+// он есть) байт возможностей:
+//
+//	Client Hello: [... ][caps]           helloCapMux - клиент просит потоки
+//	Server Hello: [... ][IssuedID][caps] helloCapMux - сервер согласен
+//
+// Старый сервер хвост Client Hello игнорирует и отвечает без caps -
+// клиент ведёт такую сессию как обычную и в общий пул не кладёт.
+//
+// В общей сессии payload каждого DATA - кадр потока:
+//
+//	DATA:    [stream ID 2 байта BE][данные]
+//	CONTROL: [0x0A][AEAD(stream ID)]     - STREAM_CLOSE, в обе стороны
+//
+// ID потоков выбирает клиент, по возрастанию. Сервер открывает поток
+// (новое соединение для xray) по первому кадру с ID новее последнего
+// принятого: запоздавший кадр закрытого потока его не воскрешает.
+// Одновременно потоков не больше maxStreams. CLOSE сессии закрывает
+// все её потоки.
+//
+// Клиентский пул держит сессии по адресу сервера и конфигурации.
+// Сессия без потоков живёт ещё sharedSessionLinger - следующее
+// соединение xray не платит за хэндшейк. Переподключение
+// (reconnect.go) для общей сессии выключено: потоки на сервере
+// гибнут вместе с ней.
+//
+// ====================================================================
+
+const (
+	// helloCapMux - байт возможностей хэндшейка: потоки в сессии
+	helloCapMux byte = 0x01
+
+	// streamHeaderSize - заголовок кадра потока
+	streamHeaderSize = 2
+
+	// sharedSessionLinger - сколько живёт общая сессия без потоков
+	sharedSessionLinger = 30 * time.Second
+)
+
+// streamFrame собирает кадр потока id
+func streamFrame(id uint16, data []byte) []byte {
+	frame := make([]byte, streamHeaderSize+len(data))
+	binary.BigEndian.PutUint16(frame, id)
+	copy(frame[streamHeaderSize:], data)
+	return frame
+}
+
+// parseStreamFrame разбирает кадр потока
+func parseStreamFrame(frame []byte) (uint16, []byte, bool) {
+	if len(frame) < streamHeaderSize {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint16(frame), frame[streamHeaderSize:], true
+}
+
+// streamOwner - сессия, по которой идут кадры потока
+type streamOwner interface {
+	// writeStream отправляет данные потока id
+	writeStream(id uint16, b []byte) (int, error)
+
+	// closeStream закрывает поток id и сообщает об этом другой стороне
+	closeStream(id uint16)
+}
+
+// muxStream - поток общей сессии, соединение для xray
+type muxStream struct {
+	id    uint16
+	owner streamOwner
+
+	// inbound - данные потока от другой стороны
+	// done закрывается, когда поток завершён любой из сторон
+	inbound  chan []byte
+	done     chan struct{}
+	doneOnce sync.Once
+
+	// rest - остатки данных от прошлого чтения
+	rest readRest
+
+	local  net.Addr
+	remote net.Addr
+
+	// setPriority - закрепление приоритета потока (nil - не
+	// поддерживается, потоки общей сессии)
+	setPriority func(PriorityLevel) error
+
+	// setDuplicate - дублирование High-пакетов потока (duplicate.go;
+	// nil - не поддерживается)
+	setDuplicate func(bool) error
+
+	closed int32
+}
+
+// push передаёт данные в поток; при переполнении пакет теряется, как
+// и для любого UDP
+func (s *muxStream) push(data []byte) {
+	select {
+	case s.inbound <- data:
+	default:
+	}
+}
+
+// finish завершает поток: Read отдаёт остаток и EOF
+func (s *muxStream) finish() {
+	s.doneOnce.Do(func() { close(s.done) })
+}
+
+// Read читает данные потока
+func (s *muxStream) Read(b []byte) (int, error) {
+	if n, ok := s.rest.read(b); ok {
+		return n, nil
+	}
+
+	var data []byte
+	select {
+	case data = <-s.inbound:
+	case <-s.done:
+		// Данные, пришедшие до завершения, отдаются до EOF
+		select {
+		case data = <-s.inbound:
+		default:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(b, data)
+	s.rest.keep(inboundPacket{data: data}, n)
+	return n, nil
+}
+
+// Write отправляет данные потока
+func (s *muxStream) Write(b []byte) (int, error) {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return 0, io.ErrClosedPipe
+	}
+	select {
+	case <-s.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	return s.owner.writeStream(s.id, b)
+}
+
+// Close закрывает поток; сессия остаётся
+func (s *muxStream) Close() error {
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		return nil
+	}
+	s.owner.closeStream(s.id)
+	s.finish()
+	return nil
+}
+
+// StreamID возвращает ID потока в сессии
+func (s *muxStream) StreamID() uint16 {
+	return s.id
+}
+
+// SetPriority закрепляет приоритет потока (streams.go)
+// PriorityAuto возвращает классификацию
+func (s *muxStream) SetPriority(level PriorityLevel) error {
+	if s.setPriority == nil {
+		return fmt.Errorf("stream priority is not supported in shared sessions")
+	}
+	return s.setPriority(level)
+}
+
+// SetDuplicate включает дублирование High-пакетов потока (duplicate.go)
+func (s *muxStream) SetDuplicate(on bool) error {
+	if s.setDuplicate == nil {
+		return fmt.Errorf("stream duplication is not supported in shared sessions")
+	}
+	return s.setDuplicate(on)
+}
+
+// LocalAddr возвращает локальный адрес сессии
+func (s *muxStream) LocalAddr() net.Addr {
+	return s.local
+}
+
+// RemoteAddr возвращает адрес другой стороны сессии
+func (s *muxStream) RemoteAddr() net.Addr {
+	return s.remote
+}
+
+// SetDeadline - заглушка для net.Conn
+func (s *muxStream) SetDeadline(t time.Time) error {
+	return nil
+}
+
+// SetReadDeadline - заглушка для net.Conn
+func (s *muxStream) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// SetWriteDeadline - заглушка для net.Conn
+func (s *muxStream) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// streamMux - потоки одной общей сессии
+type streamMux struct {
+	streams map[uint16]*muxStream
+
+	// lastID - последний выданный (клиент) или принятый (сервер) ID
+	lastID uint16
+
+	// opened - потоков открыто за время жизни сессии
+	opened uint64
+
+	// done закрывается вместе с сессией (closeAll)
+	closed bool
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// newStreamMux создаёт пустую таблицу потоков
+func newStreamMux() *streamMux {
+	return &streamMux{streams: make(map[uint16]*muxStream), done: make(chan struct{})}
+}
+
+// newStream регистрирует поток id под mu
+func (m *streamMux) newStream(id uint16, owner streamOwner, local, remote net.Addr) *muxStream {
+	s := &muxStream{
+		id:      id,
+		owner:   owner,
+		inbound: make(chan []byte, 256),
+		done:    make(chan struct{}),
+		local:   local,
+		remote:  remote,
+	}
+	m.streams[id] = s
+	m.lastID = id
+	m.opened++
+	return s
+}
+
+// open выделяет клиентский поток со следующим свободным ID
+func (m *streamMux) open(owner streamOwner, local, remote net.Addr, maxStreams uint32) (*muxStream, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("shared session closed")
+	}
+	if uint32(len(m.streams)) >= maxStreams {
+		return nil, fmt.Errorf("too many streams: limit %d", maxStreams)
+	}
+	id := m.lastID + 1
+	for id == 0 || m.streams[id] != nil {
+		id++
+	}
+	return m.newStream(id, owner, local, remote), nil
+}
+
+// accept открывает серверный поток по первому кадру клиента
+// Возвращает nil, если ID не новее последнего принятого или потоков
+// уже maxStreams
+func (m *streamMux) accept(id uint16, owner streamOwner, local, remote net.Addr, maxStreams uint32) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Сравнение по модулю 2^16: ID растут и переполняются
+	if m.closed || int16(id-m.lastID) <= 0 || uint32(len(m.streams)) >= maxStreams {
+		return nil
+	}
+	return m.newStream(id, owner, local, remote)
+}
+
+// get возвращает поток id (nil, если нет)
+func (m *streamMux) get(id uint16) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.streams[id]
+}
+
+// remove убирает поток id из таблицы и возвращает его
+func (m *streamMux) remove(id uint16) *muxStream {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.streams[id]
+	delete(m.streams, id)
+	return s
+}
+
+// count возвращает число открытых потоков
+func (m *streamMux) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.streams)
+}
+
+// closeAll завершает все потоки: сессия закрыта
+func (m *streamMux) closeAll() {
+	m.mu.Lock()
+	streams := m.streams
+	m.streams = make(map[uint16]*muxStream)
+	if !m.closed {
+		m.closed = true
+		close(m.done)
+	}
+	m.mu.Unlock()
+
+	for _, s := range streams {
+		s.finish()
+	}
+}
+
+// streamCloseBody - body STREAM_CLOSE
+func streamCloseBody(id uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, id)
+}
+
+// ====================================================================
+// Сервер
+// ====================================================================
+
+// serverStreams - потоки общей сессии на стороне сервера
+type serverStreams struct {
+	hub     *Hub
+	session *Session
+}
+
+// writeStream режет данные на кадры и ставит их в очередь сессии
+func (o serverStreams) writeStream(id uint16, b []byte) (int, error) {
+	maxPayload := int(o.hub.sessionConfig(o.session).GetMaxPayloadSize()) - streamHeaderSize
+	written := 0
+	for written < len(b) {
+		end := written + maxPayload
+		if end > len(b) {
+			end = len(b)
+		}
+		frame := streamFrame(id, b[written:end])
+		if err := o.hub.sendToSession(o.session, id, frame, o.hub.classifyStream(o.session, id, frame)); err != nil {
+			return written, fmt.Errorf("send to session: %w", err)
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// closeStream закрывает поток и шлёт клиенту STREAM_CLOSE
+func (o serverStreams) closeStream(id uint16) {
+	if o.session.streams.remove(id) != nil {
+		o.hub.unpinStream(o.session, id)
+		o.hub.sendSealedControl(o.session, 0x0A, streamCloseBody(id))
+	}
+}
+
+// deliverStream передаёт кадр клиента в его поток; первый кадр
+// нового потока открывает соединение для xray
+func (h *Hub) deliverStream(session *Session, frame []byte) {
+	id, data, ok := parseStreamFrame(frame)
+	if !ok {
+		return
+	}
+
+	// Потоки приложения: поток 0 - само соединение (streams.go)
+	if session.streamAccept != nil {
+		if id == 0 {
+			if len(data) > 0 {
+				h.pushInbound(session, data)
+			}
+			return
+		}
+		if s := session.streams.get(id); s != nil || len(data) == 0 {
+			if s != nil {
+				s.push(data)
+			}
+			return
+		}
+		if s := h.acceptAppStream(session, id); s != nil {
+			s.push(data)
+		}
+		return
+	}
+
+	s := session.streams.get(id)
+	if s == nil {
+		owner := serverStreams{hub: h, session: session}
+		s = session.streams.accept(id, owner, nil, session.RemoteAddr, h.getConfig().MaxStreams)
+		if s == nil {
+			// Запоздавший кадр закрытого потока или лимит потоков
+			return
+		}
+		if h.onNewStream == nil || !h.onNewStream(session, s) {
+			owner.closeStream(id)
+			return
+		}
+	}
+	if len(data) > 0 {
+		s.push(data)
+	}
+}
+
+// handleStreamClose закрывает поток по STREAM_CLOSE клиента
+func (h *Hub) handleStreamClose(session *Session, pkt *Packet, data []byte) error {
+	body, err := h.openControl(session, pkt, data)
+	if err != nil {
+		return err
+	}
+	if len(body) != streamHeaderSize || session.streams == nil {
+		return fmt.Errorf("invalid stream close")
+	}
+	id := binary.BigEndian.Uint16(body)
+	if s := session.streams.remove(id); s != nil {
+		s.finish()
+		h.unpinStream(session, id)
+	}
+	return nil
+}
+
+// ====================================================================
+// Клиент
+// ====================================================================
+
+// sharedKey - ключ пула: адрес сервера и конфигурация outbound-а
+type sharedKey struct {
+	addr   string
+	config *Config
+}
+
+// sharedPool - общие сессии клиента
+type sharedPool struct {
+	carriers map[sharedKey][]*GameTunnelClientConn
+	mu       sync.Mutex
+}
+
+// sharedSessions - пул общих сессий процесса
+var sharedSessions = &sharedPool{carriers: make(map[sharedKey][]*GameTunnelClientConn)}
+
+// openStream открывает поток в одной из живых сессий пула
+func (p *sharedPool) openStream(key sharedKey) (*muxStream, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, c := range p.carriers[key] {
+		if atomic.LoadInt32(&c.closed) == 1 {
+			continue
+		}
+		if s, err := c.openStream(); err == nil {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// add кладёт сессию в пул
+func (p *sharedPool) add(c *GameTunnelClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.carriers[c.sharedKey] = append(p.carriers[c.sharedKey], c)
+}
+
+// remove убирает сессию из пула
+func (p *sharedPool) remove(c *GameTunnelClientConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.removeLocked(c)
+}
+
+// removeLocked убирает сессию из пула под mu
+func (p *sharedPool) removeLocked(c *GameTunnelClientConn) {
+	carriers := p.carriers[c.sharedKey]
+	for i, carrier := range carriers {
+		if carrier == c {
+			carriers = append(carriers[:i], carriers[i+1:]...)
+			break
+		}
+	}
+	if len(carriers) == 0 {
+		delete(p.carriers, c.sharedKey)
+		return
+	}
+	p.carriers[c.sharedKey] = carriers
+}
+
+// expire закрывает сессию, если за время ожидания потоков не появилось
+// Под mu пула: openStream не успеет взять сессию, которую закрываем
+func (p *sharedPool) expire(c *GameTunnelClientConn) {
+	p.mu.Lock()
+	if c.mux.count() > 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.removeLocked(c)
+	p.mu.Unlock()
+	c.Close()
+}
+
+// openStream открывает поток в общей сессии
+func (c *GameTunnelClientConn) openStream() (*muxStream, error) {
+	return c.mux.open(c, c.LocalAddr(), c.RemoteAddr(), c.config.MaxStreams)
+}
+
+// writeStream режет данные на кадры и ставит их в очередь отправки
+func (c *GameTunnelClientConn) writeStream(id uint16, b []byte) (int, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return 0, io.ErrClosedPipe
+	}
+
+	maxPayload := c.maxPayload() - streamHeaderSize
+	written := 0
+	for written < len(b) {
+		end := written + maxPayload
+		if end > len(b) {
+			end = len(b)
+		}
+		frame := streamFrame(id, b[written:end])
+		if err := c.enqueueData(id, frame, c.classifyStream(id, frame)); err != nil {
+			return written, err
+		}
+		written = end
+	}
+	return written, nil
+}
+
+// closeStream закрывает поток и шлёт серверу STREAM_CLOSE
+// Последний поток запускает ожидание перед закрытием сессии
+func (c *GameTunnelClientConn) closeStream(id uint16) {
+	if c.mux.remove(id) == nil {
+		return
+	}
+	c.sendSealedControl(0x0A, streamCloseBody(id))
+	c.streamsChanged()
+}
+
+// streamsChanged запускает закрытие сессии без потоков
+func (c *GameTunnelClientConn) streamsChanged() {
+	if c.mux.count() == 0 && atomic.LoadInt32(&c.closed) == 0 {
+		time.AfterFunc(sharedSessionLinger, func() { sharedSessions.expire(c) })
+	}
+}
+
+// deliverStream передаёт кадр сервера в его поток
+func (c *GameTunnelClientConn) deliverStream(frame []byte) {
+	id, data, ok := parseStreamFrame(frame)
+	if !ok || len(data) == 0 {
+		return
+	}
+	if s := c.mux.get(id); s != nil {
+		s.push(data)
+	}
+}
+
+// handleStreamClose завершает поток по STREAM_CLOSE сервера
+func (c *GameTunnelClientConn) handleStreamClose(body []byte) {
+	if len(body) != streamHeaderSize {
+		return
+	}
+	// Поток приложения (streams.go)
+	if c.streams != nil {
+		id := binary.BigEndian.Uint16(body)
+		if s := c.streams.remove(id); s != nil {
+			s.finish()
+			c.unpinStream(id)
+		}
+		return
+	}
+	if c.mux == nil {
+		return
+	}
+	if s := c.mux.remove(binary.BigEndian.Uint16(body)); s != nil {
+		s.finish()
+		c.streamsChanged()
+	}
+}
+
+// GetSharedStreams возвращает число открытых потоков общей сессии и
+// число потоков, открытых за её жизнь (0, 0 - сессия не общая)
+func (c *GameTunnelClientConn) GetSharedStreams() (open int, total uint64) {
+	if c.mux == nil {
+		return 0, 0
+	}
+	c.mux.mu.Lock()
+	defer c.mux.mu.Unlock()
+	return len(c.mux.streams), c.mux.opened
+}
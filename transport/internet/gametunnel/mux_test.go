@@ -0,0 +1,156 @@
+package gametunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// dialSharedStream открывает соединение с sharedSession и ждёт его
+// потока на сервере по первой записи
+func dialSharedStream(t *testing.T, l *Listener, config *Config, accepted <-chan stat.Connection, opening string) (*muxStream, net.Conn) {
+	t.Helper()
+
+	addr := l.Addr().(*net.UDPAddr)
+	conn, err := Dial(context.Background(), xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port)),
+		&internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	stream, ok := conn.(*muxStream)
+	if !ok {
+		t.Fatalf("Dial returned %T, want a shared session stream", conn)
+	}
+	t.Cleanup(func() { stream.owner.(*GameTunnelClientConn).Close() })
+
+	stream.Write([]byte(opening))
+	select {
+	case server := <-accepted:
+		if got := readWithTimeout(t, server, len(opening)); string(got) != opening {
+			t.Fatalf("Server got %q, want %q", got, opening)
+		}
+		return stream, server
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not accept the stream")
+	}
+	return nil, nil
+}
+
+func TestSharedSessionStreams(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "shared"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.SharedSession = true
+	first, server1 := dialSharedStream(t, l, &clientConfig, accepted, "one")
+	second, server2 := dialSharedStream(t, l, &clientConfig, accepted, "two")
+
+	// Оба соединения - потоки одной сессии
+	carrier := first.owner.(*GameTunnelClientConn)
+	if second.owner != first.owner || first.StreamID() == second.StreamID() {
+		t.Fatalf("streams %d/%d not on one session", first.StreamID(), second.StreamID())
+	}
+	if n := l.hub.GetActiveSessions(); n != 1 {
+		t.Errorf("Server has %d sessions, want 1", n)
+	}
+	if open, total := carrier.GetSharedStreams(); open != 2 || total != 2 {
+		t.Errorf("streams open=%d total=%d", open, total)
+	}
+
+	// Ответы приходят в свой поток
+	server2.Write([]byte("reply2"))
+	server1.Write([]byte("reply1"))
+	if got := readWithTimeout(t, second, 6); string(got) != "reply2" {
+		t.Errorf("Second stream got %q", got)
+	}
+	if got := readWithTimeout(t, first, 6); string(got) != "reply1" {
+		t.Errorf("First stream got %q", got)
+	}
+
+	// Закрытие потока не трогает сессию и соседний поток
+	first.Close()
+	readDone := make(chan error, 1)
+	go func() {
+		_, err := server1.Read(make([]byte, 16))
+		readDone <- err
+	}()
+	select {
+	case err := <-readDone:
+		if err != io.EOF {
+			t.Errorf("Server stream read: %v, want EOF", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server stream not closed")
+	}
+
+	second.Write([]byte("still"))
+	if got := readWithTimeout(t, server2, 5); string(got) != "still" {
+		t.Errorf("Server got %q", got)
+	}
+
+	// Закрытие сессии завершает её потоки
+	carrier.Close()
+	if _, err := second.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Stream read after session close: %v", err)
+	}
+}
+
+func TestHandshakeCapabilities(t *testing.T) {
+	issued := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tests := []struct {
+		issued []byte
+		caps   byte
+	}{
+		{nil, 0},
+		{nil, helloCapMux},
+		{issued, 0},
+		{issued, helloCapMux},
+	}
+	for _, tt := range tests {
+		hello := NewHandshakePayload([Curve25519KeySize]byte{1}, 1)
+		hello.IssuedID = tt.issued
+		hello.Capabilities = tt.caps
+
+		got, err := UnmarshalHandshake(hello.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.splitCapabilities(len(issued), true, helloCapMux)
+		if string(got.IssuedID) != string(tt.issued) || got.Capabilities != tt.caps {
+			t.Errorf("issued=%x caps=%d: got issued=%x caps=%d", tt.issued, tt.caps, got.IssuedID, got.Capabilities)
+		}
+	}
+}
+
+func TestStreamMuxAcceptsOnlyNewerIDs(t *testing.T) {
+	m := newStreamMux()
+	owner := serverStreams{}
+
+	if m.accept(1, owner, nil, nil, 16) == nil {
+		t.Fatal("First stream rejected")
+	}
+	m.remove(1)
+	// Запоздавший кадр закрытого потока
+	if m.accept(1, owner, nil, nil, 16) != nil {
+		t.Error("Closed stream reopened by a late frame")
+	}
+
+	// Лимит одновременных потоков
+	if m.accept(2, owner, nil, nil, 1) == nil || m.accept(3, owner, nil, nil, 1) != nil {
+		t.Error("maxStreams not enforced")
+	}
+
+	// ID растут по модулю 2^16
+	m.remove(2)
+	m.lastID = 0xFFFF
+	if m.accept(1, owner, nil, nil, 16) == nil {
+		t.Error("Wrapped stream ID rejected")
+	}
+}
@@ -0,0 +1,396 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Подбор интервала keep-alive по маппингу NAT (клиент)
+// ====================================================================
+//
+// Операторы забывают UDP-маппинг NAT через 10-300 секунд молчания.
+// keepAliveInterval по умолчанию рассчитан на худший случай, и на
+// мобильном клиенте каждый keep-alive будит радио. С keepAliveTune
+// клиент ищет самый длинный промежуток, который маппинг выдерживает:
+//
+//	клиент -> сервер: CONTROL [0x0E][AEAD(token(8) gap(4) interval(4))]
+//	сервер -> клиент: CONTROL [0x0F][AEAD(token(8))] через gap
+//
+// gap и interval - миллисекунды. Клиент шлёт пробу и молчит; сервер
+// отвечает через gap на текущий адрес сессии. Ответ дошёл - маппинг
+// пережил gap. Не дошёл за gap + natProbeGrace - маппинг пропал:
+// клиент сразу шлёт keep-alive, новый маппинг сервер подхватывает по
+// первому пакету (roaming.go). Ответ сервера проверяет именно входящий
+// путь: исходящий пакет клиента маппинг создал бы заново.
+//
+// Поиск: от keepAliveInterval промежуток удваивается до первой
+// неудачи или keepAliveMax, затем делится пополам между последним
+// удачным и первым неудачным, пока они не сойдутся до восьмой части.
+// Интервал keep-alive - 3/4 подтверждённого промежутка: запас на
+// разброс ±20% таймера. Через natRetune и после смены сети (rebind)
+// поиск начинается заново.
+//
+// Проба идёт, только когда клиент не отправляет данные: данные сами
+// держат маппинг. Данные клиента во время пробы делают её
+// недействительной. С chaffBudget покрывающий трафик и так будит
+// радио, и подбор выключен.
+//
+// interval сообщает серверу, как часто ждать keep-alive: таймаут
+// сессии и проверка живости (deadpeer.go) растягиваются до трёх и
+// двух интервалов клиента. Подобранный интервал клиент сообщает той
+// же пробой с gap 0, без ответа. Старый сервер 0x0E не знает и не
+// отвечает - все пробы неудачны, и интервал остаётся из конфига.
+// Пока проба не прошла, сервер не может дозвониться до клиента с
+// умершим маппингом - это цена поиска.
+//
+// ====================================================================
+
+const (
+	// natProbeTokenSize - длина токена пробы
+	// natProbeBodySize - токен, gap и interval
+	natProbeTokenSize = 8
+	natProbeBodySize  = natProbeTokenSize + 8
+
+	// defaultKeepAliveMax - верхняя граница поиска по умолчанию (секунды)
+	// maxNATProbeGap - предел keepAliveMax и gap пробы (секунды)
+	defaultKeepAliveMax = 300
+	maxNATProbeGap      = 900
+
+	// natProbeGrace - ожидание ответа сервера сверх gap
+	natProbeGrace = 3 * time.Second
+
+	// natTuneStep - поиск сходится, когда промежутки ближе, чем
+	// восьмая часть подтверждённого, но не меньше natTuneStep
+	natTuneStep = 2 * time.Second
+
+	// natRetune - период повторного поиска
+	natRetune = 30 * time.Minute
+)
+
+// natTuner - поиск интервала keep-alive соединения
+type natTuner struct {
+	mu  sync.Mutex
+	max time.Duration
+
+	// lo - самый длинный промежуток, который выдержал маппинг (0 -
+	// keepAliveInterval), hi - самый короткий, после которого маппинг
+	// пропал (0 - не было)
+	lo time.Duration
+	hi time.Duration
+
+	// token / gap / probeAt / sentAt - текущая проба и lastSendAt на её
+	// старте (probeAt zero - пробы нет); nextAt - раньше следующая проба
+	// не начнётся
+	token   [natProbeTokenSize]byte
+	gap     time.Duration
+	probeAt time.Time
+	sentAt  int64
+	nextAt  time.Time
+
+	// tunedAt - поиск сошёлся (zero - идёт), announce - сообщить
+	// серверу интервал со следующим keep-alive
+	tunedAt  time.Time
+	announce bool
+
+	probes uint64
+	lost   uint64
+}
+
+// KeepAliveTuneStats - подбор интервала keep-alive
+type KeepAliveTuneStats struct {
+	// Interval - интервал keep-alive сейчас
+	Interval time.Duration `json:"interval"`
+
+	// Confirmed - самый длинный промежуток, который выдержал маппинг
+	// Failed - самый короткий, после которого он пропал (0 - не было)
+	Confirmed time.Duration `json:"confirmed"`
+	Failed    time.Duration `json:"failed,omitempty"`
+
+	// Tuned - поиск сошёлся
+	Tuned bool `json:"tuned"`
+
+	// Probes - проб отправлено, Lost - без ответа
+	Probes uint64 `json:"probes"`
+	Lost   uint64 `json:"lost"`
+}
+
+// newNATTuner создаёт подбор интервала (nil - выключен)
+func newNATTuner(config *Config) *natTuner {
+	if !config.KeepAliveTune || config.KeepAliveInterval == 0 || config.ChaffBudget > 0 {
+		return nil
+	}
+	limit := config.KeepAliveMax
+	if limit == 0 {
+		limit = defaultKeepAliveMax
+	}
+	return &natTuner{max: time.Duration(limit) * time.Second}
+}
+
+// confirmed - подтверждённый промежуток, не меньше base
+// Вызывается под t.mu
+func (t *natTuner) confirmed(base time.Duration) time.Duration {
+	return max(t.lo, base)
+}
+
+// nextGap - промежуток следующей пробы (0 - поиск сошёлся)
+// Вызывается под t.mu
+func (t *natTuner) nextGap(base time.Duration) time.Duration {
+	lo := t.confirmed(base)
+	if lo >= t.max {
+		return 0
+	}
+	if t.hi == 0 {
+		return min(2*lo, t.max)
+	}
+	if t.hi-lo <= max(lo/8, natTuneStep) {
+		return 0
+	}
+	return (lo + t.hi) / 2
+}
+
+// interval - интервал keep-alive по подтверждённому промежутку
+// base - keepAliveInterval; nil возвращает base
+func (t *natTuner) interval(base time.Duration) time.Duration {
+	if t == nil {
+		return base
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return max(t.confirmed(base)*3/4, base)
+}
+
+// pending - сколько ждать ответа на текущую пробу (false - пробы нет)
+func (t *natTuner) pending(now time.Time) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.probeAt.IsZero() {
+		return 0, false
+	}
+	return max(t.probeAt.Add(t.gap+natProbeGrace).Sub(now), 0), true
+}
+
+// begin начинает пробу, если она нужна (sentAt - lastSendAt)
+// Возвращает токен и промежуток; false - поиск сошёлся или рано
+func (t *natTuner) begin(now time.Time, base time.Duration, sentAt int64) ([natProbeTokenSize]byte, time.Duration, bool) {
+	var token [natProbeTokenSize]byte
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.probeAt.IsZero() || now.Before(t.nextAt) {
+		return token, 0, false
+	}
+	if !t.tunedAt.IsZero() {
+		if now.Sub(t.tunedAt) < natRetune {
+			return token, 0, false
+		}
+		t.lo, t.hi, t.tunedAt = 0, 0, time.Time{}
+	}
+	gap := t.nextGap(base)
+	if gap == 0 {
+		t.tunedAt = now
+		t.announce = true
+		return token, 0, false
+	}
+	if _, err := rand.Read(token[:]); err != nil {
+		return token, 0, false
+	}
+	t.token, t.gap, t.probeAt, t.sentAt = token, gap, now, sentAt
+	t.probes++
+	return token, gap, true
+}
+
+// ack учитывает ответ сервера на пробу
+// Данные клиента во время пробы освежили маппинг - проба не в счёт
+func (t *natTuner) ack(body []byte, sentAt int64) {
+	if len(body) != natProbeTokenSize {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.probeAt.IsZero() || string(body) != string(t.token[:]) {
+		return
+	}
+	if sentAt == t.sentAt {
+		t.lo = t.gap
+	}
+	t.probeAt = time.Time{}
+}
+
+// expire закрывает пробу без ответа после gap + natProbeGrace
+// true - маппинг пропал: следующая проба - не раньше retry
+func (t *natTuner) expire(now time.Time, sentAt int64, retry time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.probeAt.IsZero() || now.Before(t.probeAt.Add(t.gap+natProbeGrace)) {
+		return false
+	}
+	t.probeAt = time.Time{}
+	if sentAt != t.sentAt {
+		return false
+	}
+	t.hi = t.gap
+	t.lost++
+	t.nextAt = now.Add(retry)
+	return true
+}
+
+// announcement - интервал, который нужно сообщить серверу
+func (t *natTuner) announcement(base time.Duration) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	due := t.announce
+	t.announce = false
+	t.mu.Unlock()
+	return t.interval(base), due
+}
+
+// reset начинает поиск заново: новая сеть - новый NAT
+func (t *natTuner) reset() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lo, t.hi = 0, 0
+	t.probeAt, t.tunedAt, t.nextAt = time.Time{}, time.Time{}, time.Time{}
+	t.announce = true
+}
+
+// reannounce - новая сессия сервера не знает интервал клиента
+func (t *natTuner) reannounce() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.announce = true
+	t.mu.Unlock()
+}
+
+// stats - снимок для ClientStats
+func (t *natTuner) stats(base time.Duration) *KeepAliveTuneStats {
+	if t == nil {
+		return nil
+	}
+	interval := t.interval(base)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return &KeepAliveTuneStats{
+		Interval:  interval,
+		Confirmed: t.confirmed(base),
+		Failed:    t.hi,
+		Tuned:     !t.tunedAt.IsZero(),
+		Probes:    t.probes,
+		Lost:      t.lost,
+	}
+}
+
+// natProbeBody собирает тело NAT_PROBE
+func natProbeBody(token [natProbeTokenSize]byte, gap, interval time.Duration) []byte {
+	body := make([]byte, natProbeBodySize)
+	copy(body, token[:])
+	binary.BigEndian.PutUint32(body[natProbeTokenSize:], uint32(gap.Milliseconds()))
+	binary.BigEndian.PutUint32(body[natProbeTokenSize+4:], uint32(interval.Milliseconds()))
+	return body
+}
+
+// natProbeHold - проба идёт, keep-alive освежил бы маппинг
+// Проба без ответа закрывается здесь, и keep-alive уходит сразу
+func (c *GameTunnelClientConn) natProbeHold(now time.Time, base time.Duration) bool {
+	if c.natTune == nil {
+		return false
+	}
+	if _, ok := c.natTune.pending(now); !ok {
+		return false
+	}
+	if c.natTune.expire(now, atomic.LoadInt64(&c.lastSendAt), c.natTune.interval(base)) {
+		atomic.AddUint64(&metrics.client.natProbesLost, 1)
+		return false
+	}
+	_, ok := c.natTune.pending(now)
+	return ok
+}
+
+// startNATProbe шлёт пробу вместо keep-alive, если клиент молчит
+// дольше base и поиск не сошёлся
+func (c *GameTunnelClientConn) startNATProbe(now time.Time, base time.Duration) bool {
+	if c.natTune == nil {
+		return false
+	}
+	sentAt := atomic.LoadInt64(&c.lastSendAt)
+	if sentAt > now.Add(-base).UnixNano() {
+		return false
+	}
+	token, gap, ok := c.natTune.begin(now, base, sentAt)
+	if !ok {
+		return false
+	}
+	if err := c.sendSealedControl(0x0E, natProbeBody(token, gap, c.natTune.interval(base))); err != nil {
+		return false
+	}
+	atomic.AddUint64(&metrics.client.natProbes, 1)
+	return true
+}
+
+// announceKeepAlive сообщает серверу подобранный интервал
+func (c *GameTunnelClientConn) announceKeepAlive(base time.Duration) {
+	if interval, ok := c.natTune.announcement(base); ok {
+		c.sendSealedControl(0x0E, natProbeBody([natProbeTokenSize]byte{}, 0, interval))
+	}
+}
+
+// GetKeepAliveTune возвращает состояние подбора интервала (nil -
+// keepAliveTune выключен)
+func (c *GameTunnelClientConn) GetKeepAliveTune() *KeepAliveTuneStats {
+	return c.natTune.stats(time.Duration(c.tunedConfig().config.KeepAliveInterval) * time.Second)
+}
+
+// handleNATProbe запоминает интервал keep-alive клиента и через gap
+// отвечает на пробу её токеном
+func (h *Hub) handleNATProbe(session *Session, pkt *Packet, data []byte) error {
+	body, err := h.openControl(session, pkt, data)
+	if err != nil {
+		return err
+	}
+	if len(body) < natProbeBodySize {
+		return fmt.Errorf("nat probe too short")
+	}
+	gap := time.Duration(binary.BigEndian.Uint32(body[natProbeTokenSize:])) * time.Millisecond
+	interval := time.Duration(binary.BigEndian.Uint32(body[natProbeTokenSize+4:])) * time.Millisecond
+	if limit := maxNATProbeGap * time.Second; gap > limit || interval > limit {
+		return fmt.Errorf("nat probe gap %v, interval %v above %v", gap, interval, limit)
+	}
+	atomic.StoreInt64(&session.keepAliveIdle, int64(max(gap, interval)))
+	if gap == 0 {
+		return nil
+	}
+
+	token := append([]byte(nil), body[:natProbeTokenSize]...)
+	timer := time.AfterFunc(gap, func() {
+		if !session.isActive() {
+			return
+		}
+		if err := h.sendSealedControl(session, 0x0F, token); err == nil {
+			h.count(func(c *sideCounters) *uint64 { return &c.natProbes }, 1)
+		}
+	})
+	if old := session.natProbe.Swap(timer); old != nil {
+		old.Stop()
+	}
+	return nil
+}
+
+// keepAliveSpan - молчание сессии, допустимое вместо d: не меньше n
+// интервалов keep-alive, о которых сообщил клиент
+func (s *Session) keepAliveSpan(d time.Duration, n int) time.Duration {
+	return max(d, time.Duration(atomic.LoadInt64(&s.keepAliveIdle))*time.Duration(n))
+}
@@ -0,0 +1,150 @@
+package gametunnel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// simulateNAT прогоняет поиск против NAT, забывающего маппинг после
+// timeout молчания (0 - сервер не отвечает на пробы)
+func simulateNAT(t *testing.T, tuner *natTuner, base, timeout time.Duration) time.Time {
+	t.Helper()
+	now := time.Unix(0, 0)
+	for i := 0; i < 40; i++ {
+		token, gap, ok := tuner.begin(now, base, 0)
+		if !ok {
+			if _, probing := tuner.pending(now); probing {
+				t.Fatal("probe left pending")
+			}
+			if tuner.stats(base).Tuned {
+				return now
+			}
+			now = now.Add(base)
+			continue
+		}
+		if timeout > 0 && gap <= timeout {
+			tuner.ack(token[:], 0)
+			now = now.Add(gap)
+			continue
+		}
+		if tuner.expire(now.Add(gap), 0, base) {
+			t.Fatal("probe expired before its deadline")
+		}
+		now = now.Add(gap + natProbeGrace)
+		if !tuner.expire(now, 0, base) {
+			t.Fatal("unanswered probe not counted as lost")
+		}
+	}
+	t.Fatalf("search did not converge: %+v", tuner.stats(base))
+	return now
+}
+
+func TestNATTunerSearch(t *testing.T) {
+	base := 15 * time.Second
+	config := DefaultConfig()
+	config.KeepAliveTune = true
+
+	for _, timeout := range []time.Duration{100 * time.Second, 45 * time.Second, 10 * time.Minute} {
+		tuner := newNATTuner(config)
+		simulateNAT(t, tuner, base, timeout)
+		stats := tuner.stats(base)
+		limit := min(timeout, defaultKeepAliveMax*time.Second)
+		if stats.Confirmed > limit || stats.Confirmed < limit*3/4 {
+			t.Errorf("NAT timeout %v: confirmed %v", timeout, stats.Confirmed)
+		}
+		// Верхний край разброса таймера укладывается в маппинг
+		if stats.Interval*6/5 >= stats.Confirmed && stats.Interval != base {
+			t.Errorf("NAT timeout %v: interval %v too close to %v", timeout, stats.Interval, stats.Confirmed)
+		}
+		if iv, ok := tuner.announcement(base); !ok || iv != stats.Interval {
+			t.Errorf("tuned interval %v not announced", iv)
+		}
+	}
+
+	// Старый сервер не отвечает - интервал из конфига
+	tuner := newNATTuner(config)
+	simulateNAT(t, tuner, base, 0)
+	if stats := tuner.stats(base); stats.Interval != base || stats.Lost == 0 {
+		t.Errorf("without answers: %+v", stats)
+	}
+
+	// Смена сети - поиск заново
+	tuner.reset()
+	if stats := tuner.stats(base); stats.Tuned || stats.Failed != 0 {
+		t.Errorf("after reset: %+v", stats)
+	}
+
+	config.ChaffBudget = 1000
+	if newNATTuner(config) != nil {
+		t.Error("tuner enabled together with chaff")
+	}
+}
+
+func TestNATTunerDataVoidsProbe(t *testing.T) {
+	base := 15 * time.Second
+	config := DefaultConfig()
+	config.KeepAliveTune = true
+	tuner := newNATTuner(config)
+
+	now := time.Unix(0, 0)
+	token, gap, ok := tuner.begin(now, base, 1)
+	if !ok || gap != 2*base {
+		t.Fatalf("first probe %v %v", gap, ok)
+	}
+	// Данные во время пробы освежили маппинг - ответ не в счёт
+	tuner.ack(token[:], 2)
+	if stats := tuner.stats(base); stats.Confirmed != base {
+		t.Errorf("voided probe confirmed %v", stats.Confirmed)
+	}
+
+	_, gap, _ = tuner.begin(now, base, 2)
+	if tuner.expire(now.Add(gap+natProbeGrace), 3, base) {
+		t.Error("voided probe counted as lost")
+	}
+	if stats := tuner.stats(base); stats.Failed != 0 {
+		t.Errorf("voided probe failed %v", stats.Failed)
+	}
+}
+
+func TestNATProbeLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "nat-tune"
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	clientConfig.KeepAliveTune = true
+	client, _ := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Проба на 40 мс: base 20 мс, клиент молчит
+	base := 20 * time.Millisecond
+	if !client.startNATProbe(time.Now(), base) {
+		t.Fatal("probe not sent")
+	}
+	session := l.hub.sessions.get(client.session().ConnectionID)
+	if session == nil {
+		t.Fatal("server session not found")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.natTune.stats(base).Confirmed != 2*base {
+		if time.Now().After(deadline) {
+			t.Fatalf("probe not answered: %+v", client.natTune.stats(base))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if idle := time.Duration(atomic.LoadInt64(&session.keepAliveIdle)); idle != 2*base {
+		t.Errorf("server keep-alive idle %v, want %v", idle, 2*base)
+	}
+	timeout := l.hub.sessionTimeout()
+	if got := session.keepAliveSpan(timeout, 3); got != timeout {
+		t.Errorf("short client interval changed the timeout to %v", got)
+	}
+	atomic.StoreInt64(&session.keepAliveIdle, int64(time.Minute))
+	if got := session.keepAliveSpan(timeout, 3); got != 3*time.Minute {
+		t.Errorf("session timeout %v, want three client intervals", got)
+	}
+	if client.GetStats().KeepAliveTune == nil {
+		t.Error("client stats without keepAliveTune")
+	}
+}
@@ -0,0 +1,348 @@
+package gametunnel
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// ====================================================================
+// Симулятор сетевых условий: SLO задержки и потерь
+// ====================================================================
+//
+// Полный стек клиент↔сервер поверх сети в памяти (memnet.go) под
+// сценарием условий: RTT, джиттер, потери, узкое место по полосе;
+// условия меняются по фазам. Поверх идёт игровая нагрузка - тики
+// High в обе стороны - и, по сценарию, загрузка Low от сервера.
+// Получатели меряют одностороннюю задержку каждого сообщения, по
+// итогам проверяются SLO сценария:
+//   - p99 задержки High < RTT + 10 мс (RTT - худшей фазы)
+//   - доля доставленных High
+//   - скорость загрузки Low - не ниже доли полосы
+//
+// Изменения планировщика, pacing и очередей, ухудшающие игровой
+// трафик под нагрузкой, валят эти тесты. Сводка по каждому
+// сценарию - в выводе go test -v -run TestNetSim.
+//
+// ====================================================================
+
+const (
+	// simTickSize - игровой тик: меньше HighPriorityMaxSize
+	simTickSize = 64
+
+	// simBulkSize - сообщение загрузки: больше MediumPriorityMaxSize,
+	// но в один пакет - получатель считает сообщения, а не куски
+	simBulkSize = 1100
+
+	// simHeaderSize - вид, номер и время отправки сообщения
+	simHeaderSize = 1 + 8 + 8
+
+	// simSLOMargin - запас p99 High сверх RTT
+	simSLOMargin = 10 * time.Millisecond
+)
+
+// Виды сообщений симулятора
+const (
+	simTick byte = iota + 1
+	simBulk
+)
+
+// simPhase - условия сети на время duration
+type simPhase struct {
+	duration time.Duration
+
+	// rtt и jitter делятся поровну между направлениями
+	rtt       time.Duration
+	jitter    time.Duration
+	loss      float64
+	bandwidth uint64
+}
+
+// link - MemLink одного направления
+func (p simPhase) link() MemLink {
+	return MemLink{
+		Latency:   p.rtt / 2,
+		Jitter:    p.jitter / 2,
+		Loss:      p.loss,
+		Bandwidth: p.bandwidth,
+	}
+}
+
+// simScenario - сценарий: фазы условий, нагрузка и SLO
+type simScenario struct {
+	name   string
+	phases []simPhase
+
+	// tickRate - игровых тиков в секунду в каждую сторону
+	tickRate int
+
+	// bulkRate - предлагаемая загрузка сервер→клиент (байт/сек, 0 - нет)
+	bulkRate uint64
+
+	// config - настройка конфига сценария поверх DefaultConfig
+	config func(*Config)
+
+	// minHighDelivery - доля доставленных тиков
+	minHighDelivery float64
+
+	// minBulkShare - доля полосы, которую должна получить загрузка
+	minBulkShare float64
+}
+
+// simReceiver - учёт принятых сообщений одного направления
+type simReceiver struct {
+	mu        sync.Mutex
+	ticks     []time.Duration
+	bulkBytes uint64
+}
+
+// run читает сообщения из conn до ошибки
+func (r *simReceiver) run(conn net.Conn) {
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if n < simHeaderSize {
+			continue
+		}
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(buf[9:17])))
+		r.mu.Lock()
+		switch buf[0] {
+		case simTick:
+			r.ticks = append(r.ticks, time.Since(sent))
+		case simBulk:
+			r.bulkBytes += uint64(n)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// simMessage собирает сообщение вида kind длиной size
+func simMessage(kind byte, seq uint64, size int) []byte {
+	msg := make([]byte, size)
+	msg[0] = kind
+	binary.BigEndian.PutUint64(msg[1:9], seq)
+	binary.BigEndian.PutUint64(msg[9:17], uint64(time.Now().UnixNano()))
+	return msg
+}
+
+// simSend шлёт сообщения kind с темпом rate в секунду до stop
+func simSend(conn net.Conn, kind byte, size int, rate float64, stop <-chan struct{}) uint64 {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+	var seq uint64
+	for {
+		select {
+		case <-stop:
+			return seq
+		case <-ticker.C:
+		}
+		conn.Write(simMessage(kind, seq, size))
+		seq++
+	}
+}
+
+// simSendBulk шлёт загрузку с темпом rate байт/сек пачками раз в 5 мс:
+// так приложение выдаёт данные из буфера сокета
+func simSendBulk(conn net.Conn, rate uint64, stop <-chan struct{}) {
+	const interval = 5 * time.Millisecond
+	perTick := float64(rate) * interval.Seconds() / simBulkSize
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var seq uint64
+	var owed float64
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for owed += perTick; owed >= 1; owed-- {
+			conn.Write(simMessage(simBulk, seq, simBulkSize))
+			seq++
+		}
+	}
+}
+
+// percentile - p-й перцентиль отсортированных задержек
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// runScenario прогоняет сценарий и проверяет его SLO
+func runScenario(t *testing.T, sc simScenario) {
+	// Соединение поднимается на чистой сети: сценарий меряет
+	// установившийся режим, а не хэндшейк под потерями
+	mem := NewMemNetwork(MemLink{})
+	t.Cleanup(func() { mem.Close() })
+
+	config := DefaultConfig()
+	config.Key = "netsim"
+	if sc.config != nil {
+		sc.config(config)
+	}
+	if payload := int(config.GetMaxPayloadSize()); simBulkSize > payload {
+		t.Fatalf("bulk message %d bytes does not fit payload %d", simBulkSize, payload)
+	}
+	l, ctx, accepted := startMemListener(t, mem, config)
+	addr := l.Addr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	conn, err := Dial(ctx, dest, &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	var server net.Conn
+	select {
+	case server = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not accept the session")
+	}
+
+	up, down := &simReceiver{}, &simReceiver{}
+	go up.run(server)
+	go down.run(conn)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var sentUp, sentDown uint64
+	wg.Add(2)
+	go func() { defer wg.Done(); sentUp = simSend(conn, simTick, simTickSize, float64(sc.tickRate), stop) }()
+	go func() { defer wg.Done(); sentDown = simSend(server, simTick, simTickSize, float64(sc.tickRate), stop) }()
+	if sc.bulkRate > 0 {
+		wg.Add(1)
+		go func() { defer wg.Done(); simSendBulk(server, sc.bulkRate, stop) }()
+	}
+
+	var worstRTT time.Duration
+	var total time.Duration
+	var bandwidth uint64
+	for _, phase := range sc.phases {
+		mem.SetLink(phase.link())
+		time.Sleep(phase.duration)
+		worstRTT = max(worstRTT, phase.rtt)
+		total += phase.duration
+		if phase.bandwidth > 0 && (bandwidth == 0 || phase.bandwidth < bandwidth) {
+			bandwidth = phase.bandwidth
+		}
+	}
+	close(stop)
+	wg.Wait()
+
+	// Хвост в пути: худшая задержка плюс очередь узкого места
+	time.Sleep(worstRTT + memDefaultQueueLimit)
+
+	budget := worstRTT + simSLOMargin
+	for _, dir := range []struct {
+		name string
+		recv *simReceiver
+		sent uint64
+	}{{"up", up, sentUp}, {"down", down, sentDown}} {
+		dir.recv.mu.Lock()
+		ticks := append([]time.Duration(nil), dir.recv.ticks...)
+		dir.recv.mu.Unlock()
+		sort.Slice(ticks, func(i, j int) bool { return ticks[i] < ticks[j] })
+
+		delivery := float64(len(ticks)) / float64(max(dir.sent, 1))
+		p50, p99 := percentile(ticks, 0.5), percentile(ticks, 0.99)
+		t.Logf("%s: high %d/%d (%.1f%%), p50 %v, p99 %v (budget %v)",
+			dir.name, len(ticks), dir.sent, 100*delivery, p50.Round(time.Millisecond), p99.Round(time.Millisecond), budget)
+
+		if p99 >= budget {
+			t.Errorf("%s: high p99 %v, want < RTT + %v = %v", dir.name, p99, simSLOMargin, budget)
+		}
+		if delivery < sc.minHighDelivery {
+			t.Errorf("%s: high delivery %.1f%%, want >= %.1f%%", dir.name, 100*delivery, 100*sc.minHighDelivery)
+		}
+	}
+
+	if sc.bulkRate > 0 {
+		down.mu.Lock()
+		goodput := float64(down.bulkBytes) / total.Seconds()
+		down.mu.Unlock()
+		t.Logf("bulk: %.0f KB/s of %d KB/s link", goodput/1000, bandwidth/1000)
+		if want := sc.minBulkShare * float64(bandwidth); goodput < want {
+			t.Errorf("bulk goodput %.0f B/s, want >= %.0f B/s", goodput, want)
+		}
+	}
+	stats := mem.Stats()
+	t.Logf("network: sent %d, delivered %d, lost %d, overflow %d",
+		stats.Sent, stats.Delivered, stats.Lost, stats.Overflow)
+}
+
+func TestNetSim(t *testing.T) {
+	if testing.Short() {
+		t.Skip("network simulation takes seconds")
+	}
+
+	const mbit = 1_000_000 / 8
+	scenarios := []simScenario{
+		{
+			// Мобильная сеть: потери и джиттер без нагрузки
+			name: "lossy-mobile",
+			phases: []simPhase{
+				{duration: 2 * time.Second, rtt: 80 * time.Millisecond, jitter: 30 * time.Millisecond, loss: 0.03},
+			},
+			tickRate:        60,
+			minHighDelivery: 0.94,
+		},
+		{
+			// Загрузка в канале 8 Мбит/с: pacing держит очередь у
+			// отправителя, где тики обходят загрузку
+			name: "bulk-download",
+			phases: []simPhase{
+				{duration: 2 * time.Second, rtt: 80 * time.Millisecond, jitter: 10 * time.Millisecond, bandwidth: 8 * mbit},
+			},
+			tickRate: 60,
+			bulkRate: 12 * mbit,
+			config: func(c *Config) {
+				c.EnablePacing = true
+				c.PacingRate = 7 * mbit
+			},
+			minHighDelivery: 0.99,
+			minBulkShare:    0.6,
+		},
+		{
+			// Смена сети посреди игры: RTT растёт, полоса падает,
+			// затем всплеск потерь
+			name: "handover",
+			phases: []simPhase{
+				{duration: 700 * time.Millisecond, rtt: 40 * time.Millisecond, jitter: 10 * time.Millisecond, bandwidth: 20 * mbit},
+				{duration: 700 * time.Millisecond, rtt: 120 * time.Millisecond, jitter: 30 * time.Millisecond, bandwidth: 4 * mbit},
+				{duration: 600 * time.Millisecond, rtt: 120 * time.Millisecond, jitter: 30 * time.Millisecond, loss: 0.1, bandwidth: 4 * mbit},
+			},
+			tickRate: 60,
+			bulkRate: 2 * mbit,
+			config: func(c *Config) {
+				c.EnablePacing = true
+				c.PacingRate = 3 * mbit
+			},
+			minHighDelivery: 0.9,
+			minBulkShare:    0.3,
+		},
+	}
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			t.Parallel()
+			runScenario(t, sc)
+		})
+	}
+}
@@ -43,22 +43,85 @@ type Obfuscator interface {
 	Name() string
 }
 
+// handshakeWrapper - обфускатор, у которого пакеты хэндшейка выглядят
+// иначе, чем данные (первые пакеты соединения DPI разбирает
+// внимательнее всего)
+type handshakeWrapper interface {
+	// WrapHandshake оборачивает Client Hello / Server Hello не длиннее
+	// limit байт, если добивка нужна (0 - без ограничения)
+	// Unwrap снимает обе обёртки одинаково
+	WrapHandshake(packet []byte, limit int) ([]byte, error)
+}
+
+// wrapHandshake оборачивает пакет хэндшейка обфускатором соединения
+func wrapHandshake(obfs Obfuscator, packet []byte, limit int) ([]byte, error) {
+	if hw, ok := obfs.(handshakeWrapper); ok {
+		return hw.WrapHandshake(packet, limit)
+	}
+	return obfs.Wrap(packet)
+}
+
+// handshakePadding - размер датаграмм хэндшейка после добивки
+// Клиент и хаб берут его из одного конфига (handshakeMinSize,
+// handshakeMaxSize), так что Client Hello и Server Hello одного
+// сервера добиваются одинаково
+type handshakePadding struct {
+	// min, max - диапазон размера датаграммы (0 - без добивки)
+	min, max int
+
+	// mtu - потолок добивки (0 - без потолка): на пути с MTU меньше
+	// цели полный пакет хэндшейка не пройдёт
+	mtu int
+}
+
+// newHandshakePadding - добивка хэндшейка режима mode из конфига
+func newHandshakePadding(mode ObfuscationMode, config *Config) handshakePadding {
+	lo, hi := config.handshakeSizeRange(mode)
+	return handshakePadding{min: lo, max: hi, mtu: int(config.MTU)}
+}
+
+// handshakeSize - случайный размер датаграммы хэндшейка, не больше
+// limit (0 - без ограничения) и MTU; 0 - добивка не нужна
+func (p handshakePadding) handshakeSize(limit int) int {
+	if p.max == 0 {
+		return 0
+	}
+	size := p.min + mrand.Intn(p.max-p.min+1)
+	if limit > 0 && size > limit {
+		size = limit
+	}
+	if p.mtu > 0 && size > p.mtu {
+		size = p.mtu
+	}
+	return size
+}
+
 // NewObfuscator создаёт обфускатор по режиму из конфига
+// С captureFile пакеты до обёртки пишутся в дамп (capture.go)
 func NewObfuscator(mode ObfuscationMode, config *Config) Obfuscator {
+	var obfs Obfuscator
 	switch mode {
-	case ObfuscationMode_QUIC_MIMIC:
-		return &QUICObfuscator{connIDLen: int(config.ConnectionIdLength)}
 	case ObfuscationMode_WEBRTC_MIMIC:
-		return &WebRTCObfuscator{}
+		obfs = &WebRTCObfuscator{handshakePadding: newHandshakePadding(mode, config)}
 	case ObfuscationMode_RAW:
-		return &RawObfuscator{}
+		obfs = &RawObfuscator{handshakePadding: newHandshakePadding(mode, config)}
 	default:
-		return &QUICObfuscator{connIDLen: int(config.ConnectionIdLength)}
+		obfs = &QUICObfuscator{
+			connIDLen:        int(config.ConnectionIdLength),
+			handshakePadding: newHandshakePadding(ObfuscationMode_QUIC_MIMIC, config),
+		}
 	}
+
+	// Ошибку открытия файла Dial и Listen уже вернули
+	if tap, _ := newPacketTap(config); tap != nil {
+		tap.mode = obfs.Name()
+		return &captureObfuscator{Obfuscator: obfs, tap: tap}
+	}
+	return obfs
 }
 
 // ====================================================================
-// QUIC Obfuscator - маскировка под QUIC v1
+// QUIC Obfuscator - маскировка под QUIC v2
 // ====================================================================
 //
 // Стратегия: наши пакеты УЖЕ имеют QUIC-подобный заголовок
@@ -80,18 +143,54 @@ func NewObfuscator(mode ObfuscationMode, config *Config) Obfuscator {
 // Результат: побайтовая структура идентична настоящему
 // QUIC Initial Packet. Даже Wireshark декодирует его как QUIC.
 //
+// Версия - всегда QUIC v2 (RFC 9369): тип пакета GameTunnel лежит в
+// битах 5-4 флагов как есть, и только в v2 Handshake (01) - это
+// Initial. В v1 те же биты - 0-RTT, и настоящий сервер отбрасывает
+// такой Client Hello без Initial. Версия одна на всё соединение:
+// пакет другой версии посреди соединения стек тоже отбрасывает.
+// Unwrap версию не проверяет, так что старые пиры, шлющие и v1,
+// совместимы. Разбор настоящим стеком проверяет conformance_test.go.
+//
+// Payload короче quicMinPayload (keep-alive без padding) добивается
+// случайными байтами: заголовок настоящего QUIC защищён маской по
+// образцу из payload (RFC 9001, 5.4.2), и стек отбрасывает пакет, из
+// которого образец не взять. Unmarshal хвост за payload игнорирует.
+//
+// Пакеты хэндшейка (WrapHandshake) дополняются случайными байтами
+// до handshakeMinSize-handshakeMaxSize, по умолчанию 1200-1252 байт:
+// RFC 9000 (14.1) требует от клиента Initial не меньше 1200 байт, и
+// браузеры шлют именно такие. Короткий Initial - явный признак
+// не-QUIC. Payload Length покрывает добивку, как
+// PADDING-фреймы внутри зашифрованного payload настоящего QUIC;
+// Unmarshal пакета GameTunnel хвост за payload игнорирует.
+// Client Hello не аутентифицирован, поэтому Server Hello добивается
+// не больше чем до утроенного размера Client Hello (защита от
+// амплификации, RFC 9000 8.1): старому клиенту с коротким Client Hello
+// отвечает почти без добивки.
+//
 // ====================================================================
 
-// QUIC версии для рандомизации
-var quicVersions = []uint32{
-	0x00000001, // QUIC v1 (RFC 9000) - основной
-	0x6B3343CF, // QUIC v2 (RFC 9369)
-}
+// quicVersion - версия QUIC обёртки: QUIC v2 (RFC 9369)
+const quicVersion uint32 = 0x6B3343CF
+
+// quicMinPayload - минимум байт после Payload Length: номер пакета
+// (4) и образец защиты заголовка (16)
+const quicMinPayload = 20
+
+// Размер пакетов хэндшейка в режиме QUIC по умолчанию (как Initial у
+// браузеров)
+const (
+	quicInitialMinSize = 1200
+	quicInitialMaxSize = 1252
+)
 
 // QUICObfuscator маскирует трафик под QUIC
 type QUICObfuscator struct {
 	// connIDLen - длина Connection ID из конфига (вместо хардкода 8)
 	connIDLen int
+
+	// handshakePadding - добивка Initial хэндшейка
+	handshakePadding
 }
 
 func (o *QUICObfuscator) Name() string {
@@ -107,6 +206,18 @@ func (o *QUICObfuscator) Name() string {
 // +--------+----------+--------+------+--------+------+---------+-----------+---------+
 //
 func (o *QUICObfuscator) Wrap(packet []byte) ([]byte, error) {
+	return o.wrap(packet, 0)
+}
+
+// WrapHandshake оборачивает пакет хэндшейка в QUIC Initial размером
+// из handshakePadding, но не больше limit
+func (o *QUICObfuscator) WrapHandshake(packet []byte, limit int) ([]byte, error) {
+	return o.wrap(packet, o.handshakeSize(limit))
+}
+
+// wrap собирает QUIC Initial; minSize > 0 - добить случайными байтами
+// до этого размера датаграммы
+func (o *QUICObfuscator) wrap(packet []byte, minSize int) ([]byte, error) {
 	if len(packet) < FlagsSize+VersionSize {
 		return nil, fmt.Errorf("packet too short for QUIC wrapping: %d bytes", len(packet))
 	}
@@ -131,18 +242,20 @@ func (o *QUICObfuscator) Wrap(packet []byte) ([]byte, error) {
 	scid := make([]byte, scidLen)
 	rand.Read(scid)
 
-	// Выбираем версию QUIC
-	version := quicVersions[mrand.Intn(len(quicVersions))]
-
 	// Собираем QUIC Initial Packet
 	// Размер: flags(1) + version(4) + dcidLen(1) + dcid(N) + scidLen(1) + scid(N) + tokenLen(varint) + payloadLen(varint) + rest
 	//
 	// Token Length = 0 (no retry token)
 	// Payload Length = len(restData) в QUIC variable-length integer
 
-	payloadLenEncoded := encodeQUICVarint(uint64(len(restData)))
+	headerSize := 1 + 4 + 1 + int(dcidLen) + 1 + int(scidLen) + 1
+	payloadLen := max(len(restData), quicMinPayload)
+	for headerSize+len(encodeQUICVarint(uint64(payloadLen)))+payloadLen < minSize {
+		payloadLen = minSize - headerSize - len(encodeQUICVarint(uint64(payloadLen)))
+	}
+	payloadLenEncoded := encodeQUICVarint(uint64(payloadLen))
 
-	totalSize := 1 + 4 + 1 + int(dcidLen) + 1 + int(scidLen) + 1 + len(payloadLenEncoded) + len(restData)
+	totalSize := headerSize + len(payloadLenEncoded) + payloadLen
 	buf := make([]byte, totalSize)
 	offset := 0
 
@@ -151,7 +264,7 @@ func (o *QUICObfuscator) Wrap(packet []byte) ([]byte, error) {
 	offset++
 
 	// 2. Version
-	binary.BigEndian.PutUint32(buf[offset:], version)
+	binary.BigEndian.PutUint32(buf[offset:], quicVersion)
 	offset += 4
 
 	// 3. DCID Length
@@ -182,6 +295,11 @@ func (o *QUICObfuscator) Wrap(packet []byte) ([]byte, error) {
 	copy(buf[offset:], restData)
 	offset += len(restData)
 
+	// 10. Добивка хэндшейка и коротких пакетов - неотличима от
+	// зашифрованного payload
+	rand.Read(buf[offset:totalSize])
+	offset = totalSize
+
 	return buf[:offset], nil
 }
 
@@ -287,20 +405,44 @@ func (o *QUICObfuscator) Unwrap(data []byte) ([]byte, error) {
 // Формат DTLS Record:
 //   ContentType(1) + Version(2) + Epoch(2) + SeqNum(6) + Length(2) + Data
 //
+// Пакеты хэндшейка (WrapHandshake) идут record'ом Handshake (22) в
+// epoch 0, как ClientHello/ServerHello настоящего DTLS: Application
+// Data в первом пакете соединения не бывает. Добивка хэндшейка здесь
+// по умолчанию выключена (ClientHello DTLS короткий) и включается
+// явными handshakeMinSize/handshakeMaxSize; Length record'а покрывает
+// добивку. Данные идут в epoch 1: Application Data в epoch 0
+// настоящий DTLS отбрасывает (данные бывают только после смены
+// ключей). Unwrap epoch не проверяет.
+//
+// Тело record'а Handshake - пакет GameTunnel, а не сообщение
+// хэндшейка DTLS (заголовок msg_type/length/message_seq и
+// ClientHello): DPI с полным разбором DTLS его отличит. Настоящий
+// ClientHello - смена формата провода, несовместимая со старыми
+// пирами (conformance_test.go проверяет только record).
+//
 // ====================================================================
 
 const (
+	// dtlsDataEpoch - epoch record'ов Application Data
+	dtlsDataEpoch = 1
+
 	// DTLS content types
+	dtlsContentTypeHandshake       = 22 // Handshake
 	dtlsContentTypeApplicationData = 23 // Application Data
 
 	// DTLS versions
 	dtlsVersion12Major = 0xFE
 	dtlsVersion12Minor = 0xFD // DTLS 1.2 = {0xFE, 0xFD}
+
+	// dtlsHeaderSize - ContentType(1) + Version(2) + Epoch(2) +
+	// SeqNum(6) + Length(2)
+	dtlsHeaderSize = 13
 )
 
 // WebRTCObfuscator маскирует трафик под DTLS
 type WebRTCObfuscator struct {
-	epoch uint16
+	// handshakePadding - добивка record'ов Handshake
+	handshakePadding
 }
 
 func (o *WebRTCObfuscator) Name() string {
@@ -309,6 +451,16 @@ func (o *WebRTCObfuscator) Name() string {
 
 // Wrap оборачивает пакет в DTLS Application Data record
 func (o *WebRTCObfuscator) Wrap(packet []byte) ([]byte, error) {
+	return o.wrap(packet, dtlsContentTypeApplicationData, dtlsDataEpoch)
+}
+
+// WrapHandshake оборачивает пакет хэндшейка в DTLS Handshake record
+func (o *WebRTCObfuscator) WrapHandshake(packet []byte, limit int) ([]byte, error) {
+	return o.wrap(padHandshake(packet, o.handshakeSize(limit)-dtlsHeaderSize), dtlsContentTypeHandshake, 0)
+}
+
+// wrap собирает DTLS record с заданным типом и epoch
+func (o *WebRTCObfuscator) wrap(packet []byte, contentType byte, epoch uint16) ([]byte, error) {
 	// DTLS Record Header:
 	// ContentType (1 byte): 23 = Application Data
 	// Version (2 bytes): {0xFE, 0xFD} = DTLS 1.2
@@ -317,14 +469,14 @@ func (o *WebRTCObfuscator) Wrap(packet []byte) ([]byte, error) {
 	// Length (2 bytes): length of data
 	// Data: our packet
 
-	headerSize := 1 + 2 + 2 + 6 + 2 // 13 bytes
+	headerSize := dtlsHeaderSize
 	totalSize := headerSize + len(packet)
 
 	buf := make([]byte, totalSize)
 	offset := 0
 
 	// Content Type
-	buf[offset] = dtlsContentTypeApplicationData
+	buf[offset] = contentType
 	offset++
 
 	// Version: DTLS 1.2
@@ -333,7 +485,7 @@ func (o *WebRTCObfuscator) Wrap(packet []byte) ([]byte, error) {
 	offset += 2
 
 	// Epoch
-	binary.BigEndian.PutUint16(buf[offset:], o.epoch)
+	binary.BigEndian.PutUint16(buf[offset:], epoch)
 	offset += 2
 
 	// Sequence Number (6 bytes) - используем текущее время как основу
@@ -359,14 +511,14 @@ func (o *WebRTCObfuscator) Wrap(packet []byte) ([]byte, error) {
 
 // Unwrap снимает DTLS-обёртку
 func (o *WebRTCObfuscator) Unwrap(data []byte) ([]byte, error) {
-	headerSize := 13 // DTLS record header
+	headerSize := dtlsHeaderSize
 
 	if len(data) < headerSize {
 		return nil, fmt.Errorf("DTLS record too short: %d bytes", len(data))
 	}
 
-	// Проверяем Content Type
-	if data[0] != dtlsContentTypeApplicationData {
+	// Проверяем Content Type: данные или хэндшейк
+	if data[0] != dtlsContentTypeApplicationData && data[0] != dtlsContentTypeHandshake {
 		return nil, fmt.Errorf("unexpected DTLS content type: %d", data[0])
 	}
 
@@ -392,7 +544,10 @@ func (o *WebRTCObfuscator) Unwrap(data []byte) ([]byte, error) {
 // ====================================================================
 
 // RawObfuscator передаёт пакеты как есть
-type RawObfuscator struct{}
+// Хэндшейк добивается только с явными handshakeMinSize/handshakeMaxSize
+type RawObfuscator struct {
+	handshakePadding
+}
 
 func (o *RawObfuscator) Name() string {
 	return "raw"
@@ -402,6 +557,23 @@ func (o *RawObfuscator) Wrap(packet []byte) ([]byte, error) {
 	return packet, nil
 }
 
+// WrapHandshake добивает пакет хэндшейка случайными байтами
+func (o *RawObfuscator) WrapHandshake(packet []byte, limit int) ([]byte, error) {
+	return padHandshake(packet, o.handshakeSize(limit)), nil
+}
+
+// padHandshake дописывает к пакету хэндшейка случайные байты до size
+// Unmarshal пакета GameTunnel хвост за payload игнорирует
+func padHandshake(packet []byte, size int) []byte {
+	if size <= len(packet) {
+		return packet
+	}
+	padded := make([]byte, size)
+	copy(padded, packet)
+	rand.Read(padded[len(packet):])
+	return padded
+}
+
 func (o *RawObfuscator) Unwrap(data []byte) ([]byte, error) {
 	return data, nil
 }
@@ -0,0 +1,219 @@
+package gametunnel
+
+import (
+	"context"
+	"encoding/hex"
+	"sync/atomic"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Наблюдатели жизненного цикла сессий
+// ====================================================================
+//
+// SessionObserver получает события сессий без форка транспорта:
+// журнал аудита, автоматизация в духе fail2ban, интеграция с
+// панелями. Наблюдатель регистрируется:
+//   - на сервере: Hub.AddSessionObserver / Listener.AddSessionObserver
+//   - на клиенте: GameTunnelClientConn.AddSessionObserver, а чтобы
+//     получить события самого Dial - ContextWithSessionObserver
+//
+// События:
+//   - Created       - сессия создана (сервер: хэндшейк принят или
+//                     сессия восстановлена из снапшота; клиент: Dial
+//                     завершил хэндшейк)
+//   - Authenticated - от другой стороны пришёл первый пакет,
+//                     расшифрованный ключами сессии: PSK совпал
+//   - Migrated      - клиент сменил адрес (WiFi -> LTE, NAT rebinding)
+//   - Rekeyed       - ключи сессии сменились. Сейчас ключи выводятся
+//                     один раз при хэндшейке, и событие не возникает
+//   - Closed        - сессия закрыта, с причиной (CloseReason)
+//
+// Методы вызываются синхронно из горутин приёма и обслуживания
+// хаба, поэтому должны возвращаться быстро: тяжёлую работу
+// наблюдатель выносит в свою горутину.
+//
+// ====================================================================
+
+// SessionInfo - описание сессии в событиях наблюдателя
+type SessionInfo struct {
+	// ConnectionID - идентификатор сессии (hex)
+	ConnectionID string `json:"connectionId"`
+
+	// RemoteAddr - адрес другой стороны
+	RemoteAddr string `json:"remoteAddr"`
+
+	// User - пользователь сессии (quota.go), пусто - не назначен
+	User string `json:"user,omitempty"`
+
+	// InboundTag - тег inbound-а xray серверной сессии (policy.go)
+	InboundTag string `json:"inboundTag,omitempty"`
+
+	// Client - событие клиентского соединения
+	Client bool `json:"client"`
+}
+
+// SessionObserver - получатель событий жизненного цикла сессий
+type SessionObserver interface {
+	SessionCreated(info SessionInfo)
+	SessionAuthenticated(info SessionInfo)
+	SessionMigrated(info SessionInfo, from string)
+	SessionRekeyed(info SessionInfo)
+	SessionClosed(info SessionInfo, reason CloseReason)
+}
+
+// BaseSessionObserver - наблюдатель, игнорирующий все события
+// Встраивается, чтобы реализовать только нужные методы
+type BaseSessionObserver struct{}
+
+func (BaseSessionObserver) SessionCreated(SessionInfo)             {}
+func (BaseSessionObserver) SessionAuthenticated(SessionInfo)       {}
+func (BaseSessionObserver) SessionMigrated(SessionInfo, string)    {}
+func (BaseSessionObserver) SessionRekeyed(SessionInfo)             {}
+func (BaseSessionObserver) SessionClosed(SessionInfo, CloseReason) {}
+
+// sessionObservers - список наблюдателей (copy-on-write)
+// Нулевое значение готово к использованию
+type sessionObservers struct {
+	list atomic.Pointer[[]SessionObserver]
+}
+
+// add регистрирует наблюдателя
+func (o *sessionObservers) add(observer SessionObserver) {
+	for {
+		old := o.list.Load()
+		var next []SessionObserver
+		if old != nil {
+			next = append(next, *old...)
+		}
+		next = append(next, observer)
+		if o.list.CompareAndSwap(old, &next) {
+			return
+		}
+	}
+}
+
+// each вызывает fn для каждого наблюдателя
+func (o *sessionObservers) each(fn func(SessionObserver)) {
+	list := o.list.Load()
+	if list == nil {
+		return
+	}
+	for _, observer := range *list {
+		fn(observer)
+	}
+}
+
+// empty - наблюдателей нет (описание сессии можно не собирать)
+func (o *sessionObservers) empty() bool {
+	list := o.list.Load()
+	return list == nil || len(*list) == 0
+}
+
+// info собирает описание серверной сессии
+func (s *Session) info() SessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	info := SessionInfo{
+		ConnectionID: hex.EncodeToString(s.ID),
+		User:         s.User,
+		InboundTag:   s.InboundTag,
+	}
+	if s.RemoteAddr != nil {
+		info.RemoteAddr = s.RemoteAddr.String()
+	}
+	return info
+}
+
+// AddSessionObserver регистрирует наблюдателя событий сессий хаба
+func (h *Hub) AddSessionObserver(observer SessionObserver) {
+	h.observers.add(observer)
+}
+
+// AddSessionObserver регистрирует наблюдателя событий сессий listener
+func (l *Listener) AddSessionObserver(observer SessionObserver) {
+	l.hub.AddSessionObserver(observer)
+}
+
+// notifyCreated сообщает наблюдателям о новой сессии
+func (h *Hub) notifyCreated(session *Session) {
+	info := session.info()
+	logf(log.Severity_Info, "%s session from %s created", sessionTag(session.ID), info.RemoteAddr)
+	h.audit.sessionCreated(session)
+	if h.observers.empty() {
+		return
+	}
+	h.observers.each(func(o SessionObserver) { o.SessionCreated(info) })
+}
+
+// markAuthenticated отмечает первый расшифрованный пакет клиента
+func (h *Hub) markAuthenticated(session *Session) {
+	if !atomic.CompareAndSwapInt32(&session.authenticated, 0, 1) || h.observers.empty() {
+		return
+	}
+	info := session.info()
+	h.observers.each(func(o SessionObserver) { o.SessionAuthenticated(info) })
+}
+
+// notifyMigrated сообщает о смене адреса клиента
+func (h *Hub) notifyMigrated(session *Session, from string) {
+	info := session.info()
+	logf(log.Severity_Info, "%s client moved from %s to %s", sessionTag(session.ID), from, info.RemoteAddr)
+	h.audit.sessionMigrated(session, from)
+	if h.observers.empty() {
+		return
+	}
+	h.observers.each(func(o SessionObserver) { o.SessionMigrated(info, from) })
+}
+
+// notifyClosed сообщает о закрытии сессии
+func (h *Hub) notifyClosed(session *Session, reason CloseReason) {
+	logf(log.Severity_Info, "%s session closed: %s", sessionTag(session.ID), reason)
+	h.audit.sessionClosed(session, reason)
+	if h.observers.empty() {
+		return
+	}
+	info := session.info()
+	h.observers.each(func(o SessionObserver) { o.SessionClosed(info, reason) })
+}
+
+// observerKey - ключ наблюдателя в контексте Dial
+type observerKey struct{}
+
+// ContextWithSessionObserver добавляет в контекст наблюдателя,
+// которого Dial зарегистрирует на клиентском соединении при его
+// создании - так он получит и событие Created
+func ContextWithSessionObserver(ctx context.Context, observer SessionObserver) context.Context {
+	return context.WithValue(ctx, observerKey{}, observer)
+}
+
+// sessionObserverFromContext извлекает наблюдателя из контекста Dial
+func sessionObserverFromContext(ctx context.Context) (SessionObserver, bool) {
+	observer, ok := ctx.Value(observerKey{}).(SessionObserver)
+	return observer, ok
+}
+
+// AddSessionObserver регистрирует наблюдателя событий соединения
+func (c *GameTunnelClientConn) AddSessionObserver(observer SessionObserver) {
+	c.observers.add(observer)
+}
+
+// info собирает описание клиентской сессии
+func (c *GameTunnelClientConn) info() SessionInfo {
+	return SessionInfo{
+		ConnectionID: hex.EncodeToString(c.session().ConnectionID),
+		RemoteAddr:   c.socket().conn.RemoteAddr().String(),
+		Client:       true,
+	}
+}
+
+// markAuthenticated отмечает первый расшифрованный пакет сервера
+func (c *GameTunnelClientConn) markAuthenticated() {
+	if !atomic.CompareAndSwapInt32(&c.authenticated, 0, 1) || c.observers.empty() {
+		return
+	}
+	info := c.info()
+	c.observers.each(func(o SessionObserver) { o.SessionAuthenticated(info) })
+}
@@ -0,0 +1,120 @@
+package gametunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// recordingObserver записывает события в виде строк
+type recordingObserver struct {
+	BaseSessionObserver
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recordingObserver) record(event string) {
+	r.mu.Lock()
+	r.events = append(r.events, event)
+	r.mu.Unlock()
+}
+
+func (r *recordingObserver) SessionCreated(info SessionInfo) { r.record("created") }
+
+func (r *recordingObserver) SessionAuthenticated(info SessionInfo) { r.record("authenticated") }
+
+func (r *recordingObserver) SessionMigrated(info SessionInfo, from string) {
+	r.record("migrated " + from + " -> " + info.RemoteAddr)
+}
+
+func (r *recordingObserver) SessionClosed(info SessionInfo, reason CloseReason) {
+	r.record(fmt.Sprintf("closed %d", reason))
+}
+
+// waitEvents ждёт, пока наблюдатель не запишет want
+func (r *recordingObserver) waitEvents(t *testing.T, want ...string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mu.Lock()
+		got := fmt.Sprint(r.events)
+		r.mu.Unlock()
+		if got == fmt.Sprint(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Events: got %s, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSessionObserverLifecycle(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "observer"
+	l, accepted := startTestListener(t, config)
+	serverObs := &recordingObserver{}
+	l.AddSessionObserver(serverObs)
+
+	clientObs := &recordingObserver{}
+	addr := l.Addr().(*net.UDPAddr)
+	clientConfig := *config
+	conn, err := Dial(ContextWithSessionObserver(context.Background(), clientObs),
+		xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port)),
+		&internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: &clientConfig})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	server := <-accepted
+
+	serverObs.waitEvents(t, "created")
+	clientObs.waitEvents(t, "created")
+
+	conn.Write([]byte("ping"))
+	serverObs.waitEvents(t, "created", "authenticated")
+	server.Write([]byte("pong"))
+	clientObs.waitEvents(t, "created", "authenticated")
+
+	session := l.hub.sessions.snapshot()[0]
+	if err := l.hub.KickSession(session.ID); err != nil {
+		t.Fatal(err)
+	}
+	kicked := fmt.Sprintf("closed %d", CloseReason_KICKED)
+	serverObs.waitEvents(t, "created", "authenticated", kicked)
+	clientObs.waitEvents(t, "created", "authenticated", kicked)
+}
+
+func TestSessionObserverMigrationAndTimeout(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "observer"
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	obs := &recordingObserver{}
+	h.AddSessionObserver(obs)
+
+	from := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40001}
+	to := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40002}
+	keys, _ := DeriveSessionKeys([32]byte{3}, config.Key, false)
+	session := h.newSession([]byte{5, 5, 5, 5, 5, 5, 5, 5}, from, keys)
+	h.sessions.put(session)
+
+	data, _ := NewKeepAlivePacket(session.ID, 1).Marshal(config)
+	wrapped, _ := h.obfs.Wrap(data)
+	if _, _, err := h.RoutePacket(wrapped, to); err != nil {
+		t.Fatal(err)
+	}
+
+	h.closeSession(session.ID, CloseReason_TIMEOUT)
+	obs.waitEvents(t,
+		"migrated "+from.String()+" -> "+to.String(),
+		fmt.Sprintf("closed %d", CloseReason_TIMEOUT))
+}
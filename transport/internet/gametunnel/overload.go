@@ -0,0 +1,216 @@
+package gametunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Лимит сессий: отказ с retry-after или вытеснение LRU
+// ====================================================================
+//
+// Без лимита наплыв клиентов (или атака хэндшейками с разных
+// адресов) растит число сессий, пока процесс не упрётся в память.
+// Потолок сессий - меньшее из:
+//   - maxSessions
+//   - memoryBudgetMb / sessionMemoryEstimate
+//
+// sessionMemoryEstimate - грубая оценка памяти сессии (структуры,
+// кольца очередей, типичное заполнение inbound). Точный учёт
+// потребовал бы обхода всех очередей на каждый хэндшейк.
+//
+// Что делать с хэндшейком сверх потолка (overloadPolicy):
+//   - reject - ответить BUSY и не создавать сессию. Ключей ещё
+//              нет, поэтому BUSY не зашифрован:
+//                CONTROL [0x06][retryAfter: uint16 BE, секунды]
+//              Клиент прерывает Dial с ServerBusyError
+//   - evict  - вытеснить сессию, дольше всех не проявлявшую
+//              активности (CLOSE(Evicted)), и принять новую
+//
+// Счётчики отказов и вытеснений - в HubStats и метриках. Там же
+// фактическая оценка: HubStats.MemoryUsage - простаивающие сессии
+// (sessionIdleMemory) плюс занятое очередями чтения, рядом с бюджетом
+// MemoryBudget. Потолок по-прежнему считается по sessionMemoryEstimate
+// с запасом на всплески очередей.
+//
+// ====================================================================
+
+// OverloadPolicy - реакция на хэндшейк сверх лимита сессий
+type OverloadPolicy int32
+
+const (
+	// OverloadPolicy_REJECT - отказать с retry-after
+	OverloadPolicy_REJECT OverloadPolicy = 0
+
+	// OverloadPolicy_EVICT - вытеснить самую давно активную сессию
+	OverloadPolicy_EVICT OverloadPolicy = 1
+)
+
+// OverloadPolicyFromString парсит строковое значение политики
+func OverloadPolicyFromString(s string) OverloadPolicy {
+	switch s {
+	case "evict", "lru", "EVICT":
+		return OverloadPolicy_EVICT
+	default:
+		return OverloadPolicy_REJECT
+	}
+}
+
+const (
+	// sessionMemoryEstimate - оценка памяти одной сессии
+	sessionMemoryEstimate = 64 * 1024
+
+	// sessionIdleMemory - память простаивающей сессии без очередей
+	// (структуры, ключи, кольца очереди отправки; замер - ~11 КБ)
+	sessionIdleMemory = 12 * 1024
+
+	// defaultOverloadRetryAfter - retry-after по умолчанию
+	defaultOverloadRetryAfter = 5 * time.Second
+
+	// controlBusy - команда BUSY (отказ в сессии)
+	controlBusy = 0x06
+)
+
+// ServerBusyError - сервер отказал в сессии из-за перегрузки
+type ServerBusyError struct {
+	// RetryAfter - через сколько сервер советует повторить
+	RetryAfter time.Duration
+}
+
+func (e *ServerBusyError) Error() string {
+	return fmt.Sprintf("server busy, retry after %s", e.RetryAfter)
+}
+
+// sessionCap возвращает потолок сессий (0 = без лимита)
+func sessionCap(config *Config) int32 {
+	limit := int64(config.MaxSessions)
+	if config.MemoryBudgetMb > 0 {
+		byMemory := int64(config.MemoryBudgetMb) * 1024 * 1024 / sessionMemoryEstimate
+		if byMemory < 1 {
+			byMemory = 1
+		}
+		if limit == 0 || byMemory < limit {
+			limit = byMemory
+		}
+	}
+	if limit > 1<<31-1 {
+		limit = 1<<31 - 1
+	}
+	return int32(limit)
+}
+
+// memoryUsage - оценка памяти сессий хаба: простаивающие сессии плюс
+// фактически занятое очередями чтения (inboundMemory байт)
+func (h *Hub) memoryUsage(inboundMemory uint64) uint64 {
+	return uint64(max(h.GetActiveSessions(), 0))*sessionIdleMemory + inboundMemory
+}
+
+// admitSession проверяет лимит сессий перед хэндшейком Client Hello
+// data; policy - политика inbound-а сокета sock
+// При политике evict освобождает место, при reject отвечает BUSY
+func (h *Hub) admitSession(sock *dscpMarker, data []byte, connID []byte, remoteAddr *net.UDPAddr, policy *InboundPolicy) error {
+	config := h.getConfig()
+	limit := sessionCap(config)
+	if limit == 0 || atomic.LoadInt32(&h.activeSessions) < limit {
+		return nil
+	}
+
+	if config.OverloadPolicy == OverloadPolicy_EVICT {
+		if victim := h.leastRecentlyActive(); victim != nil {
+			h.sendClose(victim, CloseReason_EVICTED)
+			h.closeSession(victim.ID, CloseReason_EVICTED)
+			atomic.AddUint64(&h.evicted, 1)
+			atomic.AddUint64(&metrics.server.sessionsEvicted, 1)
+			return nil
+		}
+	}
+
+	atomic.AddUint64(&h.shed, 1)
+	atomic.AddUint64(&metrics.server.sessionsShed, 1)
+	h.sendBusy(sock, data, connID, remoteAddr, policy)
+	return fmt.Errorf("session limit %d reached", limit)
+}
+
+// leastRecentlyActive возвращает сессию с самой старой активностью
+func (h *Hub) leastRecentlyActive() *Session {
+	var (
+		victim *Session
+		oldest time.Time
+	)
+	for _, session := range h.sessions.snapshot() {
+		session.mu.RLock()
+		lastActive := session.LastActiveAt
+		session.mu.RUnlock()
+
+		if victim == nil || lastActive.Before(oldest) {
+			victim, oldest = session, lastActive
+		}
+	}
+	return victim
+}
+
+// sendBusy отвечает на Client Hello data BUSY с retry-after,
+// подписанным PSK клиента (sealHandshakeControl)
+func (h *Hub) sendBusy(sock *dscpMarker, data []byte, connID []byte, remoteAddr *net.UDPAddr, policy *InboundPolicy) error {
+	psk, clientKey, err := h.handshakeControlKey(data, policy)
+	if err != nil {
+		return fmt.Errorf("sign busy: %w", err)
+	}
+	config := h.getConfig()
+	retryAfter := time.Duration(config.OverloadRetryAfter) * time.Second
+	if retryAfter == 0 {
+		retryAfter = defaultOverloadRetryAfter
+	}
+
+	payload := make([]byte, 3, 3+handshakeControlMACSize)
+	payload[0] = controlBusy
+	binary.BigEndian.PutUint16(payload[1:], uint16(retryAfter/time.Second))
+	payload = sealHandshakeControl(payload, psk, clientKey)
+
+	packet, err := NewControlPacket(connID, 0, payload).Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal busy: %w", err)
+	}
+	wrapped, err := h.obfs.Wrap(packet)
+	if err != nil {
+		return fmt.Errorf("wrap busy: %w", err)
+	}
+	_, err = sock.WriteToUDP(wrapped, remoteAddr, PriorityHigh)
+	return err
+}
+
+// handshakeControlKey - PSK и ключ клиента из Client Hello data для
+// подписи BUSY и RETRY; PSK - пользователя из Client Hello (users.go)
+func (h *Hub) handshakeControlKey(data []byte, policy *InboundPolicy) (string, [Curve25519KeySize]byte, error) {
+	hello, err := parseClientHello(data, int(h.getConfig().ConnectionIdLength))
+	if err != nil {
+		return "", [Curve25519KeySize]byte{}, err
+	}
+	_, psk, err := h.helloUser(hello, policy)
+	if err != nil {
+		return "", [Curve25519KeySize]byte{}, err
+	}
+	return psk, hello.PublicKey, nil
+}
+
+// parseBusy разбирает payload BUSY без подписи; false - это не BUSY
+func parseBusy(payload []byte) (*ServerBusyError, bool) {
+	if len(payload) != 3 || payload[0] != controlBusy {
+		return nil, false
+	}
+	seconds := binary.BigEndian.Uint16(payload[1:])
+	return &ServerBusyError{RetryAfter: time.Duration(seconds) * time.Second}, true
+}
+
+// GetShedSessions - хэндшейков отклонено по лимиту сессий
+func (h *Hub) GetShedSessions() uint64 {
+	return atomic.LoadUint64(&h.shed)
+}
+
+// GetEvictedSessions - сессий вытеснено по лимиту
+func (h *Hub) GetEvictedSessions() uint64 {
+	return atomic.LoadUint64(&h.evicted)
+}
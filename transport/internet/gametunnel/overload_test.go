@@ -0,0 +1,102 @@
+package gametunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+func TestSessionCap(t *testing.T) {
+	config := DefaultConfig()
+	if n := sessionCap(config); n != 0 {
+		t.Errorf("Unlimited: got %d", n)
+	}
+
+	config.MaxSessions = 100
+	if n := sessionCap(config); n != 100 {
+		t.Errorf("maxSessions: got %d", n)
+	}
+
+	// 1 МБ / 64 КБ = 16 сессий - бюджет строже maxSessions
+	config.MemoryBudgetMb = 1
+	if n := sessionCap(config); n != 16 {
+		t.Errorf("memoryBudgetMb: got %d", n)
+	}
+
+	config.MaxSessions = 0
+	config.MemoryBudgetMb = 2
+	if n := sessionCap(config); n != 32 {
+		t.Errorf("Budget only: got %d", n)
+	}
+}
+
+func TestParseBusy(t *testing.T) {
+	busy, ok := parseBusy([]byte{controlBusy, 0, 7})
+	if !ok || busy.RetryAfter != 7*time.Second {
+		t.Fatalf("parseBusy: %v %v", busy, ok)
+	}
+	if _, ok := parseBusy([]byte{0x03, 0, 7}); ok {
+		t.Error("Close parsed as BUSY")
+	}
+	if _, ok := parseBusy([]byte{controlBusy}); ok {
+		t.Error("Short payload parsed as BUSY")
+	}
+}
+
+func TestOverloadReject(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "overload"
+	config.MaxSessions = 1
+	config.OverloadRetryAfter = 9
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	dialTestClient(t, l, &clientConfig, accepted)
+
+	addr := l.Addr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	_, err := Dial(context.Background(), dest, &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: &clientConfig,
+	})
+
+	var busy *ServerBusyError
+	if !errors.As(err, &busy) {
+		t.Fatalf("Dial over limit: got %v, want ServerBusyError", err)
+	}
+	if busy.RetryAfter != 9*time.Second {
+		t.Errorf("RetryAfter: got %v", busy.RetryAfter)
+	}
+	if l.hub.GetShedSessions() != 1 || l.hub.GetActiveSessions() != 1 {
+		t.Errorf("shed=%d active=%d", l.hub.GetShedSessions(), l.hub.GetActiveSessions())
+	}
+}
+
+func TestOverloadEvict(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "overload"
+	config.MaxSessions = 1
+	config.OverloadPolicy = OverloadPolicy_EVICT
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+
+	first, _ := dialTestClient(t, l, &clientConfig, accepted)
+	idleFor(l.hub.sessions.snapshot()[0], time.Minute)
+	dialTestClient(t, l, &clientConfig, accepted)
+
+	if l.hub.GetEvictedSessions() != 1 || l.hub.GetActiveSessions() != 1 {
+		t.Fatalf("evicted=%d active=%d", l.hub.GetEvictedSessions(), l.hub.GetActiveSessions())
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for first.CloseReason() != CloseReason_EVICTED {
+		if time.Now().After(deadline) {
+			t.Fatal("Evicted client did not receive Evicted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
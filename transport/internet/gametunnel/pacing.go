@@ -0,0 +1,127 @@
+package gametunnel
+
+import (
+	"time"
+)
+
+// ====================================================================
+// Pacing - сглаживание отправки Low/Medium
+// ====================================================================
+//
+// Отправитель выводит накопленную очередь загрузки пачкой
+// MTU-пакетов. Пачка заполняет буфер узкого места (роутер, модем),
+// и игровой пакет, идущий следом по тому же пути, стоит в этом
+// буфере - самодельный bufferbloat.
+//
+// Pacer раздаёт Low/Medium-пакеты равномерно: token bucket с
+// маленьким burst пополняется со скоростью pacing rate.
+//   - High не ждёт никогда, но расходует токены - общий темп
+//     отправки остаётся в пределах rate
+//   - rate задаётся в конфиге (pacingRate) или берётся из оценки
+//     пропускной способности: максимум замеров × pacingGain.
+//     Запас pacingGain даёт скорости расти, а не закрепляться
+//     на достигнутом уровне
+//   - Пока оценки нет (первая секунда), pacing не действует
+//   - С метками времени (timestamps.go) растущая очередь на пути от
+//     нас тормозит Low/Medium: они расходуют pacingDrainFactor
+//     токенов на байт, пока буфер узкого места не опустеет
+//
+// ====================================================================
+
+const (
+	// pacingGain - множитель оценки пропускной способности
+	pacingGain = 2.0
+
+	// pacingMinRate - нижняя граница автоматического rate (байт/сек)
+	// 10 Мбит/с: медленнее разгонять загрузку бессмысленно
+	pacingMinRate = 1_250_000
+
+	// pacingBurstPackets - пакетов, уходящих подряд без пауз
+	pacingBurstPackets = 4
+
+	// pacingDrainFactor - токенов на байт Low/Medium при раздутом
+	// буфере (timestamps.go)
+	pacingDrainFactor = 2
+)
+
+// pacer - равномерная отправка по оценке пропускной способности
+type pacer struct {
+	// bucket - токены на отправку; rate обновляется перед каждым пакетом
+	bucket *tokenBucket
+
+	// fixedRate - rate из конфига (0 = по оценке)
+	fixedRate uint64
+
+	// bandwidth - оценка пропускной способности отправителя
+	bandwidth *BandwidthEstimator
+}
+
+// newPacer создаёт pacer; nil, если pacing выключен
+func newPacer(config *Config, bandwidth *BandwidthEstimator) *pacer {
+	if !config.EnablePacing {
+		return nil
+	}
+
+	rate := config.PacingRate
+	if rate == 0 {
+		rate = pacingMinRate
+	}
+
+	// Bucket собираем вручную: newTokenBucket поднимает burst
+	// до 100ms трафика, а pacing нужен именно маленький burst
+	burst := float64(MaxPacketSize * pacingBurstPackets)
+	bucket := &tokenBucket{
+		rate:   float64(rate),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+
+	return &pacer{
+		bucket:    bucket,
+		fixedRate: config.PacingRate,
+		bandwidth: bandwidth,
+	}
+}
+
+// currentRate возвращает pacing rate (байт/сек); 0 - не ограничивать
+func (p *pacer) currentRate() float64 {
+	if p.fixedRate > 0 {
+		return float64(p.fixedRate)
+	}
+
+	estimate := p.bandwidth.GetMax()
+	if estimate == 0 {
+		return 0
+	}
+
+	rate := estimate * pacingGain
+	if rate < pacingMinRate {
+		rate = pacingMinRate
+	}
+	return rate
+}
+
+// delay возвращает паузу перед отправкой пакета размером n
+// trend - метки времени сессии пакета (nil - не согласованы)
+// nil-pacer (pacing выключен) не задерживает
+func (p *pacer) delay(n int, level PriorityLevel, trend *delayTrend) time.Duration {
+	if p == nil {
+		return 0
+	}
+
+	rate := p.currentRate()
+	if rate == 0 {
+		return 0
+	}
+	p.bucket.setRate(rate)
+
+	if level != PriorityHigh && trend.sendBloated() {
+		n *= pacingDrainFactor
+	}
+	wait := p.bucket.reserve(n)
+	if level == PriorityHigh {
+		return 0
+	}
+	return wait
+}
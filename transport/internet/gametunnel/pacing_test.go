@@ -0,0 +1,109 @@
+package gametunnel
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPacerDisabled(t *testing.T) {
+	p := newPacer(DefaultConfig(), NewBandwidthEstimator())
+	if p != nil {
+		t.Fatal("Pacing should be off by default")
+	}
+	if d := p.delay(1500, PriorityLow, nil); d != 0 {
+		t.Errorf("Disabled pacer delayed by %v", d)
+	}
+}
+
+func TestPacerSpacesLowPriority(t *testing.T) {
+	config := DefaultConfig()
+	config.EnablePacing = true
+	config.PacingRate = 150_000 // 1500 байт = 10ms
+
+	p := newPacer(config, NewBandwidthEstimator())
+
+	// Burst уходит без пауз
+	for i := 0; i < pacingBurstPackets; i++ {
+		if d := p.delay(1500, PriorityLow, nil); d != 0 {
+			t.Fatalf("Packet %d within burst delayed by %v", i, d)
+		}
+	}
+
+	d := p.delay(1500, PriorityLow, nil)
+	if d < 5*time.Millisecond || d > 15*time.Millisecond {
+		t.Errorf("Paced delay: got %v, want ~10ms", d)
+	}
+
+	// High не ждёт, даже когда токены кончились
+	if d := p.delay(100, PriorityHigh, nil); d != 0 {
+		t.Errorf("High priority delayed by %v", d)
+	}
+}
+
+func TestPacerAutoRate(t *testing.T) {
+	config := DefaultConfig()
+	config.EnablePacing = true
+
+	be := NewBandwidthEstimator()
+	p := newPacer(config, be)
+
+	// Пока нет оценки - без pacing
+	if rate := p.currentRate(); rate != 0 {
+		t.Errorf("No estimate: got rate %.0f, want 0", rate)
+	}
+
+	be.mu.Lock()
+	be.samples = append(be.samples, 1_000_000, 4_000_000)
+	be.mu.Unlock()
+
+	if rate := p.currentRate(); rate != 4_000_000*pacingGain {
+		t.Errorf("Auto rate: got %.0f, want %.0f", rate, 4_000_000*pacingGain)
+	}
+}
+
+func TestHubPacingDoesNotDelayHigh(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "pacing"
+	config.EnablePacing = true
+	config.PacingRate = 5000 // пакет 1000 байт = 200ms
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	clientConfig.EnablePacing = false
+	pacedClient, pacedServer := dialTestClient(t, l, &clientConfig, accepted)
+	highClient, highServer := dialTestClient(t, l, &clientConfig, accepted)
+
+	if err := pacedServer.(*GameTunnelConn).SetPriority(0, PriorityLow); err != nil {
+		t.Fatal(err)
+	}
+	if err := highServer.(*GameTunnelConn).SetPriority(0, PriorityHigh); err != nil {
+		t.Fatal(err)
+	}
+
+	// Burst уходит сразу, остальные Low ждут паузы pacing
+	const packets = 10
+	for i := byte(0); i < packets; i++ {
+		pacedServer.Write(bytes.Repeat([]byte{'a' + i}, 1000))
+	}
+
+	// Пауза pacing одной сессии не держит High соседней
+	time.Sleep(50 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		highServer.Write([]byte("high"))
+		if got := readWithTimeout(t, highClient, 4); string(got) != "high" {
+			t.Fatalf("high client got %q", got)
+		}
+		if d := time.Since(start); d > 100*time.Millisecond {
+			t.Errorf("High packet %d waited %v behind a paced session", i, d)
+		}
+	}
+
+	// Отложенные пакеты приходят все и по порядку
+	for i := byte(0); i < packets; i++ {
+		got := readWithTimeout(t, pacedClient, 1000)
+		if !bytes.Equal(got, bytes.Repeat([]byte{'a' + i}, 1000)) {
+			t.Fatalf("paced packet %d: %q...", i, got[:min(len(got), 8)])
+		}
+	}
+}
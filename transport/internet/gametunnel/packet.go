@@ -1,11 +1,13 @@
 package gametunnel
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	mrand "math/rand"
+	"sync/atomic"
 )
 
 // ====================================================================
@@ -78,6 +80,22 @@ const (
 	PacketType_CONTROL PacketType = 0x03
 )
 
+// String - имя типа для журнала и дампов
+func (t PacketType) String() string {
+	switch t {
+	case PacketType_DATA:
+		return "DATA"
+	case PacketType_HANDSHAKE:
+		return "HANDSHAKE"
+	case PacketType_KEEPALIVE:
+		return "KEEPALIVE"
+	case PacketType_CONTROL:
+		return "CONTROL"
+	default:
+		return fmt.Sprintf("TYPE_%d", uint8(t))
+	}
+}
+
 // Константы протокола
 const (
 	// FakeQUICVersion - фейковая версия QUIC v1 (RFC 9000)
@@ -115,6 +133,10 @@ const (
 	FlagTypeShift  = 4
 	FlagPaddingBit = 0x08 // Bit 3: Padding present
 	FlagReserved   = 0x07 // Bits 2-0: Reserved (random)
+
+	// FlagKeepAliveBit - DATA-пакет запрашивает ответный keep-alive
+	// (bit 2 из reserved, piggyback.go)
+	FlagKeepAliveBit = 0x04
 )
 
 // Packet - структура пакета GameTunnel в памяти
@@ -200,6 +222,12 @@ func DecodeFlags(flags byte) (packetType PacketType, hasPadding bool, err error)
 // Возвращает пакет БЕЗ шифрования - шифрование выполняется отдельно в crypto.go
 // Формат: [flags][version][connID][pktNum][payloadLen][payload][padding][padLen]
 func (p *Packet) Marshal(config *Config) ([]byte, error) {
+	return p.MarshalTo(nil, config)
+}
+
+// MarshalTo дописывает сериализованный пакет в dst и возвращает
+// расширенный срез; при нехватке ёмкости dst выделяется новый буфер
+func (p *Packet) MarshalTo(dst []byte, config *Config) ([]byte, error) {
 	connIDLen := int(config.ConnectionIdLength)
 
 	if len(p.ConnectionID) != connIDLen {
@@ -207,36 +235,12 @@ func (p *Packet) Marshal(config *Config) ([]byte, error) {
 			len(p.ConnectionID), connIDLen)
 	}
 
-	// Рассчитываем размер padding
-	paddingSize := 0
-	if p.HasPadding && config.EnablePadding {
-		minPad := int(config.PaddingMinSize)
-		maxPad := int(config.PaddingMaxSize)
-		if maxPad > minPad {
-			paddingSize = minPad + mrand.Intn(maxPad-minPad)
-		} else {
-			paddingSize = minPad
-		}
-	}
-
-	// Общий размер пакета
-	totalSize := FlagsSize + VersionSize + connIDLen + PacketNumberSize +
-		PayloadLengthSize + len(p.Payload)
-
-	if p.HasPadding && paddingSize > 0 {
-		totalSize += paddingSize + PaddingLengthSize
-	}
-
-	// Проверяем, что не превышаем MTU
-	// if totalSize > int(config.MTU) {
-	// 	return nil, fmt.Errorf("packet size %d exceeds MTU %d", totalSize, config.MTU)
-	// }
+	paddingSize := packetPaddingSize(p.HasPadding, config)
+	headerSize := FlagsSize + VersionSize + connIDLen
 
 	// Разрешаем любой размер - UDP сам фрагментирует если нужно
 	// Чанкинг в Write/SendToSession контролирует размер
-	_ = MaxPacketSize
-
-	buf := make([]byte, totalSize)
+	dst, buf := growPacket(dst, headerSize+packetTailSize(len(p.Payload), paddingSize))
 	offset := 0
 
 	// 1. Flags
@@ -251,37 +255,94 @@ func (p *Packet) Marshal(config *Config) ([]byte, error) {
 	copy(buf[offset:], p.ConnectionID)
 	offset += connIDLen
 
+	// 4-7. Номер, длина, payload, padding
+	putPacketTail(buf[offset:], p.PacketNumber, p.Payload, paddingSize)
+	return dst, nil
+}
+
+// packetPaddingSize выбирает размер padding пакета (0 - без padding)
+func packetPaddingSize(hasPadding bool, config *Config) int {
+	if !hasPadding || !config.EnablePadding {
+		return 0
+	}
+	minPad := int(config.PaddingMinSize)
+	maxPad := int(config.PaddingMaxSize)
+	if maxPad > minPad {
+		return minPad + mrand.Intn(maxPad-minPad)
+	}
+	return minPad
+}
+
+// packetTailSize - размер пакета после Connection ID
+func packetTailSize(payloadLen, paddingSize int) int {
+	size := PacketNumberSize + PayloadLengthSize + payloadLen
+	if paddingSize > 0 {
+		size += paddingSize + PaddingLengthSize
+	}
+	return size
+}
+
+// growPacket дописывает к dst место под size байт пакета
+// Возвращает новый dst и срез под пакет; растёт, только если не
+// хватает ёмкости
+func growPacket(dst []byte, size int) ([]byte, []byte) {
+	start := len(dst)
+	if cap(dst)-start < size {
+		grown := make([]byte, start, start+size)
+		copy(grown, dst)
+		dst = grown
+	}
+	dst = dst[:start+size]
+	return dst, dst[start:]
+}
+
+// putPacketTail пишет в buf всё после Connection ID: номер пакета,
+// длину payload, payload и padding с его длиной
+func putPacketTail(buf []byte, pktNum uint32, payload []byte, paddingSize int) {
+	offset := 0
+
 	// 4. Packet Number
-	binary.BigEndian.PutUint32(buf[offset:], p.PacketNumber)
+	binary.BigEndian.PutUint32(buf[offset:], pktNum)
 	offset += PacketNumberSize
 
 	// 5. Payload Length
-	binary.BigEndian.PutUint16(buf[offset:], uint16(len(p.Payload)))
+	binary.BigEndian.PutUint16(buf[offset:], uint16(len(payload)))
 	offset += PayloadLengthSize
 
 	// 6. Payload
-	copy(buf[offset:], p.Payload)
-	offset += len(p.Payload)
+	copy(buf[offset:], payload)
+	offset += len(payload)
 
 	// 7. Padding + Padding Length (если есть)
-	if p.HasPadding && paddingSize > 0 {
+	if paddingSize > 0 {
 		// Заполняем padding случайными байтами
-		padding := make([]byte, paddingSize)
-		rand.Read(padding)
-		copy(buf[offset:], padding)
+		rand.Read(buf[offset : offset+paddingSize])
 		offset += paddingSize
 
 		// Длина padding
 		binary.BigEndian.PutUint16(buf[offset:], uint16(paddingSize))
-		offset += PaddingLengthSize
 	}
-
-	return buf[:offset], nil
 }
 
 // Unmarshal десериализует пакет из байтов, полученных из сети
 // Ожидает пакет ПОСЛЕ расшифровки
+// ConnectionID и Payload - копии, data можно переиспользовать
 func Unmarshal(data []byte, connIDLen int) (*Packet, error) {
+	p, err := UnmarshalView(data, connIDLen)
+	if err != nil {
+		return nil, err
+	}
+	p.ConnectionID = bytes.Clone(p.ConnectionID)
+	p.Payload = bytes.Clone(p.Payload)
+	return p, nil
+}
+
+// UnmarshalView разбирает пакет без копирования: ConnectionID и
+// Payload - срезы data
+// Пакет действителен, пока жив и не меняется data; хранить его поля
+// дольше нельзя - только скопировать или расшифровать в новый буфер
+// (горячий путь приёма DATA, bufpool.go)
+func UnmarshalView(data []byte, connIDLen int) (*Packet, error) {
 	if len(data) < FlagsSize+VersionSize+connIDLen+PacketNumberSize+PayloadLengthSize {
 		return nil, fmt.Errorf("packet too short: %d bytes, minimum %d",
 			len(data), FlagsSize+VersionSize+connIDLen+PacketNumberSize+PayloadLengthSize)
@@ -309,8 +370,7 @@ func Unmarshal(data []byte, connIDLen int) (*Packet, error) {
 	offset += VersionSize
 
 	// 3. Connection ID
-	p.ConnectionID = make([]byte, connIDLen)
-	copy(p.ConnectionID, data[offset:offset+connIDLen])
+	p.ConnectionID = data[offset : offset+connIDLen : offset+connIDLen]
 	offset += connIDLen
 
 	// 4. Packet Number
@@ -329,9 +389,8 @@ func Unmarshal(data []byte, connIDLen int) (*Packet, error) {
 		return nil, fmt.Errorf("packet truncated: payload length %d, available %d",
 			payloadLen, len(data)-offset)
 	}
-	p.Payload = make([]byte, payloadLen)
-	copy(p.Payload, data[offset:offset+int(payloadLen)])
-	offset += int(payloadLen)
+	end := offset + int(payloadLen)
+	p.Payload = data[offset:end:end]
 
 	// 7. Padding - пропускаем (нам не нужно содержимое)
 	// Padding используется только для маскировки размера пакета
@@ -403,3 +462,66 @@ func IsQUICLike(firstByte byte) bool {
 	// QUIC Long Header: Form bit = 1, Fixed bit = 1
 	return (firstByte & (FlagFormBit | FlagFixedBit)) == (FlagFormBit | FlagFixedBit)
 }
+
+// ====================================================================
+// Additional data и шаблон заголовка DATA-пакетов
+// ====================================================================
+//
+// AD пакета данных - его заголовок до номера пакета: [flags][version]
+// [connID]. Из всего заголовка от пакета к пакету может меняться
+// только флаг padding в flags, поэтому сессия хранит оба готовых
+// варианта и не собирает 13 байт на каждый пакет. Тот же вариант -
+// шаблон заголовка для appendDataPacket: пакет собирается копией
+// шаблона, кодируются только номер и длины. Кэш неизменяемый:
+// одновременные Write берут его без блокировок, а смена Connection ID
+// (connid.go) просто подменяет его новым.
+//
+// ====================================================================
+
+// dataAD - готовые AD DATA-пакетов одного Connection ID
+type dataAD struct {
+	connID []byte
+
+	// variants - [без padding, с padding]
+	variants [2][]byte
+}
+
+// newDataAD собирает оба варианта AD для connID
+func newDataAD(connID []byte) *dataAD {
+	a := &dataAD{connID: bytes.Clone(connID)}
+	for i, padding := range []bool{false, true} {
+		ad := make([]byte, FlagsSize+VersionSize+len(connID))
+		ad[0] = NewDataPacket(connID, 0, nil, padding).EncodeFlags()
+		binary.BigEndian.PutUint32(ad[FlagsSize:], FakeQUICVersion)
+		copy(ad[FlagsSize+VersionSize:], connID)
+		a.variants[i] = ad
+	}
+	return a
+}
+
+// appendDataPacket дописывает к dst DATA-пакет по шаблону заголовка
+// header (loadDataAD): [flags][version][connID] копируется как есть,
+// пишутся только номер, длина, payload и padding. Результат - как у
+// MarshalTo пакета NewDataPacket с тем же флагом padding
+func appendDataPacket(dst, header []byte, pktNum uint32, payload []byte, config *Config) []byte {
+	paddingSize := packetPaddingSize(header[0]&FlagPaddingBit != 0, config)
+	dst, buf := growPacket(dst, len(header)+packetTailSize(len(payload), paddingSize))
+	copy(buf, header)
+	putPacketTail(buf[len(header):], pktNum, payload, paddingSize)
+	return dst
+}
+
+// loadDataAD возвращает AD DATA-пакета из кэша slot, пересобирая кэш
+// при смене Connection ID
+// Результат только для чтения
+func loadDataAD(slot *atomic.Pointer[dataAD], connID []byte, padding bool) []byte {
+	a := slot.Load()
+	if a == nil || !bytes.Equal(a.connID, connID) {
+		a = newDataAD(connID)
+		slot.Store(a)
+	}
+	if padding {
+		return a.variants[1]
+	}
+	return a.variants[0]
+}
@@ -0,0 +1,229 @@
+package gametunnel
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Адаптивный padding: отступ под нагрузкой канала
+// ====================================================================
+//
+// Padding добавляет к каждому DATA paddingMinSize-paddingMaxSize
+// байт. На узком мобильном uplink это 20-50% полосы - ровно тогда,
+// когда её не хватает. paddingBackoff связывает размер padding с
+// загрузкой отправителя по BandwidthEstimator:
+//
+//	загрузка = скорость за последнюю секунду / ёмкость канала
+//
+//	none   - padding всегда полный (по умолчанию)
+//	linear - полный до 50% загрузки, дальше уменьшается ступенями
+//	         по четверти и с 90% остаётся только заголовок
+//	step   - полный до 90% загрузки, выше - только заголовок
+//
+// Ёмкость - paddingBandwidth (байт/сек) или, если он 0, максимум
+// замеров оценщика, но не меньше 10 Мбит/с: без ёмкости ровный
+// игровой поток выглядел бы насыщенным каналом. На каналах медленнее
+// 10 Мбит/с задайте paddingBandwidth. С метками времени (timestamps.go)
+// растущая очередь на пути от нас - тоже насыщение: пакеты сессии
+// идут с одним заголовком, пока очередь не спадёт.
+//
+// "Только заголовок" - бит padding в флагах остаётся, а байт padding
+// нет: получатель читает длину payload и padding не разбирает, так
+// что режим действует только на своей стороне и не согласуется.
+// Форма пакетов под нагрузкой ближе к голому трафику - это цена
+// полосы. Покрывающие пакеты (chaff.go) уходят в простое и padding
+// не уменьшают.
+//
+// Уровень пересчитывается не чаще paddingRecheck. Уменьшенные пакеты
+// и оценка сэкономленных байт (по среднему padding) - в HubStats и
+// ClientStats (Padding) и в метриках.
+//
+// ====================================================================
+
+// PaddingBackoff - уменьшение padding под нагрузкой канала
+type PaddingBackoff int32
+
+const (
+	// PaddingBackoff_NONE - padding всегда полный
+	PaddingBackoff_NONE PaddingBackoff = 0
+
+	// PaddingBackoff_LINEAR - ступенями между paddingBackoffStart и
+	// paddingBackoffFull
+	PaddingBackoff_LINEAR PaddingBackoff = 1
+
+	// PaddingBackoff_STEP - сразу до заголовка с paddingBackoffFull
+	PaddingBackoff_STEP PaddingBackoff = 2
+)
+
+// PaddingBackoffFromString парсит строковое значение режима
+func PaddingBackoffFromString(s string) PaddingBackoff {
+	switch s {
+	case "linear", "LINEAR":
+		return PaddingBackoff_LINEAR
+	case "step", "STEP":
+		return PaddingBackoff_STEP
+	default:
+		return PaddingBackoff_NONE
+	}
+}
+
+// String возвращает имя режима для статистики
+func (b PaddingBackoff) String() string {
+	switch b {
+	case PaddingBackoff_LINEAR:
+		return "linear"
+	case PaddingBackoff_STEP:
+		return "step"
+	default:
+		return "none"
+	}
+}
+
+const (
+	// paddingBackoffStart / paddingBackoffFull - загрузка, с которой
+	// padding уменьшается и с которой остаётся только заголовок
+	paddingBackoffStart = 0.5
+	paddingBackoffFull  = 0.9
+
+	// paddingSteps - ступеней от полного padding до заголовка
+	paddingSteps = 4
+
+	// paddingMinCapacity - нижняя граница ёмкости по оценщику
+	// (байт/сек, 10 Мбит/с)
+	paddingMinCapacity = 1_250_000
+
+	// paddingRecheck - интервал пересчёта уровня
+	paddingRecheck = 100 * time.Millisecond
+)
+
+// paddingGovernor - уровень padding отправителя (хаба или клиента)
+type paddingGovernor struct {
+	bandwidth *BandwidthEstimator
+
+	// step - ступень по загрузке (0 - полный padding, paddingSteps -
+	// только заголовок), checkedAt - время пересчёта (atomic)
+	step      int32
+	checkedAt int64
+
+	// reduced - пакетов с уменьшенным padding, saved - оценка
+	// неотправленных байт padding (atomic)
+	reduced uint64
+	saved   uint64
+
+	// side - счётчики метрик стороны (metrics.go)
+	side *sideCounters
+}
+
+// paddingVariant - конфиг с padding ступени step
+// base - конфиг, из которого он собран
+type paddingVariant struct {
+	base   *Config
+	step   int
+	config *Config
+}
+
+// PaddingStats - адаптивный padding отправителя
+type PaddingStats struct {
+	// Backoff - режим (paddingBackoff)
+	Backoff string `json:"backoff"`
+
+	// Level - доля полного padding сейчас (1 - полный, 0 - только
+	// заголовок)
+	Level float64 `json:"level"`
+
+	// ReducedPackets - пакетов с уменьшенным padding
+	// SavedBytes - оценка неотправленных байт padding
+	ReducedPackets uint64 `json:"reducedPackets"`
+	SavedBytes     uint64 `json:"savedBytes"`
+}
+
+// newPaddingGovernor создаёт уровень padding по оценщику bandwidth
+func newPaddingGovernor(bandwidth *BandwidthEstimator, side *sideCounters) *paddingGovernor {
+	return &paddingGovernor{bandwidth: bandwidth, side: side}
+}
+
+// paddingStep - ступень для загрузки usage в режиме backoff
+func paddingStep(backoff PaddingBackoff, usage float64) int {
+	switch {
+	case backoff == PaddingBackoff_NONE || usage < paddingBackoffStart:
+		return 0
+	case usage >= paddingBackoffFull:
+		return paddingSteps
+	case backoff == PaddingBackoff_STEP:
+		return 0
+	}
+	step := int(math.Ceil((usage - paddingBackoffStart) / (paddingBackoffFull - paddingBackoffStart) * paddingSteps))
+	return min(step, paddingSteps-1)
+}
+
+// usage - загрузка канала отправителя по последнему замеру
+func (g *paddingGovernor) usage(config *Config) float64 {
+	capacity := float64(config.PaddingBandwidth)
+	if capacity == 0 {
+		capacity = max(g.bandwidth.GetMax(), paddingMinCapacity)
+	}
+	return g.bandwidth.GetLast() / capacity
+}
+
+// currentStep - ступень по загрузке, пересчёт не чаще paddingRecheck
+func (g *paddingGovernor) currentStep(config *Config, now time.Time) int {
+	checked := atomic.LoadInt64(&g.checkedAt)
+	if now.UnixNano()-checked < int64(paddingRecheck) {
+		return int(atomic.LoadInt32(&g.step))
+	}
+	step := paddingStep(config.PaddingBackoff, g.usage(config))
+	if atomic.CompareAndSwapInt64(&g.checkedAt, checked, now.UnixNano()) {
+		atomic.StoreInt32(&g.step, int32(step))
+	}
+	return step
+}
+
+// config возвращает конфиг для DATA-пакета с padding по загрузке
+// slot - кэш конфигов ступеней сессии (пересобирается при смене
+// ступени или base), trend - метки времени сессии (nil - нет)
+// Без paddingBackoff и padding (и nil) возвращает base
+func (g *paddingGovernor) config(slot *atomic.Pointer[paddingVariant], base *Config, trend *delayTrend) *Config {
+	if g == nil || base.PaddingBackoff == PaddingBackoff_NONE || !base.EnablePadding {
+		return base
+	}
+	step := g.currentStep(base, time.Now())
+	if trend.sendBloated() {
+		step = paddingSteps
+	}
+	if step == 0 {
+		return base
+	}
+
+	// Средний padding: полный и на этой ступени
+	full := (base.PaddingMinSize + base.PaddingMaxSize) / 2
+	scaled := full * uint32(paddingSteps-step) / paddingSteps
+	atomic.AddUint64(&g.reduced, 1)
+	atomic.AddUint64(&g.saved, uint64(full-scaled))
+	atomic.AddUint64(&g.side.paddingReduced, 1)
+	atomic.AddUint64(&g.side.paddingSaved, uint64(full-scaled))
+
+	if v := slot.Load(); v != nil && v.base == base && v.step == step {
+		return v.config
+	}
+	config := *base
+	config.PaddingMinSize = base.PaddingMinSize * uint32(paddingSteps-step) / paddingSteps
+	config.PaddingMaxSize = base.PaddingMaxSize * uint32(paddingSteps-step) / paddingSteps
+	slot.Store(&paddingVariant{base: base, step: step, config: &config})
+	return &config
+}
+
+// stats - снимок для HubStats и ClientStats
+func (g *paddingGovernor) stats(config *Config) PaddingStats {
+	level := 1.0
+	if config.PaddingBackoff != PaddingBackoff_NONE && config.EnablePadding {
+		level = float64(paddingSteps-paddingStep(config.PaddingBackoff, g.usage(config))) / paddingSteps
+	}
+	return PaddingStats{
+		Backoff:        config.PaddingBackoff.String(),
+		Level:          level,
+		ReducedPackets: atomic.LoadUint64(&g.reduced),
+		SavedBytes:     atomic.LoadUint64(&g.saved),
+	}
+}
@@ -0,0 +1,142 @@
+package gametunnel
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+)
+
+// withSamples добавляет замеры скорости в оценщик
+func withSamples(be *BandwidthEstimator, samples ...float64) {
+	be.mu.Lock()
+	be.samples = append(be.samples, samples...)
+	be.mu.Unlock()
+}
+
+func TestPaddingStep(t *testing.T) {
+	for _, c := range []struct {
+		backoff PaddingBackoff
+		usage   float64
+		want    int
+	}{
+		{PaddingBackoff_NONE, 1, 0},
+		{PaddingBackoff_LINEAR, 0.3, 0},
+		{PaddingBackoff_LINEAR, 0.55, 1},
+		{PaddingBackoff_LINEAR, 0.7, 2},
+		{PaddingBackoff_LINEAR, 0.85, 3},
+		{PaddingBackoff_LINEAR, 0.9, paddingSteps},
+		{PaddingBackoff_STEP, 0.85, 0},
+		{PaddingBackoff_STEP, 1.5, paddingSteps},
+	} {
+		if got := paddingStep(c.backoff, c.usage); got != c.want {
+			t.Errorf("paddingStep(%s, %g) = %d, want %d", c.backoff, c.usage, got, c.want)
+		}
+	}
+
+	for s, want := range map[string]PaddingBackoff{
+		"linear": PaddingBackoff_LINEAR,
+		"STEP":   PaddingBackoff_STEP,
+		"":       PaddingBackoff_NONE,
+		"bogus":  PaddingBackoff_NONE,
+	} {
+		if got := PaddingBackoffFromString(s); got != want {
+			t.Errorf("PaddingBackoffFromString(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestPaddingGovernor(t *testing.T) {
+	config := DefaultConfig()
+	config.PaddingMinSize = 100
+	config.PaddingMaxSize = 200
+	config.PaddingBandwidth = 1000
+
+	be := NewBandwidthEstimator()
+	var side sideCounters
+	g := newPaddingGovernor(be, &side)
+	var slot atomic.Pointer[paddingVariant]
+
+	// Без режима и без замеров - исходный конфиг
+	if got := g.config(&slot, config, nil); got != config {
+		t.Error("padding changed without paddingBackoff")
+	}
+	config.PaddingBackoff = PaddingBackoff_LINEAR
+	if got := g.config(&slot, config, nil); got != config {
+		t.Error("padding changed without load")
+	}
+
+	// 70% загрузки - половина padding
+	withSamples(be, 700)
+	atomic.StoreInt64(&g.checkedAt, 0)
+	got := g.config(&slot, config, nil)
+	if got.PaddingMinSize != 50 || got.PaddingMaxSize != 100 || !got.EnablePadding {
+		t.Errorf("70%% load: padding %v %d-%d, want 50-100", got.EnablePadding, got.PaddingMinSize, got.PaddingMaxSize)
+	}
+	if again := g.config(&slot, config, nil); again != got {
+		t.Error("variant of the same step rebuilt")
+	}
+
+	// Растущая очередь - только заголовок
+	got = g.config(&slot, config, &delayTrend{bloated: 1})
+	if got.PaddingMinSize != 0 || got.PaddingMaxSize != 0 || !got.EnablePadding {
+		t.Errorf("bloat: padding %d-%d, want header only", got.PaddingMinSize, got.PaddingMaxSize)
+	}
+
+	// Saved: 150-75 дважды и 150 один раз
+	stats := g.stats(config)
+	if stats.Backoff != "linear" || stats.Level != 0.5 || stats.ReducedPackets != 3 || stats.SavedBytes != 300 {
+		t.Errorf("stats %+v", stats)
+	}
+	if side.paddingReduced != 3 || side.paddingSaved != 300 {
+		t.Errorf("side counters %d %d", side.paddingReduced, side.paddingSaved)
+	}
+
+	// Ёмкость по оценщику не ниже paddingMinCapacity
+	config.PaddingBandwidth = 0
+	if usage := g.usage(config); usage != 700.0/paddingMinCapacity {
+		t.Errorf("usage %g with automatic capacity", usage)
+	}
+
+	var nilGovernor *paddingGovernor
+	if nilGovernor.config(&slot, config, nil) != config {
+		t.Error("nil governor changed padding")
+	}
+}
+
+func TestPaddingBackoffLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "padding-backoff"
+	config.PaddingBackoff = PaddingBackoff_STEP
+	config.PaddingBandwidth = 1
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Канал сервера насыщен: DATA уходят с одним заголовком
+	withSamples(l.hub.bandwidth, 1_000_000)
+	atomic.StoreInt64(&l.hub.padding.checkedAt, 0)
+
+	response := bytes.Repeat([]byte("world_state "), 50)
+	if _, err := server.Write(response); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, client, 2048); !bytes.Equal(got, response) {
+		t.Errorf("client got %d bytes, want %d", len(got), len(response))
+	}
+	stats := l.hub.GetStats().Padding
+	if stats.Backoff != "step" || stats.Level != 0 || stats.ReducedPackets == 0 {
+		t.Errorf("hub padding stats %+v", stats)
+	}
+
+	request := []byte("player_move: x=1 y=2")
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+		t.Errorf("server got %q, want %q", got, request)
+	}
+	if padding := client.GetStats().Padding; padding == nil || padding.Backoff != "step" {
+		t.Errorf("client padding stats %+v", padding)
+	}
+}
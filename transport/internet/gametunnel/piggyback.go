@@ -0,0 +1,131 @@
+package gametunnel
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Keep-alive поверх пакетов данных
+// ====================================================================
+//
+// Если клиент отправляет данные, а сервер молчит, keep-alive нужен
+// ради ответа сервера (keepalive.go). С padding отдельный keep-alive
+// не уходит: тик таймера помечает запросом keep-alive следующий
+// DATA-пакет - битом FlagKeepAliveBit в flags. Флаги входят в AD
+// пакета, так что пометку нельзя ни подделать, ни снять. Сервер,
+// расшифровав помеченный пакет, отвечает обычным keep-alive; для
+// клиента этот ответ ничем не отличается от ответа на отдельный
+// keep-alive: по нему так же меряется RTT и считаются пропуски
+// (health.go). На активной игровой сессии это минус пакет клиента
+// за интервал.
+//
+// Старый сервер бит не знает и не отвечает. Пока сервер не ответил
+// ни на один запрос, пропущенный ответ не засчитывается в поломку
+// пути: клиент решает, что запросы не поддерживаются, и до
+// переподключения шлёт отдельные keep-alive. Если за интервал не ушло
+// ни одного DATA-пакета, следующий тик отправляет отдельный
+// keep-alive. Сжатый заголовок (compact.go) бит не несёт - помеченный
+// пакет уходит с полным.
+//
+// ====================================================================
+
+// Поддержка запросов keep-alive сервером
+const (
+	piggybackUnknown int32 = iota
+	piggybackConfirmed
+	piggybackUnsupported
+)
+
+// keepAlivePiggyback - запросы keep-alive в DATA-пакетах клиента
+type keepAlivePiggyback struct {
+	// armed - следующий DATA-пакет несёт запрос (atomic, 0/1)
+	// pending - без ответа остался запрос в DATA-пакете, а не
+	// отдельный keep-alive (atomic, 0/1)
+	// state - поддержка сервером: piggybackUnknown/Confirmed/Unsupported
+	armed   int32
+	pending int32
+	state   int32
+
+	// sent - запросов отправлено в DATA-пакетах (atomic)
+	sent uint64
+}
+
+// reset - сервер новой сессии (reconnect.go) может быть другим
+func (p *keepAlivePiggyback) reset() {
+	atomic.StoreInt32(&p.armed, 0)
+	atomic.StoreInt32(&p.pending, 0)
+	atomic.StoreInt32(&p.state, piggybackUnknown)
+}
+
+// answered засчитывает ответный keep-alive сервера
+func (p *keepAlivePiggyback) answered() {
+	if atomic.SwapInt32(&p.pending, 0) == 1 {
+		atomic.CompareAndSwapInt32(&p.state, piggybackUnknown, piggybackConfirmed)
+	}
+}
+
+// piggybackKeepAlive - тик keep-alive (keepalive.go): пометить запросом
+// следующий DATA-пакет вместо отдельного keep-alive
+// false - нужен отдельный keep-alive
+func (c *GameTunnelClientConn) piggybackKeepAlive(now time.Time, interval time.Duration) bool {
+	p := &c.piggyback
+	if !c.config.EnablePadding || atomic.LoadInt32(&p.state) == piggybackUnsupported {
+		return false
+	}
+	// За интервал не ушло ни одного DATA-пакета
+	if atomic.SwapInt32(&p.armed, 0) == 1 {
+		return false
+	}
+	// Запрос без ответа до первого подтверждения - сервер бит не знает
+	if atomic.LoadInt32(&p.state) == piggybackUnknown &&
+		atomic.LoadInt32(&p.pending) == 1 && atomic.LoadInt64(&c.keepAliveSentAt) != 0 {
+		atomic.StoreInt32(&p.state, piggybackUnsupported)
+		atomic.StoreInt32(&p.pending, 0)
+		atomic.StoreInt64(&c.keepAliveSentAt, 0)
+		logf(log.Severity_Debug, "%s server ignores keep-alive requests in data packets", sessionTag(c.session().ConnectionID))
+		return false
+	}
+	// Данных нет - помечать нечего
+	if atomic.LoadInt64(&c.lastSendAt) <= now.Add(-interval).UnixNano() {
+		return false
+	}
+	atomic.StoreInt32(&p.armed, 1)
+	return true
+}
+
+// takeKeepAlive забирает пометку для отправляемого DATA-пакета
+// Возвращает AD пакета: с FlagKeepAliveBit, если пакет несёт запрос
+func (c *GameTunnelClientConn) takeKeepAlive(ad []byte) ([]byte, bool) {
+	p := &c.piggyback
+	if atomic.LoadInt32(&p.armed) == 0 || !atomic.CompareAndSwapInt32(&p.armed, 1, 0) {
+		return ad, false
+	}
+	c.keepAliveOut()
+	atomic.StoreInt32(&p.pending, 1)
+	atomic.AddUint64(&p.sent, 1)
+
+	marked := bytes.Clone(ad)
+	marked[0] |= FlagKeepAliveBit
+	return marked, true
+}
+
+// GetKeepAlivesPiggybacked возвращает число запросов keep-alive,
+// отправленных в DATA-пакетах
+func (c *GameTunnelClientConn) GetKeepAlivesPiggybacked() uint64 {
+	return atomic.LoadUint64(&c.piggyback.sent)
+}
+
+// answerKeepAliveRequest отвечает keep-alive на расшифрованный
+// DATA-пакет с FlagKeepAliveBit
+func (h *Hub) answerKeepAliveRequest(session *Session, data []byte) {
+	if data[0]&FlagKeepAliveBit == 0 {
+		return
+	}
+	if _, _, err := h.handleKeepAlive(session, nil); err != nil {
+		logf(log.Severity_Debug, "%s keep-alive request not answered: %v", sessionTag(session.ID), err)
+	}
+}
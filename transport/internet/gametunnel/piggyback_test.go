@@ -0,0 +1,115 @@
+package gametunnel
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepAlivePiggyback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "piggyback"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	// Тики - вручную, таймер (15 с) до них не дойдёт. Клиент шлёт,
+	// сервер молчит дольше интервала
+	time.Sleep(1100 * time.Millisecond)
+	client.Write([]byte("up"))
+	readWithTimeout(t, server, 2)
+
+	// Тик помечает следующий DATA-пакет вместо отдельного keep-alive
+	client.keepAliveTick(time.Now(), time.Second)
+	client.Write([]byte("up"))
+	readWithTimeout(t, server, 2)
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&client.piggyback.state) != piggybackConfirmed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := atomic.LoadInt32(&client.piggyback.state); state != piggybackConfirmed {
+		t.Fatalf("piggyback state %d, want confirmed", state)
+	}
+	if sent := client.GetKeepAlivesSent(); sent != 0 {
+		t.Errorf("%d standalone keep-alives sent with data going out", sent)
+	}
+	if n := client.GetKeepAlivesPiggybacked(); n != 1 {
+		t.Errorf("%d keep-alive requests piggybacked, want 1", n)
+	}
+	if atomic.LoadInt64(&client.keepAliveSentAt) != 0 || atomic.LoadUint32(&client.keepAlivesMissed) != 0 {
+		t.Error("answered request left pending or missed")
+	}
+
+	// Простой: данных нет - отдельный keep-alive
+	atomic.StoreInt64(&client.lastSendAt, time.Now().Add(-2*time.Second).UnixNano())
+	atomic.StoreInt64(&client.lastRecvAt, time.Now().Add(-2*time.Second).UnixNano())
+	client.keepAliveTick(time.Now(), time.Second)
+	if sent := client.GetKeepAlivesSent(); sent != 1 {
+		t.Errorf("%d standalone keep-alives while idle, want 1", sent)
+	}
+}
+
+func TestKeepAlivePiggybackFallback(t *testing.T) {
+	c := &GameTunnelClientConn{config: DefaultConfig()}
+	c.current.Store(&ClientSession{ConnectionID: []byte{1, 2, 3, 4}})
+	now := time.Now()
+	atomic.StoreInt64(&c.lastSendAt, now.UnixNano())
+
+	// Без padding запросы не помечаются
+	c.config.EnablePadding = false
+	if c.piggybackKeepAlive(now, time.Second) {
+		t.Fatal("piggyback without padding")
+	}
+	c.config.EnablePadding = true
+
+	if !c.piggybackKeepAlive(now, time.Second) {
+		t.Fatal("piggyback not armed while sending")
+	}
+	ad := []byte{0xC8, 0, 0, 0, 1}
+	marked, ok := c.takeKeepAlive(ad)
+	if !ok || marked[0]&FlagKeepAliveBit == 0 || ad[0]&FlagKeepAliveBit != 0 {
+		t.Fatalf("request not marked on a copy: %x, %v", marked, ok)
+	}
+	if _, ok := c.takeKeepAlive(ad); ok {
+		t.Error("one request marked twice")
+	}
+
+	// Старый сервер не ответил до первого подтверждения: без пропуска
+	// keep-alive и дальше - только отдельные
+	if c.piggybackKeepAlive(now, time.Second) {
+		t.Fatal("piggyback after unanswered first request")
+	}
+	if state := atomic.LoadInt32(&c.piggyback.state); state != piggybackUnsupported {
+		t.Errorf("state %d, want unsupported", state)
+	}
+	if atomic.LoadInt64(&c.keepAliveSentAt) != 0 || atomic.LoadUint32(&c.keepAlivesMissed) != 0 {
+		t.Error("unanswered request to old server counted as missed")
+	}
+	if c.piggybackKeepAlive(now, time.Second) {
+		t.Error("piggyback with unsupported server")
+	}
+
+	// Новая сессия - поддержка неизвестна; ответ её подтверждает
+	c.piggyback.reset()
+	c.piggybackKeepAlive(now, time.Second)
+	c.takeKeepAlive(ad)
+	c.piggyback.answered()
+	if state := atomic.LoadInt32(&c.piggyback.state); state != piggybackConfirmed {
+		t.Errorf("state %d after answer, want confirmed", state)
+	}
+
+	// Помеченный пакет так и не ушёл - нужен отдельный keep-alive
+	c.piggybackKeepAlive(now, time.Second)
+	if c.piggybackKeepAlive(now, time.Second) {
+		t.Error("piggyback re-armed without a data packet")
+	}
+
+	// Данных за интервал не было
+	atomic.StoreInt64(&c.lastSendAt, now.Add(-2*time.Second).UnixNano())
+	if c.piggybackKeepAlive(now, time.Second) {
+		t.Error("piggyback without data")
+	}
+}
@@ -0,0 +1,407 @@
+package gametunnel
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ====================================================================
+// Политики inbound-ов: общий хаб с разными правилами на портах
+// ====================================================================
+//
+// Один сервер может держать несколько портов с разным поведением:
+// "stealth" - с большим padding и только для своих пользователей,
+// "fast" - без padding и с высоким лимитом скорости. Порты - это
+// inbound-ы xray со своими тегами; с одинаковым hubGroup они делят
+// один Hub (сессии, ключ, пулы, квоты), а различия задаёт политика:
+//
+//	InboundPolicy:
+//	  Padding - padding DATA сервера (nil - из конфига хаба)
+//	  Rate    - лимит скорости сессии (nil - sessionRateLimit хаба)
+//	  Users   - пользователи, допустимые для сессий: из users
+//	            при хэндшейке и SetSessionUser (пусто - любые)
+//
+// Политику выбирает PolicyResolver при Client Hello - после фильтра
+// IP хаба и до задачи хэндшейка (puzzle.go): отказ ничего не стоит.
+// HandshakeInfo несёт тег inbound-а, на сокет которого пришёл
+// пакет, и адреса. Политика закрепляется за сессией на всё время
+// жизни: переход клиента на другой порт (port hopping) и
+// перезагрузка её не меняют.
+//
+// Без hubGroup у каждого inbound-а свой хаб; SetPolicyResolver
+// всё равно доступен - например, для ACL по тегу и адресу.
+//
+// В группе политика по умолчанию строится из конфига каждого
+// inbound-а:
+//   - enablePadding, paddingMinSize, paddingMaxSize - Padding;
+//   - sessionRateLimit, sessionRateBurst            - Rate;
+//   - allowIps, denyIps - фильтр источников только этого inbound-а.
+// Первый inbound группы создаёт хаб; ключ, MTU, длина Connection ID
+// и обфускация у остальных должны совпадать. Прочие настройки хаба
+// (таймауты, квоты, снапшоты, API) - первого inbound-а или
+// перезагруженного последним (Listener.Reload). Новые сессии
+// передаются в xray через listener своего inbound-а; закрытие
+// listener закрывает его сессии, хаб останавливается с последним.
+// Счётчики трафика xray (xraystats.go) идут под тегом первого
+// inbound-а.
+//
+// ====================================================================
+
+// PolicyResolver выбирает политику новой сессии при Client Hello
+// nil-политика - настройки хаба, ошибка - хэндшейк отклоняется
+// Вызывается из горутин приёма и должен возвращаться быстро
+type PolicyResolver interface {
+	ResolvePolicy(info HandshakeInfo) (*InboundPolicy, error)
+}
+
+// HandshakeInfo - описание Client Hello для PolicyResolver
+type HandshakeInfo struct {
+	// InboundTag - тег inbound-а xray, на сокет которого пришёл
+	// Client Hello ("" - inbound без тега)
+	InboundTag string
+
+	// RemoteAddr - адрес клиента
+	RemoteAddr *net.UDPAddr
+
+	// LocalAddr - адрес сокета сервера
+	LocalAddr net.Addr
+}
+
+// InboundPolicy - правила сессий inbound-а
+// Политика после возврата из PolicyResolver не должна меняться
+type InboundPolicy struct {
+	// Padding - padding DATA сервера (nil - из конфига хаба)
+	Padding *PaddingPolicy
+
+	// Rate - лимит скорости сессии (nil - из конфига хаба)
+	Rate *SessionRate
+
+	// Users - пользователи, которых можно назначить сессии (users
+	// при хэндшейке, SetSessionUser); пусто - любые
+	Users []string
+}
+
+// PaddingPolicy - padding DATA, как enablePadding и paddingMin/MaxSize
+type PaddingPolicy struct {
+	Enabled bool
+	MinSize uint32
+	MaxSize uint32
+}
+
+// SessionRate - лимит скорости сессии, как sessionRateLimit/Burst
+// (Limit 0 - без ограничения)
+type SessionRate struct {
+	Limit uint64
+	Burst uint64
+}
+
+// policyHolder - обёртка PolicyResolver для atomic.Value
+type policyHolder struct {
+	PolicyResolver
+}
+
+// policyConfig - конфиг хаба с padding политики сессии
+// base - снимок хаба, из которого собран config
+type policyConfig struct {
+	base   *Config
+	config *Config
+}
+
+// SetPolicyResolver задаёт выбор политик новых сессий хаба
+// (nil - без политик). В группе inbound-ов заменяет политики из их
+// конфигов
+func (h *Hub) SetPolicyResolver(r PolicyResolver) {
+	h.policy.Store(policyHolder{r})
+}
+
+// SetPolicyResolver задаёт выбор политик новых сессий listener
+func (l *Listener) SetPolicyResolver(r PolicyResolver) {
+	l.hub.SetPolicyResolver(r)
+}
+
+// resolvePolicy выбирает политику Client Hello, пришедшего на sock
+func (h *Hub) resolvePolicy(sock *dscpMarker, remoteAddr *net.UDPAddr) (*InboundPolicy, error) {
+	holder, _ := h.policy.Load().(policyHolder)
+	if holder.PolicyResolver == nil {
+		return nil, nil
+	}
+	info := HandshakeInfo{InboundTag: sock.inbound, RemoteAddr: remoteAddr}
+	if sock.conn != nil {
+		info.LocalAddr = sock.conn.LocalAddr()
+	}
+	policy, err := holder.ResolvePolicy(info)
+	if err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("inbound %q policy: %w", sock.inbound, err)
+	}
+	return policy, nil
+}
+
+// validate проверяет политику (nil допустим)
+func (p *InboundPolicy) validate() error {
+	if p == nil || p.Padding == nil || !p.Padding.Enabled {
+		return nil
+	}
+	if p.Padding.MinSize > p.Padding.MaxSize {
+		return fmt.Errorf("padding min size %d above max size %d", p.Padding.MinSize, p.Padding.MaxSize)
+	}
+	return nil
+}
+
+// limiter - лимит скорости сессии с политикой p по конфигу хаба
+func (p *InboundPolicy) limiter(config *Config) *tokenBucket {
+	if p == nil || p.Rate == nil {
+		return newTokenBucket(config.SessionRateLimit, config.SessionRateBurst)
+	}
+	return newTokenBucket(p.Rate.Limit, p.Rate.Burst)
+}
+
+// allowsUser - пользователя можно назначить сессии с политикой p
+func (p *InboundPolicy) allowsUser(user string) bool {
+	if p == nil || len(p.Users) == 0 {
+		return true
+	}
+	for _, u := range p.Users {
+		if u == user {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPolicy закрепляет за новой сессией inbound sock и политику
+// Вызывается до регистрации сессии
+func (h *Hub) applyPolicy(session *Session, sock *dscpMarker, policy *InboundPolicy) {
+	session.InboundTag = sock.inbound
+	session.policy = policy
+	if policy != nil && policy.Rate != nil {
+		session.limiter = policy.limiter(h.getConfig())
+	}
+}
+
+// sessionConfig - конфиг хаба для отправки DATA сессии: с padding
+// её политики. Копия собирается один раз на снимок конфига
+func (h *Hub) sessionConfig(session *Session) *Config {
+	config := h.getConfig()
+	if session.policy == nil || session.policy.Padding == nil {
+		return config
+	}
+	if c := session.policyConfig.Load(); c != nil && c.base == config {
+		return c.config
+	}
+	merged := *config
+	merged.EnablePadding = session.policy.Padding.Enabled
+	merged.PaddingMinSize = session.policy.Padding.MinSize
+	merged.PaddingMaxSize = session.policy.Padding.MaxSize
+	session.policyConfig.Store(&policyConfig{base: config, config: &merged})
+	return &merged
+}
+
+// ====================================================================
+// hubGroup - inbound-ы с общим хабом
+// ====================================================================
+
+// hubGroups - группы inbound-ов процесса по hubGroup
+var hubGroups = struct {
+	sync.Mutex
+	groups map[string]*hubGroup
+}{groups: make(map[string]*hubGroup)}
+
+// hubGroup - хаб группы и её inbound-ы по тегам
+// Сама группа - PolicyResolver хаба по умолчанию
+type hubGroup struct {
+	name string
+	hub  *Hub
+
+	mu      sync.RWMutex
+	members map[string]*groupMember
+}
+
+// groupMember - inbound группы
+// listener - nil, пока ListenGameTunnel не закончил запуск
+type groupMember struct {
+	listener *Listener
+	policy   *InboundPolicy
+	ipFilter *ipFilter
+}
+
+// newGroupMember строит политику inbound-а по его конфигу
+func newGroupMember(config *Config) (*groupMember, error) {
+	filter, err := newIPFilter(config)
+	if err != nil {
+		return nil, fmt.Errorf("build ip filter: %w", err)
+	}
+	return &groupMember{
+		policy: &InboundPolicy{
+			Padding: &PaddingPolicy{
+				Enabled: config.EnablePadding,
+				MinSize: config.PaddingMinSize,
+				MaxSize: config.PaddingMaxSize,
+			},
+			Rate: &SessionRate{Limit: config.SessionRateLimit, Burst: config.SessionRateBurst},
+		},
+		ipFilter: filter,
+	}, nil
+}
+
+// groupHubConfig - конфиг хаба группы: фильтр источников у каждого
+// inbound-а свой (groupMember.ipFilter)
+func groupHubConfig(config *Config) *Config {
+	hubConfig := *config
+	hubConfig.AllowIps = nil
+	hubConfig.DenyIps = nil
+	return &hubConfig
+}
+
+// joinHubGroup добавляет inbound tag в группу config.HubGroup
+// Первый inbound создаёт хаб на conn (owner = true): запускать и
+// восстанавливать его сессии - дело вызывающего
+func joinHubGroup(config *Config, tag string, conn PacketConn) (group *hubGroup, owner bool, err error) {
+	member, err := newGroupMember(config)
+	if err != nil {
+		return nil, false, err
+	}
+
+	hubGroups.Lock()
+	defer hubGroups.Unlock()
+
+	group = hubGroups.groups[config.HubGroup]
+	if group == nil {
+		group = &hubGroup{
+			name:    config.HubGroup,
+			hub:     NewHub(groupHubConfig(config), conn),
+			members: make(map[string]*groupMember),
+		}
+		group.hub.SetPolicyResolver(group)
+		hubGroups.groups[group.name] = group
+		owner = true
+	} else if err := group.compatible(config); err != nil {
+		return nil, false, err
+	}
+
+	group.mu.Lock()
+	defer group.mu.Unlock()
+	if _, ok := group.members[tag]; ok {
+		return nil, false, fmt.Errorf("hubGroup %q: inbound tag %q already in the group", group.name, tag)
+	}
+	group.members[tag] = member
+	return group, owner, nil
+}
+
+// compatible проверяет, что формат пакетов inbound-а совпадает с
+// форматом хаба группы
+func (g *hubGroup) compatible(config *Config) error {
+	hub := g.hub.getConfig()
+	switch {
+	case config.Key != hub.Key:
+		return fmt.Errorf("hubGroup %q: key differs from the first inbound", g.name)
+	case config.MTU != hub.MTU:
+		return fmt.Errorf("hubGroup %q: mtu %d differs from the first inbound (%d)", g.name, config.MTU, hub.MTU)
+	case config.ConnectionIdLength != hub.ConnectionIdLength:
+		return fmt.Errorf("hubGroup %q: connectionIdLength %d differs from the first inbound (%d)", g.name, config.ConnectionIdLength, hub.ConnectionIdLength)
+	case config.Obfuscation != hub.Obfuscation:
+		return fmt.Errorf("hubGroup %q: obfuscation differs from the first inbound", g.name)
+	}
+	return nil
+}
+
+// attach связывает inbound tag с его listener
+func (g *hubGroup) attach(tag string, l *Listener) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if member := g.members[tag]; member != nil {
+		member.listener = l
+	}
+}
+
+// leave убирает inbound tag из группы и закрывает его сессии
+// Возвращает true для последнего inbound-а: хаб пора остановить
+func (g *hubGroup) leave(tag string) bool {
+	hubGroups.Lock()
+	g.mu.Lock()
+	delete(g.members, tag)
+	last := len(g.members) == 0
+	g.mu.Unlock()
+	if last {
+		delete(hubGroups.groups, g.name)
+	}
+	hubGroups.Unlock()
+
+	if !last {
+		for _, session := range g.hub.sessions.snapshot() {
+			if session.InboundTag == tag {
+				g.hub.RemoveSession(session.ID)
+			}
+		}
+	}
+	return last
+}
+
+// reload применяет конфиг inbound-а tag: его политику и
+// перезагружаемые настройки хаба (reload.go)
+func (g *hubGroup) reload(tag string, config *Config) error {
+	member, err := newGroupMember(config)
+	if err != nil {
+		return fmt.Errorf("reload: %w", err)
+	}
+	if err := g.hub.Reload(groupHubConfig(config)); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if prev := g.members[tag]; prev != nil {
+		member.listener = prev.listener
+		g.members[tag] = member
+	}
+	return nil
+}
+
+// size - inbound-ов в группе
+func (g *hubGroup) size() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return len(g.members)
+}
+
+// member - inbound группы по тегу (nil - не в группе)
+func (g *hubGroup) member(tag string) *groupMember {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.members[tag]
+}
+
+// ResolvePolicy - политика из конфига inbound-а, на который пришёл
+// Client Hello, после его фильтра источников
+func (g *hubGroup) ResolvePolicy(info HandshakeInfo) (*InboundPolicy, error) {
+	member := g.member(info.InboundTag)
+	if member == nil {
+		return nil, fmt.Errorf("inbound %q is not in hub group %q", info.InboundTag, g.name)
+	}
+	if err := member.ipFilter.check(info.RemoteAddr.IP); err != nil {
+		return nil, err
+	}
+	return member.policy, nil
+}
+
+// acceptSession передаёт новую сессию listener её inbound-а
+func (g *hubGroup) acceptSession(session *Session) {
+	member := g.member(session.InboundTag)
+	if member == nil || member.listener == nil {
+		g.hub.RemoveSession(session.ID)
+		return
+	}
+	member.listener.acceptSession(session)
+}
+
+// acceptStream передаёт поток общей сессии listener её inbound-а
+func (g *hubGroup) acceptStream(session *Session, stream *muxStream) bool {
+	member := g.member(session.InboundTag)
+	if member == nil || member.listener == nil {
+		return false
+	}
+	return member.listener.acceptStream(stream)
+}
@@ -0,0 +1,270 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// resolverFunc - PolicyResolver из функции
+type resolverFunc func(info HandshakeInfo) (*InboundPolicy, error)
+
+func (f resolverFunc) ResolvePolicy(info HandshakeInfo) (*InboundPolicy, error) {
+	return f(info)
+}
+
+// startTaggedListener запускает Listener inbound-а xray с тегом tag
+func startTaggedListener(t *testing.T, config *Config, tag string) (*Listener, <-chan stat.Connection) {
+	t.Helper()
+
+	l, accepted, err := listenTagged(config, tag)
+	if err != nil {
+		t.Fatalf("ListenGameTunnel: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l, accepted
+}
+
+// listenTagged - ListenGameTunnel inbound-а с тегом tag
+func listenTagged(config *Config, tag string) (*Listener, <-chan stat.Connection, error) {
+	accepted := make(chan stat.Connection, 16)
+	ctx := session.ContextWithInbound(context.Background(), &session.Inbound{Tag: tag})
+	streamSettings := &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: config,
+	}
+	l, err := ListenGameTunnel(ctx, xnet.LocalHostIP, 0, streamSettings, func(conn stat.Connection) {
+		accepted <- conn
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return l.(*Listener), accepted, nil
+}
+
+// dialRejected проверяет, что сервер не отвечает на хэндшейк
+func dialRejected(t *testing.T, l *Listener, config *Config) {
+	t.Helper()
+	clientConfig := *config
+	clientConfig.HandshakeTimeout = 1
+	addr := l.Addr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	conn, err := Dial(context.Background(), dest, &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: &clientConfig,
+	})
+	if err == nil {
+		conn.Close()
+		t.Fatal("handshake accepted")
+	}
+}
+
+func TestInboundPolicy(t *testing.T) {
+	var nilPolicy *InboundPolicy
+	if !nilPolicy.allowsUser("alice") || nilPolicy.validate() != nil {
+		t.Error("nil policy restricts sessions")
+	}
+	policy := &InboundPolicy{Users: []string{"alice"}}
+	if !policy.allowsUser("alice") || policy.allowsUser("bob") {
+		t.Error("user list not applied")
+	}
+	bad := &InboundPolicy{Padding: &PaddingPolicy{Enabled: true, MinSize: 100, MaxSize: 50}}
+	if bad.validate() == nil {
+		t.Error("padding with min above max accepted")
+	}
+
+	config := DefaultConfig()
+	config.SessionRateLimit = 1000
+	if b := nilPolicy.limiter(config); b == nil || b.rate != 1000 {
+		t.Errorf("nil policy limiter %+v, want the hub limit", b)
+	}
+	if b := (&InboundPolicy{Rate: &SessionRate{}}).limiter(config); b != nil {
+		t.Errorf("policy without a limit got %+v", b)
+	}
+}
+
+func TestPolicyResolver(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "policy"
+
+	l, accepted := startTaggedListener(t, config, "stealth")
+	reject := errors.New("closed for maintenance")
+	l.SetPolicyResolver(resolverFunc(func(info HandshakeInfo) (*InboundPolicy, error) {
+		if info.InboundTag != "stealth" || info.LocalAddr.String() != l.Addr().String() || info.RemoteAddr == nil {
+			t.Errorf("handshake info %+v", info)
+		}
+		return nil, reject
+	}))
+	dialRejected(t, l, config)
+	waitDrops(t, func() map[string]uint64 { return l.hub.GetStats().Drops }, dropRejected, 1)
+
+	l.SetPolicyResolver(resolverFunc(func(HandshakeInfo) (*InboundPolicy, error) {
+		return &InboundPolicy{
+			Padding: &PaddingPolicy{Enabled: false},
+			Rate:    &SessionRate{Limit: 1 << 20},
+			Users:   []string{"alice"},
+		}, nil
+	}))
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	s := l.hub.sessions.get(client.session().ConnectionID)
+	if s == nil || s.InboundTag != "stealth" || s.GetStats().InboundTag != "stealth" {
+		t.Fatal("session not tagged with its inbound")
+	}
+	if s.limiter == nil || s.limiter.rate != 1<<20 {
+		t.Errorf("session limiter %+v, want the policy rate", s.limiter)
+	}
+	if l.hub.sessionConfig(s).EnablePadding || !l.hub.getConfig().EnablePadding {
+		t.Error("policy padding not applied to the session only")
+	}
+	if err := server.(*GameTunnelConn).SetUser("bob"); err == nil {
+		t.Error("user outside the policy list accepted")
+	}
+	if err := server.(*GameTunnelConn).SetUser("alice"); err != nil {
+		t.Errorf("SetUser(alice): %v", err)
+	}
+
+	// Перезагрузка не сбрасывает лимит политики
+	reloaded := *config
+	reloaded.SessionRateLimit = 1000
+	if err := l.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if s.limiter == nil || s.limiter.rate != 1<<20 {
+		t.Errorf("reload replaced the policy limit: %+v", s.limiter)
+	}
+
+	response := bytes.Repeat([]byte("world_state "), 100)
+	if _, err := server.Write(response); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, client, 2048); !bytes.Equal(got, response) {
+		t.Errorf("client got %d bytes, want %d", len(got), len(response))
+	}
+}
+
+func TestHubGroupLoopback(t *testing.T) {
+	base := DefaultConfig()
+	base.Key = "hub-group"
+	base.HubGroup = t.Name()
+
+	stealthConfig := *base
+	stealthConfig.PaddingMinSize = 300
+	stealthConfig.PaddingMaxSize = 400
+	stealth, stealthAccepted := startTaggedListener(t, &stealthConfig, "stealth")
+
+	fastConfig := *base
+	fastConfig.EnablePadding = false
+	fastConfig.SessionRateLimit = 1 << 20
+	fast, fastAccepted := startTaggedListener(t, &fastConfig, "fast")
+
+	lanConfig := *base
+	lanConfig.AllowIps = []*IPRule{cidrRule("10.0.0.0/8", net.ParseIP("10.0.0.0"), 8)}
+	lan, _ := startTaggedListener(t, &lanConfig, "lan")
+
+	if stealth.hub != fast.hub || fast.hub != lan.hub {
+		t.Fatal("inbounds of one group got separate hubs")
+	}
+	// Фильтр одного inbound-а не закрывает остальные
+	dialRejected(t, lan, base)
+
+	clientConfig := *base
+	clientConfig.HubGroup = ""
+	stealthClient, stealthServer := dialTestClient(t, stealth, &clientConfig, stealthAccepted)
+	fastClient, fastServer := dialTestClient(t, fast, &clientConfig, fastAccepted)
+
+	for _, c := range []struct {
+		client  *GameTunnelClientConn
+		tag     string
+		padding bool
+		rate    float64
+	}{
+		{stealthClient, "stealth", true, 0},
+		{fastClient, "fast", false, 1 << 20},
+	} {
+		s := stealth.hub.sessions.get(c.client.session().ConnectionID)
+		if s == nil || s.InboundTag != c.tag {
+			t.Fatalf("%s session not in the shared hub", c.tag)
+		}
+		config := stealth.hub.sessionConfig(s)
+		if config.EnablePadding != c.padding || (c.padding && config.PaddingMinSize != 300) {
+			t.Errorf("%s session padding %v %d-%d", c.tag, config.EnablePadding, config.PaddingMinSize, config.PaddingMaxSize)
+		}
+		var rate float64
+		if s.limiter != nil {
+			rate = s.limiter.rate
+		}
+		if rate != c.rate {
+			t.Errorf("%s session rate %g, want %g", c.tag, rate, c.rate)
+		}
+	}
+
+	for _, c := range []struct {
+		client *GameTunnelClientConn
+		server net.Conn
+	}{
+		{stealthClient, stealthServer},
+		{fastClient, fastServer},
+	} {
+		request := []byte("player_move: x=1 y=2")
+		if _, err := c.client.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		if got := readWithTimeout(t, c.server, 2048); !bytes.Equal(got, request) {
+			t.Errorf("server got %q, want %q", got, request)
+		}
+	}
+
+	// Закрытие inbound-а закрывает его сессии, хаб работает дальше
+	fast.Close()
+	if stealth.hub.sessions.get(fastClient.session().ConnectionID) != nil {
+		t.Error("session of the closed inbound kept")
+	}
+	response := []byte("world_state: tick=1")
+	if _, err := stealthServer.Write(response); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, stealthClient, 2048); !bytes.Equal(got, response) {
+		t.Errorf("client got %q, want %q", got, response)
+	}
+
+	stealth.Close()
+	lan.Close()
+	hubGroups.Lock()
+	_, ok := hubGroups.groups[base.HubGroup]
+	hubGroups.Unlock()
+	if ok {
+		t.Error("group kept after its last inbound closed")
+	}
+}
+
+func TestHubGroupJoin(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "hub-group"
+	config.HubGroup = t.Name()
+	startTaggedListener(t, config, "first")
+
+	if l, _, err := listenTagged(config, "first"); err == nil {
+		l.Close()
+		t.Error("second inbound with the same tag joined")
+	}
+	other := *config
+	other.Key = "other"
+	if l, _, err := listenTagged(&other, "second"); err == nil {
+		l.Close()
+		t.Error("inbound with another key joined")
+	}
+	upgrade := *config
+	upgrade.UpgradeSocket = "/tmp/gametunnel-upgrade.sock"
+	if upgrade.Validate() == nil {
+		t.Error("hubGroup with upgradeSocket accepted")
+	}
+}
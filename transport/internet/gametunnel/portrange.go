@@ -0,0 +1,79 @@
+package gametunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ====================================================================
+// Диапазон портов сервера (portRange)
+// ====================================================================
+//
+// portRange "20000-20010" - сервер доступен на всех портах диапазона:
+//
+//   - listener открывает по сокету (по receiveSockets сокетов) на
+//     каждом порту диапазона по адресу inbound-а, как extraListen;
+//     порт самого inbound-а может входить в диапазон или нет
+//   - клиент добавляет адрес сервера с каждым портом диапазона в
+//     endpoints: если провайдер режет или троттлит один порт, Dial и
+//     переподключение уходят на другой (endpoints.go)
+//
+// Один порт - "20000". Диапазон не шире maxPortRange портов: каждый
+// порт сервера - сокет и горутина приёма.
+//
+// ====================================================================
+
+// maxPortRange - наибольшее число портов в portRange
+const maxPortRange = 64
+
+// parsePortRange разбирает "lo-hi" или "port"
+func parsePortRange(s string) (lo, hi int, err error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if lo, err = parsePort(first); err != nil {
+		return 0, 0, fmt.Errorf("port range %q: %w", s, err)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = parsePort(last); err != nil {
+			return 0, 0, fmt.Errorf("port range %q: %w", s, err)
+		}
+	}
+	if hi < lo {
+		return 0, 0, fmt.Errorf("port range %q: end below start", s)
+	}
+	if hi-lo+1 > maxPortRange {
+		return 0, 0, fmt.Errorf("port range %q: more than %d ports", s, maxPortRange)
+	}
+	return lo, hi, nil
+}
+
+// parsePort разбирает номер порта 1-65535
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || port <= 0 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return port, nil
+}
+
+// portRangeAddrs - адреса "host:port" для портов portRange, кроме
+// skip (порт, который уже занят основным адресом)
+// Пустой или неверный portRange - нет адресов (проверяет Validate)
+func portRangeAddrs(config *Config, host string, skip int) []string {
+	if config.PortRange == "" {
+		return nil
+	}
+	lo, hi, err := parsePortRange(config.PortRange)
+	if err != nil {
+		return nil
+	}
+	addrs := make([]string, 0, hi-lo+1)
+	for port := lo; port <= hi; port++ {
+		if port != skip {
+			addrs = append(addrs, net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,82 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in     string
+		lo, hi int
+		ok     bool
+	}{
+		{"20000-20010", 20000, 20010, true},
+		{"443", 443, 443, true},
+		{" 1000 - 1001 ", 1000, 1001, true},
+		{"20010-20000", 0, 0, false},
+		{"0-10", 0, 0, false},
+		{"1000-2000", 0, 0, false}, // шире maxPortRange
+		{"abc", 0, 0, false},
+	}
+	for _, tt := range tests {
+		lo, hi, err := parsePortRange(tt.in)
+		if (err == nil) != tt.ok || lo != tt.lo || hi != tt.hi {
+			t.Errorf("%q: %d-%d, %v", tt.in, lo, hi, err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.PortRange = "1000-2000"
+	if err := config.Validate(); err == nil {
+		t.Error("oversized portRange accepted")
+	}
+}
+
+func TestPortRangeEndpoints(t *testing.T) {
+	config := DefaultConfig()
+	config.PortRange = "20000-20002"
+	dest := xnet.UDPDestination(xnet.ParseAddress("127.0.0.1"), 20001)
+
+	addrs, err := resolveEndpoints(context.Background(), dest, config, &serverResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"127.0.0.1:20001", "127.0.0.1:20000", "127.0.0.1:20002"}
+	if len(addrs) != len(want) {
+		t.Fatalf("endpoints %v, want %v", addrs, want)
+	}
+	for i, addr := range addrs {
+		if addr.String() != want[i] {
+			t.Errorf("endpoint %d: %s, want %s", i, addr, want[i])
+		}
+	}
+}
+
+func TestListenerPortRange(t *testing.T) {
+	// Свободный порт для диапазона из одного порта
+	probe, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := probe.LocalAddr().(*net.UDPAddr).Port
+	probe.Close()
+
+	config := DefaultConfig()
+	config.Key = "port-range"
+	config.PortRange = (&net.UDPAddr{Port: port}).String()[1:]
+	l, accepted := startTestListener(t, config)
+
+	client, server := dialTestClientAt(t, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port}, config, accepted)
+	defer client.Close()
+	client.Write([]byte("range"))
+	if got := readWithTimeout(t, server, 64); string(got) != "range" {
+		t.Errorf("server read %q", got)
+	}
+	if l.hub.GetActiveSessions() != 1 {
+		t.Errorf("active sessions %d", l.hub.GetActiveSessions())
+	}
+}
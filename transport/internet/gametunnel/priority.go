@@ -27,6 +27,11 @@ import (
 //   - Нет race condition при checkStarvation
 //   - Нет потери пакетов
 //
+// Очередь разбирается горутиной-отправителем (Hub.sendLoop,
+// GameTunnelClientConn.sendLoop) через DequeueBlocking: Write
+// только шифрует и ставит пакет в очередь, а на провод пакеты
+// уходят строго в порядке приоритета.
+//
 // Три уровня приоритета:
 //   0 (High)   - игры, VoIP, DNS (< 256 байт)
 //   1 (Medium) - веб-страницы, стриминг (256-1024 байт)
@@ -68,8 +73,15 @@ type PriorityPacket struct {
 	// Используется для предотвращения starvation
 	EnqueuedAt time.Time
 
+	// Deadline - крайний срок отправки (EnqueuedAt + бюджет класса)
+	// Используется планировщиком EDF
+	Deadline time.Time
+
 	// Session - сессия, которой принадлежит пакет
 	Session *Session
+
+	// Duplicate - отправитель пишет пакет дважды (duplicate.go)
+	Duplicate bool
 }
 
 // ====================================================================
@@ -82,12 +94,23 @@ type priorityRing struct {
 	tail int
 	size int
 	cap  int
+
+	// limit - штатная ёмкость; слоты сверх неё (до cap) занимаются
+	// только через PushSpare - вытеснением пакета другого класса
+	limit int
 }
 
 func newPriorityRing(capacity int) *priorityRing {
+	return newPriorityRingWithSpare(capacity, 0)
+}
+
+// newPriorityRingWithSpare создаёт кольцо с запасом spare слотов
+// для пакетов, вытеснивших чужой пакет (bump)
+func newPriorityRingWithSpare(capacity, spare int) *priorityRing {
 	return &priorityRing{
-		buf: make([]*PriorityPacket, capacity),
-		cap: capacity,
+		buf:   make([]*PriorityPacket, capacity+spare),
+		cap:   capacity + spare,
+		limit: capacity,
 	}
 }
 
@@ -96,6 +119,14 @@ func (r *priorityRing) Len() int {
 }
 
 func (r *priorityRing) Push(pkt *PriorityPacket) bool {
+	if r.size >= r.limit {
+		return false
+	}
+	return r.PushSpare(pkt)
+}
+
+// PushSpare добавляет пакет сверх штатной ёмкости (в запасные слоты)
+func (r *priorityRing) PushSpare(pkt *PriorityPacket) bool {
 	if r.size == r.cap {
 		return false
 	}
@@ -140,12 +171,42 @@ type PriorityQueue struct {
 	enqueuedHigh   uint64
 	enqueuedMedium uint64
 	enqueuedLow    uint64
-	dropped        uint64
+
+	// Потери по причинам (см. droppolicy.go)
+	droppedOverflow uint64
+	droppedBump     uint64
+	droppedCodel    uint64
+
+	// classes - счётчики и гистограммы ожидания по классам
+	classes [PriorityLevels]priorityClassCounters
+
+	// dropPolicy - политика отбрасывания при переполнении
+	dropPolicy DropPolicy
+
+	// lowDropHead - переполнение Low вытесняет старый пакет
+	// независимо от dropPolicy (sender.go)
+	lowDropHead bool
+
+	// codel - состояние CoDel для Low (DropPolicy_CODEL)
+	codel codelState
 
 	// starvationTimeout - максимальное время ожидания в очереди
 	// Если пакет ждёт дольше - его приоритет повышается
 	starvationTimeout time.Duration
 
+	// scheduler - алгоритм выбора пакета (strict priority / EDF)
+	scheduler SchedulerType
+
+	// budgets - бюджеты задержки классов для EDF
+	budgets [PriorityLevels]time.Duration
+
+	// notify - сигнал отправителю о новом пакете (буфер 1)
+	notify chan struct{}
+
+	// done - закрывается при Close, будит DequeueBlocking
+	done      chan struct{}
+	closeOnce sync.Once
+
 	mu sync.Mutex
 }
 
@@ -154,77 +215,98 @@ func NewPriorityQueue(mode PriorityMode) *PriorityQueue {
 	pq := &PriorityQueue{
 		mode:              mode,
 		starvationTimeout: 500 * time.Millisecond, // 500ms starvation guard
+		budgets:           defaultLatencyBudgets,
+		notify:            make(chan struct{}, 1),
+		done:              make(chan struct{}),
 	}
 
-	pq.queues[PriorityHigh] = newPriorityRing(HighQueueSize)
+	// High может занять слоты вытесненных Low - запас размером с Low
+	pq.queues[PriorityHigh] = newPriorityRingWithSpare(HighQueueSize, LowQueueSize)
 	pq.queues[PriorityMedium] = newPriorityRing(MediumQueueSize)
 	pq.queues[PriorityLow] = newPriorityRing(LowQueueSize)
 
+	for level := range pq.classes {
+		pq.classes[level].wait = newQueueWaitHistogram()
+	}
+
 	return pq
 }
 
 // Enqueue добавляет пакет в очередь с автоматической классификацией
 func (pq *PriorityQueue) Enqueue(data []byte, session *Session) bool {
-	priority := pq.classify(data)
+	return pq.EnqueueWithPriority(data, pq.classify(data), session)
+}
+
+// EnqueueWithPriority добавляет пакет с явно указанным приоритетом
+// Отправители классифицируют по открытому тексту: размер data уже
+// включает padding и заголовки и искажает классификацию
+func (pq *PriorityQueue) EnqueueWithPriority(data []byte, priority PriorityLevel, session *Session) bool {
+	return pq.enqueue(data, priority, session, false)
+}
+
+// enqueue добавляет пакет; duplicate - пакет дублирующего потока
+func (pq *PriorityQueue) enqueue(data []byte, priority PriorityLevel, session *Session, duplicate bool) bool {
+	if priority >= PriorityLevels {
+		priority = PriorityLow
+	}
 
 	pkt := &PriorityPacket{
 		Data:       data,
 		Priority:   priority,
 		EnqueuedAt: time.Now(),
 		Session:    session,
+		Duplicate:  duplicate,
 	}
 
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
-
+	pkt.Deadline = pkt.EnqueuedAt.Add(pq.budgets[priority])
 	ok := pq.queues[priority].Push(pkt)
 	if !ok {
 		// Очередь полна - для High-priority пытаемся вытеснить Low
 		if priority == PriorityHigh {
 			ok = pq.tryBumpLocked(pkt)
 		}
+		if !ok && (pq.dropPolicy == DropPolicy_HEAD || (priority == PriorityLow && pq.lowDropHead)) {
+			ok = pq.pushHeadDropLocked(pkt)
+		}
 		if !ok {
-			pq.dropped++
+			pq.droppedOverflow++
+			pq.recordDropLocked(priority)
+			pq.mu.Unlock()
 			return false
 		}
 	}
-
 	pq.updateStatsLocked(priority)
-	return true
-}
-
-// EnqueueWithPriority добавляет пакет с явно указанным приоритетом
-func (pq *PriorityQueue) EnqueueWithPriority(data []byte, priority PriorityLevel, session *Session) bool {
-	if priority >= PriorityLevels {
-		priority = PriorityLow
-	}
-
-	pkt := &PriorityPacket{
-		Data:       data,
-		Priority:   priority,
-		EnqueuedAt: time.Now(),
-		Session:    session,
-	}
-
-	pq.mu.Lock()
-	defer pq.mu.Unlock()
+	pq.mu.Unlock()
 
-	ok := pq.queues[priority].Push(pkt)
-	if !ok {
-		pq.dropped++
-		return false
+	// Будим отправителя (неблокирующе: сигнал уже может ждать)
+	select {
+	case pq.notify <- struct{}{}:
+	default:
 	}
 
-	pq.updateStatsLocked(priority)
 	return true
 }
 
 // Dequeue извлекает следующий пакет для отправки (non-blocking).
 // Приоритет: High → (starvation check Low) → Medium → Low
+// В режиме EDF - пакет с ближайшим дедлайном
 func (pq *PriorityQueue) Dequeue() *PriorityPacket {
 	pq.mu.Lock()
 	defer pq.mu.Unlock()
 
+	pkt := pq.dequeueLocked()
+	pq.recordDequeueLocked(pkt)
+	return pkt
+}
+
+// dequeueLocked выбирает пакет по текущему планировщику
+// Вызывается под mu.Lock
+func (pq *PriorityQueue) dequeueLocked() *PriorityPacket {
+	if pq.scheduler == SchedulerType_EDF {
+		return pq.dequeueEDFLocked()
+	}
+
 	// Всегда сначала High
 	if pkt := pq.queues[PriorityHigh].Pop(); pkt != nil {
 		return pkt
@@ -233,7 +315,9 @@ func (pq *PriorityQueue) Dequeue() *PriorityPacket {
 	// Starvation check: безопасный Peek() - НЕ извлекаем пакет
 	if lowHead := pq.queues[PriorityLow].Peek(); lowHead != nil {
 		if time.Since(lowHead.EnqueuedAt) > pq.starvationTimeout {
-			return pq.queues[PriorityLow].Pop()
+			if pkt := pq.popLocked(PriorityLow); pkt != nil {
+				return pkt
+			}
 		}
 	}
 
@@ -243,7 +327,7 @@ func (pq *PriorityQueue) Dequeue() *PriorityPacket {
 	}
 
 	// Low
-	if pkt := pq.queues[PriorityLow].Pop(); pkt != nil {
+	if pkt := pq.popLocked(PriorityLow); pkt != nil {
 		return pkt
 	}
 
@@ -251,25 +335,69 @@ func (pq *PriorityQueue) Dequeue() *PriorityPacket {
 }
 
 // DequeueBlocking извлекает пакет с блокировкой до получения
-// Используется в основном цикле отправки
+// Используется в основном цикле отправки.
+// После Close сначала выдаёт оставшиеся пакеты, затем возвращает
+// nil - сигнал отправителю завершиться.
 func (pq *PriorityQueue) DequeueBlocking() *PriorityPacket {
 	for {
 		pkt := pq.Dequeue()
 		if pkt != nil {
 			return pkt
 		}
-		// Короткий sleep вместо busy-wait
-		time.Sleep(100 * time.Microsecond)
+
+		select {
+		case <-pq.notify:
+		case <-pq.done:
+			return nil
+		}
+	}
+}
+
+// Close останавливает очередь: DequeueBlocking перестаёт ждать
+// новых пакетов и возвращает nil, как только очередь опустеет
+func (pq *PriorityQueue) Close() {
+	pq.closeOnce.Do(func() {
+		close(pq.done)
+	})
+}
+
+// Len возвращает количество пакетов во всех очередях
+func (pq *PriorityQueue) Len() int {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	n := 0
+	for _, q := range pq.queues {
+		n += q.Len()
+	}
+	return n
+}
+
+// classLens возвращает число пакетов в очереди каждого класса
+func (pq *PriorityQueue) classLens() [PriorityLevels]int {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	var lens [PriorityLevels]int
+	for level, q := range pq.queues {
+		lens[level] = q.Len()
 	}
+	return lens
 }
 
 // classify определяет приоритет пакета по его характеристикам
 func (pq *PriorityQueue) classify(data []byte) PriorityLevel {
-	switch pq.mode {
+	return ClassifyPacket(pq.mode, data)
+}
+
+// ClassifyPacket определяет приоритет пакета в заданном режиме
+// Используется и очередью, и отправителями без очереди (DSCP-маркировка)
+func ClassifyPacket(mode PriorityMode, data []byte) PriorityLevel {
+	switch mode {
 	case PriorityMode_GAMING:
-		return pq.classifyGaming(data)
+		return classifyGaming(data)
 	case PriorityMode_STREAMING:
-		return pq.classifyStreaming(data)
+		return classifyStreaming(data)
 	default:
 		return PriorityMedium // Без приоритизации - всё в Medium
 	}
@@ -277,7 +405,7 @@ func (pq *PriorityQueue) classify(data []byte) PriorityLevel {
 
 // classifyGaming - классификация для gaming-режима
 // Маленькие пакеты = высокий приоритет (игровой трафик)
-func (pq *PriorityQueue) classifyGaming(data []byte) PriorityLevel {
+func classifyGaming(data []byte) PriorityLevel {
 	size := len(data)
 
 	if size <= HighPriorityMaxSize {
@@ -293,7 +421,7 @@ func (pq *PriorityQueue) classifyGaming(data []byte) PriorityLevel {
 
 // classifyStreaming - классификация для streaming-режима
 // Средние пакеты = высокий приоритет (видео/аудио чанки)
-func (pq *PriorityQueue) classifyStreaming(data []byte) PriorityLevel {
+func classifyStreaming(data []byte) PriorityLevel {
 	size := len(data)
 
 	if size <= HighPriorityMaxSize {
@@ -308,23 +436,24 @@ func (pq *PriorityQueue) classifyStreaming(data []byte) PriorityLevel {
 }
 
 // tryBumpLocked вытесняет Low-priority пакет ради High-priority.
+// High-пакет занимает запасной слот High вместо освобождённого
+// слота Low, общий объём очереди не растёт.
 // Вызывается под mu.Lock. Не трогает Medium.
 func (pq *PriorityQueue) tryBumpLocked(highPkt *PriorityPacket) bool {
-	// Забираем из Low
-	dropped := pq.queues[PriorityLow].Pop()
-	if dropped == nil {
+	high := pq.queues[PriorityHigh]
+	if high.Len() == high.cap {
+		// Запас исчерпан - вытеснение ничего не даст
 		return false
 	}
-	pq.dropped++
 
-	// Кладём high-priority пакет в High очередь
-	if ok := pq.queues[PriorityHigh].Push(highPkt); ok {
-		return true
+	// Забираем из Low
+	if pq.queues[PriorityLow].Pop() == nil {
+		return false
 	}
+	pq.droppedBump++
+	pq.recordDropLocked(PriorityLow)
 
-	// Не удалось - edge case
-	pq.dropped++
-	return false
+	return high.PushSpare(highPkt)
 }
 
 func (pq *PriorityQueue) updateStatsLocked(level PriorityLevel) {
@@ -344,27 +473,37 @@ func (pq *PriorityQueue) GetStats() PriorityQueueStats {
 	defer pq.mu.Unlock()
 
 	return PriorityQueueStats{
-		HighQueued:     pq.queues[PriorityHigh].Len(),
-		MediumQueued:   pq.queues[PriorityMedium].Len(),
-		LowQueued:      pq.queues[PriorityLow].Len(),
-		TotalEnqueued:  pq.enqueuedHigh + pq.enqueuedMedium + pq.enqueuedLow,
-		HighEnqueued:   pq.enqueuedHigh,
-		MediumEnqueued: pq.enqueuedMedium,
-		LowEnqueued:    pq.enqueuedLow,
-		Dropped:        pq.dropped,
+		HighQueued:      pq.queues[PriorityHigh].Len(),
+		MediumQueued:    pq.queues[PriorityMedium].Len(),
+		LowQueued:       pq.queues[PriorityLow].Len(),
+		TotalEnqueued:   pq.enqueuedHigh + pq.enqueuedMedium + pq.enqueuedLow,
+		HighEnqueued:    pq.enqueuedHigh,
+		MediumEnqueued:  pq.enqueuedMedium,
+		LowEnqueued:     pq.enqueuedLow,
+		Dropped:         pq.droppedOverflow + pq.droppedBump + pq.droppedCodel,
+		DroppedOverflow: pq.droppedOverflow,
+		DroppedBump:     pq.droppedBump,
+		DroppedCodel:    pq.droppedCodel,
+		Classes:         pq.classStatsLocked(),
 	}
 }
 
 // PriorityQueueStats - статистика для панели управления
 type PriorityQueueStats struct {
-	HighQueued     int    `json:"highQueued"`
-	MediumQueued   int    `json:"mediumQueued"`
-	LowQueued      int    `json:"lowQueued"`
-	TotalEnqueued  uint64 `json:"totalEnqueued"`
-	HighEnqueued   uint64 `json:"highEnqueued"`
-	MediumEnqueued uint64 `json:"mediumEnqueued"`
-	LowEnqueued    uint64 `json:"lowEnqueued"`
-	Dropped        uint64 `json:"dropped"`
+	HighQueued      int    `json:"highQueued"`
+	MediumQueued    int    `json:"mediumQueued"`
+	LowQueued       int    `json:"lowQueued"`
+	TotalEnqueued   uint64 `json:"totalEnqueued"`
+	HighEnqueued    uint64 `json:"highEnqueued"`
+	MediumEnqueued  uint64 `json:"mediumEnqueued"`
+	LowEnqueued     uint64 `json:"lowEnqueued"`
+	Dropped         uint64 `json:"dropped"`
+	DroppedOverflow uint64 `json:"droppedOverflow"`
+	DroppedBump     uint64 `json:"droppedBump"`
+	DroppedCodel    uint64 `json:"droppedCodel"`
+
+	// Classes - статистика по классам (индекс - PriorityLevel)
+	Classes [PriorityLevels]PriorityClassStats `json:"classes"`
 }
 
 // ====================================================================
@@ -434,6 +573,34 @@ func (be *BandwidthEstimator) GetEstimate() float64 {
 	return sum / float64(len(be.samples))
 }
 
+// GetMax возвращает максимальный замер в окне (байт/сек)
+// Средняя занижает пропускную способность после простоя,
+// максимум ближе к ёмкости канала
+func (be *BandwidthEstimator) GetMax() float64 {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	max := 0.0
+	for _, s := range be.samples {
+		if s > max {
+			max = s
+		}
+	}
+	return max
+}
+
+// GetLast возвращает последний замер (байт/сек): загрузку канала
+// сейчас, без усреднения по окну
+func (be *BandwidthEstimator) GetLast() float64 {
+	be.mu.Lock()
+	defer be.mu.Unlock()
+
+	if len(be.samples) == 0 {
+		return 0
+	}
+	return be.samples[len(be.samples)-1]
+}
+
 // GetEstimateMbps возвращает оценку в Мбит/сек
 func (be *BandwidthEstimator) GetEstimateMbps() float64 {
 	return be.GetEstimate() * 8 / 1_000_000
@@ -446,4 +613,4 @@ func (be *BandwidthEstimator) IsCongestedBy(threshold float64, maxBandwidth floa
 		return false
 	}
 	return estimate/maxBandwidth > threshold
-}
\ No newline at end of file
+}
@@ -0,0 +1,280 @@
+package gametunnel
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// ====================================================================
+// Проба маршрута до сервера
+// ====================================================================
+//
+// Клиентскому приложению с несколькими серверами нужно выбрать
+// ближайший до подключения. Probe меряет маршрут без соединения:
+//
+//	result, err := gametunnel.Probe(ctx, "game-eu.example.com:443", config)
+//
+// Проба - хэндшейк общей сессии (mux.go) без остальных
+// возможностей (ни 0-RTT, ни сжатия заголовков, ни меток времени) с
+// отдельного сокета, затем probePings запечатанных Ping (quality.go)
+// через probePingInterval и ожидание Pong до probeWait после
+// последнего. У каждого Ping своя запись ожидания, так что ни один
+// не вытесняется, а потери считаются с шагом 1/probePings. Сразу
+// после замеров сессия закрывается Control Close. Ни горутин, ни
+// очередей, ни таймеров соединения проба не заводит; в клиентскую
+// статистику она не попадает.
+//
+// Общая сессия уходит в xray только потоками, а проба потоков не
+// открывает: сервер не заводит под неё соединение xray и не
+// маршрутизирует его. Остальное у сервера - как у короткой сессии:
+// ECDH хэндшейка, запись в таблице сессий и слот ipGuard до
+// закрытия, счётчики handshakes и totalSessions, session_created и
+// session_closed в auditLog. Частые пробы к одному серверу стоят
+// ему столько же хэндшейков. Puzzle (puzzle.go) решается как при
+// Dial, и время решения входит в Handshake. Старый сервер отвечает
+// на Ping пустым Pong - RTT по нему не замерить, и Probe возвращает
+// ошибку.
+//
+// Результат - RTT и джиттер по замерам пробы (без сглаживания),
+// доля Ping без ответа и оценка 0-100 по той же формуле, что
+// quality.score соединения. Серверы удобно упорядочить по Score, а
+// при равенстве - по RTT.
+//
+// ====================================================================
+
+const (
+	// probePings - Ping одной пробы: потери с шагом 5%
+	probePings = 20
+
+	// probePingInterval - пауза между Ping пробы
+	probePingInterval = 20 * time.Millisecond
+
+	// probeWait - ожидание Pong после последнего Ping
+	probeWait = time.Second
+)
+
+// ProbeResult - оценка маршрута до сервера
+type ProbeResult struct {
+	// Server - адрес, с которым прошла проба
+	Server string `json:"server"`
+
+	// Handshake - время хэндшейка (с решением задачи сервера)
+	Handshake time.Duration `json:"handshake"`
+
+	// RTT, MinRTT и Jitter - среднее, наименьшее и среднее изменение
+	// соседних замеров
+	RTT    time.Duration `json:"rtt"`
+	MinRTT time.Duration `json:"minRtt"`
+	Jitter time.Duration `json:"jitter"`
+
+	// Loss - доля Ping без ответа, Sent и Received - Ping и Pong
+	Loss     float64 `json:"loss"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+
+	// Score - оценка маршрута 0-100 (quality.go)
+	Score int `json:"score"`
+}
+
+// Probe меряет маршрут до сервера serverAddr ("host:port") без
+// соединения: хэндшейк, обмен Ping и закрытие сессии
+// config - настройки клиента (nil - DefaultConfig); отмена ctx и его
+// дедлайн прерывают пробу
+func Probe(ctx context.Context, serverAddr string, config *Config) (*ProbeResult, error) {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid GameTunnel config: %w", err)
+	}
+
+	// Хэндшейк общей сессии без потоков: сервер не отдаёт её в xray
+	// Данных проба не ведёт, а билет 0-RTT достанется настоящему Dial
+	probeConfig := *config
+	probeConfig.SharedSession = true
+	probeConfig.AppStreams = false
+	probeConfig.EarlyData = false
+	probeConfig.CompactHeaders = false
+	probeConfig.Timestamps = false
+
+	addr, err := resolveProbeAddr(ctx, serverAddr, &probeConfig)
+	if err != nil {
+		return nil, err
+	}
+	obfs := NewObfuscator(probeConfig.Obfuscation, &probeConfig)
+	conn, err := dialPathSocket(ctx, nil, addr, &probeConfig, nil)
+	if err != nil {
+		return nil, fmt.Errorf("dial UDP %s: %w", addr, err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	session, err := performHandshake(ctx, conn, &probeConfig, obfs)
+	if err != nil {
+		return nil, fmt.Errorf("handshake failed: %w", err)
+	}
+	result := &ProbeResult{Server: addr.String(), Handshake: time.Since(start)}
+
+	// Соединение без горутин - ради sealControl и openControl
+	c := &GameTunnelClientConn{config: &probeConfig, obfs: obfs}
+	c.current.Store(session)
+	defer func() {
+		if wrapped, err := c.closeControl(); err == nil {
+			conn.Write(wrapped)
+		}
+	}()
+
+	samples, err := c.probePings(ctx, conn, result)
+	if err != nil {
+		return nil, err
+	}
+	if result.Received == 0 {
+		return nil, errors.New("no pong from server")
+	}
+	result.summarize(samples)
+	return result, nil
+}
+
+// resolveProbeAddr разрешает адрес сервера пробы по настройкам
+// резолвера (resolve.go); берётся первый адрес
+func resolveProbeAddr(ctx context.Context, serverAddr string, config *Config) (*net.UDPAddr, error) {
+	host, portStr, err := net.SplitHostPort(serverAddr)
+	if err != nil {
+		return nil, fmt.Errorf("server address: %w", err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("server address %q: invalid port", serverAddr)
+	}
+	resolver, err := newServerResolver(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := resolver.lookup(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("resolve server %s: %w", host, err)
+	}
+	addrs := make([]*net.UDPAddr, len(ips))
+	for i, ip := range ips {
+		addrs[i] = &net.UDPAddr{IP: ip, Port: port}
+	}
+	addrs = applyResolveStrategy(addrs, config.ResolveStrategy)
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("resolve server %s: no addresses for strategy %d", host, config.ResolveStrategy)
+	}
+	return addrs[0], nil
+}
+
+// probePings шлёт Ping пробы и принимает Pong до probeWait после
+// последнего Ping или до ответа на все
+// Возвращает замеры RTT в порядке ответов
+func (c *GameTunnelClientConn) probePings(ctx context.Context, conn PacketConn, result *ProbeResult) ([]time.Duration, error) {
+	var pings [probePings]pendingPing
+	samples := make([]time.Duration, 0, probePings)
+	buf := make([]byte, MaxPacketSize)
+	next := time.Now()
+	for result.Received < probePings {
+		now := time.Now()
+		if !now.Before(next) {
+			if result.Sent == probePings {
+				return samples, nil
+			}
+			ping := &pings[result.Sent]
+			var nonce [8]byte
+			rand.Read(nonce[:])
+			*ping = pendingPing{seq: uint64(result.Sent + 1), nonce: binary.BigEndian.Uint64(nonce[:]), sentAt: now.UnixNano()}
+			body := binary.BigEndian.AppendUint64(make([]byte, 0, pingBodySize), ping.seq)
+			wrapped, err := c.sealControl(0x01, binary.BigEndian.AppendUint64(body, ping.nonce))
+			if err != nil {
+				return nil, err
+			}
+			if _, err := conn.Write(wrapped); err != nil {
+				return nil, fmt.Errorf("send ping: %w", err)
+			}
+			result.Sent++
+			next = now.Add(probePingInterval)
+			if result.Sent == probePings {
+				next = now.Add(probeWait)
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		deadline := next
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				continue
+			}
+			return nil, fmt.Errorf("read pong: %w", err)
+		}
+		if rtt, ok := c.probePong(buf[:n], pings[:result.Sent]); ok {
+			samples = append(samples, rtt)
+			result.Received++
+		}
+	}
+	return samples, nil
+}
+
+// probePong - пакет сервера оказался Pong на ещё не отвеченный Ping
+// пробы из pings; возвращает RTT
+func (c *GameTunnelClientConn) probePong(raw []byte, pings []pendingPing) (time.Duration, bool) {
+	data, err := c.obfs.Unwrap(raw)
+	if err != nil || len(data) == 0 {
+		return 0, false
+	}
+	if pktType, _, err := DecodeFlags(data[0]); err != nil || pktType != PacketType_CONTROL {
+		return 0, false
+	}
+	pkt, err := Unmarshal(data, int(c.config.ConnectionIdLength))
+	if err != nil || len(pkt.Payload) < 2 || pkt.Payload[0] != 0x02 {
+		return 0, false
+	}
+	body, ok := c.openControl(c.session(), pkt, data)
+	if !ok || len(body) != pingBodySize {
+		return 0, false
+	}
+	seq := binary.BigEndian.Uint64(body)
+	nonce := binary.BigEndian.Uint64(body[8:])
+	if seq == 0 || seq > uint64(len(pings)) {
+		return 0, false
+	}
+	ping := &pings[seq-1]
+	if ping.sentAt == 0 || ping.nonce != nonce {
+		return 0, false
+	}
+	rtt := time.Since(time.Unix(0, ping.sentAt))
+	ping.sentAt = 0
+	return rtt, true
+}
+
+// summarize считает оценку маршрута по замерам RTT пробы
+func (r *ProbeResult) summarize(samples []time.Duration) {
+	var total, jitter time.Duration
+	for i, rtt := range samples {
+		total += rtt
+		if r.MinRTT == 0 || rtt < r.MinRTT {
+			r.MinRTT = rtt
+		}
+		if i > 0 {
+			jitter += (rtt - samples[i-1]).Abs()
+		}
+	}
+	r.RTT = total / time.Duration(len(samples))
+	if len(samples) > 1 {
+		r.Jitter = jitter / time.Duration(len(samples)-1)
+	}
+	r.Loss = 1 - float64(r.Received)/float64(r.Sent)
+	r.Score = qualityScore(r.RTT, r.Jitter, r.Loss)
+}
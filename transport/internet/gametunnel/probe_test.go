@@ -0,0 +1,81 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "probe"
+	config.EarlyData = true
+	l, accepted := startTestListener(t, config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	clientConfig := *config
+	result, err := Probe(ctx, l.Addr().String(), &clientConfig)
+	if err != nil {
+		t.Fatalf("Probe: %v", err)
+	}
+	if result.Sent != probePings || result.Received != probePings || result.Loss != 0 {
+		t.Errorf("sent %d, received %d, loss %v", result.Sent, result.Received, result.Loss)
+	}
+	if result.RTT <= 0 || result.MinRTT <= 0 || result.MinRTT > result.RTT || result.Handshake <= 0 {
+		t.Errorf("rtt %v, min %v, handshake %v", result.RTT, result.MinRTT, result.Handshake)
+	}
+	if result.Score < 90 {
+		t.Errorf("score %d on loopback", result.Score)
+	}
+	if result.Server != l.Addr().String() {
+		t.Errorf("server %s, want %s", result.Server, l.Addr())
+	}
+
+	// Сессия пробы закрыта сразу, билет 0-RTT проба не берёт
+	deadline := time.Now().Add(time.Second)
+	for l.hub.GetActiveSessions() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := l.hub.GetActiveSessions(); n != 0 {
+		t.Errorf("%d sessions left after probe", n)
+	}
+	if _, ok := loadEarlyTicket(l.Addr().(*net.UDPAddr), &clientConfig); ok {
+		t.Error("probe stored a 0-RTT ticket")
+	}
+
+	// Сессия пробы не доходит до xray
+	select {
+	case conn := <-accepted:
+		conn.Close()
+		t.Error("probe session handed to xray")
+	default:
+	}
+}
+
+func TestProbeErrors(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "probe"
+	l, _ := startTestListener(t, config)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := Probe(ctx, "no-port", config); err == nil {
+		t.Error("probe of an address without port")
+	}
+
+	// Чужой ключ: Server Hello не подписан ключом пробы
+	wrongKey := *config
+	wrongKey.Key = "other"
+	if _, err := Probe(ctx, l.Addr().String(), &wrongKey); err == nil {
+		t.Error("probe with a wrong key succeeded")
+	}
+
+	// Отмена прерывает пробу
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if _, err := Probe(cancelled, l.Addr().String(), config); err == nil {
+		t.Error("cancelled probe succeeded")
+	}
+}
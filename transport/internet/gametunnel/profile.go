@@ -0,0 +1,85 @@
+package gametunnel
+
+// ====================================================================
+// Профили настроек: mobile, datacenter, stealth
+// ====================================================================
+//
+// Профиль - готовый набор значений по умолчанию под сценарий, чтобы
+// не подбирать десяток полей вручную:
+//
+//   - mobile     - сотовые сети: MTU 1280 (туннели операторов и IPv6
+//                  режут крупные пакеты), маскировка под WebRTC, как у
+//                  звонков из приложений, и крупный padding
+//   - datacenter - канал между серверами: без маскировки и padding,
+//                  буферы сокетов по 16 МБ и пачки sendmmsg по 64
+//   - stealth    - против DPI: маскировка под QUIC, широкий разброс
+//                  padding и покрывающий трафик в простое (chaff.go)
+//   - custom     - без профиля, DefaultConfig (по умолчанию)
+//
+// Профиль только задаёт исходные значения: ToConfig накладывает на
+// них явно заданные поля настроек, так что "profile": "mobile" с
+// "mtu": 1350 - профиль mobile с MTU 1350.
+//
+// ====================================================================
+
+// Profile - набор настроек под сценарий
+type Profile int32
+
+const (
+	// Profile_CUSTOM - без профиля
+	Profile_CUSTOM Profile = 0
+
+	// Profile_MOBILE - сотовые сети
+	Profile_MOBILE Profile = 1
+
+	// Profile_DATACENTER - канал между серверами
+	Profile_DATACENTER Profile = 2
+
+	// Profile_STEALTH - максимальная маскировка
+	Profile_STEALTH Profile = 3
+)
+
+// ProfileFromString парсит строковое значение профиля
+func ProfileFromString(s string) Profile {
+	switch s {
+	case "mobile", "MOBILE":
+		return Profile_MOBILE
+	case "datacenter", "dc", "DATACENTER":
+		return Profile_DATACENTER
+	case "stealth", "STEALTH":
+		return Profile_STEALTH
+	default:
+		return Profile_CUSTOM
+	}
+}
+
+// profileBufferSize - буферы сокетов профиля datacenter
+const profileBufferSize = 16 * 1024 * 1024
+
+// ProfileConfig возвращает DefaultConfig с настройками профиля
+func ProfileConfig(profile Profile) *Config {
+	config := DefaultConfig()
+	config.Profile = profile
+
+	switch profile {
+	case Profile_MOBILE:
+		config.MTU = 1280
+		config.Obfuscation = ObfuscationMode_WEBRTC_MIMIC
+		config.EnablePadding = true
+		config.PaddingMinSize = 64
+		config.PaddingMaxSize = 256
+	case Profile_DATACENTER:
+		config.Obfuscation = ObfuscationMode_RAW
+		config.EnablePadding = false
+		config.ReadBufferSize = profileBufferSize
+		config.WriteBufferSize = profileBufferSize
+		config.SendBatch = 64
+	case Profile_STEALTH:
+		config.Obfuscation = ObfuscationMode_QUIC_MIMIC
+		config.EnablePadding = true
+		config.PaddingMinSize = 40
+		config.PaddingMaxSize = 400
+		config.ChaffBudget = 4096
+	}
+	return config
+}
@@ -0,0 +1,81 @@
+package gametunnel
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProfileConfig(t *testing.T) {
+	mobile := ProfileConfig(ProfileFromString("mobile"))
+	if mobile.MTU != 1280 || mobile.Obfuscation != ObfuscationMode_WEBRTC_MIMIC || !mobile.EnablePadding {
+		t.Errorf("mobile: mtu %d, obfuscation %v, padding %v", mobile.MTU, mobile.Obfuscation, mobile.EnablePadding)
+	}
+
+	dc := ProfileConfig(ProfileFromString("datacenter"))
+	if dc.Obfuscation != ObfuscationMode_RAW || dc.EnablePadding || dc.ReadBufferSize != profileBufferSize {
+		t.Errorf("datacenter: obfuscation %v, padding %v, buffer %d", dc.Obfuscation, dc.EnablePadding, dc.ReadBufferSize)
+	}
+
+	stealth := ProfileConfig(ProfileFromString("stealth"))
+	if stealth.ChaffBudget == 0 || stealth.PaddingMaxSize <= DefaultConfig().PaddingMaxSize {
+		t.Errorf("stealth: chaff %d, padding max %d", stealth.ChaffBudget, stealth.PaddingMaxSize)
+	}
+
+	// Все профили проходят строгую проверку
+	for _, config := range []*Config{mobile, dc, stealth, ProfileConfig(ProfileFromString("custom"))} {
+		if err := config.Validate(); err != nil {
+			t.Errorf("profile %v: %v", config.Profile, err)
+		}
+	}
+}
+
+func TestProfileExplicitOverride(t *testing.T) {
+	noPadding := false
+	config := (&Settings{Profile: "mobile", Mtu: 1350, EnablePadding: &noPadding}).ToConfig()
+	if config.Profile != Profile_MOBILE || config.Obfuscation != ObfuscationMode_WEBRTC_MIMIC {
+		t.Errorf("profile %v, obfuscation %v", config.Profile, config.Obfuscation)
+	}
+	if config.MTU != 1350 || config.EnablePadding {
+		t.Errorf("explicit fields lost: mtu %d, padding %v", config.MTU, config.EnablePadding)
+	}
+
+	// Незаданное поле - из профиля, а не из DefaultConfig
+	config = (&Settings{Profile: "datacenter", ChaffBudget: 100}).ToConfig()
+	if config.EnablePadding || config.WriteBufferSize != profileBufferSize || config.ChaffBudget != 100 {
+		t.Errorf("datacenter: padding %v, buffer %d, chaff %d", config.EnablePadding, config.WriteBufferSize, config.ChaffBudget)
+	}
+}
+
+func TestChaffWhileIdle(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "chaff"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.ChaffBudget = 1 << 20
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for client.GetChaffSent() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.GetChaffSent() < 5 {
+		t.Fatalf("%d chaff packets sent", client.GetChaffSent())
+	}
+
+	session := l.hub.GetSession(client.session().ConnectionID)
+	session.mu.RLock()
+	recv := session.PacketsRecv
+	session.mu.RUnlock()
+	if recv == 0 {
+		t.Error("chaff did not reach the server")
+	}
+
+	// Пустые пакеты не доходят до приложения
+	client.Write([]byte("payload"))
+	if got := readWithTimeout(t, server, 64); !bytes.Equal(got, []byte("payload")) {
+		t.Errorf("server read %q", got)
+	}
+}
@@ -0,0 +1,371 @@
+package gametunnel
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Клиентская задача хэндшейка (puzzle) против флуда Client Hello
+// ====================================================================
+//
+// Каждый новый Client Hello стоит серверу ECDH, вывода ключей и
+// сессии - на порядки больше, чем клиенту отправить датаграмму.
+// ipGuard ограничивает один адрес, но не ботнет и не подделанные
+// адреса. С puzzleRate > 0 сервер считает нагрузку - Client Hello
+// в секунду - и сверх порога требует от клиента работы.
+//
+// Задача - в RETRY вместо Server Hello (ключей ещё нет, RETRY не
+// зашифрован, как BUSY):
+//
+//	CONTROL [0x0D][token]
+//	token = [срок: uint32 BE, Unix][сложность: 1][HMAC: 16]
+//
+// HMAC - ключом хаба по сроку, сложности, адресу клиента и
+// Connection ID: сервер не хранит выданные задачи, а токен,
+// подсмотренный для одного адреса, не годится для другого.
+//
+// Клиент подбирает nonce (8 байт), при котором
+// SHA-256(token || публичный ключ Client Hello || nonce) начинается
+// с «сложность» нулевых бит, и повторяет Client Hello с решением:
+// бит helloCapPuzzle и расширение [token][nonce] перед байтом
+// возможностей. Проверка решения - HMAC и один SHA-256, до ECDH.
+//
+// Сложность растёт с нагрузкой: puzzleMinBits на пороге и
+// puzzleBitsPerDoubling бит за каждое удвоение, до puzzleMaxBits.
+// 12 бит - около 4 тысяч хэшей, единицы миллисекунд клиента;
+// 20 бит - около миллиона, сотни миллисекунд. Клиент платит один раз
+// за Dial, флуд - за каждый Client Hello: 10 тысяч хэндшейков в
+// секунду при 20 битах - сотни ядер атакующего.
+//
+// Токен живёт puzzleTokenLifetime. Решение токена, выданного при
+// сложности заметно ниже текущей, не принимается: запас задач,
+// решённых в тихое время, во время флуда не поможет.
+//
+// Учёт стоимости: время обработки новых Client Hello на сервере и
+// подбора решений на клиенте - в метрике
+// gametunnel_handshake_cpu_seconds_total; нагрузка, текущая
+// сложность, выданные и решённые задачи - в HubStats.Puzzle.
+//
+// ====================================================================
+
+const (
+	// helloCapPuzzle - байт возможностей хэндшейка: решение задачи в
+	// Client Hello
+	helloCapPuzzle byte = 0x20
+
+	// controlRetry - команда RETRY (задача вместо Server Hello)
+	controlRetry = 0x0D
+
+	// puzzleTokenSize - токен задачи: срок, сложность, HMAC
+	puzzleTokenSize = 4 + 1 + 16
+
+	// puzzleNonceSize - nonce решения
+	puzzleNonceSize = 8
+
+	// puzzleExtSize - расширение Client Hello: токен и nonce
+	puzzleExtSize = puzzleTokenSize + puzzleNonceSize
+
+	// puzzleMinBits / puzzleMaxBits - сложность на пороге и предельная
+	puzzleMinBits = 12
+	puzzleMaxBits = 20
+
+	// puzzleBitsPerDoubling - прибавка сложности за удвоение нагрузки
+	puzzleBitsPerDoubling = 2
+
+	// puzzleTokenLifetime - срок действия токена
+	puzzleTokenLifetime = 30 * time.Second
+
+	// puzzleMaxRetries - RETRY, на которые клиент отвечает за один Dial
+	puzzleMaxRetries = 2
+)
+
+// puzzleRetry - сервер ответил на Client Hello задачей
+type puzzleRetry struct {
+	token []byte
+}
+
+func (e *puzzleRetry) Error() string {
+	return fmt.Sprintf("server requires a %d-bit handshake puzzle", e.token[4])
+}
+
+// parseRetry разбирает payload RETRY без подписи; false - это не RETRY
+func parseRetry(payload []byte) (*puzzleRetry, bool) {
+	if len(payload) != 1+puzzleTokenSize || payload[0] != controlRetry {
+		return nil, false
+	}
+	return &puzzleRetry{token: append([]byte(nil), payload[1:]...)}, true
+}
+
+// puzzleSolved проверяет, что SHA-256(token || publicKey || nonce)
+// начинается со сложности токена нулевых бит
+func puzzleSolved(token []byte, publicKey [Curve25519KeySize]byte, nonce []byte) bool {
+	var buf [puzzleTokenSize + Curve25519KeySize + puzzleNonceSize]byte
+	copy(buf[:], token)
+	copy(buf[puzzleTokenSize:], publicKey[:])
+	copy(buf[puzzleTokenSize+Curve25519KeySize:], nonce)
+	sum := sha256.Sum256(buf[:])
+	return bits.LeadingZeros64(binary.BigEndian.Uint64(sum[:])) >= int(token[4])
+}
+
+// solvePuzzle подбирает решение задачи token для Client Hello с
+// ключом publicKey; возвращает расширение [token][nonce]
+// Прерывается отменой ctx
+func solvePuzzle(ctx context.Context, token []byte, publicKey [Curve25519KeySize]byte) ([]byte, error) {
+	if token[4] > puzzleMaxBits {
+		return nil, fmt.Errorf("server asks for a %d-bit puzzle, limit %d", token[4], puzzleMaxBits)
+	}
+
+	var buf [puzzleTokenSize + Curve25519KeySize + puzzleNonceSize]byte
+	copy(buf[:], token)
+	copy(buf[puzzleTokenSize:], publicKey[:])
+	nonce := buf[puzzleTokenSize+Curve25519KeySize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("puzzle nonce: %w", err)
+	}
+
+	start := time.Now()
+	want := int(token[4])
+	counter := binary.BigEndian.Uint64(nonce)
+	for i := 0; ; i++ {
+		if i&0xFFF == 0 && ctx.Err() != nil {
+			return nil, fmt.Errorf("solve puzzle: %w", ctx.Err())
+		}
+		binary.BigEndian.PutUint64(nonce, counter)
+		sum := sha256.Sum256(buf[:])
+		if bits.LeadingZeros64(binary.BigEndian.Uint64(sum[:])) >= want {
+			break
+		}
+		counter++
+	}
+
+	atomic.AddUint64(&metrics.client.puzzles, 1)
+	atomic.AddUint64(&metrics.client.handshakeNanos, uint64(time.Since(start)))
+	solution := make([]byte, 0, puzzleExtSize)
+	solution = append(solution, token...)
+	return append(solution, nonce...), nil
+}
+
+// puzzleBits - сложность задачи при нагрузке load Client Hello в
+// секунду и пороге threshold (0 - задача не нужна)
+func puzzleBits(load float64, threshold uint32) uint8 {
+	if threshold == 0 || load <= float64(threshold) {
+		return 0
+	}
+	doublings := int(math.Log2(load / float64(threshold)))
+	return uint8(min(puzzleMinBits+puzzleBitsPerDoubling*doublings, puzzleMaxBits))
+}
+
+// ====================================================================
+// Сервер
+// ====================================================================
+
+// puzzleGuard - ключ токенов и замер нагрузки хэндшейками
+type puzzleGuard struct {
+	key [32]byte
+
+	// window - начало текущей секунды замера, count - Client Hello в
+	// ней, rate - сглаженная нагрузка прошлых секунд
+	mu     sync.Mutex
+	window time.Time
+	count  float64
+	rate   float64
+
+	// issued / solved - выдано задач и принято решений
+	issued uint64
+	solved uint64
+}
+
+// PuzzleStats - нагрузка хэндшейками и задачи хаба
+type PuzzleStats struct {
+	// HelloRate - Client Hello в секунду, Bits - текущая сложность
+	// (0 - задача не требуется)
+	HelloRate float64 `json:"helloRate"`
+	Bits      uint8   `json:"bits"`
+
+	Issued uint64 `json:"issued"`
+	Solved uint64 `json:"solved"`
+
+	// HandshakeCPUSeconds - время обработки новых Client Hello
+	HandshakeCPUSeconds float64 `json:"handshakeCpuSeconds"`
+}
+
+// newPuzzleGuard создаёт замер со случайным ключом токенов
+func newPuzzleGuard() *puzzleGuard {
+	g := &puzzleGuard{}
+	rand.Read(g.key[:])
+	return g
+}
+
+// observe учитывает Client Hello и возвращает нагрузку с ним
+func (g *puzzleGuard) observe(now time.Time) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollLocked(now)
+	g.count++
+	return max(g.rate, g.count)
+}
+
+// load возвращает нагрузку, Client Hello в секунду
+func (g *puzzleGuard) load(now time.Time) float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollLocked(now)
+	return max(g.rate, g.count)
+}
+
+// rollLocked закрывает прошедшие секунды замера: нагрузка - среднее
+// прошлой оценки и последней секунды, пустые секунды делят её пополам
+func (g *puzzleGuard) rollLocked(now time.Time) {
+	elapsed := now.Sub(g.window)
+	if elapsed < time.Second {
+		return
+	}
+	g.rate = (g.rate + g.count) / 2
+	if quiet := int(elapsed/time.Second) - 1; quiet > 0 {
+		g.rate = math.Ldexp(g.rate, -min(quiet, 64))
+	}
+	g.window, g.count = now, 0
+}
+
+// token выпускает токен задачи сложности difficulty для адреса и Connection ID
+func (g *puzzleGuard) token(addr *net.UDPAddr, connID []byte, difficulty uint8, expiry time.Time) []byte {
+	token := make([]byte, 5, puzzleTokenSize)
+	binary.BigEndian.PutUint32(token, uint32(expiry.Unix()))
+	token[4] = difficulty
+	return append(token, g.mac(token, addr, connID)...)
+}
+
+// mac - подпись срока и сложности токена для адреса и Connection ID
+func (g *puzzleGuard) mac(head []byte, addr *net.UDPAddr, connID []byte) []byte {
+	m := hmac.New(sha256.New, g.key[:])
+	m.Write(head)
+	m.Write(addr.IP.To16())
+	var port [2]byte
+	binary.BigEndian.PutUint16(port[:], uint16(addr.Port))
+	m.Write(port[:])
+	m.Write(connID)
+	return m.Sum(nil)[:puzzleTokenSize-5]
+}
+
+// verify проверяет решение из Client Hello при текущей сложности difficulty
+func (g *puzzleGuard) verify(solution []byte, publicKey [Curve25519KeySize]byte, addr *net.UDPAddr, connID []byte, difficulty uint8, now time.Time) error {
+	if len(solution) != puzzleExtSize {
+		return fmt.Errorf("puzzle solution of %d bytes", len(solution))
+	}
+	token := solution[:puzzleTokenSize]
+	if !hmac.Equal(token[5:], g.mac(token[:5], addr, connID)) {
+		return fmt.Errorf("puzzle token not issued to %s", addr)
+	}
+	if now.Unix() > int64(binary.BigEndian.Uint32(token)) {
+		return fmt.Errorf("puzzle token expired")
+	}
+	if int(token[4])+puzzleBitsPerDoubling < int(difficulty) {
+		return fmt.Errorf("puzzle of %d bits is below current %d", token[4], difficulty)
+	}
+	if !puzzleSolved(token, publicKey, solution[puzzleTokenSize:]) {
+		return fmt.Errorf("puzzle not solved")
+	}
+	return nil
+}
+
+// checkPuzzle требует решение задачи от Client Hello, пока нагрузка
+// хэндшейками выше puzzleRate; без верного решения отвечает RETRY
+// policy - политика inbound-а сокета sock. Вызывается до ECDH
+func (h *Hub) checkPuzzle(sock *dscpMarker, data []byte, connID []byte, remoteAddr *net.UDPAddr, policy *InboundPolicy) error {
+	config := h.getConfig()
+	if config.PuzzleRate == 0 {
+		return nil
+	}
+	now := time.Now()
+	difficulty := puzzleBits(h.puzzle.observe(now), config.PuzzleRate)
+	if difficulty == 0 {
+		return nil
+	}
+
+	hello, err := parseClientHello(data, int(config.ConnectionIdLength))
+	if err != nil {
+		return err
+	}
+	var reason error
+	if hello.Puzzle != nil {
+		if reason = h.puzzle.verify(hello.Puzzle, hello.PublicKey, remoteAddr, connID, difficulty, now); reason == nil {
+			atomic.AddUint64(&h.puzzle.solved, 1)
+			return nil
+		}
+	}
+
+	// RETRY подписан PSK клиента: без известного пользователя не
+	// отвечаем - хэндшейк всё равно не пройдёт
+	_, psk, err := h.helloUser(hello, policy)
+	if err != nil {
+		return err
+	}
+	token := h.puzzle.token(remoteAddr, connID, difficulty, now.Add(puzzleTokenLifetime))
+	if err := h.sendRetry(sock, connID, remoteAddr, token, psk, hello.PublicKey); err != nil {
+		return fmt.Errorf("send retry: %w", err)
+	}
+	atomic.AddUint64(&h.puzzle.issued, 1)
+	h.count(func(c *sideCounters) *uint64 { return &c.puzzles }, 1)
+	if reason != nil {
+		return fmt.Errorf("%d-bit puzzle required: %w", difficulty, reason)
+	}
+	return fmt.Errorf("%d-bit puzzle required", difficulty)
+}
+
+// parseClientHello разбирает payload Client Hello с расширениями
+func parseClientHello(data []byte, connIDLen int) (*HandshakePayload, error) {
+	pkt, err := Unmarshal(data, connIDLen)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal handshake: %w", err)
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("unmarshal handshake payload: %w", err)
+	}
+	hello.splitCapabilities(connIDLen, false, helloCapsKnown)
+	return hello, nil
+}
+
+// sendRetry отвечает на Client Hello RETRY с задачей, подписанным
+// psk от ключа клиента clientKey (sealHandshakeControl)
+func (h *Hub) sendRetry(sock *dscpMarker, connID []byte, remoteAddr *net.UDPAddr, token []byte, psk string, clientKey [Curve25519KeySize]byte) error {
+	config := h.getConfig()
+	payload := make([]byte, 0, 1+len(token)+handshakeControlMACSize)
+	payload = append(payload, controlRetry)
+	payload = append(payload, token...)
+	payload = sealHandshakeControl(payload, psk, clientKey)
+
+	data, err := NewControlPacket(connID, 0, payload).Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal retry: %w", err)
+	}
+	wrapped, err := h.obfs.Wrap(data)
+	if err != nil {
+		return fmt.Errorf("wrap retry: %w", err)
+	}
+	_, err = sock.WriteToUDP(wrapped, remoteAddr, PriorityHigh)
+	return err
+}
+
+// puzzleStats - снимок нагрузки и задач хаба
+func (h *Hub) puzzleStats() PuzzleStats {
+	load := h.puzzle.load(time.Now())
+	return PuzzleStats{
+		HelloRate:           load,
+		Bits:                puzzleBits(load, h.getConfig().PuzzleRate),
+		Issued:              atomic.LoadUint64(&h.puzzle.issued),
+		Solved:              atomic.LoadUint64(&h.puzzle.solved),
+		HandshakeCPUSeconds: time.Duration(atomic.LoadUint64(&h.counters.handshakeNanos)).Seconds(),
+	}
+}
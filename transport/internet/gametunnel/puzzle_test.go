@@ -0,0 +1,177 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPuzzleBits(t *testing.T) {
+	cases := []struct {
+		load      float64
+		threshold uint32
+		want      uint8
+	}{
+		{1000, 0, 0},
+		{50, 100, 0},
+		{100, 100, 0},
+		{150, 100, puzzleMinBits},
+		{200, 100, puzzleMinBits + puzzleBitsPerDoubling},
+		{800, 100, puzzleMinBits + 3*puzzleBitsPerDoubling},
+		{1e9, 100, puzzleMaxBits},
+	}
+	for _, tc := range cases {
+		if got := puzzleBits(tc.load, tc.threshold); got != tc.want {
+			t.Errorf("puzzleBits(%g, %d) = %d, want %d", tc.load, tc.threshold, got, tc.want)
+		}
+	}
+}
+
+func TestPuzzleVerify(t *testing.T) {
+	g := newPuzzleGuard()
+	addr := &net.UDPAddr{IP: net.ParseIP("192.0.2.7"), Port: 4000}
+	connID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	token := g.token(addr, connID, puzzleMinBits, now.Add(puzzleTokenLifetime))
+	solution, err := solvePuzzle(context.Background(), token, keyPair.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(solution) != puzzleExtSize || !bytes.Equal(solution[:puzzleTokenSize], token) {
+		t.Fatalf("solution %x does not carry the token", solution)
+	}
+	if err := g.verify(solution, keyPair.PublicKey, addr, connID, puzzleMinBits, now); err != nil {
+		t.Fatalf("valid solution rejected: %v", err)
+	}
+
+	other := &net.UDPAddr{IP: net.ParseIP("192.0.2.8"), Port: 4000}
+	checks := []struct {
+		name       string
+		addr       *net.UDPAddr
+		connID     []byte
+		difficulty uint8
+		now        time.Time
+	}{
+		{"other address", other, connID, puzzleMinBits, now},
+		{"other connection ID", addr, []byte{8, 7, 6, 5, 4, 3, 2, 1}, puzzleMinBits, now},
+		{"expired", addr, connID, puzzleMinBits, now.Add(2 * puzzleTokenLifetime)},
+		{"below current difficulty", addr, connID, puzzleMinBits + 2*puzzleBitsPerDoubling, now},
+	}
+	for _, c := range checks {
+		if err := g.verify(solution, keyPair.PublicKey, c.addr, c.connID, c.difficulty, c.now); err == nil {
+			t.Errorf("%s: solution accepted", c.name)
+		}
+	}
+	if err := newPuzzleGuard().verify(solution, keyPair.PublicKey, addr, connID, puzzleMinBits, now); err == nil {
+		t.Error("token of another hub accepted")
+	}
+
+	// Сложность сверх предела клиент не решает
+	hard := g.token(addr, connID, puzzleMaxBits+1, now.Add(puzzleTokenLifetime))
+	if _, err := solvePuzzle(context.Background(), hard, keyPair.PublicKey); err == nil {
+		t.Error("client solved a puzzle above puzzleMaxBits")
+	}
+}
+
+func TestPuzzleGuardLoad(t *testing.T) {
+	g := newPuzzleGuard()
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		g.observe(start)
+	}
+	if load := g.load(start); load != 100 {
+		t.Errorf("load within the first second %g, want 100", load)
+	}
+	// Следующая секунда: среднее прошлой оценки и секунды
+	if load := g.load(start.Add(time.Second)); load != 50 {
+		t.Errorf("load after one second %g, want 50", load)
+	}
+	// Тишина делит нагрузку пополам каждую секунду
+	if load := g.load(start.Add(4 * time.Second)); load != 25.0/4 {
+		t.Errorf("load after quiet seconds %g, want %g", load, 25.0/4)
+	}
+}
+
+// loadPuzzleGuard выводит нагрузку хаба к порогу: следующий Client
+// Hello получит задачу
+func loadPuzzleGuard(l *Listener, hellos int) {
+	now := time.Now()
+	for i := 0; i < hellos; i++ {
+		l.hub.puzzle.observe(now)
+	}
+}
+
+func TestHandshakePuzzleLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "puzzle"
+	config.PuzzleRate = 2
+
+	l, accepted := startTestListener(t, config)
+	loadPuzzleGuard(l, 2)
+
+	solvedBefore := atomic.LoadUint64(&metrics.client.puzzles)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	stats := l.hub.GetStats().Puzzle
+	if stats.Issued != 1 || stats.Solved != 1 {
+		t.Errorf("puzzles issued %d solved %d, want 1 and 1", stats.Issued, stats.Solved)
+	}
+	if stats.Bits < puzzleMinBits || stats.HandshakeCPUSeconds <= 0 {
+		t.Errorf("puzzle stats %+v", stats)
+	}
+	if solved := atomic.LoadUint64(&metrics.client.puzzles) - solvedBefore; solved != 1 {
+		t.Errorf("client solved %d puzzles", solved)
+	}
+	if drops := l.hub.GetStats().Drops[dropPuzzle.String()]; drops != 1 {
+		t.Errorf("%d Client Hellos dropped for a puzzle, want 1", drops)
+	}
+
+	request := []byte("player_move: x=1 y=2")
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+		t.Errorf("server got %q, want %q", got, request)
+	}
+}
+
+func TestHandshakePuzzleEarlyData(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "puzzle-early"
+	config.PuzzleRate = 2
+
+	l, accepted := startTestListener(t, config)
+	addr := l.Addr().(*net.UDPAddr)
+	clientConfig := *config
+	clientConfig.EarlyData = true
+	t.Cleanup(func() { dropEarlyTicket(addr, &clientConfig) })
+
+	first, _ := dialTestClient(t, l, &clientConfig, accepted)
+	first.Close()
+
+	// 0-RTT под нагрузкой: задача, повтор Client Hello без ранних
+	// данных, данные - заново на обычных ключах
+	loadPuzzleGuard(l, 4)
+	second, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer second.Close()
+	second.Write([]byte("early"))
+	if got := readWithTimeout(t, server, 5); string(got) != "early" {
+		t.Fatalf("server got %q", got)
+	}
+	waitEarlyDone(t, second)
+	if used, ok := second.EarlyData(); !used || ok {
+		t.Errorf("EarlyData() = %v, %v after a puzzle", used, ok)
+	}
+	if stats := l.hub.GetStats().Puzzle; stats.Solved == 0 {
+		t.Errorf("puzzle stats %+v", stats)
+	}
+}
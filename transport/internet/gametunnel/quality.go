@@ -0,0 +1,342 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Качество канала: RTT, джиттер, потери и оценка 0-100
+// ====================================================================
+//
+// Игроку важно понять, кто виноват в лагах: туннель или провайдер.
+// Каждая сторона меряет канал сама:
+//
+//   - клиент - по ответам на keep-alive, а пока данные идут в обе
+//     стороны и keep-alive не нужен - по Ping на том же таймере;
+//   - сервер - Ping сессиям, от которых с прошлой проверки
+//     deadPeerInterval пришли данные; молчащие сессии проверяет probe
+//     (deadpeer.go).
+//
+//	Ping: CONTROL [0x01][AEAD(номер 8 байт | nonce 8 байт)]
+//	Pong: CONTROL [0x02][AEAD(то же тело)]
+//
+// Запечатаны они, как probe (deadpeer.go), ключом отправки: подделать
+// замер чужой не может, а окно повторов отбрасывает повтор пакета.
+// Отправленные Ping ждут ответа в таблице на maxPendingPings записей:
+// Pong засчитывается, только если номер и nonce совпали с Ping, ещё
+// ждущим ответа, - опоздавший после pingTimeout, повторный или
+// выдуманный Pong в замер не идёт. Время отправки хранит таблица, а
+// не тело. Открытых Ping/Pong без тела обе стороны не принимают и
+// на них не отвечают: иначе знающий Connection ID подделал бы замер
+// или получал бы Pong.
+//
+// srtt и доля потерь - скользящие средние с весом 1/8 (как в
+// clientstats.go). Джиттер - среднее отклонение соседних замеров RTT
+// (RFC 3550, 6.4.1) с тем же весом. Ping без ответа дольше
+// pingTimeout (или вытесненный из полной таблицы) и keep-alive без
+// ответа к следующему - потеря. Последние rttSampleCount замеров
+// хранятся с временем (RTTSamples), наименьший из них - MinRTT.
+//
+// Оценка качества (score) - 100 минус штрафы:
+//
+//	задержка - 1 балл за каждые 4 мс сверх 20 мс в rtt + 2 x jitter
+//	потери   - 4 балла за каждый процент
+//
+// С метками времени (timestamps.go) rtt - больший из srtt и RTT по
+// меткам: раздутый буфер снижает оценку, не дожидаясь Ping.
+//
+// 50 мс и 1% потерь - около 90, 150 мс без потерь - около 70, 10%
+// потерь - не выше 60. Без замеров оценки нет (LinkQuality = nil).
+//
+// ====================================================================
+
+const (
+	// qualityBaseDelay - задержка без штрафа
+	qualityBaseDelay = 20 * time.Millisecond
+
+	// qualityDelayStep - задержка, отнимающая балл
+	qualityDelayStep = 4 * time.Millisecond
+
+	// qualityLossWeight - баллов за всю долю потерь (4 за процент)
+	qualityLossWeight = 400
+
+	// maxPendingPings - Ping, одновременно ждущих ответа
+	maxPendingPings = 4
+
+	// pingTimeout - Ping без ответа дольше считается потерянным
+	pingTimeout = 3 * time.Second
+
+	// pingBodySize - тело Ping: номер и nonce
+	pingBodySize = 16
+
+	// rttSampleCount - замеров RTT в истории
+	rttSampleCount = 16
+)
+
+// LinkQuality - качество канала по замерам одной стороны
+type LinkQuality struct {
+	// RTT - сглаженный RTT, Jitter - его колебания
+	RTT    time.Duration `json:"rtt"`
+	Jitter time.Duration `json:"jitter"`
+
+	// Loss - доля проверок без ответа [0, 1]
+	Loss float64 `json:"loss"`
+
+	// Score - оценка качества 0-100 для игрока
+	Score int `json:"score"`
+
+	// MinRTT - наименьший RTT среди последних замеров: рост RTT над
+	// ним - очередь на пути
+	MinRTT time.Duration `json:"minRtt"`
+
+	// Timestamps - очереди по меткам времени DATA (timestamps.go);
+	// nil - метки не согласованы
+	Timestamps *DelayTrend `json:"timestamps,omitempty"`
+}
+
+// linkQuality - замеры качества канала (atomic)
+type linkQuality struct {
+	// srtt - сглаженный RTT (наносекунды, 0 = замеров ещё не было)
+	// jitter - сглаженное отклонение соседних замеров (наносекунды)
+	// lastRTT - последний замер
+	srtt    int64
+	jitter  int64
+	lastRTT int64
+
+	// loss - сглаженная доля проверок без ответа (из lossScale)
+	loss uint32
+
+	// mu - таблица Ping без ответа и история замеров
+	// seq - номер последнего Ping, samples - кольцо замеров, next -
+	// место следующего
+	mu      sync.Mutex
+	seq     uint64
+	pending [maxPendingPings]pendingPing
+	samples [rttSampleCount]rttSample
+	next    int
+}
+
+// pendingPing - Ping, ждущий ответа (seq 0 - свободная запись)
+type pendingPing struct {
+	seq    uint64
+	nonce  uint64
+	sentAt int64
+}
+
+// rttSample - замер RTT (at 0 - пустая запись)
+type rttSample struct {
+	at  int64
+	rtt int64
+}
+
+// RTTSample - замер RTT с временем ответа
+type RTTSample struct {
+	At  time.Time     `json:"at"`
+	RTT time.Duration `json:"rtt"`
+}
+
+// observeReply учитывает ответ на проверку: замер RTT и
+// отсутствие потери
+func (q *linkQuality) observeReply(rtt time.Duration) {
+	q.mu.Lock()
+	q.samples[q.next] = rttSample{at: time.Now().UnixNano(), rtt: int64(rtt)}
+	q.next = (q.next + 1) % rttSampleCount
+	q.mu.Unlock()
+
+	ewma(&q.srtt, int64(rtt))
+	if last := atomic.SwapInt64(&q.lastRTT, int64(rtt)); last != 0 {
+		delta := int64(rtt) - last
+		if delta < 0 {
+			delta = -delta
+		}
+		ewma(&q.jitter, delta)
+	}
+	q.observeLoss(0)
+}
+
+// ewma сдвигает скользящее среднее *v к sample (0 - замеров не было)
+func ewma(v *int64, sample int64) {
+	for {
+		old := atomic.LoadInt64(v)
+		next := sample
+		if old != 0 {
+			next = old + (sample-old)>>statsEWMAShift
+		}
+		if atomic.CompareAndSwapInt64(v, old, next) {
+			return
+		}
+	}
+}
+
+// observeLoss сдвигает оценку потерь к sample (0 или lossScale)
+func (q *linkQuality) observeLoss(sample uint32) {
+	for {
+		old := atomic.LoadUint32(&q.loss)
+		next := uint32(int64(old) + (int64(sample)-int64(old))>>statsEWMAShift)
+		if atomic.CompareAndSwapUint32(&q.loss, old, next) {
+			return
+		}
+	}
+}
+
+// lossRatio возвращает оценку потерь в диапазоне [0, 1]
+func (q *linkQuality) lossRatio() float64 {
+	ratio := float64(atomic.LoadUint32(&q.loss)) / lossScale
+	// Округление до 0.1% - GUI не нужен шум младших разрядов
+	return math.Round(ratio*1000) / 1000
+}
+
+// pingSent ставит Ping в таблицу ожидания и возвращает его тело
+// Ping, прождавшие дольше pingTimeout, и вытесненный из полной
+// таблицы - потери
+func (q *linkQuality) pingSent(now time.Time) []byte {
+	var nonce [8]byte
+	rand.Read(nonce[:])
+
+	q.mu.Lock()
+	lost := q.expireLocked(now)
+	// Свободная запись или самая старая
+	slot := 0
+	for i, p := range q.pending {
+		if p.seq == 0 {
+			slot = i
+			break
+		}
+		if p.sentAt < q.pending[slot].sentAt {
+			slot = i
+		}
+	}
+	if q.pending[slot].seq != 0 {
+		lost++
+	}
+	q.seq++
+	ping := pendingPing{seq: q.seq, nonce: binary.BigEndian.Uint64(nonce[:]), sentAt: now.UnixNano()}
+	q.pending[slot] = ping
+	q.mu.Unlock()
+
+	for ; lost > 0; lost-- {
+		q.observeLoss(lossScale)
+	}
+	body := binary.BigEndian.AppendUint64(make([]byte, 0, pingBodySize), ping.seq)
+	return binary.BigEndian.AppendUint64(body, ping.nonce)
+}
+
+// expireLocked снимает Ping, прождавшие дольше pingTimeout
+// Возвращает их число; вызывается под mu
+func (q *linkQuality) expireLocked(now time.Time) int {
+	lost := 0
+	deadline := now.Add(-pingTimeout).UnixNano()
+	for i, p := range q.pending {
+		if p.seq != 0 && p.sentAt < deadline {
+			q.pending[i] = pendingPing{}
+			lost++
+		}
+	}
+	return lost
+}
+
+// pongReceived учитывает Pong с телом body; false - не ответ на
+// ждущий Ping (опоздавший, повтор, чужой)
+func (q *linkQuality) pongReceived(body []byte, now time.Time) (time.Duration, bool) {
+	if len(body) != pingBodySize {
+		return 0, false
+	}
+	seq := binary.BigEndian.Uint64(body)
+	nonce := binary.BigEndian.Uint64(body[8:])
+
+	q.mu.Lock()
+	lost := q.expireLocked(now)
+	sentAt := int64(0)
+	for i, p := range q.pending {
+		if seq != 0 && p.seq == seq && p.nonce == nonce {
+			sentAt = p.sentAt
+			q.pending[i] = pendingPing{}
+			break
+		}
+	}
+	q.mu.Unlock()
+
+	for ; lost > 0; lost-- {
+		q.observeLoss(lossScale)
+	}
+	if sentAt == 0 {
+		return 0, false
+	}
+	rtt := now.Sub(time.Unix(0, sentAt))
+	q.observeReply(rtt)
+	return rtt, true
+}
+
+// rttSamples возвращает замеры RTT от старых к новым
+func (q *linkQuality) rttSamples() []RTTSample {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	samples := make([]RTTSample, 0, rttSampleCount)
+	for i := 0; i < rttSampleCount; i++ {
+		s := q.samples[(q.next+i)%rttSampleCount]
+		if s.at != 0 {
+			samples = append(samples, RTTSample{At: time.Unix(0, s.at), RTT: time.Duration(s.rtt)})
+		}
+	}
+	return samples
+}
+
+// minRTT - наименьший RTT среди хранимых замеров (0 - замеров нет)
+func (q *linkQuality) minRTT() time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	least := int64(0)
+	for _, s := range q.samples {
+		if s.at != 0 && (least == 0 || s.rtt < least) {
+			least = s.rtt
+		}
+	}
+	return time.Duration(least)
+}
+
+// snapshot возвращает замеры с оценкой (nil - замеров ещё не было)
+// trend - метки времени сессии (timestamps.go): RTT по ним ловит рост
+// очереди раньше Ping и заменяет srtt в штрафе, если он больше
+func (q *linkQuality) snapshot(trend *delayTrend) *LinkQuality {
+	rtt := time.Duration(atomic.LoadInt64(&q.srtt))
+	if rtt == 0 {
+		return nil
+	}
+	quality := &LinkQuality{
+		RTT:        rtt,
+		Jitter:     time.Duration(atomic.LoadInt64(&q.jitter)),
+		Loss:       q.lossRatio(),
+		MinRTT:     q.minRTT(),
+		Timestamps: trend.snapshot(),
+	}
+	if quality.Timestamps != nil {
+		rtt = max(rtt, quality.Timestamps.RTT)
+	}
+	quality.Score = qualityScore(rtt, quality.Jitter, quality.Loss)
+	return quality
+}
+
+// RTTSamples - последние замеры RTT сессии, от старых к новым
+func (s *Session) RTTSamples() []RTTSample {
+	return s.quality.rttSamples()
+}
+
+// RTTSamples - последние замеры RTT соединения, от старых к новым
+func (c *GameTunnelClientConn) RTTSamples() []RTTSample {
+	return c.traffic.rttSamples()
+}
+
+// qualityScore - оценка качества канала 0-100
+func qualityScore(rtt, jitter time.Duration, loss float64) int {
+	score := 100.0
+	if delay := rtt + 2*jitter; delay > qualityBaseDelay {
+		score -= float64(delay-qualityBaseDelay) / float64(qualityDelayStep)
+	}
+	score -= loss * qualityLossWeight
+	return int(math.Round(math.Max(0, math.Min(100, score))))
+}
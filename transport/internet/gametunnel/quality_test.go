@@ -0,0 +1,170 @@
+package gametunnel
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQualityScore(t *testing.T) {
+	tests := []struct {
+		rtt, jitter time.Duration
+		loss        float64
+		want        int
+	}{
+		{10 * time.Millisecond, 0, 0, 100},
+		{50 * time.Millisecond, 0, 0.01, 89},
+		{150 * time.Millisecond, 0, 0, 68},
+		{40 * time.Millisecond, 10 * time.Millisecond, 0, 90},
+		{20 * time.Millisecond, 0, 0.1, 60},
+		{time.Second, 0, 0, 0},
+		{20 * time.Millisecond, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		if got := qualityScore(tt.rtt, tt.jitter, tt.loss); got != tt.want {
+			t.Errorf("qualityScore(%v, %v, %v) = %d, want %d", tt.rtt, tt.jitter, tt.loss, got, tt.want)
+		}
+	}
+}
+
+func TestLinkQualityJitter(t *testing.T) {
+	var q linkQuality
+	if q.snapshot(nil) != nil {
+		t.Fatal("quality reported without samples")
+	}
+
+	// Ровный RTT - джиттера нет
+	for i := 0; i < 50; i++ {
+		q.observeReply(30 * time.Millisecond)
+	}
+	if s := q.snapshot(nil); s.Jitter != 0 || s.RTT != 30*time.Millisecond || s.Score != 98 {
+		t.Errorf("steady link: %+v", s)
+	}
+
+	// RTT скачет 20/40 мс - джиттер сходится к 20 мс
+	for i := 0; i < 200; i++ {
+		q.observeReply(time.Duration(20+20*(i%2)) * time.Millisecond)
+	}
+	if s := q.snapshot(nil); s.Jitter < 18*time.Millisecond || s.Jitter > 22*time.Millisecond {
+		t.Errorf("jitter = %v, want ~20ms", s.Jitter)
+	}
+}
+
+func TestLinkQualityPing(t *testing.T) {
+	var q linkQuality
+	now := time.Now()
+	if _, ok := q.pongReceived(make([]byte, 8), now); ok {
+		t.Error("pong without ping measured")
+	}
+
+	// Несколько Ping ждут ответа одновременно; ответ сверяется с
+	// номером и nonce
+	first := q.pingSent(now)
+	body := q.pingSent(now.Add(time.Second))
+	forged := append([]byte(nil), body...)
+	forged[len(forged)-1] ^= 1
+	if _, ok := q.pongReceived(forged, now.Add(time.Second+time.Millisecond)); ok {
+		t.Error("pong with a wrong nonce measured")
+	}
+	rtt, ok := q.pongReceived(body, now.Add(time.Second+25*time.Millisecond))
+	if !ok || rtt != 25*time.Millisecond {
+		t.Errorf("rtt = %v, %t", rtt, ok)
+	}
+	if _, ok := q.pongReceived(body, now.Add(time.Second+30*time.Millisecond)); ok {
+		t.Error("duplicate pong measured")
+	}
+	if s := q.snapshot(nil); s.Loss != 0 {
+		t.Errorf("pending ping counted as lost: %+v", s)
+	}
+
+	// Ответ после pingTimeout - потеря, в замер не идёт
+	if _, ok := q.pongReceived(first, now.Add(pingTimeout+time.Millisecond)); ok {
+		t.Error("late pong measured")
+	}
+	if s := q.snapshot(nil); s.Loss == 0 || s.RTT != 25*time.Millisecond || s.MinRTT != 25*time.Millisecond {
+		t.Errorf("after a lost ping: %+v", s)
+	}
+
+	// Переполнение таблицы вытесняет самый старый Ping
+	later := now.Add(10 * time.Second)
+	oldest := q.pingSent(later)
+	for i := 1; i <= maxPendingPings; i++ {
+		q.pingSent(later.Add(time.Duration(i) * time.Millisecond))
+	}
+	if _, ok := q.pongReceived(oldest, later.Add(time.Second)); ok {
+		t.Error("evicted ping measured")
+	}
+}
+
+func TestRTTSamples(t *testing.T) {
+	var q linkQuality
+	if len(q.rttSamples()) != 0 {
+		t.Fatal("samples without replies")
+	}
+	for i := 1; i <= rttSampleCount+3; i++ {
+		q.observeReply(time.Duration(i) * time.Millisecond)
+	}
+	samples := q.rttSamples()
+	if len(samples) != rttSampleCount {
+		t.Fatalf("%d samples, want %d", len(samples), rttSampleCount)
+	}
+	if samples[0].RTT != 4*time.Millisecond || samples[len(samples)-1].RTT != (rttSampleCount+3)*time.Millisecond {
+		t.Errorf("samples %v .. %v", samples[0].RTT, samples[len(samples)-1].RTT)
+	}
+	if q.minRTT() != 4*time.Millisecond {
+		t.Errorf("minRTT = %v", q.minRTT())
+	}
+}
+
+func TestSessionQuality(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "quality"
+	l, accepted := startTestListener(t, config)
+	client, _ := dialTestClient(t, l, config, accepted)
+
+	session := l.hub.sessions.get(client.session().ConnectionID)
+	if session == nil {
+		t.Fatal("no server session")
+	}
+	if err := l.hub.sendPing(session, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	client.sendPing(time.Now())
+
+	deadline := time.Now().Add(2 * time.Second)
+	for (session.GetStats().Quality == nil || client.GetStats().Quality == nil) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if q := session.GetStats().Quality; q == nil || q.Score < 90 {
+		t.Errorf("server quality over loopback: %+v", q)
+	}
+	if q := client.GetStats().Quality; q == nil || q.Score < 90 {
+		t.Errorf("client quality over loopback: %+v", q)
+	}
+	if len(session.RTTSamples()) == 0 || len(client.RTTSamples()) == 0 {
+		t.Error("no RTT samples recorded")
+	}
+}
+
+func TestUnsealedPingIgnored(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "quality"
+	l, accepted := startTestListener(t, config)
+	client, _ := dialTestClient(t, l, config, accepted)
+
+	// Открытый Ping по известному Connection ID - без ответа
+	id := client.session().ConnectionID
+	data, err := NewControlPacket(id, 1<<20, []byte{0x01}).Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, _ := l.hub.obfs.Wrap(data)
+	before := atomic.LoadUint32(&l.hub.sessions.get(id).SendPacketNum)
+	if _, _, err := l.hub.RoutePacket(wrapped, client.LocalAddr().(*net.UDPAddr)); err == nil {
+		t.Error("unsealed Ping accepted")
+	}
+	if after := atomic.LoadUint32(&l.hub.sessions.get(id).SendPacketNum); after != before {
+		t.Errorf("server answered an unsealed Ping: packet number %d -> %d", before, after)
+	}
+}
@@ -0,0 +1,168 @@
+package gametunnel
+
+import (
+	"time"
+)
+
+// ====================================================================
+// Статистика очереди по классам и гистограмма времени ожидания
+// ====================================================================
+//
+// Общие счётчики enqueued не отвечают на главный вопрос - уходят
+// ли игровые пакеты быстрее остальных. Поэтому для каждого класса
+// очередь считает:
+//   - поставлено / извлечено / отброшено
+//   - гистограмму времени в очереди (от Enqueue до Dequeue)
+//
+// Статистика доступна через PriorityQueue.GetStats, у сессии -
+// в SessionStats.Queue, на хабе - через Hub.GetSessionStats.
+//
+// ====================================================================
+
+// QueueWaitBuckets - верхние границы корзин гистограммы ожидания
+// Последняя корзина гистограммы - всё, что дольше последней границы
+var QueueWaitBuckets = []time.Duration{
+	1 * time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// QueueWaitHistogram - распределение времени ожидания в очереди
+type QueueWaitHistogram struct {
+	// Counts - пакетов в каждой корзине QueueWaitBuckets
+	// (len(QueueWaitBuckets)+1, последняя - переполнение)
+	Counts []uint64 `json:"counts"`
+
+	// Count - всего замеров
+	Count uint64 `json:"count"`
+
+	// Sum - суммарное время ожидания
+	Sum time.Duration `json:"sum"`
+
+	// Max - наибольшее время ожидания
+	Max time.Duration `json:"max"`
+}
+
+// newQueueWaitHistogram создаёт пустую гистограмму
+func newQueueWaitHistogram() QueueWaitHistogram {
+	return QueueWaitHistogram{
+		Counts: make([]uint64, len(QueueWaitBuckets)+1),
+	}
+}
+
+// observe учитывает одно время ожидания
+func (h *QueueWaitHistogram) observe(wait time.Duration) {
+	i := 0
+	for i < len(QueueWaitBuckets) && wait > QueueWaitBuckets[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Count++
+	h.Sum += wait
+	if wait > h.Max {
+		h.Max = wait
+	}
+}
+
+// clone возвращает независимую копию (для снимков статистики)
+func (h *QueueWaitHistogram) clone() QueueWaitHistogram {
+	c := *h
+	c.Counts = append([]uint64(nil), h.Counts...)
+	return c
+}
+
+// Mean возвращает среднее время ожидания
+func (h *QueueWaitHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile возвращает верхнюю границу корзины, в которую попадает
+// квантиль q (0..1). Для корзины переполнения возвращает Max
+func (h *QueueWaitHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q * float64(h.Count))
+	if rank >= h.Count {
+		rank = h.Count - 1
+	}
+
+	var seen uint64
+	for i, n := range h.Counts {
+		seen += n
+		if seen > rank {
+			if i < len(QueueWaitBuckets) {
+				return QueueWaitBuckets[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+// priorityClassCounters - счётчики одного класса внутри PriorityQueue
+type priorityClassCounters struct {
+	dequeued uint64
+	dropped  uint64
+	wait     QueueWaitHistogram
+}
+
+// PriorityClassStats - статистика одного класса приоритета
+type PriorityClassStats struct {
+	Queued   int                `json:"queued"`
+	Enqueued uint64             `json:"enqueued"`
+	Dequeued uint64             `json:"dequeued"`
+	Dropped  uint64             `json:"dropped"`
+	Wait     QueueWaitHistogram `json:"wait"`
+}
+
+// recordDequeueLocked учитывает извлечённый пакет
+// Вызывается под mu.Lock
+func (pq *PriorityQueue) recordDequeueLocked(pkt *PriorityPacket) {
+	if pkt == nil {
+		return
+	}
+	class := &pq.classes[pkt.Priority]
+	class.dequeued++
+	class.wait.observe(time.Since(pkt.EnqueuedAt))
+}
+
+// recordDropLocked учитывает отброшенный пакет класса
+// Вызывается под mu.Lock
+func (pq *PriorityQueue) recordDropLocked(level PriorityLevel) {
+	pq.classes[level].dropped++
+}
+
+// classStatsLocked собирает снимок статистики классов
+// Вызывается под mu.Lock
+func (pq *PriorityQueue) classStatsLocked() [PriorityLevels]PriorityClassStats {
+	enqueued := [PriorityLevels]uint64{
+		PriorityHigh:   pq.enqueuedHigh,
+		PriorityMedium: pq.enqueuedMedium,
+		PriorityLow:    pq.enqueuedLow,
+	}
+
+	var stats [PriorityLevels]PriorityClassStats
+	for level := range stats {
+		class := &pq.classes[level]
+		stats[level] = PriorityClassStats{
+			Queued:   pq.queues[level].Len(),
+			Enqueued: enqueued[level],
+			Dequeued: class.dequeued,
+			Dropped:  class.dropped,
+			Wait:     class.wait.clone(),
+		}
+	}
+	return stats
+}
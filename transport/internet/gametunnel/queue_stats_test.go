@@ -0,0 +1,95 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueueWaitHistogram(t *testing.T) {
+	h := newQueueWaitHistogram()
+
+	for i := 0; i < 90; i++ {
+		h.observe(500 * time.Microsecond)
+	}
+	for i := 0; i < 10; i++ {
+		h.observe(30 * time.Millisecond)
+	}
+
+	if h.Count != 100 {
+		t.Fatalf("Count: got %d, want 100", h.Count)
+	}
+	if h.Counts[0] != 90 {
+		t.Errorf("≤1ms bucket: got %d, want 90", h.Counts[0])
+	}
+	if got := h.Quantile(0.5); got != time.Millisecond {
+		t.Errorf("p50: got %v, want 1ms", got)
+	}
+	if got := h.Quantile(0.95); got != 50*time.Millisecond {
+		t.Errorf("p95: got %v, want 50ms", got)
+	}
+	if h.Max != 30*time.Millisecond {
+		t.Errorf("Max: got %v, want 30ms", h.Max)
+	}
+
+	// Переполнение: квантиль - фактический максимум
+	h.observe(3 * time.Second)
+	if got := h.Quantile(1); got != 3*time.Second {
+		t.Errorf("p100: got %v, want 3s", got)
+	}
+}
+
+func TestPriorityQueueClassStats(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+
+	pq.EnqueueWithPriority([]byte("h1"), PriorityHigh, nil)
+	pq.EnqueueWithPriority([]byte("h2"), PriorityHigh, nil)
+	pq.EnqueueWithPriority([]byte("l1"), PriorityLow, nil)
+	for i := 0; i < MediumQueueSize+1; i++ {
+		pq.EnqueueWithPriority([]byte("m"), PriorityMedium, nil)
+	}
+
+	for i := 0; i < 3; i++ {
+		pq.Dequeue()
+	}
+
+	stats := pq.GetStats()
+	high := stats.Classes[PriorityHigh]
+	if high.Enqueued != 2 || high.Dequeued != 2 || high.Queued != 0 {
+		t.Errorf("High stats: %+v", high)
+	}
+	if high.Wait.Count != 2 {
+		t.Errorf("High wait histogram: got %d samples, want 2", high.Wait.Count)
+	}
+
+	medium := stats.Classes[PriorityMedium]
+	if medium.Dropped != 1 || medium.Dequeued != 1 {
+		t.Errorf("Medium stats: %+v", medium)
+	}
+
+	low := stats.Classes[PriorityLow]
+	if low.Dequeued != 0 || low.Queued != 1 {
+		t.Errorf("Low stats: %+v", low)
+	}
+
+	// Снимок не должен меняться вместе с очередью
+	pq.Dequeue()
+	if stats.Classes[PriorityMedium].Wait.Count != 1 {
+		t.Error("Stats snapshot must not alias live histogram")
+	}
+}
+
+func TestHubSessionStats(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+
+	s := newQueuedSession(1)
+	h.sessions.put(s)
+	s.queue.EnqueueWithPriority([]byte("a"), PriorityHigh, s)
+
+	stats := h.GetSessionStats()
+	if len(stats) != 1 {
+		t.Fatalf("Expected 1 session, got %d", len(stats))
+	}
+	if stats[0].Queue.Classes[PriorityHigh].Enqueued != 1 {
+		t.Errorf("Session queue stats not exported: %+v", stats[0].Queue)
+	}
+}
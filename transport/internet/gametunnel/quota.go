@@ -0,0 +1,416 @@
+package gametunnel
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Квоты трафика и учёт для биллинга
+// ====================================================================
+//
+// С users (users.go) пользователя определяет хэндшейк: у каждого
+// свой ключ, и сессия привязывается к нему до передачи в xray. Без
+// users хэндшейк аутентифицирует только общий PSK, пользователя
+// знает протокол поверх (email клиента VLESS), и сессия
+// привязывается к нему явно: Hub.SetSessionUser или
+// GameTunnelConn.SetUser. Переподключение тогда даёт сессию без
+// пользователя с новой квотой - квоту, которую клиент не должен
+// обойти, даёт только users.
+//
+// Учёт ведётся по пользователю: все его сессии расходуют одну
+// квоту (отправленные + принятые байты открытого текста). Сессия
+// без пользователя расходует собственную квоту quotaBytes.
+//
+// Квота пользователя - SetUserQuota, по умолчанию quotaBytes
+// (0 = без квоты, только учёт). При исчерпании (quotaAction):
+//   - throttle - лимит скорости сессий снижается до
+//                quotaThrottleRate, соединения живут
+//   - close    - сессии получают CLOSE(QuotaExceeded) и удаляются,
+//                новые данные отклоняются с ErrQuotaExceeded
+//
+// Для панелей (3x-ui и т.п.) хаб раз в quotaFlushInterval передаёт
+// в UsageCallback приращения трафика пользователей с прошлого
+// сброса; последний сброс - при Stop. Новый расчётный период -
+// ResetUserUsage.
+//
+// ====================================================================
+
+// ErrQuotaExceeded - квота трафика пользователя исчерпана
+var ErrQuotaExceeded = errors.New("gametunnel: traffic quota exceeded")
+
+// QuotaAction - реакция на исчерпание квоты
+type QuotaAction int32
+
+const (
+	// QuotaAction_THROTTLE - снизить скорость до quotaThrottleRate
+	QuotaAction_THROTTLE QuotaAction = 0
+
+	// QuotaAction_CLOSE - закрыть сессии с CLOSE(QuotaExceeded)
+	QuotaAction_CLOSE QuotaAction = 1
+)
+
+// QuotaActionFromString парсит строковое значение реакции на квоту
+func QuotaActionFromString(s string) QuotaAction {
+	switch s {
+	case "close", "CLOSE":
+		return QuotaAction_CLOSE
+	default:
+		return QuotaAction_THROTTLE
+	}
+}
+
+const (
+	// defaultQuotaThrottleRate - скорость после исчерпания квоты
+	// (байт/сек), если quotaThrottleRate не задан
+	defaultQuotaThrottleRate = 32 * 1024
+
+	// defaultUsageFlushInterval - период сброса учёта по умолчанию
+	defaultUsageFlushInterval = 60 * time.Second
+)
+
+// UsageReport - трафик пользователя
+// В UsageCallback Sent/Recv - приращение с прошлого сброса,
+// в GetUsage - всего за расчётный период
+type UsageReport struct {
+	User      string `json:"user"`
+	Sent      uint64 `json:"sent"`
+	Recv      uint64 `json:"recv"`
+	Quota     uint64 `json:"quota"`
+	Exhausted bool   `json:"exhausted"`
+}
+
+// UsageCallback получает учёт трафика пользователей
+type UsageCallback func(reports []UsageReport)
+
+// userUsage - счётчики трафика одного пользователя
+type userUsage struct {
+	user string
+
+	// quota - квота в байтах (0 = без квоты, atomic)
+	// quotaSet - квота задана SetUserQuota, а не конфигом
+	quota    uint64
+	quotaSet bool
+
+	sent uint64
+	recv uint64
+
+	// flushedSent / flushedRecv - значения на момент прошлого сброса
+	flushedSent uint64
+	flushedRecv uint64
+
+	exhausted int32
+}
+
+// used - израсходовано байт
+func (u *userUsage) used() uint64 {
+	return atomic.LoadUint64(&u.sent) + atomic.LoadUint64(&u.recv)
+}
+
+// charge учитывает трафик; true - квота исчерпана
+func (u *userUsage) charge(sent, recv uint64) bool {
+	if sent > 0 {
+		atomic.AddUint64(&u.sent, sent)
+	}
+	if recv > 0 {
+		atomic.AddUint64(&u.recv, recv)
+	}
+	if atomic.LoadInt32(&u.exhausted) == 1 {
+		return true
+	}
+	quota := atomic.LoadUint64(&u.quota)
+	if quota == 0 || u.used() < quota {
+		return false
+	}
+	atomic.StoreInt32(&u.exhausted, 1)
+	return true
+}
+
+// report снимает полный учёт пользователя
+func (u *userUsage) report() UsageReport {
+	return UsageReport{
+		User:      u.user,
+		Sent:      atomic.LoadUint64(&u.sent),
+		Recv:      atomic.LoadUint64(&u.recv),
+		Quota:     atomic.LoadUint64(&u.quota),
+		Exhausted: atomic.LoadInt32(&u.exhausted) == 1,
+	}
+}
+
+// quotaTracker - учёт трафика пользователей хаба
+type quotaTracker struct {
+	users map[string]*userUsage
+	mu    sync.Mutex
+}
+
+func newQuotaTracker() *quotaTracker {
+	return &quotaTracker{users: make(map[string]*userUsage)}
+}
+
+// user возвращает учёт пользователя, создавая его с квотой defaultQuota
+func (q *quotaTracker) user(name string, defaultQuota uint64) *userUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.users[name]
+	if u == nil {
+		u = &userUsage{user: name, quota: defaultQuota}
+		q.users[name] = u
+	}
+	return u
+}
+
+// setQuota задаёт квоту пользователя
+func (q *quotaTracker) setQuota(name string, quota uint64) *userUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.users[name]
+	if u == nil {
+		u = &userUsage{user: name}
+		q.users[name] = u
+	}
+	u.quotaSet = true
+	atomic.StoreUint64(&u.quota, quota)
+	u.recheck()
+	return u
+}
+
+// setDefaultQuota обновляет квоту пользователей без SetUserQuota
+func (q *quotaTracker) setDefaultQuota(quota uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, u := range q.users {
+		if !u.quotaSet {
+			atomic.StoreUint64(&u.quota, quota)
+			u.recheck()
+		}
+	}
+}
+
+// recheck снимает флаг исчерпания, если квота больше не превышена
+func (u *userUsage) recheck() {
+	quota := atomic.LoadUint64(&u.quota)
+	if quota == 0 || u.used() < quota {
+		atomic.StoreInt32(&u.exhausted, 0)
+	}
+}
+
+// reset обнуляет счётчики пользователя (новый расчётный период)
+func (q *quotaTracker) reset(name string) *userUsage {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	u := q.users[name]
+	if u == nil {
+		return nil
+	}
+	atomic.StoreUint64(&u.sent, 0)
+	atomic.StoreUint64(&u.recv, 0)
+	u.flushedSent, u.flushedRecv = 0, 0
+	atomic.StoreInt32(&u.exhausted, 0)
+	return u
+}
+
+// all возвращает полный учёт всех пользователей по имени
+func (q *quotaTracker) all() []UsageReport {
+	q.mu.Lock()
+	reports := make([]UsageReport, 0, len(q.users))
+	for _, u := range q.users {
+		reports = append(reports, u.report())
+	}
+	q.mu.Unlock()
+
+	sort.Slice(reports, func(i, j int) bool { return reports[i].User < reports[j].User })
+	return reports
+}
+
+// flush возвращает приращения трафика с прошлого сброса
+func (q *quotaTracker) flush() []UsageReport {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var reports []UsageReport
+	for _, u := range q.users {
+		r := u.report()
+		deltaSent := r.Sent - u.flushedSent
+		deltaRecv := r.Recv - u.flushedRecv
+		if deltaSent == 0 && deltaRecv == 0 {
+			continue
+		}
+		u.flushedSent, u.flushedRecv = r.Sent, r.Recv
+		r.Sent, r.Recv = deltaSent, deltaRecv
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// SetSessionUser привязывает сессию к пользователю: дальше её
+// трафик учитывается и ограничивается квотой пользователя.
+// Уже израсходованное сессией переносится на пользователя.
+// Пользователя, определённого хэндшейком (users.go), сменить нельзя
+func (h *Hub) SetSessionUser(connID []byte, user string) error {
+	session := h.GetSession(connID)
+	if session == nil {
+		return fmt.Errorf("unknown connection ID: %x", connID)
+	}
+	if atomic.LoadInt32(&session.userBound) == 1 {
+		session.mu.RLock()
+		bound := session.User
+		session.mu.RUnlock()
+		if bound != user {
+			return fmt.Errorf("session bound to user %q by handshake", bound)
+		}
+		return nil
+	}
+	if !session.policy.allowsUser(user) {
+		return fmt.Errorf("user %q not allowed on inbound %q", user, session.InboundTag)
+	}
+	h.assignUser(session, user)
+	return nil
+}
+
+// assignUser переносит учёт сессии на пользователя user
+func (h *Hub) assignUser(session *Session, user string) {
+	usage := h.quotas.user(user, h.getConfig().QuotaBytes)
+
+	session.mu.Lock()
+	prev := session.usage
+	session.User = user
+	session.usage = usage
+	session.mu.Unlock()
+
+	if prev != nil && prev != usage && prev.user == "" {
+		usage.charge(atomic.LoadUint64(&prev.sent), atomic.LoadUint64(&prev.recv))
+	}
+	if atomic.LoadInt32(&usage.exhausted) == 1 {
+		h.applyQuotaAction(session)
+	}
+}
+
+// SetUserQuota задаёт квоту пользователя в байтах (0 = без квоты)
+// Если квота больше израсходованного, ограничение снимается
+func (h *Hub) SetUserQuota(user string, quota uint64) {
+	u := h.quotas.setQuota(user, quota)
+	if atomic.LoadInt32(&u.exhausted) == 0 {
+		h.liftThrottle(u)
+	}
+}
+
+// ResetUserUsage обнуляет учёт пользователя (новый расчётный период)
+// и снимает ограничение скорости с его сессий
+func (h *Hub) ResetUserUsage(user string) bool {
+	u := h.quotas.reset(user)
+	if u == nil {
+		return false
+	}
+	h.liftThrottle(u)
+	return true
+}
+
+// GetUsage возвращает учёт трафика всех пользователей
+func (h *Hub) GetUsage() []UsageReport {
+	return h.quotas.all()
+}
+
+// SetUsageCallback задаёт получателя периодического учёта трафика
+// Безопасно вызывать на работающем Hub
+func (h *Hub) SetUsageCallback(cb UsageCallback) {
+	h.usageCallback.Store(&cb)
+}
+
+// flushUsage передаёт приращения трафика в UsageCallback
+func (h *Hub) flushUsage() {
+	cb := h.usageCallback.Load()
+	if cb == nil || *cb == nil {
+		return
+	}
+	if reports := h.quotas.flush(); len(reports) > 0 {
+		(*cb)(reports)
+	}
+}
+
+// usageLoop периодически сбрасывает учёт трафика
+func (h *Hub) usageLoop() {
+	interval := time.Duration(h.getConfig().QuotaFlushInterval) * time.Second
+	if interval == 0 {
+		interval = defaultUsageFlushInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h.flushUsage()
+	}
+}
+
+// chargeQuota учитывает трафик сессии
+// Возвращает ErrQuotaExceeded, если квота исчерпана и quotaAction=close
+func (h *Hub) chargeQuota(session *Session, sent, recv uint64) error {
+	session.mu.RLock()
+	usage, user := session.usage, session.User
+	session.mu.RUnlock()
+
+	// Счётчики stats xray (xraystats.go): uplink сервера - принятое
+	h.xstats.add(user, recv, sent)
+
+	if usage == nil || !usage.charge(sent, recv) {
+		return nil
+	}
+	return h.applyQuotaAction(session)
+}
+
+// applyQuotaAction применяет реакцию на исчерпание квоты к сессии
+func (h *Hub) applyQuotaAction(session *Session) error {
+	config := h.getConfig()
+	if config.QuotaAction == QuotaAction_CLOSE {
+		if atomic.CompareAndSwapInt32(&session.quotaHit, 0, 1) {
+			h.sendClose(session, CloseReason_QUOTA_EXCEEDED)
+			h.closeSession(session.ID, CloseReason_QUOTA_EXCEEDED)
+		}
+		return ErrQuotaExceeded
+	}
+
+	if atomic.CompareAndSwapInt32(&session.quotaHit, 0, 1) {
+		rate := config.QuotaThrottleRate
+		if rate == 0 {
+			rate = defaultQuotaThrottleRate
+		}
+		session.mu.Lock()
+		session.unthrottled = session.limiter
+		session.unthrottledPinned = session.limiterPinned
+		session.limiter = newTokenBucket(rate, 0)
+		session.limiterPinned = true
+		session.mu.Unlock()
+	}
+	return nil
+}
+
+// liftThrottle возвращает сессиям пользователя прежний лимит
+func (h *Hub) liftThrottle(u *userUsage) {
+	for _, session := range h.sessions.snapshot() {
+		session.mu.Lock()
+		if session.usage == u && atomic.CompareAndSwapInt32(&session.quotaHit, 1, 0) {
+			session.limiter = session.unthrottled
+			session.limiterPinned = session.unthrottledPinned
+			session.unthrottled = nil
+		}
+		session.mu.Unlock()
+	}
+}
+
+// SetUser привязывает соединение к пользователю для учёта и квот
+func (c *GameTunnelConn) SetUser(user string) error {
+	return c.hub.SetSessionUser(c.session.ID, user)
+}
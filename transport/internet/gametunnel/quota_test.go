@@ -0,0 +1,166 @@
+package gametunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newQuotaHub создаёт хаб с одной сессией без сокета
+func newQuotaHub(config *Config) (*Hub, *Session) {
+	h := NewHub(config, nil)
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9}
+	session := h.newSession([]byte{1, 2, 3, 4, 5, 6, 7, 8}, addr, nil)
+	h.sessions.put(session)
+	return h, session
+}
+
+func TestQuotaThrottle(t *testing.T) {
+	config := DefaultConfig()
+	config.QuotaThrottleRate = 1000
+	h, session := newQuotaHub(config)
+
+	h.SetUserQuota("alice", 100)
+	if err := h.SetSessionUser(session.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := h.chargeQuota(session, 60, 0); err != nil {
+		t.Fatalf("Under quota: %v", err)
+	}
+	if session.limiter != nil {
+		t.Fatal("Throttled before quota exhausted")
+	}
+	if err := h.chargeQuota(session, 0, 50); err != nil {
+		t.Fatalf("Throttle action should not fail: %v", err)
+	}
+	if session.limiter == nil || session.limiter.rate != 1000 {
+		t.Fatalf("Session not throttled: %+v", session.limiter)
+	}
+
+	usage := h.GetUsage()
+	if len(usage) != 1 || usage[0].Sent != 60 || usage[0].Recv != 50 || !usage[0].Exhausted {
+		t.Fatalf("GetUsage: %+v", usage)
+	}
+
+	// Новый расчётный период снимает ограничение
+	if !h.ResetUserUsage("alice") {
+		t.Fatal("ResetUserUsage: unknown user")
+	}
+	if session.limiter != nil {
+		t.Errorf("Throttle not lifted: %+v", session.limiter)
+	}
+}
+
+func TestQuotaClose(t *testing.T) {
+	config := DefaultConfig()
+	config.QuotaBytes = 10
+	config.QuotaAction = QuotaAction_CLOSE
+	h, session := newQuotaHub(config)
+
+	// Сессия без пользователя расходует собственную квоту
+	if err := h.chargeQuota(session, 16, 0); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Over quota: got %v, want ErrQuotaExceeded", err)
+	}
+	if h.GetSession(session.ID) != nil {
+		t.Error("Session over quota not removed")
+	}
+}
+
+func TestSetSessionUserCarriesUsage(t *testing.T) {
+	h, session := newQuotaHub(DefaultConfig())
+
+	h.chargeQuota(session, 30, 20)
+	h.SetSessionUser(session.ID, "bob")
+	h.chargeQuota(session, 5, 0)
+
+	usage := h.GetUsage()
+	if len(usage) != 1 || usage[0].User != "bob" || usage[0].Sent != 35 || usage[0].Recv != 20 {
+		t.Fatalf("GetUsage: %+v", usage)
+	}
+}
+
+func TestUsageFlushReportsDeltas(t *testing.T) {
+	h, session := newQuotaHub(DefaultConfig())
+	h.SetSessionUser(session.ID, "carol")
+
+	var got [][]UsageReport
+	h.SetUsageCallback(func(reports []UsageReport) {
+		got = append(got, reports)
+	})
+
+	h.chargeQuota(session, 100, 10)
+	h.flushUsage()
+	h.flushUsage() // без нового трафика отчёта нет
+	h.chargeQuota(session, 1, 0)
+	h.flushUsage()
+
+	if len(got) != 2 {
+		t.Fatalf("Flushes: got %d, want 2", len(got))
+	}
+	if r := got[0][0]; r.User != "carol" || r.Sent != 100 || r.Recv != 10 {
+		t.Errorf("First flush: %+v", r)
+	}
+	if r := got[1][0]; r.Sent != 1 || r.Recv != 0 {
+		t.Errorf("Second flush: %+v", r)
+	}
+}
+
+func TestQuotaLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "quota"
+	config.QuotaAction = QuotaAction_CLOSE
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	server.(*GameTunnelConn).SetUser("dave")
+	l.hub.SetUserQuota("dave", 8)
+
+	if _, err := server.Write([]byte("0123456789")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("server Write over quota: got %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.CloseReason() != CloseReason_QUOTA_EXCEEDED {
+		if time.Now().After(deadline) {
+			t.Fatal("client did not receive QuotaExceeded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestManagementUsage(t *testing.T) {
+	h, session := newQuotaHub(DefaultConfig())
+	h.SetSessionUser(session.ID, "erin")
+	h.chargeQuota(session, 7, 3)
+	api := NewManagementHandler(h, "")
+
+	rec := apiRequest(t, api, "GET", "/usage", "")
+	var usage []UsageReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil || len(usage) != 1 || usage[0].Sent != 7 {
+		t.Fatalf("GET /usage: %d %s", rec.Code, rec.Body)
+	}
+
+	req := httptest.NewRequest("PUT", "/users/erin/quota", bytes.NewBufferString(`{"bytes": 1000}`))
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent || h.GetUsage()[0].Quota != 1000 {
+		t.Fatalf("PUT quota: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := apiRequest(t, api, "DELETE", "/users/erin/usage", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE usage: %d", rec.Code)
+	}
+	if u := h.GetUsage()[0]; u.Sent != 0 || u.Recv != 0 {
+		t.Errorf("Usage not reset: %+v", u)
+	}
+	if rec := apiRequest(t, api, "DELETE", "/users/nobody/usage", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown user: got %d, want 404", rec.Code)
+	}
+}
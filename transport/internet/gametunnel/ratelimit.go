@@ -0,0 +1,172 @@
+package gametunnel
+
+import (
+	"sync"
+	"time"
+)
+
+// ====================================================================
+// Ограничение скорости (token bucket)
+// ====================================================================
+//
+// Операторам нужно ограничивать отдельных пользователей и
+// придерживать bulk-трафик. Ограничения проверяются в цикле
+// отправки (sendLoop), после очереди приоритетов:
+//
+//   - Лимит сессии (байт/сек + burst) - на каждого клиента.
+//     По умолчанию из конфига, для отдельного пользователя
+//     переопределяется через Hub.SetSessionRateLimit
+//   - Потолок класса - суммарная скорость High/Medium/Low
+//     (на Hub - по всем сессиям, на клиенте - по соединению)
+//
+// Что делать с пакетом сверх лимита - задаёт политика:
+//   drop  - отбросить (минимальная задержка, потери как в UDP)
+//   queue - придержать пакет до накопления токенов; на сервере
+//           сессия с ним ждёт вне круга отправки, остальные
+//           обслуживаются (session_queue.go)
+//
+// ====================================================================
+
+// RateLimitPolicy - что делать с пакетом, превысившим лимит
+type RateLimitPolicy int32
+
+const (
+	// RateLimitPolicy_DROP - отбросить пакет
+	RateLimitPolicy_DROP RateLimitPolicy = 0
+
+	// RateLimitPolicy_QUEUE - задержать отправку до появления токенов
+	RateLimitPolicy_QUEUE RateLimitPolicy = 1
+)
+
+// maxRateLimitWait - максимальная задержка пакета в режиме queue
+// Дольше держать бессмысленно: данные устареют, а очередь сессии
+// за ним стоит
+const maxRateLimitWait = time.Second
+
+// RateLimitPolicyFromString парсит строковое значение политики
+func RateLimitPolicyFromString(s string) RateLimitPolicy {
+	switch s {
+	case "queue", "delay", "QUEUE":
+		return RateLimitPolicy_QUEUE
+	default:
+		return RateLimitPolicy_DROP
+	}
+}
+
+// tokenBucket - классический token bucket
+// Токены - байты; пополняется со скоростью rate байт/сек до burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+
+	mu sync.Mutex
+}
+
+// newTokenBucket создаёт bucket; rate == 0 означает «без ограничений» (nil)
+func newTokenBucket(rate, burst uint64) *tokenBucket {
+	if rate == 0 {
+		return nil
+	}
+	if burst < rate/10 {
+		// Слишком маленький burst не пропустит даже один MTU-пакет
+		// на низких скоростях - минимум 100ms трафика
+		burst = rate / 10
+	}
+	if burst < MaxPacketSize {
+		burst = MaxPacketSize
+	}
+	return &tokenBucket{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refillLocked пополняет токены по прошедшему времени
+func (b *tokenBucket) refillLocked(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// reserve забирает n токенов (допуская уход в минус) и возвращает,
+// сколько нужно подождать до момента, когда резерв будет покрыт
+func (b *tokenBucket) reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// setRate меняет скорость пополнения (токены до этого момента
+// начисляются по старой скорости)
+func (b *tokenBucket) setRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refillLocked(time.Now())
+	b.rate = rate
+}
+
+// cancel возвращает ранее зарезервированные токены
+func (b *tokenBucket) cancel(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += float64(n)
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// admitPacket решает судьбу пакета размером n с учётом всех bucket'ов
+// Возвращает задержку перед отправкой и признак допуска.
+// В режиме drop пакет без токенов отбрасывается, резервы откатываются.
+func admitPacket(policy RateLimitPolicy, n int, buckets ...*tokenBucket) (time.Duration, bool) {
+	var wait time.Duration
+	reserved := buckets[:0:0]
+
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		d := b.reserve(n)
+		reserved = append(reserved, b)
+		if d > wait {
+			wait = d
+		}
+	}
+
+	if wait == 0 {
+		return 0, true
+	}
+
+	if policy == RateLimitPolicy_DROP || wait > maxRateLimitWait {
+		for _, b := range reserved {
+			b.cancel(n)
+		}
+		return 0, false
+	}
+
+	return wait, true
+}
+
+// newClassLimiters создаёт потолки скорости для классов приоритета
+func newClassLimiters(config *Config) [PriorityLevels]*tokenBucket {
+	var limiters [PriorityLevels]*tokenBucket
+	limiters[PriorityHigh] = newTokenBucket(config.HighRateLimit, 0)
+	limiters[PriorityMedium] = newTokenBucket(config.MediumRateLimit, 0)
+	limiters[PriorityLow] = newTokenBucket(config.LowRateLimit, 0)
+	return limiters
+}
@@ -0,0 +1,128 @@
+package gametunnel
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	if b := newTokenBucket(0, 1000); b != nil {
+		t.Error("Zero rate should mean no limiter")
+	}
+
+	// nil bucket'ы пропускаются
+	wait, ok := admitPacket(RateLimitPolicy_DROP, 1500, nil, nil)
+	if !ok || wait != 0 {
+		t.Errorf("No limiters: got (%v, %v), want (0, true)", wait, ok)
+	}
+}
+
+func TestAdmitPacketDrop(t *testing.T) {
+	// 10 KB/s, burst 3000 байт → два пакета по 1500 проходят, третий нет
+	b := newTokenBucket(10_000, 3000)
+
+	for i := 0; i < 2; i++ {
+		if _, ok := admitPacket(RateLimitPolicy_DROP, 1500, b); !ok {
+			t.Fatalf("Packet %d should fit into burst", i)
+		}
+	}
+	if _, ok := admitPacket(RateLimitPolicy_DROP, 1500, b); ok {
+		t.Error("Packet over burst should be dropped")
+	}
+
+	// Отброшенный пакет не должен съедать токены:
+	// через 150ms набирается 1500 байт - ровно на один пакет
+	time.Sleep(160 * time.Millisecond)
+	if _, ok := admitPacket(RateLimitPolicy_DROP, 1500, b); !ok {
+		t.Error("Dropped packets must not consume tokens")
+	}
+}
+
+func TestAdmitPacketQueue(t *testing.T) {
+	b := newTokenBucket(10_000, 1500)
+
+	if wait, ok := admitPacket(RateLimitPolicy_QUEUE, 1500, b); !ok || wait != 0 {
+		t.Fatalf("First packet: got (%v, %v), want (0, true)", wait, ok)
+	}
+
+	wait, ok := admitPacket(RateLimitPolicy_QUEUE, 1500, b)
+	if !ok {
+		t.Fatal("Queue policy should admit with delay")
+	}
+	// 1500 байт на 10 KB/s ≈ 150ms
+	if wait < 100*time.Millisecond || wait > 200*time.Millisecond {
+		t.Errorf("Queue wait: got %v, want ~150ms", wait)
+	}
+}
+
+func TestAdmitPacketMultipleBuckets(t *testing.T) {
+	session := newTokenBucket(1_000_000, 100_000)
+	class := newTokenBucket(10_000, 1500)
+
+	if _, ok := admitPacket(RateLimitPolicy_DROP, 1500, session, class); !ok {
+		t.Fatal("First packet should pass both buckets")
+	}
+
+	// Класс исчерпан - пакет отброшен, токены сессии возвращены
+	if _, ok := admitPacket(RateLimitPolicy_DROP, 1500, session, class); ok {
+		t.Fatal("Class ceiling should drop the packet")
+	}
+	session.mu.Lock()
+	tokens := session.tokens
+	session.mu.Unlock()
+	if tokens < 100_000-1500-1 {
+		t.Errorf("Session tokens should be refunded, got %.0f", tokens)
+	}
+}
+
+func TestRateLimitPolicyFromString(t *testing.T) {
+	if RateLimitPolicyFromString("queue") != RateLimitPolicy_QUEUE {
+		t.Error("'queue' should parse to QUEUE")
+	}
+	if RateLimitPolicyFromString("drop") != RateLimitPolicy_DROP {
+		t.Error("'drop' should parse to DROP")
+	}
+	if RateLimitPolicyFromString("") != RateLimitPolicy_DROP {
+		t.Error("Default policy should be DROP")
+	}
+}
+
+func TestHubQueuePolicyParksLimitedSession(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "ratelimit"
+	config.RateLimitPolicy = RateLimitPolicy_QUEUE
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	slowClient, slowServer := dialTestClient(t, l, &clientConfig, accepted)
+	fastClient, fastServer := dialTestClient(t, l, &clientConfig, accepted)
+
+	// 2000 байт/с при burst 1500: второй и третий пакеты ждут
+	// токенов 250 и 750ms
+	slow := slowServer.(*GameTunnelConn).session
+	if err := l.hub.SetSessionRateLimit(slow.ID, 2000, 0); err != nil {
+		t.Fatal(err)
+	}
+	for i := byte(0); i < 3; i++ {
+		slowServer.Write(bytes.Repeat([]byte{'a' + i}, 1000))
+	}
+
+	// Ожидание лимита медленной сессии не держит отправку соседней
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	fastServer.Write([]byte("fast"))
+	if got := readWithTimeout(t, fastClient, 4); string(got) != "fast" {
+		t.Fatalf("fast client got %q", got)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("fast session waited %v behind the limited one", d)
+	}
+
+	// Пакеты отложенной сессии приходят все и по порядку
+	for i := byte(0); i < 3; i++ {
+		got := readWithTimeout(t, slowClient, 1000)
+		if !bytes.Equal(got, bytes.Repeat([]byte{'a' + i}, 1000)) {
+			t.Fatalf("slow packet %d: %q...", i, got[:min(len(got), 8)])
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package gametunnel
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+
+	"golang.org/x/net/ipv4"
+)
+
+// ====================================================================
+// Пакетное чтение: ReadPackets и recvmmsg
+// ====================================================================
+//
+// Игры шлют пачки пакетов на границе тика. Read отдаёт по пакету за
+// вызов, и на каждый пакет читатель просыпается заново.
+// ReadPackets заполняет несколько буферов за вызов: ждёт только
+// первый пакет, остальные забирает из очереди чтения (inbound.go),
+// если они уже там. Один пакет - один буфер, bufs[i] укорачивается до
+// его длины. Пакет длиннее буфера дочитывается следующим вызовом, как
+// в Read: вызов на нём заканчивается, хвост придёт первым.
+//
+// Ниже, на сокете сервера, пачку принимает recvmmsg: receiveLoop
+// забирает до recvBatch датаграмм за системный вызов вместо одной
+// recvfrom на датаграмму. Пакеты пачки расходятся по сессиям как
+// раньше - сразу или через пул расшифровки (decrypt_pool.go).
+//
+// recvBatch:
+//   0 - defaultRecvBatch
+//   1 - без пачек, recvfrom на каждую датаграмму
+//   N - до N датаграмм (не больше MaxRecvBatch)
+//
+// recvmmsg есть только в Linux (batch_linux.go) и только у настоящего
+// сокета; клиент читает свой сокет по одной датаграмме.
+//
+// ====================================================================
+
+const (
+	// defaultRecvBatch - датаграмм в пачке recvmmsg по умолчанию
+	defaultRecvBatch = 32
+
+	// MaxRecvBatch - потолок recvBatch
+	MaxRecvBatch = 256
+)
+
+// PacketReader - чтение нескольких пакетов за вызов
+type PacketReader interface {
+	ReadPackets(bufs [][]byte) (int, error)
+}
+
+var (
+	_ PacketReader = (*GameTunnelConn)(nil)
+	_ PacketReader = (*GameTunnelClientConn)(nil)
+)
+
+// recvBatchSize возвращает размер пачки recvmmsg
+func recvBatchSize(config *Config) int {
+	if config.RecvBatch == 0 {
+		return defaultRecvBatch
+	}
+	return int(min(config.RecvBatch, MaxRecvBatch))
+}
+
+// ReadPackets читает до len(bufs) пакетов: ждёт первый, остальные -
+// только уже пришедшие. bufs[i] укорачивается до длины пакета.
+// Возвращает число заполненных буферов
+func (c *GameTunnelConn) ReadPackets(bufs [][]byte) (int, error) {
+	return readPackets(&c.rest, c.session.inbound, c.done, &c.closed, bufs)
+}
+
+// ReadPackets читает до len(bufs) пакетов: ждёт первый, остальные -
+// только уже пришедшие. bufs[i] укорачивается до длины пакета.
+// Возвращает число заполненных буферов
+func (c *GameTunnelClientConn) ReadPackets(bufs [][]byte) (int, error) {
+	return readPackets(&c.rest, c.session().inbound, c.ctx.Done(), &c.closed, bufs)
+}
+
+// readPackets - ReadPackets соединения: остаток прошлого Read или
+// пакет inbound (ожидание первого до закрытия или cancel), затем
+// пакеты, уже ждущие в очереди
+func readPackets(rest *readRest, inbound *inboundRing, cancel <-chan struct{}, closed *int32, bufs [][]byte) (int, error) {
+	n := 0
+	for n < len(bufs) {
+		b := bufs[n]
+		size, ok := rest.read(b)
+		if !ok {
+			var p inboundPacket
+			if n == 0 {
+				if atomic.LoadInt32(closed) == 1 {
+					return 0, io.EOF
+				}
+				if p, ok = inbound.popPacket(cancel); !ok {
+					return 0, io.EOF
+				}
+			} else if p, ok = inbound.tryPopPacket(); !ok {
+				break
+			}
+			size = rest.copyPacket(b, p)
+		}
+		bufs[n] = b[:size]
+		n++
+		// Хвост пакета - первым в следующем вызове
+		if rest.pending() {
+			break
+		}
+	}
+	return n, nil
+}
+
+// receiveBatches - receiveLoop с recvmmsg: до size датаграмм за вызов
+// Буферы пачки - из пула (bufpool.go); принятые уходят хабу,
+// на их место берутся новые
+func (l *Listener) receiveBatches(sock *listenSocket, size int) {
+	msgs := make([]ipv4.Message, size)
+	bufs := make([]*[]byte, size)
+	for i := range msgs {
+		msgs[i].Buffers = make([][]byte, 1)
+	}
+	defer func() {
+		for _, buf := range bufs {
+			if buf != nil {
+				putPacketBuf(buf)
+			}
+		}
+	}()
+
+	for {
+		for i := range msgs {
+			if bufs[i] == nil {
+				bufs[i] = getPacketBuf()
+			}
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+		n, err := sock.dscp.batch.ReadBatch(msgs, 0)
+		if err != nil {
+			if l.ctx.Err() != nil {
+				return
+			}
+			// Временная ошибка сокета - продолжаем работу
+			continue
+		}
+		if n > 1 {
+			atomic.AddUint64(&l.hub.recvBatches, 1)
+		}
+
+		for i := 0; i < n; i++ {
+			addr, _ := msgs[i].Addr.(*net.UDPAddr)
+			if msgs[i].N == 0 || addr == nil {
+				continue
+			}
+			buf := bufs[i]
+			bufs[i] = nil
+			sock.dscp.captured(false, addr, (*buf)[:msgs[i].N])
+			l.hub.receivePacket(sock.dscp, buf, msgs[i].N, addr)
+		}
+	}
+}
+
+// GetRecvBatches - вызовов recvmmsg, принявших больше одной датаграммы
+func (h *Hub) GetRecvBatches() uint64 {
+	return atomic.LoadUint64(&h.recvBatches)
+}
@@ -0,0 +1,78 @@
+package gametunnel
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// waitQueued ждёт n пакетов в очереди чтения
+func waitQueued(t *testing.T, inbound *inboundRing, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for inbound.stats().Queued < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queued %d packets, want %d", inbound.stats().Queued, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReadPackets(t *testing.T) {
+	for _, batch := range []uint32{0, 1} {
+		t.Run(fmt.Sprintf("recvBatch=%d", batch), func(t *testing.T) {
+			config := DefaultConfig()
+			config.Key = "read-packets"
+			config.RecvBatch = batch
+
+			l, accepted := startTestListener(t, config)
+			clientConfig := *config
+			client, server := dialTestClient(t, l, &clientConfig, accepted)
+			session := l.hub.GetSession(client.session().ConnectionID)
+
+			// Пачка тика - за один вызов
+			for i := 0; i < 5; i++ {
+				if _, err := client.Write([]byte(fmt.Sprintf("tick-%d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			waitQueued(t, session.inbound, 5)
+			bufs := make([][]byte, 8)
+			for i := range bufs {
+				bufs[i] = make([]byte, 64)
+			}
+			n, err := server.(*GameTunnelConn).ReadPackets(bufs)
+			if err != nil || n != 5 {
+				t.Fatalf("ReadPackets: n=%d err=%v", n, err)
+			}
+			for i := 0; i < n; i++ {
+				if want := fmt.Sprintf("tick-%d", i); string(bufs[i]) != want {
+					t.Errorf("packet %d: %q, want %q", i, bufs[i], want)
+				}
+			}
+
+			// Пакет длиннее буфера: хвост - первым в следующем вызове
+			if _, err := server.Write([]byte("snapshot")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := server.Write([]byte("delta")); err != nil {
+				t.Fatal(err)
+			}
+			waitQueued(t, client.session().inbound, 2)
+			small := [][]byte{make([]byte, 4), make([]byte, 4), make([]byte, 4)}
+			if n, err := client.ReadPackets(small); err != nil || n != 1 || string(small[0]) != "snap" {
+				t.Fatalf("first call: n=%d %q err=%v", n, small[0], err)
+			}
+			small = [][]byte{make([]byte, 8), make([]byte, 8)}
+			if n, err := client.ReadPackets(small); err != nil || n != 2 || string(small[0]) != "shot" || string(small[1]) != "delta" {
+				t.Fatalf("second call: n=%d %q %q err=%v", n, small[0], small[1], err)
+			}
+		})
+	}
+
+	config := DefaultConfig()
+	config.RecvBatch = MaxRecvBatch + 1
+	if err := config.Validate(); err == nil {
+		t.Error("recvBatch above MaxRecvBatch accepted")
+	}
+}
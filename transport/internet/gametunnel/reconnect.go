@@ -0,0 +1,239 @@
+package gametunnel
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	mrand "math/rand"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// ====================================================================
+// Автоматическое переподключение клиента (reconnectAttempts)
+// ====================================================================
+//
+// Без переподключения клиентское соединение живёт, пока жива его
+// сессия на сервере: после перезапуска сервера или долгого
+// обрыва связи сервер сессию не знает, и xray видит только EOF.
+//
+// С reconnectAttempts > 0 соединение само заводит новую сессию:
+//
+//   - сервер закрыл сессию с причиной SERVER_SHUTDOWN
+//     (перезапуск, drain);
+//   - путь не ожил и после rebind (health.go): blackholeThreshold
+//     признаков поломки подряд уже на новом сокете.
+//
+// Попытка - новый сокет и полный хэндшейк. Перед каждой попыткой
+// пауза: reconnectBackoff, удваивается до reconnectMaxBackoff, со
+// случайным разбросом ±20% - клиенты перезапущенного сервера не
+// приходят все разом. После reconnectAttempts неудач соединение
+// закрывается.
+//
+// Новая сессия подменяет старую внутри того же
+// GameTunnelClientConn: xray продолжает писать и читать, не зная о
+// смене. Сервер видит новое соединение, поэтому клиент повторяет
+// в нём начало потока - первый Write (заголовок протокола xray, до
+// maxResumePrefix байт), и сервер заново поднимает проксируемый
+// поток к той же цели. Данные в пути теряются, как при любой потере
+// UDP.
+//
+// Поток TCP так восстановить нельзя - состояние соединения с целью
+// потеряно на сервере, - поэтому соединения к TCP-целям не
+// переподключаются и, как раньше, получают EOF.
+//
+// ====================================================================
+
+const (
+	// defaultReconnectBackoff - пауза перед первой попыткой
+	defaultReconnectBackoff = 500 * time.Millisecond
+
+	// defaultReconnectMaxBackoff - потолок паузы между попытками
+	defaultReconnectMaxBackoff = 30 * time.Second
+
+	// reconnectJitter - разброс паузы (доля)
+	reconnectJitter = 0.2
+
+	// maxResumePrefix - сколько байт начала потока повторяется в новой сессии
+	maxResumePrefix = 4096
+)
+
+// reconnectBackoff возвращает начальную паузу и её потолок
+func reconnectBackoff(config *Config) (time.Duration, time.Duration) {
+	base := time.Duration(config.ReconnectBackoff) * time.Millisecond
+	if base == 0 {
+		base = defaultReconnectBackoff
+	}
+	limit := time.Duration(config.ReconnectMaxBackoff) * time.Millisecond
+	if limit == 0 {
+		limit = defaultReconnectMaxBackoff
+	}
+	if limit < base {
+		limit = base
+	}
+	return base, limit
+}
+
+// jitterDuration разбрасывает d на ±reconnectJitter
+func jitterDuration(d time.Duration) time.Duration {
+	spread := (mrand.Float64()*2 - 1) * reconnectJitter
+	return d + time.Duration(float64(d)*spread)
+}
+
+// session возвращает текущую клиентскую сессию
+func (c *GameTunnelClientConn) session() *ClientSession {
+	return c.current.Load()
+}
+
+// recordOpening запоминает начало потока для повтора после переподключения
+func (c *GameTunnelClientConn) recordOpening(b []byte) {
+	if c.opening.Load() != nil {
+		return
+	}
+	var opening []byte
+	if len(b) <= maxResumePrefix {
+		opening = append([]byte(nil), b...)
+	}
+	c.opening.CompareAndSwap(nil, &opening)
+}
+
+// startReconnect запускает переподключение, если оно разрешено
+// Возвращает true, если переподключение идёт (запущено сейчас или раньше)
+func (c *GameTunnelClientConn) startReconnect() bool {
+	if c.config.ReconnectAttempts == 0 || !c.resumable || atomic.LoadInt32(&c.closed) == 1 {
+		return false
+	}
+	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
+		return true
+	}
+	c.goLoop("reconnect", c.reconnectLoop)
+	return true
+}
+
+// reconnectLoop пробует завести новую сессию с паузами между попытками
+func (c *GameTunnelClientConn) reconnectLoop() {
+	defer atomic.StoreInt32(&c.reconnecting, 0)
+
+	// Спан всех попыток, хэндшейки - дочерние (tracing.go)
+	ctx, span := startSpan(c.ctx, c.config, "gametunnel.reconnect", spanKindClient)
+	span.set("gametunnel.connection_id", hex.EncodeToString(c.session().ConnectionID))
+
+	backoff, limit := reconnectBackoff(c.config)
+	for attempt := uint32(0); attempt < c.config.ReconnectAttempts; attempt++ {
+		if !sleepContext(c.ctx, jitterDuration(backoff)) {
+			span.end(c.ctx.Err())
+			return
+		}
+		if backoff *= 2; backoff > limit {
+			backoff = limit
+		}
+
+		err := c.redial(ctx)
+		if err == nil {
+			span.set("gametunnel.attempts", attempt+1)
+			span.set("gametunnel.new_connection_id", hex.EncodeToString(c.session().ConnectionID))
+			span.end(nil)
+			return
+		}
+		logf(log.Severity_Info, "%s reconnect attempt %d of %d failed: %v",
+			sessionTag(c.session().ConnectionID), attempt+1, c.config.ReconnectAttempts, err)
+	}
+
+	// Бюджет попыток исчерпан - xray увидит EOF
+	logf(log.Severity_Warning, "%s server unreachable after %d reconnect attempts", sessionTag(c.session().ConnectionID), c.config.ReconnectAttempts)
+	span.set("gametunnel.attempts", c.config.ReconnectAttempts)
+	span.end(fmt.Errorf("server unreachable after %d attempts", c.config.ReconnectAttempts))
+	c.shutdown()
+}
+
+// redial заводит новую сессию на новом сокете и подменяет ею текущую
+// ctx - контекст соединения со спаном переподключения
+func (c *GameTunnelClientConn) redial(ctx context.Context) error {
+	old := c.session()
+
+	// Имена сервера - заново, мимо кэша: IP за динамическим DNS мог
+	// смениться. Без ответа DNS - прежние адреса
+	if endpoints, err := resolveEndpoints(ctx, c.dest, c.config, c.resolver.refreshed()); err == nil {
+		c.endpoints = endpoints
+	}
+	dialed, err := dialEndpoints(ctx, orderEndpoints(c.endpoints), c.config, c.obfs, c.sockopt)
+	if err != nil {
+		return err
+	}
+	conn, session := dialed.conn, dialed.session
+
+	// Поток xray тот же: канал чтения, приоритеты и профиль потока
+	// переходят в новую сессию
+	session.serverAddr = dialed.addr
+	session.inbound = old.inbound
+	session.flow = old.flow
+	old.mu.RLock()
+	for id, stream := range old.Streams {
+		copied := *stream
+		session.Streams[id] = &copied
+	}
+	old.mu.RUnlock()
+
+	c.pathMu.Lock()
+	if atomic.LoadInt32(&c.closed) == 1 {
+		c.pathMu.Unlock()
+		conn.Close()
+		return nil
+	}
+	c.current.Store(session)
+	oldSock := c.sock.Swap(newDSCPMarker(conn, c.config))
+	c.pathMu.Unlock()
+	oldSock.conn.Close()
+
+	atomic.StoreUint32(&c.path.sendErrors, 0)
+	atomic.StoreUint32(&c.keepAlivesMissed, 0)
+	atomic.StoreInt64(&c.keepAliveSentAt, 0)
+	c.pathRecovered()
+	atomic.StoreInt32(&c.authenticated, 0)
+	atomic.AddUint64(&c.reconnects, 1)
+	c.natTune.reannounce()
+	logf(log.Severity_Info, "%s reconnected to %s as %s", sessionTag(old.ConnectionID), dialed.addr, sessionTag(session.ConnectionID))
+	if c.multipath != nil {
+		c.multipath.reset()
+	}
+	if c.classes != nil {
+		c.classes.reset()
+	}
+	c.piggyback.reset()
+
+	if !c.observers.empty() {
+		info := c.info()
+		c.observers.each(func(o SessionObserver) { o.SessionCreated(info) })
+	}
+
+	// Сервер видит новое соединение - повторяем начало потока
+	if opening := c.opening.Load(); opening != nil && len(*opening) > 0 {
+		c.Write(*opening)
+	}
+	return nil
+}
+
+// GetReconnects возвращает число успешных переподключений
+func (c *GameTunnelClientConn) GetReconnects() uint64 {
+	return atomic.LoadUint64(&c.reconnects)
+}
+
+// dialServerSocket открывает UDP-сокет к серверу
+func (c *GameTunnelClientConn) dialServerSocket(serverAddr *net.UDPAddr) (PacketConn, error) {
+	return dialPathSocket(c.ctx, nil, serverAddr, c.config, c.sockopt)
+}
+
+// dialPathSocket открывает UDP-сокет к серверу с локального адреса
+// local (nil - адрес из sockopt.BindAddress или выбирает ОС) через
+// internet.DialSystem и применяет к нему опции сокета (sockopt.go)
+// В сети MemNetwork из ctx (memnet.go) сокет открывается в ней
+func dialPathSocket(ctx context.Context, local, serverAddr *net.UDPAddr, config *Config, sockopt *internet.SocketConfig) (PacketConn, error) {
+	if mem := memNetworkFromContext(ctx); mem != nil {
+		return mem.DialUDP(local, serverAddr)
+	}
+	return dialSocketOptions(config, sockopt).dialSystem(ctx, local, serverAddr)
+}
@@ -0,0 +1,160 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestReconnectBackoff(t *testing.T) {
+	config := DefaultConfig()
+	base, limit := reconnectBackoff(config)
+	if base != defaultReconnectBackoff || limit != defaultReconnectMaxBackoff {
+		t.Errorf("defaults: %v/%v", base, limit)
+	}
+
+	// Потолок не ниже начальной паузы
+	config.ReconnectBackoff = 2000
+	config.ReconnectMaxBackoff = 1000
+	if base, limit = reconnectBackoff(config); base != 2*time.Second || limit != 2*time.Second {
+		t.Errorf("limit below base: %v/%v", base, limit)
+	}
+
+	for i := 0; i < 1000; i++ {
+		d := jitterDuration(time.Second)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jitter out of bounds: %v", d)
+		}
+	}
+}
+
+// restartTestListener поднимает Listener на порту остановленного
+func restartTestListener(t *testing.T, port int, config *Config) <-chan stat.Connection {
+	t.Helper()
+
+	accepted := make(chan stat.Connection, 16)
+	streamSettings := &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: config,
+	}
+	l, err := ListenGameTunnel(context.Background(), xnet.LocalHostIP, xnet.Port(port), streamSettings,
+		func(conn stat.Connection) {
+			accepted <- conn
+		})
+	if err != nil {
+		t.Fatalf("ListenGameTunnel: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return accepted
+}
+
+func TestClientReconnectsAfterServerRestart(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "reconnect"
+	l, accepted := startTestListener(t, config)
+	port := l.Addr().(*net.UDPAddr).Port
+
+	clientConfig := *config
+	clientConfig.ReconnectAttempts = 5
+	clientConfig.ReconnectBackoff = 50
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+	oldID := client.session().ConnectionID
+
+	client.Write([]byte("opening"))
+	readWithTimeout(t, server, 7)
+
+	// Перезапуск сервера: CLOSE(ServerShutdown) и тот же порт
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	l.DrainAndStop(ctx)
+	accepted = restartTestListener(t, port, config)
+
+	var resumed net.Conn
+	select {
+	case resumed = <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not reconnect")
+	}
+
+	// Новая сессия начинается с повтора начала потока
+	if got := readWithTimeout(t, resumed, 7); string(got) != "opening" {
+		t.Fatalf("Server got %q, want replayed opening", got)
+	}
+	if client.GetReconnects() != 1 || bytes.Equal(client.session().ConnectionID, oldID) {
+		t.Errorf("reconnects=%d, session not replaced", client.GetReconnects())
+	}
+	if client.CloseReason() != CloseReason_NORMAL {
+		t.Errorf("Client closed: %d", client.CloseReason())
+	}
+
+	client.Write([]byte("again"))
+	if got := readWithTimeout(t, resumed, 5); string(got) != "again" {
+		t.Fatalf("Server got %q", got)
+	}
+	resumed.Write([]byte("reply"))
+	if got := readWithTimeout(t, client, 5); string(got) != "reply" {
+		t.Fatalf("Client got %q", got)
+	}
+}
+
+func TestClientReconnectGivesUp(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "reconnect"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.ReconnectAttempts = 2
+	clientConfig.ReconnectBackoff = 10
+	clientConfig.HandshakeTimeout = 1
+	client, _ := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	// Сервер ушёл насовсем: после двух неудачных попыток - EOF
+	l.DrainAndStop(context.Background())
+	client.SetReadDeadline(time.Now().Add(10 * time.Second))
+	if _, err := client.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("Read: got %v, want EOF", err)
+	}
+	if client.GetReconnects() != 0 {
+		t.Errorf("reconnects=%d, want 0", client.GetReconnects())
+	}
+}
+
+func TestClientTCPTargetDoesNotReconnect(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "reconnect"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.ReconnectAttempts = 5
+	addr := l.Addr().(*net.UDPAddr)
+	ctx := session.ContextWithOutbounds(context.Background(), []*session.Outbound{
+		{Target: xnet.TCPDestination(xnet.DomainAddress("example.com"), 443)},
+	})
+	conn, err := Dial(ctx, xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port)),
+		&internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: &clientConfig})
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client := conn.(*GameTunnelClientConn)
+	defer client.Close()
+	<-accepted
+
+	l.DrainAndStop(context.Background())
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("Read: got %v, want EOF", err)
+	}
+	if client.CloseReason() != CloseReason_SERVER_SHUTDOWN {
+		t.Errorf("close reason %d", client.CloseReason())
+	}
+}
@@ -0,0 +1,184 @@
+package gametunnel
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// ====================================================================
+// Перезагрузка конфигурации без разрыва сессий
+// ====================================================================
+//
+// Часть настроек можно сменить на работающем сервере:
+//   - padding:    enablePadding, paddingMinSize, paddingMaxSize,
+//                 paddingBackoff, paddingBandwidth
+//   - приоритеты: priority, classifier
+//   - лимиты:     sessionRateLimit/Burst, high/medium/lowRateLimit,
+//                 rateLimitPolicy
+//   - фильтр:     allowIps, denyIps
+//   - квоты:      quotaBytes, quotaAction, quotaThrottleRate
+//   - users:      пользователи с личными ключами (новые хэндшейки;
+//                 сессии остаются со своими ключами, users.go)
+//   - перегрузка: maxSessions, memoryBudgetMb, overloadPolicy,
+//                 overloadRetryAfter (сверх нового лимита сессии
+//                 не вытесняются, ограничиваются новые хэндшейки),
+//                 puzzleRate
+//   - keep-alive: keepAliveInterval (таймаут сессий и проверки
+//                 молчащих клиентов)
+//
+// Остальные поля (ключ, MTU, длина Connection ID, адреса) задают
+// формат пакетов и сокеты - они берутся из текущего конфига,
+// новое значение молча игнорируется.
+//
+// Конфиг хаба - copy-on-write: Reload собирает новый *Config и
+// подменяет указатель целиком. Каждая операция читает снимок один
+// раз (Hub.getConfig), поэтому пакет никогда не собирается из
+// половины старого и половины нового конфига.
+//
+// Существующие сессии получают новый лимит скорости, кроме тех,
+// которым лимит назначен через SetSessionRateLimit или политикой
+// inbound-а (policy.go). Классификатор
+// пересоздаётся из конфига, заменяя установленный SetClassifier.
+//
+// Способы перезагрузки:
+//   - Hub.Reload / Listener.Reload из кода
+//   - Listener.ReloadOnSIGHUP - по сигналу SIGHUP
+//   - POST /reload в API управления (management.go)
+//   - PATCH /tunables - отдельные настройки по имени (tunables.go)
+//
+// ====================================================================
+
+// applyReloadable копирует в cur перезагружаемые поля из next
+func applyReloadable(cur, next *Config) {
+	cur.EnablePadding = next.EnablePadding
+	cur.PaddingMinSize = next.PaddingMinSize
+	cur.PaddingMaxSize = next.PaddingMaxSize
+	cur.PaddingBackoff = next.PaddingBackoff
+	cur.PaddingBandwidth = next.PaddingBandwidth
+
+	cur.Priority = next.Priority
+	cur.Classifier = next.Classifier
+
+	cur.SessionRateLimit = next.SessionRateLimit
+	cur.SessionRateBurst = next.SessionRateBurst
+	cur.HighRateLimit = next.HighRateLimit
+	cur.MediumRateLimit = next.MediumRateLimit
+	cur.LowRateLimit = next.LowRateLimit
+	cur.RateLimitPolicy = next.RateLimitPolicy
+
+	cur.AllowIps = next.AllowIps
+	cur.DenyIps = next.DenyIps
+
+	cur.QuotaBytes = next.QuotaBytes
+	cur.QuotaAction = next.QuotaAction
+	cur.QuotaThrottleRate = next.QuotaThrottleRate
+	cur.Users = next.Users
+
+	cur.MaxSessions = next.MaxSessions
+	cur.MemoryBudgetMb = next.MemoryBudgetMb
+	cur.OverloadPolicy = next.OverloadPolicy
+	cur.OverloadRetryAfter = next.OverloadRetryAfter
+	cur.PuzzleRate = next.PuzzleRate
+
+	cur.KeepAliveInterval = next.KeepAliveInterval
+
+	cur.BlackholeThreshold = next.BlackholeThreshold
+	cur.ValidateMigration = next.ValidateMigration
+}
+
+// Reload применяет перезагружаемые настройки из config к работающему
+// хабу. Сессии не разрываются. При ошибке текущий конфиг не меняется
+func (h *Hub) Reload(config *Config) error {
+	return h.updateConfig(func(next *Config) error {
+		applyReloadable(next, config)
+		return nil
+	})
+}
+
+// updateConfig меняет конфиг хаба copy-on-write: change правит копию
+// текущего снимка, после проверки копия подменяет снимок целиком
+func (h *Hub) updateConfig(change func(next *Config) error) error {
+	h.reloadMu.Lock()
+	defer h.reloadMu.Unlock()
+
+	next := *h.getConfig()
+	if err := change(&next); err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+
+	filter, err := newIPFilter(&next)
+	if err != nil {
+		return fmt.Errorf("reload ip filter: %w", err)
+	}
+	classLimiters := newClassLimiters(&next)
+
+	h.config.Store(&next)
+	h.ipFilter.Store(filter)
+	h.users.Store(newUserTable(&next))
+	h.classLimiters.Store(&classLimiters)
+	h.classifier.Store(classifierHolder{NewClassifier(&next)})
+	h.quotas.setDefaultQuota(next.QuotaBytes)
+
+	for _, session := range h.sessions.snapshot() {
+		session.mu.Lock()
+		if !session.limiterPinned {
+			session.limiter = session.policy.limiter(&next)
+		}
+		if session.usage != nil && session.User == "" {
+			atomic.StoreUint64(&session.usage.quota, next.QuotaBytes)
+		}
+		session.mu.Unlock()
+	}
+
+	return nil
+}
+
+// Reload применяет перезагружаемые настройки к работающему listener
+// В группе inbound-ов - и политику его inbound-а (policy.go)
+func (l *Listener) Reload(config *Config) error {
+	if l.group != nil {
+		return l.group.reload(l.tag, config)
+	}
+	return l.hub.Reload(config)
+}
+
+// ReloadOnSIGHUP перезагружает конфиг по сигналу SIGHUP
+// load читает свежий конфиг (например, заново разбирает файл),
+// ошибки загрузки и применения передаются в report (может быть nil).
+// Подписка снимается при Close
+func (l *Listener) ReloadOnSIGHUP(load func() (*Config, error), report func(error)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	l.goLoop("reload", func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-l.ctx.Done():
+				return
+			case <-signals:
+			}
+
+			err := l.reloadFrom(load)
+			if err != nil && report != nil {
+				report(err)
+			}
+		}
+	})
+}
+
+// reloadFrom загружает конфиг и применяет его
+func (l *Listener) reloadFrom(load func() (*Config, error)) error {
+	config, err := load()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	return l.Reload(config)
+}
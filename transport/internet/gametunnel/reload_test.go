@@ -0,0 +1,135 @@
+package gametunnel
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHubReloadAppliesSettings(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	session := newMapSession(1)
+	pinned := newMapSession(2)
+	h.sessions.put(session)
+	h.sessions.put(pinned)
+	h.SetSessionRateLimit(pinned.ID, 500, 0)
+
+	next := DefaultConfig()
+	next.EnablePadding = false
+	next.SessionRateLimit = 1000
+	next.LowRateLimit = 2000
+	next.DenyIps = []*IPRule{{Name: "10.0.0.0/8"}}
+	next.ConnectionIdLength = 16 // не перезагружается
+
+	if err := h.Reload(next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	config := h.getConfig()
+	if config.EnablePadding || config.SessionRateLimit != 1000 {
+		t.Errorf("Reloadable fields not applied: %+v", config)
+	}
+	if config.ConnectionIdLength != 8 {
+		t.Errorf("ConnectionIdLength changed to %d", config.ConnectionIdLength)
+	}
+	if l := h.classLimiters.Load()[PriorityLow]; l == nil || l.rate != 2000 {
+		t.Errorf("Low class limiter not rebuilt: %+v", l)
+	}
+	if session.limiter == nil || session.limiter.rate != 1000 {
+		t.Errorf("Existing session limiter not updated: %+v", session.limiter)
+	}
+	if pinned.limiter.rate != 500 {
+		t.Errorf("Pinned limiter overwritten: rate %v", pinned.limiter.rate)
+	}
+	if err := h.ipFilter.Load().check(net.ParseIP("10.1.2.3")); err == nil {
+		t.Error("Reloaded denyIps not enforced")
+	}
+}
+
+func TestHubReloadInvalidKeepsConfig(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	before := h.getConfig()
+
+	next := DefaultConfig()
+	next.SessionRateLimit = 1000
+	next.AllowIps = []*IPRule{{Name: "geoip:ru"}} // без geoip.dat не строится
+
+	if err := h.Reload(next); err == nil {
+		t.Fatal("Reload with unresolved geoip rule should fail")
+	}
+	if h.getConfig() != before {
+		t.Error("Failed reload replaced config")
+	}
+}
+
+func TestReloadKeepsSessionsAlive(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "reload"
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Сервер перестаёт добавлять padding, клиент - нет:
+	// флаг padding идёт в каждом пакете, формат совместим
+	next := *config
+	next.EnablePadding = false
+	if err := l.Reload(&next); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+
+	if _, err := server.Write([]byte("after reload")); err != nil {
+		t.Fatalf("server Write: %v", err)
+	}
+	if got := readWithTimeout(t, client, 64); string(got) != "after reload" {
+		t.Errorf("client got %q", got)
+	}
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	if got := readWithTimeout(t, server, 64); string(got) != "ping" {
+		t.Errorf("server got %q", got)
+	}
+}
+
+func TestManagementReload(t *testing.T) {
+	config := DefaultConfig()
+	config.DenyIps = []*IPRule{{Name: "192.0.2.0/24"}}
+	h := NewHub(config, nil)
+	api := NewManagementHandler(h, "")
+
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "/reload", bytes.NewBufferString(body))
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := post(`{"sessionRateLimit": 4096}`); code != http.StatusNoContent {
+		t.Fatalf("POST /reload: %d", code)
+	}
+	if h.getConfig().SessionRateLimit != 4096 {
+		t.Error("sessionRateLimit not reloaded")
+	}
+	if len(h.getConfig().DenyIps) != 1 {
+		t.Error("Omitted denyIps should keep current rules")
+	}
+
+	if code := post(`{"denyIps": []}`); code != http.StatusNoContent {
+		t.Fatalf("POST /reload: %d", code)
+	}
+	if h.ipFilter.Load() != nil {
+		t.Error("Empty denyIps should drop the filter")
+	}
+	if config.DenyIps[0].Name != "192.0.2.0/24" {
+		t.Error("Reload mutated the previous config")
+	}
+
+	if code := post(`{"allowIps": [{"name": "not-an-ip"}]}`); code != http.StatusBadRequest {
+		t.Errorf("Invalid rule: got %d, want 400", code)
+	}
+	if code := post(`{`); code != http.StatusBadRequest {
+		t.Errorf("Malformed body: got %d, want 400", code)
+	}
+}
@@ -1,6 +1,7 @@
 package gametunnel
 
 import (
+	"math"
 	"sync"
 )
 
@@ -89,6 +90,66 @@ func (rw *ReplayWindow) Check(seq uint32) bool {
 	return true
 }
 
+// largest - максимальный принятый номер пакета (0 - пакетов не было)
+// Опора восстановления сокращённых номеров (compact.go)
+func (rw *ReplayWindow) largest() uint32 {
+	if rw == nil {
+		return 0
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.maxSeq
+}
+
+// replayState - окно anti-replay в снапшоте сессии (snapshot.go)
+type replayState struct {
+	Max    uint32   `json:"max"`
+	Bitmap []uint64 `json:"bitmap"`
+}
+
+// state снимает окно для снапшота (nil - пакетов не было)
+func (rw *ReplayWindow) state() *replayState {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if !rw.initialized {
+		return nil
+	}
+	return &replayState{Max: rw.maxSeq, Bitmap: append([]uint64(nil), rw.bitmap[:]...)}
+}
+
+// restore поднимает окно из снапшота. Без сохранённого окна (снапшот
+// старой версии) принятыми считаются все номера до last включительно
+func (rw *ReplayWindow) restore(state *replayState, last uint32) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.initialized = true
+	if state != nil && len(state.Bitmap) == len(rw.bitmap) {
+		rw.maxSeq = state.Max
+		copy(rw.bitmap[:], state.Bitmap)
+		return
+	}
+	rw.maxSeq = last
+	rw.fillAll()
+}
+
+// skip считает принятыми n номеров после максимального: их могли
+// принять после снятия состояния
+// false - номера переполнили бы uint32
+func (rw *ReplayWindow) skip(n uint32) bool {
+	if n == 0 {
+		return true
+	}
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	if rw.maxSeq > math.MaxUint32-n {
+		return false
+	}
+	rw.initialized = true
+	rw.maxSeq += n
+	rw.fillAll()
+	return true
+}
+
 func (rw *ReplayWindow) setBit(seq uint32) {
 	idx := seq % ReplayWindowSize
 	rw.bitmap[idx/64] |= 1 << (idx % 64)
@@ -109,3 +170,9 @@ func (rw *ReplayWindow) clearAll() {
 		rw.bitmap[i] = 0
 	}
 }
+
+func (rw *ReplayWindow) fillAll() {
+	for i := range rw.bitmap {
+		rw.bitmap[i] = math.MaxUint64
+	}
+}
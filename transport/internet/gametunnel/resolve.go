@@ -0,0 +1,336 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// ====================================================================
+// Разрешение имени сервера
+// ====================================================================
+//
+// Адрес outbound-а и endpoints могут быть именами - в том числе
+// динамического DNS. Откуда брать адреса:
+//
+//   - resolver в конфигурации: свой DNS-сервер - DoH ("https://...",
+//     RFC 8484) или обычный DNS по UDP ("udp://1.1.1.1:53",
+//     "1.1.1.1"). Ответы кэшируются на их TTL (не дольше
+//     resolverMaxTTL);
+//   - иначе, если в streamSettings.sockopt задан domainStrategy, -
+//     DNS xray (internet.LookupForIP) с его выбором семейства; без
+//     ответа DNS xray и без FORCE_IP - системный резолвер;
+//   - иначе системный резолвер.
+//
+// resolveStrategy выбирает семейства: оба (auto, порядок Happy
+// Eyeballs из endpoints.go), сначала IPv4 или IPv6, или только одно.
+//
+// Переподключение (reconnect.go) разрешает имена заново мимо кэша:
+// сервер за динамическим DNS, сменивший IP, находится по новому
+// адресу. Если DNS недоступен, остаются прежние адреса.
+//
+// ====================================================================
+
+// ResolveStrategy - какие адреса имени сервера пробовать
+type ResolveStrategy int32
+
+const (
+	// ResolveStrategy_AUTO - IPv4 и IPv6 в порядке ответа резолвера
+	ResolveStrategy_AUTO ResolveStrategy = 0
+
+	// ResolveStrategy_PREFER_IPV4 - сначала IPv4, потом IPv6
+	ResolveStrategy_PREFER_IPV4 ResolveStrategy = 1
+
+	// ResolveStrategy_PREFER_IPV6 - сначала IPv6, потом IPv4
+	ResolveStrategy_PREFER_IPV6 ResolveStrategy = 2
+
+	// ResolveStrategy_IPV4_ONLY - только IPv4
+	ResolveStrategy_IPV4_ONLY ResolveStrategy = 3
+
+	// ResolveStrategy_IPV6_ONLY - только IPv6
+	ResolveStrategy_IPV6_ONLY ResolveStrategy = 4
+)
+
+// ResolveStrategyFromString парсит строковое значение стратегии
+func ResolveStrategyFromString(s string) ResolveStrategy {
+	switch s {
+	case "preferIPv4", "prefer-ipv4", "PREFER_IPV4":
+		return ResolveStrategy_PREFER_IPV4
+	case "preferIPv6", "prefer-ipv6", "PREFER_IPV6":
+		return ResolveStrategy_PREFER_IPV6
+	case "ipv4", "ipv4Only", "IPV4_ONLY":
+		return ResolveStrategy_IPV4_ONLY
+	case "ipv6", "ipv6Only", "IPV6_ONLY":
+		return ResolveStrategy_IPV6_ONLY
+	default:
+		return ResolveStrategy_AUTO
+	}
+}
+
+const (
+	// resolverTimeout - ожидание ответа своего DNS-сервера
+	resolverTimeout = 3 * time.Second
+
+	// resolverMinTTL / resolverMaxTTL - границы кэша ответов
+	resolverMinTTL = 5 * time.Second
+	resolverMaxTTL = 5 * time.Minute
+
+	// dnsMessageType - MIME-тип DoH (RFC 8484)
+	dnsMessageType = "application/dns-message"
+)
+
+// dnsCache - ответы своих DNS-серверов: "резолвер|имя" -> dnsCacheEntry
+var dnsCache sync.Map
+
+// dnsCacheEntry - адреса имени до истечения TTL
+type dnsCacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// dohClient - HTTP-клиент запросов DoH
+var dohClient = &http.Client{Timeout: resolverTimeout}
+
+// serverResolver разрешает имена сервера по настройкам соединения
+type serverResolver struct {
+	custom   *dnsResolver            // config.Resolver (nil - нет)
+	strategy internet.DomainStrategy // sockopt.domainStrategy xray
+	family   ResolveStrategy
+
+	// fresh - мимо кэша (переподключение)
+	fresh bool
+}
+
+// newServerResolver собирает резолвер из конфигурации и sockopt
+func newServerResolver(config *Config, sockopt *internet.SocketConfig) (*serverResolver, error) {
+	custom, err := parseResolver(config.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	r := &serverResolver{custom: custom, family: config.ResolveStrategy}
+	if sockopt != nil {
+		r.strategy = sockopt.DomainStrategy
+	}
+	return r, nil
+}
+
+// refreshed возвращает копию резолвера, идущую мимо кэша
+func (r *serverResolver) refreshed() *serverResolver {
+	fresh := *r
+	fresh.fresh = true
+	return &fresh
+}
+
+// lookup возвращает адреса host; IP-адрес возвращается как есть
+func (r *serverResolver) lookup(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	if r.custom != nil {
+		return r.custom.lookup(ctx, host, r.fresh)
+	}
+	if r.strategy.HasStrategy() {
+		ips, err := internet.LookupForIP(host, r.strategy, nil)
+		if err == nil || r.strategy.ForceIP() {
+			return ips, err
+		}
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// applyResolveStrategy отбирает и упорядочивает адреса по семействам
+func applyResolveStrategy(addrs []*net.UDPAddr, strategy ResolveStrategy) []*net.UDPAddr {
+	if strategy == ResolveStrategy_AUTO {
+		return addrs
+	}
+	var ipv4, ipv6 []*net.UDPAddr
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			ipv4 = append(ipv4, addr)
+		} else {
+			ipv6 = append(ipv6, addr)
+		}
+	}
+	switch strategy {
+	case ResolveStrategy_PREFER_IPV4:
+		return append(ipv4, ipv6...)
+	case ResolveStrategy_PREFER_IPV6:
+		return append(ipv6, ipv4...)
+	case ResolveStrategy_IPV4_ONLY:
+		return ipv4
+	case ResolveStrategy_IPV6_ONLY:
+		return ipv6
+	}
+	return addrs
+}
+
+// dnsResolver - свой DNS-сервер: DoH или DNS по UDP
+type dnsResolver struct {
+	name   string // как в конфигурации - ключ кэша
+	doh    string // URL DoH ("" - DNS по UDP)
+	server string // "host:port" DNS по UDP
+}
+
+// parseResolver разбирает config.Resolver ("" - резолвера нет)
+func parseResolver(s string) (*dnsResolver, error) {
+	switch {
+	case s == "":
+		return nil, nil
+	case strings.HasPrefix(s, "https://"):
+		return &dnsResolver{name: s, doh: s}, nil
+	case strings.Contains(s, "://") && !strings.HasPrefix(s, "udp://"):
+		return nil, fmt.Errorf("resolver %q: only https:// and udp:// are supported", s)
+	}
+
+	server := strings.TrimPrefix(s, "udp://")
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return &dnsResolver{name: s, server: server}, nil
+}
+
+// lookup возвращает IPv4 и IPv6 адреса host (A и AAAA параллельно)
+func (d *dnsResolver) lookup(ctx context.Context, host string, fresh bool) ([]net.IP, error) {
+	key := d.name + "|" + host
+	if cached, ok := dnsCache.Load(key); ok && !fresh {
+		if entry := cached.(dnsCacheEntry); time.Now().Before(entry.expires) {
+			return entry.ips, nil
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, resolverTimeout)
+	defer cancel()
+
+	type answer struct {
+		ips []net.IP
+		ttl time.Duration
+		err error
+	}
+	answers := make(chan answer, 2)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		go func(qtype uint16) {
+			ips, ttl, err := d.query(ctx, host, qtype)
+			answers <- answer{ips, ttl, err}
+		}(qtype)
+	}
+
+	var ips []net.IP
+	var lastErr error
+	ttl := resolverMaxTTL
+	for i := 0; i < 2; i++ {
+		a := <-answers
+		if a.err != nil {
+			lastErr = a.err
+			continue
+		}
+		ips = append(ips, a.ips...)
+		if len(a.ips) > 0 && a.ttl < ttl {
+			ttl = a.ttl
+		}
+	}
+	if len(ips) == 0 {
+		if lastErr != nil {
+			return nil, fmt.Errorf("resolve %s via %s: %w", host, d.name, lastErr)
+		}
+		return nil, fmt.Errorf("resolve %s via %s: no addresses", host, d.name)
+	}
+
+	if ttl < resolverMinTTL {
+		ttl = resolverMinTTL
+	}
+	dnsCache.Store(key, dnsCacheEntry{ips: ips, expires: time.Now().Add(ttl)})
+	return ips, nil
+}
+
+// query запрашивает записи qtype имени host и возвращает адреса и
+// наименьший TTL ответа
+func (d *dnsResolver) query(ctx context.Context, host string, qtype uint16) ([]net.IP, time.Duration, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), qtype)
+
+	resp, err := d.exchange(ctx, msg)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, 0, fmt.Errorf("%s", dns.RcodeToString[resp.Rcode])
+	}
+
+	var ips []net.IP
+	ttl := resolverMaxTTL
+	for _, rr := range resp.Answer {
+		var ip net.IP
+		switch record := rr.(type) {
+		case *dns.A:
+			ip = record.A
+		case *dns.AAAA:
+			ip = record.AAAA
+		default:
+			continue
+		}
+		ips = append(ips, ip)
+		if d := time.Duration(rr.Header().Ttl) * time.Second; d < ttl {
+			ttl = d
+		}
+	}
+	return ips, ttl, nil
+}
+
+// exchange отправляет запрос DNS-серверу
+func (d *dnsResolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	if d.doh == "" {
+		client := &dns.Client{Net: "udp", Timeout: resolverTimeout}
+		resp, _, err := client.ExchangeContext(ctx, msg, d.server)
+		return resp, err
+	}
+
+	// DoH: ID 0 - ответы кэшируются HTTP-прокси (RFC 8484, 4.1)
+	msg.Id = 0
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.doh, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := dohClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh status %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
@@ -0,0 +1,252 @@
+package gametunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// testDNS - DNS-сервер по UDP, отвечающий на A-запросы адресом answer
+type testDNS struct {
+	addr    string
+	answer  atomic.Value // net.IP
+	queries atomic.Int64
+}
+
+// startTestDNS поднимает testDNS на 127.0.0.1
+func startTestDNS(t *testing.T, answer net.IP) *testDNS {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDNS{addr: pc.LocalAddr().String()}
+	d.answer.Store(answer)
+
+	server := &dns.Server{PacketConn: pc, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		w.WriteMsg(d.reply(req))
+	})}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return d
+}
+
+// reply отвечает на запрос: A - адрес answer с TTL 60, AAAA - пусто
+func (d *testDNS) reply(req *dns.Msg) *dns.Msg {
+	d.queries.Add(1)
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	if q := req.Question[0]; q.Qtype == dns.TypeA {
+		resp.Answer = append(resp.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   d.answer.Load().(net.IP),
+		})
+	}
+	return resp
+}
+
+// listenTestAt поднимает Listener на ip:port
+func listenTestAt(t *testing.T, ip string, port int, config *Config) (*Listener, <-chan stat.Connection) {
+	t.Helper()
+
+	accepted := make(chan stat.Connection, 16)
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+	l, err := ListenGameTunnel(context.Background(), xnet.ParseAddress(ip), xnet.Port(port), streamSettings,
+		func(conn stat.Connection) {
+			accepted <- conn
+		})
+	if err != nil {
+		t.Fatalf("ListenGameTunnel %s:%d: %v", ip, port, err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.(*Listener), accepted
+}
+
+func TestResolveStrategy(t *testing.T) {
+	v4a := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 443}
+	v4b := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 443}
+	v6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}
+	addrs := []*net.UDPAddr{v6, v4a, v4b}
+
+	tests := []struct {
+		name string
+		want []*net.UDPAddr
+	}{
+		{"", []*net.UDPAddr{v6, v4a, v4b}},
+		{"preferIPv4", []*net.UDPAddr{v4a, v4b, v6}},
+		{"preferIPv6", []*net.UDPAddr{v6, v4a, v4b}},
+		{"ipv4", []*net.UDPAddr{v4a, v4b}},
+		{"ipv6", []*net.UDPAddr{v6}},
+	}
+	for _, tt := range tests {
+		got := applyResolveStrategy(addrs, ResolveStrategyFromString(tt.name))
+		if len(got) != len(tt.want) {
+			t.Fatalf("%q: %v, want %v", tt.name, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q: %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+
+	// Только IPv6 при одних IPv4 - ошибка, а не пустой список
+	config := DefaultConfig()
+	r := &serverResolver{family: ResolveStrategy_IPV6_ONLY}
+	if _, err := resolveEndpoints(context.Background(), xnet.UDPDestination(xnet.LocalHostIP, 443), config, r); err == nil {
+		t.Error("IPv4 server accepted with ipv6 strategy")
+	}
+}
+
+func TestParseResolver(t *testing.T) {
+	tests := []struct {
+		in     string
+		server string
+		doh    string
+	}{
+		{"1.1.1.1", "1.1.1.1:53", ""},
+		{"udp://1.1.1.1:5353", "1.1.1.1:5353", ""},
+		{"[2606:4700::1111]", "[2606:4700::1111]:53", ""},
+		{"https://dns.example/dns-query", "", "https://dns.example/dns-query"},
+	}
+	for _, tt := range tests {
+		d, err := parseResolver(tt.in)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.in, err)
+		}
+		if d.server != tt.server || d.doh != tt.doh {
+			t.Errorf("%q: server %q doh %q", tt.in, d.server, d.doh)
+		}
+	}
+	if _, err := parseResolver("tls://1.1.1.1"); err == nil {
+		t.Error("tls:// resolver accepted")
+	}
+}
+
+func TestDialHostnameViaResolver(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "resolve"
+	l, accepted := startTestListener(t, config)
+	port := l.Addr().(*net.UDPAddr).Port
+	dnsServer := startTestDNS(t, net.IPv4(127, 0, 0, 1))
+
+	clientConfig := *config
+	clientConfig.Resolver = "udp://" + dnsServer.addr
+	dest := xnet.UDPDestination(xnet.DomainAddress("dial.game.test"), xnet.Port(port))
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: &clientConfig}
+
+	for i := 0; i < 2; i++ {
+		conn, err := Dial(context.Background(), dest, streamSettings)
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		server := <-accepted
+		conn.Write([]byte("hello"))
+		if got := readWithTimeout(t, server, 5); string(got) != "hello" {
+			t.Fatalf("Server got %q", got)
+		}
+		conn.Close()
+	}
+
+	// Второй Dial - из кэша: A и AAAA спрошены один раз
+	if n := dnsServer.queries.Load(); n != 2 {
+		t.Errorf("DNS queries %d, want 2", n)
+	}
+}
+
+func TestResolverDoH(t *testing.T) {
+	d := &testDNS{}
+	d.answer.Store(net.IPv4(198, 51, 100, 7))
+	var contentType atomic.Value
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType.Store(r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		req := new(dns.Msg)
+		if err := req.Unpack(body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		packed, _ := d.reply(req).Pack()
+		w.Header().Set("Content-Type", dnsMessageType)
+		w.Write(packed)
+	}))
+	defer doh.Close()
+	saved := dohClient
+	dohClient = doh.Client()
+	defer func() { dohClient = saved }()
+
+	r, err := parseResolver(doh.URL + "/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err := r.lookup(context.Background(), "doh.game.test", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.IPv4(198, 51, 100, 7)) {
+		t.Errorf("DoH answer %v", ips)
+	}
+	if got := contentType.Load(); got != dnsMessageType {
+		t.Errorf("Content-Type %v", got)
+	}
+}
+
+func TestReconnectResolvesAgain(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "resolve"
+	l, accepted := listenTestAt(t, "127.0.0.1", 0, config)
+	port := l.Addr().(*net.UDPAddr).Port
+	_, movedAccepted := listenTestAt(t, "127.0.0.2", port, config)
+	dnsServer := startTestDNS(t, net.IPv4(127, 0, 0, 1))
+
+	clientConfig := *config
+	clientConfig.Resolver = dnsServer.addr
+	clientConfig.ReconnectAttempts = 5
+	clientConfig.ReconnectBackoff = 50
+	dest := xnet.UDPDestination(xnet.DomainAddress("dyndns.game.test"), xnet.Port(port))
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: &clientConfig}
+
+	conn, err := Dial(context.Background(), dest, streamSettings)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	client := conn.(*GameTunnelClientConn)
+	defer client.Close()
+	client.Write([]byte("opening"))
+	readWithTimeout(t, <-accepted, 7)
+
+	// Сервер переехал: DNS отдаёт новый адрес, старый останавливается
+	dnsServer.answer.Store(net.IPv4(127, 0, 0, 2))
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	l.DrainAndStop(ctx)
+
+	var resumed net.Conn
+	select {
+	case resumed = <-movedAccepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client did not reconnect to the new address")
+	}
+	if got := readWithTimeout(t, resumed, 7); string(got) != "opening" {
+		t.Fatalf("Server got %q, want replayed opening", got)
+	}
+	if got := client.session().serverAddr.IP.String(); got != "127.0.0.2" {
+		t.Errorf("Reconnected to %s, want 127.0.0.2", got)
+	}
+}
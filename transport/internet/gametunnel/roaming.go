@@ -0,0 +1,322 @@
+package gametunnel
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Роуминг клиента: смена сети без нового хэндшейка
+// ====================================================================
+//
+// Телефон переходит с Wi-Fi на LTE - сокет, привязанный к адресу
+// Wi-Fi, молча умирает. Клиент замечает это тремя способами:
+//
+//   - ошибки отправки или keep-alive без ответа (health.go);
+//   - маршрут до сервера теперь идёт с другого локального IP:
+//     при паузе в приёме клиент раз в localAddrCheckInterval
+//     спрашивает ядро, с какого адреса оно отправило бы пакет
+//     серверу (connect UDP-сокета ничего не отправляет).
+//
+// В обоих случаях клиент открывает новый сокет и продолжает ту же
+// сессию (connection migration), а сервер переносит сессию на
+// новый адрес. Хэндшейк не повторяется.
+//
+// Проверка пути (path validation, как в QUIC):
+//
+//	сторона A -> B: CONTROL [0x07][AEAD(token)]  - PATH_CHALLENGE
+//	сторона B -> A: CONTROL [0x08][AEAD(token)]  - PATH_RESPONSE
+//
+// token - 8 случайных байт. Клиент шлёт PATH_CHALLENGE сразу после
+// смены сокета: это и перенос сессии на сервере, и проверка, что
+// новый путь работает в обе стороны. Сервер отвечает туда, откуда
+// пришёл PATH_CHALLENGE.
+//
+// С validateMigration = true сервер строже:
+//
+//   - адрес сессии меняет только аутентифицированный пакет (DATA
+//     или sealed CONTROL), а не любой пакет с её Connection ID;
+//   - на новый адрес сервер шлёт свой PATH_CHALLENGE; если ответа
+//     нет за pathValidationTimeout, сессия возвращается на последний
+//     проверенный адрес.
+//
+// Так чужой пакет с подменённым адресом отправителя не уводит
+// трафик сессии. Старые клиенты на 0x07 не отвечают, поэтому
+// включать режим можно только после обновления клиентов.
+//
+// ====================================================================
+
+const (
+	// pathTokenSize - размер токена проверки пути
+	pathTokenSize = 8
+
+	// pathValidationTimeout - ожидание PATH_RESPONSE до отката адреса
+	pathValidationTimeout = 3 * time.Second
+
+	// localAddrCheckInterval - период проверки локального адреса клиента
+	localAddrCheckInterval = 2 * time.Second
+)
+
+// authenticatedPacket - пакет, который обработчик принимает только
+// после расшифровки: DATA и sealed-команды CONTROL
+func authenticatedPacket(pktType PacketType, data []byte, connIDLen int) bool {
+	switch pktType {
+	case PacketType_DATA:
+		return true
+	case PacketType_CONTROL:
+		switch controlCommand(data, connIDLen) {
+		case 0x05, 0x07, 0x08, 0x0A, 0x0B:
+			return true
+		}
+	}
+	return false
+}
+
+// openControl расшифровывает body sealed-команды клиента
+func (h *Hub) openControl(session *Session, pkt *Packet, data []byte) ([]byte, error) {
+	if session.Keys == nil {
+		return nil, fmt.Errorf("session has no keys")
+	}
+	adLen := FlagsSize + VersionSize + int(h.getConfig().ConnectionIdLength)
+	if len(data) < adLen {
+		return nil, fmt.Errorf("control packet too short")
+	}
+
+	// Окно replay сдвигает только расшифрованный пакет (deadpeer.go)
+	body, err := session.decrypt(pkt.Payload[1:], pkt.PacketNumber, data[:adLen])
+	if err != nil {
+		h.count(func(c *sideCounters) *uint64 { return &c.decryptFailures }, 1)
+		return nil, fmt.Errorf("decrypt control 0x%02x: %w", pkt.Payload[0], err)
+	}
+	if session.ReplayWindow != nil && !session.ReplayWindow.Check(pkt.PacketNumber) {
+		return nil, fmt.Errorf("replay detected: packet %d", pkt.PacketNumber)
+	}
+	h.markAuthenticated(session)
+	return body, nil
+}
+
+// answerPathChallenge отвечает на PATH_CHALLENGE клиента по пути,
+// по которому он пришёл
+func (h *Hub) answerPathChallenge(session *Session, pkt *Packet, data []byte, sock *dscpMarker, remoteAddr *net.UDPAddr) error {
+	token, err := h.openControl(session, pkt, data)
+	if err != nil {
+		return err
+	}
+	wrapped, err := h.sealControl(session, 0x08, token)
+	if err != nil {
+		return err
+	}
+	if _, err := sock.WriteToUDP(wrapped, remoteAddr, PriorityHigh); err != nil {
+		return fmt.Errorf("send path response: %w", err)
+	}
+	return nil
+}
+
+// handlePathResponse завершает проверку нового адреса сессии
+func (h *Hub) handlePathResponse(session *Session, pkt *Packet, data []byte) error {
+	token, err := h.openControl(session, pkt, data)
+	if err != nil {
+		return err
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if session.validatedAddr == nil || subtle.ConstantTimeCompare(token, session.pathToken[:]) != 1 {
+		return fmt.Errorf("unexpected path response")
+	}
+	session.validatedAddr = nil
+	session.validatedSock = nil
+	return nil
+}
+
+// migrateSession переносит сессию на новый адрес после
+// аутентифицированного пакета и начинает проверку пути
+func (h *Hub) migrateSession(session *Session, sock *dscpMarker, remoteAddr *net.UDPAddr) {
+	var token [pathTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return
+	}
+
+	session.mu.Lock()
+	from := session.RemoteAddr
+	if from.String() == remoteAddr.String() {
+		// Другой пакет с этого адреса успел раньше
+		session.mu.Unlock()
+		return
+	}
+	// Откат - на последний проверенный путь, а не на промежуточный
+	if session.validatedAddr == nil {
+		session.validatedAddr = from
+		session.validatedSock = session.sock
+	}
+	session.RemoteAddr = remoteAddr
+	session.sock = sock
+	session.pathToken = token
+	session.mu.Unlock()
+
+	h.compact.follow(session, remoteAddr)
+	session.path.recovered()
+	h.count(func(c *sideCounters) *uint64 { return &c.migrations }, 1)
+	h.notifyMigrated(session, from.String())
+
+	h.sendSealedControl(session, 0x07, token[:])
+	time.AfterFunc(pathValidationTimeout, func() {
+		h.expirePathValidation(session, token)
+	})
+}
+
+// expirePathValidation возвращает сессию на проверенный адрес, если
+// новый не ответил на PATH_CHALLENGE с токеном token
+func (h *Hub) expirePathValidation(session *Session, token [pathTokenSize]byte) {
+	if h.ctx.Err() != nil || h.sessions.get(session.ID) != session {
+		return
+	}
+
+	session.mu.Lock()
+	// Проверка прошла или её сменила более новая миграция
+	if session.validatedAddr == nil || session.pathToken != token {
+		session.mu.Unlock()
+		return
+	}
+	from := session.RemoteAddr
+	session.RemoteAddr = session.validatedAddr
+	session.sock = session.validatedSock
+	restored := session.RemoteAddr
+	session.validatedAddr = nil
+	session.validatedSock = nil
+	session.mu.Unlock()
+
+	h.compact.follow(session, restored)
+
+	atomic.AddUint64(&h.migrationsReverted, 1)
+	h.notifyMigrated(session, from.String())
+}
+
+// GetMigrationsReverted возвращает число переносов сессий, отменённых
+// из-за неответившего нового адреса
+func (h *Hub) GetMigrationsReverted() uint64 {
+	return atomic.LoadUint64(&h.migrationsReverted)
+}
+
+// roam переоткрывает сокет клиента без признаков поломки (сменилась сеть)
+func (c *GameTunnelClientConn) roam() {
+	c.pathMu.Lock()
+	if atomic.LoadInt32(&c.closed) == 1 {
+		c.pathMu.Unlock()
+		return
+	}
+	old, err := c.swapSocketLocked()
+	c.pathMu.Unlock()
+	if err == nil {
+		c.socketMoved(old)
+	}
+}
+
+// NetworkChanged сообщает о смене сети (уведомление ОС, например
+// ConnectivityManager на Android): клиент сразу переоткрывает сокет,
+// не дожидаясь паузы в приёме
+func (c *GameTunnelClientConn) NetworkChanged() {
+	c.roam()
+}
+
+// NetworkChanged передаёт смену сети всем клиентским соединениям
+// процесса
+func NetworkChanged() {
+	metrics.mu.Lock()
+	clients := make([]*GameTunnelClientConn, 0, len(metrics.clients))
+	for c := range metrics.clients {
+		clients = append(clients, c)
+	}
+	metrics.mu.Unlock()
+
+	for _, c := range clients {
+		c.roam()
+	}
+}
+
+// checkLocalAddr переоткрывает сокет, если маршрут до сервера теперь
+// идёт с другого локального IP
+func (c *GameTunnelClientConn) checkLocalAddr(now time.Time) {
+	last := atomic.LoadInt64(&c.addrCheckedAt)
+	if now.UnixNano()-last < int64(localAddrCheckInterval) ||
+		!atomic.CompareAndSwapInt64(&c.addrCheckedAt, last, now.UnixNano()) {
+		return
+	}
+
+	routed, err := routeLocalIP(c.session().serverAddr)
+	if err != nil {
+		// Сети нет совсем - ждём признаков поломки пути
+		return
+	}
+
+	if !c.socket().conn.LocalAddr().(*net.UDPAddr).IP.Equal(routed) {
+		c.roam()
+	}
+}
+
+// validatePath шлёт серверу PATH_CHALLENGE с текущего сокета
+func (c *GameTunnelClientConn) validatePath() error {
+	var token [pathTokenSize]byte
+	if _, err := rand.Read(token[:]); err != nil {
+		return fmt.Errorf("path token: %w", err)
+	}
+
+	c.pathMu.Lock()
+	c.pathToken = token
+	c.pathChallenged = true
+	c.pathMu.Unlock()
+
+	return c.sendSealedControl(0x07, token[:])
+}
+
+// openControl расшифровывает body sealed-команды сервера
+func (c *GameTunnelClientConn) openControl(session *ClientSession, pkt *Packet, data []byte) ([]byte, bool) {
+	adLen := FlagsSize + VersionSize + int(c.config.ConnectionIdLength)
+	if len(data) < adLen {
+		return nil, false
+	}
+	// Окно replay сдвигает только расшифрованный пакет (deadpeer.go)
+	body, err := session.Keys.Decrypt(pkt.Payload[1:], pkt.PacketNumber, data[:adLen])
+	if err != nil {
+		atomic.AddUint64(&metrics.client.decryptFailures, 1)
+		return nil, false
+	}
+	if session.ReplayWindow != nil && !session.ReplayWindow.Check(pkt.PacketNumber) {
+		return nil, false
+	}
+	c.markAuthenticated()
+	return body, true
+}
+
+// handlePathResponse засчитывает ответ сервера на PATH_CHALLENGE
+func (c *GameTunnelClientConn) handlePathResponse(token []byte) {
+	// Проверка путей multipath (multipath.go)
+	if mp := c.multipath; mp != nil && mp.answered(token, time.Now()) {
+		return
+	}
+	// Регистрация bulk-сокета (classsock.go)
+	if cs := c.classes; cs != nil && cs.answered(token) {
+		return
+	}
+
+	c.pathMu.Lock()
+	matched := c.pathChallenged && subtle.ConstantTimeCompare(token, c.pathToken[:]) == 1
+	if matched {
+		c.pathChallenged = false
+	}
+	c.pathMu.Unlock()
+
+	if matched {
+		atomic.AddUint64(&c.pathValidations, 1)
+	}
+}
+
+// GetPathValidations возвращает число путей, подтверждённых сервером
+// после смены сокета
+func (c *GameTunnelClientConn) GetPathValidations() uint64 {
+	return atomic.LoadUint64(&c.pathValidations)
+}
@@ -0,0 +1,214 @@
+package gametunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// waitPathValidated ждёт ответа сервера на PATH_CHALLENGE клиента
+func waitPathValidated(t *testing.T, client *GameTunnelClientConn) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.GetPathValidations() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Path not validated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newRoamingSession регистрирует сессию с адресом from и возвращает
+// ключи клиента для неё
+func newRoamingSession(t *testing.T, h *Hub, from *net.UDPAddr) (*Session, *SessionKeys) {
+	t.Helper()
+
+	secret := [32]byte{9}
+	serverKeys, _ := DeriveSessionKeys(secret, h.getConfig().Key, false)
+	clientKeys, _ := DeriveSessionKeys(secret, h.getConfig().Key, true)
+	session := h.newSession([]byte{9, 9, 9, 9, 9, 9, 9, 9}, from, serverKeys)
+	h.sessions.put(session)
+	return session, clientKeys
+}
+
+// newSink - UDP-сокет, который принимает пакеты и никогда не отвечает
+func newSink(t *testing.T) *net.UDPConn {
+	t.Helper()
+
+	sink, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sink.Close() })
+	return sink
+}
+
+func TestClientRoamsWithPathValidation(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "roaming"
+	config.ValidateMigration = true
+	l, accepted := startTestListener(t, config)
+	serverObs := &recordingObserver{}
+	l.AddSessionObserver(serverObs)
+
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	client.Write([]byte("wifi"))
+	readWithTimeout(t, server, 4)
+	oldAddr := client.LocalAddr().String()
+
+	// Сеть сменилась: новый сокет, та же сессия
+	client.roam()
+	waitPathValidated(t, client)
+	serverObs.waitEvents(t, "created", "authenticated",
+		"migrated "+oldAddr+" -> "+client.LocalAddr().String())
+
+	// Сервер подтвердил новый адрес своим PATH_CHALLENGE
+	session := l.hub.GetSession(client.session().ConnectionID)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		session.mu.RLock()
+		pending := session.validatedAddr != nil
+		session.mu.RUnlock()
+		if !pending {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Server did not validate the new address")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client.Write([]byte("lte"))
+	if got := readWithTimeout(t, server, 3); string(got) != "lte" {
+		t.Fatalf("Server got %q", got)
+	}
+	server.Write([]byte("back"))
+	if got := readWithTimeout(t, client, 4); string(got) != "back" {
+		t.Fatalf("Client got %q", got)
+	}
+
+	stats := l.hub.GetStats()
+	if stats.Migrations != 1 || stats.MigrationsReverted != 0 {
+		t.Errorf("migrations=%d reverted=%d", stats.Migrations, stats.MigrationsReverted)
+	}
+}
+
+func TestValidateMigrationIgnoresUnauthenticated(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "roaming"
+	config.ValidateMigration = true
+	l, _ := startTestListener(t, config)
+	h := l.hub
+
+	from := newSink(t).LocalAddr().(*net.UDPAddr)
+	session, _ := newRoamingSession(t, h, from)
+
+	// Keep-alive не аутентифицирован - по нему адрес не меняется
+	data, _ := NewKeepAlivePacket(session.ID, 1).Marshal(config)
+	wrapped, _ := h.obfs.Wrap(data)
+	if _, _, err := h.RoutePacket(wrapped, newSink(t).LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	if session.RemoteAddr != from || h.GetStats().Migrations != 0 {
+		t.Errorf("Session moved to %s by an unauthenticated packet", session.RemoteAddr)
+	}
+}
+
+func TestMigrationRevertedWithoutPathResponse(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "roaming"
+	config.ValidateMigration = true
+	l, _ := startTestListener(t, config)
+	h := l.hub
+	obs := &recordingObserver{}
+	h.AddSessionObserver(obs)
+
+	from := newSink(t).LocalAddr().(*net.UDPAddr)
+	session, clientKeys := newRoamingSession(t, h, from)
+
+	// Аутентифицированный пакет с адреса, который на проверку не ответит
+	sink := newSink(t)
+	to := sink.LocalAddr().(*net.UDPAddr)
+	ad := controlAdditionalData(session.ID, 1)
+	sealed, _ := clientKeys.Encrypt([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 1, ad)
+	data, _ := NewControlPacket(session.ID, 1, append([]byte{0x07}, sealed...)).Marshal(config)
+	wrapped, _ := h.obfs.Wrap(data)
+	if _, _, err := h.RoutePacket(wrapped, to); err != nil {
+		t.Fatal(err)
+	}
+	if session.RemoteAddr != to || session.validatedAddr != from {
+		t.Fatalf("addr=%s validated=%s", session.RemoteAddr, session.validatedAddr)
+	}
+
+	// До нового адреса дошли PATH_RESPONSE и PATH_CHALLENGE сервера
+	buf := make([]byte, MaxPacketSize)
+	for i := 0; i < 2; i++ {
+		sink.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := sink.Read(buf); err != nil {
+			t.Fatalf("Packet %d to the new address: %v", i, err)
+		}
+	}
+
+	h.expirePathValidation(session, session.pathToken)
+	if session.RemoteAddr != from {
+		t.Errorf("Session not reverted: %s", session.RemoteAddr)
+	}
+	if h.GetMigrationsReverted() != 1 {
+		t.Errorf("reverted=%d, want 1", h.GetMigrationsReverted())
+	}
+	obs.waitEvents(t, "authenticated",
+		"migrated "+from.String()+" -> "+to.String(),
+		"migrated "+to.String()+" -> "+from.String())
+}
+
+func TestSocketProtectorAndNetworkChanged(t *testing.T) {
+	var protected int32
+	SetSocketProtector(func(fd uintptr) bool {
+		atomic.AddInt32(&protected, 1)
+		return true
+	})
+	t.Cleanup(func() { SetSocketProtector(nil) })
+
+	config := DefaultConfig()
+	config.Key = "protect"
+	l, accepted := startTestListener(t, config)
+	client, server := dialTestClient(t, l, config, accepted)
+	if atomic.LoadInt32(&protected) == 0 {
+		t.Fatal("client socket not protected")
+	}
+
+	// Уведомление о смене сети: новый сокет, тоже защищённый
+	before := atomic.LoadInt32(&protected)
+	oldAddr := client.LocalAddr().String()
+	NetworkChanged()
+	waitPathValidated(t, client)
+	if client.LocalAddr().String() == oldAddr || atomic.LoadInt32(&protected) == before {
+		t.Errorf("network change: socket %s (was %s), protected %d times", client.LocalAddr(), oldAddr, protected)
+	}
+	client.Write([]byte("moved"))
+	if got := readWithTimeout(t, server, 5); string(got) != "moved" {
+		t.Fatalf("server got %q", got)
+	}
+
+	// Отказ защиты - отказ подключения: пакеты ушли бы в туннель
+	SetSocketProtector(func(fd uintptr) bool { return false })
+	addr := l.Addr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	settings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+	if conn, err := Dial(context.Background(), dest, settings); !errors.Is(err, errSocketNotProtected) {
+		if err == nil {
+			conn.Close()
+		}
+		t.Errorf("Dial with refusing protector: %v", err)
+	}
+}
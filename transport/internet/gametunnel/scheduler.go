@@ -0,0 +1,125 @@
+package gametunnel
+
+import (
+	"time"
+)
+
+// ====================================================================
+// Планировщик очереди: strict priority или EDF
+// ====================================================================
+//
+// Strict priority (по умолчанию) всегда отдаёт High, а от
+// голодания Low спасает костыль starvationTimeout. Он же
+// создаёт инверсию: Low, прождавший 500ms, обгоняет Medium.
+//
+// EDF (Earliest Deadline First) - бюджет задержки на класс:
+//   High   - 5ms
+//   Medium - 50ms
+//   Low    - 500ms
+//
+// Пакет получает дедлайн EnqueuedAt + бюджет класса, отправитель
+// берёт пакет с ближайшим дедлайном. Внутри класса бюджет один,
+// поэтому дедлайны в кольце растут монотонно - достаточно
+// сравнить головы трёх очередей, куча не нужна.
+//
+// Включается в конфиге: "scheduler": "edf"
+//
+// ====================================================================
+
+// SchedulerType - алгоритм выбора следующего пакета
+type SchedulerType int32
+
+const (
+	// SchedulerType_PRIORITY - строгий приоритет со starvation guard
+	SchedulerType_PRIORITY SchedulerType = 0
+
+	// SchedulerType_EDF - ближайший дедлайн первым
+	SchedulerType_EDF SchedulerType = 1
+)
+
+const (
+	// Бюджеты задержки классов по умолчанию (миллисекунды)
+	DefaultHighLatencyBudget   = 5
+	DefaultMediumLatencyBudget = 50
+	DefaultLowLatencyBudget    = 500
+)
+
+// defaultLatencyBudgets - бюджеты классов, если в конфиге не заданы
+var defaultLatencyBudgets = [PriorityLevels]time.Duration{
+	PriorityHigh:   DefaultHighLatencyBudget * time.Millisecond,
+	PriorityMedium: DefaultMediumLatencyBudget * time.Millisecond,
+	PriorityLow:    DefaultLowLatencyBudget * time.Millisecond,
+}
+
+// SchedulerTypeFromString парсит строковое значение планировщика
+func SchedulerTypeFromString(s string) SchedulerType {
+	switch s {
+	case "edf", "deadline", "EDF":
+		return SchedulerType_EDF
+	default:
+		return SchedulerType_PRIORITY
+	}
+}
+
+// latencyBudgets возвращает бюджеты задержки классов из конфига
+func latencyBudgets(config *Config) [PriorityLevels]time.Duration {
+	var budgets [PriorityLevels]time.Duration
+	budgets[PriorityHigh] = time.Duration(config.HighLatencyBudget) * time.Millisecond
+	budgets[PriorityMedium] = time.Duration(config.MediumLatencyBudget) * time.Millisecond
+	budgets[PriorityLow] = time.Duration(config.LowLatencyBudget) * time.Millisecond
+	return budgets
+}
+
+// newPriorityQueueFromConfig создаёт очередь с режимом, планировщиком
+// и политикой отбрасывания из конфига
+func newPriorityQueueFromConfig(config *Config) *PriorityQueue {
+	pq := NewPriorityQueue(config.Priority)
+	pq.SetScheduler(config.Scheduler, latencyBudgets(config))
+	pq.SetDropPolicy(config.DropPolicy)
+	return pq
+}
+
+// SetScheduler переключает планировщик очереди
+// Нулевой бюджет класса заменяется значением по умолчанию
+func (pq *PriorityQueue) SetScheduler(scheduler SchedulerType, budgets [PriorityLevels]time.Duration) {
+	for i := range budgets {
+		if budgets[i] <= 0 {
+			budgets[i] = defaultLatencyBudgets[i]
+		}
+	}
+
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.scheduler = scheduler
+	pq.budgets = budgets
+}
+
+// dequeueEDFLocked извлекает пакет с ближайшим дедлайном
+// При равных дедлайнах побеждает более высокий класс
+func (pq *PriorityQueue) dequeueEDFLocked() *PriorityPacket {
+	for {
+		best := -1
+		var bestDeadline time.Time
+
+		for level := range pq.queues {
+			head := pq.queues[level].Peek()
+			if head == nil {
+				continue
+			}
+			if best == -1 || head.Deadline.Before(bestDeadline) {
+				best = level
+				bestDeadline = head.Deadline
+			}
+		}
+
+		if best == -1 {
+			return nil
+		}
+
+		// CoDel мог отбросить весь Low - тогда выбираем заново
+		if pkt := pq.popLocked(PriorityLevel(best)); pkt != nil {
+			return pkt
+		}
+	}
+}
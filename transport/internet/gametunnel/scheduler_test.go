@@ -0,0 +1,74 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+)
+
+// newEDFQueue создаёт очередь EDF с заданными бюджетами
+func newEDFQueue(high, medium, low time.Duration) *PriorityQueue {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+	pq.SetScheduler(SchedulerType_EDF, [PriorityLevels]time.Duration{high, medium, low})
+	return pq
+}
+
+func TestEDFDeadlineOrder(t *testing.T) {
+	pq := newEDFQueue(5*time.Millisecond, 50*time.Millisecond, 500*time.Millisecond)
+
+	pq.EnqueueWithPriority([]byte("low"), PriorityLow, nil)
+	pq.EnqueueWithPriority([]byte("medium"), PriorityMedium, nil)
+	pq.EnqueueWithPriority([]byte("high"), PriorityHigh, nil)
+
+	for _, want := range []string{"high", "medium", "low"} {
+		pkt := pq.Dequeue()
+		if pkt == nil || string(pkt.Data) != want {
+			t.Fatalf("Expected %q, got %v", want, pkt)
+		}
+	}
+}
+
+func TestEDFNoPriorityInversion(t *testing.T) {
+	pq := newEDFQueue(5*time.Millisecond, 50*time.Millisecond, 100*time.Millisecond)
+
+	// Low ждёт дольше starvationTimeout strict-режима
+	// но его дедлайн всё ещё позже дедлайна свежего Medium
+	pq.EnqueueWithPriority([]byte("low"), PriorityLow, nil)
+	time.Sleep(20 * time.Millisecond)
+	pq.EnqueueWithPriority([]byte("medium"), PriorityMedium, nil)
+
+	if pkt := pq.Dequeue(); string(pkt.Data) != "medium" {
+		t.Errorf("Medium should go first, got %q", pkt.Data)
+	}
+}
+
+func TestEDFNoStarvation(t *testing.T) {
+	pq := newEDFQueue(5*time.Millisecond, 50*time.Millisecond, 20*time.Millisecond)
+
+	pq.EnqueueWithPriority([]byte("low"), PriorityLow, nil)
+	time.Sleep(30 * time.Millisecond)
+
+	// Дедлайн Low уже истёк - он уходит раньше свежего High
+	pq.EnqueueWithPriority([]byte("high"), PriorityHigh, nil)
+
+	if pkt := pq.Dequeue(); string(pkt.Data) != "low" {
+		t.Errorf("Overdue Low should go first, got %q", pkt.Data)
+	}
+}
+
+func TestSchedulerFromConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Scheduler = SchedulerTypeFromString("edf")
+	config.MediumLatencyBudget = 0
+
+	pq := newPriorityQueueFromConfig(config)
+	if pq.scheduler != SchedulerType_EDF {
+		t.Error("'edf' should enable EDF scheduler")
+	}
+	if pq.budgets[PriorityMedium] != DefaultMediumLatencyBudget*time.Millisecond {
+		t.Errorf("Zero budget should fall back to default, got %v", pq.budgets[PriorityMedium])
+	}
+
+	if SchedulerTypeFromString("") != SchedulerType_PRIORITY {
+		t.Error("Default scheduler should be PRIORITY")
+	}
+}
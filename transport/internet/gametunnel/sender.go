@@ -0,0 +1,84 @@
+package gametunnel
+
+import (
+	"sync/atomic"
+)
+
+// ====================================================================
+// Отправитель на сессию (sessionSenders)
+// ====================================================================
+//
+// По умолчанию пакеты всех сессий выводит на провод один sendLoop
+// хаба, обходя очереди по кругу (session_queue.go). Это честно
+// делит канал, но всё, что задерживает отправителя, задерживает
+// всех: ожидание лимита скорости одной сессии, блокировка записи
+// в сокет, всплеск от одного потока xray.
+//
+// С sessionSenders = true у каждой сессии своя горутина
+// отправки: она разбирает очередь сессии (ограниченную
+// queueSize), ждёт лимиты и пишет в сокет сама, не мешая
+// остальным. Писатели xray (SendToSession) только шифруют и
+// ставят пакет в очередь.
+//
+// Переполнение Low в этом режиме вытесняет самый старый пакет
+// класса (как dropPolicy = head): в отстающей очереди старое
+// состояние уже не нужно. High и Medium следуют dropPolicy.
+//
+// Цена - горутина на сессию и отказ от честного круга: деление
+// канала между сессиями остаётся планировщику Go и ядру.
+//
+// ====================================================================
+
+// startSender запускает горутину отправки сессии (sessionSenders)
+// Горутина завершается, когда Session.Close закрывает очередь
+func (h *Hub) startSender(session *Session) {
+	if !h.getConfig().SessionSenders {
+		return
+	}
+
+	session.queue.SetLowDropHead(true)
+	atomic.StoreInt32(&session.ownSender, 1)
+
+	h.senders.Add(1)
+	go func() {
+		defer h.senders.Done()
+		goLabeled("hub.session-send", func() { h.sessionSendLoop(session) })
+	}()
+}
+
+// sessionSendLoop выводит на провод очередь одной сессии
+func (h *Hub) sessionSendLoop(session *Session) {
+	for {
+		pkt := session.queue.DequeueBlocking()
+		if pkt == nil {
+			return
+		}
+		metrics.serverLatency.sample(pkt)
+
+		session.mu.RLock()
+		limiter := session.limiter
+		session.mu.RUnlock()
+
+		classLimiters := h.classLimiters.Load()
+		wait, ok := admitPacket(h.getConfig().RateLimitPolicy, len(pkt.Data), limiter, classLimiters[pkt.Priority])
+		if !ok {
+			atomic.AddUint64(&h.rateLimited, 1)
+			continue
+		}
+		if pace := h.pacer.delay(len(pkt.Data), pkt.Priority, session.timestamps); pace > wait {
+			wait = pace
+		}
+		if wait > 0 && !sleepContext(h.ctx, wait) {
+			return
+		}
+
+		n, err := h.writeToSession(session, pkt.Data, pkt.Priority)
+		if err != nil {
+			continue
+		}
+		if pkt.Duplicate {
+			n += h.writeCopy(session, pkt.Data, pkt.Priority)
+		}
+		h.bandwidth.RecordBytes(uint64(n))
+	}
+}
@@ -0,0 +1,72 @@
+package gametunnel
+
+import (
+	"bytes"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLowDropHead(t *testing.T) {
+	pq := NewPriorityQueue(PriorityMode_GAMING)
+	pq.SetLowDropHead(true)
+
+	for i := 0; i < LowQueueSize; i++ {
+		pq.EnqueueWithPriority([]byte{byte(i)}, PriorityLow, nil)
+	}
+	if !pq.EnqueueWithPriority([]byte{0xFF}, PriorityLow, nil) {
+		t.Fatal("Low overflow should evict the oldest packet")
+	}
+	if pkt := pq.Dequeue(); pkt.Data[0] != 1 {
+		t.Errorf("Oldest Low packet should be dropped, head is %d", pkt.Data[0])
+	}
+
+	// Medium по-прежнему tail-drop
+	for i := 0; i < MediumQueueSize; i++ {
+		pq.EnqueueWithPriority([]byte{byte(i)}, PriorityMedium, nil)
+	}
+	if pq.EnqueueWithPriority([]byte{0xFF}, PriorityMedium, nil) {
+		t.Error("Medium overflow should follow dropPolicy")
+	}
+}
+
+func TestSessionSendersIsolateSlowSession(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	config := DefaultConfig()
+	config.Key = "senders"
+	config.SessionSenders = true
+	config.RateLimitPolicy = RateLimitPolicy_QUEUE
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+
+	slowClient, slowServer := dialTestClient(t, l, &clientConfig, accepted)
+	fastClient, fastServer := dialTestClient(t, l, &clientConfig, accepted)
+
+	// Медленная сессия: 1 КБ/с, политика queue - её отправитель
+	// ждёт лимита ~0.7с на каждом пакете. С общим sendLoop это
+	// ожидание задержало бы и быструю сессию
+	slow := slowServer.(*GameTunnelConn).session
+	if err := l.hub.SetSessionRateLimit(slow.ID, 1024, 1024); err != nil {
+		t.Fatal(err)
+	}
+	chunk := bytes.Repeat([]byte{1}, 700)
+	for i := 0; i < 32; i++ {
+		slowServer.Write(chunk)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	fastServer.Write([]byte("fast"))
+	if got := readWithTimeout(t, fastClient, 4); string(got) != "fast" {
+		t.Fatalf("Fast session got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Fast session stalled behind slow one: %v", elapsed)
+	}
+
+	slowClient.Close()
+	fastClient.Close()
+	l.Close()
+	waitGoroutines(t, before)
+}
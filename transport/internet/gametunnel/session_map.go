@@ -0,0 +1,143 @@
+package gametunnel
+
+import (
+	"sync"
+)
+
+// ====================================================================
+// Шардированная карта сессий
+// ====================================================================
+//
+// Каждый входящий пакет ищет сессию по Connection ID. Раньше ключ
+// строился через fmt.Sprintf("%x") (аллокация на пакет), а вся
+// карта была под одним RWMutex хаба (точка конкуренции).
+//
+// Теперь:
+//   - ключ - сами байты Connection ID: поиск m[string(connID)]
+//     компилятор выполняет без аллокации
+//   - карта разбита на sessionShards шардов со своими мьютексами;
+//     шард выбирается хэшем FNV-1a от Connection ID
+//
+// ====================================================================
+
+// sessionShards - количество шардов (степень двойки)
+const sessionShards = 64
+
+// sessionShard - один шард карты сессий
+type sessionShard struct {
+	sessions map[string]*Session
+	mu       sync.RWMutex
+}
+
+// sessionMap - карта Connection ID → Session
+type sessionMap struct {
+	shards [sessionShards]sessionShard
+}
+
+// newSessionMap создаёт пустую карту
+func newSessionMap() *sessionMap {
+	m := &sessionMap{}
+	for i := range m.shards {
+		m.shards[i].sessions = make(map[string]*Session)
+	}
+	return m
+}
+
+// shard выбирает шард по Connection ID
+func (m *sessionMap) shard(connID []byte) *sessionShard {
+	return &m.shards[connIDHash(connID)&(sessionShards-1)]
+}
+
+// connIDHash - хэш Connection ID (FNV-1a)
+func connIDHash(connID []byte) uint32 {
+	h := uint32(2166136261)
+	for _, b := range connID {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
+
+// get возвращает сессию или nil
+func (m *sessionMap) get(connID []byte) *Session {
+	sh := m.shard(connID)
+	sh.mu.RLock()
+	session := sh.sessions[string(connID)]
+	sh.mu.RUnlock()
+	return session
+}
+
+// put регистрирует сессию под её Connection ID
+func (m *sessionMap) put(session *Session) {
+	sh := m.shard(session.ID)
+	sh.mu.Lock()
+	sh.sessions[string(session.ID)] = session
+	sh.mu.Unlock()
+}
+
+// putIfAbsent регистрирует сессию, если Connection ID ещё свободен
+// Иначе возвращает уже зарегистрированную сессию и false
+func (m *sessionMap) putIfAbsent(session *Session) (*Session, bool) {
+	sh := m.shard(session.ID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if existing, ok := sh.sessions[string(session.ID)]; ok {
+		return existing, false
+	}
+	sh.sessions[string(session.ID)] = session
+	return session, true
+}
+
+// remove удаляет сессию и возвращает её (nil, если не было)
+func (m *sessionMap) remove(connID []byte) *Session {
+	sh := m.shard(connID)
+	sh.mu.Lock()
+	session, exists := sh.sessions[string(connID)]
+	if exists {
+		delete(sh.sessions, string(connID))
+	}
+	sh.mu.Unlock()
+	return session
+}
+
+// snapshot возвращает все сессии на момент вызова
+func (m *sessionMap) snapshot() []*Session {
+	var sessions []*Session
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for _, session := range sh.sessions {
+			sessions = append(sessions, session)
+		}
+		sh.mu.RUnlock()
+	}
+	return sessions
+}
+
+// drain удаляет и возвращает все сессии
+func (m *sessionMap) drain() []*Session {
+	var sessions []*Session
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.Lock()
+		for key, session := range sh.sessions {
+			sessions = append(sessions, session)
+			delete(sh.sessions, key)
+		}
+		sh.mu.Unlock()
+	}
+	return sessions
+}
+
+// len возвращает количество сессий
+func (m *sessionMap) len() int {
+	n := 0
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		n += len(sh.sessions)
+		sh.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,151 @@
+package gametunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// benchSessions - число сессий в бенчмарках поиска
+const benchSessions = 4096
+
+// newMapSession создаёт сессию с 8-байтовым Connection ID
+func newMapSession(n uint64) *Session {
+	id := make([]byte, 8)
+	binary.BigEndian.PutUint64(id, n)
+	return &Session{ID: id, Streams: make(map[uint16]*Stream), inbound: newInboundRing(0)}
+}
+
+func TestSessionMapBasic(t *testing.T) {
+	m := newSessionMap()
+
+	for i := uint64(0); i < 1000; i++ {
+		m.put(newMapSession(i))
+	}
+	if n := m.len(); n != 1000 {
+		t.Fatalf("len: got %d, want 1000", n)
+	}
+
+	probe := newMapSession(42)
+	if s := m.get(probe.ID); s == nil || string(s.ID) != string(probe.ID) {
+		t.Fatal("Session 42 not found")
+	}
+	if s := m.get([]byte("missing!")); s != nil {
+		t.Fatal("Unexpected session for unknown ID")
+	}
+
+	if s := m.remove(probe.ID); s == nil {
+		t.Fatal("remove should return the session")
+	}
+	if s := m.remove(probe.ID); s != nil {
+		t.Fatal("Second remove should return nil")
+	}
+	if len(m.snapshot()) != 999 {
+		t.Errorf("snapshot: got %d sessions, want 999", len(m.snapshot()))
+	}
+
+	if drained := m.drain(); len(drained) != 999 || m.len() != 0 {
+		t.Errorf("drain: got %d sessions, %d left", len(drained), m.len())
+	}
+}
+
+func TestSessionMapShardSpread(t *testing.T) {
+	m := newSessionMap()
+	for i := uint64(0); i < benchSessions; i++ {
+		m.put(newMapSession(i))
+	}
+
+	// Последовательные ID не должны скапливаться в одном шарде
+	for i := range m.shards {
+		if n := len(m.shards[i].sessions); n == 0 || n > 4*benchSessions/sessionShards {
+			t.Errorf("Shard %d holds %d sessions", i, n)
+		}
+	}
+}
+
+func TestHubRemoveSessionSharded(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+
+	s := newMapSession(7)
+	h.sessions.put(s)
+	h.activeSessions = 1
+
+	if h.GetSession(s.ID) != s {
+		t.Fatal("GetSession did not find the session")
+	}
+	h.RemoveSession(s.ID)
+	if h.GetSession(s.ID) != nil || h.activeSessions != 0 {
+		t.Errorf("Session not removed: active=%d", h.activeSessions)
+	}
+}
+
+func TestSessionMapLookupNoAlloc(t *testing.T) {
+	m := newSessionMap()
+	s := newMapSession(1)
+	m.put(s)
+
+	// Connection ID приходит срезом из буфера пакета
+	packet := append([]byte{0x40, 0x01}, s.ID...)
+	connID := packet[2:]
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		m.get(connID)
+	})
+	if allocs != 0 {
+		t.Errorf("Lookup allocates: %.1f allocs/op", allocs)
+	}
+}
+
+// BenchmarkSessionMapLookup - параллельный поиск сессий, как в
+// RoutePacket: тысячи сессий, поиск на каждый входящий пакет
+func BenchmarkSessionMapLookup(b *testing.B) {
+	m := newSessionMap()
+	ids := make([][]byte, benchSessions)
+	for i := range ids {
+		s := newMapSession(uint64(i))
+		m.put(s)
+		ids[i] = s.ID
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if m.get(ids[i%benchSessions]) == nil {
+				b.Fatal("session not found")
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkSessionMapLookupLegacy - прежняя схема для сравнения:
+// hex-ключ через fmt.Sprintf и один RWMutex на всю карту
+func BenchmarkSessionMapLookupLegacy(b *testing.B) {
+	var mu sync.RWMutex
+	sessions := make(map[string]*Session)
+	ids := make([][]byte, benchSessions)
+	for i := range ids {
+		s := newMapSession(uint64(i))
+		sessions[fmt.Sprintf("%x", s.ID)] = s
+		ids[i] = s.ID
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := fmt.Sprintf("%x", ids[i%benchSessions])
+			mu.RLock()
+			s := sessions[key]
+			mu.RUnlock()
+			if s == nil {
+				b.Fatal("session not found")
+			}
+			i++
+		}
+	})
+}
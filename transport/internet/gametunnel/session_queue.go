@@ -0,0 +1,192 @@
+package gametunnel
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Очереди сессий и round-robin отправка
+// ====================================================================
+//
+// С одной общей очередью Low-трафик тяжёлой сессии задерживает
+// Medium-трафик соседней, а честное деление канала невозможно.
+//
+// Поэтому у каждой Session своя PriorityQueue, а sendLoop хаба
+// обходит сессии по кругу: за один заход сессия отправляет один
+// пакет (самый приоритетный в своей очереди) и, если у неё
+// остались пакеты, встаёт в конец круга.
+//
+// В круге только сессии с непустой очередью - флаг Session.scheduled
+// не даёт поставить сессию дважды.
+//
+// Сессия, пакет которой ждёт токенов лимита скорости (политика
+// queue) или паузы pacing, уходит из круга вместе с пакетом в
+// parkedSessions до момента готовности. Отправитель тем временем
+// обслуживает остальных, а пакеты отложенной сессии не обгоняют
+// отложенный.
+//
+// ====================================================================
+
+// sessionRoundRobin - круг сессий, ожидающих отправки
+type sessionRoundRobin struct {
+	// ready - сессии с непустой очередью в порядке обхода
+	ready []*Session
+
+	// notify - сигнал отправителю о новой сессии в круге (буфер 1)
+	notify chan struct{}
+
+	// done - закрывается при close, будит next
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu sync.Mutex
+}
+
+// newSessionRoundRobin создаёт пустой круг
+func newSessionRoundRobin() *sessionRoundRobin {
+	return &sessionRoundRobin{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// schedule ставит сессию в конец круга, если её там ещё нет
+func (rr *sessionRoundRobin) schedule(session *Session) {
+	if !atomic.CompareAndSwapInt32(&session.scheduled, 0, 1) {
+		return
+	}
+
+	rr.mu.Lock()
+	rr.ready = append(rr.ready, session)
+	rr.mu.Unlock()
+
+	select {
+	case rr.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next возвращает следующую сессию круга, блокируясь при пустом круге
+// После close возвращает nil
+func (rr *sessionRoundRobin) next() *Session {
+	session, _ := rr.nextUntil(nil)
+	return session
+}
+
+// nextUntil - next, который при пустом круге ждёт не дольше сигнала
+// wake (nil - без срока) и тогда возвращает nil
+// false - круг закрыт
+func (rr *sessionRoundRobin) nextUntil(wake <-chan time.Time) (*Session, bool) {
+	for {
+		if session := rr.tryNext(); session != nil {
+			return session, true
+		}
+
+		select {
+		case <-rr.notify:
+		case <-wake:
+			return nil, true
+		case <-rr.done:
+			return nil, false
+		}
+	}
+}
+
+// tryNext возвращает следующую сессию круга без ожидания
+// nil - круг пуст
+func (rr *sessionRoundRobin) tryNext() *Session {
+	rr.mu.Lock()
+	defer rr.mu.Unlock()
+
+	if len(rr.ready) == 0 {
+		return nil
+	}
+	session := rr.ready[0]
+	rr.ready[0] = nil
+	rr.ready = rr.ready[1:]
+	return session
+}
+
+// close останавливает круг: next перестаёт ждать и возвращает nil
+func (rr *sessionRoundRobin) close() {
+	rr.closeOnce.Do(func() {
+		close(rr.done)
+	})
+}
+
+// dequeueSession извлекает один пакет сессии и возвращает её в круг,
+// если в очереди ещё что-то осталось
+func (rr *sessionRoundRobin) dequeueSession(session *Session) *PriorityPacket {
+	pkt := rr.take(session)
+	rr.requeue(session)
+	return pkt
+}
+
+// take извлекает один пакет сессии, не возвращая её в круг: до
+// requeue сессия помечена scheduled и в круг не встанет
+func (rr *sessionRoundRobin) take(session *Session) *PriorityPacket {
+	return session.queue.Dequeue()
+}
+
+// requeue возвращает сессию в круг, если в очереди что-то осталось
+func (rr *sessionRoundRobin) requeue(session *Session) {
+	// Сбрасываем флаг до проверки длины: пакет, поставленный
+	// между Dequeue и сбросом, увидит Len ниже
+	atomic.StoreInt32(&session.scheduled, 0)
+	if session.queue.Len() > 0 {
+		rr.schedule(session)
+	}
+}
+
+// parkedSession - сессия вне круга с пакетом, ждущим токенов лимита
+// или паузы pacing
+type parkedSession struct {
+	session *Session
+	pkt     *PriorityPacket
+	ready   time.Time
+
+	// paced - пакет ждёт паузу pacing: токены pacer уже взяты
+	paced bool
+}
+
+// parkedSessions - отложенные сессии по времени готовности (куча)
+// Принадлежит sendLoop хаба, блокировки не нужны
+type parkedSessions []parkedSession
+
+func (p parkedSessions) Len() int           { return len(p) }
+func (p parkedSessions) Less(i, j int) bool { return p[i].ready.Before(p[j].ready) }
+func (p parkedSessions) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p *parkedSessions) Push(x any)        { *p = append(*p, x.(parkedSession)) }
+func (p *parkedSessions) Pop() any {
+	old := *p
+	last := old[len(old)-1]
+	old[len(old)-1] = parkedSession{}
+	*p = old[:len(old)-1]
+	return last
+}
+
+// park откладывает сессию с пакетом pkt до ready
+// paced - пауза pacing, а не лимита скорости
+func (p *parkedSessions) park(session *Session, pkt *PriorityPacket, ready time.Time, paced bool) {
+	heap.Push(p, parkedSession{session: session, pkt: pkt, ready: ready, paced: paced})
+}
+
+// until - сколько осталось до готовности ближайшей отложенной сессии
+// false - отложенных нет
+func (p parkedSessions) until(now time.Time) (time.Duration, bool) {
+	if len(p) == 0 {
+		return 0, false
+	}
+	return p[0].ready.Sub(now), true
+}
+
+// popReady извлекает отложенную сессию, время которой пришло
+func (p *parkedSessions) popReady(now time.Time) (parkedSession, bool) {
+	if len(*p) == 0 || (*p)[0].ready.After(now) {
+		return parkedSession{}, false
+	}
+	return heap.Pop(p).(parkedSession), true
+}
@@ -0,0 +1,93 @@
+package gametunnel
+
+import (
+	"testing"
+	"time"
+)
+
+// newQueuedSession создаёт сессию с собственной очередью для тестов круга
+func newQueuedSession(id byte) *Session {
+	return &Session{
+		ID:      []byte{id},
+		Streams: make(map[uint16]*Stream),
+		queue:   NewPriorityQueue(PriorityMode_GAMING),
+	}
+}
+
+func TestSessionRoundRobinFairness(t *testing.T) {
+	rr := newSessionRoundRobin()
+	heavy := newQueuedSession(1)
+	light := newQueuedSession(2)
+
+	// Тяжёлая сессия забила свою очередь загрузкой
+	for i := 0; i < 10; i++ {
+		heavy.queue.EnqueueWithPriority([]byte("bulk"), PriorityLow, heavy)
+		rr.schedule(heavy)
+	}
+	light.queue.EnqueueWithPriority([]byte("web"), PriorityMedium, light)
+	rr.schedule(light)
+
+	var order []byte
+	for i := 0; i < 11; i++ {
+		session := rr.next()
+		if pkt := rr.dequeueSession(session); pkt != nil {
+			order = append(order, pkt.Session.ID[0])
+		}
+	}
+
+	if len(order) != 11 {
+		t.Fatalf("Expected 11 packets, got %d", len(order))
+	}
+	// Лёгкая сессия ждёт не больше одного пакета соседа
+	if order[1] != 2 {
+		t.Errorf("Light session should be served second, order: %v", order)
+	}
+	if heavy.queue.Len() != 0 || light.queue.Len() != 0 {
+		t.Error("All queues should be drained")
+	}
+}
+
+func TestSessionRoundRobinNoDuplicates(t *testing.T) {
+	rr := newSessionRoundRobin()
+	s := newQueuedSession(1)
+
+	s.queue.EnqueueWithPriority([]byte("a"), PriorityHigh, s)
+	rr.schedule(s)
+	rr.schedule(s)
+
+	rr.mu.Lock()
+	n := len(rr.ready)
+	rr.mu.Unlock()
+	if n != 1 {
+		t.Errorf("Session should be scheduled once, got %d entries", n)
+	}
+}
+
+func TestSessionRoundRobinClose(t *testing.T) {
+	rr := newSessionRoundRobin()
+
+	done := make(chan *Session, 1)
+	go func() {
+		done <- rr.next()
+	}()
+
+	rr.close()
+	select {
+	case s := <-done:
+		if s != nil {
+			t.Error("next should return nil after close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("next did not unblock on close")
+	}
+}
+
+func TestSessionStatsQueueDepth(t *testing.T) {
+	s := newQueuedSession(1)
+	s.queue.EnqueueWithPriority([]byte("a"), PriorityHigh, s)
+	s.queue.EnqueueWithPriority([]byte("b"), PriorityLow, s)
+
+	if got := s.GetStats().QueueDepth; got != 2 {
+		t.Errorf("QueueDepth: got %d, want 2", got)
+	}
+}
@@ -0,0 +1,267 @@
+package gametunnel
+
+import (
+	"os"
+	"sync"
+)
+
+// ====================================================================
+// Настройки xray (config.proto) -> рабочий Config
+// ====================================================================
+//
+// xray хранит настройки транспорта в streamSettings как protobuf
+// (TransportSettings, TypedMessage) и отдаёт их Dial и Listen в
+// MemoryStreamConfig.ProtocolSettings. Settings - это сообщение из
+// config.proto, его собирает из JSON infra/conf (GameTunnelConfig):
+// режимы там - строки, как в JSON ("quic", "gaming").
+//
+// Транспорт работает с Config: режимы уже разобраны в перечисления,
+// правила фильтра - IPRule. ToConfig строит его из Settings:
+// незаданные (нулевые) поля берутся из профиля (profile.go, без
+// профиля - DefaultConfig). Значения
+// проверяет Validate при Dial и Listen: недопустимое значение -
+// ошибка запуска (или исправление с lenient).
+//
+// Поверх Settings накладываются переменные GAMETUNNEL_* (env.go).
+//
+// Config одних Settings строится один раз и кэшируется: пул общих
+// сессий (mux.go) различает outbound-ы по указателю на Config.
+//
+// Готовый *Config в ProtocolSettings (тесты, автономный клиент)
+// используется как есть.
+//
+// ====================================================================
+
+// settingsConfigs - Config, построенные из Settings (*Settings -> *Config)
+// Settings живут, пока жив конфиг xray, поэтому записи не удаляются
+var settingsConfigs sync.Map
+
+// ToConfig строит рабочий Config из настроек xray
+func (s *Settings) ToConfig() *Config {
+	config := ProfileConfig(ProfileFromString(s.Profile))
+	if s.Obfuscation != "" {
+		config.Obfuscation = ObfuscationModeFromString(s.Obfuscation)
+	}
+	if s.Priority != "" {
+		config.Priority = PriorityModeFromString(s.Priority)
+	}
+	if s.Mtu > 0 {
+		config.MTU = s.Mtu
+	}
+	if s.MaxStreams > 0 {
+		config.MaxStreams = s.MaxStreams
+	}
+	if s.ConnectionIdLength > 0 {
+		config.ConnectionIdLength = s.ConnectionIdLength
+	}
+	if s.EnablePadding != nil {
+		config.EnablePadding = *s.EnablePadding
+	}
+	if s.PaddingMinSize > 0 {
+		config.PaddingMinSize = s.PaddingMinSize
+	}
+	if s.PaddingMaxSize > 0 {
+		config.PaddingMaxSize = s.PaddingMaxSize
+	}
+	if s.HandshakeTimeout > 0 {
+		config.HandshakeTimeout = s.HandshakeTimeout
+	}
+	if s.KeepAliveInterval > 0 {
+		config.KeepAliveInterval = s.KeepAliveInterval
+	}
+	config.Key = s.Key
+	config.EnableDscp = s.EnableDscp
+	if s.DscpHigh != nil {
+		config.DscpHigh = *s.DscpHigh
+	}
+	if s.DscpMedium != nil {
+		config.DscpMedium = *s.DscpMedium
+	}
+	if s.DscpLow != nil {
+		config.DscpLow = *s.DscpLow
+	}
+	config.SessionRateLimit = s.SessionRateLimit
+	config.SessionRateBurst = s.SessionRateBurst
+	config.HighRateLimit = s.HighRateLimit
+	config.MediumRateLimit = s.MediumRateLimit
+	config.LowRateLimit = s.LowRateLimit
+	if s.RateLimitPolicy != "" {
+		config.RateLimitPolicy = RateLimitPolicyFromString(s.RateLimitPolicy)
+	}
+	if s.Classifier != "" {
+		config.Classifier = ClassifierTypeFromString(s.Classifier)
+	}
+	if s.Scheduler != "" {
+		config.Scheduler = SchedulerTypeFromString(s.Scheduler)
+	}
+	if s.HighLatencyBudget > 0 {
+		config.HighLatencyBudget = s.HighLatencyBudget
+	}
+	if s.MediumLatencyBudget > 0 {
+		config.MediumLatencyBudget = s.MediumLatencyBudget
+	}
+	if s.LowLatencyBudget > 0 {
+		config.LowLatencyBudget = s.LowLatencyBudget
+	}
+	config.EnablePacing = s.EnablePacing
+	config.PacingRate = s.PacingRate
+	if s.DropPolicy != "" {
+		config.DropPolicy = DropPolicyFromString(s.DropPolicy)
+	}
+	config.MaxSessionsPerIp = s.MaxSessionsPerIp
+	config.HandshakesPerMinute = s.HandshakesPerMinute
+	config.IpBanDuration = s.IpBanDuration
+	config.AllowIps = ipRulesFromSettings(s.AllowIps)
+	config.DenyIps = ipRulesFromSettings(s.DenyIps)
+	config.SessionSnapshotPath = s.SessionSnapshotPath
+	config.SessionSnapshotInterval = s.SessionSnapshotInterval
+	config.MetricsListen = s.MetricsListen
+	config.ApiListen = s.ApiListen
+	config.ApiToken = s.ApiToken
+	config.QuotaBytes = s.QuotaBytes
+	if s.QuotaAction != "" {
+		config.QuotaAction = QuotaActionFromString(s.QuotaAction)
+	}
+	config.QuotaThrottleRate = s.QuotaThrottleRate
+	config.QuotaFlushInterval = s.QuotaFlushInterval
+	config.DeadPeerInterval = s.DeadPeerInterval
+	config.DeadPeerProbes = s.DeadPeerProbes
+	config.ExtraListen = s.ExtraListen
+	config.ReceiveSockets = s.ReceiveSockets
+	config.MaxSessions = s.MaxSessions
+	config.MemoryBudgetMb = s.MemoryBudgetMb
+	if s.OverloadPolicy != "" {
+		config.OverloadPolicy = OverloadPolicyFromString(s.OverloadPolicy)
+	}
+	config.OverloadRetryAfter = s.OverloadRetryAfter
+	config.SessionSenders = s.SessionSenders
+	config.DecryptWorkers = s.DecryptWorkers
+	config.BlackholeThreshold = s.BlackholeThreshold
+	config.IssueConnectionIds = s.IssueConnectionIds
+	config.ReconnectAttempts = s.ReconnectAttempts
+	config.ReconnectBackoff = s.ReconnectBackoff
+	config.ReconnectMaxBackoff = s.ReconnectMaxBackoff
+	config.ValidateMigration = s.ValidateMigration
+	config.Multipath = s.Multipath
+	config.MultipathLocalAddr = s.MultipathLocalAddr
+	config.DontFragment = s.DontFragment
+	config.Endpoints = s.Endpoints
+	config.SharedSession = s.SharedSession
+	config.EarlyData = s.EarlyData
+	config.MtuProbe = s.MtuProbe
+	config.Resolver = s.Resolver
+	if s.ResolveStrategy != "" {
+		config.ResolveStrategy = ResolveStrategyFromString(s.ResolveStrategy)
+	}
+	config.CoalesceWindow = s.CoalesceWindow
+	config.AppStreams = s.AppStreams
+	config.InboundWait = s.InboundWait
+	config.EncryptWorkers = s.EncryptWorkers
+	if s.SendBatch > 0 {
+		config.SendBatch = s.SendBatch
+	}
+	if s.ChaffBudget > 0 {
+		config.ChaffBudget = s.ChaffBudget
+	}
+	if s.ReadBufferSize > 0 {
+		config.ReadBufferSize = s.ReadBufferSize
+	}
+	if s.WriteBufferSize > 0 {
+		config.WriteBufferSize = s.WriteBufferSize
+	}
+	config.Ttl = s.Ttl
+	config.Dscp = s.Dscp
+	config.BindInterface = s.BindInterface
+	config.Fwmark = s.Fwmark
+	config.PortRange = s.PortRange
+	if s.HandshakeMinSize > 0 {
+		config.HandshakeMinSize = s.HandshakeMinSize
+	}
+	if s.HandshakeMaxSize > 0 {
+		config.HandshakeMaxSize = s.HandshakeMaxSize
+	}
+	config.CaptureFile = s.CaptureFile
+	config.CapturePlaintext = s.CapturePlaintext
+	config.TracingEndpoint = s.TracingEndpoint
+	config.DebugListen = s.DebugListen
+	config.AuditLog = s.AuditLog
+	if s.AuditIp != "" {
+		config.AuditIp = AuditIPModeFromString(s.AuditIp)
+	}
+	config.AuditSalt = s.AuditSalt
+	config.AuditMaxSize = s.AuditMaxSize
+	config.AuditMaxFiles = s.AuditMaxFiles
+	config.SocketActivation = s.SocketActivation
+	config.ListenFd = s.ListenFd
+	config.UpgradeSocket = s.UpgradeSocket
+	config.CompactHeaders = s.CompactHeaders
+	config.Duplicate = s.Duplicate
+	config.PuzzleRate = s.PuzzleRate
+	config.Timestamps = s.Timestamps
+	config.HubGroup = s.HubGroup
+	if s.PaddingBackoff != "" {
+		config.PaddingBackoff = PaddingBackoffFromString(s.PaddingBackoff)
+	}
+	config.PaddingBandwidth = s.PaddingBandwidth
+	config.KeepAliveTune = s.KeepAliveTune
+	config.KeepAliveMax = s.KeepAliveMax
+	config.ClusterListen = s.ClusterListen
+	config.ClusterPeers = s.ClusterPeers
+	config.ClusterNode = s.ClusterNode
+	config.ClusterInterval = s.ClusterInterval
+	config.LbServerId = s.LbServerId
+	config.LbConfigId = s.LbConfigId
+	config.RecvBatch = s.RecvBatch
+	config.ClassSockets = s.ClassSockets
+	config.HighWriteBufferSize = s.HighWriteBufferSize
+	config.BulkWriteBufferSize = s.BulkWriteBufferSize
+	config.Users = usersFromSettings(s.Users)
+	config.UserKey = s.UserKey
+	config.Lenient = s.Lenient
+	return config
+}
+
+// ipRulesFromSettings переносит правила фильтра источников
+func ipRulesFromSettings(rules []*Settings_IPRule) []*IPRule {
+	if len(rules) == 0 {
+		return nil
+	}
+	out := make([]*IPRule, 0, len(rules))
+	for _, rule := range rules {
+		out = append(out, &IPRule{Name: rule.Name, GeoIP: rule.Geoip})
+	}
+	return out
+}
+
+// usersFromSettings переносит пользователей с личными ключами
+func usersFromSettings(users []*Settings_User) []*User {
+	if len(users) == 0 {
+		return nil
+	}
+	out := make([]*User, 0, len(users))
+	for _, u := range users {
+		out = append(out, &User{Name: u.Name, Key: u.Key})
+	}
+	return out
+}
+
+// configFromStream возвращает Config из ProtocolSettings streamSettings
+// Settings - Config из кэша с переопределениями окружения (env.go),
+// *Config - как есть, иначе DefaultConfig
+func configFromStream(settings any) (*Config, error) {
+	switch s := settings.(type) {
+	case *Config:
+		return s, nil
+	case *Settings:
+		if config, ok := settingsConfigs.Load(s); ok {
+			return config.(*Config), nil
+		}
+		config := s.ToConfig()
+		if err := config.ApplyEnv(os.LookupEnv); err != nil {
+			return nil, err
+		}
+		cached, _ := settingsConfigs.LoadOrStore(s, config)
+		return cached.(*Config), nil
+	}
+	return DefaultConfig(), nil
+}
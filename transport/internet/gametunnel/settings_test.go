@@ -0,0 +1,120 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/router"
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+func TestSettingsToConfig(t *testing.T) {
+	dscpLow := uint32(0)
+	settings := &Settings{
+		Obfuscation:    "webrtc",
+		Priority:       "streaming",
+		Mtu:            1280,
+		PaddingMaxSize: 100,
+		DscpLow:        &dscpLow,
+		Scheduler:      "edf",
+		AllowIps:       []*Settings_IPRule{{Name: "10.0.0.0/8", Geoip: &router.GeoIP{}}},
+		SendBatch:      MaxSendBatch * 2,
+	}
+
+	config := settings.ToConfig()
+	defaults := DefaultConfig()
+	if config.Obfuscation != ObfuscationMode_WEBRTC_MIMIC || config.Priority != PriorityMode_STREAMING {
+		t.Errorf("modes %v %v", config.Obfuscation, config.Priority)
+	}
+	if config.MTU != 1280 || config.PaddingMinSize != defaults.PaddingMinSize || config.PaddingMaxSize != 100 {
+		t.Errorf("mtu %d, padding %d-%d", config.MTU, config.PaddingMinSize, config.PaddingMaxSize)
+	}
+	if config.Scheduler != SchedulerType_EDF || config.DropPolicy != defaults.DropPolicy {
+		t.Errorf("scheduler %v, drop policy %v", config.Scheduler, config.DropPolicy)
+	}
+
+	// Заданный 0 - значение, незаданный - по умолчанию
+	if config.DscpLow != 0 || config.DscpHigh != DefaultDscpHigh {
+		t.Errorf("dscp high %d, low %d", config.DscpHigh, config.DscpLow)
+	}
+	if len(config.AllowIps) != 1 || config.AllowIps[0].Name != "10.0.0.0/8" || config.AllowIps[0].GeoIP == nil {
+		t.Errorf("allow rules %+v", config.AllowIps)
+	}
+	if err := config.Validate(); err == nil {
+		t.Errorf("invalid sendBatch %d accepted", config.SendBatch)
+	}
+}
+
+func TestConfigFromStream(t *testing.T) {
+	// Settings доходят до транспорта через TypedMessage, как из
+	// конфига xray
+	typed := serial.ToTypedMessage(&Settings{Key: "typed", Mtu: 1300})
+	instance, err := typed.GetInstance()
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: instance}
+
+	config, err := configFromStream(streamSettings.ProtocolSettings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Key != "typed" || config.MTU != 1300 {
+		t.Errorf("key %q, mtu %d", config.Key, config.MTU)
+	}
+
+	// Один Config на Settings - пул общих сессий различает outbound-ы
+	// по указателю
+	if again, _ := configFromStream(streamSettings.ProtocolSettings); again != config {
+		t.Error("settings converted twice")
+	}
+
+	own := DefaultConfig()
+	if config, _ := configFromStream(own); config != own {
+		t.Error("ready Config replaced")
+	}
+	if config, _ := configFromStream(nil); config.MTU != DefaultConfig().MTU {
+		t.Error("no settings: not the default config")
+	}
+}
+
+func TestSettingsDialListen(t *testing.T) {
+	// Listener и Dial из xray получают Settings, а не Config
+	settings := &Settings{Key: "settings-e2e", Obfuscation: "webrtc"}
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: settings}
+
+	accepted := make(chan stat.Connection, 1)
+	listener, err := ListenGameTunnel(context.Background(), xnet.LocalHostIP, 0, streamSettings,
+		func(conn stat.Connection) { accepted <- conn })
+	if err != nil {
+		t.Fatalf("ListenGameTunnel: %v", err)
+	}
+	defer listener.Close()
+
+	addr := listener.Addr().(*net.UDPAddr)
+	conn, err := Dial(context.Background(), xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port)), streamSettings)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	client := conn.(*GameTunnelClientConn)
+	if client.config.Key != "settings-e2e" || client.config.Obfuscation != ObfuscationMode_WEBRTC_MIMIC {
+		t.Errorf("client config: key %q, obfuscation %v", client.config.Key, client.config.Obfuscation)
+	}
+
+	client.Write([]byte("hello"))
+	select {
+	case server := <-accepted:
+		if got := readWithTimeout(t, server, 64); string(got) != "hello" {
+			t.Errorf("server read %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not accept the session")
+	}
+}
@@ -0,0 +1,510 @@
+package gametunnel
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// ====================================================================
+// Снапшоты сессий: переживаем перезапуск сервера
+// ====================================================================
+//
+// После перезапуска сервер теряет ключи сессий, и каждый клиент
+// ждёт таймаута keep-alive, чтобы переподключиться. Со снапшотами
+// хаб периодически сохраняет состояние сессий (Connection ID,
+// ключи, счётчики, адрес клиента), а при старте восстанавливает
+// его - клиенты продолжают работать с коротким провалом.
+//
+// Снапшот шифруется XChaCha20-Poly1305 ключом, выведенным из PSK
+// (config.Key) через HKDF: без PSK файл бесполезен. Поэтому
+// снапшоты требуют заданного key.
+//
+// Повтор nonce: после снапшота сервер успел отправить ещё сколько-то
+// пакетов, номера которых неизвестны. Восстановленный счётчик
+// отправки сдвигается на snapshotCounterGap, а новый снапшот
+// пишется сразу после восстановления - повторный старт с того же
+// файла не вернёт счётчики назад. Сессия, которой сдвиг переполнил бы
+// счётчик, не восстанавливается.
+//
+// Повтор пакетов клиента: окно anti-replay сохраняется вместе с
+// сессией. Снапшот остановки (Hub.Stop) последний - после него сессия
+// пакетов не принимала, и окна достаточно. Периодический снапшот мог
+// отстать: после него сервер принял ещё сколько-то пакетов. Поэтому
+// при восстановлении из него принятыми считаются и следующие
+// ReplayWindowSize номеров - до ~17 секунд данных клиента после
+// падения процесса теряются. Пакеты, принятые позже, повторить можно,
+// пока новые пакеты клиента не сдвинут окно за них.
+//
+// Хранилище - SessionStore: по умолчанию файл sessionSnapshotPath,
+// внешнее (например Redis) подключается через Hub.SetSessionStore.
+//
+// Ограничение: восстанавливается сессия транспорта. Протокол поверх
+// (VLESS и т.п.) видит новое соединение и переустанавливает своё.
+//
+// ====================================================================
+
+const (
+	// snapshotMagic - заголовок файла снапшота (формат версии 1)
+	snapshotMagic = "GTS1"
+
+	// snapshotHKDFInfo - контекст HKDF для ключа снапшота
+	snapshotHKDFInfo = "gametunnel session snapshot v1"
+
+	// snapshotCounterGap - сдвиг счётчика отправки при восстановлении
+	snapshotCounterGap = 1 << 24
+
+	// defaultSnapshotInterval - период сохранения по умолчанию
+	defaultSnapshotInterval = 30 * time.Second
+)
+
+// SessionStore - хранилище зашифрованного снапшота сессий
+type SessionStore interface {
+	// Load возвращает последний снапшот или nil, если его нет
+	Load() ([]byte, error)
+
+	// Save атомарно заменяет снапшот
+	Save(data []byte) error
+}
+
+// fileSessionStore - снапшот в файле на диске
+type fileSessionStore struct {
+	path string
+}
+
+// NewFileSessionStore создаёт файловое хранилище снапшотов
+func NewFileSessionStore(path string) SessionStore {
+	return &fileSessionStore{path: path}
+}
+
+// Load читает файл снапшота
+func (s *fileSessionStore) Load() ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save пишет во временный файл и переименовывает поверх старого
+func (s *fileSessionStore) Save(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// sessionSnapshotEntry - состояние одной сессии в снапшоте
+type sessionSnapshotEntry struct {
+	ID            []byte    `json:"id"`
+	RemoteAddr    string    `json:"remoteAddr"`
+	SendKey       []byte    `json:"sendKey"`
+	RecvKey       []byte    `json:"recvKey"`
+	SendPacketNum uint32    `json:"sendPacketNum"`
+	RecvPacketNum uint32    `json:"recvPacketNum"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastActiveAt  time.Time `json:"lastActiveAt"`
+	User          string    `json:"user,omitempty"`
+
+	// UserBound - пользователя определил хэндшейк (users.go)
+	UserBound bool `json:"userBound,omitempty"`
+
+	// Local - адрес сокета сервера, через который отвечает сессия
+	// (extraListen, portRange); пусто - основной
+	Local string `json:"local,omitempty"`
+
+	// CompactID - номер контекста сжатия заголовков (nil - сжатие не
+	// согласовано, compact.go)
+	CompactID *byte `json:"compactId,omitempty"`
+
+	// Timestamps - в DATA согласованы метки времени (timestamps.go)
+	Timestamps bool `json:"timestamps,omitempty"`
+
+	// Replay - окно anti-replay (nil - пакетов не было или снапшот
+	// старой версии)
+	Replay *replayState `json:"replay,omitempty"`
+
+	// KeepAliveIdle - интервал keep-alive, о котором сообщил клиент
+	// (nattune.go)
+	KeepAliveIdle time.Duration `json:"keepAliveIdle,omitempty"`
+}
+
+// sessionSnapshot - содержимое снапшота до шифрования
+type sessionSnapshot struct {
+	SavedAt  time.Time              `json:"savedAt"`
+	Sessions []sessionSnapshotEntry `json:"sessions"`
+
+	// Final - снапшот остановки хаба: после него сессии пакетов не
+	// принимали
+	Final bool `json:"final,omitempty"`
+}
+
+// sessionSnapshotter - шифрование и сохранение снапшотов хаба
+type sessionSnapshotter struct {
+	store    SessionStore
+	aead     cipher.AEAD
+	interval time.Duration
+}
+
+// newSessionSnapshotter создаёт снапшотер; nil, если store не задан
+func newSessionSnapshotter(config *Config, store SessionStore) (*sessionSnapshotter, error) {
+	if store == nil {
+		return nil, nil
+	}
+	if config.Key == "" {
+		return nil, fmt.Errorf("session snapshots require key")
+	}
+	aead, err := stateAEAD(config.Key, snapshotHKDFInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	interval := time.Duration(config.SessionSnapshotInterval) * time.Second
+	if interval == 0 {
+		interval = defaultSnapshotInterval
+	}
+
+	return &sessionSnapshotter{store: store, aead: aead, interval: interval}, nil
+}
+
+// seal сериализует и шифрует снапшот
+func (s *sessionSnapshotter) seal(snapshot *sessionSnapshot) ([]byte, error) {
+	return sealState(s.aead, snapshotMagic, snapshot)
+}
+
+// open расшифровывает снапшот
+func (s *sessionSnapshotter) open(data []byte) (*sessionSnapshot, error) {
+	snapshot := &sessionSnapshot{}
+	if err := openState(s.aead, snapshotMagic, "session snapshot", data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// stateAEAD - XChaCha20-Poly1305 с ключом из PSK для контекста info
+func stateAEAD(psk, info string) (cipher.AEAD, error) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	reader := hkdf.New(sha256.New, []byte(psk), []byte(HKDFSalt), []byte(info))
+	if _, err := io.ReadFull(reader, key); err != nil {
+		return nil, fmt.Errorf("derive state key: %w", err)
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, fmt.Errorf("create state cipher: %w", err)
+	}
+	return aead, nil
+}
+
+// sealState сериализует v в JSON и шифрует: magic | nonce | шифртекст
+func sealState(aead cipher.AEAD, magic string, v any) ([]byte, error) {
+	plaintext, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal state: %w", err)
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("state nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(magic)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, magic...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, plaintext, []byte(magic)), nil
+}
+
+// openState расшифровывает данные sealState в v; name - что это,
+// для ошибок
+func openState(aead cipher.AEAD, magic, name string, data []byte, v any) error {
+	headerLen := len(magic) + aead.NonceSize()
+	if len(data) < headerLen || !bytes.Equal(data[:len(magic)], []byte(magic)) {
+		return fmt.Errorf("not a %s", name)
+	}
+
+	nonce := data[len(magic):headerLen]
+	plaintext, err := aead.Open(nil, nonce, data[headerLen:], []byte(magic))
+	if err != nil {
+		return fmt.Errorf("decrypt %s: wrong key or corrupted data", name)
+	}
+	if err := json.Unmarshal(plaintext, v); err != nil {
+		return fmt.Errorf("unmarshal %s: %w", name, err)
+	}
+	return nil
+}
+
+// snapshotEntry снимает состояние сессии
+func snapshotEntry(session *Session) sessionSnapshotEntry {
+	session.mu.RLock()
+	lastActive := session.LastActiveAt
+	user := session.User
+	sock := session.sock
+	session.mu.RUnlock()
+
+	local := ""
+	if sock != nil && sock.conn != nil {
+		local = sock.conn.LocalAddr().String()
+	}
+	var compactID *byte
+	if session.compact != nil {
+		compactID = &session.compact.id
+	}
+	return sessionSnapshotEntry{
+		ID:            session.ID,
+		RemoteAddr:    session.RemoteAddr.String(),
+		SendKey:       session.Keys.SendKey[:],
+		RecvKey:       session.Keys.RecvKey[:],
+		SendPacketNum: atomic.LoadUint32(&session.SendPacketNum),
+		RecvPacketNum: atomic.LoadUint32(&session.RecvPacketNum),
+		CreatedAt:     session.CreatedAt,
+		LastActiveAt:  lastActive,
+		User:          user,
+		UserBound:     atomic.LoadInt32(&session.userBound) == 1,
+		Local:         local,
+		CompactID:     compactID,
+		Timestamps:    session.timestamps != nil,
+		Replay:        session.ReplayWindow.state(),
+		KeepAliveIdle: time.Duration(atomic.LoadInt64(&session.keepAliveIdle)),
+	}
+}
+
+// snapshotEntries снимает состояние сессий, которые можно поднять
+// в другом процессе
+func snapshotEntries(sessions []*Session) []sessionSnapshotEntry {
+	entries := make([]sessionSnapshotEntry, 0, len(sessions))
+	for _, session := range sessions {
+		// Потоки общей сессии в снапшот не попадают - её не восстановить
+		if session.Keys == nil || session.streams != nil || atomic.LoadInt32(&session.closed) == 1 {
+			continue
+		}
+		entries = append(entries, snapshotEntry(session))
+	}
+	return entries
+}
+
+// sessionKeysFromRaw восстанавливает SessionKeys из сохранённых ключей
+func sessionKeysFromRaw(sendKey, recvKey []byte) (*SessionKeys, error) {
+	if len(sendKey) != KeySize || len(recvKey) != KeySize {
+		return nil, fmt.Errorf("invalid key length")
+	}
+
+	sk := &SessionKeys{}
+	copy(sk.SendKey[:], sendKey)
+	copy(sk.RecvKey[:], recvKey)
+
+	var err error
+	if sk.sendCipher, err = chacha20poly1305.New(sk.SendKey[:]); err != nil {
+		return nil, fmt.Errorf("create send cipher: %w", err)
+	}
+	if sk.recvCipher, err = chacha20poly1305.New(sk.RecvKey[:]); err != nil {
+		return nil, fmt.Errorf("create recv cipher: %w", err)
+	}
+	return sk, nil
+}
+
+// SetSessionStore подключает хранилище снапшотов (например Redis)
+// вместо файла sessionSnapshotPath. Вызывать до Start
+func (h *Hub) SetSessionStore(store SessionStore) error {
+	snapshots, err := newSessionSnapshotter(h.getConfig(), store)
+	if err != nil {
+		return err
+	}
+	h.snapshots = snapshots
+	return nil
+}
+
+// SaveSnapshot сохраняет текущие сессии в хранилище
+// После передачи сессий новому процессу (upgrade.go) снапшот пишет он
+func (h *Hub) SaveSnapshot() error {
+	if h.snapshots == nil || atomic.LoadInt32(&h.handedOff) == 1 {
+		return nil
+	}
+	return h.saveSessions(h.sessions.snapshot(), false)
+}
+
+// saveSessions шифрует и сохраняет заданные сессии
+// final - снапшот остановки, сессии больше пакетов не примут
+func (h *Hub) saveSessions(sessions []*Session, final bool) error {
+	snapshot := &sessionSnapshot{
+		SavedAt:  time.Now(),
+		Sessions: snapshotEntries(sessions),
+		Final:    final,
+	}
+	data, err := h.snapshots.seal(snapshot)
+	if err != nil {
+		return err
+	}
+	return h.snapshots.store.Save(data)
+}
+
+// RestoreSessions восстанавливает сессии из снапшота прошлого запуска
+// Сессии, простоявшие дольше sessionTimeout, пропускаются.
+// Возвращает число восстановленных сессий
+func (h *Hub) RestoreSessions() (int, error) {
+	if h.snapshots == nil {
+		return 0, nil
+	}
+
+	data, err := h.snapshots.store.Load()
+	if err != nil {
+		return 0, fmt.Errorf("load snapshot: %w", err)
+	}
+	if data == nil {
+		return 0, nil
+	}
+	snapshot, err := h.snapshots.open(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// Периодический снапшот мог отстать от принятых пакетов
+	replayGap := uint32(ReplayWindowSize)
+	if snapshot.Final {
+		replayGap = 0
+	}
+	restored := h.restoreEntries(snapshot.Sessions, snapshotCounterGap, replayGap, nil)
+
+	// Сразу фиксируем сдвинутые счётчики: следующий старт с этого
+	// снапшота не должен повторить nonce
+	return restored, h.SaveSnapshot()
+}
+
+// restoreEntries поднимает сессии из записей снапшота или передачи
+// Счётчик отправки сдвигается на counterGap, окно anti-replay - на
+// replayGap; сессия отвечает через сокет из sockets с адресом
+// entry.Local, иначе через основной.
+// Возвращает число восстановленных сессий
+func (h *Hub) restoreEntries(entries []sessionSnapshotEntry, counterGap, replayGap uint32, sockets []*listenSocket) int {
+	restored := 0
+	for _, entry := range entries {
+		sock := h.dscp
+		for _, s := range sockets {
+			if entry.Local != "" && s.conn.LocalAddr().String() == entry.Local {
+				sock = s.dscp
+				break
+			}
+		}
+		if h.restoreEntry(entry, counterGap, replayGap, sock) != nil {
+			restored++
+		}
+	}
+	return restored
+}
+
+// restoreEntry поднимает сессию из записи со сдвигом счётчика
+// отправки counterGap; номера до replayGap после последнего принятого
+// считаются принятыми. Сессия отвечает через sock
+// nil - запись устарела или битая, сдвиг переполнил бы счётчик,
+// сессия уже есть или её inbound-а больше нет
+func (h *Hub) restoreEntry(entry sessionSnapshotEntry, counterGap, replayGap uint32, sock *dscpMarker) *Session {
+	if time.Since(entry.LastActiveAt) > max(h.sessionTimeout(), 3*entry.KeepAliveIdle) {
+		return nil
+	}
+	// Переполненный счётчик повторил бы nonce на ключах сессии
+	if entry.SendPacketNum > math.MaxUint32-counterGap {
+		return nil
+	}
+	window := NewReplayWindow()
+	window.restore(entry.Replay, entry.RecvPacketNum)
+	if !window.skip(replayGap) {
+		return nil
+	}
+	if len(entry.ID) != int(h.getConfig().ConnectionIdLength) || h.sessions.get(entry.ID) != nil {
+		return nil
+	}
+	remoteAddr, err := net.ResolveUDPAddr("udp", entry.RemoteAddr)
+	if err != nil {
+		return nil
+	}
+	keys, err := sessionKeysFromRaw(entry.SendKey, entry.RecvKey)
+	if err != nil {
+		return nil
+	}
+
+	session := h.newSession(entry.ID, remoteAddr, keys)
+	session.CreatedAt = entry.CreatedAt
+	session.SendPacketNum = entry.SendPacketNum + counterGap
+	session.RecvPacketNum = entry.RecvPacketNum
+	session.ReplayWindow = window
+	session.sock = sock
+	// Политика - заново по inbound-у сокета (policy.go); сессии
+	// inbound-а, которого больше нет, не восстанавливаются
+	policy, err := h.resolvePolicy(session.sock, remoteAddr)
+	if err != nil {
+		return nil
+	}
+	h.applyPolicy(session, session.sock, policy)
+	// Клиент продолжает слать сжатые заголовки с прежним номером
+	if entry.CompactID != nil {
+		session.compact = h.compact.restore(session, remoteAddr, *entry.CompactID)
+	}
+	// Клиент продолжает слать метки времени; оценки задержек
+	// набираются заново
+	if entry.Timestamps {
+		session.timestamps = &delayTrend{}
+	}
+	if entry.KeepAliveIdle > 0 && entry.KeepAliveIdle <= maxNATProbeGap*time.Second {
+		session.keepAliveIdle = int64(entry.KeepAliveIdle)
+	}
+
+	h.ipGuard.sessionRestored(session.ip)
+	h.sessions.put(session)
+	atomic.AddInt32(&h.activeSessions, 1)
+	atomic.AddUint64(&h.totalSessions, 1)
+	h.startSender(session)
+
+	if entry.UserBound {
+		atomic.StoreInt32(&session.userBound, 1)
+		h.assignUser(session, entry.User)
+	} else if entry.User != "" {
+		h.SetSessionUser(session.ID, entry.User)
+	}
+
+	h.notifyCreated(session)
+	if h.onNewSession != nil {
+		h.onNewSession(session)
+	}
+	return session
+}
+
+// snapshotLoop периодически сохраняет снапшот сессий
+func (h *Hub) snapshotLoop() {
+	ticker := time.NewTicker(h.snapshots.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		h.SaveSnapshot()
+	}
+}
@@ -0,0 +1,218 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// memSessionStore - хранилище снапшотов в памяти
+type memSessionStore struct {
+	data []byte
+}
+
+func (s *memSessionStore) Load() ([]byte, error) { return s.data, nil }
+
+func (s *memSessionStore) Save(data []byte) error {
+	s.data = append([]byte(nil), data...)
+	return nil
+}
+
+func newSnapshotHub(t *testing.T, config *Config, store SessionStore) *Hub {
+	t.Helper()
+	h := NewHub(config, nil)
+	if err := h.SetSessionStore(store); err != nil {
+		t.Fatalf("SetSessionStore: %v", err)
+	}
+	return h
+}
+
+func TestSessionSnapshotRequiresKey(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	if err := h.SetSessionStore(&memSessionStore{}); err == nil {
+		t.Error("Snapshots without key should be rejected")
+	}
+}
+
+func TestSessionSnapshotRestore(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "snapshot"
+	store := &memSessionStore{}
+
+	var secret [Curve25519KeySize]byte
+	secret[0] = 7
+	keys, err := DeriveSessionKeys(secret, config.Key, false)
+	if err != nil {
+		t.Fatalf("DeriveSessionKeys: %v", err)
+	}
+
+	h1 := newSnapshotHub(t, config, store)
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5000}
+	s := h1.newSession([]byte{1, 2, 3, 4, 5, 6, 7, 8}, addr, keys)
+	s.SendPacketNum = 100
+	s.RecvPacketNum = 50
+	s.compact = h1.compact.add(s, addr)
+	h1.sessions.put(s)
+	if err := h1.SaveSnapshot(); err != nil {
+		t.Fatalf("SaveSnapshot: %v", err)
+	}
+	if bytes.Contains(store.data, keys.SendKey[:]) {
+		t.Fatal("Snapshot stores keys in plaintext")
+	}
+
+	h2 := newSnapshotHub(t, config, store)
+	n, err := h2.RestoreSessions()
+	if err != nil || n != 1 {
+		t.Fatalf("RestoreSessions: n=%d err=%v", n, err)
+	}
+	restored := h2.GetSession(s.ID)
+	if restored == nil {
+		t.Fatal("Session not restored")
+	}
+	if restored.Keys.SendKey != keys.SendKey || restored.RemoteAddr.String() != addr.String() {
+		t.Error("Restored session state differs")
+	}
+	if restored.SendPacketNum != 100+snapshotCounterGap {
+		t.Errorf("SendPacketNum: got %d, want %d", restored.SendPacketNum, 100+snapshotCounterGap)
+	}
+	if restored.ReplayWindow.Check(50) {
+		t.Error("Replay window should remember the last received packet")
+	}
+	// Периодический снапшот мог отстать: номера в окне после него
+	// тоже считаются принятыми
+	if restored.ReplayWindow.Check(50 + ReplayWindowSize) {
+		t.Error("Packet possibly accepted after the snapshot replayed")
+	}
+	if !restored.ReplayWindow.Check(51 + ReplayWindowSize) {
+		t.Error("Packet past the replay gap rejected")
+	}
+	// Клиент продолжает слать сжатые заголовки с прежним номером
+	if restored.compact == nil || restored.compact.id != s.compact.id || h2.compact.get(addr, s.compact.id) != restored {
+		t.Error("Compact header context not restored")
+	}
+
+	// Повторный старт с того же хранилища не возвращает счётчик назад
+	h3 := newSnapshotHub(t, config, store)
+	h3.RestoreSessions()
+	if got := h3.GetSession(s.ID).SendPacketNum; got <= restored.SendPacketNum {
+		t.Errorf("Counter reused after second restore: %d", got)
+	}
+
+	// Снапшот с другим ключом не читается
+	other := *config
+	other.Key = "other"
+	if _, err := newSnapshotHub(t, &other, store).RestoreSessions(); err == nil {
+		t.Error("Snapshot opened with wrong key")
+	}
+}
+
+func TestSessionSnapshotReplayWindow(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "snapshot"
+	store := &memSessionStore{}
+
+	var secret [Curve25519KeySize]byte
+	keys, _ := DeriveSessionKeys(secret, config.Key, false)
+
+	h1 := newSnapshotHub(t, config, store)
+	s := h1.newSession([]byte{1, 1, 2, 2, 3, 3, 4, 4}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 3), Port: 1}, keys)
+	for _, seq := range []uint32{40, 42, 45} {
+		s.ReplayWindow.Check(seq)
+	}
+	s.RecvPacketNum = 42
+	h1.sessions.put(s)
+
+	// Сдвиг счётчика отправки переполнил бы uint32 - сессия не
+	// восстанавливается
+	wrapping := h1.newSession([]byte{9, 9, 9, 9, 9, 9, 9, 9}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 4), Port: 1}, keys)
+	wrapping.SendPacketNum = math.MaxUint32 - snapshotCounterGap + 1
+	h1.sessions.put(wrapping)
+
+	// Снапшот остановки - последний: окно восстанавливается как есть
+	h1.Stop()
+	h2 := newSnapshotHub(t, config, store)
+	if n, err := h2.RestoreSessions(); err != nil || n != 1 {
+		t.Fatalf("RestoreSessions: n=%d err=%v", n, err)
+	}
+	if h2.GetSession(wrapping.ID) != nil {
+		t.Error("Session with a wrapping send counter restored")
+	}
+	window := h2.GetSession(s.ID).ReplayWindow
+	for seq, want := range map[uint32]bool{40: false, 41: true, 42: false, 44: true, 45: false, 46: true} {
+		if got := window.Check(seq); got != want {
+			t.Errorf("Check(%d) after final snapshot = %v, want %v", seq, got, want)
+		}
+	}
+}
+
+func TestSessionSnapshotSkipsIdle(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "snapshot"
+	store := &memSessionStore{}
+
+	var secret [Curve25519KeySize]byte
+	keys, _ := DeriveSessionKeys(secret, config.Key, false)
+
+	h1 := newSnapshotHub(t, config, store)
+	s := h1.newSession([]byte{8, 7, 6, 5, 4, 3, 2, 1}, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1}, keys)
+	s.LastActiveAt = time.Now().Add(-time.Hour)
+	h1.sessions.put(s)
+	h1.SaveSnapshot()
+
+	if n, _ := newSnapshotHub(t, config, store).RestoreSessions(); n != 0 {
+		t.Errorf("Idle session restored: %d", n)
+	}
+}
+
+func TestLoopbackSessionSurvivesRestart(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "restart"
+	config.SessionSnapshotPath = filepath.Join(t.TempDir(), "sessions.snap")
+
+	l1, accepted1 := startTestListener(t, config)
+	clientConfig := *config
+	clientConfig.SessionSnapshotPath = ""
+	client, _ := dialTestClient(t, l1, &clientConfig, accepted1)
+
+	// Перезапуск сервера на том же порту
+	port := l1.Addr().(*net.UDPAddr).Port
+	l1.Close()
+
+	accepted2 := make(chan stat.Connection, 1)
+	streamSettings := &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config}
+	l2, err := ListenGameTunnel(context.Background(), xnet.LocalHostIP, xnet.Port(port), streamSettings,
+		func(conn stat.Connection) { accepted2 <- conn })
+	if err != nil {
+		t.Fatalf("restart listener: %v", err)
+	}
+	defer l2.Close()
+
+	var server net.Conn
+	select {
+	case server = <-accepted2:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session was not restored")
+	}
+
+	// Клиент продолжает работу без нового хэндшейка
+	if _, err := client.Write([]byte("still here")); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	if got := readWithTimeout(t, server, 2048); string(got) != "still here" {
+		t.Errorf("server got %q", got)
+	}
+	if _, err := server.Write([]byte("welcome back")); err != nil {
+		t.Fatalf("server Write: %v", err)
+	}
+	if got := readWithTimeout(t, client, 2048); string(got) != "welcome back" {
+		t.Errorf("client got %q", got)
+	}
+}
@@ -0,0 +1,319 @@
+package gametunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"syscall"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/transport/internet"
+	"google.golang.org/protobuf/proto"
+)
+
+// ====================================================================
+// Опции UDP-сокетов: gametunnelSettings и sockopt xray
+// ====================================================================
+//
+// Опции сокетов задаются в gametunnelSettings для каждого inbound и
+// outbound отдельно:
+//
+//   - readBufferSize / writeBufferSize - SO_RCVBUF / SO_SNDBUF
+//     (0 = socketBufferSize)
+//   - dontFragment - бит DF (клиенту его ставит и mtuProbe)
+//   - ttl - IP_TTL / IPV6_UNICAST_HOPS
+//   - dscp - DSCP всех пакетов, если нет маркировки по классам
+//     (enableDscp, dscp.go)
+//   - bindInterface - интерфейс сокетов (sockopt.interface)
+//   - fwmark - метка для policy routing (sockopt.mark)
+//
+// Сокеты открывает xray, как и для kcp, hysteria и splithttp:
+// клиентские - internet.DialSystem, серверные -
+// internet.ListenSystemPacket. Так sockopt xray (mark, interface,
+// tproxy, bindAddress, контроллеры сокетов) действуют по правилам
+// xray на каждой платформе. bindInterface и fwmark из
+// gametunnelSettings заменяют в нём interface и mark. Несуществующий
+// интерфейс - ошибка открытия сокета; остальные отказы ядра xray
+// только журналирует.
+//
+// Буферы, DF, TTL и DSCP выставляются на открытый сокет до первого
+// пакета; их отказ - ошибка открытия сокета. Реализации по
+// платформам (sockopt_dial_*.go, sockopt_windows.go): Linux, macOS и
+// Windows - все, остальные - только буферы. sendmmsg есть только в
+// Linux, на других ОС пакеты уходят по одному (batch_other.go).
+//
+// Клиент внутри VPN-приложения (Android VpnService) должен вывести
+// свои сокеты из собственного туннеля, иначе пакеты к серверу уйдут
+// в него же. SetSocketProtector задаёт на процесс функцию защиты
+// (VpnService.protect): она вызывается для каждого сокета клиента до
+// первого пакета, отказ - ошибка открытия сокета.
+//
+// ====================================================================
+
+// socketBufferSize - размер буферов UDP-сокета по умолчанию
+// Большой буфер важен для gaming-трафика при высокой нагрузке
+const socketBufferSize = 4 * 1024 * 1024
+
+// socketOptions - опции UDP-сокета сервера или клиента
+type socketOptions struct {
+	readBuffer   int
+	writeBuffer  int
+	dontFragment bool
+	ttl          int
+	dscp         int
+
+	// system - sockopt xray для DialSystem и ListenSystemPacket
+	// (nil - нет)
+	system *internet.SocketConfig
+
+	// protect - сокет клиента: вызвать socketProtector
+	protect bool
+	// inherited - переданные процессу сокеты: адрес сначала ищется
+	// среди них (nil - нет, inherit.go)
+	inherited *inheritedSockets
+}
+
+// socketProtector - защита сокетов клиента (nil - не нужна)
+var socketProtector atomic.Pointer[func(fd uintptr) bool]
+
+// errSocketNotProtected - функция защиты отказала
+var errSocketNotProtected = errors.New("socket protector refused the socket")
+
+// SetSocketProtector задаёт функцию защиты сокетов клиента от
+// собственного VPN (nil - снять); false из protect - отказ
+func SetSocketProtector(protect func(fd uintptr) bool) {
+	if protect == nil {
+		socketProtector.Store(nil)
+		return
+	}
+	socketProtector.Store(&protect)
+}
+
+// protectSocket вызывает socketProtector для сокета raw
+func protectSocket(raw syscall.RawConn) error {
+	protect := socketProtector.Load()
+	if protect == nil {
+		return nil
+	}
+	ok := false
+	if err := raw.Control(func(fd uintptr) { ok = (*protect)(fd) }); err != nil {
+		return err
+	}
+	if !ok {
+		return errSocketNotProtected
+	}
+	return nil
+}
+
+// listenSocketOptions - опции сокетов listener
+func listenSocketOptions(config *Config, sockopt *internet.SocketConfig) *socketOptions {
+	opts := newSocketOptions(config, sockopt)
+	opts.dontFragment = config.DontFragment
+	return opts
+}
+
+// dialSocketOptions - опции сокетов клиента к серверу
+func dialSocketOptions(config *Config, sockopt *internet.SocketConfig) *socketOptions {
+	opts := newSocketOptions(config, sockopt)
+	opts.dontFragment = config.DontFragment || config.MtuProbe
+	opts.protect = true
+	// Сокет хэндшейка с classSockets несёт только High (classsock.go)
+	if config.ClassSockets {
+		opts.writeBuffer = highWriteBuffer(config)
+	}
+	return opts
+}
+
+// newSocketOptions - общие опции gametunnelSettings и sockopt
+func newSocketOptions(config *Config, sockopt *internet.SocketConfig) *socketOptions {
+	opts := &socketOptions{
+		readBuffer:  socketBufferSize,
+		writeBuffer: socketBufferSize,
+		ttl:         int(config.Ttl),
+		system:      systemSockopt(config, sockopt),
+	}
+	if config.ReadBufferSize > 0 {
+		opts.readBuffer = int(config.ReadBufferSize)
+	}
+	if config.WriteBufferSize > 0 {
+		opts.writeBuffer = int(config.WriteBufferSize)
+	}
+	// С маркировкой по классам DSCP выставляет dscpMarker
+	if !config.EnableDscp {
+		opts.dscp = int(config.Dscp)
+	}
+	return opts
+}
+
+// systemSockopt - sockopt xray, в котором interface и mark заменены
+// bindInterface и fwmark из gametunnelSettings
+func systemSockopt(config *Config, sockopt *internet.SocketConfig) *internet.SocketConfig {
+	if config.BindInterface == "" && config.Fwmark == 0 {
+		return sockopt
+	}
+	merged := &internet.SocketConfig{}
+	if sockopt != nil {
+		merged = proto.Clone(sockopt).(*internet.SocketConfig)
+	}
+	if config.BindInterface != "" {
+		merged.Interface = config.BindInterface
+	}
+	if config.Fwmark != 0 {
+		merged.Mark = int32(config.Fwmark)
+	}
+	return merged
+}
+
+// checkInterface проверяет интерфейс из sockopt: системные сокеты
+// xray отказ привязки только журналируют
+func (o *socketOptions) checkInterface() error {
+	if o.system == nil || o.system.Interface == "" {
+		return nil
+	}
+	if _, err := net.InterfaceByName(o.system.Interface); err != nil {
+		return fmt.Errorf("bind to device %s: %w", o.system.Interface, err)
+	}
+	return nil
+}
+
+// apply выставляет опции на открытый сокет conn до первого пакета
+func (o *socketOptions) apply(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	if o.protect {
+		if err := protectSocket(raw); err != nil {
+			return err
+		}
+	}
+	addr, _ := conn.LocalAddr().(*net.UDPAddr)
+	if err := applySocketOptions(raw, o, addr != nil && addr.IP.To4() == nil); err != nil {
+		return err
+	}
+	o.setBuffers(conn)
+	return nil
+}
+
+// setBuffers задаёт буферы открытого сокета
+// Ядро урезает размер до net.core.rmem_max / wmem_max
+func (o *socketOptions) setBuffers(conn *net.UDPConn) {
+	conn.SetReadBuffer(o.readBuffer)
+	conn.SetWriteBuffer(o.writeBuffer)
+}
+
+// dialSystem открывает UDP-сокет клиента к server через
+// internet.DialSystem с локального адреса local (nil - адрес выбирает
+// xray: sendThrough outbound-а из ctx или ОС)
+func (o *socketOptions) dialSystem(ctx context.Context, local, server *net.UDPAddr) (PacketConn, error) {
+	if err := o.checkInterface(); err != nil {
+		return nil, err
+	}
+	sockopt := o.system
+	if sockopt != nil && len(sockopt.BindAddress) > 0 {
+		// bindAddress - адрес источника без закреплённого порта: при
+		// rebind прежний сокет ещё открыт
+		if local == nil {
+			local = &net.UDPAddr{IP: net.IP(sockopt.BindAddress)}
+		}
+		sockopt = proto.Clone(sockopt).(*internet.SocketConfig)
+		sockopt.BindAddress, sockopt.BindPort = nil, 0
+	}
+	if sockopt != nil && sockopt.DialerProxy != "" {
+		return nil, fmt.Errorf("dialerProxy is not supported")
+	}
+	if local != nil {
+		// Адрес источника системный dialer берёт из Gateway последнего
+		// outbound-а: подменяем его в копии, не трогая outbound xray
+		outbounds := append([]*session.Outbound(nil), session.OutboundsFromContext(ctx)...)
+		last := &session.Outbound{}
+		if n := len(outbounds); n > 0 {
+			copied := *outbounds[n-1]
+			last, outbounds = &copied, outbounds[:n-1]
+		}
+		last.Gateway = xnet.IPAddress(local.IP)
+		ctx = session.ContextWithOutbounds(ctx, append(outbounds, last))
+	}
+
+	dest := xnet.UDPDestination(xnet.IPAddress(server.IP), xnet.Port(server.Port))
+	raw, err := internet.DialSystem(ctx, dest, sockopt)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := newSystemConn(raw, server)
+	if err != nil {
+		raw.Close()
+		return nil, err
+	}
+	if err := o.apply(conn.UDPConn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// systemConn - сокет клиента от internet.DialSystem
+// Для UDP системный dialer xray отдаёт неподключённый сокет: Write
+// уходит на адрес сервера, а LocalAddr сокета на всех адресах -
+// адрес, с которого маршрут к серверу шёл при открытии
+type systemConn struct {
+	*net.UDPConn
+	remote *net.UDPAddr
+	local  *net.UDPAddr
+}
+
+// newSystemConn достаёт UDP-сокет из соединения DialSystem
+func newSystemConn(raw net.Conn, server *net.UDPAddr) (*systemConn, error) {
+	var udp *net.UDPConn
+	switch c := raw.(type) {
+	case *net.UDPConn:
+		udp = c
+	case *internet.PacketConnWrapper:
+		udp, _ = c.PacketConn.(*net.UDPConn)
+	}
+	if udp == nil {
+		return nil, fmt.Errorf("system dialer returned %T, want a UDP socket", raw)
+	}
+	conn := &systemConn{UDPConn: udp, remote: server, local: udp.LocalAddr().(*net.UDPAddr)}
+	if conn.local.IP.IsUnspecified() {
+		if ip, err := routeLocalIP(server); err == nil {
+			conn.local = &net.UDPAddr{IP: ip, Port: conn.local.Port}
+		}
+	}
+	return conn, nil
+}
+
+// Write отправляет датаграмму серверу
+func (c *systemConn) Write(b []byte) (int, error) {
+	return c.UDPConn.WriteToUDP(b, c.remote)
+}
+
+// RemoteAddr - адрес сервера
+func (c *systemConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// LocalAddr - адрес, с которого пакеты уходят к серверу
+func (c *systemConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// routeLocalIP - локальный адрес, который маршрут к server выбирает
+// сейчас
+// Connect UDP-сокета выбирает адрес по таблице маршрутов и ничего
+// не отправляет. Сокет защищён, как и рабочий: внутри VPN
+// незащищённый увидел бы адрес туннеля
+func routeLocalIP(server *net.UDPAddr) (net.IP, error) {
+	dialer := net.Dialer{Control: func(network, address string, raw syscall.RawConn) error {
+		return protectSocket(raw)
+	}}
+	route, err := dialer.Dial("udp", server.String())
+	if err != nil {
+		return nil, err
+	}
+	defer route.Close()
+	return route.LocalAddr().(*net.UDPAddr).IP, nil
+}
@@ -0,0 +1,61 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/xtls/xray-core/transport/internet"
+	"golang.org/x/sys/unix"
+)
+
+func TestListenSocketOptionsDarwin(t *testing.T) {
+	config := DefaultConfig()
+	config.Ttl = 17
+	config.Dscp = 10
+	config.DontFragment = true
+	config.BindInterface = "lo0"
+
+	conn, err := listenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, listenSocketOptions(config, nil))
+	if err != nil {
+		t.Fatalf("listenUDP: %v", err)
+	}
+	defer conn.Close()
+
+	raw, _ := conn.SyscallConn()
+	var ttl, tos, df, boundIf int
+	raw.Control(func(fd uintptr) {
+		ttl, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL)
+		tos, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+		df, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_DONTFRAG)
+		boundIf, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF)
+	})
+	lo, _ := net.InterfaceByName("lo0")
+	if ttl != 17 || tos != 10<<2 || df != 1 || lo == nil || boundIf != lo.Index {
+		t.Errorf("TTL %d, TOS 0x%02x, IP_DONTFRAG %d, IP_BOUND_IF %d", ttl, tos, df, boundIf)
+	}
+}
+
+func TestDialSocketOptionsDarwin(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+	addr := server.LocalAddr().(*net.UDPAddr)
+
+	config := DefaultConfig()
+	config.DontFragment = true
+	conn, err := dialPathSocket(context.Background(), nil, addr, config, nil)
+	if err != nil {
+		t.Fatalf("dialPathSocket: %v", err)
+	}
+	conn.Close()
+
+	// Несуществующий интерфейс - ошибка, а не молча игнорируемая опция
+	sockopt := &internet.SocketConfig{Interface: "gt-missing0"}
+	if conn, err := dialPathSocket(context.Background(), nil, addr, config, sockopt); err == nil {
+		conn.Close()
+		t.Error("dial bound to a missing interface succeeded")
+	}
+}
@@ -0,0 +1,40 @@
+//go:build darwin
+
+package gametunnel
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// applySocketOptions применяет к сокету DF, TTL и DSCP из
+// gametunnelSettings (sockopt.go)
+func applySocketOptions(raw syscall.RawConn, opts *socketOptions, ipv6 bool) error {
+	var sockErr error
+	err := raw.Control(func(fd uintptr) {
+		if opts.dontFragment {
+			if err := setIPOption(fd, ipv6, unix.IP_DONTFRAG, unix.IPV6_DONTFRAG, 1); err != nil {
+				sockErr = fmt.Errorf("set DF: %w", err)
+				return
+			}
+		}
+		if opts.ttl > 0 {
+			if err := setIPOption(fd, ipv6, unix.IP_TTL, unix.IPV6_UNICAST_HOPS, opts.ttl); err != nil {
+				sockErr = fmt.Errorf("set TTL %d: %w", opts.ttl, err)
+				return
+			}
+		}
+		if opts.dscp > 0 {
+			if err := setIPOption(fd, ipv6, unix.IP_TOS, unix.IPV6_TCLASS, opts.dscp<<2); err != nil {
+				sockErr = fmt.Errorf("set DSCP %d: %w", opts.dscp, err)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
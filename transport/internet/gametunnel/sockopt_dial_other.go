@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+
+package gametunnel
+
+import "syscall"
+
+// applySocketOptions - DF, TTL и DSCP сокета на этой платформе не
+// выставляются
+func applySocketOptions(raw syscall.RawConn, opts *socketOptions, ipv6 bool) error {
+	return nil
+}
@@ -0,0 +1,211 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/xtls/xray-core/transport/internet"
+	"golang.org/x/sys/unix"
+)
+
+func getSocketTOS(t *testing.T, conn *net.UDPConn) int {
+	t.Helper()
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn: %v", err)
+	}
+
+	var tos int
+	var sockErr error
+	raw.Control(func(fd uintptr) {
+		tos, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS)
+	})
+	if sockErr != nil {
+		t.Fatalf("getsockopt IP_TOS: %v", sockErr)
+	}
+	return tos
+}
+
+func TestDSCPMarkerSetsTOS(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+
+	conn, err := net.DialUDP("udp4", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP: %v", err)
+	}
+	defer conn.Close()
+
+	config := DefaultConfig()
+	config.EnableDscp = true
+	marker := newDSCPMarker(conn, config)
+
+	tests := []struct {
+		level PriorityLevel
+		dscp  int
+	}{
+		{PriorityHigh, DefaultDscpHigh},
+		{PriorityLow, DefaultDscpLow},
+		{PriorityMedium, DefaultDscpMedium},
+	}
+
+	for _, tt := range tests {
+		if _, err := marker.Write([]byte("ping"), tt.level); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if got := getSocketTOS(t, conn); got != tt.dscp<<2 {
+			t.Errorf("level %d: TOS got 0x%02x, want 0x%02x", tt.level, got, tt.dscp<<2)
+		}
+	}
+}
+
+func TestDSCPMarkerDisabled(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+
+	conn, err := net.DialUDP("udp4", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("DialUDP: %v", err)
+	}
+	defer conn.Close()
+
+	marker := newDSCPMarker(conn, DefaultConfig())
+	if _, err := marker.Write([]byte("ping"), PriorityHigh); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if got := getSocketTOS(t, conn); got != 0 {
+		t.Errorf("TOS should stay 0 when DSCP is disabled, got 0x%02x", got)
+	}
+}
+
+func TestDialPathSocketSockopt(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DontFragment = true
+	sockopt := &internet.SocketConfig{}
+	// SO_MARK требует CAP_NET_ADMIN
+	if os.Geteuid() == 0 {
+		sockopt.Mark = 0x55
+	}
+	conn, err := dialPathSocket(context.Background(), nil, server.LocalAddr().(*net.UDPAddr), config, sockopt)
+	if err != nil {
+		t.Fatalf("dialPathSocket: %v", err)
+	}
+	defer conn.Close()
+
+	// Сокет системного dialer-а не подключён: Write уходит серверу,
+	// LocalAddr - адрес маршрута к нему
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	got := readWithTimeout(t, server, 4)
+	if string(got) != "ping" {
+		t.Errorf("server got %q", got)
+	}
+	if local := conn.LocalAddr().(*net.UDPAddr); !local.IP.Equal(net.IPv4(127, 0, 0, 1)) || local.Port == 0 {
+		t.Errorf("LocalAddr %v", local)
+	}
+
+	raw, _ := conn.(syscall.Conn).SyscallConn()
+	var pmtud, mark int
+	raw.Control(func(fd uintptr) {
+		pmtud, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER)
+		mark, _ = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK)
+	})
+	if pmtud != unix.IP_PMTUDISC_DO {
+		t.Errorf("IP_MTU_DISCOVER=%d, want IP_PMTUDISC_DO", pmtud)
+	}
+	if mark != int(sockopt.Mark) {
+		t.Errorf("SO_MARK=0x%x, want 0x%x", mark, sockopt.Mark)
+	}
+
+	// Несуществующий интерфейс - ошибка, а не молча игнорируемая опция
+	_, err = dialPathSocket(context.Background(), nil, server.LocalAddr().(*net.UDPAddr), config,
+		&internet.SocketConfig{Interface: "gt-missing0"})
+	if err == nil {
+		t.Error("Dial bound to a missing interface succeeded")
+	}
+}
+
+func TestListenSocketOptions(t *testing.T) {
+	config := DefaultConfig()
+	config.Ttl = 17
+	config.Dscp = 10
+	config.DontFragment = true
+	config.ReadBufferSize = 256 * 1024
+
+	conn, err := listenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, listenSocketOptions(config, nil))
+	if err != nil {
+		t.Fatalf("listenUDP: %v", err)
+	}
+	defer conn.Close()
+
+	raw, _ := conn.SyscallConn()
+	var ttl, pmtud, rcvbuf int
+	raw.Control(func(fd uintptr) {
+		ttl, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL)
+		pmtud, _ = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER)
+		rcvbuf, _ = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	})
+	if ttl != 17 || pmtud != unix.IP_PMTUDISC_DO {
+		t.Errorf("TTL %d, IP_MTU_DISCOVER %d", ttl, pmtud)
+	}
+	if got := getSocketTOS(t, conn); got != 10<<2 {
+		t.Errorf("TOS 0x%02x, want 0x%02x", got, 10<<2)
+	}
+	// Ядро удваивает SO_RCVBUF и урезает его до rmem_max
+	if rcvbuf == 0 || rcvbuf > 2*256*1024 {
+		t.Errorf("SO_RCVBUF %d", rcvbuf)
+	}
+
+	// С маркировкой по классам DSCP сокета не выставляется
+	config.EnableDscp = true
+	if opts := listenSocketOptions(config, nil); opts.dscp != 0 {
+		t.Errorf("socket dscp %d with per-class marking", opts.dscp)
+	}
+}
+
+func TestSocketOptionsFallback(t *testing.T) {
+	config := DefaultConfig()
+	sockopt := &internet.SocketConfig{Mark: 7, Interface: "eth9"}
+
+	// Незаданное в gametunnelSettings - sockopt xray как есть
+	opts := listenSocketOptions(config, sockopt)
+	if opts.system != sockopt {
+		t.Error("fallback: sockopt not passed to the system listener")
+	}
+
+	// Заданное - важнее, sockopt inbound-а не меняется
+	config.Fwmark = 9
+	config.BindInterface = "wg0"
+	opts = dialSocketOptions(config, sockopt)
+	if opts.system.Mark != 9 || opts.system.Interface != "wg0" {
+		t.Errorf("explicit: mark %d, iface %q", opts.system.Mark, opts.system.Interface)
+	}
+	if sockopt.Mark != 7 || sockopt.Interface != "eth9" {
+		t.Errorf("sockopt changed: mark %d, iface %q", sockopt.Mark, sockopt.Interface)
+	}
+
+	// Несуществующий интерфейс - ошибка открытия сокета сервера
+	config.Fwmark = 0
+	config.BindInterface = "gt-missing0"
+	if conn, err := listenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, listenSocketOptions(config, nil)); err == nil {
+		conn.Close()
+		t.Error("listen bound to a missing interface succeeded")
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package gametunnel
+
+import "syscall"
+
+// setDSCP - на этой платформе маркировка не поддерживается
+func setDSCP(raw syscall.RawConn, dscp int, ipv6 bool) error {
+	return nil
+}
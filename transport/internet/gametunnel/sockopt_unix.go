@@ -0,0 +1,42 @@
+//go:build linux || darwin || freebsd
+
+package gametunnel
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDSCP выставляет DSCP (старшие 6 бит TOS / Traffic Class) на сокете
+func setDSCP(raw syscall.RawConn, dscp int, ipv6 bool) error {
+	tos := dscp << 2
+
+	var sockErr error
+	err := raw.Control(func(fd uintptr) {
+		if ipv6 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos)
+			// Dual-stack сокет отправляет IPv4 через IPv4-mapped адреса -
+			// для них действует IP_TOS, ошибку игнорируем
+			unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+			return
+		}
+		sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TOS, tos)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setIPOption выставляет IPv4-опцию v4 или IPv6-опцию v6 уровня IP
+// Dual-stack сокет шлёт IPv4 через IPv4-mapped адреса - для них
+// действует и IPv4-опция, её ошибку игнорируем
+func setIPOption(fd uintptr, ipv6 bool, v4, v6, value int) error {
+	if !ipv6 {
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, v4, value)
+	}
+	err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, v6, value)
+	unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, v4, value)
+	return err
+}
@@ -0,0 +1,82 @@
+//go:build windows
+
+package gametunnel
+
+import (
+	"fmt"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// ====================================================================
+// Опции сокетов Windows
+// ====================================================================
+//
+// Константы из ws2ipdef.h, которых нет в x/sys/windows. DSCP
+// setsockopt принимает, но без политики QoS (Policy-based QoS в
+// групповых политиках) Windows метку не ставит - как и у любого
+// приложения. SIO_UDP_CONNRESET (ICMP port unreachable обрывает
+// чтение UDP-сокета) выключает сам пакет net.
+//
+// ====================================================================
+
+const (
+	winIPDontFragment = 14 // IP_DONTFRAGMENT
+	winIPv6DontFrag   = 14 // IPV6_DONTFRAG
+	winIPv6TClass     = 39 // IPV6_TCLASS
+)
+
+// setDSCP выставляет DSCP (старшие 6 бит TOS / Traffic Class) на сокете
+func setDSCP(raw syscall.RawConn, dscp int, ipv6 bool) error {
+	var sockErr error
+	err := raw.Control(func(fd uintptr) {
+		sockErr = setWinIPOption(fd, ipv6, windows.IP_TOS, winIPv6TClass, dscp<<2)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// applySocketOptions применяет к сокету DF, TTL и DSCP из
+// gametunnelSettings (sockopt.go)
+func applySocketOptions(raw syscall.RawConn, opts *socketOptions, ipv6 bool) error {
+	var sockErr error
+	err := raw.Control(func(fd uintptr) {
+		if opts.dontFragment {
+			if err := setWinIPOption(fd, ipv6, winIPDontFragment, winIPv6DontFrag, 1); err != nil {
+				sockErr = fmt.Errorf("set DF: %w", err)
+				return
+			}
+		}
+		if opts.ttl > 0 {
+			if err := setWinIPOption(fd, ipv6, windows.IP_TTL, windows.IPV6_UNICAST_HOPS, opts.ttl); err != nil {
+				sockErr = fmt.Errorf("set TTL %d: %w", opts.ttl, err)
+				return
+			}
+		}
+		if opts.dscp > 0 {
+			if err := setWinIPOption(fd, ipv6, windows.IP_TOS, winIPv6TClass, opts.dscp<<2); err != nil {
+				sockErr = fmt.Errorf("set DSCP %d: %w", opts.dscp, err)
+				return
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// setWinIPOption выставляет IPv4-опцию v4 или IPv6-опцию v6
+// Ошибку IPv4-опции dual-stack сокета игнорируем, как в Unix
+func setWinIPOption(fd uintptr, ipv6 bool, v4, v6, value int) error {
+	handle := windows.Handle(fd)
+	if !ipv6 {
+		return windows.SetsockoptInt(handle, windows.IPPROTO_IP, v4, value)
+	}
+	err := windows.SetsockoptInt(handle, windows.IPPROTO_IPV6, v6, value)
+	windows.SetsockoptInt(handle, windows.IPPROTO_IP, v4, value)
+	return err
+}
@@ -0,0 +1,98 @@
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/transport/internet"
+	"golang.org/x/sys/windows"
+)
+
+// loopbackInterface - имя петлевого интерфейса Windows
+func loopbackInterface(t *testing.T) string {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestListenSocketOptionsWindows(t *testing.T) {
+	config := DefaultConfig()
+	config.Ttl = 17
+	config.Dscp = 10
+	config.DontFragment = true
+	config.BindInterface = loopbackInterface(t)
+
+	conn, err := listenUDP(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, listenSocketOptions(config, nil))
+	if err != nil {
+		t.Fatalf("listenUDP: %v", err)
+	}
+	defer conn.Close()
+
+	raw, _ := conn.SyscallConn()
+	var ttl, df int
+	raw.Control(func(fd uintptr) {
+		ttl, _ = windows.GetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, windows.IP_TTL)
+		df, _ = windows.GetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, winIPDontFragment)
+	})
+	if ttl != 17 || df != 1 {
+		t.Errorf("TTL %d, IP_DONTFRAGMENT %d", ttl, df)
+	}
+
+	// Сокет с опциями работает: датаграмма доходит
+	client, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write([]byte("ping"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	if n, _, err := conn.ReadFromUDP(buf); err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("read %q: %v", buf[:n], err)
+	}
+}
+
+func TestDialSocketOptionsWindows(t *testing.T) {
+	server, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("ListenUDP: %v", err)
+	}
+	defer server.Close()
+	addr := server.LocalAddr().(*net.UDPAddr)
+
+	config := DefaultConfig()
+	config.DontFragment = true
+	conn, err := dialPathSocket(context.Background(), nil, addr, config, nil)
+	if err != nil {
+		t.Fatalf("dialPathSocket: %v", err)
+	}
+	defer conn.Close()
+
+	// Маркировка по классам: setsockopt принимает метку даже без
+	// политики QoS
+	dscpConfig := DefaultConfig()
+	dscpConfig.EnableDscp = true
+	marker := newDSCPMarker(conn, dscpConfig)
+	if _, err := marker.Write([]byte("ping"), PriorityHigh); err != nil {
+		t.Errorf("marked write: %v", err)
+	}
+
+	// Несуществующий интерфейс - ошибка, а не молча игнорируемая опция
+	sockopt := &internet.SocketConfig{Interface: "gt-missing0"}
+	if conn, err := dialPathSocket(context.Background(), nil, addr, config, sockopt); err == nil {
+		conn.Close()
+		t.Error("dial bound to a missing interface succeeded")
+	}
+}
@@ -0,0 +1,351 @@
+package standalone
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/protocol"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/proxy/vless"
+	"github.com/xtls/xray-core/proxy/vless/encoding"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+)
+
+// ====================================================================
+// Автономный клиент GameTunnel
+// ====================================================================
+//
+// Лёгкий клиент без xray: для приставок, которые ходят в сеть через
+// ПК, и для тех, кому полный xray на клиенте не нужен. Локально
+// клиент поднимает SOCKS5 (TCP CONNECT и UDP ASSOCIATE, socks.go)
+// и/или TUN-устройство (Linux, tun_linux.go), а наружу идёт так же,
+// как xray с outbound vless + gametunnel:
+//
+//	приложение -> SOCKS5 / TUN -> VLESS -> GameTunnelClientConn -> сервер
+//
+// Хэндшейк, шифрование, обфускация, переподключение и остальное - из
+// пакета gametunnel без изменений; серверу нужен обычный VLESS inbound
+// с network "gametunnel". Блок gametunnelSettings конфигурации тот же,
+// что в streamSettings xray.
+//
+// Каждое TCP-соединение и каждый UDP-поток (источник, цель) - отдельный
+// запрос VLESS в своём соединении GameTunnel; с sharedSession они идут
+// потоками одной сессии. UDP кадрируется как в VLESS: [длина 2][данные].
+//
+// Третий режим - проброс порта без VLESS к gametunnel-server
+// (forward.go, server.go): цель задаёт сервер, UUID не нужен.
+//
+// ====================================================================
+
+// Config - конфигурация автономного клиента (JSON)
+type Config struct {
+	// Server - адрес сервера "host:port"
+	Server string `json:"server"`
+
+	// UUID - id пользователя VLESS на сервере
+	UUID string `json:"uuid"`
+
+	// Socks - адрес локального SOCKS5 "ip:port" ("" - выключен)
+	Socks string `json:"socks"`
+
+	// Tun - TUN-устройство (nil - выключено, только Linux)
+	Tun *TunConfig `json:"tun"`
+
+	// Forward - проброс локального порта к gametunnel-server
+	// (nil - выключен)
+	Forward *ForwardConfig `json:"forward"`
+
+	// GameTunnel - настройки транспорта, как gametunnelSettings в xray
+	GameTunnel *conf.GameTunnelConfig `json:"gametunnelSettings"`
+
+	// LookupEnv - источник переопределений GAMETUNNEL_* поверх
+	// gametunnelSettings (nil - os.LookupEnv). Флаги командной строки
+	// подставляются через него же, поверх окружения
+	LookupEnv func(string) (string, bool) `json:"-"`
+}
+
+// TunConfig - TUN-устройство клиента
+// Адреса и маршруты на устройство назначает пользователь (README)
+type TunConfig struct {
+	Name string `json:"name"`
+	MTU  uint32 `json:"mtu"`
+}
+
+// DefaultTunMTU - MTU TUN-устройства по умолчанию
+const DefaultTunMTU = 1500
+
+// LoadConfig читает конфигурацию клиента из JSON-файла
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := new(Config)
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Client - автономный клиент GameTunnel
+type Client struct {
+	config   *Config
+	server   xnet.Destination
+	settings *internet.MemoryStreamConfig
+	user     *protocol.MemoryUser
+
+	// ErrorLog - журнал ошибок соединений (nil - log.Default)
+	ErrorLog *log.Logger
+}
+
+// NewClient проверяет конфигурацию и создаёт клиента
+func NewClient(config *Config) (*Client, error) {
+	if config.Socks == "" && config.Tun == nil && config.Forward == nil {
+		return nil, fmt.Errorf("neither socks, tun nor forward is configured")
+	}
+	if config.Forward != nil {
+		if _, err := forwardNetwork(config.Forward.Network); err != nil {
+			return nil, err
+		}
+	}
+
+	// Имя сервера разрешает Dial - заново при каждом переподключении
+	host, port, err := net.SplitHostPort(config.Server)
+	if err != nil {
+		return nil, fmt.Errorf("server address: %w", err)
+	}
+	serverPort, err := xnet.PortFromString(port)
+	if err != nil {
+		return nil, fmt.Errorf("server port: %w", err)
+	}
+
+	// UUID нужен только VLESS: SOCKS5 и TUN
+	var user *protocol.MemoryUser
+	if config.Socks != "" || config.Tun != nil {
+		account, err := (&vless.Account{Id: config.UUID}).AsAccount()
+		if err != nil {
+			return nil, fmt.Errorf("uuid: %w", err)
+		}
+		user = &protocol.MemoryUser{Account: account}
+	}
+
+	transport, err := transportConfig(config.GameTunnel, config.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		config: config,
+		server: xnet.UDPDestination(xnet.ParseAddress(host), serverPort),
+		settings: &internet.MemoryStreamConfig{
+			ProtocolName:     "gametunnel",
+			ProtocolSettings: transport,
+		},
+		user: user,
+	}, nil
+}
+
+// transportConfig собирает конфиг транспорта: gametunnelSettings
+// поверх умолчаний, затем переменные GAMETUNNEL_* из lookup
+// (nil - os.LookupEnv)
+func transportConfig(settings *conf.GameTunnelConfig, lookup func(string) (string, bool)) (*gametunnel.Config, error) {
+	transport := gametunnel.DefaultConfig()
+	if settings != nil {
+		built, err := settings.Build()
+		if err != nil {
+			return nil, fmt.Errorf("gametunnelSettings: %w", err)
+		}
+		transport = built.ToConfig()
+	}
+	if lookup == nil {
+		lookup = os.LookupEnv
+	}
+	if err := transport.ApplyEnv(lookup); err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// Run поднимает SOCKS5 и TUN из конфигурации и работает до отмены ctx
+func (c *Client) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, 3)
+	running := 0
+	if c.config.Socks != "" {
+		ln, err := net.Listen("tcp", c.config.Socks)
+		if err != nil {
+			return fmt.Errorf("socks listen: %w", err)
+		}
+		running++
+		go func() { errc <- c.ServeSocks(ctx, ln) }()
+	}
+	if c.config.Tun != nil {
+		running++
+		go func() { errc <- c.ServeTun(ctx, c.config.Tun) }()
+	}
+	if c.config.Forward != nil {
+		serve, err := c.listenForward(c.config.Forward)
+		if err != nil {
+			cancel()
+			for i := 0; i < running; i++ {
+				<-errc
+			}
+			return err
+		}
+		running++
+		go func() { errc <- serve(ctx) }()
+	}
+
+	// Первая ошибка останавливает клиента целиком; с отменой ctx
+	// ServeSocks, ServeTun и проброс возвращают nil
+	err := <-errc
+	cancel()
+	for i := 1; i < running; i++ {
+		<-errc
+	}
+	return err
+}
+
+// logf пишет ошибку соединения в журнал клиента
+func (c *Client) logf(format string, args ...any) {
+	logger := c.ErrorLog
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf(format, args...)
+}
+
+// Dial открывает соединение GameTunnel с сервером без VLESS - как
+// проброс порта (forward.go)
+func (c *Client) Dial(ctx context.Context) (net.Conn, error) {
+	return gametunnel.Dial(ctx, c.server, c.settings)
+}
+
+// Probe меряет маршрут до сервера без соединения (gametunnel.Probe)
+func (c *Client) Probe(ctx context.Context) (*gametunnel.ProbeResult, error) {
+	return gametunnel.Probe(ctx, c.config.Server, c.settings.ProtocolSettings.(*gametunnel.Config))
+}
+
+// dial открывает соединение GameTunnel и отправляет запрос VLESS к target
+func (c *Client) dial(ctx context.Context, command protocol.RequestCommand, target xnet.Destination) (*vlessConn, error) {
+	conn, err := c.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &protocol.RequestHeader{
+		Version: encoding.Version,
+		User:    c.user,
+		Command: command,
+		Address: target.Address,
+		Port:    target.Port,
+	}
+	if err := encoding.EncodeRequestHeader(conn, request, &encoding.Addons{}); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("vless request to %s: %w", target, err)
+	}
+	return &vlessConn{Conn: conn, request: request}, nil
+}
+
+// DialTCP открывает TCP-соединение к target через сервер
+func (c *Client) DialTCP(ctx context.Context, target xnet.Destination) (net.Conn, error) {
+	return c.dial(ctx, protocol.RequestCommandTCP, target)
+}
+
+// DialUDP открывает UDP-поток к target через сервер
+func (c *Client) DialUDP(ctx context.Context, target xnet.Destination) (*PacketConn, error) {
+	conn, err := c.dial(ctx, protocol.RequestCommandUDP, target)
+	if err != nil {
+		return nil, err
+	}
+	return &PacketConn{conn: conn}, nil
+}
+
+// vlessConn - соединение с запросом VLESS; заголовок ответа сервера
+// снимается с первого чтения
+type vlessConn struct {
+	net.Conn
+	request *protocol.RequestHeader
+
+	responseOnce sync.Once
+	responseErr  error
+}
+
+// Read отдаёт данные после заголовка ответа VLESS
+func (v *vlessConn) Read(b []byte) (int, error) {
+	v.responseOnce.Do(func() {
+		if _, err := encoding.DecodeResponseHeader(v.Conn, v.request); err != nil {
+			v.responseErr = fmt.Errorf("vless response: %w", err)
+		}
+	})
+	if v.responseErr != nil {
+		return 0, v.responseErr
+	}
+	return v.Conn.Read(b)
+}
+
+// PacketConn - UDP-поток VLESS к одной цели
+type PacketConn struct {
+	conn *vlessConn
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+}
+
+// WritePacket отправляет датаграмму: [длина 2][данные] одной записью
+func (p *PacketConn) WritePacket(b []byte) error {
+	if len(b) > 0xFFFF {
+		return fmt.Errorf("udp packet too large: %d bytes", len(b))
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err := p.conn.Write(frame)
+	return err
+}
+
+// ReadPacket читает датаграмму в b; не влезшее в b отбрасывается
+func (p *PacketConn) ReadPacket(b []byte) (int, error) {
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+
+	var size [2]byte
+	if _, err := io.ReadFull(p.conn, size[:]); err != nil {
+		return 0, err
+	}
+	length := int(binary.BigEndian.Uint16(size[:]))
+	n := length
+	if n > len(b) {
+		n = len(b)
+	}
+	if _, err := io.ReadFull(p.conn, b[:n]); err != nil {
+		return 0, err
+	}
+	if n < length {
+		if _, err := io.CopyN(io.Discard, p.conn, int64(length-n)); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Close закрывает поток
+func (p *PacketConn) Close() error {
+	return p.conn.Close()
+}
@@ -0,0 +1,207 @@
+package standalone
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Проброс порта точка-точка
+// ====================================================================
+//
+// Клиент слушает локальный порт, сервер (server.go) соединяет каждое
+// пришедшее соединение GameTunnel с одной целью из своего конфига:
+//
+//	игра -> 127.0.0.1:27015 -> GameTunnel -> gametunnel-server -> цель
+//
+// Протокола поверх транспорта нет: TCP-соединение или UDP-поток
+// (адрес источника) - отдельное соединение GameTunnel, байты и
+// датаграммы идут как есть. Датаграмма GameTunnel - одна датаграмма
+// цели; датаграммы длиннее полезной нагрузки пакета (maxPayloadSize)
+// транспорт режет на части, и цель получает их по отдельности.
+//
+// ====================================================================
+
+// forwardIdleTimeout - UDP-поток без датаграмм в обе стороны
+// закрывается, как запись NAT
+const forwardIdleTimeout = 2 * time.Minute
+
+// ForwardConfig - проброс локального порта к gametunnel-server
+type ForwardConfig struct {
+	// Listen - локальный адрес "ip:port"
+	Listen string `json:"listen"`
+
+	// Network - "udp" (по умолчанию) или "tcp"; должен совпадать
+	// с network сервера
+	Network string `json:"network"`
+}
+
+// forwardNetwork проверяет network проброса; "" - udp
+func forwardNetwork(network string) (string, error) {
+	switch network {
+	case "", "udp":
+		return "udp", nil
+	case "tcp":
+		return "tcp", nil
+	}
+	return "", fmt.Errorf("forward network %q: want udp or tcp", network)
+}
+
+// listenForward открывает локальный порт проброса
+func (c *Client) listenForward(config *ForwardConfig) (func(context.Context) error, error) {
+	network, err := forwardNetwork(config.Network)
+	if err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		ln, err := net.Listen("tcp", config.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("forward listen: %w", err)
+		}
+		return func(ctx context.Context) error { return c.ServeForwardTCP(ctx, ln) }, nil
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("forward listen: %w", err)
+	}
+	sock, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("forward listen: %w", err)
+	}
+	return func(ctx context.Context) error { return c.ServeForwardUDP(ctx, sock) }, nil
+}
+
+// ServeForwardTCP пробрасывает TCP-соединения с ln на сервер до
+// отмены ctx
+func (c *Client) ServeForwardTCP(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("forward accept: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			remote, err := c.Dial(ctx)
+			if err != nil {
+				c.logf("forward %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+			relay(conn, remote)
+		}()
+	}
+}
+
+// forwardFlow - UDP-поток одного источника
+type forwardFlow struct {
+	conn net.Conn
+
+	// lastSeen - время последней датаграммы (UnixNano)
+	lastSeen int64
+}
+
+// touch отмечает датаграмму потока
+func (f *forwardFlow) touch() {
+	atomic.StoreInt64(&f.lastSeen, time.Now().UnixNano())
+}
+
+// ServeForwardUDP пробрасывает датаграммы с sock на сервер до отмены
+// ctx: по соединению GameTunnel на каждый адрес источника
+func (c *Client) ServeForwardUDP(ctx context.Context, sock *net.UDPConn) error {
+	var mu sync.Mutex
+	flows := make(map[string]*forwardFlow)
+
+	// closeFlows закрывает потоки, молчащие с before (все - нулевое время)
+	closeFlows := func(before time.Time) {
+		mu.Lock()
+		defer mu.Unlock()
+		for key, flow := range flows {
+			if before.IsZero() || atomic.LoadInt64(&flow.lastSeen) < before.UnixNano() {
+				flow.conn.Close()
+				delete(flows, key)
+			}
+		}
+	}
+	defer closeFlows(time.Time{})
+
+	go func() {
+		ticker := time.NewTicker(forwardIdleTimeout / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				sock.Close()
+				return
+			case <-ticker.C:
+				closeFlows(time.Now().Add(-forwardIdleTimeout))
+			}
+		}
+	}()
+
+	buf := make([]byte, maxUDPPacket)
+	for {
+		n, from, err := sock.ReadFromUDP(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("forward read: %w", err)
+		}
+
+		key := from.String()
+		mu.Lock()
+		flow := flows[key]
+		mu.Unlock()
+		if flow == nil {
+			// Хэндшейк блокирует приём остальных источников на время
+			// одного RTT - как и в SOCKS UDP ASSOCIATE
+			conn, err := c.Dial(ctx)
+			if err != nil {
+				c.logf("forward %s: %v", from, err)
+				continue
+			}
+			flow = &forwardFlow{conn: conn}
+			mu.Lock()
+			flows[key] = flow
+			mu.Unlock()
+			go c.forwardReplies(sock, flow, from, func() {
+				mu.Lock()
+				if flows[key] == flow {
+					delete(flows, key)
+				}
+				mu.Unlock()
+			})
+		}
+		flow.touch()
+		flow.conn.Write(buf[:n])
+	}
+}
+
+// forwardReplies возвращает источнику датаграммы сервера, пока поток
+// открыт; done убирает поток из таблицы
+func (c *Client) forwardReplies(sock *net.UDPConn, flow *forwardFlow, to *net.UDPAddr, done func()) {
+	defer done()
+	defer flow.conn.Close()
+
+	buf := make([]byte, maxUDPPacket)
+	for {
+		n, err := flow.conn.Read(buf)
+		if err != nil {
+			return
+		}
+		flow.touch()
+		sock.WriteToUDP(buf[:n], to)
+	}
+}
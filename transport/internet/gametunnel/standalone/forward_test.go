@@ -0,0 +1,248 @@
+package standalone
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/infra/conf"
+)
+
+// startForwardServer поднимает сервер проброса к target
+func startForwardServer(t *testing.T, network, target string) string {
+	t.Helper()
+
+	server, err := NewServer(&ServerConfig{Listen: "127.0.0.1:0", Target: target, Network: network})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ln, err := server.Listen(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		ln.Close()
+	})
+	return ln.Addr().String()
+}
+
+// startForwardClient поднимает клиента проброса к server, serve
+// обслуживает локальный порт до конца теста
+func startForwardClient(t *testing.T, server string, serve func(context.Context, *Client) error) {
+	t.Helper()
+
+	client, err := NewClient(&Config{Server: server, Forward: &ForwardConfig{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- serve(ctx, client) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("serve forward: %v", err)
+		}
+	})
+}
+
+func TestForwardUDP(t *testing.T) {
+	// Цель отвечает эхом с адресом источника: у каждого источника
+	// игры - свой поток, значит свой адрес у цели
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := target.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			target.WriteToUDP([]byte(fmt.Sprintf("%s from %s", buf[:n], from)), from)
+		}
+	}()
+
+	server := startForwardServer(t, "udp", target.LocalAddr().String())
+	local, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	startForwardClient(t, server, func(ctx context.Context, c *Client) error {
+		return c.ServeForwardUDP(ctx, local)
+	})
+
+	seen := make(map[string]bool)
+	for _, player := range []string{"player-1", "player-2"} {
+		app, err := net.DialUDP("udp", nil, local.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer app.Close()
+
+		var source string
+		for i := 0; i < 2; i++ {
+			app.Write([]byte(player))
+			app.SetReadDeadline(time.Now().Add(5 * time.Second))
+			buf := make([]byte, 1500)
+			n, err := app.Read(buf)
+			if err != nil {
+				t.Fatalf("%s: %v", player, err)
+			}
+			var got string
+			if _, err := fmt.Sscanf(string(buf[:n]), player+" from %s", &got); err != nil {
+				t.Fatalf("%s: reply %q", player, buf[:n])
+			}
+			if source != "" && got != source {
+				t.Errorf("%s: second datagram from %s, first from %s", player, got, source)
+			}
+			source = got
+		}
+		if seen[source] {
+			t.Errorf("%s shares the flow %s", player, source)
+		}
+		seen[source] = true
+	}
+}
+
+func TestForwardTCP(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	server := startForwardServer(t, "tcp", target.Addr().String())
+	local, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	startForwardClient(t, server, func(ctx context.Context, c *Client) error {
+		return c.ServeForwardTCP(ctx, local)
+	})
+
+	conn, err := net.Dial("tcp", local.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "hello" {
+		t.Fatalf("echo %q: %v", got, err)
+	}
+}
+
+func TestNewServerValidation(t *testing.T) {
+	tests := []struct {
+		config  ServerConfig
+		wantErr bool
+	}{
+		{ServerConfig{Target: "127.0.0.1:27015"}, false},
+		{ServerConfig{Listen: "0.0.0.0:9000", Target: "game.example:7777", Network: "tcp"}, false},
+		{ServerConfig{}, true},
+		{ServerConfig{Target: "127.0.0.1"}, true},
+		{ServerConfig{Target: "127.0.0.1:27015", Network: "sctp"}, true},
+		{ServerConfig{Listen: "0.0.0.0", Target: "127.0.0.1:27015"}, true},
+	}
+	for _, tt := range tests {
+		if _, err := NewServer(&tt.config); (err != nil) != tt.wantErr {
+			t.Errorf("NewServer(%+v) error %v, want error %v", tt.config, err, tt.wantErr)
+		}
+	}
+
+	// Проброс без UUID - можно, SOCKS5 без UUID - нет
+	if _, err := NewClient(&Config{Server: "127.0.0.1:443", Forward: &ForwardConfig{Network: "udp"}}); err != nil {
+		t.Errorf("forward client: %v", err)
+	}
+	if _, err := NewClient(&Config{Server: "127.0.0.1:443", Forward: &ForwardConfig{Network: "quic"}}); err == nil {
+		t.Error("forward network quic accepted")
+	}
+}
+
+func TestServerRunEndsAfterUpgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("upgradeSocket needs descriptor passing")
+	}
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listen := sock.LocalAddr().String()
+	sock.Close()
+	dir, err := os.MkdirTemp("", "gtu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	upgradeSocket := filepath.Join(dir, "upgrade.sock")
+
+	newServer := func() *Server {
+		server, err := NewServer(&ServerConfig{
+			Listen: listen,
+			Target: "127.0.0.1:9",
+			GameTunnel: &conf.GameTunnelConfig{
+				Key:           "upgrade",
+				UpgradeSocket: upgradeSocket,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- newServer().Run(ctx) }()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(upgradeSocket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("old server did not start")
+		}
+	}
+
+	// Старый сервер завершает Run сам, когда новый забрал порт
+	ln, err := newServer().Listen(ctx)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer ln.Close()
+	if ln.Addr().String() != listen {
+		t.Errorf("new server listens on %s, want %s", ln.Addr(), listen)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("old server Run: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("old server still running after the upgrade")
+	}
+}
@@ -0,0 +1,35 @@
+#!/bin/sh
+# Проверка совместимости с прошлой версией: собирает gametunnel-server
+# и gametunnel-client из ref (git worktree) и гоняет TestInterop -
+# текущий клиент против старого сервера и старый клиент против
+# текущего сервера
+#
+#   ./interop.sh [ref]
+#
+# Гоняется в CI (.github/workflows/test.yml, job interop); нужна
+# полная история, ref должен быть достижим из master
+set -eu
+
+# ref по умолчанию - коммит master, чей формат провода обязан
+# понимать текущий код. Намеренное изменение формата провода
+# (хэндшейк, управляющие команды, обёртки) передвигает ref на коммит
+# с этим изменением - следующим коммитом того же PR: свой хэш коммит
+# не знает. Ref должен быть достижим из master: worktree свежего
+# клона не найдёт коммит вне истории
+ref=${1:-517ef3f}
+
+cd "$(dirname "$0")/../../../.."
+tmp=$(mktemp -d)
+trap 'git worktree remove --force "$tmp/src"; rm -rf "$tmp"' EXIT
+
+git worktree add --detach "$tmp/src" "$ref"
+(
+	cd "$tmp/src"
+	go build -trimpath -o "$tmp/bin/" \
+		./transport/internet/gametunnel/cmd/gametunnel-server \
+		./transport/internet/gametunnel/cmd/gametunnel-client
+)
+
+GAMETUNNEL_INTEROP_SERVER="$tmp/bin/gametunnel-server" \
+GAMETUNNEL_INTEROP_CLIENT="$tmp/bin/gametunnel-client" \
+	go test -count=1 -v -run TestInterop ./transport/internet/gametunnel/standalone
@@ -0,0 +1,176 @@
+package standalone
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+)
+
+// Межверсионная совместимость: текущий клиент против сервера прошлой
+// версии и наоборот. Бинарники собирает interop.sh, без них тест
+// пропускается:
+//
+//	GAMETUNNEL_INTEROP_SERVER=/tmp/gt-old/gametunnel-server \
+//	GAMETUNNEL_INTEROP_CLIENT=/tmp/gt-old/gametunnel-client \
+//	go test -run TestInterop ./transport/internet/gametunnel/standalone
+
+// interopKey - общий ключ сторон
+const interopKey = "interop-test-key"
+
+// interopEnv - переменные GAMETUNNEL_* текущей стороны
+func interopEnv(name string) (string, bool) {
+	if name == gametunnel.EnvKey {
+		return interopKey, true
+	}
+	return "", false
+}
+
+// freeUDPAddr - свободный адрес loopback для бинарника прошлой версии
+func freeUDPAddr(t *testing.T) string {
+	t.Helper()
+
+	sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+	return sock.LocalAddr().String()
+}
+
+// startInteropEcho поднимает UDP-цель, отвечающую эхом
+func startInteropEcho(t *testing.T) string {
+	t.Helper()
+
+	target, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { target.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := target.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			target.WriteToUDP(buf[:n], from)
+		}
+	}()
+	return target.LocalAddr().String()
+}
+
+// startInteropBinary запускает бинарник прошлой версии до конца теста
+func startInteropBinary(t *testing.T, path string, args ...string) {
+	t.Helper()
+
+	var output bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Env = append(os.Environ(), gametunnel.EnvKey+"="+interopKey)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+		if t.Failed() {
+			t.Logf("%s output:\n%s", path, output.String())
+		}
+	})
+}
+
+// checkInteropEcho шлёт датаграммы через проброс local, пока эхо не
+// вернётся: бинарнику нужно время на старт, а Client Hello, ушедший
+// до старта сервера, клиент ждёт до таймаута хэндшейка
+func checkInteropEcho(t *testing.T, local string) {
+	t.Helper()
+
+	app, err := net.Dial("udp", local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	deadline := time.Now().Add(15 * time.Second)
+	buf := make([]byte, 1500)
+	for i := 0; time.Now().Before(deadline); i++ {
+		msg := []byte(fmt.Sprintf("interop %d", i))
+		app.Write(msg)
+		// Датаграммы, посланные до готовности туннеля, возвращаются
+		// пачкой: пропускаем их эхо до своего
+		app.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		for {
+			n, err := app.Read(buf)
+			if err != nil {
+				break
+			}
+			if bytes.Equal(buf[:n], msg) {
+				return
+			}
+		}
+	}
+	t.Fatal("no echo through the tunnel")
+}
+
+func TestInteropCurrentClient(t *testing.T) {
+	server := os.Getenv("GAMETUNNEL_INTEROP_SERVER")
+	if server == "" {
+		t.Skip("GAMETUNNEL_INTEROP_SERVER not set")
+	}
+
+	addr := freeUDPAddr(t)
+	startInteropBinary(t, server, "-listen", addr, "-target", startInteropEcho(t), "-network", "udp")
+
+	client, err := NewClient(&Config{Server: addr, Forward: &ForwardConfig{}, LookupEnv: interopEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.ServeForwardUDP(ctx, local) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	checkInteropEcho(t, local.LocalAddr().String())
+}
+
+func TestInteropCurrentServer(t *testing.T) {
+	client := os.Getenv("GAMETUNNEL_INTEROP_CLIENT")
+	if client == "" {
+		t.Skip("GAMETUNNEL_INTEROP_CLIENT not set")
+	}
+
+	server, err := NewServer(&ServerConfig{
+		Listen:    "127.0.0.1:0",
+		Target:    startInteropEcho(t),
+		LookupEnv: interopEnv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ln, err := server.Listen(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	local := freeUDPAddr(t)
+	startInteropBinary(t, client, "-server", ln.Addr().String(), "-forward", local, "-network", "udp")
+	checkInteropEcho(t, local)
+}
@@ -0,0 +1,155 @@
+package standalone
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/infra/conf"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// ServerConfig - конфигурация автономного сервера проброса (JSON)
+type ServerConfig struct {
+	// Listen - UDP-адрес GameTunnel "ip:port" ("" - ":443")
+	Listen string `json:"listen"`
+
+	// Target - цель "host:port", к которой ведёт каждое соединение
+	Target string `json:"target"`
+
+	// Network - "udp" (по умолчанию) или "tcp"
+	Network string `json:"network"`
+
+	// GameTunnel - настройки транспорта, как gametunnelSettings в xray
+	GameTunnel *conf.GameTunnelConfig `json:"gametunnelSettings"`
+
+	// LookupEnv - источник переопределений GAMETUNNEL_* (как у Config)
+	LookupEnv func(string) (string, bool) `json:"-"`
+}
+
+// defaultServerListen - адрес сервера по умолчанию
+const defaultServerListen = ":443"
+
+// LoadServerConfig читает конфигурацию сервера из JSON-файла
+func LoadServerConfig(path string) (*ServerConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config := new(ServerConfig)
+	if err := json.NewDecoder(f).Decode(config); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Server - автономный сервер GameTunnel: проброс к одной цели
+// без xray (forward.go)
+type Server struct {
+	address  xnet.Address
+	port     xnet.Port
+	network  string
+	target   string
+	settings *internet.MemoryStreamConfig
+
+	// ErrorLog - журнал ошибок соединений (nil - log.Default)
+	ErrorLog *log.Logger
+}
+
+// NewServer проверяет конфигурацию и создаёт сервер
+func NewServer(config *ServerConfig) (*Server, error) {
+	if config.Target == "" {
+		return nil, fmt.Errorf("target is not configured")
+	}
+	if _, _, err := net.SplitHostPort(config.Target); err != nil {
+		return nil, fmt.Errorf("target address: %w", err)
+	}
+	network, err := forwardNetwork(config.Network)
+	if err != nil {
+		return nil, err
+	}
+
+	listen := config.Listen
+	if listen == "" {
+		listen = defaultServerListen
+	}
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return nil, fmt.Errorf("listen address: %w", err)
+	}
+	listenPort, err := xnet.PortFromString(port)
+	if err != nil {
+		return nil, fmt.Errorf("listen port: %w", err)
+	}
+	address := xnet.AnyIP
+	if host != "" {
+		address = xnet.ParseAddress(host)
+	}
+
+	transport, err := transportConfig(config.GameTunnel, config.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		address: address,
+		port:    listenPort,
+		network: network,
+		target:  config.Target,
+		settings: &internet.MemoryStreamConfig{
+			ProtocolName:     "gametunnel",
+			ProtocolSettings: transport,
+		},
+	}, nil
+}
+
+// Listen открывает порт GameTunnel; соединения обслуживаются до
+// закрытия listener или отмены ctx
+func (s *Server) Listen(ctx context.Context) (internet.Listener, error) {
+	return gametunnel.ListenGameTunnel(ctx, s.address, s.port, s.settings, func(conn stat.Connection) {
+		go s.handle(ctx, conn)
+	})
+}
+
+// Run слушает порт и работает до отмены ctx или передачи сессий
+// новому процессу (upgradeSocket)
+func (s *Server) Run(ctx context.Context) error {
+	ln, err := s.Listen(ctx)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+	case <-ln.(*gametunnel.Listener).HandedOff():
+	}
+	return ln.Close()
+}
+
+// handle соединяет соединение GameTunnel с целью
+func (s *Server) handle(ctx context.Context, conn net.Conn) {
+	var dialer net.Dialer
+	target, err := dialer.DialContext(ctx, s.network, s.target)
+	if err != nil {
+		conn.Close()
+		s.logf("forward %s to %s: %v", conn.RemoteAddr(), s.target, err)
+		return
+	}
+	relay(conn, target)
+}
+
+// logf пишет ошибку соединения в журнал сервера
+func (s *Server) logf(format string, args ...any) {
+	logger := s.ErrorLog
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf(format, args...)
+}
@@ -0,0 +1,354 @@
+package standalone
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// SOCKS5 (RFC 1928) без аутентификации: CONNECT и UDP ASSOCIATE
+const (
+	socksVersion = 0x05
+
+	socksMethodNone         = 0x00
+	socksMethodNoAcceptable = 0xFF
+
+	socksCmdConnect      = 0x01
+	socksCmdUDPAssociate = 0x03
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded      = 0x00
+	socksReplyFailure        = 0x01
+	socksReplyCmdUnsupported = 0x07
+)
+
+// maxUDPPacket - наибольшая датаграмма UDP ASSOCIATE
+const maxUDPPacket = 65535
+
+// ServeSocks принимает SOCKS5-клиентов на ln до отмены ctx
+func (c *Client) ServeSocks(ctx context.Context, ln net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("socks accept: %w", err)
+		}
+		go c.handleSocks(ctx, conn)
+	}
+}
+
+// handleSocks обслуживает одно SOCKS5-соединение
+func (c *Client) handleSocks(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+
+	cmd, target, err := socksHandshake(conn)
+	if err != nil {
+		c.logf("socks %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	switch cmd {
+	case socksCmdConnect:
+		remote, err := c.DialTCP(ctx, target)
+		if err != nil {
+			writeSocksReply(conn, socksReplyFailure, nil)
+			c.logf("socks connect %s: %v", target, err)
+			return
+		}
+		defer remote.Close()
+		if err := writeSocksReply(conn, socksReplySucceeded, nil); err != nil {
+			return
+		}
+		relay(conn, remote)
+
+	case socksCmdUDPAssociate:
+		// Сокет ретрансляции - на том же адресе, куда пришёл клиент
+		local := conn.LocalAddr().(*net.TCPAddr)
+		sock, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP})
+		if err != nil {
+			writeSocksReply(conn, socksReplyFailure, nil)
+			return
+		}
+		assoc := newUDPAssociation(c, sock, conn.RemoteAddr().(*net.TCPAddr).IP)
+		defer assoc.close()
+		if err := writeSocksReply(conn, socksReplySucceeded, sock.LocalAddr().(*net.UDPAddr)); err != nil {
+			return
+		}
+		go assoc.serve(ctx)
+
+		// Ассоциация живёт, пока открыто управляющее TCP-соединение
+		io.Copy(io.Discard, conn)
+
+	default:
+		writeSocksReply(conn, socksReplyCmdUnsupported, nil)
+	}
+}
+
+// socksHandshake выбирает метод и читает запрос клиента
+func socksHandshake(conn net.Conn) (byte, xnet.Destination, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return 0, xnet.Destination{}, err
+	}
+	if head[0] != socksVersion {
+		return 0, xnet.Destination{}, fmt.Errorf("unsupported socks version %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, xnet.Destination{}, err
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == socksMethodNone {
+			method = socksMethodNone
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return 0, xnet.Destination{}, err
+	}
+	if method == socksMethodNoAcceptable {
+		return 0, xnet.Destination{}, fmt.Errorf("no acceptable auth method")
+	}
+
+	// Запрос: VER CMD RSV ATYP ADDR PORT
+	var request [3]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return 0, xnet.Destination{}, err
+	}
+	if request[0] != socksVersion {
+		return 0, xnet.Destination{}, fmt.Errorf("unsupported socks version %d", request[0])
+	}
+	addr, port, err := readSocksAddr(conn)
+	if err != nil {
+		return 0, xnet.Destination{}, err
+	}
+	network := xnet.Network_TCP
+	if request[1] == socksCmdUDPAssociate {
+		network = xnet.Network_UDP
+	}
+	return request[1], xnet.Destination{Network: network, Address: addr, Port: port}, nil
+}
+
+// readSocksAddr читает ATYP ADDR PORT
+func readSocksAddr(r io.Reader) (xnet.Address, xnet.Port, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return nil, 0, err
+	}
+
+	var host []byte
+	switch atyp[0] {
+	case socksAddrIPv4:
+		host = make([]byte, net.IPv4len)
+	case socksAddrIPv6:
+		host = make([]byte, net.IPv6len)
+	case socksAddrDomain:
+		var size [1]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, 0, err
+		}
+		host = make([]byte, size[0])
+	default:
+		return nil, 0, fmt.Errorf("unsupported socks address type %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, host); err != nil {
+		return nil, 0, err
+	}
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return nil, 0, err
+	}
+
+	addr := xnet.ParseAddress(string(host))
+	if atyp[0] != socksAddrDomain {
+		addr = xnet.IPAddress(host)
+	}
+	return addr, xnet.Port(binary.BigEndian.Uint16(port[:])), nil
+}
+
+// appendSocksAddr дописывает ATYP ADDR PORT
+func appendSocksAddr(b []byte, addr xnet.Address, port xnet.Port) []byte {
+	switch addr.Family() {
+	case xnet.AddressFamilyIPv4:
+		b = append(b, socksAddrIPv4)
+		b = append(b, addr.IP().To4()...)
+	case xnet.AddressFamilyIPv6:
+		b = append(b, socksAddrIPv6)
+		b = append(b, addr.IP().To16()...)
+	default:
+		b = append(b, socksAddrDomain, byte(len(addr.Domain())))
+		b = append(b, addr.Domain()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port))
+}
+
+// writeSocksReply отправляет ответ на запрос (bound nil - 0.0.0.0:0)
+func writeSocksReply(conn net.Conn, code byte, bound *net.UDPAddr) error {
+	addr, port := xnet.AnyIP, xnet.Port(0)
+	if bound != nil {
+		addr, port = xnet.IPAddress(bound.IP), xnet.Port(bound.Port)
+	}
+	_, err := conn.Write(appendSocksAddr([]byte{socksVersion, code, 0x00}, addr, port))
+	return err
+}
+
+// relay копирует данные в обе стороны, пока одна из них не закроется
+func relay(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+
+	// GameTunnel не умеет полузакрытие - закрываем обе стороны
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// udpAssociation - UDP ASSOCIATE одного SOCKS-клиента: по потоку
+// VLESS на каждую цель
+type udpAssociation struct {
+	client *Client
+	sock   *net.UDPConn
+	owner  net.IP
+
+	mu    sync.Mutex
+	peer  *net.UDPAddr
+	flows map[string]*PacketConn
+}
+
+// newUDPAssociation создаёт ассоциацию; датаграммы принимаются только
+// с IP владельца управляющего соединения
+func newUDPAssociation(client *Client, sock *net.UDPConn, owner net.IP) *udpAssociation {
+	return &udpAssociation{
+		client: client,
+		sock:   sock,
+		owner:  owner,
+		flows:  make(map[string]*PacketConn),
+	}
+}
+
+// serve пересылает датаграммы приложения в потоки VLESS
+func (a *udpAssociation) serve(ctx context.Context) {
+	buf := make([]byte, maxUDPPacket)
+	for {
+		n, from, err := a.sock.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(a.owner) {
+			continue
+		}
+		target, payload, err := parseSocksUDP(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		a.mu.Lock()
+		a.peer = from
+		a.mu.Unlock()
+
+		flow, err := a.flow(ctx, target)
+		if err != nil {
+			a.client.logf("socks udp %s: %v", target, err)
+			continue
+		}
+		flow.WritePacket(payload)
+	}
+}
+
+// flow возвращает поток к target, открывая его при первой датаграмме
+func (a *udpAssociation) flow(ctx context.Context, target xnet.Destination) (*PacketConn, error) {
+	key := target.NetAddr()
+	a.mu.Lock()
+	flow, ok := a.flows[key]
+	a.mu.Unlock()
+	if ok {
+		return flow, nil
+	}
+
+	flow, err := a.client.DialUDP(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	if a.flows == nil {
+		// Ассоциация закрылась, пока шёл хэндшейк
+		a.mu.Unlock()
+		flow.Close()
+		return nil, net.ErrClosed
+	}
+	a.flows[key] = flow
+	a.mu.Unlock()
+
+	go a.reply(flow, target)
+	return flow, nil
+}
+
+// reply возвращает приложению ответы цели с SOCKS-заголовком
+func (a *udpAssociation) reply(flow *PacketConn, target xnet.Destination) {
+	header := appendSocksAddr([]byte{0x00, 0x00, 0x00}, target.Address, target.Port)
+	packet := make([]byte, len(header)+maxUDPPacket)
+	copy(packet, header)
+	for {
+		n, err := flow.ReadPacket(packet[len(header):])
+		if err != nil {
+			return
+		}
+		a.mu.Lock()
+		peer := a.peer
+		a.mu.Unlock()
+		a.sock.WriteToUDP(packet[:len(header)+n], peer)
+	}
+}
+
+// close закрывает сокет ретрансляции и все потоки
+func (a *udpAssociation) close() {
+	a.sock.Close()
+	a.mu.Lock()
+	flows := a.flows
+	a.flows = nil
+	a.mu.Unlock()
+	for _, flow := range flows {
+		flow.Close()
+	}
+}
+
+// parseSocksUDP разбирает датаграмму UDP ASSOCIATE:
+// RSV(2) FRAG(1) ATYP ADDR PORT DATA
+func parseSocksUDP(packet []byte) (xnet.Destination, []byte, error) {
+	if len(packet) < 4 {
+		return xnet.Destination{}, nil, errors.New("socks udp packet too short")
+	}
+	if packet[2] != 0 {
+		// Фрагментация SOCKS не поддерживается (RFC 1928 разрешает)
+		return xnet.Destination{}, nil, errors.New("fragmented socks udp packet")
+	}
+	r := bytes.NewReader(packet[3:])
+	addr, port, err := readSocksAddr(r)
+	if err != nil {
+		return xnet.Destination{}, nil, err
+	}
+	return xnet.UDPDestination(addr, port), packet[len(packet)-r.Len():], nil
+}
@@ -0,0 +1,229 @@
+package standalone
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/uuid"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/gametunnel"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+// vlessRequest - разобранный сервером запрос VLESS
+type vlessRequest struct {
+	command byte
+	target  string
+}
+
+// startVLESSEcho поднимает GameTunnel-сервер с простейшим VLESS
+// inbound: проверяет UUID, отвечает заголовком и возвращает данные
+func startVLESSEcho(t *testing.T) (string, <-chan vlessRequest) {
+	t.Helper()
+
+	requests := make(chan vlessRequest, 16)
+	settings := &internet.MemoryStreamConfig{
+		ProtocolName:     "gametunnel",
+		ProtocolSettings: gametunnel.DefaultConfig(),
+	}
+	l, err := gametunnel.ListenGameTunnel(context.Background(), xnet.LocalHostIP, 0, settings,
+		func(conn stat.Connection) {
+			go func() {
+				defer conn.Close()
+				request, err := readVLESSRequest(conn)
+				if err != nil {
+					t.Errorf("vless request: %v", err)
+					return
+				}
+				requests <- request
+				conn.Write([]byte{0x00, 0x00})
+				io.Copy(conn, conn)
+			}()
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.Addr().String(), requests
+}
+
+// readVLESSRequest разбирает заголовок запроса VLESS
+func readVLESSRequest(r io.Reader) (vlessRequest, error) {
+	head := make([]byte, 1+16+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return vlessRequest{}, err
+	}
+	id, _ := uuid.ParseString(testUUID)
+	if head[0] != 0 || !bytes.Equal(head[1:17], id.Bytes()) {
+		return vlessRequest{}, fmt.Errorf("bad version or user %x", head[:17])
+	}
+	if _, err := io.CopyN(io.Discard, r, int64(head[17])); err != nil {
+		return vlessRequest{}, err
+	}
+
+	// CMD PORT ATYP ADDR
+	var fixed [4]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return vlessRequest{}, err
+	}
+	var host string
+	switch fixed[3] {
+	case 1, 3:
+		ip := make(net.IP, 4)
+		if fixed[3] == 3 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return vlessRequest{}, err
+		}
+		host = ip.String()
+	case 2:
+		var size [1]byte
+		io.ReadFull(r, size[:])
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return vlessRequest{}, err
+		}
+		host = string(name)
+	}
+	port := binary.BigEndian.Uint16(fixed[1:3])
+	return vlessRequest{command: fixed[0], target: net.JoinHostPort(host, fmt.Sprint(port))}, nil
+}
+
+// startSocks поднимает SOCKS5 клиента к server
+func startSocks(t *testing.T, server string) string {
+	t.Helper()
+
+	client, err := NewClient(&Config{Server: server, UUID: testUUID, Socks: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- client.ServeSocks(ctx, ln) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("ServeSocks: %v", err)
+		}
+	})
+	return ln.Addr().String()
+}
+
+// socksRequest здоровается с SOCKS5 и отправляет запрос, возвращает
+// адрес из ответа
+func socksRequest(t *testing.T, conn net.Conn, cmd byte, addr []byte) []byte {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	conn.Write([]byte{socksVersion, 1, socksMethodNone})
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil || method[1] != socksMethodNone {
+		t.Fatalf("method reply %v: %v", method, err)
+	}
+
+	conn.Write(append([]byte{socksVersion, cmd, 0x00}, addr...))
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		t.Fatal(err)
+	}
+	if reply[1] != socksReplySucceeded || reply[3] != socksAddrIPv4 {
+		t.Fatalf("socks reply %v", reply)
+	}
+	bound := make([]byte, 6)
+	io.ReadFull(conn, bound)
+	return bound
+}
+
+func TestSocksConnect(t *testing.T) {
+	server, requests := startVLESSEcho(t)
+	socks := startSocks(t, server)
+
+	conn, err := net.Dial("tcp", socks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	target := append([]byte{socksAddrDomain, 11}, "example.com"...)
+	socksRequest(t, conn, socksCmdConnect, binary.BigEndian.AppendUint16(target, 80))
+
+	conn.Write([]byte("hello"))
+	got := make([]byte, 5)
+	if _, err := io.ReadFull(conn, got); err != nil || string(got) != "hello" {
+		t.Fatalf("echo %q: %v", got, err)
+	}
+
+	request := <-requests
+	if request.command != 1 || request.target != "example.com:80" {
+		t.Errorf("server got %+v", request)
+	}
+}
+
+func TestSocksUDPAssociate(t *testing.T) {
+	server, requests := startVLESSEcho(t)
+	socks := startSocks(t, server)
+
+	conn, err := net.Dial("tcp", socks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	bound := socksRequest(t, conn, socksCmdUDPAssociate, []byte{socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	relayAddr := &net.UDPAddr{IP: net.IP(bound[:4]), Port: int(binary.BigEndian.Uint16(bound[4:]))}
+
+	app, err := net.DialUDP("udp", nil, relayAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer app.Close()
+
+	header := []byte{0, 0, 0, socksAddrIPv4, 1, 2, 3, 4, 0, 53}
+	for _, query := range []string{"query-1", "query-2"} {
+		app.Write(append(header, query...))
+
+		app.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1500)
+		n, err := app.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], append(header, query...)) {
+			t.Errorf("reply %q, want %q", buf[:n], query)
+		}
+	}
+
+	// Обе датаграммы к одной цели - один поток
+	request := <-requests
+	if request.command != 2 || request.target != "1.2.3.4:53" {
+		t.Errorf("server got %+v", request)
+	}
+	select {
+	case extra := <-requests:
+		t.Errorf("second flow opened: %+v", extra)
+	default:
+	}
+}
+
+func TestNewClientValidation(t *testing.T) {
+	if _, err := NewClient(&Config{Server: "127.0.0.1:443", UUID: "", Socks: "127.0.0.1:0"}); err == nil {
+		t.Error("empty uuid accepted")
+	}
+	if _, err := NewClient(&Config{Server: "127.0.0.1:443", UUID: testUUID}); err == nil {
+		t.Error("config without socks and tun accepted")
+	}
+}
@@ -0,0 +1,209 @@
+//go:build linux
+
+package standalone
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+
+	xnet "github.com/xtls/xray-core/common/net"
+)
+
+// TUN: пакеты устройства разбирает стек gVisor; каждое TCP-соединение
+// и UDP-поток (источник, цель) уходят на сервер запросом VLESS
+const (
+	tunNIC tcpip.NICID = 1
+
+	// tunMaxInFlight - незавершённых TCP-хэндшейков стека
+	tunMaxInFlight = 1024
+
+	// tunUDPIdleTimeout - UDP-поток без пакетов в обе стороны закрывается
+	tunUDPIdleTimeout = 60 * time.Second
+)
+
+// ServeTun поднимает TUN-устройство и работает до отмены ctx
+func (c *Client) ServeTun(ctx context.Context, config *TunConfig) error {
+	mtu := config.MTU
+	if mtu == 0 {
+		mtu = DefaultTunMTU
+	}
+
+	fd, err := tun.Open(config.Name)
+	if err != nil {
+		return fmt.Errorf("open tun %s: %w", config.Name, err)
+	}
+	defer unix.Close(fd)
+
+	link, err := netlink.LinkByName(config.Name)
+	if err != nil {
+		return fmt.Errorf("tun %s: %w", config.Name, err)
+	}
+	if err := netlink.LinkSetMTU(link, int(mtu)); err != nil {
+		return fmt.Errorf("tun %s mtu: %w", config.Name, err)
+	}
+
+	ep, err := fdbased.New(&fdbased.Options{
+		FDs:               []int{fd},
+		MTU:               mtu,
+		RXChecksumOffload: true,
+	})
+	if err != nil {
+		return fmt.Errorf("tun %s endpoint: %w", config.Name, err)
+	}
+	s, err := newTunStack(ep)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	tcpForwarder := tcp.NewForwarder(s, 0, tunMaxInFlight, func(r *tcp.ForwarderRequest) {
+		go c.handleTunTCP(ctx, r)
+	})
+	s.SetTransportProtocolHandler(tcp.ProtocolNumber, tcpForwarder.HandlePacket)
+	udpForwarder := udp.NewForwarder(s, func(r *udp.ForwarderRequest) bool {
+		return c.handleTunUDP(ctx, r)
+	})
+	s.SetTransportProtocolHandler(udp.ProtocolNumber, udpForwarder.HandlePacket)
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("tun %s up: %w", config.Name, err)
+	}
+	defer netlink.LinkSetDown(link)
+
+	<-ctx.Done()
+	return nil
+}
+
+// newTunStack создаёт стек gVisor, принимающий пакеты к любым адресам
+func newTunStack(ep stack.LinkEndpoint) (*stack.Stack, error) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	if err := s.CreateNIC(tunNIC, ep); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("tun stack: %s", err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: tunNIC},
+		{Destination: header.IPv6EmptySubnet, NIC: tunNIC},
+	})
+
+	// Стек отвечает от имени любой цели
+	if err := s.SetSpoofing(tunNIC, true); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("tun stack spoofing: %s", err)
+	}
+	if err := s.SetPromiscuousMode(tunNIC, true); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("tun stack promiscuous: %s", err)
+	}
+	sack := tcpip.TCPSACKEnabled(true)
+	s.SetTransportProtocolOption(tcp.ProtocolNumber, &sack)
+	return s, nil
+}
+
+// handleTunTCP принимает TCP-соединение устройства и ведёт его к цели
+func (c *Client) handleTunTCP(ctx context.Context, r *tcp.ForwarderRequest) {
+	id := r.ID()
+	var wq waiter.Queue
+	ep, tcpErr := r.CreateEndpoint(&wq)
+	if tcpErr != nil {
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+
+	conn := gonet.NewTCPConn(&wq, ep)
+	defer conn.Close()
+
+	// Локальный адрес стороны gVisor - цель соединения
+	target := xnet.TCPDestination(xnet.IPAddress(id.LocalAddress.AsSlice()), xnet.Port(id.LocalPort))
+	remote, err := c.DialTCP(ctx, target)
+	if err != nil {
+		c.logf("tun tcp %s: %v", target, err)
+		return
+	}
+	defer remote.Close()
+	relay(conn, remote)
+}
+
+// handleTunUDP открывает UDP-поток на первый пакет (источник, цель)
+// Вызывается из стека - соединение с сервером открывается в горутине
+func (c *Client) handleTunUDP(ctx context.Context, r *udp.ForwarderRequest) bool {
+	id := r.ID()
+	var wq waiter.Queue
+	ep, err := r.CreateEndpoint(&wq)
+	if err != nil {
+		return false
+	}
+	conn := gonet.NewUDPConn(&wq, ep)
+	target := xnet.UDPDestination(xnet.IPAddress(id.LocalAddress.AsSlice()), xnet.Port(id.LocalPort))
+	go c.serveTunUDP(ctx, conn, target)
+	return true
+}
+
+// serveTunUDP пересылает датаграммы потока, пока он не простаивает
+// tunUDPIdleTimeout
+func (c *Client) serveTunUDP(ctx context.Context, conn *gonet.UDPConn, target xnet.Destination) {
+	defer conn.Close()
+
+	flow, err := c.DialUDP(ctx, target)
+	if err != nil {
+		c.logf("tun udp %s: %v", target, err)
+		return
+	}
+	defer flow.Close()
+
+	var lastActive atomic.Int64
+	lastActive.Store(time.Now().UnixNano())
+	go func() {
+		buf := make([]byte, maxUDPPacket)
+		for {
+			n, err := flow.ReadPacket(buf)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			lastActive.Store(time.Now().UnixNano())
+			conn.Write(buf[:n])
+		}
+	}()
+
+	buf := make([]byte, maxUDPPacket)
+	for {
+		conn.SetReadDeadline(time.Now().Add(tunUDPIdleTimeout))
+		n, err := conn.Read(buf)
+		if err != nil {
+			// Дедлайн чтения - не простой, если цель ещё отвечает
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() &&
+				time.Since(time.Unix(0, lastActive.Load())) < tunUDPIdleTimeout {
+				continue
+			}
+			return
+		}
+		lastActive.Store(time.Now().UnixNano())
+		if err := flow.WritePacket(buf[:n]); err != nil {
+			return
+		}
+	}
+}
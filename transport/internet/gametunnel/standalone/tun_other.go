@@ -0,0 +1,13 @@
+//go:build !linux
+
+package standalone
+
+import (
+	"context"
+	"fmt"
+)
+
+// ServeTun - TUN-устройство поддерживается только на Linux
+func (c *Client) ServeTun(ctx context.Context, config *TunConfig) error {
+	return fmt.Errorf("tun is supported on linux only")
+}
@@ -0,0 +1,143 @@
+package gametunnel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/common/session"
+)
+
+// ====================================================================
+// Приоритет потоков
+// ====================================================================
+//
+// Классификация по размеру ошибается: маленькие сегменты
+// TCP-загрузки выглядят как игровые пакеты и попадают в High.
+// Поэтому приоритет можно задать явно:
+//   - приложение вызывает SetPriority(streamID, level) на соединении
+//   - клиент берёт подсказку из контекста xray при Dial:
+//     DNS (порт 53) и известные игровые порты → High
+//
+// Поток с явным приоритетом («закреплённый») через Classifier
+// не проходит. PriorityAuto снимает закрепление.
+//
+// SetPriority и SetDuplicate соединения принимают поток 0 (само
+// соединение) и открытые потоки приложения (streams.go). Другой ID -
+// ErrUnknownStream: иначе запись в таблице потоков осталась бы за
+// потоком, которого нет, и заняла бы место под maxStreams.
+//
+// ====================================================================
+
+// PriorityAuto - снять явный приоритет потока и вернуть
+// автоматическую классификацию (Classifier)
+const PriorityAuto PriorityLevel = 0xFF
+
+// ErrUnknownStream - поток с таким ID в соединении не открыт
+var ErrUnknownStream = errors.New("gametunnel: unknown stream")
+
+// checkStream проверяет, что поток id есть в соединении: 0 - само
+// соединение, остальные - открытые потоки приложения streams (nil -
+// потоки не согласованы)
+func checkStream(streams *streamMux, id uint16) error {
+	if id == 0 {
+		return nil
+	}
+	if streams == nil {
+		return fmt.Errorf("stream %d: %w, streams not negotiated", id, ErrUnknownStream)
+	}
+	if streams.get(id) == nil {
+		return fmt.Errorf("stream %d: %w", id, ErrUnknownStream)
+	}
+	return nil
+}
+
+// gamePortRange - диапазон портов, характерных для игрового трафика
+type gamePortRange struct {
+	from, to xnet.Port
+}
+
+// knownGamePorts - порты игровых серверов и голосовых чатов
+// Трафик на них получает High независимо от размера пакетов
+var knownGamePorts = []gamePortRange{
+	{3074, 3074},   // Xbox Live, Call of Duty
+	{3478, 3480},   // PlayStation Network, STUN/TURN
+	{3659, 3659},   // EA (Apex Legends, Battlefield)
+	{5055, 5058},   // Photon Engine
+	{7777, 7788},   // Unreal Engine dedicated servers
+	{27015, 27050}, // Steam / Source Engine
+}
+
+// isGamePort проверяет, относится ли порт к известным игровым
+func isGamePort(port xnet.Port) bool {
+	for _, r := range knownGamePorts {
+		if port >= r.from && port <= r.to {
+			return true
+		}
+	}
+	return false
+}
+
+// priorityHintFromContext извлекает подсказку приоритета из контекста xray
+// Смотрит на исходную цель проксируемого соединения (первый outbound)
+func priorityHintFromContext(ctx context.Context) (PriorityLevel, bool) {
+	outbounds := session.OutboundsFromContext(ctx)
+	if len(outbounds) == 0 {
+		return 0, false
+	}
+
+	target := outbounds[0].Target
+	if !target.IsValid() {
+		return 0, false
+	}
+
+	if target.Port == 53 {
+		return PriorityHigh, true
+	}
+
+	if target.Network == xnet.Network_UDP && isGamePort(target.Port) {
+		return PriorityHigh, true
+	}
+
+	return 0, false
+}
+
+// setStreamPriority закрепляет приоритет потока в таблице потоков
+// Вызывается под мьютексом владельца таблицы
+func setStreamPriority(streams map[uint16]*Stream, streamID uint16, level PriorityLevel, maxStreams uint32) error {
+	if level != PriorityAuto && level >= PriorityLevels {
+		return fmt.Errorf("invalid priority level: %d", level)
+	}
+
+	stream, exists := streams[streamID]
+	if !exists {
+		if level == PriorityAuto {
+			return nil
+		}
+		if uint32(len(streams)) >= maxStreams {
+			return fmt.Errorf("too many streams: limit %d", maxStreams)
+		}
+		stream = &Stream{ID: streamID, Active: true}
+		streams[streamID] = stream
+	}
+
+	if level == PriorityAuto {
+		stream.Pinned = false
+		return nil
+	}
+
+	stream.Priority = uint8(level)
+	stream.Pinned = true
+	return nil
+}
+
+// lookupStreamPriority возвращает закреплённый приоритет потока
+// Вызывается под мьютексом владельца таблицы
+func lookupStreamPriority(streams map[uint16]*Stream, streamID uint16) (PriorityLevel, bool) {
+	stream, exists := streams[streamID]
+	if !exists || !stream.Pinned {
+		return 0, false
+	}
+	return PriorityLevel(stream.Priority), true
+}
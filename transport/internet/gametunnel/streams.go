@@ -0,0 +1,206 @@
+package gametunnel
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Потоки приложения в одном соединении (OpenStream / AcceptStream)
+// ====================================================================
+//
+// Приложение, встроившее GameTunnel без xray, хочет развести игровой
+// трафик, чат и загрузки внутри одного туннеля: у каждого свой поток
+// со своим приоритетом, а хэндшейк и маппинг NAT общие.
+//
+// С appStreams клиент просит в Client Hello helloCapStreams; новый
+// сервер соглашается всегда. DATA такой сессии - кадры потоков из
+// mux.go ([stream ID 2][данные]), поток 0 - само соединение:
+//
+//	клиент                                   сервер
+//	GameTunnelClientConn  <- поток 0 ->      GameTunnelConn
+//	OpenStream(level)     <- поток N ->      AcceptStream()
+//
+// Потоки открывает только клиент, по возрастанию ID; сервер получает
+// их AcceptStream в порядке первых кадров. Закрытие потока - тот же
+// STREAM_CLOSE (0x0A), закрытие соединения закрывает все его потоки.
+// Приоритет OpenStream закрепляется за потоком на клиенте (как
+// SetPriority); серверная сторона задаёт свой SetPriority потока.
+//
+// Старый сервер байт возможностей с незнакомым битом не разбирает и
+// отвечает обычной сессией - OpenStream вернёт ошибку. Потоки живут,
+// пока жива сессия: соединение с appStreams не переподключается, а
+// 0-RTT и склейка записей (coalesce.go) для него выключены.
+//
+// ====================================================================
+
+const (
+	// helloCapStreams - байт возможностей хэндшейка: потоки приложения
+	helloCapStreams byte = 0x08
+
+	// streamAcceptBacklog - потоков, ждущих AcceptStream на сервере
+	streamAcceptBacklog = 64
+)
+
+// ====================================================================
+// Клиент
+// ====================================================================
+
+// appStreamOwner - клиентская сессия как владелец потоков приложения
+type appStreamOwner struct {
+	c *GameTunnelClientConn
+}
+
+// writeStream отправляет данные потока id
+func (o appStreamOwner) writeStream(id uint16, b []byte) (int, error) {
+	return o.c.writeStream(id, b)
+}
+
+// closeStream закрывает поток, снимает его приоритет и шлёт STREAM_CLOSE
+func (o appStreamOwner) closeStream(id uint16) {
+	if o.c.streams.remove(id) == nil {
+		return
+	}
+	o.c.unpinStream(id)
+	o.c.sendSealedControl(0x0A, streamCloseBody(id))
+}
+
+// OpenStream открывает новый поток в сессии соединения
+// level закрепляет приоритет потока (PriorityAuto - классификатор)
+func (c *GameTunnelClientConn) OpenStream(level PriorityLevel) (net.Conn, error) {
+	if c.streams == nil {
+		return nil, fmt.Errorf("streams not negotiated: enable appStreams on the client and update the server")
+	}
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, net.ErrClosed
+	}
+	s, err := c.streams.open(appStreamOwner{c}, c.LocalAddr(), c.RemoteAddr(), c.config.MaxStreams)
+	if err != nil {
+		return nil, err
+	}
+	if level != PriorityAuto {
+		if err := c.SetPriority(s.id, level); err != nil {
+			c.streams.remove(s.id)
+			return nil, err
+		}
+	}
+	s.setPriority = func(level PriorityLevel) error { return c.SetPriority(s.id, level) }
+	s.setDuplicate = func(on bool) error { return c.SetDuplicate(s.id, on) }
+	return s, nil
+}
+
+// unpinStream убирает поток id из таблицы приоритетов сессии
+func (c *GameTunnelClientConn) unpinStream(id uint16) {
+	session := c.session()
+	session.mu.Lock()
+	delete(session.Streams, id)
+	session.mu.Unlock()
+}
+
+// deliverAppStream передаёт кадр сервера потоку: 0 - самому соединению
+func (c *GameTunnelClientConn) deliverAppStream(session *ClientSession, frame []byte) {
+	id, data, ok := parseStreamFrame(frame)
+	if !ok || len(data) == 0 {
+		return
+	}
+	if id != 0 {
+		if s := c.streams.get(id); s != nil {
+			s.push(data)
+		}
+		return
+	}
+	c.pushInbound(session, data)
+}
+
+// classifyStream определяет приоритет кадра потока id: закреплённый
+// за потоком или, как у соединения, classify
+func (c *GameTunnelClientConn) classifyStream(id uint16, data []byte) PriorityLevel {
+	if id != 0 {
+		session := c.session()
+		session.mu.RLock()
+		level, pinned := lookupStreamPriority(session.Streams, id)
+		session.mu.RUnlock()
+		if pinned {
+			return level
+		}
+	}
+	return c.classify(data)
+}
+
+// ====================================================================
+// Сервер
+// ====================================================================
+
+// AcceptStream ждёт поток, открытый клиентом OpenStream
+// Возвращает ошибку, когда соединение закрыто или клиент потоки не
+// согласовал
+func (c *GameTunnelConn) AcceptStream() (net.Conn, error) {
+	if c.session.streamAccept == nil {
+		return nil, fmt.Errorf("streams not negotiated by the client")
+	}
+	select {
+	case s := <-c.session.streamAccept:
+		s.local = c.local
+		return s, nil
+	case <-c.session.streams.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// appStreams - потоки приложения соединения (nil - не согласованы;
+// потоки общей сессии соединению не принадлежат)
+func (c *GameTunnelConn) appStreams() *streamMux {
+	if c.session.streamAccept == nil {
+		return nil
+	}
+	return c.session.streams
+}
+
+// acceptAppStream открывает серверный поток приложения по первому
+// кадру клиента и ставит его в очередь AcceptStream
+func (h *Hub) acceptAppStream(session *Session, id uint16) *muxStream {
+	owner := serverStreams{hub: h, session: session}
+	s := session.streams.accept(id, owner, nil, session.RemoteAddr, h.getConfig().MaxStreams)
+	if s == nil {
+		return nil
+	}
+	s.setPriority = func(level PriorityLevel) error {
+		session.mu.Lock()
+		defer session.mu.Unlock()
+		return setStreamPriority(session.Streams, id, level, h.getConfig().MaxStreams)
+	}
+	s.setDuplicate = func(on bool) error {
+		session.mu.Lock()
+		defer session.mu.Unlock()
+		return setStreamDuplicate(session.Streams, id, on, h.getConfig().MaxStreams)
+	}
+	select {
+	case session.streamAccept <- s:
+		return s
+	default:
+		// Приложение не разбирает потоки
+		owner.closeStream(id)
+		return nil
+	}
+}
+
+// unpinStream убирает поток id из таблицы приоритетов сессии
+func (h *Hub) unpinStream(session *Session, id uint16) {
+	session.mu.Lock()
+	delete(session.Streams, id)
+	session.mu.Unlock()
+}
+
+// classifyStream определяет приоритет кадра потока id на сервере
+func (h *Hub) classifyStream(session *Session, id uint16, data []byte) PriorityLevel {
+	if id != 0 {
+		session.mu.RLock()
+		level, pinned := lookupStreamPriority(session.Streams, id)
+		session.mu.RUnlock()
+		if pinned {
+			return level
+		}
+	}
+	return h.classify(session, data)
+}
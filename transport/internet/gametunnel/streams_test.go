@@ -0,0 +1,144 @@
+package gametunnel
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptTestStream ждёт поток на серверном соединении
+func acceptTestStream(t *testing.T, server net.Conn) net.Conn {
+	t.Helper()
+
+	type result struct {
+		s   net.Conn
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		s, err := server.(*GameTunnelConn).AcceptStream()
+		done <- result{s, err}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatalf("AcceptStream: %v", r.err)
+		}
+		return r.s
+	case <-time.After(5 * time.Second):
+		t.Fatal("AcceptStream timed out")
+	}
+	return nil
+}
+
+func TestAppStreams(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "streams"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.AppStreams = true
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+	if client.streams == nil {
+		t.Fatal("streams not negotiated")
+	}
+
+	chat, err := client.OpenStream(PriorityLow)
+	if err != nil {
+		t.Fatalf("OpenStream: %v", err)
+	}
+	if got := client.classifyStream(chat.(*muxStream).id, []byte("x")); got != PriorityLow {
+		t.Errorf("chat stream class %v, want Low", got)
+	}
+
+	// Поток 0 и поток чата не смешиваются
+	client.Write([]byte("game"))
+	chat.Write([]byte("chat"))
+	if got := readWithTimeout(t, server, 4); string(got) != "game" {
+		t.Fatalf("Server conn got %q", got)
+	}
+	serverChat := acceptTestStream(t, server)
+	if got := readWithTimeout(t, serverChat, 4); string(got) != "chat" {
+		t.Fatalf("Server stream got %q", got)
+	}
+
+	serverChat.Write([]byte("reply"))
+	server.Write([]byte("state"))
+	if got := readWithTimeout(t, chat, 5); string(got) != "reply" {
+		t.Errorf("Client stream got %q", got)
+	}
+	if got := readWithTimeout(t, client, 5); string(got) != "state" {
+		t.Errorf("Client conn got %q", got)
+	}
+
+	// Приоритет задаётся открытым потокам, но не чужим ID
+	chatID := chat.(*muxStream).id
+	if err := server.(*GameTunnelConn).SetPriority(chatID, PriorityHigh); err != nil {
+		t.Errorf("server SetPriority of an accepted stream: %v", err)
+	}
+	if err := server.(*GameTunnelConn).SetPriority(chatID+1, PriorityHigh); !errors.Is(err, ErrUnknownStream) {
+		t.Errorf("server SetPriority of an unknown stream: %v", err)
+	}
+
+	// Закрытие потока - EOF на сервере, соединение живёт
+	chat.Close()
+	serverChat.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := serverChat.Read(make([]byte, 16)); err != io.EOF {
+		t.Errorf("Server stream read after close: %v", err)
+	}
+	client.Write([]byte("alive"))
+	if got := readWithTimeout(t, server, 5); string(got) != "alive" {
+		t.Errorf("Server conn got %q", got)
+	}
+	if err := client.SetPriority(chatID, PriorityHigh); !errors.Is(err, ErrUnknownStream) {
+		t.Errorf("SetPriority of a closed stream: %v", err)
+	}
+	if _, pinned := lookupStreamPriority(client.session().Streams, chatID); pinned {
+		t.Error("closed stream left pinned")
+	}
+
+	// Закрытие соединения завершает AcceptStream
+	client.Close()
+	server.Close()
+	if _, err := server.(*GameTunnelConn).AcceptStream(); err == nil {
+		t.Error("AcceptStream on a closed connection")
+	}
+}
+
+func TestOpenStreamNotNegotiated(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "streams"
+	l, accepted := startTestListener(t, config)
+
+	client, server := dialTestClient(t, l, config, accepted)
+	defer client.Close()
+	if _, err := client.OpenStream(PriorityAuto); err == nil {
+		t.Error("OpenStream without appStreams")
+	}
+	if _, err := server.(*GameTunnelConn).AcceptStream(); err == nil {
+		t.Error("AcceptStream without appStreams")
+	}
+
+	// Без потоков есть только поток 0
+	if err := client.SetPriority(0, PriorityHigh); err != nil {
+		t.Errorf("SetPriority of the connection: %v", err)
+	}
+	if err := client.SetPriority(1, PriorityHigh); !errors.Is(err, ErrUnknownStream) {
+		t.Errorf("client SetPriority of stream 1: %v", err)
+	}
+	if err := client.SetDuplicate(1, true); !errors.Is(err, ErrUnknownStream) {
+		t.Errorf("client SetDuplicate of stream 1: %v", err)
+	}
+	if err := server.(*GameTunnelConn).SetPriority(1, PriorityHigh); !errors.Is(err, ErrUnknownStream) {
+		t.Errorf("server SetPriority of stream 1: %v", err)
+	}
+
+	// Поток 0 без кадров: обычная сессия
+	client.Write([]byte("plain"))
+	if got := readWithTimeout(t, server, 5); string(got) != "plain" {
+		t.Errorf("Server got %q", got)
+	}
+}
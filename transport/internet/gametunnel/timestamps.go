@@ -0,0 +1,266 @@
+package gametunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ====================================================================
+// Метки времени в DATA: односторонняя задержка и bufferbloat
+// ====================================================================
+//
+// Ping (quality.go) меряет RTT раз в интервал keep-alive и не
+// различает, в какую сторону растёт очередь. С timestamps = true
+// каждый DATA несёт внутри шифрования метки времени, как TCP
+// Timestamps (RFC 7323) и LEDBAT (RFC 6817):
+//
+//	DATA: [ts 4][echo 4][hold 2][данные]
+//
+//	ts   - время отправки, микросекунды часов отправителя (mod 2^32)
+//	echo - последняя метка ts, принятая от другой стороны
+//	hold - сколько echo пролежала у отправителя, единицы 64 мкс;
+//	       0xFFFF - эха нет (меток ещё не было или оно старше 4 с)
+//
+// Часы сторон не синхронизированы: приём - ts - это задержка пути плюс
+// неизвестный сдвиг часов. Сдвиг постоянен, поэтому рост над минимумом
+// за окно timestampBaseWindow - очередь на пути к нам (inbound). Эхо
+// даёт RTT каждого пакета; его рост над минимумом - очереди обоих
+// направлений, и за вычетом inbound остаётся очередь на пути от нас
+// (outbound). Оценки сглажены с весом 1/8, как srtt.
+//
+// Куда идут оценки:
+//   - pacer (pacing.go): пока очередь outbound выше
+//     timestampBloatDelay, Low и Medium расходуют вдвое больше
+//     токенов - загрузка притормаживает, пока буфер узкого места не
+//     опустеет; High не ждёт, как и раньше;
+//   - оценка качества (quality.go): очереди и признак bufferbloat -
+//     в LinkQuality.Timestamps, RTT по меткам реагирует на очередь
+//     быстрее Ping и идёт в штраф задержки.
+//
+// Режим стоит 10 байт в каждом DATA и согласуется в хэндшейке битом
+// helloCapTimestamps: метки идут в обе стороны, если обе стороны
+// включили timestamps. Пустой DATA (chaff.go) меток не несёт, 0-RTT
+// сессии (zerortt.go) идут без меток - ранние данные уходят до
+// ответа сервера.
+//
+// ====================================================================
+
+const (
+	// helloCapTimestamps - байт возможностей хэндшейка: метки времени
+	// в DATA
+	helloCapTimestamps byte = 0x40
+
+	// timestampSize - метки в начале открытого текста DATA
+	timestampSize = 4 + 4 + 2
+
+	// timestampHoldUnit - единица поля hold
+	timestampHoldUnit = 64 * time.Microsecond
+
+	// timestampNoEcho - hold без эха
+	timestampNoEcho = 0xFFFF
+
+	// timestampBaseWindow - окно минимума задержки: переживает
+	// медленный дрейф часов и смену маршрута
+	timestampBaseWindow = 10 * time.Second
+
+	// timestampMaxRTT - замер RTT длиннее - ошибка (перезапуск
+	// стороны, переполнение часов)
+	timestampMaxRTT = 10 * time.Second
+
+	// timestampBloatDelay - очередь, с которой путь считается
+	// раздутым буфером
+	timestampBloatDelay = 20 * time.Millisecond
+)
+
+// timestampEpoch - начало часов меток процесса (монотонное)
+var timestampEpoch = time.Now()
+
+// timestampNow - показание часов меток, микросекунды
+func timestampNow() uint32 {
+	return uint32(time.Since(timestampEpoch) / time.Microsecond)
+}
+
+// timestampBefore сравнивает показания часов меток с учётом
+// переполнения
+func timestampBefore(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+// windowMin - минимум замеров за скользящее окно из двух половин
+type windowMin struct {
+	cur, prev uint32
+	since     uint32
+	set       bool
+}
+
+// add учитывает замер v в момент now и возвращает минимум окна
+func (w *windowMin) add(v, now uint32) uint32 {
+	half := uint32(timestampBaseWindow / 2 / time.Microsecond)
+	switch {
+	case !w.set:
+		*w = windowMin{cur: v, prev: v, since: now, set: true}
+	case now-w.since >= half:
+		w.prev, w.cur, w.since = w.cur, v, now
+	case timestampBefore(v, w.cur):
+		w.cur = v
+	}
+	return w.value()
+}
+
+// value - минимум окна
+func (w *windowMin) value() uint32 {
+	if timestampBefore(w.prev, w.cur) {
+		return w.prev
+	}
+	return w.cur
+}
+
+// delayTrend - метки времени сессии и задержки по ним (nil - метки
+// не согласованы)
+type delayTrend struct {
+	// peer - последняя метка другой стороны (старшие 32 бита) и время
+	// её приёма по нашим часам (младшие); peerSeen - метки были
+	peer     uint64
+	peerSeen int32
+
+	// bloated - очередь outbound выше timestampBloatDelay (atomic)
+	bloated int32
+
+	mu sync.Mutex
+
+	// owd - минимум (приём - ts), inQueue - сглаженная очередь
+	// inbound (микросекунды)
+	owd     windowMin
+	inQueue int64
+
+	// rtt - минимум RTT по эху, srtt - сглаженный RTT (микросекунды,
+	// 0 - замеров не было)
+	rtt  windowMin
+	srtt int64
+}
+
+// DelayTrend - задержки по меткам времени одной стороны
+type DelayTrend struct {
+	// InboundQueue / OutboundQueue - очередь на пути к этой стороне и
+	// от неё: рост задержки над минимумом
+	InboundQueue  time.Duration `json:"inboundQueue"`
+	OutboundQueue time.Duration `json:"outboundQueue"`
+
+	// RTT - сглаженный RTT по эху меток
+	RTT time.Duration `json:"rtt"`
+
+	// Bufferbloat - очередь одного из направлений выше 20 мс
+	Bufferbloat bool `json:"bufferbloat"`
+}
+
+// acceptTimestamps - метки времени сессии по ответу сервера (nil -
+// сервер их не включил)
+func acceptTimestamps(hello *HandshakePayload) *delayTrend {
+	if hello.Capabilities&helloCapTimestamps == 0 {
+		return nil
+	}
+	return &delayTrend{}
+}
+
+// seal дописывает к dst метки времени now и payload
+// nil (метки не согласованы) возвращает payload как есть
+func (t *delayTrend) seal(dst, payload []byte, now uint32) []byte {
+	if t == nil {
+		return payload
+	}
+	echo, hold := uint32(0), uint16(timestampNoEcho)
+	if atomic.LoadInt32(&t.peerSeen) != 0 {
+		peer := atomic.LoadUint64(&t.peer)
+		echo = uint32(peer >> 32)
+		if held := (now - uint32(peer)) / uint32(timestampHoldUnit/time.Microsecond); held < timestampNoEcho {
+			hold = uint16(held)
+		}
+	}
+	dst = binary.BigEndian.AppendUint32(dst, now)
+	dst = binary.BigEndian.AppendUint32(dst, echo)
+	dst = binary.BigEndian.AppendUint16(dst, hold)
+	return append(dst, payload...)
+}
+
+// open снимает метки времени с открытого текста DATA, принятого в
+// момент now, и учитывает их. Данные сдвигаются на место меток -
+// буфер пула остаётся целым (bufpool.go)
+// nil (метки не согласованы) и пустой DATA возвращаются как есть
+func (t *delayTrend) open(plaintext []byte, now uint32) ([]byte, error) {
+	if t == nil || len(plaintext) == 0 {
+		return plaintext, nil
+	}
+	if len(plaintext) < timestampSize {
+		return nil, fmt.Errorf("data of %d bytes without timestamps", len(plaintext))
+	}
+	t.observe(binary.BigEndian.Uint32(plaintext), binary.BigEndian.Uint32(plaintext[4:]),
+		binary.BigEndian.Uint16(plaintext[8:]), now)
+	n := copy(plaintext, plaintext[timestampSize:])
+	return plaintext[:n], nil
+}
+
+// observe учитывает метки пакета, принятого в момент now
+func (t *delayTrend) observe(ts, echo uint32, hold uint16, now uint32) {
+	atomic.StoreUint64(&t.peer, uint64(ts)<<32|uint64(now))
+	atomic.StoreInt32(&t.peerSeen, 1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Односторонняя задержка: сдвиг часов уходит в минимуме
+	owd := now - ts
+	t.inQueue += (int64(owd-t.owd.add(owd, now)) - t.inQueue) >> statsEWMAShift
+
+	if hold != timestampNoEcho {
+		rtt := now - echo - uint32(hold)*uint32(timestampHoldUnit/time.Microsecond)
+		if int32(rtt) > 0 && rtt < uint32(timestampMaxRTT/time.Microsecond) {
+			t.rtt.add(rtt, now)
+			if t.srtt == 0 {
+				t.srtt = int64(rtt)
+			} else {
+				t.srtt += (int64(rtt) - t.srtt) >> statsEWMAShift
+			}
+		}
+	}
+
+	bloated := int32(0)
+	if t.outQueueLocked() > int64(timestampBloatDelay/time.Microsecond) {
+		bloated = 1
+	}
+	atomic.StoreInt32(&t.bloated, bloated)
+}
+
+// outQueueLocked - очередь outbound: очереди RTT за вычетом inbound
+// (микросекунды)
+func (t *delayTrend) outQueueLocked() int64 {
+	if t.srtt == 0 {
+		return 0
+	}
+	return max(t.srtt-int64(t.rtt.value())-t.inQueue, 0)
+}
+
+// sendBloated сообщает, что очередь на пути от нас растёт
+// (nil - метки не согласованы)
+func (t *delayTrend) sendBloated() bool {
+	return t != nil && atomic.LoadInt32(&t.bloated) != 0
+}
+
+// snapshot возвращает задержки по меткам (nil - метки не согласованы
+// или ещё не приходили)
+func (t *delayTrend) snapshot() *DelayTrend {
+	if t == nil || atomic.LoadInt32(&t.peerSeen) == 0 {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trend := &DelayTrend{
+		InboundQueue:  time.Duration(max(t.inQueue, 0)) * time.Microsecond,
+		OutboundQueue: time.Duration(t.outQueueLocked()) * time.Microsecond,
+		RTT:           time.Duration(t.srtt) * time.Microsecond,
+	}
+	trend.Bufferbloat = max(trend.InboundQueue, trend.OutboundQueue) > timestampBloatDelay
+	return trend
+}
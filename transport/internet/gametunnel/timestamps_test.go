@@ -0,0 +1,183 @@
+package gametunnel
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimestampsOpen(t *testing.T) {
+	var nilTrend *delayTrend
+	payload := []byte("player_move")
+	if got := nilTrend.seal(nil, payload, 1); !bytes.Equal(got, payload) {
+		t.Errorf("nil trend sealed %q", got)
+	}
+	if got, err := nilTrend.open(payload, 1); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("nil trend opened %q, %v", got, err)
+	}
+
+	trend := &delayTrend{}
+	sealed := trend.seal(nil, payload, 1000)
+	if len(sealed) != timestampSize+len(payload) {
+		t.Fatalf("sealed %d bytes", len(sealed))
+	}
+	// Эха ещё нет
+	if hold := uint16(sealed[8])<<8 | uint16(sealed[9]); hold != timestampNoEcho {
+		t.Errorf("hold %#x before any peer timestamp", hold)
+	}
+	// Метки снимаются в том же буфере
+	got, err := (&delayTrend{}).open(sealed, 2000)
+	if err != nil || !bytes.Equal(got, payload) || &got[0] != &sealed[0] {
+		t.Errorf("opened %q, %v", got, err)
+	}
+
+	// Пустой DATA (chaff) меток не несёт, короткий - ошибка
+	if got, err := trend.open(nil, 1); err != nil || len(got) != 0 {
+		t.Errorf("empty data: %q, %v", got, err)
+	}
+	if _, err := trend.open(make([]byte, timestampSize-1), 1); err == nil {
+		t.Error("data shorter than timestamps accepted")
+	}
+	if trend.snapshot() != nil {
+		t.Error("snapshot without peer timestamps")
+	}
+}
+
+func TestTimestampsDelayTrend(t *testing.T) {
+	us := func(d time.Duration) uint32 { return uint32(d / time.Microsecond) }
+
+	// Часы b сдвинуты и переполняются посреди замера
+	client, server := &delayTrend{}, &delayTrend{}
+	clockA := func(now uint32) uint32 { return now }
+	clockB := func(now uint32) uint32 { return now + 0xFFFF0000 }
+	exchange := func(from, to *delayTrend, fromClock, toClock func(uint32) uint32, sent, delay uint32) {
+		t.Helper()
+		data, err := to.open(from.seal(nil, []byte("tick"), fromClock(sent)), toClock(sent+delay))
+		if err != nil || string(data) != "tick" {
+			t.Fatalf("opened %q, %v", data, err)
+		}
+	}
+
+	// Путь 10 мс в обе стороны, затем очередь растёт на пути от клиента
+	down := us(10 * time.Millisecond)
+	for i := 0; i < 200; i++ {
+		now := uint32(i) * us(10*time.Millisecond)
+		up := us(10 * time.Millisecond)
+		if i >= 100 {
+			up += uint32(i-100) * us(time.Millisecond)
+		}
+		exchange(client, server, clockA, clockB, now, up)
+		exchange(server, client, clockB, clockA, now+up+us(time.Millisecond), down)
+
+		if i == 99 {
+			if client.sendBloated() || server.sendBloated() {
+				t.Fatal("bufferbloat reported on a steady path")
+			}
+			if rtt := client.snapshot().RTT; rtt < 20*time.Millisecond || rtt > 21*time.Millisecond {
+				t.Errorf("steady RTT %v, want about 20ms", rtt)
+			}
+		}
+	}
+
+	c := client.snapshot()
+	if c.OutboundQueue < 50*time.Millisecond || c.InboundQueue > time.Millisecond || !c.Bufferbloat {
+		t.Errorf("client trend %+v, want the queue outbound", c)
+	}
+	if !client.sendBloated() {
+		t.Error("client pacer not told about bufferbloat")
+	}
+	s := server.snapshot()
+	if s.InboundQueue < 50*time.Millisecond || s.OutboundQueue > 5*time.Millisecond || !s.Bufferbloat {
+		t.Errorf("server trend %+v, want the queue inbound", s)
+	}
+	if server.sendBloated() {
+		t.Error("server pacer slowed down by the other direction")
+	}
+}
+
+func TestTimestampsPacerDrain(t *testing.T) {
+	config := DefaultConfig()
+	config.EnablePacing = true
+	config.PacingRate = 1_000_000
+	bloated := &delayTrend{bloated: 1}
+
+	// Раздутый буфер: Low расходует вдвое больше токенов, High не ждёт
+	p := newPacer(config, NewBandwidthEstimator())
+	p.delay(int(p.bucket.burst), PriorityLow, nil)
+	steady := p.delay(1000, PriorityLow, nil)
+	p = newPacer(config, NewBandwidthEstimator())
+	p.delay(int(p.bucket.burst), PriorityLow, nil)
+	drain := p.delay(1000, PriorityLow, bloated)
+	if drain < 2*steady-time.Millisecond {
+		t.Errorf("drain delay %v, steady %v", drain, steady)
+	}
+	if d := p.delay(1000, PriorityHigh, bloated); d != 0 {
+		t.Errorf("High delayed by %v", d)
+	}
+}
+
+func TestTimestampsLoopback(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "timestamps"
+	config.Timestamps = true
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	if client.session().timestamps == nil {
+		t.Fatal("client did not negotiate timestamps")
+	}
+	session := l.hub.sessions.get(client.session().ConnectionID)
+	if session == nil || session.timestamps == nil {
+		t.Fatal("server session without timestamps")
+	}
+
+	for i := 0; i < 10; i++ {
+		request := []byte(fmt.Sprintf("player_move: seq=%d", i))
+		if _, err := client.Write(request); err != nil {
+			t.Fatal(err)
+		}
+		if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+			t.Fatalf("server got %q, want %q", got, request)
+		}
+		response := []byte(fmt.Sprintf("world_state: tick=%d", i))
+		if _, err := server.Write(response); err != nil {
+			t.Fatal(err)
+		}
+		if got := readWithTimeout(t, client, 2048); !bytes.Equal(got, response) {
+			t.Fatalf("client got %q, want %q", got, response)
+		}
+	}
+
+	// Эхо прошло в обе стороны: у обеих сторон есть RTT по меткам
+	if trend := client.session().timestamps.snapshot(); trend == nil || trend.RTT <= 0 {
+		t.Errorf("client trend %+v", trend)
+	}
+	if trend := session.timestamps.snapshot(); trend == nil || trend.RTT <= 0 {
+		t.Errorf("server trend %+v", trend)
+	}
+}
+
+func TestTimestampsNotNegotiated(t *testing.T) {
+	// Метки только у клиента - сессия без них
+	config := DefaultConfig()
+	config.Key = "timestamps"
+
+	l, accepted := startTestListener(t, config)
+	clientConfig := *config
+	clientConfig.Timestamps = true
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+
+	if client.session().timestamps != nil {
+		t.Fatal("client enabled timestamps without server support")
+	}
+	request := []byte("player_move: x=1 y=2")
+	if _, err := client.Write(request); err != nil {
+		t.Fatal(err)
+	}
+	if got := readWithTimeout(t, server, 2048); !bytes.Equal(got, request) {
+		t.Errorf("server got %q, want %q", got, request)
+	}
+}
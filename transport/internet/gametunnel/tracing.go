@@ -0,0 +1,405 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Трассировка OpenTelemetry (tracingEndpoint)
+// ====================================================================
+//
+// С tracingEndpoint транспорт отправляет спаны хэндшейков и сессий в
+// коллектор OpenTelemetry по OTLP/HTTP (JSON, POST /v1/traces) - по
+// ним оператор парка серверов видит, на каком шаге теряются секунды
+// установки соединения в отдельном регионе. Как и метрики, экспорт
+// без внешних зависимостей: спаны собираются и кодируются здесь.
+//
+// Спаны:
+//
+//	gametunnel.dial      - клиент, Dial целиком: резолв адресов,
+//	                       попытки хэндшейка, 0-RTT
+//	gametunnel.handshake - клиент, хэндшейк с одним адресом (дочерний
+//	                       спан dial или reconnect): RTT до Server Hello
+//	gametunnel.reconnect - клиент, переподключение: число попыток
+//	gametunnel.accept    - сервер, обработка Client Hello
+//	gametunnel.session   - обе стороны, сессия от создания до
+//	                       закрытия, с причиной и объёмом трафика
+//
+// Отдельного rekey в протоколе нет: ключи выводятся только в
+// хэндшейке, и смену ключей клиента показывает gametunnel.reconnect.
+//
+// Спаны копятся в памяти и уходят пачкой раз в tracingFlushInterval
+// или по tracingBatchSize штук. Коллектор недоступен - пачка
+// теряется (предупреждение в журнал), очередь ограничена
+// tracingMaxPending: трассировка не держит память и не тормозит
+// хэндшейки. Один экспортёр на адрес на процесс.
+//
+// ====================================================================
+
+// Параметры экспорта спанов
+const (
+	tracingFlushInterval = 5 * time.Second
+	tracingBatchSize     = 256
+	tracingMaxPending    = 4096
+	tracingTimeout       = 10 * time.Second
+	tracingServiceName   = "xray-gametunnel"
+	tracingScopeName     = "github.com/xtls/xray-core/transport/internet/gametunnel"
+)
+
+// Виды спанов OTLP (SpanKind)
+const (
+	spanKindServer = 2
+	spanKindClient = 3
+)
+
+// otlpStatusError - статус спана с ошибкой (Status.code)
+const otlpStatusError = 2
+
+// traceExportLog - ошибки отправки в коллектор (warning)
+var traceExportLog logThrottle
+
+// tracers - экспортёры процесса по адресу коллектора
+var tracers struct {
+	sync.Mutex
+	byURL map[string]*tracer
+}
+
+// tracer - экспортёр спанов в один коллектор
+type tracer struct {
+	// url - адрес POST, с /v1/traces
+	url string
+
+	mu      sync.Mutex
+	pending []otlpSpan
+	dropped uint64
+
+	// wake - набралась пачка, отправить не дожидаясь таймера
+	wake chan struct{}
+}
+
+// tracingURL - адрес POST для tracingEndpoint
+// "http://collector:4318" дополняется путём /v1/traces
+func tracingURL(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("want http(s)://host:port")
+	}
+	if !strings.HasSuffix(u.Path, "/v1/traces") {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v1/traces"
+	}
+	return u.String(), nil
+}
+
+// tracerFor - экспортёр config (nil без tracingEndpoint)
+// Адрес проверен Validate; неразобранный - трассировка выключена
+func tracerFor(config *Config) *tracer {
+	if config.TracingEndpoint == "" {
+		return nil
+	}
+	target, err := tracingURL(config.TracingEndpoint)
+	if err != nil {
+		return nil
+	}
+
+	tracers.Lock()
+	defer tracers.Unlock()
+	if t := tracers.byURL[target]; t != nil {
+		return t
+	}
+	if tracers.byURL == nil {
+		tracers.byURL = make(map[string]*tracer)
+	}
+	t := &tracer{url: target, wake: make(chan struct{}, 1)}
+	tracers.byURL[target] = t
+	go t.exportLoop()
+	return t
+}
+
+// exportLoop отправляет накопленные спаны
+func (t *tracer) exportLoop() {
+	ticker := time.NewTicker(tracingFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-t.wake:
+		}
+		t.flush()
+	}
+}
+
+// flush отправляет накопленные спаны сейчас
+func (t *tracer) flush() {
+	t.mu.Lock()
+	spans, dropped := t.pending, t.dropped
+	t.pending, t.dropped = nil, 0
+	t.mu.Unlock()
+
+	if dropped > 0 {
+		traceExportLog.logf(log.Severity_Warning, "tracing: %d spans dropped, collector %s is too slow", dropped, t.url)
+	}
+	if len(spans) == 0 {
+		return
+	}
+	if err := t.export(spans); err != nil {
+		traceExportLog.logf(log.Severity_Warning, "tracing: %d spans not exported to %s: %v", len(spans), t.url, err)
+	}
+}
+
+// export отправляет пачку спанов в коллектор
+func (t *tracer) export(spans []otlpSpan) error {
+	body, err := json.Marshal(newOTLPRequest(spans))
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tracingTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// add ставит законченный спан в очередь отправки
+func (t *tracer) add(span otlpSpan) {
+	t.mu.Lock()
+	if len(t.pending) >= tracingMaxPending {
+		t.dropped++
+		t.mu.Unlock()
+		return
+	}
+	t.pending = append(t.pending, span)
+	full := len(t.pending) >= tracingBatchSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// traceSpan - открытый спан (nil - трассировка выключена, методы
+// ничего не делают)
+type traceSpan struct {
+	tracer *tracer
+	span   otlpSpan
+	start  time.Time
+}
+
+// traceSpanKey - ключ родительского спана в context
+type traceSpanKey struct{}
+
+// startSpan открывает спан name; родитель - спан из ctx
+// Возвращает ctx с новым спаном для дочерних
+func startSpan(ctx context.Context, config *Config, name string, kind int) (context.Context, *traceSpan) {
+	return startSpanAt(ctx, config, name, kind, time.Now())
+}
+
+// startSpanAt - startSpan с заданным временем начала (сессия,
+// созданная раньше, чем стало известно, что её надо описать)
+func startSpanAt(ctx context.Context, config *Config, name string, kind int, start time.Time) (context.Context, *traceSpan) {
+	t := tracerFor(config)
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &traceSpan{tracer: t, start: start}
+	s.span.Name = name
+	s.span.Kind = kind
+	var spanID [8]byte
+	rand.Read(spanID[:])
+	s.span.SpanID = hex.EncodeToString(spanID[:])
+	if parent, ok := ctx.Value(traceSpanKey{}).(*traceSpan); ok {
+		s.span.TraceID = parent.span.TraceID
+		s.span.ParentSpanID = parent.span.SpanID
+	} else {
+		var traceID [16]byte
+		rand.Read(traceID[:])
+		s.span.TraceID = hex.EncodeToString(traceID[:])
+	}
+	return context.WithValue(ctx, traceSpanKey{}, s), s
+}
+
+// set задаёт атрибут спана: string, bool, целое или float64
+func (s *traceSpan) set(key string, value any) {
+	if s == nil {
+		return
+	}
+	var v otlpValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case bool:
+		v.BoolValue = &value
+	case int:
+		v.IntValue = strconv.FormatInt(int64(value), 10)
+	case int64:
+		v.IntValue = strconv.FormatInt(value, 10)
+	case uint32:
+		v.IntValue = strconv.FormatUint(uint64(value), 10)
+	case uint64:
+		v.IntValue = strconv.FormatUint(value, 10)
+	case float64:
+		v.DoubleValue = &value
+	default:
+		str := fmt.Sprint(value)
+		v.StringValue = &str
+	}
+	s.span.Attributes = append(s.span.Attributes, otlpAttribute{Key: key, Value: v})
+}
+
+// end закрывает спан; err - статус ошибки
+func (s *traceSpan) end(err error) {
+	if s == nil {
+		return
+	}
+	s.span.StartTime = strconv.FormatInt(s.start.UnixNano(), 10)
+	s.span.EndTime = strconv.FormatInt(time.Now().UnixNano(), 10)
+	if err != nil {
+		s.span.Status = &otlpStatus{Code: otlpStatusError, Message: err.Error()}
+	}
+	s.tracer.add(s.span)
+}
+
+// Запрос OTLP/HTTP в кодировке JSON (ExportTraceServiceRequest)
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID      string          `json:"traceId"`
+		SpanID       string          `json:"spanId"`
+		ParentSpanID string          `json:"parentSpanId,omitempty"`
+		Name         string          `json:"name"`
+		Kind         int             `json:"kind"`
+		StartTime    string          `json:"startTimeUnixNano"`
+		EndTime      string          `json:"endTimeUnixNano"`
+		Attributes   []otlpAttribute `json:"attributes,omitempty"`
+		Status       *otlpStatus     `json:"status,omitempty"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    string   `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+// newOTLPRequest - запрос экспорта спанов процесса
+func newOTLPRequest(spans []otlpSpan) otlpRequest {
+	service := tracingServiceName
+	resource := []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: &service}}}
+	if host, err := os.Hostname(); err == nil {
+		resource = append(resource, otlpAttribute{Key: "host.name", Value: otlpValue{StringValue: &host}})
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: tracingScopeName},
+			Spans: spans,
+		}},
+	}}}
+}
+
+// traceAccept описывает обработку Client Hello, начатую в start
+func (h *Hub) traceAccept(start time.Time, remoteAddr *net.UDPAddr, session *Session, err error) {
+	_, span := startSpanAt(context.Background(), h.getConfig(), "gametunnel.accept", spanKindServer, start)
+	if span == nil {
+		return
+	}
+	span.set("client.address", remoteAddr.String())
+	span.set("gametunnel.obfuscation", h.obfs.Name())
+	if session != nil {
+		span.set("gametunnel.connection_id", hex.EncodeToString(session.ID))
+		span.set("gametunnel.early_data", session.earlyAccepted)
+	}
+	span.end(err)
+}
+
+// traceSession описывает закрытую сессию хаба целиком
+func (h *Hub) traceSession(session *Session, reason CloseReason) {
+	_, span := startSpanAt(context.Background(), h.getConfig(), "gametunnel.session", spanKindServer, session.CreatedAt)
+	if span == nil {
+		return
+	}
+	stats := session.GetStats()
+	span.set("client.address", stats.RemoteAddr)
+	span.set("gametunnel.connection_id", stats.ConnectionID)
+	span.set("gametunnel.close_reason", reason.String())
+	span.set("gametunnel.bytes_sent", stats.BytesSent)
+	span.set("gametunnel.bytes_recv", stats.BytesRecv)
+	span.set("gametunnel.packets_sent", stats.PacketsSent)
+	span.set("gametunnel.packets_recv", stats.PacketsRecv)
+	span.end(nil)
+}
+
+// traceSession описывает закрытое клиентское соединение целиком
+func (c *GameTunnelClientConn) traceSession() {
+	_, span := startSpanAt(context.Background(), c.config, "gametunnel.session", spanKindClient, c.connectedAt)
+	if span == nil {
+		return
+	}
+	stats := c.GetStats()
+	span.set("network.peer.address", stats.RemoteAddr)
+	span.set("gametunnel.connection_id", hex.EncodeToString(c.session().ConnectionID))
+	span.set("gametunnel.obfuscation", stats.Obfuscation)
+	span.set("gametunnel.close_reason", c.CloseReason().String())
+	span.set("gametunnel.rtt_ms", float64(stats.RTT)/float64(time.Millisecond))
+	span.set("gametunnel.loss", stats.Loss)
+	span.set("gametunnel.reconnects", stats.Reconnects)
+	span.set("gametunnel.bytes_sent", stats.BytesSent)
+	span.set("gametunnel.bytes_recv", stats.BytesRecv)
+	span.end(nil)
+}
@@ -0,0 +1,140 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// spanCollector - коллектор OTLP/HTTP, собирающий спаны
+type spanCollector struct {
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+func (c *spanCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			c.spans = append(c.spans, ss.Spans...)
+		}
+	}
+}
+
+// byName - спаны с именем name
+func (c *spanCollector) byName(name string) []otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var found []otlpSpan
+	for _, span := range c.spans {
+		if span.Name == name {
+			found = append(found, span)
+		}
+	}
+	return found
+}
+
+// attr - значение атрибута key (nil - нет атрибута)
+func attr(span otlpSpan, key string) *otlpValue {
+	for _, a := range span.Attributes {
+		if a.Key == key {
+			return &a.Value
+		}
+	}
+	return nil
+}
+
+func TestTracingURL(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"http://collector:4318", "http://collector:4318/v1/traces"},
+		{"https://otel.example.com/", "https://otel.example.com/v1/traces"},
+		{"http://collector:4318/v1/traces", "http://collector:4318/v1/traces"},
+		{"collector:4318", ""},
+		{"grpc://collector:4317", ""},
+	}
+	for _, tt := range tests {
+		got, err := tracingURL(tt.in)
+		if (err == nil) != (tt.want != "") || got != tt.want {
+			t.Errorf("%q: %q, %v", tt.in, got, err)
+		}
+	}
+
+	config := DefaultConfig()
+	config.TracingEndpoint = "collector:4318"
+	if err := config.Validate(); err == nil {
+		t.Error("tracingEndpoint without scheme accepted")
+	}
+}
+
+func TestTracingSpans(t *testing.T) {
+	collector := &spanCollector{}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	config := DefaultConfig()
+	config.Key = "tracing"
+	config.TracingEndpoint = srv.URL
+	l, accepted := startTestListener(t, config)
+	client, _ := dialTestClient(t, l, config, accepted)
+	l.hub.KickSession(client.session().ConnectionID)
+	client.Close()
+	tracerFor(config).flush()
+
+	dials, handshakes := collector.byName("gametunnel.dial"), collector.byName("gametunnel.handshake")
+	if len(dials) != 1 || len(handshakes) != 1 {
+		t.Fatalf("%d dial and %d handshake spans, want 1 and 1", len(dials), len(handshakes))
+	}
+	dial, handshake := dials[0], handshakes[0]
+	if handshake.TraceID != dial.TraceID || handshake.ParentSpanID != dial.SpanID {
+		t.Errorf("handshake span is not a child of dial: %+v", handshake)
+	}
+	if dial.Kind != spanKindClient || dial.Status != nil {
+		t.Errorf("dial span kind %d, status %+v", dial.Kind, dial.Status)
+	}
+	if v := attr(dial, "gametunnel.obfuscation"); v == nil || v.StringValue == nil || *v.StringValue != "quic-mimic" {
+		t.Errorf("dial obfuscation %+v", v)
+	}
+	if v := attr(dial, "gametunnel.attempts"); v == nil || v.IntValue != "1" {
+		t.Errorf("dial attempts %+v", v)
+	}
+	if v := attr(handshake, "gametunnel.rtt_ms"); v == nil || v.DoubleValue == nil {
+		t.Errorf("handshake rtt %+v", v)
+	}
+
+	accepts := collector.byName("gametunnel.accept")
+	if len(accepts) != 1 || accepts[0].Kind != spanKindServer || accepts[0].Status != nil {
+		t.Fatalf("accept spans %+v", accepts)
+	}
+	connID := attr(dial, "gametunnel.connection_id")
+	if v := attr(accepts[0], "gametunnel.connection_id"); v == nil || connID == nil || *v.StringValue != *connID.StringValue {
+		t.Errorf("accept connection ID %+v, dial %+v", v, connID)
+	}
+
+	// Сессия - с каждой стороны, сервер закрыл её с причиной kicked
+	var reasons []string
+	for _, span := range collector.byName("gametunnel.session") {
+		if v := attr(span, "gametunnel.close_reason"); v != nil && v.StringValue != nil {
+			reasons = append(reasons, *v.StringValue)
+		}
+		if span.StartTime >= span.EndTime {
+			t.Errorf("session span %s-%s", span.StartTime, span.EndTime)
+		}
+	}
+	if len(reasons) != 2 || (reasons[0] != "kicked" && reasons[1] != "kicked") {
+		t.Errorf("session close reasons %q", reasons)
+	}
+}
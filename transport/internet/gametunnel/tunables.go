@@ -0,0 +1,169 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ====================================================================
+// Настройки, меняемые на ходу (runtime tunables)
+// ====================================================================
+//
+// POST /reload принимает конфиг целиком. Для панели и автоматики
+// удобнее точечно: прочитать и сменить одну ручку по имени, не
+// пересылая остальное. Реестр tunables перечисляет такие ручки -
+// имена те же, что в JSON конфига:
+//
+//	enablePadding, paddingMinSize, paddingMaxSize  - хаб
+//	keepAliveInterval                              - хаб и клиент
+//	chaffBudget                                    - клиент
+//	sessionRateLimit, sessionRateBurst,
+//	highRateLimit, mediumRateLimit, lowRateLimit   - хаб
+//
+// API управления сервера:
+//
+//	GET   /tunables - текущие значения ручек хаба
+//	PATCH /tunables - {"paddingMaxSize": 300, "keepAliveInterval": 10}
+//
+// Изменение - та же copy-on-write подмена снимка, что и у Reload:
+// копия текущего конфига, запись значений, Validate, подмена
+// указателя. Горячие пути читают снимок один раз на операцию, так что
+// пакет не собирается из половины старых и половины новых значений.
+// Недопустимое значение или чужое имя - ошибка, снимок не меняется.
+//
+// На сервере keepAliveInterval задаёт таймаут сессий и период
+// проверок молчащих клиентов (deadpeer.go). Клиенту ручки меняет
+// встраивающий код через GameTunnelClientConn.SetTunables: таймеры
+// keep-alive и покрывающего трафика (chaff.go) перечитывают снимок и
+// перезапускаются сразу после смены.
+//
+// ====================================================================
+
+// tunable - настройка, меняемая на ходу
+type tunable struct {
+	// name - имя поля в JSON конфига
+	name string
+
+	// hub, client - действует на хаб сервера / на соединение клиента
+	hub, client bool
+
+	// field - указатель на поле в конфиге (*uint32, *uint64 или *bool)
+	field func(*Config) any
+}
+
+// tunables - реестр настроек, меняемых на ходу
+var tunables = []tunable{
+	{"enablePadding", true, false, func(c *Config) any { return &c.EnablePadding }},
+	{"paddingMinSize", true, false, func(c *Config) any { return &c.PaddingMinSize }},
+	{"paddingMaxSize", true, false, func(c *Config) any { return &c.PaddingMaxSize }},
+	{"keepAliveInterval", true, true, func(c *Config) any { return &c.KeepAliveInterval }},
+	{"chaffBudget", false, true, func(c *Config) any { return &c.ChaffBudget }},
+	{"sessionRateLimit", true, false, func(c *Config) any { return &c.SessionRateLimit }},
+	{"sessionRateBurst", true, false, func(c *Config) any { return &c.SessionRateBurst }},
+	{"highRateLimit", true, false, func(c *Config) any { return &c.HighRateLimit }},
+	{"mediumRateLimit", true, false, func(c *Config) any { return &c.MediumRateLimit }},
+	{"lowRateLimit", true, false, func(c *Config) any { return &c.LowRateLimit }},
+}
+
+// hubTunable / clientTunable - отбор ручек стороны
+func hubTunable(t *tunable) bool    { return t.hub }
+func clientTunable(t *tunable) bool { return t.client }
+
+// findTunable ищет ручку по имени
+func findTunable(name string) *tunable {
+	for i := range tunables {
+		if tunables[i].name == name {
+			return &tunables[i]
+		}
+	}
+	return nil
+}
+
+// tunableValues - значения ручек стороны в снимке config
+// Снимок после публикации не меняется, поэтому в ответе - указатели
+// на его поля
+func tunableValues(config *Config, side func(*tunable) bool) map[string]any {
+	values := make(map[string]any)
+	for i := range tunables {
+		if side(&tunables[i]) {
+			values[tunables[i].name] = tunables[i].field(config)
+		}
+	}
+	return values
+}
+
+// applyTunables записывает values в копию снимка next
+// Имена разбираются по порядку: ошибка всегда про одно и то же поле
+func applyTunables(next *Config, values map[string]json.RawMessage, side func(*tunable) bool) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := findTunable(name)
+		if t == nil || !side(t) {
+			return fmt.Errorf("%s: not a runtime tunable", name)
+		}
+		if err := json.Unmarshal(values[name], t.field(next)); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Tunables возвращает текущие значения настроек хаба, меняемых на ходу
+func (h *Hub) Tunables() map[string]any {
+	return tunableValues(h.getConfig(), hubTunable)
+}
+
+// SetTunables меняет настройки хаба по именам без разрыва сессий
+// При ошибке текущий конфиг не меняется
+func (h *Hub) SetTunables(values map[string]json.RawMessage) error {
+	return h.updateConfig(func(next *Config) error {
+		return applyTunables(next, values, hubTunable)
+	})
+}
+
+// tunedConfig - снимок конфига клиента с настройками на ходу
+// changed закрывается, когда снимок заменён: таймеры перечитывают его
+type tunedConfig struct {
+	config  *Config
+	changed chan struct{}
+}
+
+// newTunedConfig - снимок из config
+func newTunedConfig(config *Config) *tunedConfig {
+	return &tunedConfig{config: config, changed: make(chan struct{})}
+}
+
+// tunedConfig возвращает текущий снимок настроек клиента
+func (c *GameTunnelClientConn) tunedConfig() *tunedConfig {
+	return c.tuned.Load()
+}
+
+// Tunables возвращает текущие значения настроек клиента, меняемых на ходу
+func (c *GameTunnelClientConn) Tunables() map[string]any {
+	return tunableValues(c.tunedConfig().config, clientTunable)
+}
+
+// SetTunables меняет настройки соединения клиента по именам
+// При ошибке текущие настройки не меняются
+func (c *GameTunnelClientConn) SetTunables(values map[string]json.RawMessage) error {
+	c.tuneMu.Lock()
+	defer c.tuneMu.Unlock()
+
+	cur := c.tunedConfig()
+	next := *cur.config
+	if err := applyTunables(&next, values, clientTunable); err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	c.tuned.Store(newTunedConfig(&next))
+	close(cur.changed)
+	return nil
+}
@@ -0,0 +1,130 @@
+package gametunnel
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHubSetTunables(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	session := newMapSession(1)
+	h.sessions.put(session)
+	before := h.getConfig()
+
+	err := h.SetTunables(map[string]json.RawMessage{
+		"paddingMaxSize":    json.RawMessage("300"),
+		"keepAliveInterval": json.RawMessage("10"),
+		"sessionRateLimit":  json.RawMessage("5000"),
+	})
+	if err != nil {
+		t.Fatalf("SetTunables: %v", err)
+	}
+
+	config := h.getConfig()
+	if config == before || before.PaddingMaxSize != 200 {
+		t.Fatal("snapshot changed in place")
+	}
+	if config.PaddingMaxSize != 300 || config.KeepAliveInterval != 10 {
+		t.Errorf("padding max %d, keep-alive %d", config.PaddingMaxSize, config.KeepAliveInterval)
+	}
+	if h.sessionTimeout() != 30*time.Second || h.probeInterval() != 20*time.Second {
+		t.Errorf("session timeout %v, probe interval %v", h.sessionTimeout(), h.probeInterval())
+	}
+	if session.limiter == nil || session.limiter.rate != 5000 {
+		t.Errorf("session limiter not updated: %+v", session.limiter)
+	}
+	if got := h.Tunables()["paddingMaxSize"]; *got.(*uint32) != 300 {
+		t.Errorf("Tunables paddingMaxSize %v", got)
+	}
+
+	// Недопустимое значение, чужое имя или ручка клиента - ошибка,
+	// снимок прежний
+	for _, values := range []map[string]json.RawMessage{
+		{"paddingMinSize": json.RawMessage("400")},
+		{"mtu": json.RawMessage("1200")},
+		{"chaffBudget": json.RawMessage("1000")},
+		{"keepAliveInterval": json.RawMessage(`"fast"`)},
+	} {
+		if err := h.SetTunables(values); err == nil {
+			t.Errorf("%v accepted", values)
+		}
+	}
+	if h.getConfig() != config {
+		t.Error("failed update replaced the snapshot")
+	}
+}
+
+func TestManagementTunables(t *testing.T) {
+	h := NewHub(DefaultConfig(), nil)
+	api := NewManagementHandler(h, "")
+
+	rec := apiRequest(t, api, "GET", "/tunables", "")
+	var values map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &values); err != nil || values["paddingMinSize"] != float64(40) {
+		t.Fatalf("GET /tunables: %d %s", rec.Code, rec.Body)
+	}
+	if _, ok := values["chaffBudget"]; ok {
+		t.Error("client tunable listed by the hub")
+	}
+
+	req := httptest.NewRequest("PATCH", "/tunables", strings.NewReader(`{"enablePadding": false, "lowRateLimit": 1000}`))
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || h.getConfig().EnablePadding || h.getConfig().LowRateLimit != 1000 {
+		t.Fatalf("PATCH /tunables: %d %s", rec.Code, rec.Body)
+	}
+
+	req = httptest.NewRequest("PATCH", "/tunables", strings.NewReader(`{"key": "x"}`))
+	rec = httptest.NewRecorder()
+	api.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PATCH non-tunable: got %d, want 400", rec.Code)
+	}
+}
+
+func TestClientSetTunables(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "tunables"
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.KeepAliveInterval = 0
+	client, _ := dialTestClient(t, l, &clientConfig, accepted)
+	defer client.Close()
+
+	// Покрывающий трафик включается на ходу, без нового соединения
+	time.Sleep(100 * time.Millisecond)
+	if client.GetChaffSent() != 0 || client.GetKeepAlivesSent() != 0 {
+		t.Fatal("chaff or keep-alive without a budget")
+	}
+	if err := client.SetTunables(map[string]json.RawMessage{"chaffBudget": json.RawMessage("1048576")}); err != nil {
+		t.Fatalf("SetTunables: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for client.GetChaffSent() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if client.GetChaffSent() == 0 {
+		t.Error("no chaff after chaffBudget was set")
+	}
+
+	// И выключается
+	client.SetTunables(map[string]json.RawMessage{"chaffBudget": json.RawMessage("0")})
+	time.Sleep(50 * time.Millisecond)
+	sent := client.GetChaffSent()
+	time.Sleep(100 * time.Millisecond)
+	if client.GetChaffSent() != sent {
+		t.Error("chaff after chaffBudget was reset")
+	}
+
+	if err := client.SetTunables(map[string]json.RawMessage{"paddingMaxSize": json.RawMessage("10")}); err == nil {
+		t.Error("hub tunable accepted by the client")
+	}
+	if got := client.Tunables()["chaffBudget"]; *got.(*uint32) != 0 {
+		t.Errorf("Tunables chaffBudget %v", got)
+	}
+}
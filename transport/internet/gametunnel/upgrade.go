@@ -0,0 +1,364 @@
+package gametunnel
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+)
+
+// ====================================================================
+// Обновление без простоя: передача сокетов и сессий новому процессу
+// ====================================================================
+//
+// С upgradeSocket работающий сервер слушает unix-сокет. Новый
+// процесс (новый бинарник с тем же конфигом) при старте подключается
+// к нему и забирает всё, что нужно клиентам:
+//
+//  1. новый процесс шлёт запрос, зашифрованный ключом из PSK
+//     (config.Key): без key передачу не получить
+//  2. старый процесс копирует дескрипторы своих UDP-сокетов и
+//     закрывает сокеты - больше он не читает и не отправляет;
+//     датаграммы ждут в буфере ядра
+//  3. старый процесс шлёт дескрипторы (SCM_RIGHTS) и состояние
+//     сессий - ключи, счётчики, адреса клиентов, как в снапшоте
+//     (snapshot.go), зашифрованное тем же PSK
+//  4. новый процесс поднимает listener на этих сокетах,
+//     восстанавливает сессии, сам начинает слушать upgradeSocket и
+//     подтверждает приём; старый процесс закрывает listener
+//
+// Порт не закрывается ни на миг, клиенты сохраняют Connection ID и
+// ключи, и пакеты не теряются, кроме отправленных старым процессом в
+// момент передачи. Счётчик отправки сдвигается на handoffCounterGap:
+// пакеты, ушедшие после снятия состояния, не повторят nonce.
+//
+// Адрес сокета берётся по адресу inbound-а, extraListen и portRange,
+// как с socket activation (inherit.go); сокеты, не нужные новому
+// конфигу, закрываются. Ограничение то же, что у снапшотов:
+// протокол поверх транспорта (VLESS и т.п.) видит новое соединение.
+//
+// Если upgradeSocket никто не слушает, сервер стартует как обычно.
+// Только Unix: в Windows дескрипторы так не передаются.
+//
+// ====================================================================
+
+const (
+	// handoffRequestMagic / handoffStateMagic - заголовки запроса
+	// нового процесса и ответа старого
+	handoffRequestMagic = "GTU1"
+	handoffStateMagic   = "GTH1"
+
+	// handoffHKDFInfo - контекст HKDF для ключа передачи
+	handoffHKDFInfo = "gametunnel session handoff v1"
+
+	// handoffCounterGap - сдвиг счётчика отправки у нового процесса
+	handoffCounterGap = 1 << 10
+
+	// handoffTimeout - предел всей передачи и возраста запроса
+	handoffTimeout = 10 * time.Second
+
+	// handoffMaxRequest / handoffMaxState - пределы размеров
+	handoffMaxRequest = 4 << 10
+	handoffMaxState   = 64 << 20
+
+	// handoffMaxSockets - предел числа передаваемых дескрипторов
+	handoffMaxSockets = 256
+
+	// handoffAck - подтверждение нового процесса
+	handoffAck = 1
+)
+
+// handoffRequest - запрос передачи от нового процесса
+type handoffRequest struct {
+	Time time.Time `json:"time"`
+}
+
+// handoffState - состояние, которое старый процесс передаёт новому
+type handoffState struct {
+	// Sockets - адреса переданных сокетов в порядке дескрипторов;
+	// первый - основной сокет listener-а
+	Sockets []string `json:"sockets"`
+
+	Sessions []sessionSnapshotEntry `json:"sessions"`
+}
+
+// upgradeServer - unix-сокет, на котором listener ждёт новый процесс
+type upgradeServer struct {
+	ln   *net.UnixListener
+	aead cipher.AEAD
+}
+
+// handoff - передача, принятая новым процессом
+type handoff struct {
+	conn     *net.UnixConn
+	sockets  *inheritedSockets
+	main     *net.UDPAddr
+	sessions []sessionSnapshotEntry
+}
+
+// handoffAEAD - шифр передачи из PSK
+func handoffAEAD(config *Config) (cipher.AEAD, error) {
+	if !handoffSupported {
+		return nil, fmt.Errorf("upgradeSocket is not supported on this platform")
+	}
+	if config.Key == "" {
+		return nil, fmt.Errorf("upgradeSocket requires key")
+	}
+	return stateAEAD(config.Key, handoffHKDFInfo)
+}
+
+// requestHandoff забирает сокеты и сессии у процесса на upgradeSocket
+// nil без ошибки - его никто не слушает, сервер стартует сам
+func requestHandoff(config *Config) (*handoff, error) {
+	aead, err := handoffAEAD(config)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: config.UpgradeSocket, Net: "unix"})
+	if err != nil {
+		return nil, nil
+	}
+	h, err := receiveHandoff(conn, aead)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("upgrade from %s: %w", config.UpgradeSocket, err)
+	}
+	return h, nil
+}
+
+// receiveHandoff - сторона нового процесса: запрос, дескрипторы и
+// состояние
+func receiveHandoff(conn *net.UnixConn, aead cipher.AEAD) (*handoff, error) {
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	request, err := sealState(aead, handoffRequestMagic, &handoffRequest{Time: time.Now()})
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFrame(conn, request); err != nil {
+		return nil, fmt.Errorf("send request: %w", err)
+	}
+
+	// Длина состояния приходит вместе с дескрипторами
+	var header [4]byte
+	fds, err := readFds(conn, header[:], handoffMaxSockets)
+	if err != nil {
+		return nil, fmt.Errorf("receive sockets: %w", err)
+	}
+	sockets := &inheritedSockets{}
+	for i, fd := range fds {
+		udp, err := fileUDPConn(uintptr(fd))
+		if err != nil {
+			for _, rest := range fds[i+1:] {
+				os.NewFile(uintptr(rest), "").Close()
+			}
+			sockets.closeRest()
+			return nil, err
+		}
+		sockets.conns = append(sockets.conns, udp)
+	}
+
+	state := &handoffState{}
+	if err := readState(conn, binary.BigEndian.Uint32(header[:]), aead, state); err != nil {
+		sockets.closeRest()
+		return nil, err
+	}
+	if len(state.Sockets) != len(sockets.conns) || len(state.Sockets) == 0 {
+		sockets.closeRest()
+		return nil, fmt.Errorf("%d sockets for %d addresses", len(sockets.conns), len(state.Sockets))
+	}
+	return &handoff{
+		conn:     conn,
+		sockets:  sockets,
+		main:     sockets.conns[0].LocalAddr().(*net.UDPAddr),
+		sessions: state.Sessions,
+	}, nil
+}
+
+// readState читает состояние длины size и расшифровывает
+func readState(conn *net.UnixConn, size uint32, aead cipher.AEAD, state *handoffState) error {
+	if size > handoffMaxState {
+		return fmt.Errorf("state of %d bytes", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(conn, data); err != nil {
+		return fmt.Errorf("receive state: %w", err)
+	}
+	return openState(aead, handoffStateMagic, "upgrade state", data, state)
+}
+
+// ack подтверждает старому процессу, что listener работает
+func (h *handoff) ack() {
+	if h == nil {
+		return
+	}
+	h.conn.Write([]byte{handoffAck})
+}
+
+// close закрывает соединение со старым процессом и сокеты, не
+// нужные новому конфигу
+func (h *handoff) close() {
+	if h == nil {
+		return
+	}
+	h.conn.Close()
+	h.sockets.closeRest()
+}
+
+// listenUpgrade начинает слушать upgradeSocket для следующего процесса
+// Старый файл сокета удаляется: его процесс упал или уже передал
+// сессии нам
+func listenUpgrade(config *Config) (*upgradeServer, error) {
+	aead, err := handoffAEAD(config)
+	if err != nil {
+		return nil, err
+	}
+	path := config.UpgradeSocket
+	os.Remove(path)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("upgradeSocket: %w", err)
+	}
+	// Файл удаляет Listener.Close, и только без передачи: после неё
+	// путь принадлежит новому процессу
+	ln.SetUnlinkOnClose(false)
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("upgradeSocket: %w", err)
+	}
+	return &upgradeServer{ln: ln, aead: aead}, nil
+}
+
+// serveUpgrade ждёт новый процесс; завершается передачей или Close
+func (l *Listener) serveUpgrade() {
+	for {
+		conn, err := l.upgrade.ln.AcceptUnix()
+		if err != nil {
+			return
+		}
+		err = l.handoff(conn)
+		conn.Close()
+		if err != nil {
+			logf(log.Severity_Warning, "upgradeSocket %s: handoff failed: %v", l.upgrade.ln.Addr(), err)
+		}
+		if atomic.LoadInt32(&l.closed) == 1 {
+			return
+		}
+	}
+}
+
+// handoff передаёт сокеты и сессии процессу на conn
+// После проверки запроса listener закрывается, даже если передача
+// не удалась: сокеты уже отданы
+func (l *Listener) handoff(conn *net.UnixConn) error {
+	conn.SetDeadline(time.Now().Add(handoffTimeout))
+
+	frame, err := readFrame(conn, handoffMaxRequest)
+	if err != nil {
+		return fmt.Errorf("read request: %w", err)
+	}
+	request := &handoffRequest{}
+	if err := openState(l.upgrade.aead, handoffRequestMagic, "upgrade request", frame, request); err != nil {
+		return err
+	}
+	if age := time.Since(request.Time); age > handoffTimeout || age < -handoffTimeout {
+		return fmt.Errorf("upgrade request is %v old", age)
+	}
+
+	// Копии дескрипторов - до закрытия сокетов
+	files := make([]*os.File, 0, len(l.sockets))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	addrs := make([]string, 0, len(l.sockets))
+	for _, sock := range l.sockets {
+		filer, ok := sock.conn.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("socket %s can't be passed", sock.conn.LocalAddr())
+		}
+		f, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("socket %s: %w", sock.conn.LocalAddr(), err)
+		}
+		files = append(files, f)
+		addrs = append(addrs, sock.conn.LocalAddr().String())
+	}
+
+	// Дальше пути назад нет: сокеты закрываются, этот процесс больше
+	// не читает и не отправляет, и счётчики сессий не растут
+	atomic.StoreInt32(&l.hub.handedOff, 1)
+	defer l.Close()
+	l.cancel()
+	closeSockets(l.sockets)
+
+	state := &handoffState{Sockets: addrs, Sessions: snapshotEntries(l.hub.sessions.snapshot())}
+	data, err := sealState(l.upgrade.aead, handoffStateMagic, state)
+	if err != nil {
+		return err
+	}
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(data)))
+	if err := writeFds(conn, header[:], files); err != nil {
+		return fmt.Errorf("send sockets: %w", err)
+	}
+	if _, err := conn.Write(data); err != nil {
+		return fmt.Errorf("send state: %w", err)
+	}
+
+	var ack [1]byte
+	if _, err := io.ReadFull(conn, ack[:]); err != nil || ack[0] != handoffAck {
+		return fmt.Errorf("new process did not confirm: %v", err)
+	}
+	logf(log.Severity_Info, "upgradeSocket %s: %d sessions handed off to the new process", l.upgrade.ln.Addr(), len(state.Sessions))
+	close(l.handedOff)
+	return nil
+}
+
+// HandedOff закрывается, когда listener передал сокеты и сессии
+// новому процессу (upgradeSocket): процессу можно завершаться
+func (l *Listener) HandedOff() <-chan struct{} {
+	return l.handedOff
+}
+
+// closeUpgrade закрывает upgradeSocket; файл удаляется, если сессии
+// не переданы
+func (l *Listener) closeUpgrade() {
+	if l.upgrade == nil {
+		return
+	}
+	l.upgrade.ln.Close()
+	if atomic.LoadInt32(&l.hub.handedOff) == 0 {
+		os.Remove(l.upgrade.ln.Addr().String())
+	}
+}
+
+// writeFrame пишет data с длиной uint32
+func writeFrame(w io.Writer, data []byte) error {
+	frame := binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(data)), uint32(len(data)))
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+// readFrame читает данные writeFrame не длиннее max
+func readFrame(r io.Reader, max uint32) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size > max {
+		return nil, fmt.Errorf("frame of %d bytes", size)
+	}
+	data := make([]byte, size)
+	_, err := io.ReadFull(r, data)
+	return data, err
+}
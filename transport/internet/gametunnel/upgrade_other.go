@@ -0,0 +1,20 @@
+//go:build !linux && !darwin && !freebsd
+
+package gametunnel
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// handoffSupported - дескрипторы сокетов не передаются
+const handoffSupported = false
+
+func writeFds(conn *net.UnixConn, data []byte, files []*os.File) error {
+	return errors.ErrUnsupported
+}
+
+func readFds(conn *net.UnixConn, buf []byte, max int) ([]int, error) {
+	return nil, errors.ErrUnsupported
+}
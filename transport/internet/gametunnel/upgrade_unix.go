@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd
+
+package gametunnel
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+)
+
+// handoffSupported - дескрипторы передаются через SCM_RIGHTS
+const handoffSupported = true
+
+// writeFds пишет data и передаёт с ней дескрипторы files
+func writeFds(conn *net.UnixConn, data []byte, files []*os.File) error {
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+	_, _, err := conn.WriteMsgUnix(data, syscall.UnixRights(fds...), nil)
+	return err
+}
+
+// readFds читает len(buf) байт и дескрипторы, пришедшие с ними
+// Полученные дескрипторы закрываются при exec (MSG_CMSG_CLOEXEC)
+func readFds(conn *net.UnixConn, buf []byte, max int) ([]int, error) {
+	oob := make([]byte, syscall.CmsgSpace(max*4))
+	n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	var fds []int
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		rights, err := syscall.ParseUnixRights(&msg)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+	closeFds := func() {
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+	}
+
+	if flags&syscall.MSG_CTRUNC != 0 {
+		closeFds()
+		return nil, fmt.Errorf("more than %d descriptors", max)
+	}
+	if _, err := io.ReadFull(conn, buf[n:]); err != nil {
+		closeFds()
+		return nil, err
+	}
+	return fds, nil
+}
@@ -0,0 +1,207 @@
+//go:build linux || darwin || freebsd
+
+package gametunnel
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+	"github.com/xtls/xray-core/transport/internet/stat"
+)
+
+// freeUDPPort - свободный порт 127.0.0.1
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	sock, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sock.Close()
+	return sock.LocalAddr().(*net.UDPAddr).Port
+}
+
+// upgradeSocketPath - короткий путь unix-сокета (в macOS до 104 байт)
+func upgradeSocketPath(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "gtu")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "upgrade.sock")
+}
+
+// listenUpgradeAt поднимает Listener на 127.0.0.1:port - как новый
+// процесс с тем же конфигом
+func listenUpgradeAt(t *testing.T, port int, config *Config) (*Listener, <-chan stat.Connection, error) {
+	t.Helper()
+
+	accepted := make(chan stat.Connection, 16)
+	copied := *config
+	l, err := ListenGameTunnel(context.Background(), xnet.LocalHostIP, xnet.Port(port),
+		&internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: &copied},
+		func(conn stat.Connection) { accepted <- conn })
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Cleanup(func() { l.Close() })
+	return l.(*Listener), accepted, nil
+}
+
+// echoOnce - пакет от клиента доходит до server, ответ - до клиента
+func echoOnce(t *testing.T, client, server net.Conn, msg string) {
+	t.Helper()
+	if _, err := client.Write([]byte(msg)); err != nil {
+		t.Fatalf("client Write: %v", err)
+	}
+	if got := readWithTimeout(t, server, 64); string(got) != msg {
+		t.Fatalf("server got %q, want %q", got, msg)
+	}
+	if _, err := server.Write([]byte("re:" + msg)); err != nil {
+		t.Fatalf("server Write: %v", err)
+	}
+	if got := readWithTimeout(t, client, 64); string(got) != "re:"+msg {
+		t.Fatalf("client got %q", got)
+	}
+}
+
+func TestUpgradeHandoff(t *testing.T) {
+	port, extraPort := freeUDPPort(t), freeUDPPort(t)
+	config := DefaultConfig()
+	config.Key = "upgrade"
+	config.UpgradeSocket = upgradeSocketPath(t)
+	config.ExtraListen = []string{"127.0.0.1:" + strconv.Itoa(extraPort)}
+
+	old, accepted, err := listenUpgradeAt(t, port, config)
+	if err != nil {
+		t.Fatalf("old listener: %v", err)
+	}
+
+	// Клиент на основном адресе и клиент на extraListen: после
+	// передачи ответ должен прийти с того же адреса
+	var clients []net.Conn
+	for _, addr := range old.Addrs() {
+		clientConfig := *config
+		clientConfig.UpgradeSocket = ""
+		client, server := dialTestClientAt(t, addr.(*net.UDPAddr), &clientConfig, accepted)
+		echoOnce(t, client, server, "before")
+		clients = append(clients, client)
+	}
+
+	// Новый процесс забирает сокеты и сессии
+	next, nextAccepted, err := listenUpgradeAt(t, port, config)
+	if err != nil {
+		t.Fatalf("new listener: %v", err)
+	}
+	select {
+	case <-old.HandedOff():
+	case <-time.After(5 * time.Second):
+		t.Fatal("old listener did not hand off")
+	}
+	if n := next.hub.GetActiveSessions(); n != 2 {
+		t.Fatalf("new listener has %d sessions, want 2", n)
+	}
+	if got := len(next.Addrs()); got != 2 {
+		t.Fatalf("new listener has %d addresses, want 2", got)
+	}
+
+	// Восстановленные сессии - новые соединения для xray
+	servers := make(map[int]net.Conn)
+	for range clients {
+		select {
+		case server := <-nextAccepted:
+			servers[server.RemoteAddr().(*net.UDPAddr).Port] = server
+		case <-time.After(5 * time.Second):
+			t.Fatal("restored session not accepted")
+		}
+	}
+
+	// Клиенты продолжают без переподключения
+	for i, client := range clients {
+		server := servers[client.LocalAddr().(*net.UDPAddr).Port]
+		if server == nil {
+			t.Fatalf("client %d: no restored session", i)
+		}
+		echoOnce(t, client, server, "after")
+	}
+	if n := atomic.LoadUint64(&next.hub.totalSessions); n != 2 {
+		t.Errorf("new listener created %d sessions, want the 2 restored: clients reconnected", n)
+	}
+
+	// upgradeSocket теперь у нового процесса: старый его не удалил
+	old.Close()
+	if _, err := os.Stat(config.UpgradeSocket); err != nil {
+		t.Fatalf("upgrade socket removed by the old listener: %v", err)
+	}
+	next.Close()
+	if _, err := os.Stat(config.UpgradeSocket); !os.IsNotExist(err) {
+		t.Errorf("upgrade socket left after Close: %v", err)
+	}
+}
+
+func TestUpgradeWithoutOldProcess(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "upgrade"
+	config.UpgradeSocket = upgradeSocketPath(t)
+
+	// Старый файл упавшего процесса не мешает старту
+	if err := os.WriteFile(config.UpgradeSocket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	l, accepted, err := listenUpgradeAt(t, 0, config)
+	if err != nil {
+		t.Fatalf("ListenGameTunnel: %v", err)
+	}
+	info, err := os.Stat(config.UpgradeSocket)
+	if err != nil || info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Fatalf("upgrade socket: %v %v", info.Mode(), err)
+	}
+	clientConfig := *config
+	clientConfig.UpgradeSocket = ""
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	echoOnce(t, client, server, "ping")
+}
+
+func TestUpgradeRejectsWrongKey(t *testing.T) {
+	port := freeUDPPort(t)
+	config := DefaultConfig()
+	config.Key = "upgrade"
+	config.UpgradeSocket = upgradeSocketPath(t)
+	old, accepted, err := listenUpgradeAt(t, port, config)
+	if err != nil {
+		t.Fatalf("old listener: %v", err)
+	}
+
+	other := *config
+	other.Key = "other"
+	if _, _, err := listenUpgradeAt(t, port, &other); err == nil {
+		t.Fatal("handoff with a different key succeeded")
+	}
+
+	// Старый процесс работает дальше
+	select {
+	case <-old.HandedOff():
+		t.Fatal("old listener handed off to a process without the key")
+	default:
+	}
+	clientConfig := *config
+	clientConfig.UpgradeSocket = ""
+	client, server := dialTestClient(t, old, &clientConfig, accepted)
+	echoOnce(t, client, server, "ping")
+}
+
+func TestUpgradeRequiresKey(t *testing.T) {
+	config := DefaultConfig()
+	config.UpgradeSocket = upgradeSocketPath(t)
+	if _, _, err := listenUpgradeAt(t, 0, config); err == nil {
+		t.Error("upgradeSocket without key accepted")
+	}
+}
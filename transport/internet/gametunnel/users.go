@@ -0,0 +1,169 @@
+package gametunnel
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"sync/atomic"
+)
+
+// ====================================================================
+// Пользователи с личными ключами
+// ====================================================================
+//
+// Общий PSK (key) не отличает одного клиента от другого, и квота,
+// привязанная к сессии, обходится переподключением. С users у
+// каждого пользователя сервера свой ключ:
+//
+//	"users": [{"name": "alice", "key": "..."}, {"name": "bob", "key": "..."}]
+//
+// Клиент задаёт свой ключ в userKey. Client Hello несёт helloCapUser
+// и ID пользователя - 8 байт HMAC-SHA256 от его ключа: имя по сети
+// не передаётся. Ключи сессии (и ранние ключи 0-RTT) выводятся из
+// key и ключа пользователя вместе, так что чужой ID без ключа даёт
+// лишь сессию, пакеты которой не расшифровываются.
+//
+// Сервер находит пользователя по ID и привязывает к нему сессию ещё
+// при хэндшейке: квота (quota.go), счётчики xray (xraystats.go) и
+// InboundPolicy.Users (policy.go) работают по нему. С users Client
+// Hello без известного ID отклоняется; без users отклоняется Client
+// Hello с ID - ключи сторон не совпали бы. Сменить пользователя
+// такой сессии через SetSessionUser нельзя.
+//
+// Key остаётся общим: им же защищены обфускация, снапшоты и
+// передача сессий. Список users перезагружается (reload.go) -
+// сессии остаются со своими ключами.
+//
+// ====================================================================
+
+const (
+	// helloCapUser - Client Hello несёт ID пользователя (UserID)
+	helloCapUser byte = 0x80
+
+	// userIDSize - размер ID пользователя
+	userIDSize = 8
+
+	// userIDInfo - контекст HMAC для ID пользователя
+	userIDInfo = "gametunnel user id"
+)
+
+// User - пользователь сервера с личным ключом
+type User struct {
+	// Name - имя для учёта и квот (UsageReport.User)
+	Name string `json:"name"`
+
+	// Key - личный ключ пользователя (userKey клиента)
+	Key string `json:"key"`
+}
+
+// userID - ID пользователя с ключом key в Client Hello
+func userID(key string) [userIDSize]byte {
+	var id [userIDSize]byte
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userIDInfo))
+	copy(id[:], mac.Sum(nil))
+	return id
+}
+
+// sessionPSK - PSK ключей сессии: общий key, с ключом пользователя -
+// оба
+func sessionPSK(key, userKey string) string {
+	if userKey == "" {
+		return key
+	}
+	return key + "\x00" + userKey
+}
+
+// sessionPSK - PSK ключей сессий клиента
+func (c *Config) sessionPSK() string {
+	return sessionPSK(c.Key, c.UserKey)
+}
+
+// helloUser добавляет в Client Hello ID пользователя userKey
+func (c *Config) helloUser(payload *HandshakePayload) {
+	if c.UserKey == "" {
+		return
+	}
+	id := userID(c.UserKey)
+	payload.Capabilities |= helloCapUser
+	payload.UserID = id[:]
+}
+
+// validUsers проверяет список users: имена и ключи заданы и не
+// повторяются
+func validUsers(users []*User) error {
+	names := make(map[string]bool, len(users))
+	ids := make(map[[userIDSize]byte]bool, len(users))
+	for i, u := range users {
+		if u == nil || u.Name == "" || u.Key == "" {
+			return fmt.Errorf("user %d: want name and key", i)
+		}
+		if names[u.Name] {
+			return fmt.Errorf("user %q listed twice", u.Name)
+		}
+		id := userID(u.Key)
+		if ids[id] {
+			return fmt.Errorf("user %q: key shared with another user", u.Name)
+		}
+		names[u.Name], ids[id] = true, true
+	}
+	return nil
+}
+
+// userTable - пользователи хаба по ID (nil - users не заданы)
+type userTable map[[userIDSize]byte]*User
+
+// newUserTable строит таблицу пользователей конфига
+func newUserTable(config *Config) *userTable {
+	if len(config.Users) == 0 {
+		return nil
+	}
+	table := make(userTable, len(config.Users))
+	for _, u := range config.Users {
+		table[userID(u.Key)] = u
+	}
+	return &table
+}
+
+// helloUser определяет пользователя Client Hello и PSK его сессии
+// nil - users не заданы, сессия без пользователя
+func (h *Hub) helloUser(hello *HandshakePayload, policy *InboundPolicy) (*User, string, error) {
+	config := h.getConfig()
+	var table userTable
+	if p := h.users.Load(); p != nil {
+		table = *p
+	}
+	hasID := hello.Capabilities&helloCapUser != 0
+	switch {
+	case table == nil && !hasID:
+		return nil, config.Key, nil
+	case table == nil:
+		return nil, "", fmt.Errorf("client hello with a user ID, but no users configured")
+	case !hasID:
+		return nil, "", fmt.Errorf("client hello without a user ID")
+	}
+	var id [userIDSize]byte
+	copy(id[:], hello.UserID)
+	user := table[id]
+	if user == nil {
+		return nil, "", fmt.Errorf("unknown user ID %x", id)
+	}
+	if !policy.allowsUser(user.Name) {
+		return nil, "", fmt.Errorf("user %q not allowed on this inbound", user.Name)
+	}
+	// Исчерпанную квоту с quotaAction close переподключение не обходит
+	usage := h.quotas.user(user.Name, config.QuotaBytes)
+	if config.QuotaAction == QuotaAction_CLOSE && atomic.LoadInt32(&usage.exhausted) == 1 {
+		return nil, "", fmt.Errorf("user %q: %w", user.Name, ErrQuotaExceeded)
+	}
+	return user, sessionPSK(config.Key, user.Key), nil
+}
+
+// bindHelloUser привязывает новую сессию к пользователю хэндшейка
+func (h *Hub) bindHelloUser(session *Session, user *User) {
+	if user == nil {
+		return
+	}
+	atomic.StoreInt32(&session.userBound, 1)
+	h.assignUser(session, user.Name)
+}
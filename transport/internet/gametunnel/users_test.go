@@ -0,0 +1,126 @@
+package gametunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	xnet "github.com/xtls/xray-core/common/net"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// dialUserClient - Dial, который должен завершиться ошибкой хэндшейка
+func dialUserClient(l *Listener, config *Config) error {
+	addr := l.Addr().(*net.UDPAddr)
+	dest := xnet.UDPDestination(xnet.IPAddress(addr.IP), xnet.Port(addr.Port))
+	conn, err := Dial(context.Background(), dest, &internet.MemoryStreamConfig{ProtocolName: "gametunnel", ProtocolSettings: config})
+	if err == nil {
+		conn.Close()
+	}
+	return err
+}
+
+func TestUsersHandshake(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "users"
+	config.Users = []*User{{Name: "alice", Key: "alice-key"}, {Name: "bob", Key: "bob-key"}}
+	l, accepted := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.Users = nil
+	clientConfig.UserKey = "alice-key"
+	client, server := dialTestClient(t, l, &clientConfig, accepted)
+	session := server.(*GameTunnelConn).session
+	if session.User != "alice" || session.userBound != 1 {
+		t.Fatalf("session user %q, bound %d", session.User, session.userBound)
+	}
+	client.Write([]byte("hi"))
+	if got := readWithTimeout(t, server, 2); string(got) != "hi" {
+		t.Fatalf("server got %q", got)
+	}
+
+	// Пользователя хэндшейка протокол поверх не подменит
+	if err := l.hub.SetSessionUser(session.ID, "bob"); err == nil {
+		t.Error("handshake user replaced")
+	}
+	if err := l.hub.SetSessionUser(session.ID, "alice"); err != nil {
+		t.Errorf("same user rejected: %v", err)
+	}
+
+	// Переподключение - тот же учёт пользователя
+	_, again := dialTestClient(t, l, &clientConfig, accepted)
+	if other := again.(*GameTunnelConn).session; other.usage != session.usage {
+		t.Error("reconnected session got its own usage")
+	}
+
+	clientConfig.HandshakeTimeout = 1
+	for name, key := range map[string]string{"unknown user": "eve-key", "no user": ""} {
+		clientConfig.UserKey = key
+		if err := dialUserClient(l, &clientConfig); err == nil {
+			t.Errorf("%s: handshake accepted", name)
+		}
+	}
+}
+
+func TestUsersRejectedWithoutUserList(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "users"
+	l, _ := startTestListener(t, config)
+
+	clientConfig := *config
+	clientConfig.UserKey = "alice-key"
+	clientConfig.HandshakeTimeout = 1
+	if err := dialUserClient(l, &clientConfig); err == nil {
+		t.Error("user ID accepted by a server without users")
+	}
+}
+
+func TestUsersQuotaSurvivesReconnect(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "users"
+	config.QuotaAction = QuotaAction_CLOSE
+	config.Users = []*User{{Name: "carol", Key: "carol-key"}}
+	l, accepted := startTestListener(t, config)
+	l.hub.SetUserQuota("carol", 8)
+
+	clientConfig := *config
+	clientConfig.Users = nil
+	clientConfig.UserKey = "carol-key"
+	_, server := dialTestClient(t, l, &clientConfig, accepted)
+	if _, err := server.Write([]byte("0123456789")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("server Write over quota: got %v", err)
+	}
+
+	clientConfig.HandshakeTimeout = 1
+	if err := dialUserClient(l, &clientConfig); err == nil {
+		t.Error("reconnect escaped the exhausted quota")
+	}
+}
+
+func TestValidUsers(t *testing.T) {
+	for name, users := range map[string][]*User{
+		"no name":        {{Key: "k"}},
+		"no key":         {{Name: "a"}},
+		"duplicate name": {{Name: "a", Key: "1"}, {Name: "a", Key: "2"}},
+		"shared key":     {{Name: "a", Key: "1"}, {Name: "b", Key: "1"}},
+	} {
+		config := DefaultConfig()
+		config.Users = users
+		if err := config.Validate(); err == nil {
+			t.Errorf("%s: users accepted", name)
+		}
+	}
+
+	// Расширение разбирается при любых других возможностях
+	id := userID("k")
+	hello := NewHandshakePayload([Curve25519KeySize]byte{1}, 1)
+	hello.Capabilities = helloCapUser | helloCapCompact | helloCapPuzzle
+	hello.UserID = id[:]
+	hello.Puzzle = make([]byte, puzzleExtSize)
+	got, _ := UnmarshalHandshake(hello.Marshal())
+	got.splitCapabilities(8, false, helloCapsKnown)
+	if string(got.UserID) != string(id[:]) || len(got.Puzzle) != puzzleExtSize || got.IssuedID != nil {
+		t.Errorf("user ID %x, puzzle %d, tail %x", got.UserID, len(got.Puzzle), got.IssuedID)
+	}
+}
@@ -0,0 +1,163 @@
+package gametunnel
+
+import (
+	"context"
+	"sync"
+
+	"github.com/xtls/xray-core/common/session"
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/features/policy"
+	"github.com/xtls/xray-core/features/stats"
+)
+
+// ====================================================================
+// Счётчики трафика в stats xray (x-ui, Marzban)
+// ====================================================================
+//
+// Панели читают трафик из stats.Manager xray (StatsService), а не из
+// API управления транспорта. Когда в конфиге xray есть "stats", а
+// политика включает нужные счётчики, транспорт ведёт в нём:
+//
+//	user>>>USER>>>traffic>>>uplink|downlink
+//	    - трафик пользователей GameTunnel (users.go, Hub.SetSessionUser,
+//	      GameTunnelConn.SetUser), те же байты, что расходуют квоту;
+//	      политика уровня 0: statsUserUplink / statsUserDownlink
+//	inbound>>>TAG>>>gametunnel>>>uplink|downlink
+//	outbound>>>TAG>>>gametunnel>>>uplink|downlink
+//	    - открытый текст сессий inbound/outbound с тегом TAG;
+//	      политика system: statsInbound* / statsOutbound*
+//
+// Счётчики inbound>>>TAG>>>traffic xray ведёт сам для любого
+// транспорта, поэтому у тегов свой сегмент gametunnel - без двойного
+// учёта. Пользователь xray (email VLESS) транспорту не виден: если
+// прокси сам учитывает того же пользователя, имя пользователя
+// GameTunnel должно отличаться, иначе трафик посчитается дважды.
+//
+// Uplink - от клиента к серверу: на сервере это принятое, на клиенте -
+// отправленное. Менеджер и тег берутся из context Listen и Dial; без
+// экземпляра xray в context (встраивание, автономный клиент) счётчиков
+// нет.
+//
+// ====================================================================
+
+// xrayStats - счётчики хаба или клиента в stats.Manager xray
+// (nil - выключены)
+type xrayStats struct {
+	manager stats.Manager
+
+	// uplink / downlink - счётчики тега (nil - выключены политикой)
+	uplink, downlink stats.Counter
+
+	// userUplink / userDownlink - политика включает счётчики
+	// пользователей
+	userUplink, userDownlink bool
+
+	// users - счётчики пользователей по имени
+	users sync.Map // string -> *userCounters
+}
+
+// userCounters - счётчики одного пользователя (nil - выключен)
+type userCounters struct {
+	uplink, downlink stats.Counter
+}
+
+// newXrayStats - счётчики для Listen (server) или Dial по context xray
+func newXrayStats(ctx context.Context, server bool) *xrayStats {
+	instance := core.FromContext(ctx)
+	if instance == nil {
+		return nil
+	}
+	manager, _ := instance.GetFeature(stats.ManagerType()).(stats.Manager)
+	policies, _ := instance.GetFeature(policy.ManagerType()).(policy.Manager)
+	if manager == nil || policies == nil {
+		return nil
+	}
+
+	var tag string
+	if server {
+		tag = inboundTag(ctx)
+	} else if outbounds := session.OutboundsFromContext(ctx); len(outbounds) > 0 {
+		tag = outbounds[len(outbounds)-1].Tag
+	}
+	return newXrayStatsFrom(manager, policies, tag, server)
+}
+
+// inboundTag - тег inbound-а xray из context Listen ("" - без тега)
+func inboundTag(ctx context.Context) string {
+	if inbound := session.InboundFromContext(ctx); inbound != nil {
+		return inbound.Tag
+	}
+	return ""
+}
+
+// newXrayStatsFrom - счётчики по менеджеру и политике xray
+func newXrayStatsFrom(manager stats.Manager, policies policy.Manager, tag string, server bool) *xrayStats {
+	// Без приложения stats xray подставляет NoopManager
+	if _, noop := manager.(stats.NoopManager); noop {
+		return nil
+	}
+
+	system, user := policies.ForSystem().Stats, policies.ForLevel(0).Stats
+	s := &xrayStats{
+		manager:      manager,
+		userUplink:   user.UserUplink,
+		userDownlink: user.UserDownlink,
+	}
+	if tag != "" {
+		prefix, up, down := "outbound>>>"+tag, system.OutboundUplink, system.OutboundDownlink
+		if server {
+			prefix, up, down = "inbound>>>"+tag, system.InboundUplink, system.InboundDownlink
+		}
+		s.uplink = s.counter(up, prefix+">>>gametunnel>>>uplink")
+		s.downlink = s.counter(down, prefix+">>>gametunnel>>>downlink")
+	}
+	if s.uplink == nil && s.downlink == nil && !s.userUplink && !s.userDownlink {
+		return nil
+	}
+	return s
+}
+
+// counter регистрирует счётчик name, если enabled
+func (s *xrayStats) counter(enabled bool, name string) stats.Counter {
+	if !enabled {
+		return nil
+	}
+	c, _ := stats.GetOrRegisterCounter(s.manager, name)
+	return c
+}
+
+// user - счётчики пользователя name ("" - без пользователя)
+func (s *xrayStats) user(name string) *userCounters {
+	if name == "" || (!s.userUplink && !s.userDownlink) {
+		return nil
+	}
+	if u, ok := s.users.Load(name); ok {
+		return u.(*userCounters)
+	}
+	u := &userCounters{
+		uplink:   s.counter(s.userUplink, "user>>>"+name+">>>traffic>>>uplink"),
+		downlink: s.counter(s.userDownlink, "user>>>"+name+">>>traffic>>>downlink"),
+	}
+	actual, _ := s.users.LoadOrStore(name, u)
+	return actual.(*userCounters)
+}
+
+// add учитывает трафик тега и пользователя user
+func (s *xrayStats) add(user string, up, down uint64) {
+	if s == nil {
+		return
+	}
+	addCounter(s.uplink, up)
+	addCounter(s.downlink, down)
+	if u := s.user(user); u != nil {
+		addCounter(u.uplink, up)
+		addCounter(u.downlink, down)
+	}
+}
+
+// addCounter прибавляет n к счётчику (nil или n = 0 - ничего)
+func addCounter(c stats.Counter, n uint64) {
+	if c != nil && n > 0 {
+		c.Add(int64(n))
+	}
+}
@@ -0,0 +1,101 @@
+package gametunnel
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/xtls/xray-core/app/policy"
+	"github.com/xtls/xray-core/app/stats"
+	feature_stats "github.com/xtls/xray-core/features/stats"
+)
+
+// newTestStats - менеджер stats и политика со всеми счётчиками
+func newTestStats(t *testing.T) (*stats.Manager, *policy.Instance) {
+	t.Helper()
+	manager, err := stats.NewManager(context.Background(), &stats.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policies, err := policy.New(context.Background(), &policy.Config{
+		Level: map[uint32]*policy.Policy{0: {Stats: &policy.Policy_Stats{UserUplink: true, UserDownlink: true}}},
+		System: &policy.SystemPolicy{Stats: &policy.SystemPolicy_Stats{
+			InboundUplink: true, InboundDownlink: true, OutboundUplink: true, OutboundDownlink: true,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, policies
+}
+
+// counterValue - значение счётчика name (-1 - не зарегистрирован)
+func counterValue(m feature_stats.Manager, name string) int64 {
+	c := m.GetCounter(name)
+	if c == nil {
+		return -1
+	}
+	return c.Value()
+}
+
+// waitCounter ждёт значение счётчика want
+func waitCounter(t *testing.T, m feature_stats.Manager, name string, want int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for counterValue(m, name) != want && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := counterValue(m, name); got != want {
+		t.Errorf("%s = %d, want %d", name, got, want)
+	}
+}
+
+func TestXrayStatsPolicy(t *testing.T) {
+	manager, policies := newTestStats(t)
+	if newXrayStatsFrom(feature_stats.NoopManager{}, policies, "in", true) != nil {
+		t.Error("counters without the stats app")
+	}
+
+	off, err := policy.New(context.Background(), &policy.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newXrayStatsFrom(manager, off, "in", true) != nil {
+		t.Error("counters with stats disabled by policy")
+	}
+
+	// Клиент: uplink - отправленное
+	s := newXrayStatsFrom(manager, policies, "out", false)
+	s.add("", 100, 7)
+	if up, down := counterValue(manager, "outbound>>>out>>>gametunnel>>>uplink"), counterValue(manager, "outbound>>>out>>>gametunnel>>>downlink"); up != 100 || down != 7 {
+		t.Errorf("outbound counters %d/%d", up, down)
+	}
+}
+
+func TestXrayStatsSession(t *testing.T) {
+	manager, policies := newTestStats(t)
+
+	config := DefaultConfig()
+	config.Key = "xray-stats"
+	l, accepted := startTestListener(t, config)
+	l.hub.xstats = newXrayStatsFrom(manager, policies, "gt-in", true)
+	client, server := dialTestClient(t, l, config, accepted)
+	if err := l.hub.SetSessionUser(client.session().ConnectionID, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	client.Write(make([]byte, 300))
+	readWithTimeout(t, server, 1500)
+	server.Write(make([]byte, 500))
+	readWithTimeout(t, client, 1500)
+
+	waitCounter(t, manager, "inbound>>>gt-in>>>gametunnel>>>uplink", 300)
+	waitCounter(t, manager, "inbound>>>gt-in>>>gametunnel>>>downlink", 500)
+	waitCounter(t, manager, "user>>>alice@example.com>>>traffic>>>uplink", 300)
+	waitCounter(t, manager, "user>>>alice@example.com>>>traffic>>>downlink", 500)
+
+	// Стандартный счётчик inbound ведёт сам xray - транспорт его не трогает
+	if v := counterValue(manager, "inbound>>>gt-in>>>traffic>>>uplink"); v != -1 {
+		t.Errorf("xray inbound counter registered by the transport: %d", v)
+	}
+}
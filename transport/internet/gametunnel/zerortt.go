@@ -0,0 +1,614 @@
+package gametunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xtls/xray-core/common/log"
+	"github.com/xtls/xray-core/transport/internet"
+)
+
+// ====================================================================
+// 0-RTT: ранние данные по ключу возобновления (earlyData)
+// ====================================================================
+//
+// Обычный Dial ждёт Server Hello - один RTT до первого байта данных.
+// Мобильный клиент переподключается часто, и этот RTT он платит на
+// каждом соединении.
+//
+// У хаба есть ключ возобновления - пара X25519 на время жизни
+// процесса. Клиент с earlyData просит его в Client Hello
+// (helloCapResume), сервер добавляет публичный ключ в Server Hello, и
+// клиент запоминает его для адреса сервера.
+//
+// Следующий Dial к этому адресу не ждёт ответа:
+//
+//	ранние ключи = HKDF(ECDH(эфемерный ключ клиента, ключ возобновления))
+//
+// Client Hello несёт helloCapEarly и ID ключа (4 байта SHA-256 от
+// него), а данные сразу идут DATA-пакетами на ранних ключах. Сервер
+// выводит те же ключи своим приватным ключом возобновления и
+// расшифровывает ими пакеты, пока не придёт первый пакет на обычных
+// ключах. Обычные ключи - как всегда, ECDH эфемерных ключей, с forward
+// secrecy; клиент переходит на них, получив Server Hello. Сервер
+// всегда шлёт на обычных ключах.
+//
+// Ранние данные можно повторить: перехваченный Client Hello с
+// пакетами за ним сервер принял бы ещё раз. Поэтому сервер принимает
+// их, только если время Client Hello в пределах earlyDataWindow от
+// своего, а эфемерный ключ клиента за это окно не встречался
+// (strike register).
+//
+// Принятие сервер подтверждает, повторяя ID ключа в Server Hello.
+// Без подтверждения (сервер перезапущен и ключ другой, повтор, старый
+// сервер) клиент заново отправляет на обычных ключах всё, что записал
+// до Server Hello. Ранних данных не больше maxEarlyData байт: Write
+// сверх них ждёт Server Hello, иначе отказ сервера их потерял бы.
+// Write после Server Hello ждёт конца повтора и не обгоняет его. Если
+// Server Hello нет и за handshakeTimeout, ключ забывается, а
+// соединение закрывается.
+//
+// Общие сессии (sharedSession) и переподключение (reconnect.go)
+// работают без 0-RTT.
+//
+// ====================================================================
+
+const (
+	// helloCapResume - клиент просит ключ возобновления; в Server Hello -
+	// ключ в расширении ResumeKey
+	helloCapResume byte = 0x02
+
+	// helloCapEarly - Client Hello с ранними данными на ключе EarlyKeyID;
+	// в Server Hello - ранние данные приняты
+	helloCapEarly byte = 0x04
+
+	// helloCapsKnown - все биты возможностей хэндшейка
+	helloCapsKnown = helloCapMux | helloCapResume | helloCapEarly | helloCapStreams | helloCapCompact | helloCapPuzzle | helloCapTimestamps | helloCapUser
+
+	// earlyKeyIDSize - размер ID ключа возобновления
+	earlyKeyIDSize = 4
+
+	// earlyDataWindow - допустимое расхождение времени Client Hello и
+	// срок памяти strike register
+	earlyDataWindow = 10 * time.Second
+
+	// maxEarlyData - сколько байт ранних данных клиент хранит для
+	// повтора после отказа
+	maxEarlyData = 16 * 1024
+
+	// earlyHelloRetry - первая пауза перед повтором Client Hello 0-RTT
+	earlyHelloRetry = 250 * time.Millisecond
+
+	// earlyPacketGap - пропуск номеров пакетов при смене ключей:
+	// Write, начатый на ранних ключах, не повторит номер на обычных
+	earlyPacketGap = 64
+
+	// HKDF info ранних ключей
+	hkdfInfoEarlyClient = "gametunnel early client-to-server"
+	hkdfInfoEarlyServer = "gametunnel early server-to-client"
+)
+
+// helloExtLens возвращает длины расширений хвоста хэндшейка по байту
+// возможностей
+func helloExtLens(caps byte, fromServer bool) (resume, early, compact, user, puzzle int) {
+	if fromServer && caps&helloCapResume != 0 {
+		resume = Curve25519KeySize
+	}
+	if caps&helloCapEarly != 0 {
+		early = earlyKeyIDSize
+	}
+	if fromServer && caps&helloCapCompact != 0 {
+		compact = compactIDSize
+	}
+	if !fromServer && caps&helloCapUser != 0 {
+		user = userIDSize
+	}
+	if !fromServer && caps&helloCapPuzzle != 0 {
+		puzzle = puzzleExtSize
+	}
+	return resume, early, compact, user, puzzle
+}
+
+// resumeKeyID - ID ключа возобновления
+func resumeKeyID(key [Curve25519KeySize]byte) [earlyKeyIDSize]byte {
+	var id [earlyKeyIDSize]byte
+	sum := sha256.Sum256(key[:])
+	copy(id[:], sum[:])
+	return id
+}
+
+// deriveEarlyKeys выводит ранние ключи из ECDH с ключом возобновления
+func deriveEarlyKeys(secret [Curve25519KeySize]byte, psk string, isClient bool) (*SessionKeys, error) {
+	return deriveKeys(secret, psk, isClient, hkdfInfoEarlyClient, hkdfInfoEarlyServer)
+}
+
+// ====================================================================
+// Сервер
+// ====================================================================
+
+// resumption - ключ возобновления хаба и strike register ранних данных
+type resumption struct {
+	keyPair *KeyPair
+	id      [earlyKeyIDSize]byte
+
+	// strikes - эфемерные ключи принятых Client Hello 0-RTT и срок
+	// их хранения
+	mu      sync.Mutex
+	strikes map[[Curve25519KeySize]byte]time.Time
+	sweptAt time.Time
+
+	// accepted / rejected - Client Hello 0-RTT принято и отклонено
+	accepted uint64
+	rejected uint64
+}
+
+// newResumption создаёт ключ возобновления (nil - 0-RTT недоступен)
+func newResumption() *resumption {
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		return nil
+	}
+	return &resumption{
+		keyPair: keyPair,
+		id:      resumeKeyID(keyPair.PublicKey),
+		strikes: make(map[[Curve25519KeySize]byte]time.Time),
+	}
+}
+
+// acceptEarly проверяет Client Hello 0-RTT и выводит ранние ключи
+// nil - ранние данные не принимаются: чужой ключ, время вне окна
+// или повтор
+func (r *resumption) acceptEarly(hello *HandshakePayload, psk string, now time.Time) *SessionKeys {
+	if r == nil || !bytes.Equal(hello.EarlyKeyID, r.id[:]) {
+		return nil
+	}
+	sent := time.Unix(int64(hello.Timestamp), 0)
+	if now.Sub(sent) > earlyDataWindow || sent.Sub(now) > earlyDataWindow {
+		atomic.AddUint64(&r.rejected, 1)
+		return nil
+	}
+	if !r.strike(hello.PublicKey, now) {
+		atomic.AddUint64(&r.rejected, 1)
+		return nil
+	}
+
+	secret, err := ComputeSharedSecret(r.keyPair.PrivateKey, hello.PublicKey)
+	if err != nil {
+		return nil
+	}
+	keys, err := deriveEarlyKeys(secret, psk, false)
+	if err != nil {
+		return nil
+	}
+	atomic.AddUint64(&r.accepted, 1)
+	return keys
+}
+
+// strike запоминает ключ клиента; false - он уже встречался в окне
+func (r *resumption) strike(key [Curve25519KeySize]byte, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Ключ живёт два окна: Client Hello с временем на краю окна
+	// повторить позже уже нельзя
+	if now.Sub(r.sweptAt) >= earlyDataWindow {
+		for k, expires := range r.strikes {
+			if now.After(expires) {
+				delete(r.strikes, k)
+			}
+		}
+		r.sweptAt = now
+	}
+	if expires, seen := r.strikes[key]; seen && !now.After(expires) {
+		return false
+	}
+	r.strikes[key] = now.Add(2 * earlyDataWindow)
+	return true
+}
+
+// decrypt расшифровывает пакет клиента обычными ключами сессии, а до
+// первого пакета на них - и ранними
+func (s *Session) decrypt(ciphertext []byte, packetNumber uint32, additionalData []byte) ([]byte, error) {
+	plaintext, err := decryptPlain(s.Keys, ciphertext, packetNumber, additionalData)
+	if err == nil {
+		if s.earlyKeys.Load() != nil {
+			s.earlyKeys.Store(nil)
+		}
+		return plaintext, nil
+	}
+	if early := s.earlyKeys.Load(); early != nil {
+		if plaintext, eErr := decryptPlain(early, ciphertext, packetNumber, additionalData); eErr == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
+
+// GetEarlyDataStats возвращает число Client Hello 0-RTT: принятых и
+// отклонённых (вне окна времени или повтор)
+func (h *Hub) GetEarlyDataStats() (accepted, rejected uint64) {
+	if h.resumption == nil {
+		return 0, 0
+	}
+	return atomic.LoadUint64(&h.resumption.accepted), atomic.LoadUint64(&h.resumption.rejected)
+}
+
+// ====================================================================
+// Клиент
+// ====================================================================
+
+// earlyTicket - ключ возобновления сервера
+type earlyTicket struct {
+	key [Curve25519KeySize]byte
+	id  [earlyKeyIDSize]byte
+}
+
+// ticketKey - адрес сервера и PSK: ключ действует только с ними
+type ticketKey struct {
+	addr string
+	psk  string
+}
+
+// earlyTickets - ключи возобновления серверов (ticketKey → *earlyTicket)
+var earlyTickets sync.Map
+
+// storeEarlyTicket запоминает ключ возобновления сервера addr
+func storeEarlyTicket(addr *net.UDPAddr, config *Config, key []byte) {
+	if len(key) != Curve25519KeySize {
+		return
+	}
+	ticket := &earlyTicket{}
+	copy(ticket.key[:], key)
+	ticket.id = resumeKeyID(ticket.key)
+	earlyTickets.Store(ticketKey{addr: addr.String(), psk: config.sessionPSK()}, ticket)
+}
+
+// loadEarlyTicket возвращает ключ возобновления сервера addr
+func loadEarlyTicket(addr *net.UDPAddr, config *Config) (*earlyTicket, bool) {
+	ticket, ok := earlyTickets.Load(ticketKey{addr: addr.String(), psk: config.sessionPSK()})
+	if !ok {
+		return nil, false
+	}
+	return ticket.(*earlyTicket), true
+}
+
+// dropEarlyTicket забывает ключ возобновления сервера addr
+func dropEarlyTicket(addr *net.UDPAddr, config *Config) {
+	earlyTickets.Delete(ticketKey{addr: addr.String(), psk: config.sessionPSK()})
+}
+
+// pendingHello - хэндшейк 0-RTT, ждущий Server Hello
+type pendingHello struct {
+	keyPair *KeyPair
+	keyID   [earlyKeyIDSize]byte
+
+	// hello - обфусцированный Client Hello для повторов
+	// payload - его содержимое для повтора с решением задачи
+	hello   []byte
+	payload *HandshakePayload
+
+	// retry - задача сервера в ответ на Client Hello (puzzle.go)
+	retry chan *puzzleRetry
+}
+
+// dialEarly открывает сессию 0-RTT к первому адресу, если для него
+// есть ключ возобновления
+// false - 0-RTT невозможен, нужен обычный хэндшейк
+func dialEarly(ctx context.Context, addrs []*net.UDPAddr, config *Config, obfs Obfuscator, sockopt *internet.SocketConfig) (dialResult, bool) {
+	if !config.EarlyData || config.SharedSession || config.AppStreams || len(addrs) == 0 {
+		return dialResult{}, false
+	}
+	addr := addrs[0]
+	ticket, ok := loadEarlyTicket(addr, config)
+	if !ok {
+		return dialResult{}, false
+	}
+
+	conn, err := dialPathSocket(ctx, nil, addr, config, sockopt)
+	if err != nil {
+		return dialResult{}, false
+	}
+	session, err := performEarlyHandshake(ctx, conn, config, obfs, ticket)
+	if err != nil {
+		conn.Close()
+		return dialResult{}, false
+	}
+	return dialResult{conn: conn, session: session, addr: addr}, true
+}
+
+// performEarlyHandshake отправляет Client Hello 0-RTT и возвращает
+// сессию на ранних ключах, не дожидаясь ответа
+func performEarlyHandshake(ctx context.Context, conn PacketConn, config *Config, obfs Obfuscator, ticket *earlyTicket) (*ClientSession, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		return nil, fmt.Errorf("generate keypair: %w", err)
+	}
+	connID, err := GenerateConnectionID(int(config.ConnectionIdLength))
+	if err != nil {
+		return nil, fmt.Errorf("generate connection ID: %w", err)
+	}
+
+	payload := NewHandshakePayload(keyPair.PublicKey, uint64(time.Now().Unix()))
+	payload.Capabilities = helloCapResume | helloCapEarly
+	payload.EarlyKeyID = ticket.id[:]
+	if config.CompactHeaders {
+		payload.Capabilities |= helloCapCompact
+	}
+	config.helloUser(payload)
+
+	wrapped, err := buildClientHello(connID, payload, config, obfs)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := ComputeSharedSecret(keyPair.PrivateKey, ticket.key)
+	if err != nil {
+		return nil, fmt.Errorf("compute early secret: %w", err)
+	}
+	earlyKeys, err := deriveEarlyKeys(secret, config.sessionPSK(), true)
+	if err != nil {
+		return nil, fmt.Errorf("derive early keys: %w", err)
+	}
+
+	server, _ := conn.RemoteAddr().(*net.UDPAddr)
+	tapOf(obfs).wire(true, udpLocalAddr(conn), server, wrapped)
+	if _, err := conn.Write(wrapped); err != nil {
+		return nil, fmt.Errorf("send client hello: %w", err)
+	}
+
+	return &ClientSession{
+		ConnectionID:  connID,
+		Keys:          earlyKeys,
+		SendPacketNum: 1, // 0 использован для Client Hello
+		ReplayWindow:  NewReplayWindow(),
+		inbound:       newInboundRing(config.InboundWait),
+		Streams:       make(map[uint16]*Stream),
+		pending: &pendingHello{
+			keyPair: keyPair,
+			keyID:   ticket.id,
+			hello:   wrapped,
+			payload: payload,
+			retry:   make(chan *puzzleRetry, 1),
+		},
+	}, nil
+}
+
+// earlyHelloLoop повторяет Client Hello 0-RTT, пока не придёт Server
+// Hello; за handshakeTimeout без ответа соединение закрывается
+func (c *GameTunnelClientConn) earlyHelloLoop() {
+	session := c.session()
+	pending := session.pending
+	if pending == nil {
+		return
+	}
+
+	deadline := time.Now().Add(time.Duration(c.config.HandshakeTimeout) * time.Second)
+	retry := earlyHelloRetry
+	puzzles := 0
+	for {
+		timer := time.NewTimer(retry)
+		select {
+		case <-c.ctx.Done():
+			timer.Stop()
+			return
+		case challenge := <-pending.retry:
+			timer.Stop()
+			if c.session() != session || puzzles == puzzleMaxRetries {
+				continue
+			}
+			puzzles++
+			if err := c.solveEarlyPuzzle(session, challenge); err != nil {
+				logf(log.Severity_Warning, "%s handshake puzzle: %v", sessionTag(session.ConnectionID), err)
+				continue
+			}
+			c.write(pending.hello, PriorityHigh)
+			retry = earlyHelloRetry
+			continue
+		case <-timer.C:
+		}
+		if c.session() != session {
+			return
+		}
+		if time.Now().After(deadline) {
+			// Сервера по этому адресу, видимо, больше нет - следующий
+			// Dial пойдёт обычным хэндшейком
+			dropEarlyTicket(session.serverAddr, c.config)
+			atomic.AddUint64(&metrics.client.handshakeFailures, 1)
+			c.shutdown()
+			return
+		}
+		c.write(pending.hello, PriorityHigh)
+		retry *= 2
+	}
+}
+
+// openRetry проверяет подпись RETRY на Client Hello 0-RTT и
+// разбирает его; false - не RETRY, подделка или хэндшейк завершён
+func (c *GameTunnelClientConn) openRetry(session *ClientSession, payload []byte) (*puzzleRetry, bool) {
+	pending := session.pending
+	if pending == nil {
+		return nil, false
+	}
+	payload, ok := openHandshakeControl(payload, c.config.sessionPSK(), pending.keyPair.PublicKey)
+	if !ok {
+		atomic.AddUint64(&metrics.client.spoofedHellos, 1)
+		return nil, false
+	}
+	return parseRetry(payload)
+}
+
+// solveEarlyPuzzle решает задачу сервера и готовит повтор Client Hello
+// с решением. Ранние пакеты, отправленные до решения, сервер уже
+// отбросил, поэтому повтор не просит их принять: данные из holdEarly
+// уйдут заново после Server Hello
+func (c *GameTunnelClientConn) solveEarlyPuzzle(session *ClientSession, challenge *puzzleRetry) error {
+	pending := session.pending
+	solution, err := solvePuzzle(c.ctx, challenge.token, pending.keyPair.PublicKey)
+	if err != nil {
+		return err
+	}
+	payload := *pending.payload
+	payload.Capabilities = (payload.Capabilities &^ helloCapEarly) | helloCapPuzzle
+	payload.EarlyKeyID = nil
+	payload.Puzzle = solution
+	hello, err := buildClientHello(session.ConnectionID, &payload, c.config, c.obfs)
+	if err != nil {
+		return err
+	}
+	pending.hello = hello
+	return nil
+}
+
+// holdEarly сохраняет данные Write, отправляемые на ранних ключах, для
+// повтора после отказа сервера
+// Возвращает функцию, которую Write вызывает после отправки: смена
+// ключей ждёт Write, начатый на ранних ключах. Данные сверх
+// maxEarlyData и Write после Server Hello ждут конца хэндшейка 0-RTT
+// (earlyDone)
+func (c *GameTunnelClientConn) holdEarly(b []byte) (func(), error) {
+	if c.earlyDone == nil {
+		return func() {}, nil
+	}
+	select {
+	case <-c.earlyDone:
+		return func() {}, nil
+	default:
+	}
+	c.earlyMu.Lock()
+	if c.session().pending != nil && c.earlySize+len(b) <= maxEarlyData {
+		c.earlyData = append(c.earlyData, append([]byte(nil), b...))
+		c.earlySize += len(b)
+		return c.earlyMu.Unlock, nil
+	}
+	c.earlyMu.Unlock()
+
+	select {
+	case <-c.earlyDone:
+		return func() {}, nil
+	case <-c.ctx.Done():
+		return nil, io.ErrClosedPipe
+	}
+}
+
+// handleServerHello завершает хэндшейк 0-RTT: сессия переходит на
+// обычные ключи, отклонённые ранние данные отправляются заново
+func (c *GameTunnelClientConn) handleServerHello(data []byte) {
+	session := c.session()
+	pending := session.pending
+	if pending == nil {
+		return
+	}
+
+	connIDLen := int(c.config.ConnectionIdLength)
+	pkt, err := Unmarshal(data, connIDLen)
+	if err != nil || !bytes.Equal(pkt.ConnectionID, session.ConnectionID) {
+		return
+	}
+	// Ответ без подписи ключом сессии - подделка (crypto.go)
+	if !serverHelloAuthentic(pkt.Payload, c.config.sessionPSK(), pending.keyPair.PublicKey) {
+		atomic.AddUint64(&metrics.client.spoofedHellos, 1)
+		return
+	}
+	hello, err := UnmarshalHandshake(pkt.Payload)
+	if err != nil {
+		return
+	}
+	tail := hello.IssuedID
+	allowed := helloCapResume | helloCapEarly
+	if c.config.CompactHeaders {
+		allowed |= helloCapCompact
+	}
+	hello.splitCapabilities(connIDLen, true, allowed)
+	accepted := hello.Capabilities&helloCapEarly != 0 && bytes.Equal(hello.EarlyKeyID, pending.keyID[:])
+	if hello.Capabilities&helloCapEarly != 0 && !accepted {
+		// Старый сервер: весь хвост - выданный ID, лишь похожий на
+		// расширения
+		hello.IssuedID, hello.Capabilities, hello.ResumeKey, hello.EarlyKeyID, hello.CompactID = tail, 0, nil, nil, nil
+	}
+
+	secret, err := ComputeSharedSecret(pending.keyPair.PrivateKey, hello.PublicKey)
+	if err != nil {
+		return
+	}
+	keys, err := DeriveSessionKeys(secret, c.config.sessionPSK(), true)
+	if err != nil {
+		return
+	}
+	connID := session.ConnectionID
+	if len(hello.IssuedID) == connIDLen {
+		connID = hello.IssuedID
+	}
+
+	next := &ClientSession{
+		ConnectionID:  connID,
+		Keys:          keys,
+		SendPacketNum: atomic.LoadUint32(&session.SendPacketNum) + earlyPacketGap,
+		ReplayWindow:  NewReplayWindow(),
+		inbound:       session.inbound,
+		serverAddr:    session.serverAddr,
+		Streams:       make(map[uint16]*Stream),
+		flow:          session.flow,
+		compact:       acceptCompact(hello, connID),
+	}
+	session.mu.RLock()
+	for id, stream := range session.Streams {
+		copied := *stream
+		next.Streams[id] = &copied
+	}
+	session.mu.RUnlock()
+
+	c.earlyMu.Lock()
+	swapped := c.current.CompareAndSwap(session, next)
+	held := c.earlyData
+	c.earlyData, c.earlySize = nil, 0
+	c.earlyMu.Unlock()
+	if !swapped {
+		return
+	}
+	// Новые Write ждут в holdEarly, пока повтор не уйдёт целиком
+	defer close(c.earlyDone)
+
+	atomic.AddUint64(&metrics.client.handshakes, 1)
+	if len(hello.ResumeKey) == Curve25519KeySize {
+		storeEarlyTicket(session.serverAddr, c.config, hello.ResumeKey)
+	} else {
+		dropEarlyTicket(session.serverAddr, c.config)
+	}
+
+	if accepted {
+		atomic.StoreInt32(&c.earlyState, earlyStateAccepted)
+		return
+	}
+	atomic.StoreInt32(&c.earlyState, earlyStateRejected)
+	for _, b := range held {
+		if _, err := c.writeData(b); err != nil {
+			return
+		}
+	}
+}
+
+// Состояние 0-RTT соединения
+const (
+	earlyStateNone int32 = iota
+	earlyStatePending
+	earlyStateAccepted
+	earlyStateRejected
+)
+
+// EarlyData сообщает, открыто ли соединение с 0-RTT и принял ли сервер
+// ранние данные (accepted = false и до Server Hello)
+func (c *GameTunnelClientConn) EarlyData() (used, accepted bool) {
+	state := atomic.LoadInt32(&c.earlyState)
+	return state != earlyStateNone, state == earlyStateAccepted
+}
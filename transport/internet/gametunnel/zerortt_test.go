@@ -0,0 +1,187 @@
+package gametunnel
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// waitEarlyDone ждёт Server Hello сессии 0-RTT
+func waitEarlyDone(t *testing.T, client *GameTunnelClientConn) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for client.session().pending != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("0-RTT handshake not completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEarlyData(t *testing.T) {
+	config := DefaultConfig()
+	config.Key = "zero-rtt"
+	l, accepted := startTestListener(t, config)
+	addr := l.Addr().(*net.UDPAddr)
+	port := addr.Port
+
+	clientConfig := *config
+	clientConfig.EarlyData = true
+	t.Cleanup(func() { dropEarlyTicket(addr, &clientConfig) })
+
+	// Первое соединение - обычный хэндшейк, клиент получает ключ
+	first, server := dialTestClient(t, l, &clientConfig, accepted)
+	if used, _ := first.EarlyData(); used {
+		t.Error("first dial used 0-RTT without a ticket")
+	}
+	first.Write([]byte("one"))
+	readWithTimeout(t, server, 3)
+	first.Close()
+	if _, ok := loadEarlyTicket(addr, &clientConfig); !ok {
+		t.Fatal("resumption key not cached")
+	}
+
+	// Второе - данные уходят до Server Hello и принимаются
+	second, server := dialTestClient(t, l, &clientConfig, accepted)
+	second.Write([]byte("early"))
+	if got := readWithTimeout(t, server, 5); string(got) != "early" {
+		t.Fatalf("server got %q", got)
+	}
+	waitEarlyDone(t, second)
+	if used, ok := second.EarlyData(); !used || !ok {
+		t.Errorf("EarlyData() = %v, %v", used, ok)
+	}
+	server.Write([]byte("reply"))
+	if got := readWithTimeout(t, second, 5); string(got) != "reply" {
+		t.Errorf("client got %q", got)
+	}
+	second.Write([]byte("after"))
+	if got := readWithTimeout(t, server, 5); string(got) != "after" {
+		t.Errorf("server got %q after key switch", got)
+	}
+	if accepted, _ := l.hub.GetEarlyDataStats(); accepted != 1 {
+		t.Errorf("hub accepted %d early hellos", accepted)
+	}
+	second.Close()
+	before, _ := loadEarlyTicket(addr, &clientConfig)
+
+	// Сервер перезапущен, ключ возобновления другой: ранние данные
+	// отклонены и приходят повтором на обычных ключах
+	l.Close()
+	accepted = restartTestListener(t, port, config)
+	third, server := dialTestClientAt(t, addr, &clientConfig, accepted)
+	defer third.Close()
+	third.Write([]byte("retry"))
+	// Сверх maxEarlyData Write ждёт Server Hello и не теряется при
+	// отказе; следующий Write не обгоняет повтор. Крупные пакеты идут
+	// своим классом (priority.go), порядок - среди мелких
+	third.Write(bytes.Repeat([]byte("x"), maxEarlyData))
+	third.Write([]byte("end"))
+	if got := readWithTimeout(t, server, 5); string(got) != "retry" {
+		t.Fatalf("server got %q after rejection", got)
+	}
+	for left, end := maxEarlyData, false; left > 0 || !end; {
+		got := readWithTimeout(t, server, MaxPacketSize)
+		switch {
+		case string(got) == "end" && !end:
+			end = true
+		case len(got) <= left && bytes.Count(got, []byte("x")) == len(got):
+			left -= len(got)
+		default:
+			t.Fatalf("server got %q with %d bytes over the cap left", got, left)
+		}
+	}
+	if used, ok := third.EarlyData(); !used || ok {
+		t.Errorf("EarlyData() = %v, %v after rejection", used, ok)
+	}
+	// Server Hello перезапущенного сервера принёс новый ключ
+	if after, _ := loadEarlyTicket(addr, &clientConfig); after == nil || after.id == before.id {
+		t.Error("resumption key not refreshed after rejection")
+	}
+}
+
+func TestAcceptEarlyRejectsReplay(t *testing.T) {
+	r := newResumption()
+	client, _ := GenerateKeyPair()
+	now := time.Now()
+
+	hello := NewHandshakePayload(client.PublicKey, uint64(now.Unix()))
+	hello.EarlyKeyID = r.id[:]
+	if r.acceptEarly(hello, "psk", now) == nil {
+		t.Fatal("fresh early hello rejected")
+	}
+	// Повтор того же Client Hello
+	if r.acceptEarly(hello, "psk", now.Add(time.Second)) != nil {
+		t.Error("replayed early hello accepted")
+	}
+
+	other, _ := GenerateKeyPair()
+	stale := NewHandshakePayload(other.PublicKey, uint64(now.Add(-time.Minute).Unix()))
+	stale.EarlyKeyID = r.id[:]
+	if r.acceptEarly(stale, "psk", now) != nil {
+		t.Error("stale early hello accepted")
+	}
+
+	foreign := NewHandshakePayload(other.PublicKey, uint64(now.Unix()))
+	foreign.EarlyKeyID = []byte{1, 2, 3, 4}
+	if r.acceptEarly(foreign, "psk", now) != nil {
+		t.Error("early hello for another key accepted")
+	}
+	if r.accepted != 1 || r.rejected != 2 {
+		t.Errorf("accepted=%d rejected=%d", r.accepted, r.rejected)
+	}
+
+	// Ранние ключи сторон совпадают
+	fresh := NewHandshakePayload(other.PublicKey, uint64(now.Unix()))
+	fresh.EarlyKeyID = r.id[:]
+	serverKeys := r.acceptEarly(fresh, "psk", now)
+	secret, _ := ComputeSharedSecret(other.PrivateKey, r.keyPair.PublicKey)
+	clientKeys, _ := deriveEarlyKeys(secret, "psk", true)
+	if serverKeys == nil || clientKeys.SendKey != serverKeys.RecvKey {
+		t.Error("early keys differ between client and server")
+	}
+}
+
+func TestHandshakeExtensions(t *testing.T) {
+	issued := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	resumeKey := make([]byte, Curve25519KeySize)
+	resumeKey[0] = 9
+	keyID := []byte{7, 7, 7, 7}
+
+	server := NewHandshakePayload([Curve25519KeySize]byte{1}, 1)
+	server.IssuedID = issued
+	server.ResumeKey = resumeKey
+	server.EarlyKeyID = keyID
+	server.Capabilities = helloCapResume | helloCapEarly
+
+	got, err := UnmarshalHandshake(server.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.splitCapabilities(len(issued), true, helloCapResume|helloCapEarly)
+	if string(got.IssuedID) != string(issued) || string(got.ResumeKey) != string(resumeKey) ||
+		string(got.EarlyKeyID) != string(keyID) || got.Capabilities != server.Capabilities {
+		t.Errorf("server hello: %+v", got)
+	}
+
+	// Client Hello: ID ключа без ключа возобновления
+	client := NewHandshakePayload([Curve25519KeySize]byte{2}, 1)
+	client.EarlyKeyID = keyID
+	client.Capabilities = helloCapResume | helloCapEarly
+	got, _ = UnmarshalHandshake(client.Marshal())
+	got.splitCapabilities(len(issued), false, helloCapsKnown)
+	if got.IssuedID != nil || got.ResumeKey != nil || string(got.EarlyKeyID) != string(keyID) {
+		t.Errorf("client hello: %+v", got)
+	}
+
+	// Старый сервер выдал 5-байтный ID, последний байт которого похож
+	// на helloCapEarly: клиент, не просивший 0-RTT, берёт ID целиком
+	old := NewHandshakePayload([Curve25519KeySize]byte{3}, 1)
+	old.IssuedID = []byte{9, 9, 9, 9, helloCapEarly}
+	got, _ = UnmarshalHandshake(old.Marshal())
+	got.splitCapabilities(5, true, helloCapResume)
+	if len(got.IssuedID) != 5 || got.Capabilities != 0 {
+		t.Errorf("old server issued ID misparsed: %+v", got)
+	}
+}